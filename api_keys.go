@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// API keys let scripts and CI pipelines call the API as their user without a
+// password, with "Authorization: ApiKey <key>". Each key carries scopes, and
+// only works on the routes its scopes cover.
+const (
+	scopeUploadVideo  = "upload:video"
+	scopeReadVideos   = "read:videos"
+	scopeDeleteVideos = "delete:videos"
+	// scopeAdmin covers /api/admin/; the key's user must still be an admin.
+	scopeAdmin = "admin"
+
+	// apiKeyPrefix starts every key, so leaked keys are easy to search for:
+	apiKeyPrefix = "tubely_"
+	// apiKeyTokenTTL is how long the access token a key stands in for lives;
+	// it only has to last the request.
+	apiKeyTokenTTL = 5 * time.Minute
+	// maxAPIKeyDays bounds expires_in_days:
+	maxAPIKeyDays = 365
+)
+
+var apiKeyScopes = []string{scopeUploadVideo, scopeReadVideos, scopeDeleteVideos, scopeAdmin}
+
+// routeScopes are the routes API keys may call, by mux pattern, and the scopes
+// that let them (any one will do). Every other route, the key endpoints
+// included, is for logged-in users only.
+var routeScopes = map[string][]string{
+	"GET /api/users/me/usage":                           {scopeUploadVideo, scopeReadVideos},
+	"POST /api/videos":                                  {scopeUploadVideo},
+	"POST /api/video_upload/{videoID}":                  {scopeUploadVideo},
+	"POST /api/thumbnail_upload/{videoID}":              {scopeUploadVideo},
+	"POST /api/videos/{videoID}/upload-from-url":        {scopeUploadVideo},
+	"POST /api/videos/{videoID}/upload-url":             {scopeUploadVideo},
+	"POST /api/videos/{videoID}/upload-policy":          {scopeUploadVideo},
+	"POST /api/videos/{videoID}/uploads":                {scopeUploadVideo},
+	"HEAD /api/uploads/{uploadID}":                      {scopeUploadVideo},
+	"PATCH /api/uploads/{uploadID}":                     {scopeUploadVideo},
+	"DELETE /api/uploads/{uploadID}":                    {scopeUploadVideo},
+	"GET /api/videos/{videoID}/jobs":                    {scopeUploadVideo, scopeReadVideos},
+	"GET /api/jobs/{jobID}":                             {scopeUploadVideo, scopeReadVideos},
+	"GET /api/jobs/{jobID}/events":                      {scopeUploadVideo, scopeReadVideos},
+	"GET /api/videos":                                   {scopeReadVideos},
+	"GET /api/videos/search":                            {scopeReadVideos},
+	"GET /api/videos/{videoID}":                         {scopeReadVideos},
+	"GET /api/videos/{videoID}/stream":                  {scopeReadVideos},
+	"GET /api/videos/{videoID}/hls-key":                 {scopeReadVideos},
+	"GET /api/videos/{videoID}/share-links":             {scopeReadVideos},
+	"DELETE /api/videos/{videoID}":                      {scopeDeleteVideos},
+	"POST /api/videos/bulk-delete":                      {scopeDeleteVideos},
+	"POST /api/videos/bulk-import":                      {scopeUploadVideo},
+	"POST /api/videos/{videoID}/clips":                  {scopeUploadVideo},
+	"GET /api/videos/{videoID}/clips":                   {scopeReadVideos},
+	"PUT /api/videos/{videoID}/tags":                    {scopeUploadVideo},
+	"GET /api/tags/popular":                             {scopeReadVideos},
+	"DELETE /api/videos/{videoID}/audio":                {scopeUploadVideo},
+	"PUT /api/videos/{videoID}/audio":                   {scopeUploadVideo},
+	"DELETE /api/videos/{videoID}/share-links/{linkID}": {scopeDeleteVideos},
+}
+
+// scopesForRoute returns the scopes that let a key call the route with the
+// mux pattern:
+func scopesForRoute(pattern string) []string {
+	if scopes, ok := routeScopes[pattern]; ok {
+		return scopes
+	}
+	if _, path, _ := strings.Cut(pattern, " "); strings.HasPrefix(path, "/api/admin/") {
+		return []string{scopeAdmin}
+	}
+	return nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyMiddleware lets an API key, or a request signed with one, stand in for
+// a Bearer token, like the session cookie does: once the key checks out and its scopes cover the route
+// mux would send the request to, the Authorization header is swapped for a
+// short-lived access token of the key's user, so handlers keep using
+// auth.GetBearerToken.
+func (cfg *apiConfig) apiKeyMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var apiKey *database.APIKey
+		if isSignedRequest(r) {
+			var err error
+			apiKey, r, err = cfg.verifySignedRequest(w, r)
+			if err != nil {
+				respondWithPipelineError(w, err)
+				return
+			}
+		} else {
+			key, err := auth.GetAPIKey(r.Header)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			apiKey, err = cfg.db.GetAPIKeyByHash(r.Context(), hashAPIKey(key))
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't check API key", err)
+				return
+			}
+			if apiKey == nil {
+				respondWithError(w, http.StatusUnauthorized, "Invalid, revoked or expired API key", nil)
+				return
+			}
+		}
+		_, pattern := mux.Handler(r)
+		allowed := scopesForRoute(pattern)
+		if !slices.ContainsFunc(apiKey.Scopes, func(scope string) bool { return slices.Contains(allowed, scope) }) {
+			if len(allowed) == 0 {
+				respondWithError(w, http.StatusForbidden, "API keys can't be used here", nil)
+				return
+			}
+			respondWithError(w, http.StatusForbidden, "API key needs one of the scopes: "+strings.Join(allowed, ", "), nil)
+			return
+		}
+
+		token, err := cfg.tokens.MakeJWT(apiKey.UserID, apiKeyTokenTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't authenticate API key", err)
+			return
+		}
+		if err := cfg.db.TouchAPIKey(r.Context(), apiKey.ID); err != nil {
+			log.Printf("Couldn't record use of API key %s: %v", apiKey.ID, err)
+		}
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+token)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlerAPIKeyCreate makes a key for the logged-in user. The key is in the
+// response and nowhere else; it can't be shown again. So is its signing
+// secret, though that one can be derived again.
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	type response struct {
+		database.APIKey
+		Key string `json:"key"`
+		// SigningSecret signs requests as the key, see request_signing.go:
+		SigningSecret string `json:"signing_secret,omitempty"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	var fieldErrors []fieldError
+	if params.Name == "" {
+		fieldErrors = append(fieldErrors, fieldError{"name", "Name is required"})
+	}
+	if len(params.Scopes) == 0 {
+		fieldErrors = append(fieldErrors, fieldError{"scopes", "At least one scope is required: " + strings.Join(apiKeyScopes, ", ")})
+	}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			fieldErrors = append(fieldErrors, fieldError{"scopes", "Unknown scope " + scope + ", expected one of " + strings.Join(apiKeyScopes, ", ")})
+		}
+	}
+	if params.ExpiresInDays < 0 || params.ExpiresInDays > maxAPIKeyDays {
+		fieldErrors = append(fieldErrors, fieldError{"expires_in_days", "Must be between 0 (never) and 365"})
+	}
+	if slices.Contains(params.Scopes, scopeAdmin) {
+		user, err := cfg.db.GetUser(r.Context(), userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if user == nil || !user.IsAdmin {
+			fieldErrors = append(fieldErrors, fieldError{"scopes", "Only admins can create admin keys"})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, fieldErrors)
+		return
+	}
+	slices.Sort(params.Scopes)
+	params.Scopes = slices.Compact(params.Scopes)
+
+	secret, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+	key := apiKeyPrefix + secret
+	var expiresAt *time.Time
+	if params.ExpiresInDays > 0 {
+		t := time.Now().UTC().Add(time.Duration(params.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+	apiKey, err := cfg.db.CreateAPIKey(r.Context(), database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      params.Name,
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		KeyHash:   hashAPIKey(key),
+		Scopes:    params.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+	resp := response{APIKey: apiKey, Key: key}
+	if cfg.requestSigning.Secret != "" {
+		resp.SigningSecret = cfg.requestSigning.signingSecret(apiKey.ID)
+	}
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+// handlerAPIKeysList lists the logged-in user's keys, without the keys themselves.
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	keys, err := cfg.db.GetAPIKeys(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API keys", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) handlerAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid key ID", err)
+		return
+	}
+
+	found, err := cfg.db.RevokeAPIKey(r.Context(), userID, keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
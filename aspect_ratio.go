@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// aspectRatioTolerance is how far (relative) a video's ratio may be from a named
+// ratio and still count as it. Loose enough for 1366x768 or 854x480, tight enough
+// that 16:10 (1.6) isn't mistaken for 16:9 (1.78).
+const aspectRatioTolerance = 0.02
+
+// namedAspectRatios are checked in order; anything else is "other":
+var namedAspectRatios = []struct {
+	name  string
+	ratio float64
+}{
+	{"16:9", 16.0 / 9.0},
+	{"9:16", 9.0 / 16.0},
+	{"4:3", 4.0 / 3.0},
+	{"1:1", 1.0},
+}
+
+// aspectRatioFromProbe classifies the first video stream in ffprobe's
+// -show_streams JSON output, see probeFile:
+func aspectRatioFromProbe(probeOutput []byte) (string, error) {
+	var output ffprobeStreams
+	if err := json.Unmarshal(probeOutput, &output); err != nil {
+		return "", fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+
+	// Use the first video stream; the container may list audio or data streams first:
+	for _, stream := range output.Streams {
+		if stream.CodecType != "video" {
+			continue
+		}
+		return classifyAspectRatio(stream.Width, stream.Height, stream.rotation()), nil
+	}
+	return "", errors.New("no video streams found")
+}
+
+// rotationFromProbe is the display rotation of the first video stream as
+// ffprobe reports it, from 0 to 359 degrees; 0 for files without video. The
+// direction depends on whether it came from a tag or the display matrix, but
+// only whether there is one matters: ffmpeg reads which way from the file.
+func rotationFromProbe(probeOutput []byte) (int, error) {
+	var output ffprobeStreams
+	if err := json.Unmarshal(probeOutput, &output); err != nil {
+		return 0, fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+	for _, stream := range output.Streams {
+		if stream.CodecType == "video" {
+			return (stream.rotation()%360 + 360) % 360, nil
+		}
+	}
+	return 0, nil
+}
+
+// ffprobeStreams is the part of ffprobe's -show_streams JSON we care about:
+type ffprobeStreams struct {
+	Streams []ffprobeStream `json:"streams"`
+}
+
+type ffprobeStream struct {
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	PixFmt    string `json:"pix_fmt"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	// Older ffmpeg builds report rotation as a "rotate" tag:
+	Tags struct {
+		Rotate string `json:"rotate"`
+	} `json:"tags"`
+	// newer ones as display matrix side data:
+	SideDataList []struct {
+		SideDataType string  `json:"side_data_type"`
+		Rotation     float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+// rotation returns the stream's display rotation in degrees, 0 if there is none:
+func (s ffprobeStream) rotation() int {
+	for _, sideData := range s.SideDataList {
+		if sideData.SideDataType == "Display Matrix" {
+			return int(math.Round(sideData.Rotation))
+		}
+	}
+	if rotate, err := strconv.Atoi(s.Tags.Rotate); err == nil {
+		return rotate
+	}
+	return 0
+}
+
+// classifyAspectRatio names the ratio the video is displayed at. A rotation of
+// ±90/270 degrees (phones recording portrait into a landscape frame) swaps the
+// stored width and height.
+func classifyAspectRatio(width, height, rotation int) string {
+	if width <= 0 || height <= 0 {
+		return "other"
+	}
+	if rotation%180 != 0 && rotation%90 == 0 {
+		width, height = height, width
+	}
+
+	ratio := float64(width) / float64(height)
+	for _, named := range namedAspectRatios {
+		if math.Abs(ratio-named.ratio)/named.ratio <= aspectRatioTolerance {
+			return named.name
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestClassifyAspectRatio(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		rotation      int
+		want          string
+	}{
+		{"1080p", 1920, 1080, 0, "16:9"},
+		{"720p", 1280, 720, 0, "16:9"},
+		{"1366x768 laptop", 1366, 768, 0, "16:9"},
+		{"854x480", 854, 480, 0, "16:9"},
+		{"vertical phone", 1080, 1920, 0, "9:16"},
+		{"vertical 720x1280", 720, 1280, 0, "9:16"},
+		{"VGA", 640, 480, 0, "4:3"},
+		{"1024x768", 1024, 768, 0, "4:3"},
+		{"square", 1080, 1080, 0, "1:1"},
+		{"nearly square", 1080, 1072, 0, "1:1"},
+		{"16:10 is not 16:9", 1920, 1200, 0, "other"},
+		{"ultrawide", 2560, 1080, 0, "other"},
+		{"rotated 90", 1920, 1080, 90, "9:16"},
+		{"rotated -90", 1920, 1080, -90, "9:16"},
+		{"rotated 270", 1920, 1080, 270, "9:16"},
+		{"rotated 180", 1920, 1080, 180, "16:9"},
+		{"rotated portrait back to landscape", 1080, 1920, 90, "16:9"},
+		{"zero height", 1920, 0, 0, "other"},
+		{"zero width", 0, 1080, 0, "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyAspectRatio(tt.width, tt.height, tt.rotation)
+			if got != tt.want {
+				t.Errorf("classifyAspectRatio(%d, %d, %d) = %q, want %q", tt.width, tt.height, tt.rotation, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFFprobeStreamRotation(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want int
+	}{
+		{"none", `{"codec_type": "video"}`, 0},
+		{"rotate tag", `{"tags": {"rotate": "90"}}`, 90},
+		{"display matrix", `{"side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]}`, -90},
+		{"display matrix wins over tag", `{"tags": {"rotate": "180"}, "side_data_list": [{"side_data_type": "Display Matrix", "rotation": 90}]}`, 90},
+		{"other side data ignored", `{"side_data_list": [{"side_data_type": "Spherical Mapping", "rotation": 0}]}`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream ffprobeStream
+			if err := json.Unmarshal([]byte(tt.json), &stream); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if got := stream.rotation(); got != tt.want {
+				t.Errorf("rotation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotationFromProbe(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe string
+		want  int
+	}{
+		{"upright", `{"streams": [{"codec_type": "video"}]}`, 0},
+		{"display matrix", `{"streams": [{"codec_type": "video", "side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]}]}`, 270},
+		{"rotate tag", `{"streams": [{"codec_type": "video", "tags": {"rotate": "90"}}]}`, 90},
+		{"full turn", `{"streams": [{"codec_type": "video", "tags": {"rotate": "360"}}]}`, 0},
+		{"audio first", `{"streams": [{"codec_type": "audio"}, {"codec_type": "video", "tags": {"rotate": "180"}}]}`, 180},
+		{"no video", `{"streams": [{"codec_type": "audio"}]}`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rotationFromProbe([]byte(tt.probe))
+			if err != nil {
+				t.Fatalf("rotationFromProbe: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("rotationFromProbe() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
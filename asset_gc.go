@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/google/uuid"
+)
+
+const jobKindAssetGC = "asset_gc"
+
+// assetGCConfig controls the removal of asset files nothing points at anymore,
+// mostly thumbnails that were replaced by a re-upload.
+type assetGCConfig struct {
+	// Grace is how old an unreferenced file must be before it goes
+	// (ASSET_GC_GRACE). It keeps files that were just written and whose
+	// database update hasn't landed yet.
+	Grace time.Duration
+	// Interval between background runs (ASSET_GC_INTERVAL); 0 disables them.
+	Interval time.Duration
+}
+
+// orphanedAsset is a file in the assets directory that nothing references:
+type orphanedAsset struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// findOrphanedAssets lists the files in assetsRoot that no video thumbnail,
+// avatar or watermark uses and that are older than the grace period. Only files
+// directly in assetsRoot are considered, since that's where assets are written;
+// subdirectories are left alone.
+func (cfg *apiConfig) findOrphanedAssets(ctx context.Context) ([]orphanedAsset, error) {
+	// List the directory before reading the references, so a file written in
+	// between is either too new or already referenced:
+	entries, err := os.ReadDir(cfg.assetsRoot)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := cfg.db.GetAssetReferences(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool, len(refs.ThumbnailURLs)+len(refs.Paths))
+	for _, thumbnailURL := range refs.ThumbnailURLs {
+		if name, ok := assetNameFromURL(thumbnailURL); ok {
+			referenced[name] = true
+		}
+	}
+	for _, assetPath := range refs.Paths {
+		referenced[filepath.Clean(assetPath)] = true
+	}
+
+	cutoff := time.Now().Add(-cfg.assetGC.Grace)
+	var orphans []orphanedAsset
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || referenced[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since the listing:
+			continue
+		}
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+		orphans = append(orphans, orphanedAsset{Name: entry.Name(), Size: info.Size(), ModifiedAt: info.ModTime().UTC()})
+	}
+	return orphans, nil
+}
+
+// assetNameFromURL is the file name a getAssetURL URL points at. The host isn't
+// checked, since it changes with PORT; URLs outside /assets/ aren't assets.
+func assetNameFromURL(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	name, ok := strings.CutPrefix(u.Path, "/assets/")
+	if !ok || name == "" {
+		return "", false
+	}
+	return filepath.Clean(name), true
+}
+
+// collectAssetGarbage removes the orphaned asset files and returns them.
+func (cfg *apiConfig) collectAssetGarbage(ctx context.Context) ([]orphanedAsset, error) {
+	orphans, err := cfg.findOrphanedAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	removed := orphans[:0]
+	for _, orphan := range orphans {
+		if err := os.Remove(cfg.getAssetDiskPath(orphan.Name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't remove orphaned asset %s: %v", orphan.Name, err)
+			continue
+		}
+		removed = append(removed, orphan)
+	}
+	if len(removed) > 0 {
+		log.Printf("Asset GC removed %d unreferenced files", len(removed))
+	}
+	return removed, nil
+}
+
+// submitAssetGC queues a GC run on the low-priority tier. ownerID is the admin
+// who asked for it, or uuid.Nil for the background runs.
+func (cfg *apiConfig) submitAssetGC(ctx context.Context, ownerID uuid.UUID) (*jobs.Job, error) {
+	return cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindAssetGC,
+		OwnerID:  ownerID,
+		Priority: jobs.PriorityLow,
+		Run: tracedJob(ctx, jobKindAssetGC, func(ctx context.Context, job *jobs.Job) error {
+			_, err := cfg.collectAssetGarbage(ctx)
+			return err
+		}),
+	})
+}
+
+// startAssetGC queues a GC run every Interval until ctx is done:
+func (cfg *apiConfig) startAssetGC(ctx context.Context) {
+	if cfg.assetGC.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.assetGC.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := cfg.submitAssetGC(ctx, uuid.Nil); err != nil {
+					log.Printf("Couldn't queue asset GC: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// handlerAdminAssetGC reports the files GC would remove with ?dry_run=true, and
+// otherwise queues a run and responds with its job ID.
+func (cfg *apiConfig) handlerAdminAssetGC(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		DryRun bool            `json:"dry_run"`
+		Files  []orphanedAsset `json:"files"`
+		Bytes  int64           `json:"bytes"`
+	}
+
+	user, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "dry_run must be true or false", err)
+			return
+		}
+	}
+
+	if !dryRun {
+		job, err := cfg.submitAssetGC(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusServiceUnavailable, "Couldn't queue asset GC", err)
+			return
+		}
+		respondWithJSON(w, http.StatusAccepted, map[string]any{"job_id": job.ID})
+		return
+	}
+
+	orphans, err := cfg.findOrphanedAssets(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list orphaned assets", err)
+		return
+	}
+	resp := response{DryRun: true, Files: orphans}
+	if resp.Files == nil {
+		resp.Files = []orphanedAsset{}
+	}
+	for _, orphan := range orphans {
+		resp.Bytes += orphan.Size
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
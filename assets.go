@@ -10,7 +10,7 @@ import (
 )
 
 // ensure a directory exists at cfg.assetsRoot:
-func (cfg apiConfig) ensureAssetsDir() error {
+func (cfg *apiConfig) ensureAssetsDir() error {
 	// os.Stat(cfg.assetsRoot): checks file/dir info.
 	// If it returns an error and os.IsNotExist(err) is true, the path doesn't exist.
 	if _, err := os.Stat(cfg.assetsRoot); os.IsNotExist(err) {
@@ -40,22 +40,14 @@ func getAssetPath(mediaType string) string {
 	return fmt.Sprintf("%s%s", id, ext)
 }
 
-// S3 URLs are in the format https://<bucket-name>.s3.<region>.amazonaws.com/<key>. 
-// Make sure you use the correct region and bucket name!
-// Create a method on apiConfig that builds a public S3 object URL from a key (path/filename)
-func (cfg apiConfig) getObjectURL(key string) string {
-	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.s3Bucket, cfg.s3Region, key)
-}
-
-
 // filepath.Join(cfg.assetsRoot, assetPath) safely builds an OS-correct path by joining the assets root 
 // directory with the relative asset path:
-func (cfg apiConfig) getAssetDiskPath(assetPath string) string {
+func (cfg *apiConfig) getAssetDiskPath(assetPath string) string {
 	return filepath.Join(cfg.assetsRoot, assetPath)
 }
 
 // create the URL for the file:
-func (cfg apiConfig) getAssetURL(assetPath string) string {
+func (cfg *apiConfig) getAssetURL(assetPath string) string {
 	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, assetPath)
 }
 
@@ -63,7 +55,14 @@ func (cfg apiConfig) getAssetURL(assetPath string) string {
 //	* Split the string on "/"", e.g. "image/png" -> ["image","png"]
 //	* If it doesn�t split into exactly two parts, returns a default ".bin"
 //	* Otherwise returns "." + the subtype, e.g. ".png", ".jpeg", ".mp4"
+//	* Audio types whose subtype isn't the usual extension are special-cased
 func mediaTypeToExt(mediaType string) string {
+	switch mediaType {
+	case "audio/mpeg":
+		return ".mp3"
+	case "audio/mp4":
+		return ".m4a"
+	}
 	parts := strings.Split(mediaType, "/")
 	if len(parts) != 2 {
 		return ".bin"
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+)
+
+// media_kind values, so clients can tell videos from audio posts:
+const (
+	mediaKindVideo = "video"
+	mediaKindAudio = "audio"
+)
+
+// audioFormats lists the accepted audio uploads and how each is re-encoded after
+// normalization; the output keeps the upload's container.
+var audioFormats = map[string]struct {
+	codec  string
+	format string
+}{
+	"audio/mpeg": {"libmp3lame", "mp3"},
+	"audio/mp4":  {"aac", "mp4"},
+	"audio/ogg":  {"libopus", "ogg"},
+}
+
+func isAllowedUploadType(mediaType string) bool {
+	if mediaType == "video/mp4" {
+		return true
+	}
+	_, ok := audioFormats[mediaType]
+	return ok
+}
+
+func mediaKindFor(mediaType string) string {
+	if _, ok := audioFormats[mediaType]; ok {
+		return mediaKindAudio
+	}
+	return mediaKindVideo
+}
+
+// podcastTask is the transcode step that normalizes an audio upload's loudness,
+// keeping its container:
+func podcastTask(mediaType string) (transcode.Task, error) {
+	audioFormat, ok := audioFormats[mediaType]
+	if !ok {
+		return transcode.Task{}, fmt.Errorf("unsupported audio type %q", mediaType)
+	}
+	return transcode.Task{
+		Kind:   transcode.KindPodcast,
+		Codec:  audioFormat.codec,
+		Format: audioFormat.format,
+	}, nil
+}
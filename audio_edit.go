@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/upload"
+	"github.com/google/uuid"
+)
+
+// audioReplaceLimit caps the audio file sent to replace a video's soundtrack:
+const audioReplaceLimit = 256 << 20
+
+// handlerVideoAudioMute drops the audio track from one of the caller's videos:
+// DELETE /api/videos/{videoID}/audio. The muted file replaces the video's, and
+// the file it played until now is kept as a version.
+func (cfg *apiConfig) handlerVideoAudioMute(w http.ResponseWriter, r *http.Request) {
+	video, key, ok := cfg.audioEditTarget(w, r)
+	if !ok {
+		return
+	}
+
+	video, err := cfg.editVideoAudio(r.Context(), video, key, "", database.VersionAudioMuted)
+	if err != nil {
+		respondWithPipelineError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerVideoAudioReplace swaps the audio track of one of the caller's videos
+// for an uploaded MP3, M4A or Ogg file, sent as "audio" in a multipart form:
+// PUT /api/videos/{videoID}/audio. The new audio is padded with silence, or cut,
+// to the length of the video. The file it played until now is kept as a
+// version.
+func (cfg *apiConfig) handlerVideoAudioReplace(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, audioReplaceLimit)
+
+	video, key, ok := cfg.audioEditTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(cfg.live().MultipartMaxMemory); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Audio is too large", err)
+			return
+		}
+		if respondIfUploadTooSlow(w, err) {
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+		return
+	}
+	file, header, err := r.FormFile("audio")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Invalid Content-Type", err)
+		return
+	}
+	if _, ok := audioFormats[mediaType]; !ok {
+		respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Invalid file type, only MP3, M4A and Ogg audio are allowed", nil)
+		return
+	}
+
+	// Encrypted on disk like an upload's temp file, when TEMP_ENCRYPTION is on:
+	tempFile, err := os.CreateTemp(cfg.uploadTmpDir, "tubely-audio")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not create temp file", err)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	size, err := bufpool.Copy(cfg.tempCipher.writer(tempFile, 0), file)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not write file to disk", err)
+		return
+	}
+	cfg.recordBandwidth(r.Context(), video.UserID, size, 0)
+	audio, closeAudio, err := cfg.tempCipher.plainSource(tempFile.Name())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not read audio", err)
+		return
+	}
+	defer closeAudio()
+
+	// The body is in; processing gets its own, longer deadline:
+	cfg.extendForProcessing(w)
+
+	video, err = cfg.editVideoAudio(r.Context(), video, key, audio, database.VersionAudioReplaced)
+	if err != nil {
+		respondWithPipelineError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// audioEditTarget loads the video an audio edit is for, and the key of its
+// stored file, checking that it's the caller's and that there's a video file
+// to edit. When it reports false, it has already responded.
+func (cfg *apiConfig) audioEditTarget(w http.ResponseWriter, r *http.Request) (database.Video, string, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Video{}, "", false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, "", false
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, "", false
+	}
+
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, "", false
+	}
+	if video.ID == uuid.Nil {
+		respondWithCode(w, http.StatusNotFound, codeNotFound, "Video not found", nil)
+		return database.Video{}, "", false
+	}
+	if video.UserID != userID {
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to edit this video", nil)
+		return database.Video{}, "", false
+	}
+	if video.VideoURL == nil || video.MediaKind != mediaKindVideo {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video has no uploaded video content", nil)
+		return database.Video{}, "", false
+	}
+	obj, err := cfg.db.GetVideoObject(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, "", false
+	}
+	if obj.ObjectKey == "" {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video has no uploaded video content", nil)
+		return database.Video{}, "", false
+	}
+	// An archived object can't be read until it's restored; this starts the restore:
+	if status, err := cfg.playbackStatus(r.Context(), video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video availability", err)
+		return database.Video{}, "", false
+	} else if status == playbackRestoring {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video is being restored from archive, try again later", nil)
+		return database.Video{}, "", false
+	}
+	return video, obj.ObjectKey, true
+}
+
+// editVideoAudio writes the stored file at key with its audio replaced by
+// audio (a path ffmpeg reads), or muted when audio is "", and makes that the
+// video's file. The one it replaces is kept as a version, with reason.
+func (cfg *apiConfig) editVideoAudio(ctx context.Context, video database.Video, key, audio, reason string) (database.Video, error) {
+	// Processing while ffmpeg runs; the old file keeps playing until the new one
+	// is published, and the video goes back to ready if this fails:
+	settle, err := cfg.beginVideoStatus(ctx, video.ID, database.StatusProcessing, database.StatusReady)
+	if err != nil {
+		return database.Video{}, videoStatusError(err)
+	}
+	defer settle()
+
+	source, cleanup, err := cfg.openVideoSource(ctx, key)
+	if err != nil {
+		return database.Video{}, storageError(http.StatusBadGateway, "Couldn't read video from storage", err)
+	}
+	defer cleanup()
+
+	output, err := os.CreateTemp(cfg.uploadTmpDir, "tubely-audio-edit-*.mp4")
+	if err != nil {
+		return database.Video{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Could not create temp file", err}
+	}
+	output.Close()
+	defer os.Remove(output.Name())
+	if err := cfg.transcoder.ReplaceAudio(ctx, source, audio, output.Name()); err != nil {
+		return database.Video{}, &pipelineError{http.StatusInternalServerError, codeProcessingFailed, "Couldn't replace audio", err}
+	}
+
+	// The picture is untouched, so the stored file's (cached) probe has its shape:
+	processedHash, err := cfg.db.GetVideoProcessedHash(ctx, video.ID)
+	if err != nil {
+		log.Printf("Couldn't get processed checksum of video %s: %v", video.ID, err)
+	}
+	probe, err := cfg.probeFile(ctx, source, processedHash)
+	if err != nil {
+		return database.Video{}, &pipelineError{http.StatusInternalServerError, codeProcessingFailed, "Could not probe video", err}
+	}
+	aspectRatio, err := aspectRatioFromProbe(probe)
+	if err != nil {
+		return database.Video{}, &pipelineError{http.StatusInternalServerError, codeProcessingFailed, "Could not determine aspect ratio", err}
+	}
+
+	originalFilename := ""
+	if video.OriginalFilename != nil {
+		originalFilename = *video.OriginalFilename
+	}
+	video, _, err = cfg.storeProcessedVideo(ctx, video, output.Name(), "video/mp4", aspectRatio, originalFilename, upload.Metadata{}, reason)
+	if err != nil {
+		return database.Video{}, err
+	}
+	cfg.hooks.Processed(ctx, video)
+	return video, nil
+}
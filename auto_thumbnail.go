@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/flags"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/google/uuid"
+)
+
+const jobKindAutoThumbnail = "auto_thumbnail"
+
+// autoThumbnailFilter skips frames that are mostly black (fade-ins, title cards
+// on black) and lets ffmpeg's thumbnail filter pick the most representative of
+// the next 100 frames:
+const autoThumbnailFilter = "blackframe=amount=0,metadata=mode=select:key=lavfi.blackframe.pblack:value=90:function=less,thumbnail=100"
+
+// scheduleAutoThumbnail queues a job that gives a video without a thumbnail one
+// taken from its own frames, so list views never show an empty tile. It's off
+// with AUTO_THUMBNAILS=false. Like packaging, the job gets its own hard link to
+// the processed file.
+func (cfg *apiConfig) scheduleAutoThumbnail(ctx context.Context, video database.Video, processedFilePath string) {
+	if !cfg.live().Flags.Enabled(flags.AutoThumbnails) || video.ThumbnailURL != nil || video.MediaKind != mediaKindVideo {
+		return
+	}
+
+	input := processedFilePath + ".thumbnail"
+	if err := os.Link(processedFilePath, input); err != nil {
+		log.Printf("Couldn't stage video %s for its thumbnail: %v", video.ID, err)
+		return
+	}
+	_, err := cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindAutoThumbnail,
+		OwnerID:  video.UserID,
+		VideoID:  video.ID,
+		Priority: jobs.PriorityLow,
+		Run: tracedJob(ctx, jobKindAutoThumbnail, func(ctx context.Context, job *jobs.Job) error {
+			defer os.Remove(input)
+			return cfg.generateThumbnail(ctx, video.ID, input)
+		}),
+	})
+	if err != nil {
+		os.Remove(input)
+		log.Printf("Couldn't queue thumbnail generation for video %s: %v", video.ID, err)
+	}
+}
+
+// scheduleAutoThumbnailFromStore is scheduleAutoThumbnail for a video that was
+// never on local disk (UPLOAD_STAGING=s3), reading the stored file instead:
+func (cfg *apiConfig) scheduleAutoThumbnailFromStore(ctx context.Context, video database.Video, key string) {
+	if !cfg.live().Flags.Enabled(flags.AutoThumbnails) || video.ThumbnailURL != nil || video.MediaKind != mediaKindVideo {
+		return
+	}
+	_, err := cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindAutoThumbnail,
+		OwnerID:  video.UserID,
+		VideoID:  video.ID,
+		Priority: jobs.PriorityLow,
+		Run: tracedJob(ctx, jobKindAutoThumbnail, func(ctx context.Context, job *jobs.Job) error {
+			source, cleanup, err := cfg.openVideoSource(ctx, key)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			return cfg.generateThumbnail(ctx, video.ID, source)
+		}),
+	})
+	if err != nil {
+		log.Printf("Couldn't queue thumbnail generation for video %s: %v", video.ID, err)
+	}
+}
+
+// generateThumbnail extracts the frame and sets it as the thumbnail, unless the
+// owner uploaded one in the meantime.
+func (cfg *apiConfig) generateThumbnail(ctx context.Context, videoID uuid.UUID, inputFilePath string) error {
+	video, err := cfg.videos.GetVideo(ctx, videoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.ThumbnailURL != nil {
+		return nil
+	}
+
+	assetPath := getAssetPath("image/jpeg")
+	assetDiskPath := cfg.getAssetDiskPath(assetPath)
+	if err := cfg.transcoder.ExtractThumbnail(ctx, inputFilePath, assetDiskPath); err != nil {
+		os.Remove(assetDiskPath)
+		return err
+	}
+
+	url := cfg.getAssetURL(assetPath)
+	video, err = cfg.updateVideo(ctx, video, func(v *database.Video) {
+		if v.ThumbnailURL == nil {
+			v.ThumbnailURL = &url
+		}
+	})
+	if err != nil || video.ThumbnailURL == nil || *video.ThumbnailURL != url {
+		// Deleted, or someone else's thumbnail won:
+		os.Remove(assetDiskPath)
+		if errors.Is(err, errVideoGone) {
+			return nil
+		}
+		return err
+	}
+	cfg.makeThumbnailVariants(ctx, video, assetDiskPath)
+	log.Printf("Generated a thumbnail for video %s", videoID)
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// recordBandwidth counts bytes a user uploaded (in), or had served through the
+// API (out), against the day. Uploads are counted as they arrive, whether or
+// not they're processed, and streams by their viewers against the video's
+// owner. A failure only means the bytes are free, so it's logged.
+func (cfg *apiConfig) recordBandwidth(ctx context.Context, userID uuid.UUID, bytesIn, bytesOut int64) {
+	if bytesIn <= 0 && bytesOut <= 0 {
+		return
+	}
+	if err := cfg.db.RecordBandwidth(context.WithoutCancel(ctx), userID, bytesIn, bytesOut); err != nil {
+		log.Printf("Couldn't record bandwidth of user %s: %v", userID, err)
+	}
+}
+
+// tierLimitOf is the limits of the user's tier; an unknown tier gets the free
+// one's:
+func (cfg *apiConfig) tierLimitOf(user *database.User) tierLimit {
+	limit, ok := cfg.live().TierLimits[user.Tier]
+	if !ok {
+		limit = cfg.live().TierLimits[database.TierFree]
+	}
+	return limit
+}
+
+// checkTransfer turns the user away once they've used up their tier's monthly
+// transfer (FREE_MONTHLY_TRANSFER_GB, PRO_MONTHLY_TRANSFER_GB): their uploads
+// aren't processed and their videos aren't streamed until the month is out.
+func (cfg *apiConfig) checkTransfer(ctx context.Context, user *database.User) error {
+	limit := cfg.tierLimitOf(user)
+	if limit.MonthlyTransfer <= 0 {
+		return nil
+	}
+	used, err := cfg.db.GetBandwidthSince(ctx, user.ID, monthStart(time.Now()))
+	if err != nil {
+		return &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't get bandwidth usage", err}
+	}
+	if used.Total() >= limit.MonthlyTransfer {
+		return &pipelineError{http.StatusPaymentRequired, codeTransferQuota,
+			fmt.Sprintf("The %s tier allows %d GiB of transfer a month, and it's used up", user.Tier, limit.MonthlyTransfer>>30), nil}
+	}
+	return nil
+}
+
+// countingResponseWriter counts the body bytes written through it:
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection's deadlines:
+func (w *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// handlerUsage is what the user has used of their tier's monthly limits, with
+// the month's bandwidth per day.
+func (cfg *apiConfig) handlerUsage(w http.ResponseWriter, r *http.Request) {
+	type uploadUsage struct {
+		UsedMinutes  int `json:"used_minutes"`
+		LimitMinutes int `json:"limit_minutes,omitempty"`
+	}
+	type transferUsage struct {
+		database.Bandwidth
+		TotalBytes int64 `json:"total_bytes"`
+		LimitBytes int64 `json:"limit_bytes,omitempty"`
+	}
+	type response struct {
+		Tier        string                  `json:"tier"`
+		PeriodStart time.Time               `json:"period_start"`
+		Uploads     uploadUsage             `json:"uploads"`
+		Transfer    transferUsage           `json:"transfer"`
+		Days        []database.BandwidthDay `json:"days"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	limit := cfg.tierLimitOf(user)
+	since := monthStart(time.Now())
+
+	minutes, err := cfg.db.GetUploadUsageSince(r.Context(), userID, since)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload usage", err)
+		return
+	}
+	days, err := cfg.db.GetDailyBandwidth(r.Context(), userID, since)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get bandwidth usage", err)
+		return
+	}
+	transfer := transferUsage{LimitBytes: limit.MonthlyTransfer}
+	for _, day := range days {
+		transfer.BytesIn += day.BytesIn
+		transfer.BytesOut += day.BytesOut
+	}
+	transfer.TotalBytes = transfer.Total()
+
+	respondWithJSON(w, http.StatusOK, response{
+		Tier:        user.Tier,
+		PeriodStart: since,
+		Uploads: uploadUsage{
+			UsedMinutes:  int(minutes.Minutes()),
+			LimitMinutes: int(limit.MonthlyDuration.Minutes()),
+		},
+		Transfer: transfer,
+		Days:     days,
+	})
+}
@@ -1,10 +1,51 @@
 package main
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
 
-func noCacheMiddleware(next http.Handler) http.Handler {
+// cachingConfig is how long CDNs and browsers may keep each kind of file
+// (CACHE_*_MAX_AGE). Objects get theirs as Cache-Control metadata when stored,
+// so it only applies to files stored after a change.
+type cachingConfig struct {
+	// Immutable is for videos under content-derived keys: the key names the
+	// bytes, so what's behind it can never change and caches needn't revalidate.
+	Immutable time.Duration
+	// Video is for videos under the random-name layouts.
+	Video time.Duration
+	// Stream is for HLS/DASH packages, which get a fresh prefix each time.
+	Stream time.Duration
+	// Thumbnail is for the files under /assets/: thumbnails, avatars and
+	// watermarks. They're the shortest, since asset GC removes replaced ones.
+	Thumbnail time.Duration
+}
+
+// cacheControl is the Cache-Control value for maxAge; 0 means caches must
+// revalidate every time.
+func cacheControl(maxAge time.Duration, immutable bool) string {
+	if maxAge <= 0 {
+		return "no-cache"
+	}
+	value := fmt.Sprintf("public, max-age=%d", int64(maxAge/time.Second))
+	if immutable {
+		value += ", immutable"
+	}
+	return value
+}
+
+// videoCacheControl is the Cache-Control of a stored video file:
+func (cfg *apiConfig) videoCacheControl(contentAddressed bool) string {
+	if contentAddressed {
+		return cacheControl(cfg.caching.Immutable, true)
+	}
+	return cacheControl(cfg.caching.Video, false)
+}
+
+func cacheControlMiddleware(value string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Cache-Control", value)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+
+	"github.com/google/uuid"
+)
+
+// With STORAGE_KEY_LAYOUT=cas, identical uploads share one object (see
+// keys.LayoutCAS); content_objects counts the videos pointing at each.
+
+// hashFile returns the hex SHA-256 of the file's contents:
+func hashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := bufpool.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// releaseVideoContent drops the video's reference to its content-addressed object
+// and deletes the object once nothing else points at it. Videos stored under the
+// prefix layout have no hash and are left alone.
+func (cfg *apiConfig) releaseVideoContent(ctx context.Context, videoID uuid.UUID) error {
+	hash, err := cfg.db.GetVideoContentHash(ctx, videoID)
+	if err != nil {
+		return err
+	}
+	if hash == nil {
+		return nil
+	}
+	if err := cfg.db.SetVideoContentHash(ctx, videoID, nil); err != nil {
+		return err
+	}
+	return cfg.releaseContentHash(ctx, *hash)
+}
+
+func (cfg *apiConfig) releaseContentHash(ctx context.Context, hash string) error {
+	orphanedKey, err := cfg.db.ReleaseContentObject(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if orphanedKey == "" {
+		return nil
+	}
+	log.Printf("Deleting unreferenced object %s", orphanedKey)
+	if err := cfg.store.Delete(ctx, orphanedKey); err != nil {
+		return err
+	}
+	cfg.invalidateCDN(ctx, orphanedKey)
+	cfg.deleteReplicas(ctx, orphanedKey)
+	return nil
+}
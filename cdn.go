@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// newCDN builds what serves media URLs (CDN_PROVIDER, checked by
+// internal/config):
+//
+//   - "cloudfront", the default on S3: the S3_CF_DISTRO distribution. Setting
+//     CLOUDFRONT_DISTRIBUTION_ID lets deletes invalidate it, and
+//     CLOUDFRONT_KEY_PAIR_ID with CLOUDFRONT_PRIVATE_KEY_FILE lets it sign URLs.
+//   - "cloudflare": CLOUDFLARE_DOMAIN, an R2 custom domain or a Worker route.
+//     CLOUDFLARE_ZONE_ID and CLOUDFLARE_API_TOKEN enable purges, and
+//     CLOUDFLARE_SIGNING_SECRET signed URLs (for the Worker to check).
+//   - "none", the default on local storage: the store's own URLs.
+func newCDN(conf config.CDN, store storage.Store, awsCfg aws.Config) (cdn.CDN, error) {
+	switch conf.Provider {
+	case "cloudfront":
+		cf := &cdn.CloudFront{
+			Domain:         conf.CloudFrontDomain,
+			DistributionID: conf.CloudFrontDistributionID,
+			KeyPairID:      conf.CloudFrontKeyPairID,
+			Config:         awsCfg,
+		}
+		if keyFile := conf.CloudFrontPrivateKeyFile; keyFile != "" {
+			key, err := cdn.LoadCloudFrontKey(keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't load CloudFront signing key: %w", err)
+			}
+			cf.PrivateKey = key
+		}
+		return cf, nil
+	case "cloudflare":
+		return &cdn.Cloudflare{
+			Domain:        conf.CloudflareDomain,
+			ZoneID:        conf.CloudflareZoneID,
+			APIToken:      conf.CloudflareAPIToken,
+			SigningSecret: conf.CloudflareSigningSecret,
+		}, nil
+	default:
+		return cdn.Origin{Store: store}, nil
+	}
+}
+
+// invalidateCDN drops deleted objects from the CDN's caches, so they stop being
+// served before their TTL runs out. It's best effort, like the deletes it follows.
+func (cfg *apiConfig) invalidateCDN(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	if err := cfg.cdn.Invalidate(ctx, keys...); err != nil {
+		log.Printf("Couldn't invalidate %d objects in the CDN: %v", len(keys), err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// maxChapters keeps a single upload from filling the chapters table:
+const maxChapters = 500
+
+// chaptersFromProbe reads the chapter markers embedded in an MP4 (the "chpl"/QuickTime
+// chapter track) from ffprobe's -show_chapters JSON output, see probeFile. A file
+// without chapters returns an empty slice, not an error.
+func chaptersFromProbe(probeOutput []byte) ([]database.CreateChapterParams, error) {
+	// ffprobe reports the times as decimal strings, e.g. "12.345000":
+	var output struct {
+		Chapters []struct {
+			StartTime string `json:"start_time"`
+			EndTime   string `json:"end_time"`
+			Tags      struct {
+				Title string `json:"title"`
+			} `json:"tags"`
+		} `json:"chapters"`
+	}
+	if err := json.Unmarshal(probeOutput, &output); err != nil {
+		return nil, fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+
+	chapters := []database.CreateChapterParams{}
+	for i, ch := range output.Chapters {
+		start, err := strconv.ParseFloat(ch.StartTime, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chapter start time %q: %v", ch.StartTime, err)
+		}
+		end, err := strconv.ParseFloat(ch.EndTime, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chapter end time %q: %v", ch.EndTime, err)
+		}
+		title := strings.TrimSpace(ch.Tags.Title)
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		chapters = append(chapters, database.CreateChapterParams{
+			StartSeconds: start,
+			EndSeconds:   end,
+			Title:        title,
+		})
+	}
+	return chapters, nil
+}
+
+// parseWebVTTChapters parses a WebVTT chapters file, the format HTML5 players use
+// for <track kind="chapters">:
+//
+//	WEBVTT
+//
+//	00:00:00.000 --> 00:01:30.000
+//	Intro
+func parseWebVTTChapters(r io.Reader) ([]database.CreateChapterParams, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || !strings.HasPrefix(strings.TrimPrefix(scanner.Text(), "\ufeff"), "WEBVTT") {
+		return nil, errors.New("missing WEBVTT header")
+	}
+
+	chapters := []database.CreateChapterParams{}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.Contains(line, "-->") {
+			// blank lines, cue identifiers and NOTE blocks carry no timing:
+			continue
+		}
+		parts := strings.SplitN(line, "-->", 2)
+		start, err := parseVTTTimestamp(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		// cue settings may follow the end timestamp, e.g. "00:01.000 align:start":
+		fields := strings.Fields(parts[1])
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("cue %q has no end timestamp", line)
+		}
+		end, err := parseVTTTimestamp(fields[0])
+		if err != nil {
+			return nil, err
+		}
+
+		// the cue text runs until the next blank line:
+		var title []string
+		for scanner.Scan() {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				break
+			}
+			title = append(title, text)
+		}
+		chapters = append(chapters, database.CreateChapterParams{
+			StartSeconds: start,
+			EndSeconds:   end,
+			Title:        strings.Join(title, " "),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return chapters, nil
+}
+
+// parseVTTTimestamp accepts both "hh:mm:ss.ttt" and "mm:ss.ttt":
+func parseVTTTimestamp(ts string) (float64, error) {
+	ts = strings.TrimSpace(ts)
+	parts := strings.Split(ts, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", ts)
+	}
+	seconds := 0.0
+	for _, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q", ts)
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds, nil
+}
+
+// validateChapters checks a chapter list before it replaces the stored one:
+func validateChapters(chapters []database.CreateChapterParams) error {
+	if len(chapters) > maxChapters {
+		return fmt.Errorf("too many chapters, the limit is %d", maxChapters)
+	}
+	prevStart := -1.0
+	for i, ch := range chapters {
+		if strings.TrimSpace(ch.Title) == "" {
+			return fmt.Errorf("chapter %d has no title", i+1)
+		}
+		if ch.StartSeconds < 0 || ch.EndSeconds <= ch.StartSeconds {
+			return fmt.Errorf("chapter %d has an invalid time range", i+1)
+		}
+		if ch.StartSeconds <= prevStart {
+			return fmt.Errorf("chapter %d starts before the previous chapter", i+1)
+		}
+		prevStart = ch.StartSeconds
+	}
+	return nil
+}
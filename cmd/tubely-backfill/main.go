@@ -0,0 +1,273 @@
+// Command tubely-backfill runs videos stored before a processing change through
+// the server's current pipeline: the fast-start and codec policy, then HLS/DASH
+// packaging, thumbnails and embedded chapters as new uploads get them. It pages
+// through every stored video with the admin API and has the server reprocess
+// them, -concurrency at a time; the server reads each file back from storage,
+// so the work happens where ffmpeg and the storage credentials are. The file
+// each video played until then is kept as a version.
+//
+// Usage:
+//
+//	tubely-backfill -server http://localhost:8091 -email admin@example.com
+//	tubely-backfill -missing-streams -concurrency 4 -checkpoint hls.checkpoint
+//
+// The password is read from TUBELY_PASSWORD; the account has to be an admin.
+// Each video that's done is appended to the -checkpoint file and skipped by
+// later runs with the same file, so an interrupted backfill picks up where it
+// stopped. Failed videos aren't recorded and are tried again next time.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/pkg/tubelyclient"
+	"github.com/google/uuid"
+)
+
+// pageSize is how many videos are listed per request:
+const pageSize = 200
+
+func main() {
+	server := flag.String("server", envOr("TUBELY_SERVER", "http://localhost:8091"), "Tubely base URL")
+	email := flag.String("email", os.Getenv("TUBELY_EMAIL"), "admin account to run as")
+	checkpointPath := flag.String("checkpoint", "tubely-backfill.checkpoint", "file of the video IDs done so far")
+	concurrency := flag.Int("concurrency", 2, "number of videos reprocessed at once")
+	missingStreams := flag.Bool("missing-streams", false, "only videos that haven't been packaged for HLS or DASH")
+	dryRun := flag.Bool("dry-run", false, "list what would be reprocessed without reprocessing it")
+	flag.Parse()
+
+	if *concurrency < 1 {
+		log.Fatal("-concurrency must be at least 1")
+	}
+	password := os.Getenv("TUBELY_PASSWORD")
+	if *email == "" || password == "" {
+		log.Fatal("-email (or TUBELY_EMAIL) and TUBELY_PASSWORD are required")
+	}
+
+	checkpoint, err := openCheckpoint(*checkpointPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer checkpoint.Close()
+	if n := checkpoint.Len(); n > 0 {
+		log.Printf("Resuming, %d videos done already", n)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sess := &session{server: *server, email: *email, password: password}
+	if err := sess.login(ctx); err != nil {
+		log.Fatalf("Couldn't log in: %v", err)
+	}
+
+	// A fixed pool of workers pulling from a channel keeps at most -concurrency
+	// videos (and server-side transcodes) going at once:
+	queue := make(chan tubelyclient.ReprocessableVideo)
+	var (
+		wg          sync.WaitGroup
+		reprocessed atomic.Int64
+		failed      atomic.Int64
+	)
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for video := range queue {
+				if err := sess.reprocess(ctx, video.ID); err != nil {
+					failed.Add(1)
+					log.Printf("FAIL %s: %v", video.ID, err)
+					continue
+				}
+				if err := checkpoint.Done(video.ID); err != nil {
+					log.Printf("Couldn't record %s in the checkpoint: %v", video.ID, err)
+				}
+				reprocessed.Add(1)
+				log.Printf("OK   %s", video.ID)
+			}
+		}()
+	}
+
+	skipped, err := listVideos(ctx, sess, func(video tubelyclient.ReprocessableVideo) bool {
+		if checkpoint.Has(video.ID) || (*missingStreams && (video.HasStreams || video.MediaKind != "video")) {
+			return false
+		}
+		if *dryRun {
+			fmt.Printf("%s\t%s\t%s\n", video.ID, video.UserID, video.MediaKind)
+			return true
+		}
+		select {
+		case queue <- video:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	close(queue)
+	wg.Wait()
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Couldn't list videos: %v", err)
+	}
+
+	log.Printf("Reprocessed %d, failed %d, skipped %d", reprocessed.Load(), failed.Load(), skipped)
+	if err != nil || failed.Load() > 0 || ctx.Err() != nil {
+		os.Exit(1)
+	}
+}
+
+// listVideos pages through every reprocessable video and hands each to take,
+// which reports whether it took it. It returns how many weren't taken.
+func listVideos(ctx context.Context, sess *session, take func(tubelyclient.ReprocessableVideo) bool) (skipped int, err error) {
+	after := uuid.Nil
+	for ctx.Err() == nil {
+		var page []tubelyclient.ReprocessableVideo
+		err := sess.retryUnauthorized(ctx, func(c *tubelyclient.Client) error {
+			var err error
+			page, err = c.ListReprocessableVideos(ctx, after, pageSize)
+			return err
+		})
+		if err != nil {
+			return skipped, err
+		}
+		if len(page) == 0 {
+			return skipped, nil
+		}
+		for _, video := range page {
+			if !take(video) {
+				skipped++
+			}
+		}
+		after = page[len(page)-1].ID
+	}
+	return skipped, ctx.Err()
+}
+
+// session logs in again when its access token runs out, which it will over a
+// backfill of any size. A fresh client takes over each time, since a client
+// isn't safe to log in while others use it.
+type session struct {
+	server   string
+	email    string
+	password string
+
+	mu     sync.Mutex
+	client *tubelyclient.Client
+}
+
+func (s *session) login(ctx context.Context) error {
+	client := tubelyclient.New(s.server)
+	if _, err := client.Login(ctx, s.email, s.password); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.client = client
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *session) current() *tubelyclient.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client
+}
+
+// retryUnauthorized calls call, and once more after logging in again if the
+// server turned the token away.
+func (s *session) retryUnauthorized(ctx context.Context, call func(*tubelyclient.Client) error) error {
+	err := call(s.current())
+	var apiErr *tubelyclient.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		return err
+	}
+	if err := s.login(ctx); err != nil {
+		return fmt.Errorf("log in again: %w", err)
+	}
+	return call(s.current())
+}
+
+func (s *session) reprocess(ctx context.Context, id uuid.UUID) error {
+	return s.retryUnauthorized(ctx, func(client *tubelyclient.Client) error {
+		_, err := client.ReprocessVideo(ctx, id)
+		return err
+	})
+}
+
+// checkpoint is the set of video IDs done so far, kept in a file with one ID
+// per line. Lines are only ever appended, so a run that's killed leaves at
+// worst a torn last line, which is ignored.
+type checkpoint struct {
+	mu   sync.Mutex
+	f    *os.File
+	done map[uuid.UUID]bool
+}
+
+func openCheckpoint(path string) (*checkpoint, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c := &checkpoint{f: f, done: map[uuid.UUID]bool{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id, err := uuid.Parse(strings.TrimSpace(scanner.Text())); err == nil {
+			c.done[id] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("read checkpoint %s: %w", path, err)
+	}
+	// Finish a torn last line, so the next ID starts on a line of its own:
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			f.WriteString("\n")
+		}
+	}
+	return c, nil
+}
+
+func (c *checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.done)
+}
+
+func (c *checkpoint) Has(id uuid.UUID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[id]
+}
+
+// Done records the video as done, on disk before it returns:
+func (c *checkpoint) Done(id uuid.UUID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done[id] = true
+	if _, err := c.f.WriteString(id.String() + "\n"); err != nil {
+		return err
+	}
+	return c.f.Sync()
+}
+
+func (c *checkpoint) Close() error {
+	return c.f.Close()
+}
+
+func envOr(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	done, todo := uuid.New(), uuid.New()
+
+	c, err := openCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Done(done); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	// A run killed halfway through writing an ID leaves a torn last line:
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(todo.String()[:8])
+	f.Close()
+
+	c, err = openCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 1 || !c.Has(done) || c.Has(todo) {
+		t.Fatalf("resumed with %d done, want only %s", c.Len(), done)
+	}
+	if err := c.Done(todo); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	c, err = openCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Len() != 2 || !c.Has(done) || !c.Has(todo) {
+		t.Errorf("resumed with %d done, want %s and %s", c.Len(), done, todo)
+	}
+}
@@ -0,0 +1,246 @@
+// Command tubely-import migrates an existing video library into Tubely. It walks
+// a directory (or reads a CSV manifest), creates a video record for every file
+// and uploads it through the regular API, so each file goes through the same
+// probe/faststart/storage pipeline as a browser upload.
+//
+// Usage:
+//
+//	tubely-import -server http://localhost:8091 -email me@example.com -dir ./library
+//	tubely-import -manifest library.csv -concurrency 4
+//
+// The password is read from TUBELY_PASSWORD. A manifest has a header row and
+// the columns path,title,description; relative paths are resolved against the
+// manifest's directory. Without a manifest, titles come from the file names.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/pkg/tubelyclient"
+	"github.com/google/uuid"
+)
+
+// importItem is one file to import:
+type importItem struct {
+	Path        string
+	Title       string
+	Description string
+}
+
+// importableExts maps the extensions we pick up when walking a directory to the
+// Content-Type the server expects:
+var importableExts = map[string]string{
+	".mp4": "video/mp4",
+	".m4v": "video/mp4",
+	".mp3": "audio/mpeg",
+	".m4a": "audio/mp4",
+	".ogg": "audio/ogg",
+}
+
+func main() {
+	server := flag.String("server", envOr("TUBELY_SERVER", "http://localhost:8091"), "Tubely base URL")
+	email := flag.String("email", os.Getenv("TUBELY_EMAIL"), "account to import into")
+	dir := flag.String("dir", "", "directory to walk for video files")
+	manifest := flag.String("manifest", "", "CSV manifest (path,title,description) instead of -dir")
+	concurrency := flag.Int("concurrency", 2, "number of uploads in flight")
+	dryRun := flag.Bool("dry-run", false, "list what would be imported without uploading")
+	flag.Parse()
+
+	if (*dir == "") == (*manifest == "") {
+		log.Fatal("exactly one of -dir or -manifest is required")
+	}
+	if *concurrency < 1 {
+		log.Fatal("-concurrency must be at least 1")
+	}
+
+	var (
+		items []importItem
+		err   error
+	)
+	if *manifest != "" {
+		items, err = readManifest(*manifest)
+	} else {
+		items, err = walkDir(*dir)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Found %d files to import", len(items))
+	if *dryRun {
+		for _, item := range items {
+			fmt.Printf("%s\t%s\n", item.Path, item.Title)
+		}
+		return
+	}
+
+	password := os.Getenv("TUBELY_PASSWORD")
+	if *email == "" || password == "" {
+		log.Fatal("-email (or TUBELY_EMAIL) and TUBELY_PASSWORD are required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := tubelyclient.New(*server)
+	if _, err := client.Login(ctx, *email, password); err != nil {
+		log.Fatalf("Couldn't log in: %v", err)
+	}
+
+	// A fixed pool of workers pulling from a channel keeps at most -concurrency
+	// uploads (and server-side transcodes) going at once:
+	queue := make(chan importItem)
+	var (
+		wg       sync.WaitGroup
+		imported atomic.Int64
+		failed   atomic.Int64
+	)
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				id, err := importOne(ctx, client, item)
+				if err != nil {
+					failed.Add(1)
+					log.Printf("FAIL %s: %v", item.Path, err)
+					continue
+				}
+				imported.Add(1)
+				log.Printf("OK   %s -> %s", item.Path, id)
+			}
+		}()
+	}
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- item
+	}
+	close(queue)
+	wg.Wait()
+
+	log.Printf("Imported %d, failed %d, skipped %d", imported.Load(), failed.Load(), int64(len(items))-imported.Load()-failed.Load())
+	if failed.Load() > 0 || ctx.Err() != nil {
+		os.Exit(1)
+	}
+}
+
+// importOne creates the record and uploads the file. If the upload fails the
+// draft is deleted again, so a rerun doesn't leave duplicates behind.
+func importOne(ctx context.Context, client *tubelyclient.Client, item importItem) (uuid.UUID, error) {
+	contentType, ok := importableExts[strings.ToLower(filepath.Ext(item.Path))]
+	if !ok {
+		return uuid.Nil, fmt.Errorf("unsupported file type")
+	}
+
+	video, err := client.CreateVideo(ctx, item.Title, item.Description)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("create video: %w", err)
+	}
+	if _, err := client.UploadVideo(ctx, video.ID, item.Path, contentType); err != nil {
+		if delErr := client.DeleteVideo(context.WithoutCancel(ctx), video.ID); delErr != nil {
+			log.Printf("Couldn't delete draft %s: %v", video.ID, delErr)
+		}
+		return uuid.Nil, fmt.Errorf("upload: %w", err)
+	}
+	return video.ID, nil
+}
+
+func walkDir(root string) ([]importItem, error) {
+	var items []importItem
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if _, ok := importableExts[ext]; !ok {
+			return nil
+		}
+		items = append(items, importItem{
+			Path:  path,
+			Title: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		})
+		return nil
+	})
+	return items, err
+}
+
+func readManifest(manifestPath string) ([]importItem, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read manifest header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["path"]; !ok {
+		return nil, errors.New("manifest needs a path column")
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	baseDir := filepath.Dir(manifestPath)
+	var items []importItem
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("manifest line %d: %w", line, err)
+		}
+		item := importItem{
+			Path:        field(record, "path"),
+			Title:       field(record, "title"),
+			Description: field(record, "description"),
+		}
+		if item.Path == "" {
+			return nil, fmt.Errorf("manifest line %d: empty path", line)
+		}
+		if !filepath.IsAbs(item.Path) {
+			item.Path = filepath.Join(baseDir, item.Path)
+		}
+		if item.Title == "" {
+			item.Title = strings.TrimSuffix(filepath.Base(item.Path), filepath.Ext(item.Path))
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func envOr(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,300 @@
+// Command tubely-worker runs transcodes for the API server. With JOB_BACKEND set
+// to sqs or redis, the server stages each raw upload in the bucket and queues a
+// task instead of running ffmpeg itself; any number of workers pull those tasks,
+// process the file and upload the result, so the CPU-heavy work scales apart
+// from the API.
+//
+// It reads the same environment as the server: JOB_BACKEND, SQS_QUEUE_URL or
+// REDIS_URL (and REDIS_STREAM), S3_BUCKET, S3_REGION, S3_ENDPOINT, S3_SSE_MODE
+// and S3_SSE_KMS_KEY_ID. UPLOAD_TMP_DIR is where files are processed, with the
+// encoder VIDEO_ENCODER (and VAAPI_DEVICE) picks on this worker's host.
+//
+// Usage:
+//
+//	tubely-worker -concurrency 2
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/taskqueue"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/telemetry"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/joho/godotenv"
+)
+
+// worker pulls tasks off the queue and runs them:
+type worker struct {
+	name   string
+	broker taskqueue.Broker
+	store  storage.Store
+	tmpDir string
+}
+
+func main() {
+	godotenv.Load(".env")
+
+	concurrency := flag.Int("concurrency", envInt("WORKER_CONCURRENCY", 1), "number of transcodes run at once")
+	flag.Parse()
+	if *concurrency < 1 {
+		log.Fatal("-concurrency must be at least 1")
+	}
+
+	backend := os.Getenv("JOB_BACKEND")
+	s3Bucket := os.Getenv("S3_BUCKET")
+	s3Region := os.Getenv("S3_REGION")
+	if s3Bucket == "" || s3Region == "" {
+		log.Fatal("S3_BUCKET and S3_REGION environment variables are required")
+	}
+	encryption, err := storage.ParseEncryption(os.Getenv("S3_SSE_MODE"), os.Getenv("S3_SSE_KMS_KEY_ID"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	tmpDir := os.Getenv("UPLOAD_TMP_DIR")
+	if tmpDir == "" {
+		tmpDir = os.TempDir()
+	}
+	if size := envInt("COPY_BUFFER_SIZE", bufpool.DefaultSize); size < 4<<10 {
+		log.Fatal("COPY_BUFFER_SIZE must be at least 4096")
+	} else {
+		bufpool.SetSize(size)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	vaapiDevice := os.Getenv("VAAPI_DEVICE")
+	if vaapiDevice == "" {
+		vaapiDevice = "/dev/dri/renderD128"
+	}
+	encoding, err := transcode.DetectEncoding(ctx, os.Getenv("VIDEO_ENCODER"), vaapiDevice)
+	if err != nil {
+		log.Printf("Falling back to libx264: %v", err)
+	}
+	transcode.UseEncoding(encoding)
+
+	shutdownTracing, err := telemetry.Setup(ctx, "tubely-worker")
+	if err != nil {
+		log.Fatalf("Couldn't set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(s3Region))
+	if err != nil {
+		log.Fatal(err)
+	}
+	telemetry.AppendAWSMiddlewares(&awsCfg.APIOptions)
+
+	hostname, _ := os.Hostname()
+	name := hostname + "-" + strconv.Itoa(os.Getpid())
+	broker, err := taskqueue.Open(taskqueue.Config{
+		Backend:     backend,
+		SQSQueueURL: os.Getenv("SQS_QUEUE_URL"),
+		AWS:         awsCfg,
+		RedisURL:    os.Getenv("REDIS_URL"),
+		RedisStream: os.Getenv("REDIS_STREAM"),
+		Consumer:    name,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := &worker{
+		name:   name,
+		broker: broker,
+		store: &storage.S3Store{
+			Client:     storage.NewS3Client(awsCfg, os.Getenv("S3_ENDPOINT")),
+			Bucket:     s3Bucket,
+			Region:     s3Region,
+			Encryption: encryption,
+			Endpoint:   os.Getenv("S3_ENDPOINT"),
+		},
+		tmpDir: tmpDir,
+	}
+
+	log.Printf("Worker %s pulling transcodes from %s with concurrency %d, encoding with %s", name, backend, *concurrency, encoding.Encoder)
+	var wg sync.WaitGroup
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+	// Tasks interrupted by the shutdown weren't acked, so another worker picks
+	// them up once their visibility timeout runs out.
+	log.Printf("Worker %s stopped", name)
+}
+
+// loop receives and handles tasks until ctx is done:
+func (w *worker) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		d, err := w.broker.Receive(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Couldn't receive task: %v", err)
+				// Don't spin while the queue is unreachable:
+				select {
+				case <-ctx.Done():
+				case <-time.After(5 * time.Second):
+				}
+			}
+			continue
+		}
+		if d != nil {
+			w.handle(ctx, d)
+		}
+	}
+}
+
+// errAbandoned means the API server gave up on the task and deleted its input:
+var errAbandoned = errors.New("task input is gone")
+
+// handle runs one task. A failed transcode is reported to the server and the
+// task acked, since the same file would fail again; trouble reaching storage
+// leaves it unacked, to be retried.
+func (w *worker) handle(ctx context.Context, d *taskqueue.Delivery) {
+	msg := d.Message
+	stop := d.KeepAlive(ctx)
+	defer stop()
+
+	start := time.Now()
+	outputSize, transcodeErr, err := w.run(ctx, msg)
+	if errors.Is(err, errAbandoned) {
+		log.Printf("Dropping task %s for video %s: %v", msg.ID, msg.VideoID, err)
+		w.ack(d)
+		return
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Task %s for video %s will be retried: %v", msg.ID, msg.VideoID, err)
+		}
+		return
+	}
+
+	result := taskqueue.Result{Worker: w.name, FinishedAt: time.Now().UTC(), OutputSize: outputSize}
+	if transcodeErr != nil {
+		result.Error = transcodeErr.Error()
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Couldn't encode result of task %s: %v", msg.ID, err)
+		return
+	}
+	if err := w.store.Put(ctx, msg.ResultKey, bytes.NewReader(body), storage.PutOptions{ContentType: "application/json"}); err != nil {
+		log.Printf("Couldn't store result of task %s, it will be retried: %v", msg.ID, err)
+		return
+	}
+	w.ack(d)
+
+	if transcodeErr != nil {
+		log.Printf("Task %s (%s) for video %s failed: %v", msg.ID, msg.Task.Kind, msg.VideoID, transcodeErr)
+		return
+	}
+	log.Printf("Task %s (%s) for video %s done in %s", msg.ID, msg.Task.Kind, msg.VideoID, time.Since(start).Round(time.Millisecond))
+}
+
+// ack removes a handled task from the queue. If that fails it comes back, and
+// running it again does no harm.
+func (w *worker) ack(d *taskqueue.Delivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := d.Ack(ctx); err != nil {
+		log.Printf("Couldn't ack task %s: %v", d.Message.ID, err)
+	}
+}
+
+// run downloads the task's files, transcodes and uploads the output, returning
+// its size. The first error is the transcode's own failure; the second is
+// anything else.
+func (w *worker) run(ctx context.Context, msg taskqueue.Message) (outputSize int64, transcodeErr, err error) {
+	dir, err := os.MkdirTemp(w.tmpDir, "tubely-worker-")
+	if err != nil {
+		return 0, nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	inputPath := filepath.Join(dir, "input")
+	if err := w.download(ctx, msg.InputKey, inputPath); err != nil {
+		return 0, nil, err
+	}
+	task := msg.Task
+	if msg.LogoKey != "" {
+		task.LogoPath = filepath.Join(dir, "logo")
+		if err := w.download(ctx, msg.LogoKey, task.LogoPath); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	outputPath, err := transcode.Run(ctx, task, inputPath, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, nil, ctx.Err()
+		}
+		return 0, err, nil
+	}
+
+	f, err := os.Open(outputPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := w.store.Put(ctx, msg.OutputKey, f, storage.PutOptions{ContentType: "application/octet-stream"}); err != nil {
+		return 0, nil, fmt.Errorf("couldn't upload output: %w", err)
+	}
+	return info.Size(), nil, nil
+}
+
+func (w *worker) download(ctx context.Context, key, filePath string) error {
+	body, err := w.store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return errAbandoned
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't download %s: %w", key, err)
+	}
+	defer body.Close()
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err := bufpool.Copy(f, body); err != nil {
+		f.Close()
+		return fmt.Errorf("couldn't download %s: %w", key, err)
+	}
+	return f.Close()
+}
+
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", name, err)
+	}
+	return n
+}
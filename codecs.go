@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+)
+
+// codecPolicy lists the codecs browsers play everywhere. Uploads whose streams
+// fall outside it (HEVC, 10-bit or 4:2:2 video, AC-3 audio...) are re-encoded
+// to H.264/yuv420p and AAC during processing instead of having their streams
+// copied. It's config.Codecs, with the methods to apply it.
+type codecPolicy struct {
+	// VideoCodecs and PixelFormats are ffprobe names (ALLOWED_VIDEO_CODECS,
+	// ALLOWED_PIXEL_FORMATS), e.g. "h264" and "yuv420p":
+	VideoCodecs  []string
+	PixelFormats []string
+	// AudioCodecs (ALLOWED_AUDIO_CODECS), e.g. "aac":
+	AudioCodecs []string
+	// Reencode (AUTO_REENCODE) turns the re-encoding off; everything is copied
+	// as uploaded, as before the allow-list existed.
+	Reencode bool
+}
+
+// apply marks the streams of the probed file that are outside the allow-list
+// for re-encoding on task. Streams the file doesn't have are left alone.
+func (p codecPolicy) apply(task *transcode.Task, probeOutput []byte) error {
+	var output ffprobeStreams
+	if err := json.Unmarshal(probeOutput, &output); err != nil {
+		return fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+	// ffmpeg keeps one stream of each type; judge the first:
+	var sawVideo, sawAudio bool
+	for _, stream := range output.Streams {
+		switch {
+		case stream.CodecType == "video" && !sawVideo:
+			sawVideo = true
+			if !slices.Contains(p.VideoCodecs, stream.CodecName) || !slices.Contains(p.PixelFormats, stream.PixFmt) {
+				task.ReencodeVideo = true
+			}
+		case stream.CodecType == "audio" && !sawAudio:
+			sawAudio = true
+			if !slices.Contains(p.AudioCodecs, stream.CodecName) {
+				task.ReencodeAudio = true
+			}
+		}
+	}
+	return nil
+}
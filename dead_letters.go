@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/upload"
+	"github.com/google/uuid"
+)
+
+// A transcode or storage upload that fails is retried with backoff
+// (JOB_RETRY_ATTEMPTS, JOB_RETRY_BACKOFF). When every attempt fails the upload
+// is dead-lettered: the raw file is kept in UPLOAD_TMP_DIR/dead-letter and a
+// database.DeadLetter records what went wrong, for an admin to look into and
+// re-drive through the pipeline once it's fixed. Uploads staged in the bucket
+// (UPLOAD_STAGING=s3) aren't kept.
+const (
+	jobKindStoreVideo = "store_video"
+	deadLetterDir     = "dead-letter"
+	// maxDeadLetterStderr is how much of ffmpeg's stderr is kept, from the end,
+	// where it says what went wrong:
+	maxDeadLetterStderr = 16 << 10
+)
+
+func (cfg *apiConfig) deadLetterPath() string {
+	return filepath.Join(cfg.uploadTmpDir, deadLetterDir)
+}
+
+// storeProcessedFile puts the file at filePath into storage under key on the
+// job queue, so a failed upload is retried like a failed transcode.
+func (cfg *apiConfig) storeProcessedFile(ctx context.Context, video database.Video, key, filePath string, size int64, opts storage.PutOptions) error {
+	job, err := cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindStoreVideo,
+		OwnerID:  video.UserID,
+		VideoID:  video.ID,
+		Priority: cfg.processingPriority(size),
+		Retry:    cfg.jobRetry,
+		Run: tracedJob(ctx, jobKindStoreVideo, func(ctx context.Context, job *jobs.Job) error {
+			f, err := os.Open(filePath)
+			if err != nil {
+				return jobs.Permanent(err)
+			}
+			defer f.Close()
+			err = cfg.store.Put(ctx, key, f, opts)
+			if errors.Is(err, storage.ErrTooLarge) {
+				return jobs.Permanent(err)
+			}
+			return err
+		}),
+	})
+	if err != nil {
+		return &pipelineError{http.StatusServiceUnavailable, codeUnavailable, "Processing queue is unavailable", err}
+	}
+	if err := job.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			job.Cancel()
+		}
+		return err
+	}
+	return nil
+}
+
+// deadLetterUpload records an upload whose processing failed with a job that
+// ran out of retries, and keeps its raw file for a re-drive. Other failures
+// aren't for retrying, and are left alone. The file keeps its name, which an
+// encrypted upload's keystream is derived from.
+func (cfg *apiConfig) deadLetterUpload(ctx context.Context, video database.Video, failure error, tempFilePath, mediaType, filename string, meta upload.Metadata) {
+	var exhausted *jobs.RetriesExhaustedError
+	if !errors.As(failure, &exhausted) {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	params := database.CreateDeadLetterParams{
+		VideoID:   video.ID,
+		UserID:    video.UserID,
+		JobKind:   exhausted.Kind,
+		Attempts:  exhausted.Attempts,
+		Error:     exhausted.Err.Error(),
+		MediaType: mediaType,
+		Filename:  filename,
+	}
+	// ffmpeg's stderr gets its own field instead of swamping the error:
+	var ffmpegErr *transcode.FFmpegError
+	if errors.As(exhausted.Err, &ffmpegErr) {
+		stderr := ffmpegErr.Stderr
+		if len(stderr) > maxDeadLetterStderr {
+			stderr = stderr[len(stderr)-maxDeadLetterStderr:]
+		}
+		params.FFmpegStderr = &stderr
+		params.Error = strings.Replace(params.Error, ffmpegErr.Error(), ffmpegErr.Err.Error(), 1)
+	}
+	if metadata, err := json.Marshal(meta); err == nil {
+		params.Metadata = string(metadata)
+	}
+
+	kept := filepath.Join(cfg.deadLetterPath(), filepath.Base(tempFilePath))
+	if err := os.MkdirAll(cfg.deadLetterPath(), 0700); err != nil {
+		log.Printf("Couldn't create dead letter directory: %v", err)
+	} else if err := os.Rename(tempFilePath, kept); err != nil {
+		log.Printf("Couldn't keep the upload of video %s for a re-drive: %v", video.ID, err)
+	} else {
+		params.InputPath = kept
+	}
+
+	letter, err := cfg.db.CreateDeadLetter(ctx, params)
+	if err != nil {
+		log.Printf("Couldn't dead-letter the upload of video %s: %v", video.ID, err)
+		if params.InputPath != "" {
+			os.Remove(params.InputPath)
+		}
+		return
+	}
+	log.Printf("Dead-lettered the upload of video %s as %s after %d failed %s attempts", video.ID, letter.ID, exhausted.Attempts, exhausted.Kind)
+}
+
+// redriveUpload runs a dead-lettered upload through the pipeline again. It's
+// removed afterwards, unless it was dead-lettered once more.
+func (cfg *apiConfig) redriveUpload(letter database.DeadLetter, video database.Video, meta upload.Metadata) {
+	_, err := cfg.uploads().Run(context.Background(), video, upload.Staged{
+		Path:      letter.InputPath,
+		MediaType: letter.MediaType,
+		Filename:  letter.Filename,
+		Metadata:  meta,
+	})
+	if !errors.As(err, new(*jobs.RetriesExhaustedError)) {
+		os.Remove(letter.InputPath)
+	}
+	if err != nil {
+		log.Printf("Re-drive of dead letter %s (video %s) failed: %v", letter.ID, video.ID, err)
+		return
+	}
+	log.Printf("Re-drove dead letter %s, video %s is processed", letter.ID, video.ID)
+}
+
+type deadLetterResponse struct {
+	database.DeadLetter
+	// Redrivable is false when the upload couldn't be kept:
+	Redrivable bool `json:"redrivable"`
+}
+
+func (cfg *apiConfig) handlerAdminDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	letters, err := cfg.db.GetDeadLetters(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list dead letters", err)
+		return
+	}
+	resp := make([]deadLetterResponse, 0, len(letters))
+	for _, letter := range letters {
+		resp = append(resp, deadLetterResponse{letter, letter.InputPath != ""})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerAdminDeadLetterRedrive takes the letter off the list and processes its
+// upload again in the background; a failure that runs out of retries again
+// dead-letters it anew.
+func (cfg *apiConfig) handlerAdminDeadLetterRedrive(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	letter, ok := cfg.deadLetterFromPath(w, r)
+	if !ok {
+		return
+	}
+	if letter.InputPath == "" {
+		respondWithCode(w, http.StatusConflict, codeConflict, "The upload wasn't kept, it has to be uploaded again", nil)
+		return
+	}
+	var meta upload.Metadata
+	if letter.Metadata != "" {
+		if err := json.Unmarshal([]byte(letter.Metadata), &meta); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't read the upload's metadata", err)
+			return
+		}
+	}
+	video, err := cfg.videos.GetVideo(r.Context(), letter.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithCode(w, http.StatusConflict, codeConflict, "The video was deleted, discard the dead letter instead", nil)
+		return
+	}
+	// Whoever deletes it re-drives it:
+	deleted, err := cfg.db.DeleteDeadLetter(r.Context(), letter.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't take dead letter", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "Dead letter not found", nil)
+		return
+	}
+	go cfg.redriveUpload(*letter, video, meta)
+	respondWithJSON(w, http.StatusAccepted, deadLetterResponse{*letter, true})
+}
+
+// handlerAdminDeadLetterDiscard deletes the letter and its kept upload.
+func (cfg *apiConfig) handlerAdminDeadLetterDiscard(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	letter, ok := cfg.deadLetterFromPath(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := cfg.db.DeleteDeadLetter(r.Context(), letter.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete dead letter", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "Dead letter not found", nil)
+		return
+	}
+	if letter.InputPath != "" {
+		os.Remove(letter.InputPath)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deadLetterFromPath looks up the {deadLetterID} path value, responding itself
+// when that fails:
+func (cfg *apiConfig) deadLetterFromPath(w http.ResponseWriter, r *http.Request) (*database.DeadLetter, bool) {
+	id, err := uuid.Parse(r.PathValue("deadLetterID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid dead letter ID", err)
+		return nil, false
+	}
+	letter, err := cfg.db.GetDeadLetter(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get dead letter", err)
+		return nil, false
+	}
+	if letter == nil {
+		respondWithError(w, http.StatusNotFound, "Dead letter not found", nil)
+		return nil, false
+	}
+	return letter, true
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Decode check modes (DECODE_CHECK). ffprobe only reads headers, so a file cut
+// off halfway, or with garbage in its streams, still probes fine and would be
+// transcoded and stored. The check decodes it with ffmpeg first: all of it with
+// "full", or the first and last decodeSampleLength of it with "sample", which is
+// where truncation and most upload damage shows.
+const (
+	decodeCheckOff    = "off"
+	decodeCheckSample = "sample"
+	decodeCheckFull   = "full"
+)
+
+// decodeSampleLength is how much of each end of the file "sample" decodes:
+const decodeSampleLength = 30 * time.Second
+
+// decodeCheckMaxErrors is how much of ffmpeg's error output makes it into logs:
+const decodeCheckMaxErrors = 4 << 10
+
+// decodeCheckConfig is config.DecodeCheck:
+type decodeCheckConfig struct {
+	// Mode is one of the decodeCheck* modes:
+	Mode string
+	// Strict (DECODE_CHECK_STRICT) rejects files ffmpeg reports any decoding
+	// error for. Without it only files ffmpeg can't get through at all are,
+	// for libraries with old, slightly broken but playable files.
+	Strict bool
+	// Timeout (DECODE_CHECK_TIMEOUT) bounds the check. A check that runs out of
+	// time lets the file through; it's the server that was slow, not the file
+	// that's broken.
+	Timeout time.Duration
+}
+
+// checkDecodable rejects the upload at source (a path or URL) with a 422 if it
+// doesn't decode cleanly. duration is the probed length of the media.
+func (cfg *apiConfig) checkDecodable(ctx context.Context, source string, duration time.Duration) error {
+	check := cfg.live().DecodeCheck
+	if check.Mode == decodeCheckOff {
+		return nil
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	passes := [][]string{nil}
+	if check.Mode == decodeCheckSample && duration > 2*decodeSampleLength {
+		// The start, then the end, seeking there on the input:
+		start := strconv.FormatFloat(decodeSampleLength.Seconds(), 'f', -1, 64)
+		end := strconv.FormatFloat((duration - decodeSampleLength).Seconds(), 'f', -1, 64)
+		passes = [][]string{{"-t", start}, {"-ss", end}}
+	}
+	for _, pass := range passes {
+		err := cfg.prober.Decode(checkCtx, source, pass, check.Strict)
+		if err == nil {
+			continue
+		}
+		if checkCtx.Err() != nil && ctx.Err() == nil {
+			log.Printf("Decode check of %s didn't finish in %s, letting it through", source, check.Timeout)
+			return nil
+		}
+		return &pipelineError{http.StatusUnprocessableEntity, codeCorruptMedia, "The file is corrupt or truncated", err}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Request bodies may be compressed with Content-Encoding gzip or zstd, so
+// clients on slow links can shrink what they send. Only JSON and CSV bodies
+// and thumbnail uploads are decompressed; videos are compressed already.
+//
+// A small compressed body can expand to gigabytes (a "zip bomb"), so the
+// decompressed size is capped: past the cap the handler's read fails with an
+// *http.MaxBytesError and the connection is closed.
+const acceptedRequestEncodings = "gzip, zstd"
+
+// decompressJSON decompresses JSON (and CSV, see handlerVideosBulkImport)
+// request bodies, up to maxDecompressedBody (MAX_DECOMPRESSED_BODY) once
+// decompressed. Other bodies pass through as they are.
+func (cfg *apiConfig) decompressJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if (mediaType == "application/json" || mediaType == "text/csv") && !decompressBody(w, r, cfg.live().MaxDecompressedBody) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decompressThumbnail decompresses a thumbnail upload, up to the thumbnail
+// upload limit. It goes inside uploadDeadlines, which should see the bytes as
+// they arrive.
+func (cfg *apiConfig) decompressThumbnail(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if decompressBody(w, r, cfg.live().ThumbnailUploadLimit) {
+			next(w, r)
+		}
+	}
+}
+
+// decompressBody swaps a compressed r.Body for its decompressed contents,
+// limited to limit bytes. Bodies without a Content-Encoding are left alone. It
+// responds and returns false when the encoding is unsupported or the body isn't
+// what it claims to be.
+func decompressBody(w http.ResponseWriter, r *http.Request, limit int64) bool {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	var body io.ReadCloser
+	switch encoding {
+	case "", "identity":
+		return true
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid gzip body", err)
+			return false
+		}
+		body = &decompressedBody{Reader: zr, closeDecoder: func() { zr.Close() }, raw: r.Body}
+	case "zstd":
+		// Bound the decoder's own buffers too, not just what it returns:
+		zr, err := zstd.NewReader(r.Body,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true),
+			zstd.WithDecoderMaxMemory(uint64(limit)),
+		)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid zstd body", err)
+			return false
+		}
+		body = &decompressedBody{Reader: zr, closeDecoder: zr.Close, raw: r.Body}
+	default:
+		// RFC 7694: tell the client which encodings it can use instead.
+		w.Header().Set("Accept-Encoding", acceptedRequestEncodings)
+		respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported Content-Encoding "+encoding, nil)
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, body, limit)
+	// The handler sees the decompressed body, whose length isn't known:
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return true
+}
+
+// decompressedBody closes both the decoder and the request body under it:
+type decompressedBody struct {
+	io.Reader
+	closeDecoder func()
+	raw          io.ReadCloser
+}
+
+func (b *decompressedBody) Close() error {
+	b.closeDecoder()
+	return b.raw.Close()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+// The handlers reach what's outside the process through these interfaces, so
+// they can be tested without ffmpeg, S3 or a populated database: see the fakes
+// in fakes_test.go. main wires in the real ones.
+
+// VideoStore is the video records: database.Client, or a fake. Queries about
+// everything else (users, jobs, stats) still go to cfg.db.
+type VideoStore interface {
+	CreateVideo(ctx context.Context, params database.CreateVideoParams) (database.Video, error)
+	GetVideo(ctx context.Context, id uuid.UUID) (database.Video, error)
+	GetVideos(ctx context.Context, userID uuid.UUID) ([]database.Video, error)
+	UpdateVideo(ctx context.Context, video database.Video) error
+	DeleteVideo(ctx context.Context, id uuid.UUID) error
+	SetVideoStatus(ctx context.Context, id uuid.UUID, status string) error
+	SettleVideoStatus(ctx context.Context, id uuid.UUID, from, fallback string) error
+}
+
+// ObjectStore is where processed media is kept: S3, a local directory, or a fake.
+type ObjectStore = storage.Store
+
+// Prober inspects media files. source is a path or a URL ffmpeg can read.
+type Prober interface {
+	// Probe returns ffprobe's JSON description of source: its format, streams and
+	// chapters.
+	Probe(ctx context.Context, source string) ([]byte, error)
+	// Decode decodes source, after the input options in opts, to nowhere. With
+	// strict, anything the decoder complains about is an error.
+	Decode(ctx context.Context, source string, opts []string, strict bool) error
+}
+
+// Transcoder writes new media files from uploaded ones.
+type Transcoder interface {
+	// Transcode runs task on source, returning the path of the output file,
+	// which the caller removes.
+	Transcode(ctx context.Context, task transcode.Task, source string, onProgress transcode.ProgressFunc) (string, error)
+	// ExtractFrame writes the frame of source at timestamp to output as a JPEG.
+	ExtractFrame(ctx context.Context, source string, timestamp time.Duration, output string) error
+	// ExtractThumbnail writes a representative frame of source to output as a
+	// JPEG, see autoThumbnailFilter.
+	ExtractThumbnail(ctx context.Context, source, output string) error
+	// ExtractClip writes the part of source from start to end to output as a
+	// fast-start MP4.
+	ExtractClip(ctx context.Context, source string, start, end time.Duration, output string) error
+	// ReplaceAudio writes source to output as a fast-start MP4 with the audio
+	// of audio instead of its own, or with no audio when audio is "".
+	ReplaceAudio(ctx context.Context, source, audio, output string) error
+}
+
+var (
+	_ VideoStore = database.Client{}
+	_ Prober     = ffmpegProber{}
+	_ Transcoder = ffmpegTranscoder{}
+)
+
+// ffmpegProber runs ffprobe and ffmpeg from PATH:
+type ffmpegProber struct{}
+
+func (ffmpegProber) Probe(ctx context.Context, source string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		"-show_chapters",
+		source,
+	)
+	stdout := bufpool.GetBuffer()
+	defer bufpool.PutBuffer(stdout)
+	cmd.Stdout = stdout
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe error: %v", err)
+	}
+	// The buffer goes back to the pool, the probe outlives it:
+	probe := bytes.Clone(stdout.Bytes())
+	if !json.Valid(probe) {
+		return nil, fmt.Errorf("could not parse ffprobe output")
+	}
+	return probe, nil
+}
+
+func (ffmpegProber) Decode(ctx context.Context, source string, opts []string, strict bool) error {
+	args := []string{"-nostdin", "-v", "error"}
+	if strict {
+		// Stop at the first error instead of concealing it and carrying on:
+		args = append(args, "-xerror")
+	}
+	args = append(args, opts...)
+	args = append(args, "-i", source, "-f", "null", "-")
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stderr := bufpool.GetBuffer()
+	defer bufpool.PutBuffer(stderr)
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	output := strings.TrimSpace(stderr.String())
+	if len(output) > decodeCheckMaxErrors {
+		output = output[:decodeCheckMaxErrors]
+	}
+	if err != nil {
+		return fmt.Errorf("ffmpeg decode: %v: %s", err, output)
+	}
+	// -xerror doesn't catch everything the demuxer complains about:
+	if strict && output != "" {
+		return errors.New("ffmpeg decode: " + output)
+	}
+	return nil
+}
+
+// ffmpegTranscoder runs ffmpeg from PATH, through internal/transcode:
+type ffmpegTranscoder struct{}
+
+func (ffmpegTranscoder) Transcode(ctx context.Context, task transcode.Task, source string, onProgress transcode.ProgressFunc) (string, error) {
+	return transcode.Run(ctx, task, source, onProgress)
+}
+
+// ExtractFrame seeks with -ss before -i, which is fast and, for URLs, only
+// fetches what's needed.
+func (ffmpegTranscoder) ExtractFrame(ctx context.Context, source string, timestamp time.Duration, output string) error {
+	err := transcode.RunFFmpeg(ctx, source, nil,
+		"-y",
+		"-ss", strconv.FormatFloat(timestamp.Seconds(), 'f', 3, 64),
+		"-i", source,
+		"-frames:v", "1",
+		"-q:v", "2",
+		output,
+	)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(output)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return errors.New("ffmpeg produced an empty image")
+	}
+	return nil
+}
+
+func (ffmpegTranscoder) ExtractClip(ctx context.Context, source string, start, end time.Duration, output string) error {
+	return transcode.Clip(ctx, source, start, end, output)
+}
+
+func (ffmpegTranscoder) ReplaceAudio(ctx context.Context, source, audio, output string) error {
+	return transcode.ReplaceAudio(ctx, source, audio, output)
+}
+
+// ExtractThumbnail takes the first representative non-black frame; a video that
+// is black throughout gets its first frame instead.
+func (t ffmpegTranscoder) ExtractThumbnail(ctx context.Context, source, output string) error {
+	err := transcode.RunFFmpeg(ctx, source, nil,
+		"-y",
+		"-i", source,
+		"-vf", autoThumbnailFilter,
+		"-frames:v", "1",
+		"-q:v", "2",
+		output,
+	)
+	if err == nil {
+		if info, statErr := os.Stat(output); statErr == nil && info.Size() > 0 {
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return t.ExtractFrame(ctx, source, 0, output)
+}
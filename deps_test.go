@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/flags"
+	"github.com/google/uuid"
+)
+
+// testSettings are the defaults, with only the required settings given:
+func testSettings(t *testing.T) *liveSettings {
+	t.Helper()
+	env := map[string]string{
+		"DB_PATH":         "unused",
+		"JWT_SECRET":      "unused",
+		"PLATFORM":        "dev",
+		"FILEPATH_ROOT":   "unused",
+		"ASSETS_ROOT":     "unused",
+		"PORT":            "8091",
+		"STORAGE_BACKEND": "local",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	conf, err := config.LoadFrom(lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	featureFlags, err := flags.LoadFrom("", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newLiveSettings(conf, featureFlags)
+}
+
+// testDB is an empty database in a temp directory:
+func testDB(t *testing.T) database.Client {
+	t.Helper()
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"), database.PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// testUser creates a user and returns their ID and an access token:
+func testUser(t *testing.T, db database.Client, tokens *auth.KeySet) (uuid.UUID, string) {
+	t.Helper()
+	user, err := db.CreateUser(context.Background(), database.CreateUserParams{
+		Email:    uuid.NewString() + "@example.com",
+		Password: "unused",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := tokens.MakeJWT(user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID, token
+}
+
+func testKeySet(t *testing.T) *auth.KeySet {
+	t.Helper()
+	tokens, err := auth.NewKeySet("test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// newFakeUploadHandlers is newUploadHandlers with fakes and temp dirs for
+// whatever d leaves unset; d.DB and d.Tokens are the caller's. The queue is
+// shut down when the test ends.
+func newFakeUploadHandlers(t *testing.T, d uploadDeps) *apiConfig {
+	t.Helper()
+	if d.Videos == nil {
+		d.Videos = d.DB
+	}
+	if d.Objects == nil {
+		d.Objects = newFakeObjectStore()
+	}
+	if d.Prober == nil {
+		d.Prober = &fakeProber{}
+	}
+	if d.Transcoder == nil {
+		d.Transcoder = &fakeTranscoder{}
+	}
+	if d.Settings == nil {
+		d.Settings = testSettings(t)
+	}
+	if d.TmpDir == "" {
+		d.TmpDir = t.TempDir()
+	}
+	if d.AssetsRoot == "" {
+		d.AssetsRoot = t.TempDir()
+	}
+	if d.DiskHighWater == 0 {
+		// Whatever else is on the test machine's disk:
+		d.DiskHighWater = 100
+	}
+	cfg := newUploadHandlers(d)
+	t.Cleanup(cfg.jobs.Shutdown)
+	return cfg
+}
+
+// videoUploadRequest is the upload of contents as boots.mp4 to the video, as
+// the user token belongs to.
+func videoUploadRequest(t *testing.T, videoID uuid.UUID, token string, contents []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="video"; filename="boots.mp4"`)
+	header.Set("Content-Type", "video/mp4")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(contents)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+videoID.String(), &body)
+	req.SetPathValue("videoID", videoID.String())
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestUploadVideoWithFakes(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	tokens := testKeySet(t)
+	userID, token := testUser(t, db, tokens)
+	video, err := db.CreateVideo(ctx, database.CreateVideoParams{Title: "Boots", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := newFakeObjectStore()
+	transcoder := &fakeTranscoder{}
+	cfg := newFakeUploadHandlers(t, uploadDeps{DB: db, Tokens: tokens, Objects: objects, Transcoder: transcoder})
+
+	contents := []byte("not really an mp4, but nothing here decodes it")
+	rec := httptest.NewRecorder()
+	cfg.handlerUploadVideo(rec, videoUploadRequest(t, video.ID, token, contents))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var got database.Video
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != database.StatusReady {
+		t.Errorf("status = %q, want %q", got.Status, database.StatusReady)
+	}
+	stored := objects.keys()
+	if len(stored) != 1 || !strings.HasPrefix(stored[0], "landscape/") {
+		t.Fatalf("stored objects = %v, want one under landscape/", stored)
+	}
+	if got.VideoURL == nil || *got.VideoURL != objects.URL(stored[0]) {
+		t.Errorf("video_url = %v, want %s", got.VideoURL, objects.URL(stored[0]))
+	}
+	if !bytes.Equal(objects.objects[stored[0]], contents) {
+		t.Error("stored object isn't the upload")
+	}
+	if tasks := transcoder.ran(); len(tasks) != 1 {
+		t.Errorf("transcoder ran %d tasks, want 1", len(tasks))
+	}
+}
+
+func TestUploadVideoDecodeFailure(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	tokens := testKeySet(t)
+	userID, token := testUser(t, db, tokens)
+	video, err := db.CreateVideo(ctx, database.CreateVideoParams{Title: "Broken", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := newFakeObjectStore()
+	transcoder := &fakeTranscoder{}
+	cfg := newFakeUploadHandlers(t, uploadDeps{DB: db, Tokens: tokens, Objects: objects, Prober: &fakeProber{DecodeErr: errFake}, Transcoder: transcoder})
+
+	rec := httptest.NewRecorder()
+	cfg.handlerUploadVideo(rec, videoUploadRequest(t, video.ID, token, []byte("truncated")))
+
+	if rec.Code < 400 {
+		t.Fatalf("status = %d, want an error", rec.Code)
+	}
+	if len(objects.keys()) != 0 || len(transcoder.ran()) != 0 {
+		t.Error("an undecodable upload was transcoded or stored")
+	}
+	video, err = db.GetVideo(ctx, video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Status != database.StatusFailed {
+		t.Errorf("status = %q, want %q", video.Status, database.StatusFailed)
+	}
+}
+
+func TestUploadTransferCap(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	tokens := testKeySet(t)
+	userID, token := testUser(t, db, tokens)
+
+	contents := []byte("not really an mp4, but nothing here decodes it")
+	settings := testSettings(t)
+	settings.TierLimits[database.TierFree] = tierLimit{MonthlyTransfer: int64(len(contents)) + 1}
+	cfg := newFakeUploadHandlers(t, uploadDeps{DB: db, Tokens: tokens, Settings: settings})
+
+	upload := func() *httptest.ResponseRecorder {
+		video, err := db.CreateVideo(ctx, database.CreateVideoParams{Title: "Boots", UserID: userID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		cfg.handlerUploadVideo(rec, videoUploadRequest(t, video.ID, token, contents))
+		return rec
+	}
+
+	if rec := upload(); rec.Code != http.StatusOK {
+		t.Fatalf("first upload: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/users/me/usage", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.handlerUsage(rec, req)
+	var usage struct {
+		Transfer struct {
+			BytesIn    int64 `json:"bytes_in"`
+			LimitBytes int64 `json:"limit_bytes"`
+		} `json:"transfer"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.Transfer.BytesIn != int64(len(contents)) || usage.Transfer.LimitBytes != int64(len(contents))+1 {
+		t.Errorf("transfer = %+v, want %d bytes in of %d", usage.Transfer, len(contents), len(contents)+1)
+	}
+
+	// The second puts the month over the cap:
+	if rec := upload(); rec.Code != http.StatusPaymentRequired || !strings.Contains(rec.Body.String(), string(codeTransferQuota)) {
+		t.Errorf("second upload: status = %d, want 402 %s: %s", rec.Code, codeTransferQuota, rec.Body)
+	}
+}
+
+func TestVideoHandlersWithFakeStore(t *testing.T) {
+	db := testDB(t)
+	tokens := testKeySet(t)
+	userID, token := testUser(t, db, tokens)
+	videos := newFakeVideoStore()
+	cfg := newVideoHandlers(videoDeps{
+		DB:       db,
+		Videos:   videos,
+		Objects:  newFakeObjectStore(),
+		Tokens:   tokens,
+		Settings: testSettings(t),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/videos", strings.NewReader(`{"title": "Boots", "visibility": "private"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.handlerVideoMetaCreate(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/videos", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	cfg.handlerVideosRetrieve(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var listed []database.Video
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Title != "Boots" || listed[0].Visibility != database.VisibilityPrivate || listed[0].UserID != userID {
+		t.Errorf("listed %+v, want the private video just created", listed)
+	}
+}
+
+func TestUpdateVideoReappliesAfterConflict(t *testing.T) {
+	ctx := context.Background()
+	videos := newFakeVideoStore()
+	cfg := &apiConfig{videos: videos}
+	video, err := videos.CreateVideo(ctx, database.CreateVideoParams{Title: "Old", UserID: uuid.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another request saves a new description between our read and our write:
+	conflicts := 0
+	videos.BeforeUpdate = func(id uuid.UUID) {
+		if conflicts > 0 {
+			return
+		}
+		conflicts++
+		videos.BeforeUpdate = nil
+		other, _ := videos.GetVideo(ctx, id)
+		other.Description = "From elsewhere"
+		if err := videos.UpdateVideo(ctx, other); err != nil {
+			t.Error(err)
+		}
+	}
+	saved, err := cfg.updateVideo(ctx, video, func(v *database.Video) { v.Title = "New" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Title != "New" || saved.Description != "From elsewhere" {
+		t.Errorf("saved %q / %q, want both writes", saved.Title, saved.Description)
+	}
+	if stored, _ := videos.GetVideo(ctx, video.ID); stored.Version != saved.Version {
+		t.Errorf("returned version %d, stored %d", saved.Version, stored.Version)
+	}
+
+	// A video deleted in between is gone, not a conflict:
+	videos.BeforeUpdate = func(id uuid.UUID) {
+		videos.BeforeUpdate = nil
+		videos.DeleteVideo(ctx, id)
+	}
+	if _, err := cfg.updateVideo(ctx, saved, func(v *database.Video) { v.Title = "Newer" }); !errors.Is(err, errVideoGone) {
+		t.Errorf("err = %v, want errVideoGone", err)
+	}
+}
+
+func TestBeginVideoStatus(t *testing.T) {
+	ctx := context.Background()
+	videos := newFakeVideoStore()
+	cfg := &apiConfig{videos: videos}
+	video, err := videos.CreateVideo(ctx, database.CreateVideoParams{Title: "Draft", UserID: uuid.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settle, err := cfg.beginVideoStatus(ctx, video.ID, database.StatusUploading, database.StatusDraft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := videos.GetVideo(ctx, video.ID); v.Status != database.StatusUploading {
+		t.Errorf("status = %q, want uploading", v.Status)
+	}
+	settle()
+	if v, _ := videos.GetVideo(ctx, video.ID); v.Status != database.StatusDraft {
+		t.Errorf("after settle, status = %q, want draft", v.Status)
+	}
+
+	if err := videos.SetVideoStatus(ctx, video.ID, database.StatusArchived); err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.beginVideoStatus(ctx, video.ID, database.StatusUploading, database.StatusDraft)
+	if pe := videoStatusError(err); err == nil || pe.status != http.StatusConflict {
+		t.Errorf("archived video: err = %v, want a 409", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errInsufficientStorage means an upload wouldn't fit in the temp directory, or
+// the disk is past its high-water mark:
+var errInsufficientStorage = errors.New("insufficient storage for upload")
+
+// diskSpaceHeadroom is left free on top of the upload itself, so one big upload
+// can't take the last bytes the rest of the system needs:
+const diskSpaceHeadroom = 512 << 20
+
+// diskReliefInterval spaces out the cleanups uploads turned away for a full
+// disk set off; one run at a time is plenty:
+const diskReliefInterval = time.Minute
+
+// diskReliefAt is when the last cleanup was set off, in Unix seconds:
+var diskReliefAt atomic.Int64
+
+// diskUsage is how full the filesystem holding a directory is:
+type diskUsage struct {
+	Path           string  `json:"path"`
+	TotalBytes     int64   `json:"total_bytes"`
+	AvailableBytes int64   `json:"available_bytes"`
+	UsedPercent    float64 `json:"used_percent"`
+	// OverHighWater means uploads are turned away until it's cleaned up:
+	OverHighWater bool `json:"over_high_water"`
+}
+
+// diskUsage reports on the directories uploads fill: the temp directory raw
+// uploads wait in, and the assets directory.
+func (cfg *apiConfig) diskUsage() ([]diskUsage, error) {
+	var usage []diskUsage
+	for _, dir := range []string{cfg.uploadTmpDir, cfg.assetsRoot} {
+		total, available, err := diskSpace(dir)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't check free space in %s: %w", dir, err)
+		}
+		u := diskUsage{Path: dir, TotalBytes: total, AvailableBytes: available}
+		if total > 0 {
+			u.UsedPercent = 100 * float64(total-available) / float64(total)
+			u.OverHighWater = u.UsedPercent >= float64(cfg.diskHighWater)
+		}
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
+
+// checkDiskPressure turns uploads away while a directory they fill is past
+// DISK_HIGH_WATER_PERCENT, and sets off a cleanup to bring it back down,
+// rather than letting them fail halfway with a write error.
+func (cfg *apiConfig) checkDiskPressure() error {
+	usage, err := cfg.diskUsage()
+	if err != nil {
+		return err
+	}
+	for _, u := range usage {
+		if u.OverHighWater {
+			cfg.relieveDiskPressure()
+			return fmt.Errorf("%w: %s is %.1f%% full", errInsufficientStorage, u.Path, u.UsedPercent)
+		}
+	}
+	return nil
+}
+
+// withDiskHeadroom turns a request away while the disk is past its high-water
+// mark, see checkDiskPressure. It's for routes that write files without an
+// upload's declared size to check, like edits of stored videos.
+func (cfg *apiConfig) withDiskHeadroom(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := cfg.checkDiskPressure(); err != nil {
+			respondWithSpaceError(w, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// relieveDiskPressure queues an asset GC run and sweeps expired resumable
+// uploads now, instead of when they're next due, at most once a
+// diskReliefInterval.
+func (cfg *apiConfig) relieveDiskPressure() {
+	now := time.Now().Unix()
+	last := diskReliefAt.Load()
+	if now-last < int64(diskReliefInterval.Seconds()) || !diskReliefAt.CompareAndSwap(last, now) {
+		return
+	}
+	log.Printf("Disk usage is past %d%%, cleaning up", cfg.diskHighWater)
+	if _, err := cfg.submitAssetGC(context.Background(), uuid.Nil); err != nil {
+		log.Printf("Couldn't queue asset GC: %v", err)
+	}
+	if cfg.uploadTTL > 0 {
+		go cfg.expireUploads(context.Background())
+	}
+}
+
+// checkUploadSpace rejects an upload up front when the temp directory can't hold
+// it, or the disk is under pressure. need is the declared body size (multiplied
+// by however many copies the caller makes); unknown sizes (-1) pass, as far as
+// the size goes, MaxBytesReader still bounds them.
+func (cfg *apiConfig) checkUploadSpace(need int64) error {
+	if err := cfg.checkDiskPressure(); err != nil {
+		return err
+	}
+	if need < 0 {
+		return nil
+	}
+	_, available, err := diskSpace(cfg.uploadTmpDir)
+	if err != nil {
+		return fmt.Errorf("couldn't check free space in %s: %w", cfg.uploadTmpDir, err)
+	}
+	if need+diskSpaceHeadroom > available {
+		return fmt.Errorf("%w: need %d bytes, %d available", errInsufficientStorage, need, available)
+	}
+	return nil
+}
+
+// respondWithSpaceError turns a checkUploadSpace failure into a response:
+func respondWithSpaceError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInsufficientStorage) {
+		respondWithError(w, http.StatusInsufficientStorage, "Not enough temporary storage for this upload, try again later", err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Couldn't check temporary storage", err)
+}
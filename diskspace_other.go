@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "math"
+
+// diskSpace has no portable implementation here, so the preflight check always
+// passes, usage is never over the high-water mark, and a full disk surfaces as
+// a write error instead.
+func diskSpace(dir string) (total, available int64, err error) {
+	return 0, math.MaxInt64, nil
+}
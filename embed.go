@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// embedDefaultWidth is the oEmbed player width when the consumer doesn't ask
+// for a smaller one; players keep a 16:9 frame:
+const embedDefaultWidth = 640
+
+// embedPage is the player served at /embed/{videoID}, for iframes. The oEmbed
+// discovery link lets unfurlers that only have the page's URL find the rest.
+var embedPage = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<meta property="og:type" content="video.other">
+<meta property="og:title" content="{{.Title}}">
+{{if .Description}}<meta property="og:description" content="{{.Description}}">
+{{end}}{{if .ThumbnailURL}}<meta property="og:image" content="{{.ThumbnailURL}}">
+{{end}}<meta property="og:video" content="{{.MediaURL}}">
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbedURL}}" title="{{.Title}}">
+<style>
+html, body { margin: 0; height: 100%; background: #000; }
+video, audio { display: block; width: 100%; height: 100%; }
+</style>
+</head>
+<body>
+{{if .Audio}}<audio src="{{.MediaURL}}" controls preload="metadata"></audio>
+{{else}}<video src="{{.MediaURL}}"{{if .ThumbnailURL}} poster="{{.ThumbnailURL}}"{{end}} controls playsinline preload="metadata"></video>
+{{end}}</body>
+</html>
+`))
+
+// embeddableVideo returns the video if anyone may embed it: it exists, has
+// media, and passed moderation. Public videos are unlisted, reachable by anyone
+// who has their ID; private ones need a share link's ?token= on the request.
+func (cfg *apiConfig) embeddableVideo(r *http.Request, videoID uuid.UUID) (database.Video, bool, error) {
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		return database.Video{}, false, err
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil || video.ModerationStatus != database.ModerationApproved {
+		return database.Video{}, false, nil
+	}
+	if video.Visibility == database.VisibilityPrivate && !cfg.hasShareLink(r, video) {
+		return database.Video{}, false, nil
+	}
+	return cfg.withReplicaFallback(r.Context(), video), true, nil
+}
+
+// handlerEmbed serves the minimal player page for iframes on other sites.
+func (cfg *apiConfig) handlerEmbed(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	video, ok, err := cfg.embeddableVideo(r, videoID)
+	if err != nil {
+		log.Printf("Couldn't get video %s for embedding: %v", videoID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		Title        string
+		Description  string
+		ThumbnailURL string
+		MediaURL     string
+		Audio        bool
+		OEmbedURL    string
+	}{
+		Title:       video.Title,
+		Description: video.Description,
+		MediaURL:    *video.VideoURL,
+		Audio:       video.MediaKind == mediaKindAudio,
+		OEmbedURL:   cfg.publicBaseURL + "/api/oembed?" + url.Values{"url": {cfg.embedURL(videoID)}}.Encode(),
+	}
+	if video.ThumbnailURL != nil {
+		data.ThumbnailURL = *video.ThumbnailURL
+	}
+
+	var buf bytes.Buffer
+	if err := embedPage.Execute(&buf, data); err != nil {
+		log.Printf("Couldn't render embed page of video %s: %v", videoID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// The page exists to be framed, by anyone:
+	w.Header().Set("Content-Security-Policy", "frame-ancestors *")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(buf.Bytes())
+}
+
+// embedURL is the player page of a video:
+func (cfg *apiConfig) embedURL(videoID uuid.UUID) string {
+	return cfg.publicBaseURL + "/embed/" + videoID.String()
+}
+
+// handlerOEmbed implements the oEmbed provider endpoint
+// (https://oembed.com/#section2): GET /api/oembed?url=<video or embed URL>
+// describes the video as a "video" type with an iframe of the embed page. The
+// url may be an embed page or any other of our URLs ending in the video ID.
+func (cfg *apiConfig) handlerOEmbed(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Version      string `json:"version"`
+		Type         string `json:"type"`
+		Title        string `json:"title"`
+		ProviderName string `json:"provider_name"`
+		ProviderURL  string `json:"provider_url"`
+		HTML         string `json:"html"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		CacheAge     int    `json:"cache_age"`
+	}
+
+	query := r.URL.Query()
+	// Only JSON is offered; the spec asks for a 501 for other formats:
+	if format := query.Get("format"); format != "" && format != "json" {
+		respondWithError(w, http.StatusNotImplemented, "Only the json format is supported", nil)
+		return
+	}
+	videoID, ok := cfg.videoIDFromURL(query.Get("url"))
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Not a video URL", nil)
+		return
+	}
+	width, height, err := embedSize(query.Get("maxwidth"), query.Get("maxheight"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid maxwidth or maxheight", err)
+		return
+	}
+
+	video, ok, err := cfg.embeddableVideo(r, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	html := fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" frameborder="0" allow="autoplay; fullscreen; picture-in-picture" allowfullscreen title="%s"></iframe>`,
+		template.HTMLEscapeString(cfg.embedURL(videoID)), width, height, template.HTMLEscapeString(video.Title))
+	respondWithJSON(w, http.StatusOK, response{
+		Version:      "1.0",
+		Type:         "video",
+		Title:        video.Title,
+		ProviderName: "Tubely",
+		ProviderURL:  cfg.publicBaseURL + "/",
+		HTML:         html,
+		Width:        width,
+		Height:       height,
+		CacheAge:     3600,
+	})
+}
+
+// videoIDFromURL takes the video ID from one of our URLs, e.g.
+// https://tubely.example.com/embed/<id>. URLs on other hosts aren't ours to
+// describe.
+func (cfg *apiConfig) videoIDFromURL(rawURL string) (uuid.UUID, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return uuid.Nil, false
+	}
+	base, err := url.Parse(cfg.publicBaseURL)
+	if err != nil || !strings.EqualFold(u.Host, base.Host) {
+		return uuid.Nil, false
+	}
+	videoID, err := uuid.Parse(path.Base(u.Path))
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return videoID, true
+}
+
+// embedSize is the largest 16:9 player within the consumer's maxwidth and
+// maxheight, each optional:
+func embedSize(maxWidth, maxHeight string) (int, int, error) {
+	width := embedDefaultWidth
+	if maxWidth != "" {
+		n, err := strconv.Atoi(maxWidth)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("maxwidth %q isn't a positive integer", maxWidth)
+		}
+		width = min(width, n)
+	}
+	if maxHeight != "" {
+		n, err := strconv.Atoi(maxHeight)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("maxheight %q isn't a positive integer", maxHeight)
+		}
+		width = min(width, n*16/9)
+	}
+	width = max(width, 1)
+	return width, width * 9 / 16, nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+// Fakes of the interfaces in deps.go, for testing handlers without ffmpeg, S3
+// or, where only the video records are touched, a database.
+
+var (
+	_ VideoStore  = (*fakeVideoStore)(nil)
+	_ ObjectStore = (*fakeObjectStore)(nil)
+	_ Prober      = (*fakeProber)(nil)
+	_ Transcoder  = (*fakeTranscoder)(nil)
+)
+
+// fakeVideoStore keeps videos in memory, with the versioning and status rules
+// database.Client enforces. BeforeUpdate, if set, runs before each UpdateVideo,
+// e.g. to simulate a concurrent write.
+type fakeVideoStore struct {
+	mu           sync.Mutex
+	videos       map[uuid.UUID]database.Video
+	BeforeUpdate func(id uuid.UUID)
+}
+
+func newFakeVideoStore() *fakeVideoStore {
+	return &fakeVideoStore{videos: map[uuid.UUID]database.Video{}}
+}
+
+func (s *fakeVideoStore) CreateVideo(ctx context.Context, params database.CreateVideoParams) (database.Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	visibility := params.Visibility
+	if visibility == "" {
+		visibility = database.VisibilityPublic
+	}
+	now := time.Now().UTC()
+	video := database.Video{
+		ID:        uuid.New(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		CreateVideoParams: database.CreateVideoParams{
+			Title:       params.Title,
+			Description: params.Description,
+			UserID:      params.UserID,
+			Visibility:  visibility,
+		},
+		MediaKind:        mediaKindVideo,
+		Status:           database.StatusDraft,
+		ModerationStatus: database.ModerationApproved,
+	}
+	s.videos[video.ID] = video
+	return video, nil
+}
+
+// GetVideo returns a zero Video for a missing one, like database.Client.
+func (s *fakeVideoStore) GetVideo(ctx context.Context, id uuid.UUID) (database.Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.videos[id], nil
+}
+
+func (s *fakeVideoStore) GetVideos(ctx context.Context, userID uuid.UUID) ([]database.Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	videos := []database.Video{}
+	for _, video := range s.videos {
+		if video.UserID == userID {
+			videos = append(videos, video)
+		}
+	}
+	sort.Slice(videos, func(i, j int) bool { return videos[i].CreatedAt.After(videos[j].CreatedAt) })
+	return videos, nil
+}
+
+func (s *fakeVideoStore) UpdateVideo(ctx context.Context, video database.Video) error {
+	if s.BeforeUpdate != nil {
+		s.BeforeUpdate(video.ID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.videos[video.ID]
+	if !ok || stored.Version != video.Version {
+		return &database.ConflictError{VideoID: video.ID, Version: video.Version}
+	}
+	// Only the fields database.Client writes:
+	stored.Title = video.Title
+	stored.Description = video.Description
+	stored.ThumbnailURL = video.ThumbnailURL
+	stored.VideoURL = video.VideoURL
+	stored.MediaKind = video.MediaKind
+	stored.OriginalFilename = video.OriginalFilename
+	stored.UserID = video.UserID
+	stored.Version++
+	stored.UpdatedAt = time.Now().UTC()
+	s.videos[video.ID] = stored
+	return nil
+}
+
+func (s *fakeVideoStore) DeleteVideo(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.videos, id)
+	return nil
+}
+
+func (s *fakeVideoStore) SetVideoStatus(ctx context.Context, id uuid.UUID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	video, ok := s.videos[id]
+	if !ok {
+		return nil
+	}
+	if !database.StatusTransitionAllowed(video.Status, status) {
+		return &database.StatusTransitionError{VideoID: id, From: video.Status, To: status}
+	}
+	video.Status = status
+	s.videos[id] = video
+	return nil
+}
+
+func (s *fakeVideoStore) SettleVideoStatus(ctx context.Context, id uuid.UUID, from, fallback string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	video, ok := s.videos[id]
+	if !ok || video.Status != from {
+		return nil
+	}
+	video.Status = fallback
+	if video.VideoURL != nil {
+		video.Status = database.StatusReady
+	}
+	s.videos[id] = video
+	return nil
+}
+
+// fakeObjectStore keeps objects in memory, at https://objects.test/<key>.
+type fakeObjectStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newFakeObjectStore() *fakeObjectStore {
+	return &fakeObjectStore{objects: map[string][]byte{}, types: map[string]string{}}
+}
+
+func (s *fakeObjectStore) Put(ctx context.Context, key string, body io.Reader, opts storage.PutOptions) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	s.types[key] = opts.ContentType
+	return nil
+}
+
+func (s *fakeObjectStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *fakeObjectStore) Delete(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		delete(s.objects, key)
+		delete(s.types, key)
+	}
+	return nil
+}
+
+func (s *fakeObjectStore) List(ctx context.Context, prefix string, fn func(storage.ObjectInfo) error) error {
+	s.mu.Lock()
+	var infos []storage.ObjectInfo
+	for key, data := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, storage.ObjectInfo{Key: key, Size: int64(len(data))})
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeObjectStore) URL(key string) string {
+	return "https://objects.test/" + key
+}
+
+// keys returns the stored keys, sorted:
+func (s *fakeObjectStore) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fakeProbe is what fakeProber reports by default: ten seconds of 1080p H.264
+// with AAC audio.
+const fakeProbe = `{
+	"streams": [
+		{"index": 0, "codec_type": "video", "codec_name": "h264", "profile": "High", "pix_fmt": "yuv420p", "width": 1920, "height": 1080},
+		{"index": 1, "codec_type": "audio", "codec_name": "aac"}
+	],
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "10.000000"},
+	"chapters": []
+}`
+
+// fakeProber answers every probe with Output, fakeProbe if that's empty, and
+// fails decodes with DecodeErr.
+type fakeProber struct {
+	Output    string
+	DecodeErr error
+}
+
+func (p *fakeProber) Probe(ctx context.Context, source string) ([]byte, error) {
+	if p.Output != "" {
+		return []byte(p.Output), nil
+	}
+	return []byte(fakeProbe), nil
+}
+
+func (p *fakeProber) Decode(ctx context.Context, source string, opts []string, strict bool) error {
+	return p.DecodeErr
+}
+
+// fakeTranscoder "transcodes" by copying the input, and writes a few bytes for
+// frames. It records the tasks it was given; Err fails them all.
+type fakeTranscoder struct {
+	mu    sync.Mutex
+	tasks []transcode.Task
+	Err   error
+}
+
+func (t *fakeTranscoder) Transcode(ctx context.Context, task transcode.Task, source string, onProgress transcode.ProgressFunc) (string, error) {
+	t.mu.Lock()
+	t.tasks = append(t.tasks, task)
+	t.mu.Unlock()
+	if t.Err != nil {
+		return "", t.Err
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return "", err
+	}
+	output := source + ".processing"
+	if err := os.WriteFile(output, data, 0600); err != nil {
+		return "", err
+	}
+	if onProgress != nil {
+		onProgress(100)
+	}
+	return output, nil
+}
+
+func (t *fakeTranscoder) ExtractFrame(ctx context.Context, source string, timestamp time.Duration, output string) error {
+	if t.Err != nil {
+		return t.Err
+	}
+	return os.WriteFile(output, []byte("\xff\xd8fake jpeg\xff\xd9"), 0600)
+}
+
+func (t *fakeTranscoder) ExtractThumbnail(ctx context.Context, source, output string) error {
+	return t.ExtractFrame(ctx, source, 0, output)
+}
+
+// ExtractClip copies source whole, the fake has no way to cut it:
+func (t *fakeTranscoder) ExtractClip(ctx context.Context, source string, start, end time.Duration, output string) error {
+	if t.Err != nil {
+		return t.Err
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, data, 0600)
+}
+
+// ReplaceAudio copies source whole, the fake has no audio to swap:
+func (t *fakeTranscoder) ReplaceAudio(ctx context.Context, source, audio, output string) error {
+	if t.Err != nil {
+		return t.Err
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, data, 0600)
+}
+
+// ran returns the tasks Transcode was given:
+func (t *fakeTranscoder) ran() []transcode.Task {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]transcode.Task(nil), t.tasks...)
+}
+
+var errFake = errors.New("fake failure")
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// feedMaxItems is how many of a user's newest videos a feed lists:
+const feedMaxItems = 50
+
+// Feed formats. MRSS is the RSS feed with every rendition of each video, its
+// duration and all its thumbnail sizes, for syndicating to video platforms;
+// the plain one sticks to what podcast apps and feed readers understand.
+const (
+	feedFormatRSS  = "rss"
+	feedFormatMRSS = "mrss"
+	feedFormatJSON = "json"
+)
+
+// feedExtensions maps a feed URL's extension to its format:
+var feedExtensions = map[string]string{
+	".xml":  feedFormatRSS,
+	".rss":  feedFormatRSS,
+	".mrss": feedFormatMRSS,
+	".json": feedFormatJSON,
+}
+
+var feedContentTypes = map[string]string{
+	feedFormatRSS:  "application/rss+xml",
+	feedFormatMRSS: "application/rss+xml",
+	feedFormatJSON: "application/feed+json",
+}
+
+// feedMaxAge is how long feed readers and CDNs may cache a feed. It's kept
+// short of any signed playback URL's lifetime, see signPlaybackURLs.
+const feedMaxAge = 5 * time.Minute
+
+// RSS 2.0 (https://www.rssboard.org/rss-specification), with Media RSS
+// (https://www.rssboard.org/media-rss) for the media and the Atom self link
+// feed validators ask for. encoding/xml writes prefixed names as they are,
+// so the namespaces are declared on <rss> by hand.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	MediaNS string     `xml:"xmlns:media,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	Self          rssAtomLink `xml:"atom:link"`
+	LastBuildDate string      `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem   `xml:"item"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string           `xml:"title"`
+	Link        string           `xml:"link"`
+	Description string           `xml:"description,omitempty"`
+	GUID        rssGUID          `xml:"guid"`
+	PubDate     string           `xml:"pubDate"`
+	Categories  []string         `xml:"category"`
+	Enclosure   rssEnclosure     `xml:"enclosure"`
+	Content     *rssMediaContent `xml:"media:content"`
+	Thumbnail   *rssMediaImage   `xml:"media:thumbnail"`
+	// Group is the MRSS flavor's instead of Content and Thumbnail:
+	Group *mrssGroup `xml:"media:group"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssMediaContent struct {
+	URL       string `xml:"url,attr"`
+	Type      string `xml:"type,attr"`
+	Medium    string `xml:"medium,attr"`
+	IsDefault bool   `xml:"isDefault,attr,omitempty"`
+	FileSize  int64  `xml:"fileSize,attr,omitempty"`
+	// Duration is in whole seconds:
+	Duration int64 `xml:"duration,attr,omitempty"`
+}
+
+type rssMediaImage struct {
+	URL   string `xml:"url,attr"`
+	Width int    `xml:"width,attr,omitempty"`
+}
+
+// mrssGroup is every rendition of one video, the progressive file first:
+type mrssGroup struct {
+	Contents   []rssMediaContent `xml:"media:content"`
+	Thumbnails []rssMediaImage   `xml:"media:thumbnail"`
+	Keywords   string            `xml:"media:keywords,omitempty"`
+}
+
+// JSON Feed 1.1 (https://www.jsonfeed.org/version/1.1/):
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url"`
+	Title         string               `json:"title"`
+	ContentText   string               `json:"content_text"`
+	Image         string               `json:"image,omitempty"`
+	DatePublished time.Time            `json:"date_published"`
+	DateModified  time.Time            `json:"date_modified"`
+	Tags          []string             `json:"tags,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments"`
+}
+
+type jsonFeedAttachment struct {
+	URL               string `json:"url"`
+	MimeType          string `json:"mime_type"`
+	SizeInBytes       int64  `json:"size_in_bytes,omitempty"`
+	DurationInSeconds int64  `json:"duration_in_seconds,omitempty"`
+}
+
+// feedVideos is the user's videos a feed lists, newest first: the public,
+// approved ones that have media. Soft-deleted videos never make it this far.
+func (cfg *apiConfig) feedVideos(r *http.Request, userID uuid.UUID) ([]database.Video, error) {
+	videos, err := cfg.videos.GetVideos(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	listed := make([]database.Video, 0, min(len(videos), feedMaxItems))
+	for _, video := range videos {
+		if video.VideoURL == nil || video.ModerationStatus != database.ModerationApproved || video.Visibility != database.VisibilityPublic {
+			continue
+		}
+		listed = append(listed, cfg.withReplicaFallback(r.Context(), video))
+		if len(listed) == feedMaxItems {
+			break
+		}
+	}
+	cfg.signPlaybackURLs(r.Context(), listed)
+	return listed, nil
+}
+
+// negotiateFeedFormat picks the feed format the Accept header prefers, RSS when
+// it doesn't care, or "" when it takes none of them. MRSS has no media type of
+// its own, so it's only picked for the unofficial application/mrss+xml.
+func negotiateFeedFormat(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return feedFormatRSS
+	}
+	format, best := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var candidate string
+		switch mediaType {
+		case "application/feed+json", "application/json":
+			candidate = feedFormatJSON
+		case "application/mrss+xml":
+			candidate = feedFormatMRSS
+		case "application/rss+xml", "application/xml", "text/xml", "application/*", "text/*", "*/*":
+			candidate = feedFormatRSS
+		default:
+			continue
+		}
+		if q > best {
+			format, best = candidate, q
+		}
+	}
+	return format
+}
+
+// userFeed is what a user's feed lists, whatever its format:
+type userFeed struct {
+	userID    uuid.UUID
+	videos    []database.Video
+	sizes     map[uuid.UUID]int64
+	durations map[uuid.UUID]time.Duration
+	// lastModified is the newest change to a listed video:
+	lastModified time.Time
+}
+
+func (f userFeed) title() string {
+	return "Tubely videos of user " + f.userID.String()
+}
+
+const userFeedDescription = "Public videos and audio posts, newest first"
+
+// handlerUserFeed is a feed of a user's public videos, for podcast apps, feed
+// readers and syndication: GET /feeds/users/{userID}.xml for RSS, .mrss for
+// MRSS, .json for JSON Feed, or without an extension for whichever the Accept
+// header prefers. Each item links the video's CDN URL. The feed is cacheable for
+// feedMaxAge, and carries an ETag and Last-Modified for conditional GETs.
+func (cfg *apiConfig) handlerUserFeed(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("feed")
+	ext := path.Ext(name)
+	format := feedExtensions[ext]
+	if ext == "" {
+		w.Header().Add("Vary", "Accept")
+		format = negotiateFeedFormat(r.Header.Get("Accept"))
+		if format == "" {
+			http.Error(w, "Feeds are available as application/rss+xml or application/feed+json", http.StatusNotAcceptable)
+			return
+		}
+	}
+	if format == "" {
+		http.NotFound(w, r)
+		return
+	}
+	userID, err := uuid.Parse(strings.TrimSuffix(name, ext))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("Couldn't get user %s for their feed: %v", userID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	feed, err := cfg.loadUserFeed(r, userID)
+	if err != nil {
+		log.Printf("Couldn't get videos of user %s for their feed: %v", userID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case feedFormatJSON:
+		err = json.NewEncoder(&buf).Encode(cfg.jsonUserFeed(feed))
+	default:
+		buf.WriteString(xml.Header)
+		enc := xml.NewEncoder(&buf)
+		enc.Indent("", "  ")
+		err = enc.Encode(cfg.rssUserFeed(feed, format == feedFormatMRSS))
+	}
+	if err != nil {
+		log.Printf("Couldn't render feed of user %s: %v", userID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The ETag is of the body, signed URLs included, so a 304 never keeps a
+	// reader on URLs that have since expired:
+	sum := sha256.Sum256(buf.Bytes())
+	w.Header().Set("Content-Type", feedContentTypes[format]+"; charset=utf-8")
+	w.Header().Set("Cache-Control", cacheControl(feedMaxAge, false))
+	w.Header().Set("ETag", strconv.Quote(hex.EncodeToString(sum[:16])))
+	// ServeContent answers If-None-Match and If-Modified-Since with a 304:
+	http.ServeContent(w, r, "", feed.lastModified, bytes.NewReader(buf.Bytes()))
+}
+
+// loadUserFeed reads the videos a user's feed lists, with their sizes and
+// durations.
+func (cfg *apiConfig) loadUserFeed(r *http.Request, userID uuid.UUID) (userFeed, error) {
+	videos, err := cfg.feedVideos(r, userID)
+	if err != nil {
+		return userFeed{}, err
+	}
+	ids := make([]uuid.UUID, len(videos))
+	for i, video := range videos {
+		ids[i] = video.ID
+	}
+	sizes, err := cfg.db.VideoSizes(r.Context(), ids)
+	if err != nil {
+		return userFeed{}, err
+	}
+	durations, err := cfg.db.VideoDurations(r.Context(), ids)
+	if err != nil {
+		return userFeed{}, err
+	}
+	feed := userFeed{userID: userID, videos: videos, sizes: sizes, durations: durations}
+	for _, video := range videos {
+		if video.UpdatedAt.After(feed.lastModified) {
+			feed.lastModified = video.UpdatedAt
+		}
+	}
+	return feed, nil
+}
+
+// feedURL is the URL of a user's feed in the given format:
+func (cfg *apiConfig) feedURL(userID uuid.UUID, format string) string {
+	ext := ".xml"
+	switch format {
+	case feedFormatMRSS:
+		ext = ".mrss"
+	case feedFormatJSON:
+		ext = ".json"
+	}
+	return cfg.publicBaseURL + "/feeds/users/" + userID.String() + ext
+}
+
+func (cfg *apiConfig) rssUserFeed(feed userFeed, mrss bool) rssFeed {
+	format := feedFormatRSS
+	if mrss {
+		format = feedFormatMRSS
+	}
+	out := rssFeed{
+		Version: "2.0",
+		MediaNS: "http://search.yahoo.com/mrss/",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       feed.title(),
+			Link:        cfg.publicBaseURL + "/",
+			Description: userFeedDescription,
+			Self:        rssAtomLink{Href: cfg.feedURL(feed.userID, format), Rel: "self", Type: feedContentTypes[format]},
+			Items:       []rssItem{},
+		},
+	}
+	if !feed.lastModified.IsZero() {
+		out.Channel.LastBuildDate = feed.lastModified.UTC().Format(time.RFC1123Z)
+	}
+	for _, video := range feed.videos {
+		item := cfg.feedItem(video, feed.sizes[video.ID])
+		if mrss {
+			item.Group = mrssGroupOf(video, feed.sizes[video.ID], feed.durations[video.ID])
+			item.Content, item.Thumbnail = nil, nil
+		}
+		out.Channel.Items = append(out.Channel.Items, item)
+	}
+	return out
+}
+
+// feedMediaType is the media type and MRSS medium of a video's stored file.
+// Stored keys end in the extension of the type they were stored as.
+func feedMediaType(video database.Video) (mediaType, medium string) {
+	if video.MediaKind != mediaKindAudio {
+		return "video/mp4", "video"
+	}
+	if u, err := url.Parse(*video.VideoURL); err == nil {
+		return mediaTypeFromExt(path.Ext(u.Path)), "audio"
+	}
+	return "video/mp4", "audio"
+}
+
+// feedItem is one video of a feed; size is its stored file's, 0 if unknown.
+func (cfg *apiConfig) feedItem(video database.Video, size int64) rssItem {
+	mediaType, medium := feedMediaType(video)
+	item := rssItem{
+		Title:       video.Title,
+		Link:        cfg.embedURL(video.ID),
+		Description: video.Description,
+		GUID:        rssGUID{Value: "urn:uuid:" + video.ID.String()},
+		PubDate:     video.CreatedAt.UTC().Format(time.RFC1123Z),
+		Categories:  video.Tags,
+		Enclosure:   rssEnclosure{URL: *video.VideoURL, Length: size, Type: mediaType},
+		Content:     &rssMediaContent{URL: *video.VideoURL, Type: mediaType, Medium: medium, FileSize: size},
+	}
+	if video.ThumbnailURL != nil {
+		item.Thumbnail = &rssMediaImage{URL: *video.ThumbnailURL}
+	}
+	return item
+}
+
+// mrssGroupOf is every rendition of a video, for the MRSS feed: the stored file,
+// then its HLS and DASH manifests when it's been packaged, and the thumbnail in
+// each size it's been scaled to.
+func mrssGroupOf(video database.Video, size int64, duration time.Duration) *mrssGroup {
+	mediaType, medium := feedMediaType(video)
+	seconds := int64(duration.Round(time.Second) / time.Second)
+	group := &mrssGroup{
+		Contents: []rssMediaContent{{
+			URL: *video.VideoURL, Type: mediaType, Medium: medium, IsDefault: true, FileSize: size, Duration: seconds,
+		}},
+		Keywords: strings.Join(video.Tags, ", "),
+	}
+	if video.HLSURL != nil {
+		group.Contents = append(group.Contents, rssMediaContent{
+			URL: *video.HLSURL, Type: packageContentTypes[".m3u8"], Medium: medium, Duration: seconds,
+		})
+	}
+	if video.DashURL != nil {
+		group.Contents = append(group.Contents, rssMediaContent{
+			URL: *video.DashURL, Type: packageContentTypes[".mpd"], Medium: medium, Duration: seconds,
+		})
+	}
+	if video.ThumbnailURL != nil {
+		group.Thumbnails = append(group.Thumbnails, rssMediaImage{URL: *video.ThumbnailURL})
+	}
+	for _, width := range thumbnailWidths {
+		if u, ok := video.Thumbnails[strconv.Itoa(width)]; ok {
+			group.Thumbnails = append(group.Thumbnails, rssMediaImage{URL: u, Width: width})
+		}
+	}
+	return group
+}
+
+func (cfg *apiConfig) jsonUserFeed(feed userFeed) jsonFeed {
+	out := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.title(),
+		HomePageURL: cfg.publicBaseURL + "/",
+		FeedURL:     cfg.feedURL(feed.userID, feedFormatJSON),
+		Description: userFeedDescription,
+		Items:       []jsonFeedItem{},
+	}
+	for _, video := range feed.videos {
+		item := jsonFeedItem{
+			ID:            video.ID.String(),
+			URL:           cfg.embedURL(video.ID),
+			Title:         video.Title,
+			ContentText:   video.Description,
+			DatePublished: video.CreatedAt.UTC(),
+			DateModified:  video.UpdatedAt.UTC(),
+			Tags:          video.Tags,
+		}
+		if video.ThumbnailURL != nil {
+			item.Image = *video.ThumbnailURL
+		}
+		// Every rendition is an attachment, the stored file first:
+		for _, content := range mrssGroupOf(video, feed.sizes[video.ID], feed.durations[video.ID]).Contents {
+			item.Attachments = append(item.Attachments, jsonFeedAttachment{
+				URL:               content.URL,
+				MimeType:          content.Type,
+				SizeInBytes:       content.FileSize,
+				DurationInSeconds: content.Duration,
+			})
+		}
+		out.Items = append(out.Items, item)
+	}
+	return out
+}
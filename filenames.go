@@ -0,0 +1,47 @@
+package main
+
+import (
+	"mime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxFilenameBytes matches the usual filesystem limit for a single name:
+const maxFilenameBytes = 255
+
+// sanitizeFilename makes a client-supplied file name safe to store and to put in
+// a Content-Disposition header: any directory part (either slash style) is
+// dropped, as are control characters and quotes, and the name is cut to
+// maxFilenameBytes without splitting a character. It returns "" when nothing
+// usable is left.
+func sanitizeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	for len(name) > maxFilenameBytes {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// contentDisposition builds an inline Content-Disposition carrying the file name,
+// so browsers play the file but save it under the name it was uploaded with.
+// Non-ASCII names are encoded per RFC 2231.
+func contentDisposition(filename string) string {
+	if filename == "" {
+		return ""
+	}
+	return mime.FormatMediaType("inline", map[string]string{"filename": filename})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/flags"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// topUsersLimit is how many users the storage leaderboard returns:
+const topUsersLimit = 10
+
+// requireAdmin authenticates the request and checks the user's is_admin flag.
+// It writes the error response itself, so callers just return when ok is false.
+func (cfg *apiConfig) requireAdmin(w http.ResponseWriter, r *http.Request) (user *database.User, ok bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return nil, false
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return nil, false
+	}
+
+	user, err = cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return nil, false
+	}
+	if user == nil || !user.IsAdmin {
+		respondWithError(w, http.StatusForbidden, "Admin access required", nil)
+		return nil, false
+	}
+	return user, true
+}
+
+// handlerAdminConfig reports the effective, non-secret deployment settings:
+func (cfg *apiConfig) handlerAdminConfig(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Platform         string             `json:"platform"`
+		StorageBackend   string             `json:"storage_backend"`
+		KeyLayout        string             `json:"key_layout"`
+		S3Bucket         string             `json:"s3_bucket,omitempty"`
+		S3Region         string             `json:"s3_region,omitempty"`
+		S3CfDistribution string             `json:"s3_cf_distribution,omitempty"`
+		S3Encryption     storage.Encryption `json:"s3_encryption"`
+		CDNProvider      string             `json:"cdn_provider"`
+		EnableHLS        bool               `json:"enable_hls"`
+		EnableDASH       bool               `json:"enable_dash"`
+		S3Retention      storage.Retention  `json:"s3_retention"`
+		HLSEncryption    bool               `json:"hls_encryption"`
+		AutoThumbnails   bool               `json:"auto_thumbnails"`
+		ReplicaBucket    string             `json:"replica_bucket,omitempty"`
+		ReplicaRegion    string             `json:"replica_region,omitempty"`
+		JobBackend       string             `json:"job_backend"`
+		UploadStaging    string             `json:"upload_staging"`
+		DecodeCheck      string             `json:"decode_check"`
+		VideoEncoder     string             `json:"video_encoder"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	resp := response{
+		Platform:         cfg.platform,
+		StorageBackend:   cfg.storageBackend,
+		KeyLayout:        string(cfg.keys.Layout),
+		S3Bucket:         cfg.s3Bucket,
+		S3Region:         cfg.s3Region,
+		S3CfDistribution: cfg.s3CfDistribution,
+		CDNProvider:      cfg.cdnProvider,
+		S3Encryption:     cfg.s3Encryption,
+		S3Retention:      cfg.s3Retention,
+		EnableHLS:        cfg.live().Flags.Enabled(flags.EnableHLS),
+		EnableDASH:       cfg.live().Flags.Enabled(flags.EnableDASH),
+		HLSEncryption:    cfg.hlsKeyCipher != nil,
+		AutoThumbnails:   cfg.live().Flags.Enabled(flags.AutoThumbnails),
+		JobBackend:       "local",
+		UploadStaging:    cfg.uploadStaging,
+		DecodeCheck:      cfg.live().DecodeCheck.Mode,
+		VideoEncoder:     string(cfg.videoEncoder),
+	}
+	if cfg.replication != nil {
+		resp.ReplicaBucket = cfg.replication.Replica.Bucket
+		resp.ReplicaRegion = cfg.replication.Replica.Region
+	}
+	if cfg.remoteTranscode != nil {
+		resp.JobBackend = cfg.remoteTranscode.Backend
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerAdminFlags reports every feature flag, with where its value came from:
+func (cfg *apiConfig) handlerAdminFlags(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]any{"flags": cfg.live().Flags.All()})
+}
+
+func (cfg *apiConfig) handlerAdminStats(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		TotalVideos       int64                    `json:"total_videos"`
+		Storage           *database.StorageStats   `json:"storage"`
+		Processing        database.ProcessingStats `json:"processing"`
+		TopUsers          []database.UserStorage   `json:"top_users_by_storage"`
+		Bandwidth         database.Bandwidth       `json:"bandwidth"`
+		TopUsersBandwidth []database.UserBandwidth `json:"top_users_by_bandwidth"`
+		QueueDepths       map[string]int           `json:"queue_depths"`
+		Disks             []diskUsage              `json:"disks"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	totalVideos, err := cfg.db.CountVideos(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count videos", err)
+		return
+	}
+
+	// Storage totals come from the last reconciliation scan rather than a live
+	// ListObjects walk, which would be far too slow for a dashboard:
+	storage, err := cfg.db.GetStorageStats(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage stats", err)
+		return
+	}
+
+	processing, err := cfg.db.GetProcessingStats(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processing stats", err)
+		return
+	}
+
+	topUsers, err := cfg.db.GetTopUsersByStorage(r.Context(), topUsersLimit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get top users", err)
+		return
+	}
+
+	month := monthStart(time.Now())
+	bandwidth, err := cfg.db.GetTotalBandwidthSince(r.Context(), month)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get bandwidth usage", err)
+		return
+	}
+	topBandwidth, err := cfg.db.GetTopUsersByBandwidth(r.Context(), month, topUsersLimit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get top users", err)
+		return
+	}
+
+	disks, err := cfg.diskUsage()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get disk usage", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		TotalVideos:       totalVideos,
+		Storage:           storage,
+		Processing:        processing,
+		TopUsers:          topUsers,
+		Bandwidth:         bandwidth,
+		TopUsersBandwidth: topBandwidth,
+		QueueDepths:       cfg.jobs.Depths(),
+		Disks:             disks,
+	})
+}
+
+// handlerAdminReconcileStorage walks the whole bucket, totals object sizes, and
+// caches the result for handlerAdminStats.
+func (cfg *apiConfig) handlerAdminReconcileStorage(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	stats, err := cfg.scanBucket(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't scan bucket", err)
+		return
+	}
+
+	if err := cfg.db.SaveStorageStats(r.Context(), stats); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save storage stats", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, stats)
+}
+
+func (cfg *apiConfig) scanBucket(r *http.Request) (database.StorageStats, error) {
+	stats := database.StorageStats{}
+	err := cfg.store.List(r.Context(), "", func(obj storage.ObjectInfo) error {
+		stats.ObjectCount++
+		stats.TotalBytes += obj.Size
+		return nil
+	})
+	if err != nil {
+		return database.StorageStats{}, err
+	}
+	stats.ScannedAt = time.Now().UTC()
+	return stats, nil
+}
+
+// handlerAdminTieringRun runs the cold-video policy now instead of waiting for
+// the next TIERING_INTERVAL tick:
+func (cfg *apiConfig) handlerAdminTieringRun(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	if _, ok := cfg.store.(storage.Tiering); !ok {
+		respondWithError(w, http.StatusConflict, "Storage backend doesn't support tiering", nil)
+		return
+	}
+
+	tagged, err := cfg.runTieringPolicy(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't run tiering policy", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]int{"tagged": tagged})
+}
+
+// handlerAdminTieringLifecycle installs the bucket lifecycle rule that moves
+// tagged objects to Infrequent Access and Glacier:
+func (cfg *apiConfig) handlerAdminTieringLifecycle(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	tiering, ok := cfg.store.(storage.Tiering)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Storage backend doesn't support tiering", nil)
+		return
+	}
+
+	if err := tiering.EnsureLifecycleRule(r.Context(), cfg.tiering.Lifecycle); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update lifecycle rule", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.tiering.Lifecycle)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/images"
+)
+
+// avatarSize is the side, in pixels, of the square avatars we store:
+const avatarSize = 256
+
+// handlerAvatarUpload replaces the caller's avatar. The "avatar" form file (JPEG
+// or PNG) is cropped to a centered square, scaled to avatarSize and stored in the
+// assets directory under a random name, like thumbnails.
+func (cfg *apiConfig) handlerAvatarUpload(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.live().ThumbnailUploadLimit)
+	part, err := findMultipartFile(r, "avatar")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Invalid Content-Type", err)
+		return
+	}
+	if mediaType != "image/jpeg" && mediaType != "image/png" {
+		respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Invalid file type, only JPEG and PNG are allowed", nil)
+		return
+	}
+
+	img, format, err := images.Decode(part)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Avatar is too large", err)
+		case errors.Is(err, images.ErrTooLarge):
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Avatar dimensions are too large", err)
+		case respondIfUploadTooSlow(w, err):
+		default:
+			respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Couldn't decode image", err)
+		}
+		return
+	}
+	avatar := images.Resize(images.CropSquare(img), avatarSize, avatarSize)
+
+	// Save under the detected format, whatever the client claimed:
+	assetPath := getAssetPath("image/" + format)
+	assetDiskPath := cfg.getAssetDiskPath(assetPath)
+	dst, err := os.Create(assetDiskPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to create file on server", err)
+		return
+	}
+	defer dst.Close()
+	if err := images.Encode(dst, avatar, format); err != nil {
+		os.Remove(assetDiskPath)
+		respondWithError(w, http.StatusInternalServerError, "Error saving file", err)
+		return
+	}
+
+	previous, err := cfg.db.GetAvatarPath(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get avatar", err)
+		return
+	}
+	avatarURL := cfg.getAssetURL(assetPath)
+	if err := cfg.db.SetAvatar(r.Context(), userID, &assetPath, &avatarURL); err != nil {
+		os.Remove(assetDiskPath)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save avatar", err)
+		return
+	}
+	if previous != "" {
+		os.Remove(cfg.getAssetDiskPath(previous))
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+func (cfg *apiConfig) handlerAvatarDelete(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	previous, err := cfg.db.GetAvatarPath(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get avatar", err)
+		return
+	}
+	if err := cfg.db.SetAvatar(r.Context(), userID, nil, nil); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove avatar", err)
+		return
+	}
+	if previous != "" {
+		os.Remove(cfg.getAssetDiskPath(previous))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+const jobKindBulkDelete = "bulk_delete"
+
+// bulkDeleteMax caps the IDs accepted per request:
+const bulkDeleteMax = 1000
+
+// handlerVideosBulkDelete deletes many of the caller's videos at once. The rows
+// are hidden right away; their objects are removed by a background job, whose ID
+// is returned so the client can follow it on /api/jobs/{jobID}.
+func (cfg *apiConfig) handlerVideosBulkDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []string `json:"video_ids"`
+	}
+	type response struct {
+		JobID   uuid.UUID   `json:"job_id"`
+		Deleted []uuid.UUID `json:"deleted"`
+		Skipped []uuid.UUID `json:"skipped"`
+		// Retained are the caller's videos still under Object Lock retention:
+		Retained []uuid.UUID `json:"retained,omitempty"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.VideoIDs) == 0 || len(params.VideoIDs) > bulkDeleteMax {
+		respondWithFieldErrors(w, []fieldError{{"video_ids", fmt.Sprintf("Must list between 1 and %d video IDs", bulkDeleteMax)}})
+		return
+	}
+	ids := make([]uuid.UUID, 0, len(params.VideoIDs))
+	seen := map[uuid.UUID]bool{}
+	for i, s := range params.VideoIDs {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			respondWithFieldErrors(w, []fieldError{{fmt.Sprintf("video_ids[%d]", i), "Invalid ID"}})
+			return
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	// Videos with files under Object Lock retention stay until it runs out, see
+	// object_lock.go:
+	var retained []uuid.UUID
+	if cfg.s3Retention.Enabled() {
+		deletable := make([]uuid.UUID, 0, len(ids))
+		for _, id := range ids {
+			video, err := cfg.videos.GetVideo(r.Context(), id)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+				return
+			}
+			if video.ID == uuid.Nil || video.UserID != userID {
+				deletable = append(deletable, id)
+				continue
+			}
+			until, err := cfg.videoRetainedUntil(r.Context(), id)
+			if err != nil {
+				respondWithPipelineError(w, storageError(http.StatusInternalServerError, "Couldn't check the videos' retention", err))
+				return
+			}
+			if until.IsZero() {
+				deletable = append(deletable, id)
+			} else {
+				retained = append(retained, id)
+			}
+		}
+		ids = deletable
+	}
+
+	// Only the caller's own videos are deleted; anything else is skipped, without
+	// saying whether it exists:
+	deleted, err := cfg.db.SoftDeleteVideos(r.Context(), userID, ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete videos", err)
+		return
+	}
+	skipped := []uuid.UUID{}
+	isDeleted := map[uuid.UUID]bool{}
+	for _, id := range deleted {
+		isDeleted[id] = true
+		// Gone as far as anyone can see, though the purge below takes a while:
+		cfg.hooks.Deleted(r.Context(), id, userID)
+	}
+	for _, id := range ids {
+		if !isDeleted[id] {
+			skipped = append(skipped, id)
+		}
+	}
+
+	// Deleting objects is quick I/O, so it doesn't wait behind transcodes:
+	job, err := cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindBulkDelete,
+		OwnerID:  userID,
+		Priority: jobs.PriorityHigh,
+		Run: tracedJob(r.Context(), jobKindBulkDelete, func(ctx context.Context, job *jobs.Job) error {
+			return cfg.purgeVideos(ctx, deleted, job.SetProgress)
+		}),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Couldn't queue deletion", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, response{
+		JobID:    job.ID,
+		Deleted:  deleted,
+		Skipped:  skipped,
+		Retained: retained,
+	})
+}
+
+// purgeVideos removes the stored objects of soft-deleted videos, then their rows.
+// Keys are gathered first so the store can delete them in batches (S3's
+// DeleteObjects takes up to 1000 per call) instead of one request per object.
+func (cfg *apiConfig) purgeVideos(ctx context.Context, ids []uuid.UUID, onProgress transcode.ProgressFunc) error {
+	var keys []string
+	for i, id := range ids {
+		// Content-addressed objects may be shared, so releasing them deletes only
+		// unreferenced ones; objects in the prefix layout belong to this video alone:
+		hash, err := cfg.db.GetVideoContentHash(ctx, id)
+		if err != nil {
+			return err
+		}
+		if hash != nil {
+			if err := cfg.releaseVideoContent(ctx, id); err != nil {
+				return err
+			}
+		} else {
+			obj, err := cfg.db.GetVideoObject(ctx, id)
+			if err != nil {
+				return err
+			}
+			if obj.ObjectKey != "" {
+				keys = append(keys, obj.ObjectKey)
+			}
+		}
+		versionKeys, err := cfg.releaseVersions(ctx, id)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, versionKeys...)
+
+		streamPrefix, err := cfg.db.GetVideoStreamPrefix(ctx, id)
+		if err != nil {
+			return err
+		}
+		if streamPrefix != "" {
+			err := cfg.store.List(ctx, streamPrefix+"/", func(obj storage.ObjectInfo) error {
+				keys = append(keys, obj.Key)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		onProgress(float64(i+1) / float64(len(ids)) * 50)
+	}
+
+	if len(keys) > 0 {
+		if err := cfg.store.Delete(ctx, keys...); err != nil {
+			return err
+		}
+		cfg.invalidateCDN(ctx, keys...)
+		cfg.deleteReplicas(ctx, keys...)
+	}
+	for i, id := range ids {
+		if err := cfg.videos.DeleteVideo(ctx, id); err != nil {
+			return err
+		}
+		onProgress(50 + float64(i+1)/float64(len(ids))*50)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	// bulkImportMax caps the rows accepted per request:
+	bulkImportMax = 1000
+	// bulkImportMaxBody caps the request body, compressed or not:
+	bulkImportMaxBody = 4 << 20
+)
+
+// bulkImportRow is one video of a bulk import. In a CSV, the header row names
+// the columns, in any order: title, description, url, visibility.
+type bulkImportRow struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// URL is where the video's file is, for POST
+	// /api/videos/{videoID}/upload-from-url to fetch when it's given no URL.
+	URL        string `json:"url"`
+	Visibility string `json:"visibility"`
+}
+
+var bulkImportColumns = map[string]bool{"title": true, "description": true, "url": true, "visibility": true}
+
+// handlerVideosBulkImport creates draft videos from a JSON array or a CSV of
+// their metadata, for moving a library in. It's all or nothing: when any row is
+// invalid, nothing is created and every problem is listed, with rows numbered
+// from 0 in the order given (not counting a CSV's header).
+func (cfg *apiConfig) handlerVideosBulkImport(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Videos []database.Video `json:"videos"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, bulkImportMaxBody)
+	var rows []bulkImportRow
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		err = json.NewDecoder(body).Decode(&rows)
+	case "text/csv":
+		rows, err = readBulkImportCSV(body)
+	default:
+		respondWithCode(w, http.StatusUnsupportedMediaType, codeInvalidMIME, "Content-Type must be application/json or text/csv", nil)
+		return
+	}
+	if maxErr := (*http.MaxBytesError)(nil); errors.As(err, &maxErr) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Import is larger than %d bytes", maxErr.Limit), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode import: "+err.Error(), err)
+		return
+	}
+	if len(rows) == 0 || len(rows) > bulkImportMax {
+		respondWithFieldErrors(w, []fieldError{{"rows", fmt.Sprintf("Must have between 1 and %d rows", bulkImportMax)}})
+		return
+	}
+
+	var fieldErrors []fieldError
+	params := make([]database.ImportVideoParams, len(rows))
+	for i, row := range rows {
+		fieldErrors = append(fieldErrors, validateBulkImportRow(i, row)...)
+		params[i] = database.ImportVideoParams{
+			CreateVideoParams: database.CreateVideoParams{
+				Title:       strings.TrimSpace(row.Title),
+				Description: row.Description,
+				UserID:      userID,
+				Visibility:  row.Visibility,
+			},
+			SourceURL: row.URL,
+		}
+	}
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, fieldErrors)
+		return
+	}
+
+	videos, err := cfg.db.ImportVideos(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create videos", err)
+		return
+	}
+	for _, video := range videos {
+		cfg.hooks.Created(r.Context(), video)
+	}
+	respondWithJSON(w, http.StatusCreated, response{Videos: videos})
+}
+
+func validateBulkImportRow(i int, row bulkImportRow) []fieldError {
+	var fieldErrors []fieldError
+	field := func(name string) string {
+		return fmt.Sprintf("rows[%d].%s", i, name)
+	}
+	if strings.TrimSpace(row.Title) == "" {
+		fieldErrors = append(fieldErrors, fieldError{field("title"), "Title is required"})
+	}
+	if row.Visibility != "" && !validVisibility(row.Visibility) {
+		fieldErrors = append(fieldErrors, fieldError{field("visibility"), `Invalid visibility, expected "public" or "private"`})
+	}
+	if row.URL != "" {
+		sourceURL, err := url.Parse(row.URL)
+		if err != nil || (sourceURL.Scheme != "http" && sourceURL.Scheme != "https") || sourceURL.Host == "" {
+			fieldErrors = append(fieldErrors, fieldError{field("url"), "url must be an absolute http or https URL"})
+		}
+	}
+	return fieldErrors
+}
+
+// readBulkImportCSV reads the rows of a CSV import. The header row is required,
+// and unknown columns are an error rather than silently dropped.
+func readBulkImportCSV(body io.Reader) ([]bulkImportRow, error) {
+	cr := csv.NewReader(body)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !bulkImportColumns[name] {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("column %q given twice", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, errors.New(`the header has no "title" column`)
+	}
+
+	var rows []bulkImportRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == bulkImportMax {
+			// One more than allowed is enough to reject it; don't read the rest:
+			return append(rows, bulkImportRow{}), nil
+		}
+		value := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return record[i]
+			}
+			return ""
+		}
+		rows = append(rows, bulkImportRow{
+			Title:       value("title"),
+			Description: value("description"),
+			URL:         strings.TrimSpace(value("url")),
+			Visibility:  strings.TrimSpace(value("visibility")),
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerChaptersUpdate replaces a video's chapter list. The body is either JSON
+// ({"chapters": [{"title", "start_seconds", "end_seconds"}]}) or a multipart form
+// with a WebVTT chapters file in the "chapters" field.
+func (cfg *apiConfig) handlerChaptersUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Chapters []database.CreateChapterParams `json:"chapters"`
+	}
+	// chapter lists are tiny, 1 MB is plenty:
+	const maxChaptersBody = 1 << 20
+	r.Body = http.MaxBytesReader(w, r.Body, maxChaptersBody)
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to update this video", nil)
+		return
+	}
+
+	// Pick the parser from the request's Content-Type:
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var chapters []database.CreateChapterParams
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(cfg.live().MultipartMaxMemory); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+			return
+		}
+		file, _, err := r.FormFile("chapters")
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+			return
+		}
+		defer file.Close()
+
+		chapters, err = parseWebVTTChapters(file)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid WebVTT chapters file", err)
+			return
+		}
+	} else {
+		params := parameters{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+		chapters = params.Chapters
+	}
+
+	if err := validateChapters(chapters); err != nil {
+		respondWithFieldErrors(w, []fieldError{{"chapters", err.Error()}})
+		return
+	}
+
+	video.Chapters, err = cfg.db.ReplaceChapters(r.Context(), videoID, chapters)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chapters", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
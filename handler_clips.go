@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/upload"
+	"github.com/google/uuid"
+)
+
+// minClipLength keeps clips from being cut down to nothing:
+const minClipLength = 500 * time.Millisecond
+
+// clipResponse is a clip, with the video it was cut from:
+type clipResponse struct {
+	database.Video
+	SourceVideoID uuid.UUID `json:"source_video_id"`
+}
+
+// handlerClipCreate cuts a time range out of one of the caller's videos into a
+// new video of theirs, with the source's visibility, which goes through the same
+// pipeline as an upload. The body is {"start_seconds": 12, "end_seconds": 30}
+// plus an optional "title" and "description"; the title defaults to the
+// source's. The source is left as it is.
+func (cfg *apiConfig) handlerClipCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		StartSeconds *float64 `json:"start_seconds"`
+		EndSeconds   *float64 `json:"end_seconds"`
+		Title        string   `json:"title"`
+		Description  string   `json:"description"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	var fieldErrors []fieldError
+	if params.StartSeconds == nil || *params.StartSeconds < 0 {
+		fieldErrors = append(fieldErrors, fieldError{"start_seconds", "Required, and can't be negative"})
+	}
+	if params.EndSeconds == nil {
+		fieldErrors = append(fieldErrors, fieldError{"end_seconds", "Required"})
+	} else if params.StartSeconds != nil && *params.EndSeconds-*params.StartSeconds < minClipLength.Seconds() {
+		fieldErrors = append(fieldErrors, fieldError{"end_seconds", fmt.Sprintf("Must be at least %.1fs after start_seconds", minClipLength.Seconds())})
+	}
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, fieldErrors)
+		return
+	}
+	start := time.Duration(*params.StartSeconds * float64(time.Second))
+	end := time.Duration(*params.EndSeconds * float64(time.Second))
+
+	source, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if source.ID == uuid.Nil {
+		respondWithCode(w, http.StatusNotFound, codeNotFound, "Video not found", nil)
+		return
+	}
+	if source.UserID != userID {
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to clip this video", nil)
+		return
+	}
+	if source.VideoURL == nil || source.MediaKind != mediaKindVideo {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video has no uploaded video content", nil)
+		return
+	}
+	obj, err := cfg.db.GetVideoObject(r.Context(), source.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if obj.ObjectKey == "" {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video has no uploaded video content", nil)
+		return
+	}
+	// An archived object can't be read until it's restored; this starts the restore:
+	if status, err := cfg.playbackStatus(r.Context(), source); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video availability", err)
+		return
+	} else if status == playbackRestoring {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video is being restored from archive, try again later", nil)
+		return
+	}
+
+	sourcePath, cleanup, err := cfg.openVideoSource(r.Context(), obj.ObjectKey)
+	if err != nil {
+		respondWithPipelineError(w, storageError(http.StatusBadGateway, "Couldn't read video from storage", err))
+		return
+	}
+	defer cleanup()
+
+	// Catch a range past the end here, ffmpeg would just cut it short:
+	processedHash, err := cfg.db.GetVideoProcessedHash(r.Context(), source.ID)
+	if err != nil {
+		log.Printf("Couldn't get processed checksum of video %s: %v", source.ID, err)
+	}
+	var duration time.Duration
+	probe, err := cfg.probeFile(r.Context(), sourcePath, processedHash)
+	if err == nil {
+		duration, err = durationFromProbe(probe)
+	}
+	if err == nil && end > duration {
+		respondWithFieldErrors(w, []fieldError{{"end_seconds", fmt.Sprintf("Can't be past the end of the video (%.2fs)", duration.Seconds())}})
+		return
+	}
+
+	staged, err := cfg.cutClip(r.Context(), sourcePath, start, end)
+	if err != nil {
+		respondWithPipelineError(w, err)
+		return
+	}
+	defer os.Remove(staged.Path)
+
+	title := strings.TrimSpace(params.Title)
+	if title == "" {
+		title = source.Title
+	}
+	clip, err := cfg.db.CreateClip(r.Context(), database.CreateVideoParams{
+		Title:       title,
+		Description: params.Description,
+		UserID:      userID,
+		Visibility:  source.Visibility,
+	}, source.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create clip", err)
+		return
+	}
+	cfg.hooks.Created(r.Context(), clip)
+
+	name := "video"
+	if source.OriginalFilename != nil {
+		name = strings.TrimSuffix(*source.OriginalFilename, path.Ext(*source.OriginalFilename))
+	}
+	staged.Filename = fmt.Sprintf("%s-clip-%.0f-%.0f.mp4", name, start.Seconds(), end.Seconds())
+	// The clip exists now even if processing fails; it's left failed, like an
+	// upload would be, for the owner to delete:
+	clip, err = cfg.uploads().Run(r.Context(), clip, staged)
+	if err != nil {
+		respondWithPipelineError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, clipResponse{Video: clip, SourceVideoID: source.ID})
+}
+
+// cutClip writes the range of source to a temp file and stages it for the
+// upload pipeline, encrypted like an upload's when TEMP_ENCRYPTION is on. The
+// caller must remove the staged file.
+func (cfg *apiConfig) cutClip(ctx context.Context, source string, start, end time.Duration) (upload.Staged, error) {
+	cut, err := os.CreateTemp(cfg.uploadTmpDir, "tubely-clip-*.mp4")
+	if err != nil {
+		return upload.Staged{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Could not create temp file", err}
+	}
+	cut.Close()
+	defer os.Remove(cut.Name())
+	if err := cfg.transcoder.ExtractClip(ctx, source, start, end, cut.Name()); err != nil {
+		return upload.Staged{}, &pipelineError{http.StatusInternalServerError, codeProcessingFailed, "Couldn't cut clip", err}
+	}
+
+	plain, err := os.Open(cut.Name())
+	if err != nil {
+		return upload.Staged{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Could not read clip", err}
+	}
+	defer plain.Close()
+	staged, err := cfg.uploads().Stage(ctx, upload.Source{Body: plain, ContentType: "video/mp4"})
+	if err != nil {
+		var pe *pipelineError
+		if errors.As(err, &pe) {
+			return upload.Staged{}, err
+		}
+		return upload.Staged{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Could not write clip", err}
+	}
+	return staged, nil
+}
+
+// handlerClipsList lists the clips cut from one of the caller's videos:
+func (cfg *apiConfig) handlerClipsList(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	source, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if source.ID == uuid.Nil {
+		respondWithCode(w, http.StatusNotFound, codeNotFound, "Video not found", nil)
+		return
+	}
+	if source.UserID != userID {
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to view this video's clips", nil)
+		return
+	}
+
+	clips, err := cfg.db.GetClips(r.Context(), source.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get clips", err)
+		return
+	}
+	cfg.signPlaybackURLs(r.Context(), clips)
+
+	respondWithJSON(w, http.StatusOK, clips)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// exportPageSize is how many videos the export reads at a time; only one page
+// is held in memory, however big the library.
+const exportPageSize = 500
+
+const (
+	exportFormatJSON = "application/json"
+	exportFormatCSV  = "text/csv"
+)
+
+// exportedVideo is one video in an export. Download URLs are only there when
+// asked for, and not for archived videos, which can't be read until restored.
+type exportedVideo struct {
+	database.Video
+	SizeBytes            *int64     `json:"size_bytes,omitempty"`
+	StorageTier          string     `json:"storage_tier"`
+	SHA256               *string    `json:"sha256,omitempty"`
+	DownloadURL          string     `json:"download_url,omitempty"`
+	DownloadURLExpiresAt *time.Time `json:"download_url_expires_at,omitempty"`
+}
+
+// exportCSVHeader names the CSV columns, in the order exportCSVRow writes them:
+var exportCSVHeader = []string{
+	"id", "created_at", "updated_at", "title", "description", "media_kind",
+	"visibility", "status", "moderation_status", "original_filename", "like_count",
+	"video_url", "thumbnail_url", "hls_url", "dash_url", "size_bytes",
+	"storage_tier", "sha256", "download_url", "download_url_expires_at",
+}
+
+// handlerExport returns all of the caller's video metadata, for data
+// portability: JSON (an array) or CSV, whichever the Accept header prefers.
+// With ?download_urls=true each video that has a file gets a signed URL to it,
+// valid for SIGNED_URL_DOWNLOAD_TTL. The response is written a page at a time as
+// it's read, so it starts at once and never holds the whole library; an error
+// partway through can only cut it short, leaving invalid JSON or a short CSV.
+func (cfg *apiConfig) handlerExport(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	format := negotiateExportFormat(r.Header.Get("Accept"))
+	if format == "" {
+		respondWithError(w, http.StatusNotAcceptable, "Export is available as application/json or text/csv", nil)
+		return
+	}
+	withURLs := false
+	if v := r.URL.Query().Get("download_urls"); v != "" {
+		withURLs, err = strconv.ParseBool(v)
+		if err != nil {
+			respondWithFieldErrors(w, []fieldError{{"download_urls", "Must be true or false"}})
+			return
+		}
+	}
+
+	// The first page is read before the headers go out, so a failure there can
+	// still be reported properly:
+	page, cursor, err := cfg.db.ExportVideos(r.Context(), userID, 0, exportPageSize)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't export videos", err)
+		return
+	}
+
+	ext := ".json"
+	if format == exportFormatCSV {
+		ext = ".csv"
+	}
+	w.Header().Set("Content-Type", format+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": "tubely-export-" + time.Now().UTC().Format("2006-01-02") + ext,
+	}))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	var out exportWriter
+	if format == exportFormatCSV {
+		out = newExportCSVWriter(w)
+	} else {
+		out = &exportJSONWriter{w: w}
+	}
+	rc := http.NewResponseController(w)
+	for {
+		for _, v := range page {
+			video := cfg.exportedVideo(r, v, withURLs)
+			if err := out.write(video); err != nil {
+				log.Printf("Couldn't write export of user %s: %v", userID, err)
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		if len(page) < exportPageSize {
+			break
+		}
+		page, cursor, err = cfg.db.ExportVideos(r.Context(), userID, cursor, exportPageSize)
+		if err != nil {
+			log.Printf("Couldn't export videos of user %s: %v", userID, err)
+			return
+		}
+	}
+	if err := out.close(); err != nil {
+		log.Printf("Couldn't write export of user %s: %v", userID, err)
+	}
+}
+
+func (cfg *apiConfig) exportedVideo(r *http.Request, v database.ExportedVideo, withURL bool) exportedVideo {
+	video := exportedVideo{
+		Video:       v.Video,
+		SizeBytes:   v.SizeBytes,
+		StorageTier: v.StorageTier,
+		SHA256:      v.ProcessedSHA256,
+	}
+	if !withURL || v.ObjectKey == "" || v.StorageTier != database.StorageTierHot {
+		return video
+	}
+	url, expiresAt, err := cfg.signedURL(r.Context(), v.ObjectKey, cfg.signedURLs.downloadTTL)
+	if err != nil {
+		log.Printf("Couldn't sign download URL of video %s for export: %v", v.ID, err)
+		return video
+	}
+	video.DownloadURL = url
+	video.DownloadURLExpiresAt = &expiresAt
+	return video
+}
+
+// negotiateExportFormat picks the export format the Accept header prefers, by
+// q-value and then by the order listed; no header means JSON. It returns ""
+// when neither format is acceptable.
+func negotiateExportFormat(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return exportFormatJSON
+	}
+	type option struct {
+		format string
+		q      float64
+	}
+	var options []option
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		switch mediaType {
+		case exportFormatJSON, exportFormatCSV:
+			options = append(options, option{mediaType, q})
+		case "application/*", "*/*":
+			options = append(options, option{exportFormatJSON, q})
+		case "text/*":
+			options = append(options, option{exportFormatCSV, q})
+		}
+	}
+	if len(options) == 0 {
+		return ""
+	}
+	sort.SliceStable(options, func(i, j int) bool { return options[i].q > options[j].q })
+	return options[0].format
+}
+
+// exportWriter writes an export one video at a time:
+type exportWriter interface {
+	write(video exportedVideo) error
+	// close ends the export; it's not called when one is cut short.
+	close() error
+}
+
+// exportJSONWriter writes a JSON array:
+type exportJSONWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (e *exportJSONWriter) write(video exportedVideo) error {
+	sep := ",\n"
+	if !e.started {
+		sep = "[\n"
+		e.started = true
+	}
+	data, err := json.Marshal(video)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(e.w, sep); err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *exportJSONWriter) close() error {
+	end := "\n]\n"
+	if !e.started {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+type exportCSVWriter struct {
+	w          *csv.Writer
+	headerDone bool
+}
+
+func newExportCSVWriter(w io.Writer) *exportCSVWriter {
+	return &exportCSVWriter{w: csv.NewWriter(w)}
+}
+
+func (e *exportCSVWriter) write(video exportedVideo) error {
+	if err := e.header(); err != nil {
+		return err
+	}
+	if err := e.w.Write(exportCSVRow(video)); err != nil {
+		return err
+	}
+	// Flushed per row: the response is flushed per page, and what's buffered
+	// here wouldn't be.
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *exportCSVWriter) close() error {
+	if err := e.header(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *exportCSVWriter) header() error {
+	if e.headerDone {
+		return nil
+	}
+	e.headerDone = true
+	return e.w.Write(exportCSVHeader)
+}
+
+func exportCSVRow(video exportedVideo) []string {
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	size := ""
+	if video.SizeBytes != nil {
+		size = strconv.FormatInt(*video.SizeBytes, 10)
+	}
+	expiresAt := ""
+	if video.DownloadURLExpiresAt != nil {
+		expiresAt = video.DownloadURLExpiresAt.Format(time.RFC3339)
+	}
+	return []string{
+		video.ID.String(),
+		video.CreatedAt.UTC().Format(time.RFC3339),
+		video.UpdatedAt.UTC().Format(time.RFC3339),
+		csvText(video.Title),
+		csvText(video.Description),
+		video.MediaKind,
+		video.Visibility,
+		video.Status,
+		video.ModerationStatus,
+		csvText(str(video.OriginalFilename)),
+		strconv.Itoa(video.LikeCount),
+		str(video.VideoURL),
+		str(video.ThumbnailURL),
+		str(video.HLSURL),
+		str(video.DashURL),
+		size,
+		video.StorageTier,
+		str(video.SHA256),
+		video.DownloadURL,
+		expiresAt,
+	}
+}
+
+// csvText keeps user-written text from being read as a formula when the CSV
+// is opened in a spreadsheet, by quoting it the way spreadsheets do.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
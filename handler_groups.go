@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/keys"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+)
+
+// main builds one apiConfig with everything and serves every route from it.
+// The constructors here build one with only what a group of handlers uses, from
+// the interfaces in deps.go, so the group can be served, or tested, on its own.
+// Calling another group's handlers on it is a bug: what they need is nil.
+
+// videoDeps is what the video metadata handlers need. DB is still needed for
+// likes and views; the videos themselves come from Videos.
+type videoDeps struct {
+	DB       database.Client
+	Videos   VideoStore
+	Objects  ObjectStore
+	Tokens   *auth.KeySet
+	Settings *liveSettings
+}
+
+// newVideoHandlers serves handlerVideoMetaCreate, handlerVideoGet,
+// handlerVideosRetrieve and handlerVideoMetaDelete.
+func newVideoHandlers(d videoDeps) *apiConfig {
+	cfg := &apiConfig{
+		db:     d.DB,
+		videos: d.Videos,
+		store:  d.Objects,
+		tokens: d.Tokens,
+		cdn:    cdn.Origin{Store: d.Objects},
+	}
+	cfg.settings.Store(d.Settings)
+	return cfg
+}
+
+// uploadDeps is what the upload handlers need: everything between the request
+// and a ready video.
+type uploadDeps struct {
+	DB         database.Client
+	Videos     VideoStore
+	Objects    ObjectStore
+	Prober     Prober
+	Transcoder Transcoder
+	Tokens     *auth.KeySet
+	Settings   *liveSettings
+	// TmpDir is where uploads are written while they're processed:
+	TmpDir string
+	// AssetsRoot is where thumbnails are written:
+	AssetsRoot string
+	// DiskHighWater is DISK_HIGH_WATER_PERCENT, see diskspace.go:
+	DiskHighWater int
+}
+
+// newUploadHandlers serves handlerUploadVideo, handlerUploadVideoFromURL and
+// handlerThumbnailFromFrame, processing on a queue of its own with one worker
+// per tier; the caller shuts it down with cfg.jobs.Shutdown. Uploads are staged
+// on disk, named by the prefix layout and approved without moderation.
+func newUploadHandlers(d uploadDeps) *apiConfig {
+	queue := jobs.NewQueue(jobs.Config{
+		Workers: [jobs.NumPriorities]int{1, 1, 1},
+	})
+	queue.Start()
+	layout, _ := keys.New(string(keys.LayoutPrefix))
+	cfg := &apiConfig{
+		db:            d.DB,
+		videos:        d.Videos,
+		tokens:        d.Tokens,
+		store:         d.Objects,
+		keys:          layout,
+		cdn:           cdn.Origin{Store: d.Objects},
+		uploadTmpDir:  d.TmpDir,
+		assetsRoot:    d.AssetsRoot,
+		diskHighWater: d.DiskHighWater,
+		uploadStaging: "disk",
+		jobs:          queue,
+		moderator:     moderation.NoOp{},
+		prober:        d.Prober,
+		transcoder:    d.Transcoder,
+	}
+	cfg.settings.Store(d.Settings)
+	return cfg
+}
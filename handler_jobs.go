@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/google/uuid"
+)
+
+// handlerJobGet reports the state of one of the caller's background jobs:
+func (cfg *apiConfig) handlerJobGet(w http.ResponseWriter, r *http.Request) {
+	jobIDString := r.PathValue("jobID")
+	jobID, err := uuid.Parse(jobIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// Someone else's job looks the same as a missing one:
+	job, err := cfg.jobs.Get(jobID)
+	if err != nil || job.OwnerID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find job", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job.Snapshot())
+}
+
+// jobEventsKeepAlive is how often an idle event stream gets a comment line, so
+// proxies don't close it while a long transcode sits in the queue:
+const jobEventsKeepAlive = 15 * time.Second
+
+// handlerJobEvents streams a job's state as server-sent events: one "job" event
+// right away, then one per status or progress change, ending after the job
+// finishes. The data is the same JSON handlerJobGet returns.
+func (cfg *apiConfig) handlerJobEvents(w http.ResponseWriter, r *http.Request) {
+	jobIDString := r.PathValue("jobID")
+	jobID, err := uuid.Parse(jobIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	job, err := cfg.jobs.Get(jobID)
+	if err != nil || job.OwnerID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find job", err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming unsupported", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(jobEventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		// Grab the channel before the snapshot so a change in between isn't lost:
+		changed := job.Changed()
+		snapshot := job.Snapshot()
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: job\ndata: %s\n\n", data)
+		flusher.Flush()
+		if snapshot.Status.Finished() {
+			return
+		}
+
+	wait:
+		for {
+			select {
+			case <-changed:
+				break wait
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// handlerVideoJobs lists the background jobs (processing, packaging) for one of
+// the caller's videos, so a client can find the job to follow while an upload is
+// still in flight.
+func (cfg *apiConfig) handlerVideoJobs(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to view this video's jobs", nil)
+		return
+	}
+
+	snapshots := []jobs.Snapshot{}
+	for _, job := range cfg.jobs.ListByVideo(videoID) {
+		snapshots = append(snapshots, job.Snapshot())
+	}
+	respondWithJSON(w, http.StatusOK, snapshots)
+}
@@ -0,0 +1,13 @@
+package main
+
+import "net/http"
+
+// handlerJWKS publishes the public keys access tokens are signed with, so other
+// services can verify them without the server's secrets. With HS256 only, the
+// set is empty.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	// Verifiers cache the set; a few minutes lets a new signing key spread
+	// before it's first used, if it's added second and promoted later:
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.tokens.JWKS())
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerVideoLike likes the video for the caller: POST
+// /api/videos/{videoID}/like. Liking twice is the same as once.
+func (cfg *apiConfig) handlerVideoLike(w http.ResponseWriter, r *http.Request) {
+	cfg.setVideoLike(w, r, true)
+}
+
+// handlerVideoUnlike takes the caller's like back: DELETE
+// /api/videos/{videoID}/like.
+func (cfg *apiConfig) handlerVideoUnlike(w http.ResponseWriter, r *http.Request) {
+	cfg.setVideoLike(w, r, false)
+}
+
+func (cfg *apiConfig) setVideoLike(w http.ResponseWriter, r *http.Request, like bool) {
+	type response struct {
+		LikeCount int  `json:"like_count"`
+		LikedByMe bool `json:"liked_by_me"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// Only videos the caller can see can be liked:
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	var count int
+	if like {
+		count, err = cfg.db.LikeVideo(r.Context(), userID, videoID)
+	} else {
+		count, err = cfg.db.UnlikeVideo(r.Context(), userID, videoID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update like", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{LikeCount: count, LikedByMe: like})
+}
+
+// optionalViewer returns the logged-in user behind the request, or uuid.Nil
+// for anonymous requests and bad tokens alike.
+func (cfg *apiConfig) optionalViewer(r *http.Request) uuid.UUID {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		return uuid.Nil
+	}
+	return userID
+}
+
+// markLiked sets LikedByMe on each video for viewerID; anonymous viewers get
+// no liked_by_me at all. A failure only leaves it out.
+func (cfg *apiConfig) markLiked(ctx context.Context, viewerID uuid.UUID, videos []database.Video) {
+	if viewerID == uuid.Nil || len(videos) == 0 {
+		return
+	}
+	ids := make([]uuid.UUID, len(videos))
+	for i, video := range videos {
+		ids[i] = video.ID
+	}
+	liked, err := cfg.db.LikedVideos(ctx, viewerID, ids)
+	if err != nil {
+		log.Printf("Couldn't get likes of user %s: %v", viewerID, err)
+		return
+	}
+	for i := range videos {
+		likedByMe := liked[videos[i].ID]
+		videos[i].LikedByMe = &likedByMe
+	}
+}
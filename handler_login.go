@@ -1,18 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Password string `json:"password"`
 		Email    string `json:"email"`
+		// UseCookies sets the tokens as httpOnly cookies instead of returning them:
+		UseCookies bool `json:"use_cookies"`
 	}
 	type response struct {
 		database.User
@@ -28,41 +34,43 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByEmail(params.Email)
+	user, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, user.Password)
+	needsRehash, err := cfg.passwords.Check(params.Password, user.Password)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
-
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour*24*30,
-	)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
-		return
+	// Move the hash to the current algorithm and costs while we have the password;
+	// the login goes ahead either way:
+	if needsRehash {
+		if hash, err := cfg.passwords.Hash(params.Password); err != nil {
+			log.Printf("Couldn't rehash password of user %s: %v", user.ID, err)
+		} else if err := cfg.db.SetUserPassword(r.Context(), user.ID, hash); err != nil {
+			log.Printf("Couldn't save rehashed password of user %s: %v", user.ID, err)
+		}
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	// A cookie session renews its access token through /api/refresh on its own, so
+	// it gets a short-lived one:
+	accessTTL := bearerAccessTokenTTL
+	if params.UseCookies {
+		accessTTL = accessTokenTTL
+	}
+	accessToken, refreshToken, err := cfg.issueTokens(r.Context(), user.ID, accessTTL)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
 		return
 	}
 
-	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
-		UserID:    user.ID,
-		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
-	})
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
+	if params.UseCookies {
+		cfg.setAccessCookie(w, accessToken)
+		cfg.setRefreshCookie(w, refreshToken)
+		respondWithJSON(w, http.StatusOK, user)
 		return
 	}
 
@@ -72,3 +80,25 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		RefreshToken: refreshToken,
 	})
 }
+
+// issueTokens signs in the user: a new access token valid for accessTTL, and a
+// refresh token, saved so it can be exchanged and revoked.
+func (cfg *apiConfig) issueTokens(ctx context.Context, userID uuid.UUID, accessTTL time.Duration) (accessToken, refreshToken string, err error) {
+	accessToken, err = cfg.tokens.MakeJWT(userID, accessTTL)
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't create access JWT: %w", err)
+	}
+	refreshToken, err = auth.MakeRefreshToken()
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't create refresh token: %w", err)
+	}
+	_, err = cfg.db.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{
+		UserID:    userID,
+		Token:     refreshToken,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't save refresh token: %w", err)
+	}
+	return accessToken, refreshToken, nil
+}
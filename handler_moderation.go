@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// moderationQueueLimit is how many videos the review queue returns at once:
+const moderationQueueLimit = 100
+
+// handlerAdminModerationQueue lists flagged videos, and pending ones whose
+// checks are still running or failed, oldest first:
+func (cfg *apiConfig) handlerAdminModerationQueue(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	items, err := cfg.db.GetModerationQueue(r.Context(), moderationQueueLimit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get moderation queue", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, items)
+}
+
+// handlerAdminModerationReview records an admin's decision on a video:
+// "approve" publishes it, "reject" keeps it visible only to its owner.
+func (cfg *apiConfig) handlerAdminModerationReview(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Decision string `json:"decision"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	var status string
+	switch params.Decision {
+	case "approve":
+		status = database.ModerationApproved
+	case "reject":
+		status = database.ModerationRejected
+	default:
+		respondWithFieldErrors(w, []fieldError{{"decision", `Must be "approve" or "reject"`}})
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if err := cfg.db.SetModerationStatus(r.Context(), videoID, status); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update moderation status", err)
+		return
+	}
+	video.ModerationStatus = status
+	cfg.hooks.Updated(r.Context(), video)
+	if status == database.ModerationApproved {
+		cfg.notify(r.Context(), video, database.NotificationModerationApproved, fmt.Sprintf("%q was approved by a moderator", video.Title))
+	} else {
+		cfg.notify(r.Context(), video, database.NotificationModerationRejected, fmt.Sprintf("%q was rejected by a moderator and is only visible to you", video.Title))
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
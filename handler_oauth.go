@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oauth"
+)
+
+// Social login: GET /api/auth/{provider}/login sends the browser to Google or
+// GitHub, which sends it back to /api/auth/{provider}/callback, where the user
+// gets the same access and refresh tokens a password login gives. Users who
+// only ever sign in this way have no password at all.
+const (
+	// oauthCookieName holds the state, PKCE verifier and session mode of a
+	// sign-in in progress, so the callback can tell it's the one it started:
+	oauthCookieName = "tubely_oauth"
+	oauthCookiePath = "/api/auth/"
+	oauthCookieTTL  = 10 * time.Minute
+
+	// oauthModeCookie ends the sign-in with session cookies and a redirect to
+	// the web app; oauthModeToken with the tokens in the response, like
+	// POST /api/login.
+	oauthModeCookie = "cookie"
+	oauthModeToken  = "token"
+)
+
+// errIdentityEmailUnverified means the provider couldn't vouch for the
+// account's email address, which users are keyed on:
+var errIdentityEmailUnverified = errors.New("the account has no verified email address")
+
+// oauthProviders returns the providers with client credentials configured:
+func oauthProviders(googleID, googleSecret, githubID, githubSecret string) map[string]*oauth.Provider {
+	providers := map[string]*oauth.Provider{}
+	if googleID != "" {
+		providers[oauth.ProviderGoogle] = oauth.Google(googleID, googleSecret)
+	}
+	if githubID != "" {
+		providers[oauth.ProviderGitHub] = oauth.GitHub(githubID, githubSecret)
+	}
+	return providers
+}
+
+// oauthRedirectURI is where the provider sends the user back to; it has to be
+// registered with the provider as is.
+func (cfg *apiConfig) oauthRedirectURI(provider string) string {
+	return cfg.publicBaseURL + "/api/auth/" + provider + "/callback"
+}
+
+func (cfg *apiConfig) handlerOAuthLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oauthProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown login provider", nil)
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = oauthModeCookie
+	}
+	if mode != oauthModeCookie && mode != oauthModeToken {
+		respondWithFieldErrors(w, []fieldError{{"mode", `Invalid mode, expected "cookie" or "token"`}})
+		return
+	}
+
+	state, err := oauth.NewVerifier()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+	verifier, err := oauth.NewVerifier()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+	// Lax whatever COOKIE_SAMESITE says: the callback is a cross-site
+	// navigation from the provider, and a strict cookie wouldn't come along.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookieName,
+		Value:    strings.Join([]string{state, verifier, mode}, "."),
+		Path:     oauthCookiePath,
+		MaxAge:   int(oauthCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.sessionCookies.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(cfg.oauthRedirectURI(provider.Name), state, verifier), http.StatusFound)
+}
+
+func (cfg *apiConfig) handlerOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	provider, ok := cfg.oauthProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown login provider", nil)
+		return
+	}
+	// The sign-in is over one way or another; the cookie is single-use:
+	cookie, err := r.Cookie(oauthCookieName)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookieName,
+		Path:     oauthCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cfg.sessionCookies.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Login expired, please try again", err)
+		return
+	}
+	parts := strings.Split(cookie.Value, ".")
+	query := r.URL.Query()
+	if len(parts) != 3 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(query.Get("state"))) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Login state doesn't match, please try again", nil)
+		return
+	}
+	verifier, mode := parts[1], parts[2]
+	if providerErr := query.Get("error"); providerErr != "" {
+		respondWithError(w, http.StatusUnauthorized, "Login was cancelled or denied", errors.New(providerErr))
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), cfg.oauthRedirectURI(provider.Name), query.Get("code"), verifier)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't verify login with "+provider.Name, err)
+		return
+	}
+	user, err := cfg.userForIdentity(r.Context(), identity)
+	if err != nil {
+		if errors.Is(err, errIdentityEmailUnverified) {
+			respondWithError(w, http.StatusForbidden, "Your "+provider.Name+" account has no verified email address", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign in", err)
+		return
+	}
+
+	accessTTL := bearerAccessTokenTTL
+	if mode == oauthModeCookie {
+		accessTTL = accessTokenTTL
+	}
+	accessToken, refreshToken, err := cfg.issueTokens(r.Context(), user.ID, accessTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+	if mode == oauthModeCookie {
+		cfg.setAccessCookie(w, accessToken)
+		cfg.setRefreshCookie(w, refreshToken)
+		http.Redirect(w, r, "/app/", http.StatusSeeOther)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		User:         *user,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	})
+}
+
+// userForIdentity returns the user the provider's account signs in as. The
+// first sign-in links it to the user with the same email address, creating a
+// passwordless one if there's none; that's only done for addresses the
+// provider has verified, or anyone could claim an account by its email.
+func (cfg *apiConfig) userForIdentity(ctx context.Context, identity oauth.Identity) (*database.User, error) {
+	user, err := cfg.db.GetUserByIdentity(ctx, identity.Provider, identity.Subject)
+	if err != nil || user != nil {
+		return user, err
+	}
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errIdentityEmailUnverified
+	}
+
+	err = cfg.db.WithTx(ctx, func(tx database.Client) error {
+		existing, err := tx.GetUserByEmail(ctx, identity.Email)
+		if err != nil {
+			return err
+		}
+		if existing.Email != "" {
+			user = &existing
+		} else if user, err = tx.CreateUser(ctx, database.CreateUserParams{Email: identity.Email}); err != nil {
+			return err
+		}
+		_, err = tx.CreateIdentity(ctx, database.CreateIdentityParams{
+			UserID:   user.ID,
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Linked %s account %s to user %s", identity.Provider, identity.Subject, user.ID)
+	return user, nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// playlistMaxVideos bounds one playlist; the detail response lists them all.
+const playlistMaxVideos = 500
+
+// playlistResponse is a playlist with its videos, URLs and all, in order.
+type playlistResponse struct {
+	database.Playlist
+	Videos []database.Video `json:"videos"`
+}
+
+// handlerPlaylistCreate: POST /api/playlists
+// {"title", "description", "visibility", "video_ids"}.
+func (cfg *apiConfig) handlerPlaylistCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       string      `json:"title"`
+		Description string      `json:"description"`
+		Visibility  string      `json:"visibility"`
+		VideoIDs    []uuid.UUID `json:"video_ids"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Visibility == "" {
+		params.Visibility = database.VisibilityPublic
+	}
+	var fieldErrors []fieldError
+	if strings.TrimSpace(params.Title) == "" {
+		fieldErrors = append(fieldErrors, fieldError{"title", "Title is required"})
+	}
+	if !validVisibility(params.Visibility) {
+		fieldErrors = append(fieldErrors, fieldError{"visibility", `Invalid visibility, expected "public" or "private"`})
+	}
+	videoErrors, err := cfg.checkPlaylistVideos(r.Context(), userID, params.VideoIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check videos", err)
+		return
+	}
+	fieldErrors = append(fieldErrors, videoErrors...)
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, fieldErrors)
+		return
+	}
+
+	playlist, err := cfg.db.CreatePlaylist(r.Context(), database.CreatePlaylistParams{
+		UserID:      userID,
+		Title:       params.Title,
+		Description: params.Description,
+		Visibility:  params.Visibility,
+	}, params.VideoIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playlist", err)
+		return
+	}
+	cfg.respondWithPlaylist(w, r, http.StatusCreated, playlist, userID)
+}
+
+// handlerPlaylistsRetrieve lists the caller's playlists, without their videos.
+func (cfg *apiConfig) handlerPlaylistsRetrieve(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	playlists, err := cfg.db.GetPlaylists(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlists", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlists)
+}
+
+// handlerPlaylistGet returns the playlist with its videos. Public playlists are
+// anyone's to see, private ones only their owner's; either way, videos the
+// viewer couldn't open on their own are left out.
+func (cfg *apiConfig) handlerPlaylistGet(w http.ResponseWriter, r *http.Request) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return
+	}
+
+	// Logging in is optional, a bad token is still an error:
+	var viewerID uuid.UUID
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		viewerID, err = cfg.tokens.ValidateJWT(token)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+	}
+
+	playlist, err := cfg.db.GetPlaylist(r.Context(), playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	if playlist.ID == uuid.Nil || (playlist.Visibility == database.VisibilityPrivate && playlist.UserID != viewerID) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get playlist", nil)
+		return
+	}
+	cfg.respondWithPlaylist(w, r, http.StatusOK, playlist, viewerID)
+}
+
+// handlerPlaylistUpdate changes any of title, description, visibility and
+// video_ids; video_ids replaces the whole list, in its order.
+func (cfg *apiConfig) handlerPlaylistUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string      `json:"title"`
+		Description *string      `json:"description"`
+		Visibility  *string      `json:"visibility"`
+		VideoIDs    *[]uuid.UUID `json:"video_ids"`
+	}
+
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	var fieldErrors []fieldError
+	if params.Title != nil {
+		if strings.TrimSpace(*params.Title) == "" {
+			fieldErrors = append(fieldErrors, fieldError{"title", "Title is required"})
+		}
+		playlist.Title = *params.Title
+	}
+	if params.Description != nil {
+		playlist.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		if !validVisibility(*params.Visibility) {
+			fieldErrors = append(fieldErrors, fieldError{"visibility", `Invalid visibility, expected "public" or "private"`})
+		}
+		playlist.Visibility = *params.Visibility
+	}
+	if params.VideoIDs != nil {
+		videoErrors, err := cfg.checkPlaylistVideos(r.Context(), playlist.UserID, *params.VideoIDs)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check videos", err)
+			return
+		}
+		fieldErrors = append(fieldErrors, videoErrors...)
+	}
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, fieldErrors)
+		return
+	}
+
+	err := cfg.db.WithTx(r.Context(), func(tx database.Client) error {
+		if err := tx.UpdatePlaylist(r.Context(), playlist); err != nil {
+			return err
+		}
+		if params.VideoIDs != nil {
+			return tx.SetPlaylistVideos(r.Context(), playlist.ID, *params.VideoIDs)
+		}
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update playlist", err)
+		return
+	}
+	playlist, err = cfg.db.GetPlaylist(r.Context(), playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	cfg.respondWithPlaylist(w, r, http.StatusOK, playlist, playlist.UserID)
+}
+
+// handlerPlaylistDelete removes the playlist; its videos stay.
+func (cfg *apiConfig) handlerPlaylistDelete(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.db.DeletePlaylist(r.Context(), playlist.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete playlist", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownedPlaylist loads the playlist named in the path for a request that must
+// come from its owner, and responds with the error if it can't.
+func (cfg *apiConfig) ownedPlaylist(w http.ResponseWriter, r *http.Request) (database.Playlist, bool) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return database.Playlist{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Playlist{}, false
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Playlist{}, false
+	}
+
+	playlist, err := cfg.db.GetPlaylist(r.Context(), playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return database.Playlist{}, false
+	}
+	if playlist.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get playlist", nil)
+		return database.Playlist{}, false
+	}
+	if playlist.UserID != userID {
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to update this playlist", nil)
+		return database.Playlist{}, false
+	}
+	return playlist, true
+}
+
+// checkPlaylistVideos validates a playlist's video list: no repeats, and only
+// videos the owner could watch themselves, their own or public approved ones.
+func (cfg *apiConfig) checkPlaylistVideos(ctx context.Context, ownerID uuid.UUID, videoIDs []uuid.UUID) ([]fieldError, error) {
+	if len(videoIDs) > playlistMaxVideos {
+		return []fieldError{{"video_ids", fmt.Sprintf("A playlist holds at most %d videos", playlistMaxVideos)}}, nil
+	}
+	seen := make(map[uuid.UUID]bool, len(videoIDs))
+	for _, id := range videoIDs {
+		if seen[id] {
+			return []fieldError{{"video_ids", fmt.Sprintf("Video %s is in the list twice", id)}}, nil
+		}
+		seen[id] = true
+
+		video, err := cfg.videos.GetVideo(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if video.ID == uuid.Nil || !visibleTo(video, ownerID) {
+			return []fieldError{{"video_ids", fmt.Sprintf("Video %s not found", id)}}, nil
+		}
+	}
+	return nil, nil
+}
+
+// respondWithPlaylist sends the playlist with the videos in it viewerID may see.
+func (cfg *apiConfig) respondWithPlaylist(w http.ResponseWriter, r *http.Request, status int, playlist database.Playlist, viewerID uuid.UUID) {
+	videos, err := cfg.db.GetPlaylistVideos(r.Context(), playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist videos", err)
+		return
+	}
+	visible := []database.Video{}
+	for _, video := range videos {
+		if visibleTo(video, viewerID) {
+			// Play from the replica while the primary bucket is down:
+			visible = append(visible, cfg.withReplicaFallback(r.Context(), video))
+		}
+	}
+	cfg.markLiked(r.Context(), viewerID, visible)
+	cfg.signPlaybackURLs(r.Context(), visible)
+	respondWithJSON(w, status, playlistResponse{Playlist: playlist, Videos: visible})
+}
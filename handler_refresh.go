@@ -2,7 +2,6 @@ package main
 
 import (
 	"net/http"
-	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 )
@@ -12,27 +11,33 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		Token string `json:"token"`
 	}
 
-	refreshToken, err := auth.GetBearerToken(r.Header)
+	// Cookie sessions send the refresh token as a cookie and get the new access
+	// token back the same way:
+	refreshToken, fromCookie, err := refreshTokenFromRequest(r)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Couldn't find token", err)
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	user, err := cfg.db.GetUserByRefreshToken(r.Context(), refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(
+	accessToken, err := cfg.tokens.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
+		accessTokenTTL,
 	)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
 		return
 	}
+	if fromCookie {
+		cfg.setAccessCookie(w, accessToken)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 
 	respondWithJSON(w, http.StatusOK, response{
 		Token: accessToken,
@@ -46,7 +51,7 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(refreshToken)
+	err = cfg.db.RevokeRefreshToken(r.Context(), refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
 		return
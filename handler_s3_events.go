@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3EventsBodyLimit caps webhook bodies; real notifications are a few KB:
+const s3EventsBodyLimit = 256 << 10
+
+// s3EventSignatureHeader carries the hex HMAC-SHA256 of the body for direct HTTP
+// deliveries (e.g. from an EventBridge API destination or a Lambda forwarder):
+const s3EventSignatureHeader = "X-Tubely-Signature"
+
+// snsCertHost is the only place we'll fetch SNS signing certificates from:
+var snsCertHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// snsCerts caches signing certificates by URL; SNS rotates them rarely.
+var snsCerts sync.Map
+
+// ingestsInFlight holds the keys being ingested right now, since S3 may deliver
+// the same event more than once:
+var ingestsInFlight sync.Map
+
+// snsMessage is the envelope SNS POSTs to HTTP subscribers:
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageId        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+}
+
+// s3EventNotification is the S3 event payload, trimmed to what we use:
+type s3EventNotification struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key  string `json:"key"`
+				Size int64  `json:"size"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// handlerS3Events receives S3 event notifications, either wrapped by SNS (and
+// verified against the SNS signing certificate) or POSTed directly with an HMAC
+// signature. ObjectCreated events under uploads/ start processing of the
+// presigned direct upload they belong to.
+func (cfg *apiConfig) handlerS3Events(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s3EventsBodyLimit))
+	if err != nil {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Couldn't read body", err)
+		return
+	}
+
+	var notification s3EventNotification
+	if r.Header.Get("X-Amz-Sns-Message-Type") != "" {
+		var msg snsMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode SNS message", err)
+			return
+		}
+		if cfg.s3EventsTopicARN == "" || msg.TopicArn != cfg.s3EventsTopicARN {
+			respondWithError(w, http.StatusForbidden, "Unexpected SNS topic", nil)
+			return
+		}
+		if err := verifySNSMessage(r.Context(), msg); err != nil {
+			respondWithError(w, http.StatusForbidden, "Invalid SNS signature", err)
+			return
+		}
+		switch msg.Type {
+		case "SubscriptionConfirmation":
+			if err := confirmSNSSubscription(r.Context(), msg.SubscribeURL); err != nil {
+				respondWithError(w, http.StatusBadGateway, "Couldn't confirm subscription", err)
+				return
+			}
+			log.Printf("Confirmed SNS subscription to %s", msg.TopicArn)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case "Notification":
+		default:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err := json.Unmarshal([]byte(msg.Message), &notification); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode S3 event", err)
+			return
+		}
+	} else {
+		if cfg.s3EventsSecret == "" || !validHMAC(body, r.Header.Get(s3EventSignatureHeader), cfg.s3EventsSecret) {
+			respondWithError(w, http.StatusForbidden, "Invalid signature", nil)
+			return
+		}
+		if err := json.Unmarshal(body, &notification); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode S3 event", err)
+			return
+		}
+	}
+
+	for _, record := range notification.Records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") || record.S3.Bucket.Name != cfg.s3Bucket {
+			continue
+		}
+		// Keys arrive form-encoded ("my video.mp4" is "my+video.mp4"):
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil || !strings.HasPrefix(key, directUploadPrefix) {
+			continue
+		}
+		if _, busy := ingestsInFlight.LoadOrStore(key, struct{}{}); busy {
+			continue
+		}
+		size := record.S3.Object.Size
+		go func() {
+			defer ingestsInFlight.Delete(key)
+			if err := cfg.ingestDirectUpload(context.WithoutCancel(r.Context()), key, size); err != nil {
+				log.Printf("Couldn't ingest direct upload %s: %v", key, err)
+			}
+		}()
+	}
+
+	// Acknowledge right away; SNS retries anything slower than a few seconds:
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func validHMAC(body []byte, signature, secret string) bool {
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// verifySNSMessage checks the message signature against the certificate SNS
+// published it with, per
+// https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html
+func verifySNSMessage(ctx context.Context, msg snsMessage) error {
+	var hash crypto.Hash
+	switch msg.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("unsupported signature version %q", msg.SignatureVersion)
+	}
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return err
+	}
+	cert, err := snsCertificate(ctx, msg.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signing certificate doesn't hold an RSA key")
+	}
+
+	// The signed string is "Name\nvalue\n" for a fixed list of fields, in order:
+	var fields []string
+	switch msg.Type {
+	case "Notification":
+		fields = []string{"Message", msg.Message, "MessageId", msg.MessageId}
+		if msg.Subject != "" {
+			fields = append(fields, "Subject", msg.Subject)
+		}
+		fields = append(fields, "Timestamp", msg.Timestamp, "TopicArn", msg.TopicArn, "Type", msg.Type)
+	case "SubscriptionConfirmation", "UnsubscribeConfirmation":
+		fields = []string{
+			"Message", msg.Message,
+			"MessageId", msg.MessageId,
+			"SubscribeURL", msg.SubscribeURL,
+			"Timestamp", msg.Timestamp,
+			"Token", msg.Token,
+			"TopicArn", msg.TopicArn,
+			"Type", msg.Type,
+		}
+	default:
+		return fmt.Errorf("unknown message type %q", msg.Type)
+	}
+	var stringToSign strings.Builder
+	for _, f := range fields {
+		stringToSign.WriteString(f)
+		stringToSign.WriteString("\n")
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(stringToSign.String()))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(stringToSign.String()))
+		digest = sum[:]
+	}
+	return rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+}
+
+// snsCertificate fetches (and caches) a signing certificate, refusing URLs that
+// don't point at SNS itself, so a forged message can't bring its own cert.
+func snsCertificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	if cached, ok := snsCerts.Load(certURL); ok {
+		return cached.(*x509.Certificate), nil
+	}
+	u, err := url.Parse(certURL)
+	if err != nil || u.Scheme != "https" || !snsCertHost.MatchString(u.Hostname()) || !strings.HasSuffix(u.Path, ".pem") {
+		return nil, fmt.Errorf("untrusted signing certificate URL %q", certURL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching signing certificate: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing certificate isn't PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	snsCerts.Store(certURL, cert)
+	return cert, nil
+}
+
+// confirmSNSSubscription visits the SubscribeURL, which is how an HTTP endpoint
+// accepts an SNS subscription. The URL must point at SNS, like the cert URL.
+func confirmSNSSubscription(ctx context.Context, subscribeURL string) error {
+	u, err := url.Parse(subscribeURL)
+	if err != nil || u.Scheme != "https" || !snsCertHost.MatchString(u.Hostname()) {
+		return fmt.Errorf("untrusted SubscribeURL %q", subscribeURL)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, subscribeURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("confirming subscription: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// sourceURLTTL is how long the presigned URL ffmpeg reads the source through
+// stays valid. Seeking to one frame takes a few range requests, so it's short:
+const sourceURLTTL = 10 * time.Minute
+
+// handlerThumbnailFromFrame replaces the thumbnail with a frame of the uploaded
+// video, so creators can pick their cover after the upload. The body is
+// {"timestamp_seconds": 12.5}.
+func (cfg *apiConfig) handlerThumbnailFromFrame(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		TimestampSeconds *float64 `json:"timestamp_seconds"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<10)
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.TimestampSeconds == nil || *params.TimestampSeconds < 0 {
+		respondWithFieldErrors(w, []fieldError{{"timestamp_seconds", "Required, and can't be negative"}})
+		return
+	}
+	timestamp := time.Duration(*params.TimestampSeconds * float64(time.Second))
+
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to update this video", nil)
+		return
+	}
+	if video.VideoURL == nil || video.MediaKind != mediaKindVideo {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video has no uploaded video content", nil)
+		return
+	}
+	obj, err := cfg.db.GetVideoObject(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if obj.ObjectKey == "" {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video has no uploaded video content", nil)
+		return
+	}
+	// An archived object can't be read until it's restored; this starts the restore:
+	if status, err := cfg.playbackStatus(r.Context(), video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video availability", err)
+		return
+	} else if status == playbackRestoring {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video is being restored from archive, try again later", nil)
+		return
+	}
+
+	source, cleanup, err := cfg.openVideoSource(r.Context(), obj.ObjectKey)
+	if err != nil {
+		respondWithPipelineError(w, storageError(http.StatusBadGateway, "Couldn't read video from storage", err))
+		return
+	}
+	defer cleanup()
+
+	// Catch a timestamp past the end here, ffmpeg would just write nothing:
+	// The probe is cached under the stored file's hash, so repeat requests skip ffprobe:
+	processedHash, err := cfg.db.GetVideoProcessedHash(r.Context(), video.ID)
+	if err != nil {
+		log.Printf("Couldn't get processed checksum of video %s: %v", video.ID, err)
+	}
+	var duration time.Duration
+	probe, err := cfg.probeFile(r.Context(), source, processedHash)
+	if err == nil {
+		duration, err = durationFromProbe(probe)
+	}
+	if err == nil && timestamp >= duration {
+		respondWithFieldErrors(w, []fieldError{{"timestamp_seconds", fmt.Sprintf("Must be less than the video's duration (%.2fs)", duration.Seconds())}})
+		return
+	}
+
+	assetPath := getAssetPath("image/jpeg")
+	assetDiskPath := cfg.getAssetDiskPath(assetPath)
+	if err := cfg.transcoder.ExtractFrame(r.Context(), source, timestamp, assetDiskPath); err != nil {
+		os.Remove(assetDiskPath)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't extract frame", err)
+		return
+	}
+
+	url := cfg.getAssetURL(assetPath)
+	video, err = cfg.updateVideo(r.Context(), video, func(v *database.Video) {
+		v.ThumbnailURL = &url
+	})
+	if err != nil {
+		os.Remove(assetDiskPath)
+		respondWithPipelineError(w, videoUpdateError(err))
+		return
+	}
+	video = cfg.makeThumbnailVariants(r.Context(), video, assetDiskPath)
+	// The new thumbnail is screened like an uploaded one:
+	if image, err := os.ReadFile(assetDiskPath); err == nil {
+		cfg.scheduleModeration(video.ID, func(ctx context.Context) (moderation.Result, error) {
+			return cfg.moderator.ModerateImage(ctx, image)
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// openVideoSource returns something ffmpeg can read the stored object from, and a
+// cleanup function to call when done. Local stores give the file itself; S3 gives
+// a presigned URL, so ffmpeg range-reads just the part around the frame instead
+// of the whole video. Any other store is copied to a temp file.
+func (cfg *apiConfig) openVideoSource(ctx context.Context, key string) (source string, cleanup func(), err error) {
+	if files, ok := cfg.store.(storage.FileStore); ok {
+		path, err := files.FilePath(key)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return "", nil, err
+		}
+		return path, func() {}, nil
+	}
+	if presigner, ok := cfg.store.(storage.GetPresigner); ok {
+		url, err := presigner.PresignGet(ctx, key, sourceURLTTL)
+		if err != nil {
+			return "", nil, err
+		}
+		return url, func() {}, nil
+	}
+
+	body, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	defer body.Close()
+	tmp, err := os.CreateTemp(cfg.uploadTmpDir, "tubely-source-")
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := bufpool.Copy(tmp, body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", nil, err
+	}
+	return tmp.Name(), func() { os.Remove(tmp.Name()) }, nil
+}
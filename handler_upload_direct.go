@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/flags"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/upload"
+	"github.com/google/uuid"
+)
+
+// directUploadPrefix is where clients PUT raw files. Keys look like
+// uploads/<videoID>/<random>.<ext>; the S3 event for the object carries the key,
+// which is all the webhook needs to find the video again.
+const directUploadPrefix = "uploads/"
+
+// directUploadLimit caps direct uploads where the upload method can enforce it
+// (POST policies), matching the API's own upload limit:
+const directUploadLimit = 1 << 30
+
+// handlerDirectUploadURL hands the owner a presigned URL to PUT the raw video
+// straight to S3. Processing starts when S3 reports the new object to
+// handlerS3Events, so the client doesn't have to call back when it's done.
+func (cfg *apiConfig) handlerDirectUploadURL(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		UploadURL   string    `json:"upload_url"`
+		Key         string    `json:"key"`
+		ContentType string    `json:"content_type"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}
+
+	key, contentType, ok := cfg.directUploadTarget(w, r)
+	if !ok {
+		return
+	}
+	presigner, ok := cfg.store.(storage.Presigner)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Storage backend doesn't support direct uploads", nil)
+		return
+	}
+	expiresAt := time.Now().Add(cfg.signedURLs.uploadTTL).UTC()
+	uploadURL, err := presigner.PresignPut(r.Context(), key, contentType, cfg.signedURLs.uploadTTL+cfg.signedURLs.clockSkew)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		UploadURL:   uploadURL,
+		Key:         key,
+		ContentType: contentType,
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// handlerDirectUploadPolicy is handlerDirectUploadURL for browsers: it returns a
+// signed POST policy, so a plain HTML form can upload to the bucket. The form
+// posts to url with every one of fields, then the file as "file". S3 itself
+// rejects files of another Content-Type or over max_size. The upload is picked
+// up from the S3 event like a PUT.
+func (cfg *apiConfig) handlerDirectUploadPolicy(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		URL         string            `json:"url"`
+		Fields      map[string]string `json:"fields"`
+		Key         string            `json:"key"`
+		ContentType string            `json:"content_type"`
+		MaxSize     int64             `json:"max_size"`
+		ExpiresAt   time.Time         `json:"expires_at"`
+	}
+
+	key, contentType, ok := cfg.directUploadTarget(w, r)
+	if !ok {
+		return
+	}
+	presigner, ok := cfg.store.(storage.PostPresigner)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Storage backend doesn't support direct uploads", nil)
+		return
+	}
+	expiresAt := time.Now().Add(cfg.signedURLs.uploadTTL).UTC()
+	policy, err := presigner.PresignPost(r.Context(), key, contentType, directUploadLimit, cfg.signedURLs.uploadTTL+cfg.signedURLs.clockSkew)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign upload policy", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		URL:         policy.URL,
+		Fields:      policy.Fields,
+		Key:         key,
+		ContentType: contentType,
+		MaxSize:     directUploadLimit,
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// directUploadTarget checks a direct upload request, {"content_type": ...} from
+// the video's owner, and picks the key to upload to. It has responded when ok is
+// false.
+func (cfg *apiConfig) directUploadTarget(w http.ResponseWriter, r *http.Request) (key, contentType string, ok bool) {
+	type parameters struct {
+		ContentType string `json:"content_type"`
+	}
+
+	if !cfg.live().Flags.Enabled(flags.EnableDirectUploads) {
+		respondWithCode(w, http.StatusForbidden, codeFeatureDisabled, "Direct uploads are disabled", nil)
+		return "", "", false
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return "", "", false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return "", "", false
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return "", "", false
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return "", "", false
+	}
+	if !isAllowedUploadType(params.ContentType) {
+		respondWithFieldErrors(w, []fieldError{{"content_type", "Invalid file type, only MP4 video or MP3, M4A and Ogg audio are allowed"}})
+		return "", "", false
+	}
+
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return "", "", false
+	}
+	if video.UserID != userID {
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to update this video", nil)
+		return "", "", false
+	}
+
+	return path.Join(directUploadPrefix, videoID.String(), getAssetPath(params.ContentType)), params.ContentType, true
+}
+
+// parseDirectUploadKey pulls the video ID and media type back out of a key made
+// by handlerDirectUploadURL:
+func parseDirectUploadKey(key string) (uuid.UUID, string, error) {
+	rest, ok := strings.CutPrefix(key, directUploadPrefix)
+	if !ok {
+		return uuid.Nil, "", fmt.Errorf("key %q is outside %s", key, directUploadPrefix)
+	}
+	idString, name, ok := strings.Cut(rest, "/")
+	if !ok || strings.Contains(name, "/") {
+		return uuid.Nil, "", fmt.Errorf("malformed upload key %q", key)
+	}
+	videoID, err := uuid.Parse(idString)
+	if err != nil {
+		return uuid.Nil, "", fmt.Errorf("malformed upload key %q: %w", key, err)
+	}
+	for mediaType := range audioFormats {
+		if path.Ext(name) == mediaTypeToExt(mediaType) {
+			return videoID, mediaType, nil
+		}
+	}
+	if path.Ext(name) == ".mp4" {
+		return videoID, "video/mp4", nil
+	}
+	return uuid.Nil, "", fmt.Errorf("unsupported upload type %q", key)
+}
+
+// ingestDirectUpload copies a raw upload from the bucket to local disk, runs the
+// normal pipeline on it and removes the raw object. It runs detached from the
+// webhook request, which has to be acknowledged quickly.
+func (cfg *apiConfig) ingestDirectUpload(ctx context.Context, key string, size int64) error {
+	videoID, mediaType, err := parseDirectUploadKey(key)
+	if err != nil {
+		return err
+	}
+	video, err := cfg.videos.GetVideo(ctx, videoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		// The video was deleted while the upload was in flight:
+		return cfg.store.Delete(ctx, key)
+	}
+
+	// Label the raw object (PUT or multipart) with its owner while it waits:
+	if tagger, ok := cfg.store.(storage.Tagger); ok {
+		if err := tagger.SetTags(ctx, key, videoObjectTags(video, contentKindUpload)); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Couldn't tag raw upload %s: %v", key, err)
+		}
+	}
+
+	if err := cfg.checkUploadSpace(size); err != nil {
+		return err
+	}
+	body, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			// Already ingested by an earlier delivery of the same event:
+			return nil
+		}
+		return err
+	}
+	defer body.Close()
+	cfg.recordBandwidth(ctx, video.UserID, size, 0)
+
+	// The presigned PUT carries no file name, so there's none to keep:
+	if _, err := cfg.uploads().Upload(ctx, video, upload.Source{Body: body, ContentType: mediaType}); err != nil {
+		return err
+	}
+	if err := cfg.store.Delete(ctx, key); err != nil {
+		log.Printf("Couldn't delete raw upload %s: %v", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/upload"
+	"github.com/google/uuid"
+)
+
+// Limits for server-side fetches. The size cap matches the multipart upload limit:
+const (
+	urlImportLimit   = 1 << 30
+	urlImportTimeout = 10 * time.Minute
+)
+
+// errBlockedAddress is returned when a URL resolves to an address we refuse to fetch:
+var errBlockedAddress = errors.New("destination address is not allowed")
+
+// importHTTPClient fetches remote videos. Its dialer refuses loopback, private and
+// link-local addresses (checked after DNS resolution, so redirects and rebinding
+// can't sneak past) to keep the endpoint from being used to probe our network.
+var importHTTPClient = &http.Client{
+	Timeout: urlImportTimeout,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+					return errBlockedAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// handlerUploadVideoFromURL downloads a video from a URL supplied by the owner and
+// runs it through the same pipeline as a multipart upload. Without a URL, it
+// uses the one the video was bulk imported with.
+func (cfg *apiConfig) handlerUploadVideoFromURL(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL string `json:"url"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to update this video", nil)
+		return
+	}
+	if params.URL == "" {
+		params.URL, err = cfg.db.GetVideoSourceURL(r.Context(), video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+			return
+		}
+	}
+	sourceURL, err := url.Parse(params.URL)
+	if err != nil || (sourceURL.Scheme != "http" && sourceURL.Scheme != "https") || sourceURL.Host == "" {
+		respondWithFieldErrors(w, []fieldError{{"url", "url must be an absolute http or https URL"}})
+		return
+	}
+	// Fetching counts as uploading; a failed fetch puts the video back how it was:
+	settle, err := cfg.beginVideoStatus(r.Context(), video.ID, database.StatusUploading, database.StatusDraft)
+	if err != nil {
+		respondWithPipelineError(w, videoStatusError(err))
+		return
+	}
+	defer settle()
+	cfg.hooks.UploadStarted(r.Context(), video)
+
+	staged, err := cfg.downloadVideo(r.Context(), sourceURL.String())
+	if err != nil {
+		respondWithPipelineError(w, err)
+		return
+	}
+	defer os.Remove(staged.Path)
+	cfg.recordBandwidth(r.Context(), video.UserID, staged.Size, 0)
+
+	// Name it after the last path segment of the URL, as a browser download would:
+	staged.Filename = path.Base(sourceURL.Path)
+	video, err = cfg.uploads().Run(r.Context(), video, staged)
+	if err != nil {
+		respondWithPipelineError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// downloadVideo streams the remote file to a staged file for the upload
+// pipeline, enforcing the size limit and checking the Content-Type before a
+// single byte hits the disk. The caller must remove the staged file.
+func (cfg *apiConfig) downloadVideo(ctx context.Context, sourceURL string) (upload.Staged, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return upload.Staged{}, &pipelineError{http.StatusBadRequest, codeInvalidURL, "Invalid url", err}
+	}
+	resp, err := importHTTPClient.Do(req)
+	if err != nil {
+		if errors.Is(err, errBlockedAddress) {
+			return upload.Staged{}, &pipelineError{http.StatusBadRequest, codeInvalidURL, "url points to a disallowed address", err}
+		}
+		return upload.Staged{}, &pipelineError{http.StatusBadGateway, codeUpstreamFailed, "Couldn't fetch url", err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return upload.Staged{}, &pipelineError{http.StatusBadGateway, codeUpstreamFailed, fmt.Sprintf("Remote server responded with %s", resp.Status), nil}
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return upload.Staged{}, &pipelineError{http.StatusBadRequest, codeInvalidMIME, "Remote file has an invalid Content-Type", err}
+	}
+	if !isAllowedUploadType(mediaType) {
+		return upload.Staged{}, &pipelineError{http.StatusBadRequest, codeInvalidMIME, "Invalid file type, only MP4 video or MP3, M4A and Ogg audio are allowed", nil}
+	}
+	// Reject early when the server tells us the size up front:
+	if resp.ContentLength > urlImportLimit {
+		return upload.Staged{}, &pipelineError{http.StatusRequestEntityTooLarge, codeFileTooLarge, "Remote file is too large", nil}
+	}
+
+	if err := cfg.checkUploadSpace(resp.ContentLength); err != nil {
+		if errors.Is(err, errInsufficientStorage) {
+			return upload.Staged{}, &pipelineError{http.StatusInsufficientStorage, codeInsufficientStorage, "Not enough temporary storage for this upload, try again later", err}
+		}
+		return upload.Staged{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't check temporary storage", err}
+	}
+
+	// Read one byte past the limit so we can tell "exactly at the limit" from "over":
+	staged, err := cfg.uploads().Stage(ctx, upload.Source{
+		Body:        io.LimitReader(resp.Body, urlImportLimit+1),
+		ContentType: mediaType,
+	})
+	if err != nil {
+		var pe *pipelineError
+		if errors.As(err, &pe) {
+			return upload.Staged{}, err
+		}
+		return upload.Staged{}, &pipelineError{http.StatusBadGateway, codeUpstreamFailed, "Couldn't download url", err}
+	}
+	if staged.Size > urlImportLimit {
+		os.Remove(staged.Path)
+		return upload.Staged{}, &pipelineError{http.StatusRequestEntityTooLarge, codeFileTooLarge, "Remote file is too large", nil}
+	}
+	return staged, nil
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/upload"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads follow the tus 1.0 core protocol (https://tus.io): POST
+// creates an upload, HEAD reports how much arrived, PATCH appends from that
+// offset, DELETE cancels (the termination extension). Their state is in the
+// uploads table and their bytes are in files under the staging directory, so an
+// interrupted upload resumes even across a restart. A client can pause for as
+// long as it likes up to UPLOAD_TTL after its last PATCH; after that the upload
+// expires and its file is removed (the expiration extension). A PATCH may carry
+// the chunk's checksum, which it has to match to be kept (the checksum
+// extension), so a chunk corrupted on the way is sent again on its own.
+const (
+	tusVersion = "1.0.0"
+	// tusChecksumAlgorithms are the Upload-Checksum algorithms taken, see
+	// uploadChecksumAlgorithms:
+	tusChecksumAlgorithms = "crc32c,sha256"
+	// statusChecksumMismatch is the tus status for a chunk that doesn't match
+	// its Upload-Checksum:
+	statusChecksumMismatch = 460
+	// resumableUploadLimit matches the multipart upload limit:
+	resumableUploadLimit = 1 << 30
+	// resumableStagingDir is the directory under UPLOAD_TMP_DIR holding the files:
+	resumableStagingDir = "resumable"
+	// uploadExpiryInterval is how often expired uploads are looked for:
+	uploadExpiryInterval = time.Hour
+)
+
+// uploadsInFlight holds the IDs of uploads a PATCH is writing to right now; a
+// second PATCH for the same upload would interleave bytes.
+var uploadsInFlight sync.Map
+
+func (cfg *apiConfig) resumableStagingPath() string {
+	return filepath.Join(cfg.uploadTmpDir, resumableStagingDir)
+}
+
+// handlerUploadCreate starts a resumable upload of the video's file. The size
+// comes from Upload-Length and the media type from the "filetype" key of
+// Upload-Metadata, as tus clients send them.
+func (cfg *apiConfig) handlerUploadCreate(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to update this video", nil)
+		return
+	}
+
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Upload-Length must be a positive number of bytes", err)
+		return
+	}
+	if size > resumableUploadLimit {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Video is too large", nil)
+		return
+	}
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+	mediaType := metadata["filetype"]
+	if !isAllowedUploadType(mediaType) {
+		respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Invalid file type, only MP4 video or MP3, M4A and Ogg audio are allowed", nil)
+		return
+	}
+	if err := cfg.checkUploadSpace(size); err != nil {
+		respondWithSpaceError(w, err)
+		return
+	}
+
+	// The video stays uploading until the upload completes, is canceled or expires:
+	if err := cfg.videos.SetVideoStatus(r.Context(), videoID, database.StatusUploading); err != nil {
+		respondWithPipelineError(w, videoStatusError(err))
+		return
+	}
+	created := false
+	defer func() {
+		if !created {
+			cfg.settleUploadStatus(r.Context(), videoID)
+		}
+	}()
+
+	id := uuid.New()
+	tempPath := filepath.Join(cfg.resumableStagingPath(), id.String()+".part")
+	f, err := os.OpenFile(tempPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not create temp file", err)
+		return
+	}
+	f.Close()
+
+	upload, err := cfg.db.CreateUpload(r.Context(), database.CreateUploadParams{
+		ID:        id,
+		VideoID:   videoID,
+		UserID:    userID,
+		MediaType: mediaType,
+		SizeBytes: size,
+		TempPath:  tempPath,
+		Metadata:  r.Header.Get("Upload-Metadata"),
+	})
+	if err != nil {
+		os.Remove(tempPath)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+	created = true
+	cfg.hooks.UploadStarted(r.Context(), video)
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Checksum-Algorithm", tusChecksumAlgorithms)
+	w.Header().Set("Location", "/api/uploads/"+upload.ID.String())
+	w.Header().Set("Upload-Offset", "0")
+	cfg.setUploadExpires(w, upload.UpdatedAt)
+	respondWithJSON(w, http.StatusCreated, upload)
+}
+
+// getOwnUpload loads the upload named in the path for its owner. It writes the
+// error response itself, so callers just return when ok is false.
+func (cfg *apiConfig) getOwnUpload(w http.ResponseWriter, r *http.Request) (upload database.Upload, ok bool) {
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Upload{}, false
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Upload{}, false
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Upload{}, false
+	}
+
+	upload, err = cfg.db.GetUpload(r.Context(), uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return database.Upload{}, false
+	}
+	// Someone else's upload looks the same as a missing one:
+	if upload.ID == uuid.Nil || upload.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find upload", nil)
+		return database.Upload{}, false
+	}
+	// The sweep may not have got to it yet:
+	if cfg.uploadExpired(upload) {
+		if _, busy := uploadsInFlight.LoadOrStore(upload.ID, struct{}{}); !busy {
+			cfg.discardUpload(r.Context(), upload)
+			uploadsInFlight.Delete(upload.ID)
+			respondWithError(w, http.StatusGone, "Upload expired", nil)
+			return database.Upload{}, false
+		}
+	}
+	return upload, true
+}
+
+// uploadExpired reports whether the upload has sat untouched for longer than
+// UPLOAD_TTL:
+func (cfg *apiConfig) uploadExpired(upload database.Upload) bool {
+	return cfg.uploadTTL > 0 && time.Since(upload.UpdatedAt) > cfg.uploadTTL
+}
+
+// setUploadExpires tells the client how long it may pause, counting from the
+// last write to the upload:
+func (cfg *apiConfig) setUploadExpires(w http.ResponseWriter, touched time.Time) {
+	if cfg.uploadTTL > 0 {
+		w.Header().Set("Upload-Expires", touched.Add(cfg.uploadTTL).UTC().Format(http.TimeFormat))
+	}
+}
+
+// handlerUploadHead tells a client where to resume:
+func (cfg *apiConfig) handlerUploadHead(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.getOwnUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Checksum-Algorithm", tusChecksumAlgorithms)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.OffsetBytes, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.SizeBytes, 10))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	cfg.setUploadExpires(w, upload.UpdatedAt)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlerUploadCancel abandons an upload: its file and row are removed. Nothing
+// reaches storage or the video record until the last chunk arrives, so the video
+// keeps whatever file it had before the upload started.
+func (cfg *apiConfig) handlerUploadCancel(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.getOwnUpload(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	// A PATCH still writing (or processing the finished file) owns the upload:
+	if _, busy := uploadsInFlight.LoadOrStore(upload.ID, struct{}{}); busy {
+		respondWithError(w, http.StatusConflict, "Another request is writing to this upload", nil)
+		return
+	}
+	defer uploadsInFlight.Delete(upload.ID)
+
+	if err := cfg.discardUpload(r.Context(), upload); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't cancel upload", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// discardUpload removes an unfinished upload's file and row. The caller must
+// hold the upload's uploadsInFlight entry.
+func (cfg *apiConfig) discardUpload(ctx context.Context, upload database.Upload) error {
+	if err := os.Remove(upload.TempPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := cfg.db.DeleteUpload(context.WithoutCancel(ctx), upload.ID); err != nil {
+		return err
+	}
+	cfg.settleUploadStatus(ctx, upload.VideoID)
+	return nil
+}
+
+// settleUploadStatus ends the video's uploading status after an upload that
+// never reached processing: back to ready if it has an older file, else draft.
+func (cfg *apiConfig) settleUploadStatus(ctx context.Context, videoID uuid.UUID) {
+	err := cfg.videos.SettleVideoStatus(context.WithoutCancel(ctx), videoID, database.StatusUploading, database.StatusDraft)
+	if err != nil {
+		log.Printf("Couldn't settle status of video %s: %v", videoID, err)
+	}
+}
+
+// startUploadExpiry removes uploads left untouched for longer than UPLOAD_TTL,
+// every uploadExpiryInterval until ctx is done:
+func (cfg *apiConfig) startUploadExpiry(ctx context.Context) {
+	if cfg.uploadTTL <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(uploadExpiryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				cfg.expireUploads(ctx)
+			}
+		}
+	}()
+}
+
+// expireUploads removes the uploads left untouched for longer than UPLOAD_TTL:
+func (cfg *apiConfig) expireUploads(ctx context.Context) {
+	stale, err := cfg.db.GetStaleUploads(ctx, time.Now().Add(-cfg.uploadTTL))
+	if err != nil {
+		log.Printf("Upload expiry sweep failed: %v", err)
+		return
+	}
+	expired := 0
+	for _, upload := range stale {
+		// A PATCH in progress only touches the row when it ends:
+		if _, busy := uploadsInFlight.LoadOrStore(upload.ID, struct{}{}); busy {
+			continue
+		}
+		if err := cfg.discardUpload(ctx, upload); err != nil {
+			log.Printf("Couldn't remove expired upload %s: %v", upload.ID, err)
+		} else {
+			expired++
+		}
+		uploadsInFlight.Delete(upload.ID)
+	}
+	if expired > 0 {
+		log.Printf("Removed %d expired resumable uploads", expired)
+	}
+}
+
+// handlerUploadPatch appends the body at Upload-Offset. Whatever arrives is kept,
+// even if the connection drops halfway, so the client can resume from there. The
+// request that completes the file also runs it through the processing pipeline
+// and returns the updated video.
+func (cfg *apiConfig) handlerUploadPatch(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.getOwnUpload(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+	checksum, err := parseUploadChecksum(r.Header.Get("Upload-Checksum"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Checksum", err)
+		return
+	}
+
+	if _, busy := uploadsInFlight.LoadOrStore(upload.ID, struct{}{}); busy {
+		respondWithError(w, http.StatusConflict, "Another request is writing to this upload", nil)
+		return
+	}
+	defer uploadsInFlight.Delete(upload.ID)
+
+	// Re-read under the lock; a PATCH that just finished may have moved the offset:
+	upload, err = cfg.db.GetUpload(r.Context(), upload.ID)
+	if err != nil || upload.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find upload", err)
+		return
+	}
+	if offset != upload.OffsetBytes {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.OffsetBytes, 10))
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Upload-Offset must be %d", upload.OffsetBytes), nil)
+		return
+	}
+
+	written, err := cfg.appendUploadChunk(upload, r.Body, checksum)
+	newOffset := upload.OffsetBytes + written
+	if dbErr := cfg.db.SetUploadOffset(r.Context(), upload.ID, newOffset); dbErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload progress", dbErr)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	cfg.recordBandwidth(r.Context(), upload.UserID, written, 0)
+	if err != nil {
+		if errors.Is(err, errUploadOverflow) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Body runs past Upload-Length", err)
+			return
+		}
+		if errors.Is(err, errChecksumMismatch) {
+			respondWithCode(w, statusChecksumMismatch, codeChecksumMismatch, "Chunk doesn't match Upload-Checksum, send it again", err)
+			return
+		}
+		// What did arrive is kept, unless the chunk had a checksum; the client
+		// can resume from Upload-Offset:
+		if respondIfUploadTooSlow(w, err) {
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Could not write file to disk", err)
+		return
+	}
+	if newOffset < upload.SizeBytes {
+		cfg.setUploadExpires(w, time.Now())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	upload.OffsetBytes = newOffset
+	// That was the last chunk; processing gets its own, longer deadline:
+	cfg.extendForProcessing(w)
+	video, err := cfg.finishUpload(r.Context(), upload)
+	if err != nil {
+		respondWithPipelineError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+var (
+	errUploadOverflow   = errors.New("upload is longer than its declared length")
+	errChecksumMismatch = errors.New("chunk doesn't match its checksum")
+)
+
+// uploadChecksumAlgorithms are the Upload-Checksum algorithms, by tus name:
+var uploadChecksumAlgorithms = map[string]func() hash.Hash{
+	"crc32c": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"sha256": sha256.New,
+}
+
+// chunkChecksum is a chunk's Upload-Checksum: what hash has to sum to.
+type chunkChecksum struct {
+	hash hash.Hash
+	want []byte
+}
+
+// parseUploadChecksum parses "<algorithm> <base64 checksum>"; a PATCH without
+// one has a nil checksum.
+func parseUploadChecksum(header string) (*chunkChecksum, error) {
+	if header == "" {
+		return nil, nil
+	}
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return nil, errors.New("expected <algorithm> <base64 checksum>")
+	}
+	newHash, ok := uploadChecksumAlgorithms[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q, expected one of %s", algorithm, tusChecksumAlgorithms)
+	}
+	want, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("checksum isn't valid base64: %w", err)
+	}
+	checksum := &chunkChecksum{hash: newHash(), want: want}
+	if len(want) != checksum.hash.Size() {
+		return nil, fmt.Errorf("%s checksums are %d bytes, got %d", algorithm, checksum.hash.Size(), len(want))
+	}
+	return checksum, nil
+}
+
+// appendUploadChunk writes body to the upload's file at its offset, stopping at
+// the declared size. It returns how many bytes are safely on disk, even when it
+// also returns an error. A chunk with a checksum is all or nothing: one that's
+// cut short or doesn't match is cut off the file again.
+func (cfg *apiConfig) appendUploadChunk(upload database.Upload, body io.Reader, checksum *chunkChecksum) (int64, error) {
+	f, err := os.OpenFile(upload.TempPath, os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(upload.OffsetBytes, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	remaining := upload.SizeBytes - upload.OffsetBytes
+	chunk := io.LimitReader(body, remaining)
+	if checksum != nil {
+		chunk = io.TeeReader(chunk, checksum.hash)
+	}
+	written, err := bufpool.Copy(cfg.tempCipher.writer(f, upload.OffsetBytes), chunk)
+	if err == nil && written == remaining {
+		if extra, _ := io.CopyN(io.Discard, body, 1); extra > 0 {
+			err = errUploadOverflow
+		}
+	}
+	if checksum != nil && (err != nil || !bytes.Equal(checksum.hash.Sum(nil), checksum.want)) {
+		if err == nil {
+			err = errChecksumMismatch
+		}
+		if truncErr := f.Truncate(upload.OffsetBytes); truncErr != nil {
+			return 0, truncErr
+		}
+		written = 0
+	}
+	// Flush before the caller records the new offset, so the offset in the
+	// database never runs ahead of the file:
+	if syncErr := f.Sync(); syncErr != nil && err == nil {
+		return 0, syncErr
+	}
+	return written, err
+}
+
+// finishUpload runs a complete upload through the processing pipeline, then
+// removes it whether or not processing worked; a file that failed once would
+// fail again.
+func (cfg *apiConfig) finishUpload(ctx context.Context, u database.Upload) (database.Video, error) {
+	defer func() {
+		os.Remove(u.TempPath)
+		if err := cfg.db.DeleteUpload(context.WithoutCancel(ctx), u.ID); err != nil {
+			log.Printf("Couldn't delete upload %s: %v", u.ID, err)
+		}
+	}()
+
+	video, err := cfg.videos.GetVideo(ctx, u.VideoID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if video.ID == uuid.Nil {
+		return database.Video{}, &pipelineError{http.StatusNotFound, codeNotFound, "Video was deleted", nil}
+	}
+	// tus clients send the file's name as "filename" in Upload-Metadata:
+	metadata, _ := parseUploadMetadata(u.Metadata)
+	return cfg.uploads().Run(ctx, video, upload.Staged{
+		Path:      u.TempPath,
+		MediaType: u.MediaType,
+		Filename:  metadata["filename"],
+	})
+}
+
+// recoverUploads reconciles the uploads table with the staging directory at
+// startup. A crash can leave a file longer than its recorded offset (bytes
+// written, offset not saved yet) or shorter, so the file is the source of truth.
+// Complete uploads that never got processed are processed now, and files with
+// no upload are removed.
+func (cfg *apiConfig) recoverUploads(ctx context.Context) error {
+	if err := os.MkdirAll(cfg.resumableStagingPath(), 0700); err != nil {
+		return err
+	}
+	uploads, err := cfg.db.GetUploads(ctx)
+	if err != nil {
+		return err
+	}
+
+	known := map[string]bool{}
+	for _, upload := range uploads {
+		known[filepath.Base(upload.TempPath)] = true
+
+		var size int64
+		info, err := os.Stat(upload.TempPath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// The bytes are gone (a wiped /tmp, say); the client starts over from 0:
+			if f, err := os.Create(upload.TempPath); err == nil {
+				f.Close()
+			}
+		case err != nil:
+			return err
+		default:
+			size = info.Size()
+			if size > upload.SizeBytes {
+				size = upload.SizeBytes
+				if err := os.Truncate(upload.TempPath, size); err != nil {
+					return err
+				}
+			}
+		}
+		if size != upload.OffsetBytes {
+			if err := cfg.db.SetUploadOffset(ctx, upload.ID, size); err != nil {
+				return err
+			}
+			upload.OffsetBytes = size
+		}
+
+		if upload.OffsetBytes == upload.SizeBytes {
+			// Hold the upload's lock, so a client retrying its last PATCH doesn't
+			// process it a second time:
+			uploadsInFlight.Store(upload.ID, struct{}{})
+			go func() {
+				defer uploadsInFlight.Delete(upload.ID)
+				if _, err := cfg.finishUpload(context.Background(), upload); err != nil {
+					log.Printf("Couldn't process recovered upload %s: %v", upload.ID, err)
+				}
+			}()
+		}
+	}
+
+	entries, err := os.ReadDir(cfg.resumableStagingPath())
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !known[entry.Name()] {
+			os.Remove(filepath.Join(cfg.resumableStagingPath(), entry.Name()))
+		}
+	}
+	if len(uploads) > 0 {
+		log.Printf("Recovered %d resumable uploads", len(uploads))
+	}
+	return nil
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header: comma-separated
+// "key base64value" pairs, where the value may be left out.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("empty key in %q", header)
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("value of %q isn't base64: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
-	"io"
+	"context"
+	"errors"
 	"os"
 	"mime"
 	"net/http"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/images"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
 	"github.com/google/uuid"
 )
 
@@ -32,95 +37,113 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// call the ValidateJWT function from the auth.go package, passing in:
+	// call the ValidateJWT method of the server's key set (internal/auth), passing in:
 	// 	* token: The JWT token (usually extracted from the request headers)
-	// 	* cfg.jwtSecret: A secret key used to verify the token's authenticity
+	// (the key set holds the secret or keys used to verify the token's authenticity)
 	// (userID: If the token is valid, this contains the user's ID that was encoded in the token)
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.tokens.ValidateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	// Parse the form data:
-	// Set a const maxMemory to 10MB. I just bit-shifted the number 10 to the left 20 
-	// times to get an int that stores the proper number of bytes:
-	const maxMemory = 10 << 20 // 10 MB
-	// Use (http.Request).ParseMultipartForm with the maxMemory const as an argument:
-	r.ParseMultipartForm(maxMemory)
+	// Get the video's metadata from the SQLite database and check ownership before we
+	// accept a single byte of the upload. The apiConfig's db has a GetVideo method you can use:
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	// If the authenticated user is not the video owner, return a http.StatusUnauthorized response:
+	if video.UserID != userID {
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to update this video", nil)
+		return
+	}
+
+	// Bound the whole request body. ParseMultipartForm's memory argument only decides
+	// what gets buffered in RAM versus spilled to disk, it never limits the body size:
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.live().ThumbnailUploadLimit)
 
-	// Get the image data from the form:
-	// Use r.FormFile to get the file data and file headers. The key the web browser 
-	// is using is called "thumbnail":
-	file, header, err := r.FormFile("thumbnail")
+	// Stream the "thumbnail" part straight to its asset file with a multipart.Reader
+	// instead of ParseMultipartForm, so whole images are never buffered in memory:
+	part, err := findMultipartFile(r, "thumbnail")
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
 		return
 	}
-	defer file.Close()
+	defer part.Close()
 
 	// Use the mime.ParseMediaType function to get the media type from the Content-Type header:
 	// mediaType will get the main part of the MIME type, _ discards the parameters, and err will 
 	// catch any potential errors during the parsing
-	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+		respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Invalid Content-Type", err)
 		return
 	}
 	// If the media type isn't either image/jpeg or image/png, respond with an error (respondWithError helper):
 	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type", nil)
+		respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Invalid file type", nil)
+		return
+	}
+
+	// Re-encode the image instead of saving the bytes as sent (images.Sanitize), which strips
+	// EXIF/GPS metadata and anything crafted to exploit whoever decodes it next. Whatever
+	// doesn't decode as a JPEG or PNG is turned away:
+	sanitized := bufpool.GetBuffer()
+	defer bufpool.PutBuffer(sanitized)
+	format, err := images.Sanitize(sanitized, part)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Thumbnail is too large", err)
+		case errors.Is(err, images.ErrTooLarge):
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Thumbnail dimensions are too large", err)
+		case respondIfUploadTooSlow(w, err):
+		default:
+			respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Couldn't decode image", err)
+		}
 		return
 	}
 
-	// create a relative path for the asset (a filename)
-	// used for the URL that clients will use to access the file (like http://localhost:8091/assets/12345.png)
-	assetPath := getAssetPath(mediaType)
+	// create a relative path for the asset (a filename), under the detected format rather than
+	// whatever the client claimed. It's used for the URL that clients will use to access the
+	// file (like http://localhost:8091/assets/12345.png)
+	assetPath := getAssetPath("image/" + format)
 	// take that relative path and converts it to a full filesystem path where the file will 
 	// actually be stored on disk
 	assetDiskPath := cfg.getAssetDiskPath(assetPath)
 
-	// opens a file for writing at the given path:
-	//	* If it doesn't exist, creates it. If it does, truncates it to empty
-	dst, err := os.Create(assetDiskPath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create file on server", err)
-		return
-	}
-	defer dst.Close()	// always defer close the file we just created
-	// streams all bytes from the source file (the uploaded multipart.File) to the destination dst 
-	// (the os.File you created):
-	// Returns the number of bytes written and an error
-	if _, err = io.Copy(dst, file); err != nil {
+	// writes the re-encoded image to that path, creating the file or truncating it if it exists:
+	if err := os.WriteFile(assetDiskPath, sanitized.Bytes(), 0o644); err != nil {
+		// don't leave a truncated image behind:
+		os.Remove(assetDiskPath)
 		respondWithError(w, http.StatusInternalServerError, "Error saving file", err)
 		return
 	}
 
-	// Get the video's metadata from the SQLite database. The apiConfig's db has a GetVideo method you can use:
-	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
-		return
-	}
-	// If the authenticated user is not the video owner, return a http.StatusUnauthorized response:
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
-		return
-	}
-
 	// builds the public URL (e.g., http://localhost:8091/assets/<id>.<ext>) from a disk path like 
 	// /assets/<id>.<ext>
 	url := cfg.getAssetURL(assetPath)
-	// store a pointer to that string in the video struct
-	// Using a pointer allows it to be nil when absent
-	video.ThumbnailURL = &url
 	
-	// then update the record in the database by using the cfg.db.UpdateVideo function:
-	err = cfg.db.UpdateVideo(video)
+	// then update the record in the database, storing a pointer to that string in the video struct
+	// (using a pointer allows it to be nil when absent). updateVideo retries on top of any
+	// concurrent change, like a video upload finishing meanwhile:
+	video, err = cfg.updateVideo(r.Context(), video, func(v *database.Video) {
+		v.ThumbnailURL = &url
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		respondWithPipelineError(w, videoUpdateError(err))
 		return
 	}
+	// scale it to the srcset widths too (see thumbnail_variants.go), so the response has them:
+	video = cfg.makeThumbnailVariants(r.Context(), video, assetDiskPath)
+	// have the moderator check the new thumbnail before it's shown publicly:
+	image := sanitized.Bytes()
+	cfg.scheduleModeration(video.ID, func(ctx context.Context) (moderation.Result, error) {
+		return cfg.moderator.ModerateImage(ctx, image)
+	})
 
 	// Respond with updated JSON of the video's metadata. Use the provided respondWithJSON function and 
 	// pass it the updated database.Video struct to marshal:
@@ -1,20 +1,22 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"mime"
+	"log"
 	"net/http"
 	"os"
-	"os/exec"
-	"path"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/keys"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/upload"
 	"github.com/google/uuid"
 )
 
@@ -37,232 +39,420 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Authenticate the user to get a userID:
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.tokens.ValidateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 	// Get the video metadata from the database:
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
 		return
 	}
 	// if the user is not the video owner, return a http.StatusUnauthorized response:
 	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to update this video", nil)
 		return
 	}
-	// Parse the uploaded video file from the form data:
-	// Use (http.Request).FormFile with the key "video" to get a multipart.File in memory:
-	file, handler, err := r.FormFile("video")
+	// The video is uploading until the pipeline takes over; if we give up before
+	// that, it goes back to how it was:
+	settle, err := cfg.beginVideoStatus(r.Context(), video.ID, database.StatusUploading, database.StatusDraft)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		respondWithPipelineError(w, videoStatusError(err))
 		return
 	}
-	// Remember to defer closing the file with (os.File).Close - we don't want any memory leaks:
-	defer file.Close()
-
-	// Validate the uploaded file to ensure it's an MP4 video:
-	// Use mime.ParseMediaType and "video/mp4" as the MIME type
-	mediaType, _, err := mime.ParseMediaType(handler.Header.Get("Content-Type"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+	defer settle()
+	cfg.hooks.UploadStarted(r.Context(), video)
+	// With UPLOAD_STAGING=s3 the body goes straight to the bucket, see upload_staging.go:
+	if cfg.uploadStaging == uploadStagingS3 {
+		cfg.handleStagedUpload(w, r, video)
 		return
 	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type, only MP4 is allowed", nil)
+	// Preflight: make sure the temp directory can hold the upload before reading any of
+	// the body. It is written twice, once when the multipart parser spills the part to
+	// disk and once in our own temp copy:
+	if err := cfg.checkUploadSpace(2 * r.ContentLength); err != nil {
+		respondWithSpaceError(w, err)
 		return
 	}
 
-	// Save the uploaded file to a temporary file on disk:
-	// Use os.CreateTemp to create a temporary file. I passed in an empty string for the directory to use 
-	// the system default, and the name "tubely-upload.mp4" (but you can use whatever you want)
-	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not create temp file", err)
-		return
+	var file io.Reader
+	var filename, contentType string
+	var meta upload.Metadata
+	if cfg.tempCipher != nil {
+		// With encrypted temp files the form is streamed, like with S3 staging, so
+		// the video part can't spill to disk in the clear:
+		part, partMeta, ok := readStreamedVideoForm(w, r)
+		if !ok {
+			return
+		}
+		defer part.Close()
+		file, filename, contentType, meta = part, part.FileName(), part.Header.Get("Content-Type"), partMeta
+	} else {
+		// Parse the form, keeping at most multipartMaxMemory in RAM; the rest of the video
+		// part spills to a temp file:
+		if err := r.ParseMultipartForm(cfg.live().MultipartMaxMemory); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				respondWithError(w, http.StatusRequestEntityTooLarge, "Video is too large", err)
+				return
+			}
+			if respondIfUploadTooSlow(w, err) {
+				return
+			}
+			respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+			return
+		}
+		// The title, description and visibility may come along as form fields, to be
+		// saved with the file:
+		formMeta, fieldErrors := uploadMetadataFrom(r.MultipartForm.Value)
+		if len(fieldErrors) > 0 {
+			respondWithFieldErrors(w, fieldErrors)
+			return
+		}
+		// Parse the uploaded video file from the form data:
+		// Use (http.Request).FormFile with the key "video" to get a multipart.File:
+		formFile, handler, err := r.FormFile("video")
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+			return
+		}
+		// Remember to defer closing the file with (os.File).Close - we don't want any memory leaks:
+		defer formFile.Close()
+		file, filename, contentType, meta = formFile, handler.Filename, handler.Header.Get("Content-Type"), formMeta
 	}
-	// defer remove the temp file with os.Remove:
-	defer os.Remove(tempFile.Name())
-	// defer close the temp file (defer is LIFO, so it will close before the remove):
-	defer tempFile.Close()
 
-	// io.Copy the contents over from the wire to the temp file:
-	if _, err := io.Copy(tempFile, file); err != nil {
+	// Validate the uploaded file to ensure it's an MP4 video (or a supported audio file),
+	// and save it to a temporary file in the configured upload temp directory (UPLOAD_TMP_DIR).
+	// The pipeline hashes and probes the bytes on their way through, so processing doesn't
+	// have to re-read the file before it can start:
+	staged, err := cfg.uploads().Stage(r.Context(), upload.Source{
+		Body:        file,
+		Filename:    filename,
+		ContentType: contentType,
+		Metadata:    meta,
+	})
+	if err != nil {
+		var pe *pipelineError
+		if errors.As(err, &pe) {
+			respondWithPipelineError(w, err)
+			return
+		}
+		// A streamed form is still being read from the client:
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Video is too large", err)
+			return
+		}
+		if respondIfUploadTooSlow(w, err) {
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Could not write file to disk", err)
 		return
 	}
+	// defer remove the temp file with os.Remove:
+	defer os.Remove(staged.Path)
+	cfg.recordBandwidth(r.Context(), video.UserID, staged.Size, 0)
 
-	// Reset the tempFile's file pointer to the beginning with .Seek(0, io.SeekStart) - this will 
-	// allow us to read the file again from the beginning:
-	_, err = tempFile.Seek(0, io.SeekStart)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not reset file pointer", err)
-		return
-	}
+	// The body is in; processing gets its own, longer deadline:
+	cfg.extendForProcessing(w)
 
-	// initialize empty 'directory' string:
-	directory := ""
-	// Call getVideoAspectRatio (below) to get aspect ratio of video:
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
+	// Hand the temp file to the shared probe/faststart/store pipeline:
+	video, err = cfg.uploads().Run(r.Context(), video, staged)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error determining aspect ratio", err)
+		respondWithPipelineError(w, err)
 		return
 	}
-	switch aspectRatio {
-	case "16:9":
-		directory = "landscape"
-	case "9:16":
-		directory = "portrait"
-	default:
-		directory = "other"
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// pipelineError carries the HTTP status, error code and client message for a failed
+// pipeline step:
+type pipelineError struct {
+	status  int
+	code    errorCode
+	message string
+	err     error
+}
+
+func (e *pipelineError) Error() string {
+	if e.err == nil {
+		return e.message
 	}
+	return fmt.Sprintf("%s: %v", e.message, e.err)
+}
 
-	// Generate random 32-bit hex filename with extension:
-	key := getAssetPath(mediaType)
-	// Join directory and key = directory/filename:
-	key = path.Join(directory, key)
+func (e *pipelineError) Unwrap() error {
+	return e.err
+}
 
-	// Call the function to generate a fast-start copy of the uploaded temp file and
-	// return the new file path:
-	processedFilePath, err := processVideoForFastStart(tempFile.Name())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error processing video", err)
+func respondWithPipelineError(w http.ResponseWriter, err error) {
+	var pe *pipelineError
+	if errors.As(err, &pe) {
+		if pe.code == codeStorageThrottled {
+			w.Header().Set("Retry-After", storageRetryAfter)
+		}
+		respondWithCode(w, pe.status, pe.code, pe.message, pe.err)
 		return
 	}
-	// Schedule deletion of the processed file when the handler returns:
-	defer os.Remove(processedFilePath)
+	respondWithCode(w, http.StatusInternalServerError, codeProcessingFailed, "Error processing video", err)
+}
 
-	// Open the processed video for reading, returning a file handle or an error;
-	// (you need a readable stream to upload it (or to copy it elsewhere))
-	// (S3 SDKs and similar APIs accept an io.Reader; opening the processed file gives you that reader 
-	// so you can stream its bytes to the destination)
-	processedFile, err := os.Open(processedFilePath)
+// storeProcessedVideo stores the processed file and points the video at it, then
+// starts what follows from a new file: packaging, checksums, a thumbnail. It's
+// the upload pipeline's store step (see upload_pipeline.go), shared with edits that make a new file out of
+// a stored video (see audio_edit.go). aspectRatio goes into the key in the
+// prefix layout, "" for audio. The file the video played until now, if any, is
+// kept as a version, with prior as the reason it was replaced. It returns the
+// video as published and the processed file's hash.
+func (cfg *apiConfig) storeProcessedVideo(ctx context.Context, video database.Video, processedFilePath, mediaType, aspectRatio, originalFilename string, meta upload.Metadata, prior string) (database.Video, string, error) {
+	// Stat the processed video for its size; storeProcessedFile opens it for each
+	// attempt at uploading it, since a retry has to start reading from the top:
+	processedInfo, err := os.Stat(processedFilePath)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not open processed file", err)
-		return
+		return database.Video{}, "", &pipelineError{http.StatusInternalServerError, codeInternal, "Could not stat processed file", err}
 	}
-	// Ensure the file handle is closed when the handler returns:
-	defer processedFile.Close()
 
-	// Put the object into S3 using PutObject. You'll need to provide:
-	//	* The bucket name
+	// Put the object into storage (S3, or the local directory in dev mode). You'll need to provide:
 	//	* The file key. Use the same <random-32-byte-hex>.ext format as the key
-	// 	* Upload the processed video to S3, and discard the original
+	// 	* Upload the processed video, and discard the original
 	//	* Content type, which is the MIME type of the file
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(key),
-		Body:        processedFile,
-		ContentType: aws.String(mediaType),
+	// In the content-addressable layout the key comes from the file's hash instead, and
+	// the upload is skipped entirely when another video already stored the same bytes.
+	// The hash also keys the processed file's probe cache entry:
+	processedHash, err := hashFile(processedFilePath)
+	if err != nil {
+		return database.Video{}, "", &pipelineError{http.StatusInternalServerError, codeInternal, "Could not hash processed file", err}
+	}
+	key, err := cfg.keys.Key(keys.Object{
+		UserID:      video.UserID,
+		Audio:       video.MediaKind == mediaKindAudio,
+		AspectRatio: aspectRatio,
+		Ext:         mediaTypeToExt(mediaType),
+		Hash:        processedHash,
+		Time:        time.Now(),
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error uploading file to S3", err)
-		return
+		return database.Video{}, "", &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't name stored file", err}
+	}
+	var contentHash *string
+	if cfg.keys.ContentAddressed() {
+		hash := processedHash
+		created, err := cfg.db.AcquireContentObject(ctx, hash, key, processedInfo.Size())
+		if err != nil {
+			return database.Video{}, "", &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't record content object", err}
+		}
+		if created {
+			// No Content-Disposition or owner tags here: the object is shared by every video
+			// with the same bytes, and one uploader's file name shouldn't show up in another's download.
+			err = cfg.storeProcessedFile(ctx, video, key, processedFilePath, processedInfo.Size(), storage.PutOptions{
+				ContentType:  mediaType,
+				CacheControl: cfg.videoCacheControl(true),
+				Tags:         sharedObjectTags(video.MediaKind),
+				Retain:       true,
+			})
+			if err != nil {
+				if relErr := cfg.releaseContentHash(ctx, hash); relErr != nil {
+					log.Printf("Couldn't release content object %s: %v", hash, relErr)
+				}
+				return database.Video{}, "", storageError(http.StatusInternalServerError, "Error uploading file to S3", err)
+			}
+		}
+		contentHash = &hash
+	} else {
+		err = cfg.storeProcessedFile(ctx, video, key, processedFilePath, processedInfo.Size(), storage.PutOptions{
+			ContentType:        mediaType,
+			ContentDisposition: contentDisposition(originalFilename),
+			CacheControl:       cfg.videoCacheControl(false),
+			Tags:               videoObjectTags(video, video.MediaKind),
+			Retain:             true,
+		})
+		if err != nil {
+			return database.Video{}, "", storageError(http.StatusInternalServerError, "Error uploading file to S3", err)
+		}
 	}
 
-	// Store an actual URL again in the video_url column, but this time, use the cloudfront URL. 
-	// Use your distribution's domain name, and then dynamically inject the S3 object's key:
-	url := fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, key)
-	video.VideoURL = &url
-	// calling the UpdateVideo method on it, passing the video object (which now has its VideoURL field populated with the S3 link)
-	err = cfg.db.UpdateVideo(video)
+	// Keep what the video played until now, see video_versions.go:
+	if err := cfg.keepPriorVersion(ctx, video, prior); err != nil {
+		return database.Video{}, "", &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't keep the previous version", err}
+	}
+	video, err = cfg.publishVideo(ctx, video, key, originalFilename, meta, contentHash)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+		return database.Video{}, "", err
 	}
+	// Remember the stored size for the per-user storage stats:
+	if err := cfg.db.SetVideoSize(ctx, video.ID, processedInfo.Size()); err != nil {
+		log.Printf("Couldn't record size for video %s: %v", video.ID, err)
+	}
+	// Checksum it block by block, so the integrity checks can verify it with range reads:
+	cfg.recordBlockChecksums(ctx, video.ID, key, processedFilePath)
 
-	respondWithJSON(w, http.StatusOK, video)
-}
-
-func getVideoAspectRatio(filePath string) (string, error) {
-	// use exec.Command to run the same ffprobe command. In this case, the command is ffprobe 
-	// and the arguments are -v: error, -print_format: json, -show_streams, and the file path:
-	cmd := exec.Command("ffprobe",
-		"-v", "error",
-		"-print_format", "json",
-		"-show_streams",
-		filePath,
-	)
+	// Segment it for HLS/DASH in the background; the MP4 is playable meanwhile:
+	if video.MediaKind == mediaKindVideo {
+		cfg.schedulePackaging(ctx, video, processedFilePath, processedInfo.Size())
+	}
+	// Give it a thumbnail from its own frames if it has none, unless AUTO_THUMBNAILS=false:
+	cfg.scheduleAutoThumbnail(ctx, video, processedFilePath)
 
-	//  allocate an in-memory growable buffer:
-	var stdout bytes.Buffer
-	// redirect the command's stdout to that buffer:
-	cmd.Stdout = &stdout
+	if err := cfg.db.SetVideoProcessedHash(ctx, video.ID, processedHash); err != nil {
+		log.Printf("Couldn't record processed checksum for video %s: %v", video.ID, err)
+	}
+	return video, processedHash, nil
+}
 
-	// runs the command and handle errors inline:
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("ffprobe error: %v", err)
+// transcodeTaskFor picks the processing step for an upload: audio is
+// normalized; users with a watermark get it burned in, which also produces a
+// fast-start file; everyone else gets a fast-start copy. watermark is false for
+// files that were stored with it already, see reprocess.go. source is the raw
+// upload, a path or a URL ffprobe can read, with hash its probe cache key.
+func (cfg *apiConfig) transcodeTaskFor(ctx context.Context, video database.Video, mediaType, source, hash string, watermark bool) (transcode.Task, error) {
+	if video.MediaKind == mediaKindAudio {
+		task, err := podcastTask(mediaType)
+		if err != nil {
+			return transcode.Task{}, &pipelineError{http.StatusInternalServerError, codeProcessingFailed, "Error processing video", err}
+		}
+		return task, nil
 	}
 
-	// create an anonymous struct type used as a JSON target for unmarshaling ffprobe's output:
-	var output struct {
-		// The JSON has a top-level key 'streams' mapping to an array:
-		Streams []struct {
-			Width  int `json:"width"`
-			Height int `json:"height"`
-		} `json:"streams"`
-	}
-	// take the JSON bytes from the stdout buffer (the output from the ffprobe command) and parse 
-	// it into the output variable:
-	// (&output uses the address-of operator because json.Unmarshal needs a pointer to the struct 
-	// so it can modify it directly with the parsed JSON data)
-	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
-		return "", fmt.Errorf("could not parse ffprobe output: %v", err)
-	}
-	// get the length (number of elements) in the Streams slice:
-	// if the slice is empty (has zero elements), return an error:
-	// (When ffprobe analyzes a video file, it returns information about all the streams in the file 
-	// (video streams, audio streams, subtitle streams, etc.). For this function to work, we need at 
-	// least one video stream to get the width and height dimensions)
-	if len(output.Streams) == 0 {
-		return "", errors.New("no video streams found")
-	}
-	// access the Width and Height fields of the first stream. These fields contain the pixel 
-	// dimensions of the video:
-	width := output.Streams[0].Width
-	height := output.Streams[0].Height
+	task := transcode.Task{Kind: transcode.KindFastStart}
+	if watermark {
+		settings, err := cfg.db.GetWatermark(ctx, video.UserID)
+		if err != nil {
+			return transcode.Task{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't get watermark settings", err}
+		}
+		if settings != nil {
+			task, err = watermarkTask(settings, cfg.getAssetDiskPath(settings.AssetPath))
+			if err != nil {
+				return transcode.Task{}, &pipelineError{http.StatusInternalServerError, codeProcessingFailed, "Error processing video", err}
+			}
+		}
+	}
+	// The probe is cached by now:
+	probe, err := cfg.probeFile(ctx, source, hash)
+	if err != nil {
+		return transcode.Task{}, &pipelineError{http.StatusInternalServerError, codeProbeFailed, "Error probing video", err}
+	}
+	// Phone videos carry a rotation that naive players ignore, so it's baked in:
+	task.Rotation, err = rotationFromProbe(probe)
+	if err != nil {
+		return transcode.Task{}, &pipelineError{http.StatusInternalServerError, codeProbeFailed, "Error determining rotation", err}
+	}
+	// Streams browsers may not play (HEVC, 10-bit video...) are re-encoded
+	// instead of copied, see codecs.go:
+	if codecs := cfg.live().Codecs; codecs.Reencode {
+		if err := codecs.apply(&task, probe); err != nil {
+			return transcode.Task{}, &pipelineError{http.StatusInternalServerError, codeProbeFailed, "Error determining codecs", err}
+		}
+	}
+	return task, nil
+}
 
-	// return a text string of the aspect ratio:
-	if width == 16*height/9 {
-		return "16:9", nil
-	} else if height == 16*width/9 {
-		return "9:16", nil
+// recordProcessingRun times the processing step so the admin dashboard can
+// report failure rates and average transcode times:
+func (cfg *apiConfig) recordProcessingRun(ctx context.Context, video database.Video, start time.Time, err error) {
+	run := database.CreateProcessingRunParams{
+		VideoID:   video.ID,
+		UserID:    video.UserID,
+		Duration:  time.Since(start),
+		Succeeded: err == nil,
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	if runErr := cfg.db.CreateProcessingRun(ctx, run); runErr != nil {
+		log.Printf("Couldn't record processing run for video %s: %v", video.ID, runErr)
 	}
-	return "other", nil
 }
 
-// Create a new function called processVideoForFastStart(filePath string) (string, error) that takes a file 
-// path as input and creates and returns a new path to a file with "fast start" encoding:
-func processVideoForFastStart(inputFilePath string) (string, error) {
-	//Create a new string for the output file path. I just appended .processing to the input file 
-	// (which should be the path to the temp file on disk):
-	processedFilePath := fmt.Sprintf("%s.processing", inputFilePath)
-	// Create a new exec.Cmd using exec.Command:
-	// The command is ffmpeg and the arguments are -i, the input file path, -c, copy, -movflags, faststart, 
-	// -f, mp4 and the output file path
-	cmd := exec.Command("ffmpeg", "-i", inputFilePath, "-movflags", "faststart", "-codec", "copy", "-f", "mp4", processedFilePath)
-	// create a buffer in memory:
-	var stderr bytes.Buffer
-	// tell exec.Cmd to write anything the process prints to stderr into that buffer
-	cmd.Stderr = &stderr
-	// run the command:
-	// (After cmd.Run(), you can read stderr.String() for ffmpeg error messages or logs)
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("error processing video: %s, %v", stderr.String(), err)
+// publishVideo points the video at its newly stored object under key and marks
+// it ready, then starts replication and moderation. contentHash is the object's
+// hash in the content-addressable layout, nil otherwise.
+func (cfg *apiConfig) publishVideo(ctx context.Context, video database.Video, key, originalFilename string, meta upload.Metadata, contentHash *string) (database.Video, error) {
+	// This upload replaces whatever the video pointed at before, so drop that reference:
+	if err := cfg.releaseVideoContent(ctx, video.ID); err != nil {
+		log.Printf("Couldn't release previous content of video %s: %v", video.ID, err)
 	}
 
-	// read filesystem metadata for the given path. Return fileInfo (info about the file) or an 
-	// error if the path doesn’t exist or isn’t accessible
-	fileInfo, err := os.Stat(processedFilePath)
+	// Store an actual URL again in the video_url column. With a CDN this is its URL:
+	// your distribution's domain name, with the object's key dynamically injected:
+	url := cfg.cdn.PublicURL(key)
+	mediaKind := video.MediaKind
+	// The new URL, its content hash, object key, status and any metadata sent with
+	// the upload go in together, so a failure halfway doesn't leave the row pointing
+	// at one file and describing another:
+	err := cfg.db.WithTx(ctx, func(tx database.Client) error {
+		if contentHash != nil {
+			if err := tx.SetVideoContentHash(ctx, video.ID, contentHash); err != nil {
+				return &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't update video", err}
+			}
+		}
+		// save the new VideoURL. The row may have changed while we were processing (a thumbnail
+		// upload, say), so updateVideo re-applies just our fields on top of it if needed:
+		var err error
+		video, err = updateVideoIn(ctx, tx, video, func(v *database.Video) {
+			v.VideoURL = &url
+			v.MediaKind = mediaKind
+			v.OriginalFilename = nil
+			if originalFilename != "" {
+				v.OriginalFilename = &originalFilename
+			}
+			meta.ApplyTo(v)
+		})
+		if err != nil {
+			return videoUpdateError(err)
+		}
+		if meta.Visibility != nil {
+			if err := tx.SetVideoVisibility(ctx, video.ID, *meta.Visibility); err != nil {
+				return &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't update video", err}
+			}
+			video.Visibility = *meta.Visibility
+		}
+		if err := tx.SetVideoStatus(ctx, video.ID, database.StatusReady); err != nil {
+			return videoStatusError(err)
+		}
+		// Remember the object key so the tiering policy can find it later:
+		if err := tx.SetVideoObject(ctx, video.ID, key); err != nil {
+			return &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't update video", err}
+		}
+		return nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("could not stat processed file: %v", err)
+		var pe *pipelineError
+		if errors.As(err, &pe) {
+			return database.Video{}, pe
+		}
+		return database.Video{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't update video", err}
+	}
+	video.Status = database.StatusReady
+	// Copy it to the replica bucket, if there is one:
+	cfg.scheduleReplication(ctx, video, key)
+	// Keep it out of public view until the moderator has looked at it (audio has nothing to look at):
+	if video.MediaKind == mediaKindVideo {
+		cfg.scheduleModeration(video.ID, func(ctx context.Context) (moderation.Result, error) {
+			return cfg.moderator.ModerateVideo(ctx, key)
+		})
+	}
+	return video, nil
+}
+
+// saveEmbeddedChapters pulls any chapter markers embedded in the processed file
+// (a path or URL) so players can show them. A broken chapter track shouldn't
+// fail an otherwise good upload, so errors are only logged.
+func (cfg *apiConfig) saveEmbeddedChapters(ctx context.Context, video *database.Video, source, hash string) {
+	var chapters []database.CreateChapterParams
+	probe, err := cfg.probeFile(ctx, source, hash)
+	if err == nil {
+		chapters, err = chaptersFromProbe(probe)
 	}
-	// check the file's size:
-	if fileInfo.Size() == 0 {
-		return "", fmt.Errorf("processed file is empty")
+	if err != nil {
+		log.Printf("Couldn't extract chapters for video %s: %v", video.ID, err)
+	} else if len(chapters) > 0 {
+		if err := validateChapters(chapters); err != nil {
+			log.Printf("Ignoring embedded chapters for video %s: %v", video.ID, err)
+		} else if video.Chapters, err = cfg.db.ReplaceChapters(ctx, video.ID, chapters); err != nil {
+			log.Printf("Couldn't save chapters for video %s: %v", video.ID, err)
+		}
 	}
-	// Return the output file path:
-	return processedFilePath, nil
 }
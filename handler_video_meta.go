@@ -2,7 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -19,7 +25,7 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.tokens.ValidateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -33,12 +39,17 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	params.UserID = userID
+	if params.Visibility != "" && !validVisibility(params.Visibility) {
+		respondWithFieldErrors(w, []fieldError{{"visibility", `Invalid visibility, expected "public" or "private"`}})
+		return
+	}
 
-	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	video, err := cfg.videos.CreateVideo(r.Context(), params.CreateVideoParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
+	cfg.hooks.Created(r.Context(), video)
 
 	respondWithJSON(w, http.StatusCreated, video)
 }
@@ -56,31 +67,67 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.tokens.ValidateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
 	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+		respondWithCode(w, http.StatusForbidden, codeNotOwner, "You can't delete this video", err)
+		return
+	}
+	// Files under Object Lock retention can't go yet, see object_lock.go:
+	if err := cfg.checkDeletable(r.Context(), videoID); err != nil {
+		respondWithPipelineError(w, err)
+		return
+	}
+
+	// Content-addressed objects may be shared, so only the last reference deletes one:
+	if err := cfg.releaseVideoContent(r.Context(), videoID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't release video content", err)
+		return
+	}
+	// And the files it played before any edits, see video_versions.go:
+	versionKeys, err := cfg.releaseVersions(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't release earlier versions", err)
+		return
+	}
+	if err := cfg.deleteVersionObjects(r.Context(), versionKeys); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete earlier versions", err)
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	// Remove the HLS/DASH segments too, if the video was ever packaged:
+	streamPrefix, err := cfg.db.GetVideoStreamPrefix(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video streams", err)
+		return
+	}
+	if streamPrefix != "" {
+		cfg.deletePrefix(r.Context(), streamPrefix+"/")
+	}
+
+	err = cfg.videos.DeleteVideo(r.Context(), videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	cfg.hooks.Deleted(r.Context(), videoID, userID)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handlerVideoGet returns a video for playback. With ?format=hls or ?format=dash
+// the response's playback_url is that rendition when the video has one; when it
+// doesn't yet, the video is queued for packaging, see backfillPackaging, and
+// playback_url is the progressive MP4 with renditions_pending set.
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -88,14 +135,67 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
 		return
 	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", playbackFormatMP4, playbackFormatHLS, playbackFormatDASH:
+	default:
+		respondWithFieldErrors(w, []fieldError{{"format", "Must be mp4, hls or dash"}})
+		return
+	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+	// Videos that haven't passed moderation, and private ones without a share
+	// link, don't exist as far as the public knows:
+	if !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	// Each fetch counts as a view for the cold-storage policy:
+	if err := cfg.db.RecordView(r.Context(), videoID); err != nil {
+		log.Printf("Couldn't record view of video %s: %v", videoID, err)
+	}
+	// Archived videos can't play until S3 restores them; this starts the restore:
+	status, err := cfg.playbackStatus(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video availability", err)
+		return
+	}
+
+	// Videos from before HLS or DASH was turned on are packaged when first asked
+	// for; the stored file can't be read while it's being restored:
+	pending := false
+	if status == playbackAvailable {
+		pending = cfg.backfillPackaging(r.Context(), video, format)
+	}
+
+	// Play from the replica while the primary bucket is down:
+	video = cfg.withReplicaFallback(r.Context(), video)
+	videos := []database.Video{video}
+	cfg.markLiked(r.Context(), cfg.optionalViewer(r), videos)
+	cfg.signPlaybackURLs(r.Context(), videos)
+	video = videos[0]
+
+	var playbackURL *string
+	switch {
+	case format == playbackFormatHLS && video.HLSURL != nil:
+		playbackURL = video.HLSURL
+	case format == playbackFormatDASH && video.DashURL != nil:
+		playbackURL = video.DashURL
+	case format != "":
+		playbackURL = video.VideoURL
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		database.Video
+		PlaybackStatus    string  `json:"playback_status"`
+		PlaybackURL       *string `json:"playback_url,omitempty"`
+		RenditionsPending bool    `json:"renditions_pending,omitempty"`
+	}{video, status, playbackURL, pending})
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
@@ -104,17 +204,120 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.tokens.ValidateJWT(token)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	videos, err := cfg.videos.GetVideos(r.Context(), userID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
+	// ?tag= narrows the list to the videos with that tag:
+	if tag := normalizeTag(r.URL.Query().Get("tag")); tag != "" {
+		videos = slices.DeleteFunc(videos, func(v database.Video) bool {
+			return !slices.Contains(v.Tags, tag)
+		})
+	}
+	cfg.markLiked(r.Context(), userID, videos)
+	cfg.signPlaybackURLs(r.Context(), videos)
 
 	respondWithJSON(w, http.StatusOK, videos)
 }
+
+// handlerVideosSearch is full-text search over titles and descriptions:
+// GET /api/videos/search?q=...&tag=...&owner=me|<user id>&limit=20&offset=0.
+// With a tag, q may be left out to list the tag's videos newest first. Anyone can
+// search approved videos; logged-in users also find their own unmoderated ones.
+// With SEARCH_BACKEND=opensearch, the OpenSearch index answers instead of SQLite.
+func (cfg *apiConfig) handlerVideosSearch(w http.ResponseWriter, r *http.Request) {
+	const (
+		defaultSearchLimit = 20
+		maxSearchLimit     = 100
+	)
+	type response struct {
+		Results []database.Video `json:"results"`
+		Limit   int              `json:"limit"`
+		Offset  int              `json:"offset"`
+	}
+
+	// Logging in is optional, a bad token is still an error:
+	var viewerID uuid.UUID
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		viewerID, err = cfg.tokens.ValidateJWT(token)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+	}
+
+	query := r.URL.Query()
+	params := database.SearchVideosParams{
+		Query:    query.Get("q"),
+		Tag:      normalizeTag(query.Get("tag")),
+		ViewerID: viewerID,
+		Limit:    defaultSearchLimit,
+	}
+	var fieldErrors []fieldError
+	if strings.TrimSpace(params.Query) == "" && params.Tag == "" {
+		fieldErrors = append(fieldErrors, fieldError{"q", "Search query is required"})
+	}
+	switch owner := query.Get("owner"); owner {
+	case "":
+	case "me":
+		if viewerID == uuid.Nil {
+			fieldErrors = append(fieldErrors, fieldError{"owner", "owner=me requires logging in"})
+		}
+		params.OwnerID = viewerID
+	default:
+		id, err := uuid.Parse(owner)
+		if err != nil {
+			fieldErrors = append(fieldErrors, fieldError{"owner", "Invalid owner, expected \"me\" or a user ID"})
+		}
+		params.OwnerID = id
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			fieldErrors = append(fieldErrors, fieldError{"limit", fmt.Sprintf("Invalid limit, expected 1 to %d", maxSearchLimit)})
+		}
+		params.Limit = n
+	}
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			fieldErrors = append(fieldErrors, fieldError{"offset", "Invalid offset, expected a non-negative number"})
+		}
+		params.Offset = n
+	}
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, fieldErrors)
+		return
+	}
+
+	var videos []database.Video
+	var err error
+	if cfg.search.Query {
+		videos, err = cfg.searchIndexVideos(r.Context(), params)
+	} else {
+		videos, err = cfg.db.SearchVideos(r.Context(), params)
+	}
+	if errors.Is(err, database.ErrEmptySearch) {
+		respondWithFieldErrors(w, []fieldError{{"q", "Search query is required"}})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+	cfg.markLiked(r.Context(), viewerID, videos)
+	cfg.signPlaybackURLs(r.Context(), videos)
+
+	respondWithJSON(w, http.StatusOK, response{
+		Results: videos,
+		Limit:   params.Limit,
+		Offset:  params.Offset,
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// handlerVideoStream serves the video's file through the API, for deployments
+// where players can't reach CloudFront or the bucket directly. Range and
+// If-Range are passed through to storage, so seeking and resumed downloads get
+// 206 Partial Content. Who may stream is who may see the video, share links
+// included.
+func (cfg *apiConfig) handlerVideoStream(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video has no uploaded content", nil)
+		return
+	}
+	obj, err := cfg.db.GetVideoObject(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if obj.ObjectKey == "" {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video has no uploaded content", nil)
+		return
+	}
+	// An archived object can't be read until it's restored; this starts the restore:
+	if status, err := cfg.playbackStatus(r.Context(), video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video availability", err)
+		return
+	} else if status == playbackRestoring {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video is being restored from archive, try again later", nil)
+		return
+	}
+	// What's streamed counts against the owner's monthly transfer, see bandwidth.go:
+	owner, err := cfg.db.GetUser(r.Context(), video.UserID)
+	if err != nil || owner == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video owner", err)
+		return
+	}
+	if err := cfg.checkTransfer(r.Context(), owner); err != nil {
+		respondWithPipelineError(w, err)
+		return
+	}
+
+	// Local files get Range and conditional requests from http.ServeContent:
+	if files, ok := cfg.store.(storage.FileStore); ok {
+		cfg.serveVideoFile(w, r, files, obj.ObjectKey, video)
+		return
+	}
+
+	store := cfg.store
+	key := obj.ObjectKey
+	// Stream from the replica while the primary bucket is down:
+	if cfg.primaryUnreachable(r.Context()) {
+		if replication, err := cfg.db.GetReplication(r.Context(), video.ID); err == nil && replication.Status == database.ReplicationReplicated {
+			store, key = cfg.replication.Replica, replication.ObjectKey
+		}
+	}
+	ranges, ok := store.(storage.RangeGetter)
+	if !ok {
+		respondWithCode(w, http.StatusNotImplemented, codeUnavailable, "Streaming isn't supported by this storage backend", nil)
+		return
+	}
+
+	object, err := ranges.GetRange(r.Context(), key, storage.RangeOptions{
+		Range:   r.Header.Get("Range"),
+		IfRange: r.Header.Get("If-Range"),
+	})
+	if errors.Is(err, storage.ErrInvalidRange) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		respondWithCode(w, http.StatusRequestedRangeNotSatisfiable, codeInvalidRequest, "Requested range is outside the video", err)
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video file is missing from storage", err)
+		return
+	}
+	if err != nil {
+		respondWithPipelineError(w, storageError(http.StatusBadGateway, "Couldn't read video from storage", err))
+		return
+	}
+	defer object.Body.Close()
+
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Content-Length", strconv.FormatInt(object.ContentLength, 10))
+	if object.ContentType != "" {
+		header.Set("Content-Type", object.ContentType)
+	}
+	if object.ContentDisposition != "" {
+		header.Set("Content-Disposition", object.ContentDisposition)
+	} else if video.OriginalFilename != nil {
+		header.Set("Content-Disposition", contentDisposition(*video.OriginalFilename))
+	}
+	if object.ETag != "" {
+		header.Set("ETag", object.ETag)
+	}
+	if !object.LastModified.IsZero() {
+		header.Set("Last-Modified", object.LastModified.UTC().Format(http.TimeFormat))
+	}
+	status := http.StatusOK
+	if object.ContentRange != "" {
+		header.Set("Content-Range", object.ContentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	n, err := bufpool.Copy(w, object.Body)
+	cfg.recordBandwidth(r.Context(), video.UserID, 0, n)
+	if err != nil {
+		// Players drop connections all the time when seeking; nothing to tell them.
+		log.Printf("Stream of video %s ended early: %v", video.ID, err)
+	}
+}
+
+func (cfg *apiConfig) serveVideoFile(w http.ResponseWriter, r *http.Request, files storage.FileStore, key string, video database.Video) {
+	p, err := files.FilePath(key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video from storage", err)
+		return
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Video file is missing from storage", err)
+		return
+	}
+	if err != nil {
+		respondWithCode(w, http.StatusBadGateway, codeStorageFailed, "Couldn't read video from storage", err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		respondWithCode(w, http.StatusBadGateway, codeStorageFailed, "Couldn't read video from storage", err)
+		return
+	}
+	if video.OriginalFilename != nil {
+		w.Header().Set("Content-Disposition", contentDisposition(*video.OriginalFilename))
+	}
+	counted := &countingResponseWriter{ResponseWriter: w}
+	http.ServeContent(counted, r, info.Name(), info.ModTime(), f)
+	cfg.recordBandwidth(r.Context(), video.UserID, 0, counted.written)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerWatermarkUpload stores the caller's watermark logo. The multipart form
+// carries the PNG in "watermark" plus optional "position" and "opacity" fields.
+// Every video the user uploads afterwards gets the logo burned in.
+func (cfg *apiConfig) handlerWatermarkUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.live().ThumbnailUploadLimit)
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	if err := r.ParseMultipartForm(cfg.live().MultipartMaxMemory); err != nil {
+		if respondIfUploadTooSlow(w, err) {
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+		return
+	}
+
+	watermark := database.Watermark{
+		Position: defaultWatermarkPosition,
+		Opacity:  defaultWatermarkOpacity,
+	}
+	// Check both fields so the client hears about every mistake at once:
+	var fieldErrors []fieldError
+	if position := r.FormValue("position"); position != "" {
+		if _, ok := watermarkPositions[position]; !ok {
+			fieldErrors = append(fieldErrors, fieldError{"position", "Invalid position, expected top-left, top-right, bottom-left, bottom-right or center"})
+		}
+		watermark.Position = position
+	}
+	if opacity := r.FormValue("opacity"); opacity != "" {
+		watermark.Opacity, err = strconv.ParseFloat(opacity, 64)
+		if err != nil || watermark.Opacity <= 0 || watermark.Opacity > 1 {
+			fieldErrors = append(fieldErrors, fieldError{"opacity", "Invalid opacity, expected a number in (0, 1]"})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, fieldErrors)
+		return
+	}
+
+	file, header, err := r.FormFile("watermark")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	// Only PNG, a logo needs an alpha channel to look right over video:
+	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Invalid Content-Type", err)
+		return
+	}
+	if mediaType != "image/png" {
+		respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Invalid file type, only PNG is allowed", nil)
+		return
+	}
+
+	// Store it with the same random-name scheme as thumbnails:
+	watermark.AssetPath = getAssetPath(mediaType)
+	dst, err := os.Create(cfg.getAssetDiskPath(watermark.AssetPath))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to create file on server", err)
+		return
+	}
+	defer dst.Close()
+	if _, err = bufpool.Copy(dst, file); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving file", err)
+		return
+	}
+
+	// Swap in the new logo, then remove the old file:
+	previous, err := cfg.db.GetWatermark(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watermark", err)
+		return
+	}
+	if err := cfg.db.SetWatermark(r.Context(), userID, watermark); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save watermark", err)
+		return
+	}
+	if previous != nil {
+		os.Remove(cfg.getAssetDiskPath(previous.AssetPath))
+	}
+
+	respondWithJSON(w, http.StatusOK, watermark)
+}
+
+// handlerWatermarkDelete turns watermarking off for future uploads:
+func (cfg *apiConfig) handlerWatermarkDelete(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	previous, err := cfg.db.GetWatermark(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watermark", err)
+		return
+	}
+	if err := cfg.db.ClearWatermark(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove watermark", err)
+		return
+	}
+	if previous != nil {
+		os.Remove(cfg.getAssetDiskPath(previous.AssetPath))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// hlsKeySize is the length of an AES-128 content key:
+const hlsKeySize = 16
+
+// newHLSKeyCipher builds the AEAD that seals content keys at rest from
+// HLS_KEY_ENCRYPTION_KEY, a base64-encoded 32-byte AES-256 key. A database dump
+// alone is then not enough to decrypt any segments.
+func newHLSKeyCipher(encoded string) (cipher.AEAD, error) {
+	kek, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("HLS_KEY_ENCRYPTION_KEY isn't valid base64: %w", err)
+	}
+	if len(kek) != 32 {
+		return nil, fmt.Errorf("HLS_KEY_ENCRYPTION_KEY must be 32 bytes, got %d", len(kek))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hlsKeyURL is where playlists tell players to fetch the video's key:
+func (cfg *apiConfig) hlsKeyURL(videoID uuid.UUID) string {
+	return fmt.Sprintf("http://localhost:%s/api/videos/%s/hls-key", cfg.port, videoID)
+}
+
+// videoHLSKey returns the video's AES-128 content key, generating and storing one
+// the first time. The key stays the same across re-packaging, so players that
+// already fetched it keep working.
+func (cfg *apiConfig) videoHLSKey(ctx context.Context, videoID uuid.UUID) ([]byte, error) {
+	sealed, err := cfg.db.GetHLSKey(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+	if sealed == nil {
+		key := make([]byte, hlsKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, cfg.hlsKeyCipher.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		// The video ID is the additional data, so a sealed key can't be copied to
+		// another video's row:
+		sealed = cfg.hlsKeyCipher.Seal(nonce, nonce, key, videoID[:])
+		if err := cfg.db.SetHLSKeyIfMissing(ctx, videoID, sealed); err != nil {
+			return nil, err
+		}
+		// Another packaging run may have won the race; use whichever key was stored:
+		if sealed, err = cfg.db.GetHLSKey(ctx, videoID); err != nil {
+			return nil, err
+		}
+	}
+
+	n := cfg.hlsKeyCipher.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("sealed HLS key is truncated")
+	}
+	return cfg.hlsKeyCipher.Open(nil, sealed[:n], sealed[n:], videoID[:])
+}
+
+// writeHLSKeyInfo writes the key file and the key info file ffmpeg's
+// -hls_key_info_file reads (key URI, then key file path). They go in their own
+// temp directory so the key never ends up uploaded next to the segments; call
+// cleanup when ffmpeg is done.
+func (cfg *apiConfig) writeHLSKeyInfo(ctx context.Context, videoID uuid.UUID) (keyInfoPath string, cleanup func(), err error) {
+	key, err := cfg.videoHLSKey(ctx, videoID)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp(cfg.uploadTmpDir, "tubely-hls-key-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	keyPath := filepath.Join(dir, "video.key")
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	keyInfoPath = filepath.Join(dir, "video.keyinfo")
+	// No IV line: ffmpeg then uses each segment's sequence number, as HLS specifies.
+	if err := os.WriteFile(keyInfoPath, []byte(cfg.hlsKeyURL(videoID)+"\n"+keyPath+"\n"), 0600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return keyInfoPath, cleanup, nil
+}
+
+// handlerVideoHLSKey serves the key for an encrypted HLS package. Unlike the
+// segments, which anyone can download from the CDN, it requires a login or a
+// share link's ?token=, so only those viewers can play the video.
+func (cfg *apiConfig) handlerVideoHLSKey(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	shared := r.URL.Query().Has("token")
+	if !shared {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+		if _, err := cfg.tokens.ValidateJWT(token); err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+	}
+
+	if cfg.hlsKeyCipher == nil {
+		respondWithError(w, http.StatusNotFound, "HLS encryption isn't enabled", nil)
+		return
+	}
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// A token stands in for the login, so it has to be a live one even for
+	// public videos:
+	if video.ID == uuid.Nil || !cfg.canView(r, video) || (shared && !cfg.hasShareLink(r, video)) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	sealed, err := cfg.db.GetHLSKey(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get key", err)
+		return
+	}
+	if sealed == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no encrypted stream", nil)
+		return
+	}
+	key, err := cfg.videoHLSKey(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get key", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(key)
+}
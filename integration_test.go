@@ -0,0 +1,321 @@
+//go:build integration
+
+package main
+
+// The end-to-end upload test: it builds the server, runs it against a real
+// S3-compatible service and a fresh SQLite database, uploads a small MP4 the
+// way the web app does, and checks the object and its URL. ffmpeg and ffprobe
+// do the real work, so they have to be on PATH. With LocalStack:
+//
+//	docker run --rm -p 4566:4566 localstack/localstack
+//	go test -tags integration -run Integration -v .
+//
+// or MinIO, with its credentials:
+//
+//	docker run --rm -p 9000:9000 minio/minio server /data
+//	TUBELY_IT_S3_ENDPOINT=http://localhost:9000 AWS_ACCESS_KEY_ID=minioadmin \
+//	  AWS_SECRET_ACCESS_KEY=minioadmin go test -tags integration -run Integration -v .
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// integrationEnv is the S3-compatible service to test against, from
+// TUBELY_IT_S3_ENDPOINT and TUBELY_IT_S3_BUCKET, LocalStack's defaults otherwise.
+// Credentials are the usual AWS_* variables, or LocalStack's test/test.
+type integrationEnv struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+func loadIntegrationEnv() integrationEnv {
+	get := func(name, fallback string) string {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+		return fallback
+	}
+	return integrationEnv{
+		endpoint:  get("TUBELY_IT_S3_ENDPOINT", "http://localhost:4566"),
+		bucket:    get("TUBELY_IT_S3_BUCKET", "tubely-integration"),
+		region:    get("AWS_REGION", "us-east-1"),
+		accessKey: get("AWS_ACCESS_KEY_ID", "test"),
+		secretKey: get("AWS_SECRET_ACCESS_KEY", "test"),
+	}
+}
+
+func TestUploadIntegration(t *testing.T) {
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Fatalf("%s isn't on PATH; the pipeline needs it", tool)
+		}
+	}
+	env := loadIntegrationEnv()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithRegion(env.region),
+		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(env.accessKey, env.secretKey, "")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := storage.NewS3Client(awsCfg, env.endpoint)
+	createIntegrationBucket(t, ctx, client, env.bucket)
+
+	dir := t.TempDir()
+	fixture := filepath.Join(dir, "fixture.mp4")
+	makeFixtureMP4(t, ctx, fixture)
+	server := startIntegrationServer(t, ctx, env, dir)
+
+	// Sign up and log in, as the web app does:
+	api := &integrationClient{t: t, base: server}
+	api.do(http.MethodPost, "/api/users", `{"email": "it@example.com", "password": "integration"}`, nil)
+	var login struct {
+		Token string `json:"token"`
+	}
+	api.do(http.MethodPost, "/api/login", `{"email": "it@example.com", "password": "integration"}`, &login)
+	api.token = login.Token
+
+	var video database.Video
+	api.do(http.MethodPost, "/api/videos", `{"title": "Integration", "description": "end to end"}`, &video)
+
+	var uploaded database.Video
+	api.upload("/api/video_upload/"+video.ID.String(), fixture, &uploaded)
+	if uploaded.Status != database.StatusReady {
+		t.Errorf("status after upload = %q, want %q", uploaded.Status, database.StatusReady)
+	}
+
+	var fetched database.Video
+	api.do(http.MethodGet, "/api/videos/"+video.ID.String(), "", &fetched)
+	if fetched.VideoURL == nil {
+		t.Fatal("video has no video_url after upload")
+	}
+	// The default (prefix) layout, on the service's path-style URLs:
+	prefix := strings.TrimSuffix(env.endpoint, "/") + "/" + env.bucket + "/"
+	key, ok := strings.CutPrefix(*fetched.VideoURL, prefix)
+	if !ok || !strings.HasPrefix(key, "landscape/") || !strings.HasSuffix(key, ".mp4") {
+		t.Fatalf("video_url = %s, want %slandscape/<key>.mp4", *fetched.VideoURL, prefix)
+	}
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(env.bucket), Key: aws.String(key)})
+	if err != nil {
+		t.Fatalf("object %s isn't in the bucket: %v", key, err)
+	}
+	if got := aws.ToString(head.ContentType); got != "video/mp4" {
+		t.Errorf("Content-Type = %q, want video/mp4", got)
+	}
+	if aws.ToInt64(head.ContentLength) == 0 {
+		t.Error("stored object is empty")
+	}
+}
+
+func createIntegrationBucket(t *testing.T, ctx context.Context, client *s3.Client, bucket string) {
+	t.Helper()
+	_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)})
+	var owned *types.BucketAlreadyOwnedByYou
+	var exists *types.BucketAlreadyExists
+	if err != nil && !errors.As(err, &owned) && !errors.As(err, &exists) {
+		t.Fatalf("couldn't create bucket %s (is LocalStack or MinIO running?): %v", bucket, err)
+	}
+}
+
+// makeFixtureMP4 writes two seconds of 320x180 test pattern with a tone:
+func makeFixtureMP4(t *testing.T, ctx context.Context, path string) {
+	t.Helper()
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-nostdin", "-v", "error",
+		"-f", "lavfi", "-i", "testsrc=size=320x180:rate=25:duration=2",
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=2",
+		"-c:v", "libx264", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-shortest",
+		"-movflags", "+faststart",
+		path,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("couldn't make the fixture MP4: %v: %s", err, out)
+	}
+}
+
+// startIntegrationServer builds the server and runs it from dir, so no .env is
+// picked up, until the test ends. It returns the server's base URL.
+func startIntegrationServer(t *testing.T, ctx context.Context, env integrationEnv, dir string) string {
+	t.Helper()
+	binary := filepath.Join(dir, "tubely")
+	build := exec.CommandContext(ctx, "go", "build", "-o", binary, ".")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("couldn't build the server: %v: %s", err, out)
+	}
+	appRoot, err := filepath.Abs("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := freePort(t)
+
+	server := exec.Command(binary)
+	server.Dir = dir
+	server.Env = append(os.Environ(),
+		"DB_PATH="+filepath.Join(dir, "tubely.db"),
+		"JWT_SECRET=integration-test-secret",
+		"PLATFORM=dev",
+		"FILEPATH_ROOT="+appRoot,
+		"ASSETS_ROOT="+filepath.Join(dir, "assets"),
+		"UPLOAD_TMP_DIR="+filepath.Join(dir, "tmp"),
+		"PORT="+port,
+		"STORAGE_BACKEND=s3",
+		"S3_BUCKET="+env.bucket,
+		"S3_REGION="+env.region,
+		"S3_ENDPOINT="+env.endpoint,
+		"CDN_PROVIDER=none",
+		"AWS_ACCESS_KEY_ID="+env.accessKey,
+		"AWS_SECRET_ACCESS_KEY="+env.secretKey,
+	)
+	var logs bytes.Buffer
+	server.Stdout = &logs
+	server.Stderr = &logs
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- server.Wait() }()
+	t.Cleanup(func() {
+		server.Process.Kill()
+		<-exited
+		if t.Failed() {
+			t.Logf("server output:\n%s", logs.String())
+		}
+	})
+
+	base := "http://localhost:" + port
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		resp, err := http.Get(base + "/.well-known/jwks.json")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return base
+			}
+		}
+		select {
+		case err := <-exited:
+			exited <- err
+			t.Fatalf("server exited on startup: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			// The cleanup logs its output once it's stopped:
+			t.Fatalf("server didn't come up on %s", base)
+		}
+	}
+}
+
+func freePort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return fmt.Sprint(l.Addr().(*net.TCPAddr).Port)
+}
+
+// integrationClient calls the API as a logged-in user, failing the test on any
+// response other than 2xx:
+type integrationClient struct {
+	t     *testing.T
+	base  string
+	token string
+}
+
+func (c *integrationClient) do(method, path, body string, out any) {
+	c.t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.send(req, out)
+}
+
+// upload posts file as the "video" part of a multipart form:
+func (c *integrationClient) upload(path, file string, out any) {
+	c.t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="video"; filename=%q`, filepath.Base(file)))
+	header.Set("Content-Type", "video/mp4")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req, err := http.NewRequest(http.MethodPost, c.base+path, &body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	c.send(req, out)
+}
+
+func (c *integrationClient) send(req *http.Request, out any) {
+	c.t.Helper()
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.StatusCode/100 != 2 {
+		c.t.Fatalf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			c.t.Fatalf("%s %s: couldn't decode %s: %v", req.Method, req.URL.Path, data, err)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const jobKindIntegrityCheck = "integrity_check"
+
+// integrityBlockSize is the size of the blocks checksummed when a video is
+// stored, and so of the range reads that verify them:
+const integrityBlockSize = 1 << 20
+
+// integrityReportLimit caps the flagged videos GET /api/admin/integrity lists:
+const integrityReportLimit = 100
+
+// integrityConfig controls the spot checks that catch stored videos gone
+// missing or corrupt before a viewer does. Each run reads a few blocks of the
+// least recently checked videos back from storage and compares them with the
+// checksums taken at upload.
+type integrityConfig struct {
+	// Interval between background runs (INTEGRITY_CHECK_INTERVAL); 0 disables them.
+	Interval time.Duration
+	// SampleVideos is how many videos a run checks (INTEGRITY_SAMPLE_VIDEOS):
+	SampleVideos int
+	// SampleBlocks is how many blocks of each are read (INTEGRITY_SAMPLE_BLOCKS).
+	// The last block is always one of them, to catch truncation.
+	SampleBlocks int
+}
+
+// integrityRun is what one run found:
+type integrityRun struct {
+	Checked int `json:"checked"`
+	Flagged int `json:"flagged"`
+	// Inconclusive checks (storage unreachable, say) leave the video's status alone:
+	Inconclusive int `json:"inconclusive"`
+}
+
+// blockChecksums takes the SHA-256 of every integrityBlockSize block of the file
+// stored under key:
+func blockChecksums(filePath, key string) (database.BlockChecksums, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return database.BlockChecksums{}, err
+	}
+	defer f.Close()
+
+	blocks := database.BlockChecksums{ObjectKey: key, BlockSize: integrityBlockSize}
+	h := sha256.New()
+	for {
+		h.Reset()
+		n, err := io.CopyN(h, f, integrityBlockSize)
+		if n > 0 {
+			blocks.SizeBytes += n
+			blocks.Checksums = append(blocks.Checksums, hex.EncodeToString(h.Sum(nil)))
+		}
+		if errors.Is(err, io.EOF) {
+			return blocks, nil
+		}
+		if err != nil {
+			return database.BlockChecksums{}, err
+		}
+	}
+}
+
+// recordBlockChecksums keeps the block checksums of a freshly stored file. A
+// video without them is still checked, but only for presence and size, so a
+// failure here is only logged.
+func (cfg *apiConfig) recordBlockChecksums(ctx context.Context, videoID uuid.UUID, key, filePath string) {
+	blocks, err := blockChecksums(filePath, key)
+	if err == nil {
+		err = cfg.db.SetBlockChecksums(ctx, videoID, blocks)
+	}
+	if err != nil {
+		log.Printf("Couldn't record block checksums for video %s: %v", videoID, err)
+	}
+}
+
+// readObjectRange reads n bytes of key from offset off, and returns them with
+// the object's full size, -1 if the store didn't say. Fewer than n bytes come
+// back when the object ends first.
+func (cfg *apiConfig) readObjectRange(ctx context.Context, key string, off, n int64) ([]byte, int64, error) {
+	if rg, ok := cfg.store.(storage.RangeGetter); ok {
+		obj, err := rg.GetRange(ctx, key, storage.RangeOptions{Range: fmt.Sprintf("bytes=%d-%d", off, off+n-1)})
+		if err != nil {
+			return nil, 0, err
+		}
+		defer obj.Body.Close()
+		data, err := io.ReadAll(io.LimitReader(obj.Body, n))
+		if err != nil {
+			return nil, 0, err
+		}
+		total := int64(-1)
+		if _, size, ok := strings.Cut(obj.ContentRange, "/"); ok {
+			if parsed, err := strconv.ParseInt(size, 10, 64); err == nil {
+				total = parsed
+			}
+		}
+		return data, total, nil
+	}
+
+	// The local store opens plain files, which can seek:
+	body, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer body.Close()
+	total := int64(-1)
+	if seeker, ok := body.(io.Seeker); ok {
+		if total, err = seeker.Seek(0, io.SeekEnd); err != nil {
+			return nil, 0, err
+		}
+		if _, err := seeker.Seek(off, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+	} else if _, err := io.CopyN(io.Discard, body, off); err != nil && !errors.Is(err, io.EOF) {
+		return nil, 0, err
+	}
+	data, err := io.ReadAll(io.LimitReader(body, n))
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, total, nil
+}
+
+// checkIntegrity reads sampled blocks of the candidate's object back and
+// returns its status, with what was wrong for a flagged one. An error means
+// the check itself failed and says nothing about the object.
+func (cfg *apiConfig) checkIntegrity(ctx context.Context, candidate database.IntegrityCandidate) (status, problem string, err error) {
+	blocks := candidate.Blocks
+	if blocks == nil {
+		// Nothing to compare the contents with; see that it's there and the
+		// right size:
+		_, total, err := cfg.readObjectRange(ctx, candidate.ObjectKey, 0, 1)
+		if status, problem, ok := integrityReadProblem(err); ok {
+			return status, problem, nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if candidate.SizeBytes != nil && total >= 0 && total != *candidate.SizeBytes {
+			return database.IntegrityCorrupted, fmt.Sprintf("object is %d bytes, expected %d", total, *candidate.SizeBytes), nil
+		}
+		return database.IntegrityOK, "", nil
+	}
+
+	for _, i := range sampleBlocks(len(blocks.Checksums), cfg.integrity.SampleBlocks) {
+		off := int64(i) * blocks.BlockSize
+		n := min(blocks.BlockSize, blocks.SizeBytes-off)
+		data, total, err := cfg.readObjectRange(ctx, candidate.ObjectKey, off, n)
+		if status, problem, ok := integrityReadProblem(err); ok {
+			return status, problem, nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if total >= 0 && total != blocks.SizeBytes {
+			return database.IntegrityCorrupted, fmt.Sprintf("object is %d bytes, expected %d", total, blocks.SizeBytes), nil
+		}
+		if int64(len(data)) != n {
+			return database.IntegrityCorrupted, fmt.Sprintf("block %d is %d bytes, expected %d", i, len(data), n), nil
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != blocks.Checksums[i] {
+			return database.IntegrityCorrupted, fmt.Sprintf("block %d doesn't match its checksum", i), nil
+		}
+	}
+	return database.IntegrityOK, "", nil
+}
+
+// integrityReadProblem reports whether a range read failed because of the
+// object itself rather than the check:
+func integrityReadProblem(err error) (status, problem string, ok bool) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return database.IntegrityMissing, "object not found", true
+	case errors.Is(err, storage.ErrInvalidRange):
+		return database.IntegrityCorrupted, "object is shorter than recorded", true
+	}
+	return "", "", false
+}
+
+// sampleBlocks picks up to n distinct block indexes out of count, the last
+// block always among them:
+func sampleBlocks(count, n int) []int {
+	if count == 0 {
+		return nil
+	}
+	picked := []int{count - 1}
+	for _, i := range rand.Perm(count - 1) {
+		if len(picked) >= n {
+			break
+		}
+		picked = append(picked, i)
+	}
+	return picked
+}
+
+// runIntegrityCheck spot-checks the least recently checked videos and records
+// what it found on each.
+func (cfg *apiConfig) runIntegrityCheck(ctx context.Context, job *jobs.Job) (integrityRun, error) {
+	var run integrityRun
+	candidates, err := cfg.db.GetIntegrityCandidates(ctx, cfg.integrity.SampleVideos)
+	if err != nil {
+		return run, err
+	}
+	for i, candidate := range candidates {
+		if err := ctx.Err(); err != nil {
+			return run, err
+		}
+		status, problem, err := cfg.checkIntegrity(ctx, candidate)
+		if err != nil {
+			log.Printf("Integrity check of video %s inconclusive: %v", candidate.VideoID, err)
+			run.Inconclusive++
+			continue
+		}
+		run.Checked++
+		if status != database.IntegrityOK {
+			run.Flagged++
+			log.Printf("Integrity check flagged video %s (%s) as %s: %s", candidate.VideoID, candidate.ObjectKey, status, problem)
+		}
+		if err := cfg.db.SetVideoIntegrity(ctx, candidate.VideoID, status, problem); err != nil {
+			log.Printf("Couldn't record integrity of video %s: %v", candidate.VideoID, err)
+		}
+		if job != nil {
+			job.SetProgress(float64(i+1) / float64(len(candidates)) * 100)
+		}
+	}
+	if run.Flagged > 0 {
+		log.Printf("Integrity check flagged %d of %d videos", run.Flagged, run.Checked)
+	}
+	return run, nil
+}
+
+// submitIntegrityCheck queues a run on the low-priority tier. ownerID is the
+// admin who asked for it, or uuid.Nil for the background runs.
+func (cfg *apiConfig) submitIntegrityCheck(ctx context.Context, ownerID uuid.UUID) (*jobs.Job, error) {
+	return cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindIntegrityCheck,
+		OwnerID:  ownerID,
+		Priority: jobs.PriorityLow,
+		Run: tracedJob(ctx, jobKindIntegrityCheck, func(ctx context.Context, job *jobs.Job) error {
+			_, err := cfg.runIntegrityCheck(ctx, job)
+			return err
+		}),
+	})
+}
+
+// startIntegrityChecks queues a run every Interval until ctx is done:
+func (cfg *apiConfig) startIntegrityChecks(ctx context.Context) {
+	if cfg.integrity.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.integrity.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := cfg.submitIntegrityCheck(ctx, uuid.Nil); err != nil {
+					log.Printf("Couldn't queue integrity check: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// handlerAdminIntegrityRun queues a spot check now rather than at the next
+// interval.
+func (cfg *apiConfig) handlerAdminIntegrityRun(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	job, err := cfg.submitIntegrityCheck(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Couldn't queue integrity check", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, map[string]any{"job_id": job.ID})
+}
+
+// handlerAdminIntegrity reports how many stored videos are in each integrity
+// state, and which were found missing or corrupted.
+func (cfg *apiConfig) handlerAdminIntegrity(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	report, err := cfg.db.GetIntegrityReport(r.Context(), integrityReportLimit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get integrity report", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// SigningKey is an asymmetric key access tokens are signed with. Its public
+// half is published in the JWKS, under ID, so other services can verify the
+// tokens without the private key or the shared secret.
+type SigningKey struct {
+	// ID is the key's RFC 7638 thumbprint, sent as the token's "kid":
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+}
+
+// JWK is a public key in the JSON Web Key format, RSA or Ed25519 ("OKP"):
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA:
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519:
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json:
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySet signs and verifies access tokens. Without signing keys it uses the
+// shared HS256 secret, like MakeJWT and ValidateJWT. With them, tokens are
+// signed with the first key, RS256 or EdDSA, and carry its kid; the others
+// only verify, so a key can be rotated out once the tokens it signed have
+// expired. HS256 tokens are still accepted while there's a secret, for the
+// switch over.
+type KeySet struct {
+	secret []byte
+	keys   []SigningKey
+	byID   map[string]SigningKey
+}
+
+// NewKeySet returns a key set with the secret (which may be empty when there
+// are keys) and keys, the first of which signs.
+func NewKeySet(secret string, keys ...SigningKey) (*KeySet, error) {
+	if secret == "" && len(keys) == 0 {
+		return nil, errors.New("need a secret or a signing key")
+	}
+	ks := &KeySet{secret: []byte(secret), keys: keys, byID: make(map[string]SigningKey, len(keys))}
+	for _, key := range keys {
+		if _, ok := ks.byID[key.ID]; ok {
+			return nil, fmt.Errorf("signing key %s is listed twice", key.ID)
+		}
+		ks.byID[key.ID] = key
+	}
+	return ks, nil
+}
+
+// LoadSigningKeys reads PEM-encoded RSA or Ed25519 private keys, one per file.
+func LoadSigningKeys(paths []string) ([]SigningKey, error) {
+	keys := make([]SigningKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseSigningKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func parseSigningKey(pemData []byte) (SigningKey, error) {
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemData); err == nil {
+		if rsaKey.N.BitLen() < 2048 {
+			return SigningKey{}, fmt.Errorf("RSA key is %d bits, need at least 2048", rsaKey.N.BitLen())
+		}
+		key := SigningKey{Method: jwt.SigningMethodRS256, Private: rsaKey}
+		key.ID = thumbprint(key.publicJWK())
+		return key, nil
+	}
+	if edKey, err := jwt.ParseEdPrivateKeyFromPEM(pemData); err == nil {
+		if edKey, ok := edKey.(ed25519.PrivateKey); ok {
+			key := SigningKey{Method: jwt.SigningMethodEdDSA, Private: edKey}
+			key.ID = thumbprint(key.publicJWK())
+			return key, nil
+		}
+	}
+	return SigningKey{}, errors.New("not a PEM-encoded RSA or Ed25519 private key")
+}
+
+// publicJWK is the key's public half, as the JWKS lists it:
+func (k SigningKey) publicJWK() JWK {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+	switch pub := k.Private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// thumbprint is the RFC 7638 thumbprint of the key: the SHA-256 of its
+// required members, in lexical order.
+func thumbprint(jwk JWK) string {
+	var members any
+	if jwk.Kty == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWKS lists the public keys tokens may be signed with; empty with HS256 only.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		set.Keys = append(set.Keys, key.publicJWK())
+	}
+	return set
+}
+
+func (ks *KeySet) MakeJWT(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	claims := jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		Subject:   userID.String(),
+	}
+	if len(ks.keys) == 0 {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.secret)
+	}
+	key := ks.keys[0]
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// ValidateJWT checks the token's signature and claims and returns the user it
+// was issued to. A token with a kid must be signed by that key, with that key's
+// algorithm; one without must be HS256 with the secret.
+func (ks *KeySet) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	claims := jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, &claims, ks.verificationKey)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	issuer, err := token.Claims.GetIssuer()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if issuer != string(TokenTypeAccess) {
+		return uuid.Nil, errors.New("invalid issuer")
+	}
+	userIDString, err := token.Claims.GetSubject()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	id, err := uuid.Parse(userIDString)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+	return id, nil
+}
+
+func (ks *KeySet) verificationKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if len(ks.secret) == 0 || token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("token has no key ID")
+		}
+		return ks.secret, nil
+	}
+	key, ok := ks.byID[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("signing key %q is for %s, not %s", kid, key.Method.Alg(), token.Method.Alg())
+	}
+	return key.Private.Public(), nil
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms, as PASSWORD_HASH names them:
+const (
+	PasswordBcrypt   = "bcrypt"
+	PasswordArgon2id = "argon2id"
+)
+
+// ErrPasswordMismatch is returned by Passwords.Check for a wrong password:
+var ErrPasswordMismatch = errors.New("password doesn't match")
+
+// argon2Prefix starts Argon2id hashes in the PHC string format:
+const argon2Prefix = "$argon2id$"
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Argon2Params are the Argon2id cost parameters. They're stored in each hash,
+// so raising them later still checks the old hashes (and rehashes them).
+type Argon2Params struct {
+	// Memory is in KiB:
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// Passwords hashes new passwords with Algorithm, and checks hashes made with
+// either algorithm, so switching only affects passwords hashed from then on.
+// Check says when a hash is out of date, for the caller to replace it while it
+// has the password at hand.
+type Passwords struct {
+	Algorithm string
+	Argon2    Argon2Params
+}
+
+func (p Passwords) Hash(password string) (string, error) {
+	if p.Algorithm != PasswordArgon2id {
+		return HashPassword(password)
+	}
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Argon2.Iterations, p.Argon2.Memory, p.Argon2.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version,
+		p.Argon2.Memory, p.Argon2.Iterations, p.Argon2.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Check returns nil if password matches hash, and whether hash should be
+// replaced by a new Hash of it: it was made with the other algorithm, or with
+// other parameters.
+func (p Passwords) Check(password, hash string) (needsRehash bool, err error) {
+	if !strings.HasPrefix(hash, argon2Prefix) {
+		if err := CheckPasswordHash(password, hash); err != nil {
+			return false, err
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return p.Algorithm == PasswordArgon2id || err != nil || cost != bcrypt.DefaultCost, nil
+	}
+
+	params, salt, key, err := parseArgon2Hash(hash)
+	if err != nil {
+		return false, err
+	}
+	got := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(got, key) != 1 {
+		return false, ErrPasswordMismatch
+	}
+	return p.Algorithm != PasswordArgon2id || params != p.Argon2, nil
+}
+
+// parseArgon2Hash splits "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>":
+func parseArgon2Hash(hash string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, errors.New("malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, errors.New("malformed argon2id key")
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,80 @@
+// Package bufpool reuses the buffers that uploads pass through on their way to
+// temp files and storage, and the ones that collect ffmpeg's and ffprobe's
+// output, so a busy server isn't allocating (and collecting) a fresh one for
+// every copy.
+package bufpool
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSize is the size of copy buffers until SetSize is called.
+const DefaultSize = 256 << 10
+
+// maxPooledBuffer is the largest bytes.Buffer put back in the pool; one that
+// grew past it, say for a chatty ffmpeg or a huge thumbnail, is left to the
+// garbage collector rather than pinned in memory for good.
+const maxPooledBuffer = 4 << 20
+
+var (
+	size    atomic.Int64
+	copies  sync.Pool
+	buffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+)
+
+func init() {
+	size.Store(DefaultSize)
+}
+
+// SetSize sets the size of the copy buffers handed out from then on. Pooled
+// buffers of the old size are dropped as they come back.
+func SetSize(n int) {
+	if n > 0 {
+		size.Store(int64(n))
+	}
+}
+
+// Get returns a copy buffer; give it back with Put once done with it.
+func Get() *[]byte {
+	n := int(size.Load())
+	if buf, ok := copies.Get().(*[]byte); ok && len(*buf) == n {
+		return buf
+	}
+	buf := make([]byte, n)
+	return &buf
+}
+
+// Put returns a buffer from Get to the pool.
+func Put(buf *[]byte) {
+	if len(*buf) == int(size.Load()) {
+		copies.Put(buf)
+	}
+}
+
+// Copy is io.Copy through a pooled buffer. Like io.Copy it hands over to
+// src's WriteTo or dst's ReadFrom when they have one, e.g. file to file, which
+// needs no buffer at all.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := Get()
+	defer Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// GetBuffer returns an empty buffer, for a command's output or an image being
+// re-encoded; give it back with PutBuffer, and copy out anything that must
+// outlive it first.
+func GetBuffer() *bytes.Buffer {
+	return buffers.Get().(*bytes.Buffer)
+}
+
+// PutBuffer returns a buffer from GetBuffer to the pool.
+func PutBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	buffers.Put(buf)
+}
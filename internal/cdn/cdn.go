@@ -0,0 +1,43 @@
+// Package cdn builds the URLs media is played from and purges the caches in
+// front of it. CloudFront and Cloudflare are supported; without a CDN, Origin
+// hands out the store's own URLs.
+package cdn
+
+import (
+	"context"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+type CDN interface {
+	// PublicURL is where anyone can fetch key.
+	PublicURL(key string) string
+	// SignedURL is a URL for key that stops working after ttl, for content the
+	// CDN only serves to signed requests.
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+	// Invalidate drops cached copies of the keys, e.g. after they're deleted.
+	Invalidate(ctx context.Context, keys ...string) error
+}
+
+// Origin serves straight from the store, with no cache to invalidate:
+type Origin struct {
+	Store storage.Store
+}
+
+func (o Origin) PublicURL(key string) string {
+	return o.Store.URL(key)
+}
+
+// SignedURL presigns a GET where the store can (S3), and otherwise returns the
+// public URL, which is all a local store has.
+func (o Origin) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if presigner, ok := o.Store.(storage.GetPresigner); ok {
+		return presigner.PresignGet(ctx, key, ttl)
+	}
+	return o.Store.URL(key), nil
+}
+
+func (o Origin) Invalidate(ctx context.Context, keys ...string) error {
+	return nil
+}
@@ -0,0 +1,123 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// cloudflareMaxPurgeFiles is how many URLs one purge_cache call takes:
+const cloudflareMaxPurgeFiles = 30
+
+// Cloudflare serves media from a Cloudflare-fronted domain: an R2 bucket's
+// custom domain, or a Worker route proxying the bucket.
+//
+// Signed URLs carry exp (Unix seconds) and sig query parameters, where sig is
+// the unpadded base64url HMAC-SHA256 of the URL path and exp joined by a colon,
+// keyed with SigningSecret. Cloudflare itself doesn't check them; the Worker in
+// front of the bucket has to, e.g.:
+//
+//	const [path, exp, sig] = [url.pathname, url.searchParams.get("exp"), url.searchParams.get("sig")]
+//	if (Date.now() / 1000 > exp || sig !== await hmac(env.SIGNING_SECRET, `${path}:${exp}`)) return new Response(null, { status: 403 })
+type Cloudflare struct {
+	// Domain serves the objects, e.g. media.example.com.
+	Domain string
+	// ZoneID and APIToken (with the Cache Purge permission) are needed for
+	// invalidations only:
+	ZoneID   string
+	APIToken string
+	// SigningSecret is shared with the Worker; without it SignedURL fails.
+	SigningSecret string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+func (c *Cloudflare) PublicURL(key string) string {
+	return fmt.Sprintf("https://%s/%s", c.Domain, key)
+}
+
+func (c *Cloudflare) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if c.SigningSecret == "" {
+		return "", errors.New("Cloudflare URL signing isn't configured")
+	}
+	exp := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	path := "/" + (&url.URL{Path: key}).EscapedPath()
+	mac := hmac.New(sha256.New, []byte(c.SigningSecret))
+	mac.Write([]byte(path + ":" + exp))
+
+	query := url.Values{}
+	query.Set("exp", exp)
+	query.Set("sig", base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+	return c.PublicURL(key) + "?" + query.Encode(), nil
+}
+
+func (c *Cloudflare) Invalidate(ctx context.Context, keys ...string) error {
+	if c.ZoneID == "" || c.APIToken == "" {
+		return nil
+	}
+	for len(keys) > 0 {
+		n := min(len(keys), cloudflareMaxPurgeFiles)
+		files := make([]string, n)
+		for i, key := range keys[:n] {
+			files[i] = c.PublicURL(key)
+		}
+		if err := c.purge(ctx, files); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+func (c *Cloudflare) purge(ctx context.Context, files []string) error {
+	body, err := json.Marshal(map[string]any{"files": files})
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/purge_cache", url.PathEscape(c.ZoneID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.APIToken)
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &out); err != nil || !out.Success {
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("cloudflare purge_cache: %s: %d %s", resp.Status, out.Errors[0].Code, out.Errors[0].Message)
+		}
+		return fmt.Errorf("cloudflare purge_cache: %s", resp.Status)
+	}
+	return nil
+}
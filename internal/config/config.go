@@ -0,0 +1,696 @@
+// Package config loads the API server's settings from the environment. Every
+// setting is declared once here, with its default and what it's for, and
+// checked as it's read: required values, numeric ranges, URLs and the values
+// that depend on each other. Load reports every problem at once rather than
+// stopping at the first, so a deployment missing three variables hears about
+// all three before it starts.
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is every setting of the API server, grouped by what it configures.
+type Config struct {
+	// Platform is "dev" or anything else; only dev may reset the database:
+	Platform  string
+	JWTSecret string
+	// JWTSigningKeys are PEM files of the RSA or Ed25519 keys that sign access
+	// tokens instead of JWTSecret, the first signing, see internal/auth:
+	JWTSigningKeys []string
+	Port           string
+	// PublicBaseURL is the server's address as the outside world sees it, for
+	// links to embed pages:
+	PublicBaseURL string
+	FilepathRoot  string
+	AssetsRoot    string
+	// FeatureFlagsFile is a JSON object of feature flags, see internal/flags:
+	FeatureFlagsFile string
+
+	DB          DB
+	Storage     Storage
+	CDN         CDN
+	SignedURLs  SignedURLs
+	Replica     Replica
+	Moderation  Moderation
+	Jobs        Jobs
+	Uploads     Uploads
+	Timeouts    Timeouts
+	Tiers       Tiers
+	Tiering     Tiering
+	AssetGC     AssetGC
+	Integrity   Integrity
+	Versions    Versions
+	Caching     Caching
+	Codecs      Codecs
+	Encoding    Encoding
+	DecodeCheck DecodeCheck
+	HLS         HLS
+	OAuth       OAuth
+	Passwords   Passwords
+	Signing     Signing
+	Cookies     Cookies
+	S3Events    S3Events
+	Search      Search
+	Sentry      Sentry
+	TLS         TLS
+}
+
+type DB struct {
+	Path            string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	QueryTimeout    time.Duration
+}
+
+type Storage struct {
+	// Backend is "s3" or "local"; KeyLayout one of the layouts in
+	// internal/keys:
+	Backend   string
+	KeyLayout string
+	LocalRoot string
+	Bucket    string
+	Region    string
+	// Endpoint is an S3-compatible service to use instead of AWS:
+	Endpoint string
+	// SSEMode and SSEKMSKeyID go through storage.ParseEncryption:
+	SSEMode     string
+	SSEKMSKeyID string
+	// ObjectLockMode and ObjectLockDays go through storage.ParseRetention:
+	ObjectLockMode string
+	ObjectLockDays int
+}
+
+type CDN struct {
+	// Provider is "cloudfront", "cloudflare" or "none":
+	Provider                 string
+	CloudFrontDomain         string
+	CloudFrontDistributionID string
+	CloudFrontKeyPairID      string
+	CloudFrontPrivateKeyFile string
+	CloudflareDomain         string
+	CloudflareZoneID         string
+	CloudflareAPIToken       string
+	CloudflareSigningSecret  string
+}
+
+// SignedURLs sets how long the URLs handed to clients stay valid, by what
+// they're for. ClockSkew is added at the front (S3 signatures are dated that much
+// earlier) and at the back, so a verifier whose clock is a little off from ours
+// still takes them; the expiry clients are told leaves it out.
+type SignedURLs struct {
+	// SignPlayback signs the video URLs in API responses, for distributions
+	// that only serve signed requests:
+	SignPlayback bool
+	PlaybackTTL  time.Duration
+	DownloadTTL  time.Duration
+	UploadTTL    time.Duration
+	ClockSkew    time.Duration
+}
+
+// Replica is off unless Bucket is set:
+type Replica struct {
+	Bucket                   string
+	Region                   string
+	SSEKMSKeyID              string
+	CloudFrontDomain         string
+	CloudFrontDistributionID string
+	Interval                 time.Duration
+}
+
+type Moderation struct {
+	// Provider is "none" or "rekognition":
+	Provider      string
+	MinConfidence int
+}
+
+type Jobs struct {
+	WorkersHigh   int
+	WorkersNormal int
+	WorkersLow    int
+	StarvationAge time.Duration
+	Retention     time.Duration
+	// SmallFileBytes and LargeFileBytes pick an upload's priority tier:
+	SmallFileBytes int64
+	LargeFileBytes int64
+	// Backend is "local", "sqs" or "redis"; the others are for the remote ones:
+	Backend       string
+	SQSQueueURL   string
+	RedisURL      string
+	RedisStream   string
+	StagingPrefix string
+	PollInterval  time.Duration
+	// RetryAttempts and RetryBackoff are for failed transcodes and storage
+	// uploads, before they're dead-lettered:
+	RetryAttempts int
+	RetryBackoff  time.Duration
+}
+
+type Uploads struct {
+	TmpDir string
+	// TmpEncryptionKey is base64 of 32 bytes; raw uploads in TmpDir are
+	// encrypted with it when it's set:
+	TmpEncryptionKey string
+	// DiskHighWater is the percentage of TmpDir's or the assets directory's
+	// disk past which uploads are turned away:
+	DiskHighWater int
+	// Concurrency is how many video uploads are taken in at once, 0 for no
+	// limit; one past it waits up to QueueWait for a slot:
+	Concurrency int
+	QueueWait   time.Duration
+	// Staging is "disk" or "s3":
+	Staging            string
+	MultipartMaxMemory int64
+	// CopyBufferSize is the size of the pooled buffers uploads are copied
+	// through, see internal/bufpool:
+	CopyBufferSize       int64
+	ThumbnailUploadLimit int64
+	MaxDecompressedBody  int64
+	UploadTTL            time.Duration
+	ProbeCacheTTL        time.Duration
+}
+
+type Timeouts struct {
+	Read             time.Duration
+	Write            time.Duration
+	UploadIdle       time.Duration
+	MinUploadRate    int64
+	UploadSlowWindow time.Duration
+	Processing       time.Duration
+}
+
+type Tiers struct {
+	FreeMaxDuration     time.Duration
+	FreeMonthlyDuration time.Duration
+	ProMaxDuration      time.Duration
+	ProMonthlyDuration  time.Duration
+	// bytes uploaded plus bytes streamed through the API a month, 0 for no cap:
+	FreeMonthlyTransfer int64
+	ProMonthlyTransfer  int64
+}
+
+type Tiering struct {
+	ColdAfter            time.Duration
+	MaxViews             int
+	InfrequentAccessDays int
+	GlacierDays          int
+	RestoreDays          int
+	Interval             time.Duration
+}
+
+type AssetGC struct {
+	Grace    time.Duration
+	Interval time.Duration
+}
+
+type Versions struct {
+	Keep     int
+	MaxAge   time.Duration
+	Interval time.Duration
+}
+
+// Caching is the max-age of each kind of stored file; see cache.go.
+type Caching struct {
+	Immutable time.Duration
+	Video     time.Duration
+	Stream    time.Duration
+	Thumbnail time.Duration
+}
+
+type Integrity struct {
+	Interval     time.Duration
+	SampleVideos int
+	SampleBlocks int
+}
+
+type Codecs struct {
+	VideoCodecs  []string
+	PixelFormats []string
+	AudioCodecs  []string
+	Reencode     bool
+}
+
+// Encoding picks the H.264 encoder re-encodes use. It's detected once at
+// startup, so unlike Codecs it takes a restart to change.
+type Encoding struct {
+	// Encoder is "software", "auto" or a hardware encoder; see
+	// transcode.DetectEncoding:
+	Encoder     string
+	VAAPIDevice string
+}
+
+type DecodeCheck struct {
+	// Mode is "sample", "full" or "off":
+	Mode    string
+	Strict  bool
+	Timeout time.Duration
+}
+
+type HLS struct {
+	Encryption bool
+	// KeyEncryptionKey is base64 of 32 bytes:
+	KeyEncryptionKey string
+}
+
+// OAuth holds the app's client credentials at the identity providers users may
+// sign in with; a provider without them is off:
+type OAuth struct {
+	GoogleClientID     string
+	GoogleClientSecret string
+	GitHubClientID     string
+	GitHubClientSecret string
+}
+
+type Passwords struct {
+	// Algorithm is "bcrypt" or "argon2id":
+	Algorithm string
+	// Argon2 costs; memory is in KiB:
+	Argon2Memory      int
+	Argon2Iterations  int
+	Argon2Parallelism int
+}
+
+// Signing configures HMAC-signed requests made with API keys:
+type Signing struct {
+	// Secret derives the keys' signing secrets; without it, signing is off:
+	Secret string
+	Window time.Duration
+}
+
+type Cookies struct {
+	// SameSite is "lax", "strict" or "none":
+	SameSite string
+	Secure   bool
+}
+
+type S3Events struct {
+	TopicARN string
+	Secret   string
+}
+
+type Search struct {
+	// Backend is "sqlite" or "opensearch", what GET /api/videos/search queries:
+	Backend string
+	// OpenSearchURL turns on indexing into OpenSearchIndex when set:
+	OpenSearchURL      string
+	OpenSearchIndex    string
+	OpenSearchUsername string
+	OpenSearchPassword string
+}
+
+type Sentry struct {
+	DSN         string
+	Environment string
+	Release     string
+}
+
+type TLS struct {
+	CertFile         string
+	KeyFile          string
+	AutocertDomains  []string
+	AutocertCacheDir string
+	AutocertEmail    string
+	RedirectAddr     string
+}
+
+// Load reads the configuration from the environment.
+func Load() (*Config, error) {
+	return LoadFrom(os.LookupEnv)
+}
+
+// Settings documents every setting Load reads, in the order it reads them.
+func Settings() []Setting {
+	e := &env{lookup: func(string) (string, bool) { return "", false }}
+	loadFrom(e)
+	return e.settings
+}
+
+// LoadFrom is Load with the variables looked up through lookup, e.g. to
+// re-read a .env file on reload.
+func LoadFrom(lookup func(string) (string, bool)) (*Config, error) {
+	e := &env{lookup: lookup}
+	c := loadFrom(e)
+	if err := e.err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func loadFrom(e *env) *Config {
+	c := &Config{}
+
+	c.DB = DB{
+		Path:            e.required("DB_PATH", "path of the SQLite database"),
+		MaxOpenConns:    e.int("DB_MAX_OPEN_CONNS", 0, 0, 1000, "connection pool size, 0 for no limit"),
+		MaxIdleConns:    e.int("DB_MAX_IDLE_CONNS", 0, 0, 1000, "idle connections kept open, 0 for the driver default"),
+		ConnMaxLifetime: e.duration("DB_CONN_MAX_LIFETIME", 0, "how long a connection is reused, 0 for ever"),
+		QueryTimeout:    e.duration("DB_QUERY_TIMEOUT", 5*time.Second, "bound on every query"),
+	}
+	c.JWTSigningKeys = e.paths("JWT_SIGNING_KEYS", "PEM private keys (RSA or Ed25519) that sign access tokens, published at /.well-known/jwks.json; the first signs, the rest only verify")
+	c.JWTSecret = e.string("JWT_SECRET", "", "secret that signs HS256 access tokens; with JWT_SIGNING_KEYS it only verifies them")
+	e.requireIf(len(c.JWTSigningKeys) == 0, "JWT_SECRET", c.JWTSecret, "without JWT_SIGNING_KEYS")
+	c.Platform = e.required("PLATFORM", `"dev" allows POST /admin/reset`)
+	c.FilepathRoot = e.required("FILEPATH_ROOT", "directory of the web app served at /app/")
+	c.AssetsRoot = e.required("ASSETS_ROOT", "directory of thumbnails and avatars served at /assets/")
+
+	c.Port = e.required("PORT", "port the API listens on")
+	if c.Port != "" {
+		if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+			e.fail("PORT must be a port number between 1 and 65535, got %q", c.Port)
+		}
+	}
+	c.PublicBaseURL = strings.TrimSuffix(e.url("PUBLIC_BASE_URL", "address clients reach the server at, e.g. https://tubely.example.com; http://localhost:$PORT by default"), "/")
+	if c.PublicBaseURL == "" {
+		c.PublicBaseURL = "http://localhost:" + c.Port
+	}
+
+	c.Storage = Storage{
+		Backend:        e.oneOf("STORAGE_BACKEND", "where processed media is stored", "s3", "local"),
+		KeyLayout:      e.oneOf("STORAGE_KEY_LAYOUT", "how media keys are named", "prefix", "cas", "user", "date"),
+		LocalRoot:      e.string("STORAGE_LOCAL_ROOT", "./media", "directory of STORAGE_BACKEND=local"),
+		Bucket:         e.string("S3_BUCKET", "", "bucket of STORAGE_BACKEND=s3"),
+		Region:         e.string("S3_REGION", "", "region of S3_BUCKET"),
+		Endpoint:       e.string("S3_ENDPOINT", "", "URL of an S3-compatible service to use instead of AWS, e.g. LocalStack or MinIO"),
+		SSEMode:        e.oneOf("S3_SSE_MODE", "at-rest encryption of uploaded objects", "none", "sse-s3", "sse-kms"),
+		SSEKMSKeyID:    e.string("S3_SSE_KMS_KEY_ID", "", "KMS key of S3_SSE_MODE=sse-kms, aws/s3 by default"),
+		ObjectLockMode: e.oneOf("S3_OBJECT_LOCK_MODE", "Object Lock retention put on stored media; the bucket must have Object Lock enabled", "none", "governance", "compliance"),
+		ObjectLockDays: e.int("S3_OBJECT_LOCK_DAYS", 0, 0, 36500, "days stored media is retained under S3_OBJECT_LOCK_MODE"),
+	}
+	onS3 := c.Storage.Backend == "s3"
+	e.requireIf(onS3, "S3_BUCKET", c.Storage.Bucket, "with STORAGE_BACKEND=s3")
+	e.requireIf(onS3, "S3_REGION", c.Storage.Region, "with STORAGE_BACKEND=s3")
+	if c.Storage.SSEKMSKeyID != "" && c.Storage.SSEMode != "sse-kms" {
+		e.fail("S3_SSE_KMS_KEY_ID is only used with S3_SSE_MODE=sse-kms")
+	}
+	if c.Storage.ObjectLockMode != "none" {
+		if !onS3 {
+			e.fail("S3_OBJECT_LOCK_MODE needs STORAGE_BACKEND=s3")
+		}
+		if c.Storage.ObjectLockDays == 0 {
+			e.fail("S3_OBJECT_LOCK_DAYS is required with S3_OBJECT_LOCK_MODE=%s", c.Storage.ObjectLockMode)
+		}
+	}
+
+	defaultCDN := "none"
+	if onS3 {
+		defaultCDN = "cloudfront"
+	}
+	c.CDN = CDN{
+		Provider:                 e.string("CDN_PROVIDER", "", `what serves media URLs: "cloudfront" (the default on S3), "cloudflare" or "none"`),
+		CloudFrontDomain:         e.string("S3_CF_DISTRO", "", "domain of the CloudFront distribution in front of S3_BUCKET"),
+		CloudFrontDistributionID: e.string("CLOUDFRONT_DISTRIBUTION_ID", "", "lets deletes invalidate the distribution"),
+		CloudFrontKeyPairID:      e.string("CLOUDFRONT_KEY_PAIR_ID", "", "public key ID for signed URLs"),
+		CloudFrontPrivateKeyFile: e.string("CLOUDFRONT_PRIVATE_KEY_FILE", "", "PEM private key for signed URLs"),
+		CloudflareDomain:         e.string("CLOUDFLARE_DOMAIN", "", "R2 custom domain or Worker route"),
+		CloudflareZoneID:         e.string("CLOUDFLARE_ZONE_ID", "", "zone to purge on deletes"),
+		CloudflareAPIToken:       e.string("CLOUDFLARE_API_TOKEN", "", "API token for purges"),
+		CloudflareSigningSecret:  e.string("CLOUDFLARE_SIGNING_SECRET", "", "secret the Worker checks signed URLs with"),
+	}
+	switch strings.ToLower(c.CDN.Provider) {
+	case "":
+		c.CDN.Provider = defaultCDN
+	case "none", "cloudfront", "cloudflare":
+		c.CDN.Provider = strings.ToLower(c.CDN.Provider)
+	default:
+		e.fail("CDN_PROVIDER must be one of cloudfront, cloudflare, none, got %q", c.CDN.Provider)
+	}
+	if c.CDN.Provider == "cloudfront" && !onS3 {
+		e.fail("CDN_PROVIDER=cloudfront needs STORAGE_BACKEND=s3")
+	}
+	e.requireIf(c.CDN.Provider == "cloudfront", "S3_CF_DISTRO", c.CDN.CloudFrontDomain, "with CDN_PROVIDER=cloudfront")
+	e.requireIf(c.CDN.CloudFrontPrivateKeyFile != "", "CLOUDFRONT_KEY_PAIR_ID", c.CDN.CloudFrontKeyPairID, "with CLOUDFRONT_PRIVATE_KEY_FILE")
+	e.requireIf(c.CDN.Provider == "cloudflare", "CLOUDFLARE_DOMAIN", c.CDN.CloudflareDomain, "with CDN_PROVIDER=cloudflare")
+
+	c.SignedURLs = SignedURLs{
+		SignPlayback: e.bool("SIGN_PLAYBACK_URLS", false, "sign the video URLs in API responses, for a CDN or bucket that only serves signed requests"),
+		PlaybackTTL:  e.duration("SIGNED_URL_PLAYBACK_TTL", 6*time.Hour, "how long signed playback URLs stay valid"),
+		DownloadTTL:  e.duration("SIGNED_URL_DOWNLOAD_TTL", time.Hour, "how long signed download URLs stay valid"),
+		UploadTTL:    e.duration("SIGNED_URL_UPLOAD_TTL", 15*time.Minute, "how long presigned direct upload URLs and policies stay valid"),
+		ClockSkew:    e.duration("SIGNED_URL_CLOCK_SKEW", time.Minute, "how far off the clocks checking signed URLs may be from ours"),
+	}
+	// S3 won't presign for longer than 7 days:
+	for _, ttl := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"SIGNED_URL_PLAYBACK_TTL", c.SignedURLs.PlaybackTTL},
+		{"SIGNED_URL_DOWNLOAD_TTL", c.SignedURLs.DownloadTTL},
+		{"SIGNED_URL_UPLOAD_TTL", c.SignedURLs.UploadTTL},
+	} {
+		if ttl.value <= 0 || ttl.value+2*c.SignedURLs.ClockSkew > 7*24*time.Hour {
+			e.fail("%s must be positive and, with twice SIGNED_URL_CLOCK_SKEW, at most 7 days, got %s", ttl.name, ttl.value)
+		}
+	}
+
+	c.Replica = Replica{
+		Bucket:                   e.string("REPLICA_BUCKET", "", "second bucket every video is copied to and played from while the primary is down"),
+		Region:                   e.string("REPLICA_REGION", "", "region of REPLICA_BUCKET, S3_REGION by default"),
+		SSEKMSKeyID:              e.string("REPLICA_SSE_KMS_KEY_ID", "", "KMS key of the replica with S3_SSE_MODE=sse-kms"),
+		CloudFrontDomain:         e.string("REPLICA_CF_DISTRO", "", "CloudFront distribution in front of REPLICA_BUCKET"),
+		CloudFrontDistributionID: e.string("REPLICA_CLOUDFRONT_DISTRIBUTION_ID", "", "lets deletes invalidate the replica's distribution"),
+		Interval:                 e.duration("REPLICATION_INTERVAL", 5*time.Minute, "how often missed copies are retried"),
+	}
+	if c.Replica.Bucket != "" && !onS3 {
+		e.fail("REPLICA_BUCKET needs STORAGE_BACKEND=s3")
+	}
+	if c.Replica.Region == "" {
+		c.Replica.Region = c.Storage.Region
+	}
+
+	c.Moderation = Moderation{
+		Provider:      e.oneOf("MODERATION_PROVIDER", "what screens new videos and thumbnails", "none", "rekognition"),
+		MinConfidence: e.int("MODERATION_MIN_CONFIDENCE", 80, 0, 100, "confidence (%) from which a label flags the video"),
+	}
+	if c.Moderation.Provider == "rekognition" && !onS3 {
+		e.fail("MODERATION_PROVIDER=rekognition needs STORAGE_BACKEND=s3")
+	}
+
+	c.Jobs = Jobs{
+		WorkersHigh:    e.int("JOB_WORKERS_HIGH", max(runtime.NumCPU()/2, 1), 0, 1024, "workers for small uploads, half the CPUs by default"),
+		WorkersNormal:  e.int("JOB_WORKERS_NORMAL", 1, 0, 1024, "workers for medium uploads"),
+		WorkersLow:     e.int("JOB_WORKERS_LOW", 1, 0, 1024, "workers for large uploads"),
+		StarvationAge:  e.duration("JOB_STARVATION_AGE", 10*time.Minute, "wait after which a job is bumped up a tier"),
+		Retention:      e.duration("JOB_RETENTION", time.Hour, "how long finished jobs stay visible"),
+		SmallFileBytes: e.bytes("JOB_SMALL_FILE_BYTES", 100<<20, 0, "uploads up to this size are high priority"),
+		LargeFileBytes: e.bytes("JOB_LARGE_FILE_BYTES", 500<<20, 0, "uploads from this size are low priority"),
+		Backend:        e.oneOf("JOB_BACKEND", "where transcodes run: here, or on cmd/tubely-worker processes", "local", "sqs", "redis"),
+		SQSQueueURL:    e.url("SQS_QUEUE_URL", "queue of JOB_BACKEND=sqs"),
+		RedisURL:       e.string("REDIS_URL", "", "server of JOB_BACKEND=redis"),
+		RedisStream:    e.string("REDIS_STREAM", "", "stream of JOB_BACKEND=redis"),
+		StagingPrefix:  e.string("TRANSCODE_STAGING_PREFIX", "staging/", "bucket prefix files go through to and from the workers"),
+		PollInterval:   e.duration("TRANSCODE_POLL_INTERVAL", 2*time.Second, "how often remote results are checked for"),
+		RetryAttempts:  e.int("JOB_RETRY_ATTEMPTS", 3, 1, 100, "times a transcode or storage upload is tried before the upload is dead-lettered"),
+		RetryBackoff:   e.duration("JOB_RETRY_BACKOFF", 5*time.Second, "wait before the first retry; it doubles after each"),
+	}
+	if c.Jobs.LargeFileBytes < c.Jobs.SmallFileBytes {
+		e.fail("JOB_LARGE_FILE_BYTES can't be below JOB_SMALL_FILE_BYTES")
+	}
+	remote := c.Jobs.Backend != "local"
+	if remote && !onS3 {
+		e.fail("JOB_BACKEND=%s needs STORAGE_BACKEND=s3", c.Jobs.Backend)
+	}
+	e.requireIf(c.Jobs.Backend == "sqs", "SQS_QUEUE_URL", c.Jobs.SQSQueueURL, "with JOB_BACKEND=sqs")
+	e.requireIf(c.Jobs.Backend == "redis", "REDIS_URL", c.Jobs.RedisURL, "with JOB_BACKEND=redis")
+
+	c.Uploads = Uploads{
+		TmpDir:               e.string("UPLOAD_TMP_DIR", os.TempDir(), "where raw uploads wait while they're processed"),
+		DiskHighWater:        e.int("DISK_HIGH_WATER_PERCENT", 90, 1, 100, "disk usage of UPLOAD_TMP_DIR or ASSETS_ROOT past which uploads get 507 and a cleanup runs"),
+		TmpEncryptionKey:     e.string("UPLOAD_TMP_ENCRYPTION_KEY", "", "base64 of a 32-byte key raw uploads are encrypted with in UPLOAD_TMP_DIR; MP4s then have to be fast-start"),
+		Concurrency:          e.int("UPLOAD_CONCURRENCY", runtime.NumCPU(), 0, 4096, "video uploads taken in at once, the CPU count by default, 0 for no limit"),
+		QueueWait:            e.duration("UPLOAD_QUEUE_WAIT", 10*time.Second, "how long an upload past UPLOAD_CONCURRENCY waits for a slot before a 503"),
+		Staging:              e.oneOf("UPLOAD_STAGING", "where video uploads wait for processing", "disk", "s3"),
+		MultipartMaxMemory:   e.bytes("MULTIPART_MAX_MEMORY", 10<<20, 0, "how much of a multipart form is kept in RAM before spilling to disk"),
+		CopyBufferSize:       e.bytes("COPY_BUFFER_SIZE", 256<<10, 4<<10, "size of the pooled buffers uploads are copied to disk and storage through"),
+		ThumbnailUploadLimit: e.bytes("THUMBNAIL_UPLOAD_LIMIT", 10<<20, 1, "largest thumbnail upload"),
+		MaxDecompressedBody:  e.bytes("MAX_DECOMPRESSED_BODY", 1<<20, 1, "largest request body after decompression"),
+		UploadTTL:            e.duration("UPLOAD_TTL", 7*24*time.Hour, "how long a paused resumable upload is kept, 0 for ever"),
+		ProbeCacheTTL:        e.duration("PROBE_CACHE_TTL", 30*24*time.Hour, "how long an unread probe cache entry is kept, 0 for ever"),
+	}
+	if c.Uploads.Staging == "s3" {
+		if !remote {
+			e.fail("UPLOAD_STAGING=s3 needs JOB_BACKEND workers to transcode the staged uploads")
+		}
+		if c.Storage.KeyLayout == "cas" {
+			e.fail("UPLOAD_STAGING=s3 doesn't support STORAGE_KEY_LAYOUT=cas")
+		}
+	}
+
+	c.Timeouts = Timeouts{
+		Read:             e.duration("API_READ_TIMEOUT", 30*time.Second, "read deadline of ordinary API calls"),
+		Write:            e.duration("API_WRITE_TIMEOUT", time.Minute, "write deadline of ordinary API calls"),
+		UploadIdle:       e.duration("UPLOAD_IDLE_TIMEOUT", time.Minute, "how long an upload body may stall"),
+		MinUploadRate:    e.bytes("UPLOAD_MIN_RATE", 16<<10, 0, "slowest an upload may arrive, in bytes/s, 0 for no floor"),
+		UploadSlowWindow: e.duration("UPLOAD_SLOW_WINDOW", 2*time.Minute, "window UPLOAD_MIN_RATE is averaged over"),
+		Processing:       e.duration("PROCESSING_TIMEOUT", 30*time.Minute, "how long a handler may process a file"),
+	}
+
+	c.Tiers = Tiers{
+		FreeMaxDuration:     e.duration("FREE_MAX_DURATION", 15*time.Minute, "longest upload of the free tier"),
+		FreeMonthlyDuration: time.Duration(e.int("FREE_MONTHLY_MINUTES", 120, 0, 1<<20, "minutes of media the free tier may process a month")) * time.Minute,
+		ProMaxDuration:      e.duration("PRO_MAX_DURATION", 4*time.Hour, "longest upload of the pro tier"),
+		ProMonthlyDuration:  time.Duration(e.int("PRO_MONTHLY_MINUTES", 3000, 0, 1<<20, "minutes of media the pro tier may process a month")) * time.Minute,
+		FreeMonthlyTransfer: int64(e.int("FREE_MONTHLY_TRANSFER_GB", 0, 0, 1<<20, "GiB the free tier may upload and stream through the API a month, 0 for no cap")) << 30,
+		ProMonthlyTransfer:  int64(e.int("PRO_MONTHLY_TRANSFER_GB", 0, 0, 1<<20, "GiB the pro tier may upload and stream through the API a month, 0 for no cap")) << 30,
+	}
+
+	c.Tiering = Tiering{
+		ColdAfter:            e.duration("COLD_AFTER", 90*24*time.Hour, "period a video's views are counted over for cold tiering"),
+		MaxViews:             e.int("COLD_MAX_VIEWS", 0, 0, 1<<30, "views in COLD_AFTER up to which a video is cold"),
+		InfrequentAccessDays: e.int("COLD_IA_DAYS", 30, 0, 36500, "days before cold videos move to Infrequent Access"),
+		GlacierDays:          e.int("COLD_GLACIER_DAYS", 90, 0, 36500, "days before cold videos move to Glacier"),
+		RestoreDays:          e.int("COLD_RESTORE_DAYS", 7, 1, 365, "days a restored Glacier object stays readable"),
+		Interval:             e.duration("TIERING_INTERVAL", 24*time.Hour, "how often videos are checked for tiering"),
+	}
+	c.AssetGC = AssetGC{
+		Grace:    e.duration("ASSET_GC_GRACE", 24*time.Hour, "age from which unreferenced assets are removed"),
+		Interval: e.duration("ASSET_GC_INTERVAL", 24*time.Hour, "how often assets are collected"),
+	}
+	c.Versions = Versions{
+		Keep:     e.int("VERSION_KEEP", 5, 1, 1000, "earlier versions kept per video; older ones are pruned"),
+		MaxAge:   e.duration("VERSION_MAX_AGE", 0, "age from which earlier versions are pruned, however few; 0 prunes by count only"),
+		Interval: e.duration("VERSION_PRUNE_INTERVAL", 24*time.Hour, "how often earlier versions are pruned; 0 disables"),
+	}
+	c.Caching = Caching{
+		Immutable: e.duration("CACHE_IMMUTABLE_MAX_AGE", 365*24*time.Hour, "max-age of videos under content-derived keys, which are also marked immutable"),
+		Video:     e.duration("CACHE_VIDEO_MAX_AGE", 24*time.Hour, "max-age of videos under random keys; 0 for no-cache"),
+		Stream:    e.duration("CACHE_STREAM_MAX_AGE", 24*time.Hour, "max-age of HLS/DASH playlists and segments; 0 for no-cache"),
+		Thumbnail: e.duration("CACHE_THUMBNAIL_MAX_AGE", 5*time.Minute, "max-age of thumbnails and other files under /assets/; 0 for no-cache"),
+	}
+	c.Integrity = Integrity{
+		Interval:     e.duration("INTEGRITY_CHECK_INTERVAL", 6*time.Hour, "how often stored videos are spot-checked for corruption; 0 disables"),
+		SampleVideos: e.int("INTEGRITY_SAMPLE_VIDEOS", 20, 1, 10000, "videos checked per integrity run"),
+		SampleBlocks: e.int("INTEGRITY_SAMPLE_BLOCKS", 3, 1, 100, "blocks of each video read back and verified"),
+	}
+
+	c.Codecs = Codecs{
+		VideoCodecs:  e.list("ALLOWED_VIDEO_CODECS", "h264", "video codecs kept as uploaded; others are re-encoded"),
+		PixelFormats: e.list("ALLOWED_PIXEL_FORMATS", "yuv420p", "pixel formats kept as uploaded"),
+		AudioCodecs:  e.list("ALLOWED_AUDIO_CODECS", "aac", "audio codecs kept as uploaded"),
+		Reencode:     e.bool("AUTO_REENCODE", true, "re-encode streams outside the ALLOWED_* lists"),
+	}
+	for _, list := range []struct {
+		name   string
+		values []string
+	}{
+		{"ALLOWED_VIDEO_CODECS", c.Codecs.VideoCodecs},
+		{"ALLOWED_PIXEL_FORMATS", c.Codecs.PixelFormats},
+		{"ALLOWED_AUDIO_CODECS", c.Codecs.AudioCodecs},
+	} {
+		if len(list.values) == 0 {
+			e.fail("%s must list at least one value", list.name)
+		}
+	}
+	c.Encoding = Encoding{
+		Encoder:     e.oneOf("VIDEO_ENCODER", "H.264 encoder re-encodes use: libx264, the first hardware encoder that works, or that one, falling back to libx264", "software", "auto", "nvenc", "videotoolbox", "vaapi"),
+		VAAPIDevice: e.string("VAAPI_DEVICE", "/dev/dri/renderD128", "render node the vaapi encoder uses"),
+	}
+	c.DecodeCheck = DecodeCheck{
+		Mode:    e.oneOf("DECODE_CHECK", "how much of an upload is decoded before processing", "sample", "full", "off"),
+		Strict:  e.bool("DECODE_CHECK_STRICT", true, "reject uploads with any decoding error"),
+		Timeout: e.duration("DECODE_CHECK_TIMEOUT", 10*time.Minute, "bound on the decode check"),
+	}
+
+	c.FeatureFlagsFile = e.string("FEATURE_FLAGS_FILE", "", "JSON object of feature flags; ENABLE_HLS and the like override it")
+	c.HLS = HLS{
+		Encryption:       e.bool("HLS_ENCRYPTION", false, "AES-128 encrypt HLS segments"),
+		KeyEncryptionKey: e.string("HLS_KEY_ENCRYPTION_KEY", "", "base64 of the 32-byte key the HLS keys are sealed with"),
+	}
+	e.requireIf(c.HLS.Encryption, "HLS_KEY_ENCRYPTION_KEY", c.HLS.KeyEncryptionKey, "with HLS_ENCRYPTION=true")
+
+	c.OAuth = OAuth{
+		GoogleClientID:     e.string("OAUTH_GOOGLE_CLIENT_ID", "", "OAuth client ID for signing in with Google; the redirect URI is $PUBLIC_BASE_URL/api/auth/google/callback"),
+		GoogleClientSecret: e.string("OAUTH_GOOGLE_CLIENT_SECRET", "", "OAuth client secret for signing in with Google"),
+		GitHubClientID:     e.string("OAUTH_GITHUB_CLIENT_ID", "", "OAuth client ID for signing in with GitHub; the redirect URI is $PUBLIC_BASE_URL/api/auth/github/callback"),
+		GitHubClientSecret: e.string("OAUTH_GITHUB_CLIENT_SECRET", "", "OAuth client secret for signing in with GitHub"),
+	}
+	e.requireIf(c.OAuth.GoogleClientID != "", "OAUTH_GOOGLE_CLIENT_SECRET", c.OAuth.GoogleClientSecret, "with OAUTH_GOOGLE_CLIENT_ID")
+	e.requireIf(c.OAuth.GitHubClientID != "", "OAUTH_GITHUB_CLIENT_SECRET", c.OAuth.GitHubClientSecret, "with OAUTH_GITHUB_CLIENT_ID")
+
+	c.Passwords = Passwords{
+		Algorithm:         e.oneOf("PASSWORD_HASH", "algorithm new passwords are hashed with; hashes of the other are replaced at login", "bcrypt", "argon2id"),
+		Argon2Memory:      e.int("ARGON2_MEMORY_KIB", 64*1024, 8*1024, 4*1024*1024, "memory one argon2id hash uses, in KiB"),
+		Argon2Iterations:  e.int("ARGON2_ITERATIONS", 3, 1, 100, "passes over memory of one argon2id hash"),
+		Argon2Parallelism: e.int("ARGON2_PARALLELISM", 2, 1, 255, "threads one argon2id hash uses"),
+	}
+
+	c.Signing = Signing{
+		Secret: e.string("REQUEST_SIGNING_SECRET", "", "secret API keys' request signing secrets are derived from; changing it changes them all"),
+		Window: e.duration("REQUEST_SIGNING_WINDOW", 5*time.Minute, "how far a signed request's timestamp may be from the server's clock"),
+	}
+	if c.Signing.Window <= 0 {
+		e.fail("REQUEST_SIGNING_WINDOW must be positive")
+	}
+
+	c.Cookies = Cookies{
+		SameSite: e.oneOf("COOKIE_SAMESITE", "SameSite of session cookies", "lax", "strict", "none"),
+		Secure:   e.bool("COOKIE_SECURE", true, "send session cookies over HTTPS only"),
+	}
+	if c.Cookies.SameSite == "none" && !c.Cookies.Secure {
+		e.fail("COOKIE_SAMESITE=none needs COOKIE_SECURE=true, browsers reject it otherwise")
+	}
+
+	c.S3Events = S3Events{
+		TopicARN: e.string("S3_EVENTS_TOPIC_ARN", "", "SNS topic S3 event notifications are accepted from"),
+		Secret:   e.string("S3_EVENTS_SECRET", "", "HMAC secret of direct S3 event deliveries"),
+	}
+	c.Search = Search{
+		Backend:            e.oneOf("SEARCH_BACKEND", "what video search queries; opensearch is for catalogs too big for SQLite", "sqlite", "opensearch"),
+		OpenSearchURL:      e.url("OPENSEARCH_URL", "OpenSearch or Elasticsearch cluster videos are indexed into"),
+		OpenSearchIndex:    e.string("OPENSEARCH_INDEX", "tubely-videos", "index of OPENSEARCH_URL videos are kept in"),
+		OpenSearchUsername: e.string("OPENSEARCH_USERNAME", "", "basic auth user of OPENSEARCH_URL"),
+		OpenSearchPassword: e.string("OPENSEARCH_PASSWORD", "", "basic auth password of OPENSEARCH_URL"),
+	}
+	e.requireIf(c.Search.Backend == "opensearch", "OPENSEARCH_URL", c.Search.OpenSearchURL, "with SEARCH_BACKEND=opensearch")
+
+	c.Sentry = Sentry{
+		DSN:         e.url("SENTRY_DSN", "where panics are reported"),
+		Environment: e.string("SENTRY_ENVIRONMENT", "", "environment label of Sentry events"),
+		Release:     e.string("SENTRY_RELEASE", "", "release label of Sentry events"),
+	}
+
+	c.TLS = TLS{
+		CertFile:         e.string("TLS_CERT_FILE", "", "certificate to serve HTTPS with"),
+		KeyFile:          e.string("TLS_KEY_FILE", "", "key of TLS_CERT_FILE"),
+		AutocertDomains:  e.list("TLS_AUTOCERT_DOMAINS", "", "host names to get Let's Encrypt certificates for"),
+		AutocertCacheDir: e.string("TLS_AUTOCERT_CACHE_DIR", "", "where issued certificates are kept, ./autocert-cache with autocert"),
+		AutocertEmail:    e.string("TLS_AUTOCERT_EMAIL", "", "contact address for Let's Encrypt"),
+		RedirectAddr:     e.string("TLS_REDIRECT_ADDR", "", "address of the plain HTTP redirect listener, :80 with autocert"),
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		e.fail("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLS.CertFile != "" && len(c.TLS.AutocertDomains) > 0 {
+		e.fail("set either TLS_CERT_FILE/TLS_KEY_FILE or TLS_AUTOCERT_DOMAINS, not both")
+	}
+	if len(c.TLS.AutocertDomains) > 0 {
+		if c.TLS.AutocertCacheDir == "" {
+			c.TLS.AutocertCacheDir = "./autocert-cache"
+		}
+		if c.TLS.RedirectAddr == "" {
+			c.TLS.RedirectAddr = ":80"
+		}
+	}
+
+	return c
+}
+
+// PrintSettings writes the documentation of every setting, for -config-help:
+func PrintSettings(w io.Writer) {
+	for _, s := range Settings() {
+		line := s.Name
+		switch {
+		case s.Required:
+			line += " (required)"
+		case s.Default != "":
+			line += fmt.Sprintf(" (default %s)", s.Default)
+		}
+		fmt.Fprintf(w, "%s\n    %s\n", line, s.Doc)
+	}
+}
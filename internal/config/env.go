@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Setting documents one environment variable, as -config-help lists it.
+type Setting struct {
+	Name string
+	// Default is the value used when the variable is unset, as it would be
+	// written; empty for none.
+	Default  string
+	Required bool
+	Doc      string
+}
+
+// Error is every problem Load found, so they can all be fixed in one go.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid configuration:")
+	for _, problem := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(problem)
+	}
+	return b.String()
+}
+
+// env reads settings through lookup (os.LookupEnv outside tests). Each getter
+// records the setting it was asked for and, instead of stopping at a bad value,
+// notes the problem and returns the default, so one Load finds every problem.
+type env struct {
+	lookup   func(string) (string, bool)
+	settings []Setting
+	problems []string
+}
+
+func (e *env) get(name, def string, required bool, doc string) string {
+	e.settings = append(e.settings, Setting{Name: name, Default: def, Required: required, Doc: doc})
+	value, _ := e.lookup(name)
+	return strings.TrimSpace(value)
+}
+
+func (e *env) fail(format string, args ...any) {
+	e.problems = append(e.problems, fmt.Sprintf(format, args...))
+}
+
+// string reads an optional setting:
+func (e *env) string(name, def, doc string) string {
+	if value := e.get(name, def, false, doc); value != "" {
+		return value
+	}
+	return def
+}
+
+// required reads a setting that has no sensible default:
+func (e *env) required(name, doc string) string {
+	value := e.get(name, "", true, doc)
+	if value == "" {
+		e.fail("%s is required: %s", name, doc)
+	}
+	return value
+}
+
+// requireIf reports name as missing when value is empty but cond says it's
+// needed, e.g. S3_BUCKET with STORAGE_BACKEND=s3:
+func (e *env) requireIf(cond bool, name, value, why string) {
+	if cond && value == "" {
+		e.fail("%s is required %s", name, why)
+	}
+}
+
+// oneOf reads a setting that takes one of a few values; the first is the
+// default.
+func (e *env) oneOf(name, doc string, options ...string) string {
+	doc = fmt.Sprintf("%s (%s)", doc, strings.Join(options, ", "))
+	value := strings.ToLower(e.string(name, options[0], doc))
+	for _, option := range options {
+		if value == option {
+			return value
+		}
+	}
+	e.fail("%s must be one of %s, got %q", name, strings.Join(options, ", "), value)
+	return options[0]
+}
+
+// int reads an integer setting within [lo, hi]:
+func (e *env) int(name string, def, lo, hi int, doc string) int {
+	value := e.get(name, strconv.Itoa(def), false, doc)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		e.fail("%s must be an integer, got %q", name, value)
+		return def
+	}
+	if n < lo || n > hi {
+		e.fail("%s must be between %d and %d, got %d", name, lo, hi, n)
+		return def
+	}
+	return n
+}
+
+// bytes reads a size in bytes, at least lo:
+func (e *env) bytes(name string, def, lo int64, doc string) int64 {
+	value := e.get(name, strconv.FormatInt(def, 10), false, doc)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		e.fail("%s must be a number of bytes, got %q", name, value)
+		return def
+	}
+	if n < lo {
+		e.fail("%s must be at least %d, got %d", name, lo, n)
+		return def
+	}
+	return n
+}
+
+// bool reads an on/off setting like "true" or "0":
+func (e *env) bool(name string, def bool, doc string) bool {
+	value := e.get(name, strconv.FormatBool(def), false, doc)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.fail("%s must be true or false, got %q", name, value)
+		return def
+	}
+	return b
+}
+
+// duration reads a non-negative duration like "30s" or "5m":
+func (e *env) duration(name string, def time.Duration, doc string) time.Duration {
+	value := e.get(name, def.String(), false, doc)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		e.fail("%s must be a duration (e.g. 30s), got %q", name, value)
+		return def
+	}
+	if d < 0 {
+		e.fail("%s can't be negative, got %s", name, value)
+		return def
+	}
+	return d
+}
+
+// url reads an optional absolute http(s) URL:
+func (e *env) url(name, doc string) string {
+	value := e.string(name, "", doc)
+	if value == "" {
+		return ""
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		e.fail("%s must be an http(s) URL, got %q", name, value)
+		return ""
+	}
+	return value
+}
+
+// list reads a comma-separated setting, lower-cased:
+func (e *env) list(name, def, doc string) []string {
+	value := e.string(name, def, doc)
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, strings.ToLower(item))
+		}
+	}
+	return list
+}
+
+// paths reads a comma-separated list of file paths, kept as written:
+func (e *env) paths(name, doc string) []string {
+	var paths []string
+	for _, item := range strings.Split(e.string(name, "", doc), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			paths = append(paths, item)
+		}
+	}
+	return paths
+}
+
+// err is the problems found so far, or nil:
+func (e *env) err() error {
+	if len(e.problems) == 0 {
+		return nil
+	}
+	return &Error{Problems: e.problems}
+}
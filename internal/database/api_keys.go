@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey lets a script or CI pipeline act as its user, limited to Scopes.
+// Only the key's SHA-256 is stored; the key itself is shown once, when it's
+// created.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Name   string    `json:"name"`
+	// Prefix is the start of the key, to tell keys apart in listings:
+	Prefix    string     `json:"prefix"`
+	KeyHash   string     `json:"-"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+const apiKeyColumns = `id, created_at, last_used_at, revoked_at, user_id, name, prefix, key_hash, scopes, expires_at`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var (
+		key    APIKey
+		scopes string
+	)
+	err := row.Scan(&key.ID, &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &key.ExpiresAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.Scopes = strings.Split(scopes, ",")
+	return key, nil
+}
+
+func (c Client) CreateAPIKey(ctx context.Context, params CreateAPIKeyParams) (APIKey, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	INSERT INTO api_keys (id, created_at, user_id, name, prefix, key_hash, scopes, expires_at)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	RETURNING ` + apiKeyColumns
+	// In CURRENT_TIMESTAMP's format, so the expiry check is a plain string compare:
+	var expiresAt *string
+	if params.ExpiresAt != nil {
+		formatted := params.ExpiresAt.UTC().Format(time.DateTime)
+		expiresAt = &formatted
+	}
+	row := c.db.QueryRowContext(ctx, query, uuid.New(), params.UserID, params.Name, params.Prefix, params.KeyHash, strings.Join(params.Scopes, ","), expiresAt)
+	return scanAPIKey(row)
+}
+
+// GetAPIKeyByHash returns the live key with the hash, or nil when there's none,
+// or it was revoked or has expired.
+func (c Client) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	return c.getLiveAPIKey(ctx, "key_hash", keyHash)
+}
+
+// GetLiveAPIKey is GetAPIKeyByHash by the key's ID, for signed requests, which
+// name their key rather than send it.
+func (c Client) GetLiveAPIKey(ctx context.Context, keyID uuid.UUID) (*APIKey, error) {
+	return c.getLiveAPIKey(ctx, "id", keyID)
+}
+
+func (c Client) getLiveAPIKey(ctx context.Context, column string, value any) (*APIKey, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT ` + apiKeyColumns + `
+	FROM api_keys
+	WHERE ` + column + ` = ?
+		AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+	`
+	key, err := scanAPIKey(c.db.QueryRowContext(ctx, query, value))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAPIKeys lists the user's keys, revoked ones included, newest first.
+func (c Client) GetAPIKeys(ctx context.Context, userID uuid.UUID) ([]APIKey, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT ` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes the user's key; false means the user has no such key.
+// Revoking twice is fine.
+func (c Client) RevokeAPIKey(ctx context.Context, userID, keyID uuid.UUID) (bool, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE api_keys
+	SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP)
+	WHERE id = ? AND user_id = ?
+	`
+	res, err := c.db.ExecContext(ctx, query, keyID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// TouchAPIKey records that the key was used. It's written at most once a
+// minute, so a busy pipeline doesn't turn every request into a write.
+func (c Client) TouchAPIKey(ctx context.Context, keyID uuid.UUID) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE api_keys
+	SET last_used_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)
+	`
+	_, err := c.db.ExecContext(ctx, query, keyID, time.Now().UTC().Add(-time.Minute).Format(time.DateTime))
+	return err
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+)
+
+// AssetReferences is everything in the database that points at a file in the
+// assets directory.
+type AssetReferences struct {
+	// ThumbnailURLs are the videos' thumbnail_url values and the URLs in their
+	// thumbnails, deleted videos' included, as they are stored (full URLs).
+	ThumbnailURLs []string
+	// Paths are the users' avatar and watermark asset paths.
+	Paths []string
+}
+
+// GetAssetReferences collects the asset references of every video and user:
+func (c Client) GetAssetReferences(ctx context.Context) (AssetReferences, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT 'url', thumbnail_url FROM videos WHERE thumbnail_url IS NOT NULL
+	UNION ALL
+	SELECT 'urls', thumbnails FROM videos WHERE thumbnails IS NOT NULL
+	UNION ALL
+	SELECT 'path', avatar_path FROM users WHERE avatar_path IS NOT NULL
+	UNION ALL
+	SELECT 'path', watermark_path FROM users WHERE watermark_path IS NOT NULL
+	`
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return AssetReferences{}, err
+	}
+	defer rows.Close()
+
+	var refs AssetReferences
+	for rows.Next() {
+		var kind, value string
+		if err := rows.Scan(&kind, &value); err != nil {
+			return AssetReferences{}, err
+		}
+		switch kind {
+		case "url":
+			refs.ThumbnailURLs = append(refs.ThumbnailURLs, value)
+		case "urls":
+			var thumbnails Thumbnails
+			if err := thumbnails.Scan(value); err != nil {
+				return AssetReferences{}, err
+			}
+			for _, url := range thumbnails {
+				refs.ThumbnailURLs = append(refs.ThumbnailURLs, url)
+			}
+		default:
+			refs.Paths = append(refs.Paths, value)
+		}
+	}
+	return refs, rows.Err()
+}
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// GetAvatarPath returns the asset path of the user's avatar, or "" if none.
+func (c Client) GetAvatarPath(ctx context.Context, userID uuid.UUID) (string, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var path *string
+	err := c.db.QueryRowContext(ctx, `SELECT avatar_path FROM users WHERE id = ?`, userID.String()).Scan(&path)
+	if err != nil || path == nil {
+		return "", err
+	}
+	return *path, nil
+}
+
+// SetAvatar stores the avatar's asset path and public URL. Pass nil for both to
+// remove it.
+func (c Client) SetAvatar(ctx context.Context, userID uuid.UUID, path, url *string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE users
+	SET avatar_path = ?, avatar_url = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, path, url, userID.String())
+	return err
+}
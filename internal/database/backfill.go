@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// GetReprocessableVideos returns a page of the live, ready videos that have a
+// stored file, by ID, starting after the given one (uuid.Nil for the first
+// page). Paging by ID rather than by offset keeps a long backfill from
+// skipping or repeating videos as others are added or deleted.
+func (c Client) GetReprocessableVideos(ctx context.Context, after uuid.UUID, limit int) ([]StoredVideo, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT id, user_id, media_kind, object_key, content_hash IS NOT NULL, COALESCE(stream_prefix, '')
+	FROM videos
+	WHERE deleted_at IS NULL AND status = ? AND object_key IS NOT NULL AND id > ?
+	ORDER BY id
+	LIMIT ?
+	`
+	start := ""
+	if after != uuid.Nil {
+		start = after.String()
+	}
+	rows, err := c.db.QueryContext(ctx, query, StatusReady, start, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []StoredVideo{}
+	for rows.Next() {
+		var v StoredVideo
+		if err := rows.Scan(&v.VideoID, &v.UserID, &v.MediaKind, &v.ObjectKey, &v.Shared, &v.StreamPrefix); err != nil {
+			return nil, err
+		}
+		videos = append(videos, v)
+	}
+	return videos, rows.Err()
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Bandwidth is bytes uploaded (in) and served through the API (out):
+type Bandwidth struct {
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+}
+
+// Total is in and out together, what the monthly transfer caps count:
+func (b Bandwidth) Total() int64 {
+	return b.BytesIn + b.BytesOut
+}
+
+// BandwidthDay is one user's bandwidth on one day (UTC), as YYYY-MM-DD:
+type BandwidthDay struct {
+	Day string `json:"day"`
+	Bandwidth
+}
+
+type UserBandwidth struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+	Bandwidth
+}
+
+// RecordBandwidth adds to the user's bandwidth for today:
+func (c Client) RecordBandwidth(ctx context.Context, userID uuid.UUID, bytesIn, bytesOut int64) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	INSERT INTO bandwidth_usage (user_id, day, bytes_in, bytes_out)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(user_id, day) DO UPDATE SET
+		bytes_in = bytes_in + excluded.bytes_in,
+		bytes_out = bytes_out + excluded.bytes_out
+	`
+	day := time.Now().UTC().Format(time.DateOnly)
+	_, err := c.db.ExecContext(ctx, query, userID.String(), day, bytesIn, bytesOut)
+	return err
+}
+
+// GetBandwidthSince is the user's bandwidth from the day of since on:
+func (c Client) GetBandwidthSince(ctx context.Context, userID uuid.UUID, since time.Time) (Bandwidth, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT COALESCE(SUM(bytes_in), 0), COALESCE(SUM(bytes_out), 0)
+	FROM bandwidth_usage
+	WHERE user_id = ? AND day >= ?
+	`
+	var b Bandwidth
+	err := c.db.QueryRowContext(ctx, query, userID.String(), since.UTC().Format(time.DateOnly)).Scan(&b.BytesIn, &b.BytesOut)
+	return b, err
+}
+
+// GetDailyBandwidth lists the user's bandwidth per day from the day of since
+// on, oldest first. Days without any are left out.
+func (c Client) GetDailyBandwidth(ctx context.Context, userID uuid.UUID, since time.Time) ([]BandwidthDay, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT day, bytes_in, bytes_out
+	FROM bandwidth_usage
+	WHERE user_id = ? AND day >= ?
+	ORDER BY day
+	`
+	rows, err := c.db.QueryContext(ctx, query, userID.String(), since.UTC().Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []BandwidthDay{}
+	for rows.Next() {
+		var day BandwidthDay
+		if err := rows.Scan(&day.Day, &day.BytesIn, &day.BytesOut); err != nil {
+			return nil, err
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
+
+// GetTotalBandwidthSince is every user's bandwidth from the day of since on:
+func (c Client) GetTotalBandwidthSince(ctx context.Context, since time.Time) (Bandwidth, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT COALESCE(SUM(bytes_in), 0), COALESCE(SUM(bytes_out), 0)
+	FROM bandwidth_usage
+	WHERE day >= ?
+	`
+	var b Bandwidth
+	err := c.db.QueryRowContext(ctx, query, since.UTC().Format(time.DateOnly)).Scan(&b.BytesIn, &b.BytesOut)
+	return b, err
+}
+
+// GetTopUsersByBandwidth lists the users who moved the most bytes, in and out,
+// from the day of since on.
+func (c Client) GetTopUsersByBandwidth(ctx context.Context, since time.Time, limit int) ([]UserBandwidth, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT
+		u.id,
+		u.email,
+		SUM(b.bytes_in),
+		SUM(b.bytes_out),
+		SUM(b.bytes_in + b.bytes_out) AS total_bytes
+	FROM users u
+	JOIN bandwidth_usage b ON b.user_id = u.id
+	WHERE b.day >= ?
+	GROUP BY u.id, u.email
+	ORDER BY total_bytes DESC
+	LIMIT ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, since.UTC().Format(time.DateOnly), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []UserBandwidth{}
+	for rows.Next() {
+		var user UserBandwidth
+		var total int64
+		if err := rows.Scan(&user.UserID, &user.Email, &user.BytesIn, &user.BytesOut, &total); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
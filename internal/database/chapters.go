@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Chapter struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	VideoID   uuid.UUID `json:"video_id"`
+	Position  int       `json:"position"`
+	CreateChapterParams
+}
+
+type CreateChapterParams struct {
+	StartSeconds float64 `json:"start_seconds"`
+	EndSeconds   float64 `json:"end_seconds"`
+	Title        string  `json:"title"`
+}
+
+func (c Client) GetChapters(ctx context.Context, videoID uuid.UUID) ([]Chapter, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT
+		id,
+		created_at,
+		video_id,
+		position,
+		start_seconds,
+		end_seconds,
+		title
+	FROM chapters
+	WHERE video_id = ?
+	ORDER BY position ASC
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := []Chapter{}
+	for rows.Next() {
+		var chapter Chapter
+		if err := rows.Scan(
+			&chapter.ID,
+			&chapter.CreatedAt,
+			&chapter.VideoID,
+			&chapter.Position,
+			&chapter.StartSeconds,
+			&chapter.EndSeconds,
+			&chapter.Title,
+		); err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, chapter)
+	}
+
+	return chapters, rows.Err()
+}
+
+// ReplaceChapters swaps the whole chapter list of a video in one transaction,
+// so players never observe a half-written list.
+func (c Client) ReplaceChapters(ctx context.Context, videoID uuid.UUID, params []CreateChapterParams) ([]Chapter, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	err := c.inTx(ctx, func(tx dbtx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM chapters WHERE video_id = ?`, videoID); err != nil {
+			return err
+		}
+
+		query := `
+		INSERT INTO chapters (
+			id,
+			created_at,
+			video_id,
+			position,
+			start_seconds,
+			end_seconds,
+			title
+		) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+		`
+		for i, p := range params {
+			_, err := tx.ExecContext(ctx, query, uuid.New(), videoID, i, p.StartSeconds, p.EndSeconds, p.Title)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.GetChapters(ctx, videoID)
+}
+
+func (c Client) DeleteChapters(ctx context.Context, videoID uuid.UUID) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	DELETE FROM chapters
+	WHERE video_id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, videoID)
+	return err
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// CreateClip creates a draft video cut from the video sourceID, which the clip
+// keeps a link to in source_video_id; see GetClips.
+func (c Client) CreateClip(ctx context.Context, params CreateVideoParams, sourceID uuid.UUID) (Video, error) {
+	var clip Video
+	err := c.WithTx(ctx, func(tx Client) error {
+		var err error
+		clip, err = tx.CreateVideo(ctx, params)
+		if err != nil {
+			return err
+		}
+		_, err = tx.db.ExecContext(ctx, `UPDATE videos SET source_video_id = ? WHERE id = ?`, sourceID, clip.ID)
+		return err
+	})
+	if err != nil {
+		return Video{}, err
+	}
+	return clip, nil
+}
+
+// GetClips returns the clips cut from the video, newest first.
+func (c Client) GetClips(ctx context.Context, sourceID uuid.UUID) ([]Video, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		thumbnail_url,
+		thumbnails,
+		video_url,
+		hls_url,
+		dash_url,
+		media_kind,
+		original_filename,
+		version,
+		status,
+		moderation_status,
+		visibility,
+		like_count,
+		user_id
+	FROM videos
+	WHERE source_video_id = ? AND deleted_at IS NULL
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		var video Video
+		if err := rows.Scan(
+			&video.ID,
+			&video.CreatedAt,
+			&video.UpdatedAt,
+			&video.Title,
+			&video.Description,
+			&video.ThumbnailURL,
+			&video.Thumbnails,
+			&video.VideoURL,
+			&video.HLSURL,
+			&video.DashURL,
+			&video.MediaKind,
+			&video.OriginalFilename,
+			&video.Version,
+			&video.Status,
+			&video.ModerationStatus,
+			&video.Visibility,
+			&video.LikeCount,
+			&video.UserID,
+		); err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Where a configuration reload came from:
+const (
+	ConfigSourceSignal = "signal"
+	ConfigSourceAdmin  = "admin"
+)
+
+// ConfigChange is one setting a reload changed:
+type ConfigChange struct {
+	Setting string `json:"setting"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+}
+
+// ConfigAuditEntry records one configuration reload that changed something.
+type ConfigAuditEntry struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Source is one of the ConfigSource* constants; ActorID is the admin who
+	// asked for an admin reload.
+	Source  string         `json:"source"`
+	ActorID *uuid.UUID     `json:"actor_id"`
+	Changes []ConfigChange `json:"changes"`
+}
+
+type CreateConfigAuditEntryParams struct {
+	Source string
+	// ActorID is uuid.Nil for reloads nobody logged in asked for, like SIGHUP.
+	ActorID uuid.UUID
+	Changes []ConfigChange
+}
+
+func (c Client) CreateConfigAuditEntry(ctx context.Context, params CreateConfigAuditEntryParams) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	changes, err := json.Marshal(params.Changes)
+	if err != nil {
+		return err
+	}
+	var actorID *uuid.UUID
+	if params.ActorID != uuid.Nil {
+		actorID = &params.ActorID
+	}
+	query := `
+	INSERT INTO config_audit (id, source, actor_id, changes)
+	VALUES (?, ?, ?, ?)
+	`
+	_, err = c.db.ExecContext(ctx, query, uuid.New(), params.Source, actorID, string(changes))
+	return err
+}
+
+// GetConfigAuditEntries returns the latest limit entries, newest first.
+func (c Client) GetConfigAuditEntries(ctx context.Context, limit int) ([]ConfigAuditEntry, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT id, created_at, source, actor_id, changes
+	FROM config_audit
+	ORDER BY created_at DESC, rowid DESC
+	LIMIT ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []ConfigAuditEntry{}
+	for rows.Next() {
+		var entry ConfigAuditEntry
+		var changes string
+		if err := rows.Scan(&entry.ID, &entry.CreatedAt, &entry.Source, &entry.ActorID, &changes); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(changes), &entry.Changes); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
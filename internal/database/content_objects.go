@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// AcquireContentObject records one more reference to the object holding the
+// content with the given hash. created is true when this is the first reference,
+// meaning the caller still has to upload the object.
+func (c Client) AcquireContentObject(ctx context.Context, hash, key string, sizeBytes int64) (created bool, err error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	INSERT INTO content_objects (hash, created_at, object_key, size_bytes, ref_count)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, 1)
+	ON CONFLICT(hash) DO UPDATE SET ref_count = ref_count + 1
+	RETURNING ref_count
+	`
+	var refCount int
+	if err := c.db.QueryRowContext(ctx, query, hash, key, sizeBytes).Scan(&refCount); err != nil {
+		return false, err
+	}
+	return refCount == 1, nil
+}
+
+// ReleaseContentObject drops one reference. When it was the last one the row is
+// removed and the object key returned, so the caller can delete the object.
+func (c Client) ReleaseContentObject(ctx context.Context, hash string) (orphanedKey string, err error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	err = c.inTx(ctx, func(tx dbtx) error {
+		var (
+			key      string
+			refCount int
+		)
+		query := `
+		UPDATE content_objects
+		SET ref_count = ref_count - 1
+		WHERE hash = ?
+		RETURNING object_key, ref_count
+		`
+		err := tx.QueryRowContext(ctx, query, hash).Scan(&key, &refCount)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+
+		if refCount > 0 {
+			return nil
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM content_objects WHERE hash = ?`, hash); err != nil {
+			return err
+		}
+		orphanedKey = key
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return orphanedKey, nil
+}
+
+// GetVideoContentHash returns nil for videos stored under the random-key layout.
+func (c Client) GetVideoContentHash(ctx context.Context, videoID uuid.UUID) (*string, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var hash *string
+	err := c.db.QueryRowContext(ctx, `SELECT content_hash FROM videos WHERE id = ?`, videoID).Scan(&hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return hash, nil
+}
+
+func (c Client) SetVideoContentHash(ctx context.Context, videoID uuid.UUID, hash *string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE videos
+	SET content_hash = ?
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, hash, videoID)
+	return err
+}
@@ -1,22 +1,51 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"runtime"
+	"strings"
+	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/telemetry"
 	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Client struct {
-	db *sql.DB
+	// db is a *sql.DB, or a *sql.Tx inside WithTx:
+	db           dbtx
+	queryTimeout time.Duration
+	// searchFTS5 is set when the search index could use FTS5, see search.go:
+	searchFTS5 bool
 }
 
-func NewClient(pathToDB string) (Client, error) {
+// PoolConfig tunes the connection pool and bounds every statement. Zero values
+// keep database/sql's defaults (and no statement timeout).
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	QueryTimeout    time.Duration
+}
+
+func NewClient(pathToDB string, pool PoolConfig) (Client, error) {
 	db, err := sql.Open("sqlite3", pathToDB)
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
+	if pool.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+	c := Client{db: db, queryTimeout: pool.QueryTimeout}
 	err = c.autoMigrate()
 	if err != nil {
 		return Client{}, err
@@ -25,6 +54,35 @@ func NewClient(pathToDB string) (Client, error) {
 
 }
 
+// withTimeout derives the per-statement context used by every query method. It
+// also starts a "db.<Method>" span, named after the calling method, which the
+// returned cancel function ends.
+func (c Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, span := telemetry.Start(ctx, "db."+callerName(), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "sqlite")))
+	var cancel context.CancelFunc
+	if c.queryTimeout <= 0 {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, c.queryTimeout)
+	}
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
+
+// callerName returns the name of the method that called withTimeout, e.g.
+// "GetVideo":
+func callerName() string {
+	pc, _, _, ok := runtime.Caller(2)
+	if !ok {
+		return "query"
+	}
+	name := runtime.FuncForPC(pc).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
 func (c *Client) autoMigrate() error {
 	userTable := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -55,6 +113,49 @@ func (c *Client) autoMigrate() error {
 		return err
 	}
 
+	// Accounts at outside identity providers users sign in with; each belongs
+	// to one user:
+	identityTable := `
+	CREATE TABLE IF NOT EXISTS identities (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		email TEXT NOT NULL,
+		UNIQUE(provider, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_identities_user ON identities(user_id);
+	`
+	_, err = c.db.Exec(identityTable)
+	if err != nil {
+		return err
+	}
+
+	// Keys scripts use instead of logging in. Only their hashes are kept;
+	// scopes is a comma-separated list:
+	apiKeyTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		expires_at TIMESTAMP,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id, created_at);
+	`
+	_, err = c.db.Exec(apiKeyTable)
+	if err != nil {
+		return err
+	}
+
 	videoTable := `
 	CREATE TABLE IF NOT EXISTS videos (
 		id TEXT PRIMARY KEY,
@@ -72,17 +173,553 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
-	return nil
+
+	chapterTable := `
+	CREATE TABLE IF NOT EXISTS chapters (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		start_seconds REAL NOT NULL,
+		end_seconds REAL NOT NULL,
+		title TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(chapterTable)
+	if err != nil {
+		return err
+	}
+
+	processingRunTable := `
+	CREATE TABLE IF NOT EXISTS processing_runs (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		duration_ms INTEGER NOT NULL,
+		succeeded BOOLEAN NOT NULL,
+		error TEXT
+	);
+	`
+	_, err = c.db.Exec(processingRunTable)
+	if err != nil {
+		return err
+	}
+
+	// Uploads whose transcode or storage upload failed on every retry, kept
+	// for an admin to look into and re-drive; see DeadLetter:
+	deadLetterTable := `
+	CREATE TABLE IF NOT EXISTS dead_letters (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		job_kind TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		error TEXT NOT NULL,
+		ffmpeg_stderr TEXT,
+		input_path TEXT,
+		media_type TEXT NOT NULL,
+		filename TEXT,
+		metadata TEXT
+	);
+	`
+	_, err = c.db.Exec(deadLetterTable)
+	if err != nil {
+		return err
+	}
+
+	storageStatsTable := `
+	CREATE TABLE IF NOT EXISTS storage_stats (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		scanned_at TIMESTAMP NOT NULL,
+		object_count INTEGER NOT NULL,
+		total_bytes INTEGER NOT NULL
+	);
+	`
+	_, err = c.db.Exec(storageStatsTable)
+	if err != nil {
+		return err
+	}
+
+	contentObjectTable := `
+	CREATE TABLE IF NOT EXISTS content_objects (
+		hash TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		object_key TEXT NOT NULL,
+		size_bytes INTEGER NOT NULL,
+		ref_count INTEGER NOT NULL
+	);
+	`
+	_, err = c.db.Exec(contentObjectTable)
+	if err != nil {
+		return err
+	}
+
+	viewTable := `
+	CREATE TABLE IF NOT EXISTS video_views (
+		video_id TEXT NOT NULL,
+		viewed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS video_views_video_id_viewed_at ON video_views(video_id, viewed_at);
+	`
+	_, err = c.db.Exec(viewTable)
+	if err != nil {
+		return err
+	}
+
+	// Resumable uploads survive restarts, so their state lives here, not in memory:
+	uploadTable := `
+	CREATE TABLE IF NOT EXISTS uploads (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		media_type TEXT NOT NULL,
+		size_bytes INTEGER NOT NULL,
+		offset_bytes INTEGER NOT NULL DEFAULT 0,
+		temp_path TEXT NOT NULL,
+		metadata TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(uploadTable)
+	if err != nil {
+		return err
+	}
+
+	// ffprobe output by content hash, so the same bytes are only probed once:
+	probeTable := `
+	CREATE TABLE IF NOT EXISTS probe_cache (
+		hash TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		used_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		probe_json TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(probeTable)
+	if err != nil {
+		return err
+	}
+
+	// Minutes of media each user has had processed, for the monthly tier limits:
+	uploadUsageTable := `
+	CREATE TABLE IF NOT EXISTS upload_usage (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		duration_ms INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_upload_usage_user ON upload_usage(user_id, created_at);
+	`
+	_, err = c.db.Exec(uploadUsageTable)
+	if err != nil {
+		return err
+	}
+
+	// Bytes each user has uploaded, and had served through the API, per day
+	// (UTC), for the usage endpoint and the monthly transfer caps. See
+	// bandwidth.go:
+	bandwidthTable := `
+	CREATE TABLE IF NOT EXISTS bandwidth_usage (
+		user_id TEXT NOT NULL,
+		day TEXT NOT NULL,
+		bytes_in INTEGER NOT NULL DEFAULT 0,
+		bytes_out INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY(user_id, day),
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(bandwidthTable)
+	if err != nil {
+		return err
+	}
+
+	// Links that let anyone holding the token watch one private video. Only the
+	// token's SHA-256 is kept; the token itself is shown once, when it's made:
+	shareLinkTable := `
+	CREATE TABLE IF NOT EXISTS share_links (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_share_links_video ON share_links(video_id, created_at);
+	`
+	_, err = c.db.Exec(shareLinkTable)
+	if err != nil {
+		return err
+	}
+
+	// Files a video played before an edit replaced them, numbered from 1 per
+	// video; see versions.go:
+	videoVersionTable := `
+	CREATE TABLE IF NOT EXISTS video_versions (
+		video_id TEXT NOT NULL,
+		number INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		reason TEXT NOT NULL,
+		object_key TEXT NOT NULL,
+		video_url TEXT,
+		content_hash TEXT,
+		PRIMARY KEY(video_id, number),
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(videoVersionTable)
+	if err != nil {
+		return err
+	}
+
+	playlistTable := `
+	CREATE TABLE IF NOT EXISTS playlists (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		visibility TEXT NOT NULL DEFAULT 'public',
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_playlists_user ON playlists(user_id, created_at);
+	CREATE TABLE IF NOT EXISTS playlist_videos (
+		playlist_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		PRIMARY KEY(playlist_id, video_id),
+		FOREIGN KEY(playlist_id) REFERENCES playlists(id) ON DELETE CASCADE,
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(playlistTable)
+	if err != nil {
+		return err
+	}
+
+	// One row per user per liked video; videos.like_count is their count:
+	likeTable := `
+	CREATE TABLE IF NOT EXISTS video_likes (
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(user_id, video_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_video_likes_video ON video_likes(video_id);
+	`
+	_, err = c.db.Exec(likeTable)
+	if err != nil {
+		return err
+	}
+
+	// Tags are shared by name; video_tags links them to videos. See tags.go:
+	tagTable := `
+	CREATE TABLE IF NOT EXISTS tags (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE
+	);
+	CREATE TABLE IF NOT EXISTS video_tags (
+		video_id TEXT NOT NULL,
+		tag_id INTEGER NOT NULL,
+		PRIMARY KEY(video_id, tag_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE,
+		FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_video_tags_tag ON video_tags(tag_id);
+	`
+	_, err = c.db.Exec(tagTable)
+	if err != nil {
+		return err
+	}
+
+	notificationTable := `
+	CREATE TABLE IF NOT EXISTS notifications (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		video_id TEXT,
+		message TEXT NOT NULL,
+		read_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, created_at);
+	`
+	_, err = c.db.Exec(notificationTable)
+	if err != nil {
+		return err
+	}
+
+	// Configuration reloads, and what each changed. actor_id isn't a foreign
+	// key: the record outlives the admin.
+	configAuditTable := `
+	CREATE TABLE IF NOT EXISTS config_audit (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		source TEXT NOT NULL,
+		actor_id TEXT,
+		changes TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(configAuditTable)
+	if err != nil {
+		return err
+	}
+
+	// SHA-256s of each stored object's blocks, for the integrity spot checks:
+	videoChecksumTable := `
+	CREATE TABLE IF NOT EXISTS video_checksums (
+		video_id TEXT PRIMARY KEY,
+		object_key TEXT NOT NULL,
+		size_bytes INTEGER NOT NULL,
+		block_size INTEGER NOT NULL,
+		checksums TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.db.Exec(videoChecksumTable)
+	if err != nil {
+		return err
+	}
+
+	if err := c.addColumnIfNotExists("users", "is_admin", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("users", "tier", "TEXT NOT NULL DEFAULT 'free'"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "size_bytes", "INTEGER"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "content_hash", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("users", "watermark_path", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("users", "watermark_position", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("users", "watermark_opacity", "REAL"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "hls_url", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "dash_url", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "stream_prefix", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "media_kind", "TEXT NOT NULL DEFAULT 'video'"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("users", "avatar_path", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("users", "avatar_url", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "object_key", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "storage_tier", "TEXT NOT NULL DEFAULT 'hot'"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "version", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	// Rows from before moderation existed stay visible:
+	if err := c.addColumnIfNotExists("videos", "moderation_status", "TEXT NOT NULL DEFAULT 'approved'"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "moderation_labels", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "moderation_checks", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "deleted_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "hls_key", "BLOB"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "original_filename", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "source_sha256", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "source_size_bytes", "INTEGER"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "replication_status", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "replication_error", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "replicated_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "processed_sha256", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "visibility", "TEXT NOT NULL DEFAULT 'public'"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "like_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "integrity_status", "TEXT NOT NULL DEFAULT 'unchecked'"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "integrity_error", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "integrity_checked_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "thumbnails", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "source_url", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfNotExists("videos", "source_video_id", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec(`CREATE INDEX IF NOT EXISTS idx_videos_source_video ON videos(source_video_id)`); err != nil {
+		return err
+	}
+	if err := c.migrateStatus(); err != nil {
+		return err
+	}
+	return c.migrateSearch()
 }
 
-func (c Client) Reset() error {
-	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
+// addColumnIfNotExists lets autoMigrate grow tables that were created by an
+// older version of the schema; CREATE TABLE IF NOT EXISTS won't touch them.
+func (c *Client) addColumnIfNotExists(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   bool
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func (c Client) Reset(ctx context.Context) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM identities"); err != nil {
+		return fmt.Errorf("failed to reset table identities: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM notifications"); err != nil {
+		return fmt.Errorf("failed to reset table notifications: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM config_audit"); err != nil {
+		return fmt.Errorf("failed to reset table config_audit: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM video_checksums"); err != nil {
+		return fmt.Errorf("failed to reset table video_checksums: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM playlist_videos"); err != nil {
+		return fmt.Errorf("failed to reset table playlist_videos: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM playlists"); err != nil {
+		return fmt.Errorf("failed to reset table playlists: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM processing_runs"); err != nil {
+		return fmt.Errorf("failed to reset table processing_runs: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM dead_letters"); err != nil {
+		return fmt.Errorf("failed to reset table dead_letters: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM storage_stats"); err != nil {
+		return fmt.Errorf("failed to reset table storage_stats: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM content_objects"); err != nil {
+		return fmt.Errorf("failed to reset table content_objects: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM uploads"); err != nil {
+		return fmt.Errorf("failed to reset table uploads: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM probe_cache"); err != nil {
+		return fmt.Errorf("failed to reset table probe_cache: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM upload_usage"); err != nil {
+		return fmt.Errorf("failed to reset table upload_usage: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM bandwidth_usage"); err != nil {
+		return fmt.Errorf("failed to reset table bandwidth_usage: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM video_views"); err != nil {
+		return fmt.Errorf("failed to reset table video_views: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM video_likes"); err != nil {
+		return fmt.Errorf("failed to reset table video_likes: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM share_links"); err != nil {
+		return fmt.Errorf("failed to reset table share_links: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM video_tags"); err != nil {
+		return fmt.Errorf("failed to reset table video_tags: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM tags"); err != nil {
+		return fmt.Errorf("failed to reset table tags: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM video_versions"); err != nil {
+		return fmt.Errorf("failed to reset table video_versions: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM chapters"); err != nil {
+		return fmt.Errorf("failed to reset table chapters: %w", err)
+	}
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
 	return nil
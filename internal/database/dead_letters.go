@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeadLetter is an upload whose processing failed on every attempt. The raw
+// upload is kept at InputPath, so once whatever broke is fixed an admin can
+// re-drive it through the pipeline.
+type DeadLetter struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateDeadLetterParams
+}
+
+type CreateDeadLetterParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	// JobKind is the step that gave up, e.g. "process_video":
+	JobKind  string `json:"job_kind"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+	// FFmpegStderr is the end of ffmpeg's stderr, when ffmpeg is what failed:
+	FFmpegStderr *string `json:"ffmpeg_stderr,omitempty"`
+	// InputPath is empty when the upload couldn't be kept; such a letter can't
+	// be re-driven.
+	InputPath string `json:"-"`
+	MediaType string `json:"media_type"`
+	Filename  string `json:"filename,omitempty"`
+	// Metadata is the upload's form metadata as JSON, for the caller to decode:
+	Metadata string `json:"-"`
+}
+
+const deadLetterColumns = `id, created_at, video_id, user_id, job_kind, attempts, error, ffmpeg_stderr, input_path, media_type, filename, metadata`
+
+func scanDeadLetter(row interface{ Scan(...any) error }) (DeadLetter, error) {
+	var (
+		letter            DeadLetter
+		input, file, meta sql.NullString
+	)
+	err := row.Scan(&letter.ID, &letter.CreatedAt, &letter.VideoID, &letter.UserID, &letter.JobKind, &letter.Attempts, &letter.Error, &letter.FFmpegStderr, &input, &letter.MediaType, &file, &meta)
+	letter.InputPath, letter.Filename, letter.Metadata = input.String, file.String, meta.String
+	return letter, err
+}
+
+func (c Client) CreateDeadLetter(ctx context.Context, params CreateDeadLetterParams) (DeadLetter, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	INSERT INTO dead_letters (id, created_at, video_id, user_id, job_kind, attempts, error, ffmpeg_stderr, input_path, media_type, filename, metadata)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	RETURNING ` + deadLetterColumns
+	row := c.db.QueryRowContext(ctx, query, uuid.New(), params.VideoID, params.UserID, params.JobKind, params.Attempts, params.Error,
+		params.FFmpegStderr, params.InputPath, params.MediaType, params.Filename, params.Metadata)
+	return scanDeadLetter(row)
+}
+
+// GetDeadLetter returns the letter, or nil if there's none with the ID.
+func (c Client) GetDeadLetter(ctx context.Context, id uuid.UUID) (*DeadLetter, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + deadLetterColumns + ` FROM dead_letters WHERE id = ?`
+	letter, err := scanDeadLetter(c.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// GetDeadLetters lists every letter, newest first.
+func (c Client) GetDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + deadLetterColumns + ` FROM dead_letters ORDER BY created_at DESC`
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := []DeadLetter{}
+	for rows.Next() {
+		letter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, rows.Err()
+}
+
+// DeleteDeadLetter removes the letter, and reports whether there was one.
+// Re-driving takes a letter off the list this way, so two admins can't
+// re-drive the same one.
+func (c Client) DeleteDeadLetter(ctx context.Context, id uuid.UUID) (bool, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	result, err := c.db.ExecContext(ctx, `DELETE FROM dead_letters WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// ExportedVideo is a video with what a data export adds to it: where its file
+// is stored and how big it is.
+type ExportedVideo struct {
+	Video
+	// ObjectKey is empty for videos without an uploaded file:
+	ObjectKey       string
+	SizeBytes       *int64
+	StorageTier     string
+	ProcessedSHA256 *string
+}
+
+// ExportVideos returns up to limit of the user's videos, oldest first, starting
+// after the one at cursor; pass 0 for the first page. The returned cursor is the
+// one to pass for the next page, and the page is the last one when it has fewer
+// than limit videos. Paging by rowid keeps each query short however many videos
+// there are, and isn't thrown off by videos added or deleted in between.
+func (c Client) ExportVideos(ctx context.Context, userID uuid.UUID, cursor int64, limit int) ([]ExportedVideo, int64, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT
+		rowid,
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		thumbnail_url,
+		thumbnails,
+		video_url,
+		hls_url,
+		dash_url,
+		media_kind,
+		original_filename,
+		version,
+		status,
+		moderation_status,
+		visibility,
+		like_count,
+		user_id,
+		COALESCE(object_key, ''),
+		size_bytes,
+		storage_tier,
+		processed_sha256
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NULL AND rowid > ?
+	ORDER BY rowid
+	LIMIT ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, userID, cursor, limit)
+	if err != nil {
+		return nil, cursor, err
+	}
+	defer rows.Close()
+
+	videos := []ExportedVideo{}
+	for rows.Next() {
+		var video ExportedVideo
+		if err := rows.Scan(
+			&cursor,
+			&video.ID,
+			&video.CreatedAt,
+			&video.UpdatedAt,
+			&video.Title,
+			&video.Description,
+			&video.ThumbnailURL,
+			&video.Thumbnails,
+			&video.VideoURL,
+			&video.HLSURL,
+			&video.DashURL,
+			&video.MediaKind,
+			&video.OriginalFilename,
+			&video.Version,
+			&video.Status,
+			&video.ModerationStatus,
+			&video.Visibility,
+			&video.LikeCount,
+			&video.UserID,
+			&video.ObjectKey,
+			&video.SizeBytes,
+			&video.StorageTier,
+			&video.ProcessedSHA256,
+		); err != nil {
+			return nil, cursor, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, cursor, rows.Err()
+}
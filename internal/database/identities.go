@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Identity links a user to an account at an outside identity provider they
+// sign in with, see internal/oauth.
+type Identity struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateIdentityParams
+}
+
+type CreateIdentityParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Provider string    `json:"provider"`
+	// Subject is the provider's ID for the account:
+	Subject string `json:"subject"`
+	// Email is the account's address when it was linked:
+	Email string `json:"email"`
+}
+
+func (c Client) CreateIdentity(ctx context.Context, params CreateIdentityParams) (Identity, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	identity := Identity{ID: uuid.New(), CreateIdentityParams: params}
+	query := `
+	INSERT INTO identities (id, created_at, user_id, provider, subject, email)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	RETURNING created_at
+	`
+	err := c.db.QueryRowContext(ctx, query, identity.ID, params.UserID, params.Provider, params.Subject, params.Email).Scan(&identity.CreatedAt)
+	if err != nil {
+		return Identity{}, err
+	}
+	return identity, nil
+}
+
+// GetUserByIdentity returns the user linked to the provider's account, or nil
+// when nobody is.
+func (c Client) GetUserByIdentity(ctx context.Context, provider, subject string) (*User, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var userID uuid.UUID
+	err := c.db.QueryRowContext(ctx, `SELECT user_id FROM identities WHERE provider = ? AND subject = ?`, provider, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c.GetUser(ctx, userID)
+}
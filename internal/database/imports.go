@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// ImportVideoParams is one video of a bulk import. SourceURL, if set, is where
+// its file is to be fetched from; see GetVideoSourceURL.
+type ImportVideoParams struct {
+	CreateVideoParams
+	SourceURL string
+}
+
+// ImportVideos creates draft videos for all of params, or none of them if one
+// fails. They're returned in the order given.
+func (c Client) ImportVideos(ctx context.Context, params []ImportVideoParams) ([]Video, error) {
+	videos := make([]Video, 0, len(params))
+	err := c.WithTx(ctx, func(tx Client) error {
+		for _, p := range params {
+			video, err := tx.CreateVideo(ctx, p.CreateVideoParams)
+			if err != nil {
+				return err
+			}
+			if p.SourceURL != "" {
+				if _, err := tx.db.ExecContext(ctx, `UPDATE videos SET source_url = ? WHERE id = ?`, p.SourceURL, video.ID); err != nil {
+					return err
+				}
+			}
+			videos = append(videos, video)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
+
+// GetVideoSourceURL returns the URL the video was imported with, or "" when
+// there's none.
+func (c Client) GetVideoSourceURL(ctx context.Context, videoID uuid.UUID) (string, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var sourceURL sql.NullString
+	err := c.db.QueryRowContext(ctx, `SELECT source_url FROM videos WHERE id = ?`, videoID).Scan(&sourceURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return sourceURL.String, err
+}
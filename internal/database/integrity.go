@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Integrity states of a video's stored object, as the spot checks last found
+// it. Videos are unchecked until their first check.
+const (
+	IntegrityUnchecked = "unchecked"
+	IntegrityOK        = "ok"
+	IntegrityMissing   = "missing"
+	IntegrityCorrupted = "corrupted"
+)
+
+// BlockChecksums are the SHA-256s of an object's fixed-size blocks, taken when
+// it was stored, so a check can verify any block with a range read instead of
+// fetching the whole object. The last block may be short.
+type BlockChecksums struct {
+	ObjectKey string
+	SizeBytes int64
+	BlockSize int64
+	Checksums []string
+}
+
+// IntegrityCandidate is a stored video due for a spot check. Blocks is nil for
+// objects stored before block checksums were taken, or when they were taken for
+// an object the video no longer points at; SizeBytes is nil when unknown.
+type IntegrityCandidate struct {
+	VideoID   uuid.UUID
+	ObjectKey string
+	SizeBytes *int64
+	Blocks    *BlockChecksums
+}
+
+// IntegrityProblem is a video a spot check flagged:
+type IntegrityProblem struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Title     string    `json:"title"`
+	ObjectKey string    `json:"object_key"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// IntegrityReport is the outcome of the spot checks so far: how many stored
+// videos are in each state, and the ones flagged.
+type IntegrityReport struct {
+	Counts   map[string]int     `json:"counts"`
+	Problems []IntegrityProblem `json:"problems"`
+}
+
+// SetBlockChecksums records the block checksums of the video's object,
+// replacing those of any object it was stored under before.
+func (c Client) SetBlockChecksums(ctx context.Context, videoID uuid.UUID, blocks BlockChecksums) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	INSERT INTO video_checksums (video_id, object_key, size_bytes, block_size, checksums)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		object_key = excluded.object_key,
+		size_bytes = excluded.size_bytes,
+		block_size = excluded.block_size,
+		checksums = excluded.checksums
+	`
+	_, err := c.db.ExecContext(ctx, query, videoID, blocks.ObjectKey, blocks.SizeBytes, blocks.BlockSize, strings.Join(blocks.Checksums, ","))
+	return err
+}
+
+// GetIntegrityCandidates returns up to limit stored videos, those never checked
+// first and then the longest since their last check. Cold videos are skipped:
+// their objects may be in Glacier, where they can't be read.
+func (c Client) GetIntegrityCandidates(ctx context.Context, limit int) ([]IntegrityCandidate, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT v.id, v.object_key, v.size_bytes, vc.object_key, vc.size_bytes, vc.block_size, vc.checksums
+	FROM videos v
+	LEFT JOIN video_checksums vc ON vc.video_id = v.id
+	WHERE v.object_key IS NOT NULL
+		AND v.deleted_at IS NULL
+		AND v.storage_tier = ?
+	ORDER BY v.integrity_checked_at IS NOT NULL, v.integrity_checked_at
+	LIMIT ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, StorageTierHot, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []IntegrityCandidate{}
+	for rows.Next() {
+		var (
+			candidate IntegrityCandidate
+			blockKey  sql.NullString
+			blockSize sql.NullInt64
+			size      sql.NullInt64
+			checksums sql.NullString
+		)
+		err := rows.Scan(&candidate.VideoID, &candidate.ObjectKey, &candidate.SizeBytes, &blockKey, &size, &blockSize, &checksums)
+		if err != nil {
+			return nil, err
+		}
+		// Checksums of an earlier upload don't describe the current object:
+		if blockKey.Valid && blockKey.String == candidate.ObjectKey {
+			candidate.Blocks = &BlockChecksums{
+				ObjectKey: blockKey.String,
+				SizeBytes: size.Int64,
+				BlockSize: blockSize.Int64,
+				Checksums: strings.Split(checksums.String, ","),
+			}
+			candidate.SizeBytes = &candidate.Blocks.SizeBytes
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+// SetVideoIntegrity records the outcome of a spot check; errMsg says what was
+// wrong and is cleared for IntegrityOK.
+func (c Client) SetVideoIntegrity(ctx context.Context, videoID uuid.UUID, status, errMsg string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE videos
+	SET integrity_status = ?,
+		integrity_error = NULLIF(?, ''),
+		integrity_checked_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, status, errMsg, videoID)
+	return err
+}
+
+// GetIntegrityReport counts the stored videos by integrity state and lists up
+// to limit of the flagged ones, most recently checked first.
+func (c Client) GetIntegrityReport(ctx context.Context, limit int) (IntegrityReport, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	report := IntegrityReport{
+		Counts: map[string]int{
+			IntegrityUnchecked: 0,
+			IntegrityOK:        0,
+			IntegrityMissing:   0,
+			IntegrityCorrupted: 0,
+		},
+		Problems: []IntegrityProblem{},
+	}
+	rows, err := c.db.QueryContext(ctx, `
+	SELECT integrity_status, COUNT(*)
+	FROM videos
+	WHERE object_key IS NOT NULL AND deleted_at IS NULL
+	GROUP BY integrity_status
+	`)
+	if err != nil {
+		return IntegrityReport{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			status string
+			count  int
+		)
+		if err := rows.Scan(&status, &count); err != nil {
+			return IntegrityReport{}, err
+		}
+		report.Counts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return IntegrityReport{}, err
+	}
+	rows.Close()
+
+	query := `
+	SELECT id, user_id, title, object_key, integrity_status, COALESCE(integrity_error, ''), integrity_checked_at
+	FROM videos
+	WHERE integrity_status IN (?, ?)
+		AND object_key IS NOT NULL
+		AND deleted_at IS NULL
+	ORDER BY integrity_checked_at DESC
+	LIMIT ?
+	`
+	rows, err = c.db.QueryContext(ctx, query, IntegrityMissing, IntegrityCorrupted, limit)
+	if err != nil {
+		return IntegrityReport{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p IntegrityProblem
+		if err := rows.Scan(&p.VideoID, &p.UserID, &p.Title, &p.ObjectKey, &p.Status, &p.Error, &p.CheckedAt); err != nil {
+			return IntegrityReport{}, err
+		}
+		report.Problems = append(report.Problems, p)
+	}
+	return report, rows.Err()
+}
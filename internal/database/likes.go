@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// LikeVideo records that the user likes the video and returns its like count.
+// Liking it again changes nothing.
+func (c Client) LikeVideo(ctx context.Context, userID, videoID uuid.UUID) (int, error) {
+	return c.setLike(ctx, userID, videoID, `INSERT OR IGNORE INTO video_likes (user_id, video_id) VALUES (?, ?)`, 1)
+}
+
+// UnlikeVideo takes the user's like back and returns the video's like count.
+func (c Client) UnlikeVideo(ctx context.Context, userID, videoID uuid.UUID) (int, error) {
+	return c.setLike(ctx, userID, videoID, `DELETE FROM video_likes WHERE user_id = ? AND video_id = ?`, -1)
+}
+
+// setLike runs change and, if it did anything, moves like_count by delta in the
+// same transaction, so the count always matches the rows.
+func (c Client) setLike(ctx context.Context, userID, videoID uuid.UUID, change string, delta int) (int, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := c.inTx(ctx, func(tx dbtx) error {
+		result, err := tx.ExecContext(ctx, change, userID, videoID)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			_, err := tx.ExecContext(ctx, `UPDATE videos SET like_count = MAX(like_count + ?, 0) WHERE id = ?`, delta, videoID)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.QueryRowContext(ctx, `SELECT like_count FROM videos WHERE id = ?`, videoID).Scan(&count)
+	})
+	return count, err
+}
+
+// LikedVideos returns which of videoIDs the user likes.
+func (c Client) LikedVideos(ctx context.Context, userID uuid.UUID, videoIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	liked := map[uuid.UUID]bool{}
+	if len(videoIDs) == 0 {
+		return liked, nil
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	args := []any{userID}
+	for _, id := range videoIDs {
+		args = append(args, id)
+	}
+	query := `SELECT video_id FROM video_likes WHERE user_id = ? AND video_id IN (?` + strings.Repeat(", ?", len(videoIDs)-1) + `)`
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		liked[id] = true
+	}
+	return liked, rows.Err()
+}
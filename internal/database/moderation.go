@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// moderation_status values. Only approved videos are shown to people other than
+// their owner and admins:
+const (
+	ModerationPending  = "pending"  // checks running, or one failed and needs review
+	ModerationApproved = "approved" // passed checks, or approved by an admin
+	ModerationFlagged  = "flagged"  // a check found something; awaiting review
+	ModerationRejected = "rejected" // an admin confirmed the flag
+)
+
+// ModerationItem is a video waiting for an admin decision:
+type ModerationItem struct {
+	VideoID      uuid.UUID `json:"video_id"`
+	UserID       uuid.UUID `json:"user_id"`
+	Title        string    `json:"title"`
+	ThumbnailURL *string   `json:"thumbnail_url"`
+	VideoURL     *string   `json:"video_url"`
+	Status       string    `json:"moderation_status"`
+	Labels       []string  `json:"moderation_labels"`
+	// ChecksRunning is how many automated checks haven't reported yet:
+	ChecksRunning int       `json:"checks_running"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// BeginModeration marks the video pending while one more check runs. Labels
+// from an earlier, finished round are cleared.
+func (c Client) BeginModeration(ctx context.Context, videoID uuid.UUID) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE videos
+	SET
+		moderation_labels = CASE WHEN moderation_checks = 0 THEN NULL ELSE moderation_labels END,
+		moderation_status = ?,
+		moderation_checks = moderation_checks + 1
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, ModerationPending, videoID)
+	return err
+}
+
+// FinishModeration records one check's outcome: ModerationFlagged (with the
+// labels found), ModerationApproved, or ModerationPending if the check failed.
+// A flag always sticks; the video is only approved once every check has passed.
+func (c Client) FinishModeration(ctx context.Context, videoID uuid.UUID, outcome string, labels []string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	// SQLite evaluates every SET expression against the row as it was before the
+	// update, so moderation_checks below is the count including this check:
+	query := `
+	UPDATE videos
+	SET
+		moderation_status = CASE
+			WHEN ? = 'flagged' THEN 'flagged'
+			WHEN ? = 'approved' AND moderation_status = 'pending' AND moderation_checks <= 1 THEN 'approved'
+			ELSE moderation_status
+		END,
+		moderation_labels = CASE
+			WHEN ? = '' THEN moderation_labels
+			WHEN moderation_labels IS NULL OR moderation_labels = '' THEN ?
+			ELSE moderation_labels || ',' || ?
+		END,
+		moderation_checks = MAX(moderation_checks - 1, 0)
+	WHERE id = ?
+	`
+	joined := strings.Join(labels, ",")
+	_, err := c.db.ExecContext(ctx, query, outcome, outcome, joined, joined, joined, videoID)
+	return err
+}
+
+// SetModerationStatus records an admin decision:
+func (c Client) SetModerationStatus(ctx context.Context, videoID uuid.UUID, status string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `UPDATE videos SET moderation_status = ? WHERE id = ?`, status, videoID)
+	return err
+}
+
+// GetModerationQueue returns flagged and pending videos, oldest first:
+func (c Client) GetModerationQueue(ctx context.Context, limit int) ([]ModerationItem, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT id, user_id, title, thumbnail_url, video_url, moderation_status, moderation_labels, moderation_checks, updated_at
+	FROM videos
+	WHERE moderation_status IN (?, ?) AND deleted_at IS NULL
+	ORDER BY updated_at ASC
+	LIMIT ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, ModerationFlagged, ModerationPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []ModerationItem{}
+	for rows.Next() {
+		var (
+			item   ModerationItem
+			labels *string
+		)
+		if err := rows.Scan(
+			&item.VideoID,
+			&item.UserID,
+			&item.Title,
+			&item.ThumbnailURL,
+			&item.VideoURL,
+			&item.Status,
+			&labels,
+			&item.ChecksRunning,
+			&item.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		item.Labels = []string{}
+		if labels != nil && *labels != "" {
+			item.Labels = strings.Split(*labels, ",")
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
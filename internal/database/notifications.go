@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notification kinds:
+const (
+	NotificationVideoReady         = "video_ready"
+	NotificationVideoFailed        = "video_failed"
+	NotificationModerationFlagged  = "moderation_flagged"
+	NotificationModerationApproved = "moderation_approved"
+	NotificationModerationRejected = "moderation_rejected"
+)
+
+// Notification tells a user something happened to one of their videos.
+type Notification struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UserID    uuid.UUID  `json:"user_id"`
+	Kind      string     `json:"kind"`
+	VideoID   *uuid.UUID `json:"video_id"`
+	Message   string     `json:"message"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+type CreateNotificationParams struct {
+	UserID uuid.UUID
+	// Kind is one of the Notification* constants:
+	Kind string
+	// VideoID is uuid.Nil for notifications about no video in particular.
+	VideoID uuid.UUID
+	Message string
+}
+
+type GetNotificationsParams struct {
+	UserID     uuid.UUID
+	UnreadOnly bool
+	Limit      int
+	Offset     int
+}
+
+func (c Client) CreateNotification(ctx context.Context, params CreateNotificationParams) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var videoID *uuid.UUID
+	if params.VideoID != uuid.Nil {
+		videoID = &params.VideoID
+	}
+	query := `
+	INSERT INTO notifications (id, user_id, kind, video_id, message)
+	VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := c.db.ExecContext(ctx, query, uuid.New(), params.UserID, params.Kind, videoID, params.Message)
+	return err
+}
+
+// GetNotifications returns a page of the user's notifications, newest first.
+func (c Client) GetNotifications(ctx context.Context, params GetNotificationsParams) ([]Notification, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT id, created_at, user_id, kind, video_id, message, read_at
+	FROM notifications
+	WHERE user_id = ? AND (? = 0 OR read_at IS NULL)
+	ORDER BY created_at DESC, rowid DESC
+	LIMIT ? OFFSET ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, params.UserID, params.UnreadOnly, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.CreatedAt, &n.UserID, &n.Kind, &n.VideoID, &n.Message, &n.ReadAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// CountUnreadNotifications is how many of the user's notifications are unread:
+func (c Client) CountUnreadNotifications(ctx context.Context, userID uuid.UUID) (int, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var n int
+	err := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+// MarkNotificationRead marks the user's notification read. It reports false if
+// the user has no notification with that ID; marking one twice is fine.
+func (c Client) MarkNotificationRead(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE notifications
+	SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP)
+	WHERE id = ? AND user_id = ?
+	`
+	result, err := c.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
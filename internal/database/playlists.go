@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Playlist is an ordered collection of videos. Its Visibility works like a
+// video's: private playlists are only for their owner.
+type Playlist struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatePlaylistParams
+}
+
+type CreatePlaylistParams struct {
+	UserID      uuid.UUID `json:"user_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	// Visibility is one of the Visibility* constants:
+	Visibility string `json:"visibility"`
+}
+
+const playlistColumns = `id, created_at, updated_at, user_id, title, description, visibility`
+
+func scanPlaylist(row interface{ Scan(...any) error }) (Playlist, error) {
+	var playlist Playlist
+	err := row.Scan(
+		&playlist.ID,
+		&playlist.CreatedAt,
+		&playlist.UpdatedAt,
+		&playlist.UserID,
+		&playlist.Title,
+		&playlist.Description,
+		&playlist.Visibility,
+	)
+	return playlist, err
+}
+
+// CreatePlaylist makes the playlist with videoIDs in it, in that order.
+func (c Client) CreatePlaylist(ctx context.Context, params CreatePlaylistParams, videoIDs []uuid.UUID) (Playlist, error) {
+	id := uuid.New()
+	err := c.WithTx(ctx, func(tx Client) error {
+		query := `
+		INSERT INTO playlists (id, user_id, title, description, visibility)
+		VALUES (?, ?, ?, ?, ?)
+		`
+		if _, err := tx.db.ExecContext(ctx, query, id, params.UserID, params.Title, params.Description, params.Visibility); err != nil {
+			return err
+		}
+		return tx.SetPlaylistVideos(ctx, id, videoIDs)
+	})
+	if err != nil {
+		return Playlist{}, err
+	}
+	return c.GetPlaylist(ctx, id)
+}
+
+// GetPlaylist returns the playlist, or a zero Playlist if there's none with that ID.
+func (c Client) GetPlaylist(ctx context.Context, id uuid.UUID) (Playlist, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	playlist, err := scanPlaylist(c.db.QueryRowContext(ctx, `SELECT `+playlistColumns+` FROM playlists WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Playlist{}, nil
+	}
+	return playlist, err
+}
+
+// GetPlaylists returns the user's playlists, newest first.
+func (c Client) GetPlaylists(ctx context.Context, userID uuid.UUID) ([]Playlist, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT `+playlistColumns+` FROM playlists WHERE user_id = ? ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		playlist, err := scanPlaylist(rows)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists, rows.Err()
+}
+
+// UpdatePlaylist writes the playlist's title, description and visibility.
+func (c Client) UpdatePlaylist(ctx context.Context, playlist Playlist) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE playlists
+	SET title = ?, description = ?, visibility = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, playlist.Title, playlist.Description, playlist.Visibility, playlist.ID)
+	return err
+}
+
+// SetPlaylistVideos replaces the playlist's videos with videoIDs, in that
+// order, in one transaction.
+func (c Client) SetPlaylistVideos(ctx context.Context, playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	return c.inTx(ctx, func(tx dbtx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM playlist_videos WHERE playlist_id = ?`, playlistID); err != nil {
+			return err
+		}
+		for i, videoID := range videoIDs {
+			_, err := tx.ExecContext(ctx, `INSERT INTO playlist_videos (playlist_id, video_id, position) VALUES (?, ?, ?)`, playlistID, videoID, i)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?`, playlistID)
+		return err
+	})
+}
+
+// GetPlaylistVideos returns the playlist's videos in order, leaving out deleted
+// ones. Whether the viewer may see each is up to the caller.
+func (c Client) GetPlaylistVideos(ctx context.Context, playlistID uuid.UUID) ([]Video, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT
+		v.id,
+		v.created_at,
+		v.updated_at,
+		v.title,
+		v.description,
+		v.thumbnail_url,
+		v.thumbnails,
+		v.video_url,
+		v.hls_url,
+		v.dash_url,
+		v.media_kind,
+		v.original_filename,
+		v.version,
+		v.status,
+		v.moderation_status,
+		v.visibility,
+		v.like_count,
+		v.user_id
+	FROM playlist_videos pv
+	JOIN videos v ON v.id = pv.video_id
+	WHERE pv.playlist_id = ? AND v.deleted_at IS NULL
+	ORDER BY pv.position
+	`
+	rows, err := c.db.QueryContext(ctx, query, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		var video Video
+		if err := rows.Scan(
+			&video.ID,
+			&video.CreatedAt,
+			&video.UpdatedAt,
+			&video.Title,
+			&video.Description,
+			&video.ThumbnailURL,
+			&video.Thumbnails,
+			&video.VideoURL,
+			&video.HLSURL,
+			&video.DashURL,
+			&video.MediaKind,
+			&video.OriginalFilename,
+			&video.Version,
+			&video.Status,
+			&video.ModerationStatus,
+			&video.Visibility,
+			&video.LikeCount,
+			&video.UserID,
+		); err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// DeletePlaylist removes the playlist; its videos stay.
+func (c Client) DeletePlaylist(ctx context.Context, id uuid.UUID) error {
+	return c.WithTx(ctx, func(tx Client) error {
+		if _, err := tx.db.ExecContext(ctx, `DELETE FROM playlist_videos WHERE playlist_id = ?`, id); err != nil {
+			return err
+		}
+		_, err := tx.db.ExecContext(ctx, `DELETE FROM playlists WHERE id = ?`, id)
+		return err
+	})
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GetProbe returns the cached ffprobe JSON for the content with the given hash,
+// or nil when it was never probed, and marks the entry as used.
+func (c Client) GetProbe(ctx context.Context, hash string) ([]byte, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE probe_cache
+	SET used_at = CURRENT_TIMESTAMP
+	WHERE hash = ?
+	RETURNING probe_json
+	`
+	var probe string
+	err := c.db.QueryRowContext(ctx, query, hash).Scan(&probe)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(probe), nil
+}
+
+// SaveProbe caches ffprobe's JSON output for the content with the given hash:
+func (c Client) SaveProbe(ctx context.Context, hash string, probe []byte) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	INSERT INTO probe_cache (hash, created_at, used_at, probe_json)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?)
+	ON CONFLICT(hash) DO UPDATE SET used_at = CURRENT_TIMESTAMP, probe_json = excluded.probe_json
+	`
+	_, err := c.db.ExecContext(ctx, query, hash, string(probe))
+	return err
+}
+
+// DeleteProbesUnusedSince drops cache entries nobody read since before and
+// returns how many went.
+func (c Client) DeleteProbesUnusedSince(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	res, err := c.db.ExecContext(ctx, `DELETE FROM probe_cache WHERE used_at < ?`, before.UTC().Format(time.DateTime))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SetVideoProcessedHash records the SHA-256 of the stored file, which is what
+// its probe is cached under:
+func (c Client) SetVideoProcessedHash(ctx context.Context, videoID uuid.UUID, hash string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `UPDATE videos SET processed_sha256 = ? WHERE id = ?`, hash, videoID)
+	return err
+}
+
+// GetVideoProcessedHash returns the SHA-256 of the stored file, "" for videos
+// stored before it was recorded.
+func (c Client) GetVideoProcessedHash(ctx context.Context, videoID uuid.UUID) (string, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var hash sql.NullString
+	err := c.db.QueryRowContext(ctx, `SELECT processed_sha256 FROM videos WHERE id = ?`, videoID).Scan(&hash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	return hash.String, nil
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Replication states of a video's object in the replica bucket. Videos stored
+// while replication was off have none.
+const (
+	ReplicationPending    = "pending"
+	ReplicationReplicated = "replicated"
+	ReplicationFailed     = "failed"
+)
+
+// Replication is where a video's object stands in the replica bucket:
+type Replication struct {
+	VideoID      uuid.UUID  `json:"video_id"`
+	ObjectKey    string     `json:"object_key"`
+	Status       string     `json:"status"`
+	Error        string     `json:"error,omitempty"`
+	ReplicatedAt *time.Time `json:"replicated_at,omitempty"`
+}
+
+// SetReplicationStatus records the outcome of a copy; errMsg is kept for failures
+// and cleared otherwise.
+func (c Client) SetReplicationStatus(ctx context.Context, videoID uuid.UUID, status, errMsg string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE videos
+	SET replication_status = ?,
+		replication_error = NULLIF(?, ''),
+		replicated_at = CASE WHEN ? = ? THEN CURRENT_TIMESTAMP ELSE replicated_at END
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, status, errMsg, status, ReplicationReplicated, videoID)
+	return err
+}
+
+// GetReplication returns the video's replication state; Status is "" when it
+// has never been replicated.
+func (c Client) GetReplication(ctx context.Context, videoID uuid.UUID) (Replication, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT COALESCE(object_key, ''), COALESCE(replication_status, ''), COALESCE(replication_error, ''), replicated_at
+	FROM videos
+	WHERE id = ?
+	`
+	r := Replication{VideoID: videoID}
+	err := c.db.QueryRowContext(ctx, query, videoID).Scan(&r.ObjectKey, &r.Status, &r.Error, &r.ReplicatedAt)
+	if err != nil {
+		return Replication{}, err
+	}
+	return r, nil
+}
+
+// GetUnreplicated returns up to limit videos whose copy is still pending or
+// failed, oldest change first, for the retry sweep.
+func (c Client) GetUnreplicated(ctx context.Context, limit int) ([]Replication, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT id, object_key, replication_status, COALESCE(replication_error, '')
+	FROM videos
+	WHERE replication_status IN (?, ?)
+		AND object_key IS NOT NULL
+		AND deleted_at IS NULL
+	ORDER BY updated_at
+	LIMIT ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, ReplicationPending, ReplicationFailed, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replications []Replication
+	for rows.Next() {
+		var r Replication
+		if err := rows.Scan(&r.VideoID, &r.ObjectKey, &r.Status, &r.Error); err != nil {
+			return nil, err
+		}
+		replications = append(replications, r)
+	}
+	return replications, rows.Err()
+}
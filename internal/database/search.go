@@ -0,0 +1,291 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The search index over video titles and descriptions is an external-content
+// full-text table: it stores only the index and reads the text from videos, and
+// triggers keep it in sync. go-sqlite3 only compiles FTS5 in with the
+// sqlite_fts5 build tag (go build -tags sqlite_fts5); without it the index falls
+// back to FTS4, which matches the same queries but can't rank them, so results
+// come newest first instead.
+
+// FTS5 triggers remove rows with the special 'delete' command, which is given
+// the old text. FTS4 takes a plain DELETE by docid but reads the old text from
+// videos itself, so it has to run before the row changes.
+const (
+	searchTriggersFTS5 = `
+	CREATE TRIGGER IF NOT EXISTS videos_fts_ai AFTER INSERT ON videos BEGIN
+		INSERT INTO videos_fts(rowid, title, description) VALUES (new.rowid, new.title, new.description);
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_ad AFTER DELETE ON videos BEGIN
+		INSERT INTO videos_fts(videos_fts, rowid, title, description) VALUES ('delete', old.rowid, old.title, old.description);
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_au AFTER UPDATE OF title, description ON videos BEGIN
+		INSERT INTO videos_fts(videos_fts, rowid, title, description) VALUES ('delete', old.rowid, old.title, old.description);
+		INSERT INTO videos_fts(rowid, title, description) VALUES (new.rowid, new.title, new.description);
+	END;
+	`
+	searchTriggersFTS4 = `
+	CREATE TRIGGER IF NOT EXISTS videos_fts_ai AFTER INSERT ON videos BEGIN
+		INSERT INTO videos_fts(docid, title, description) VALUES (new.rowid, new.title, new.description);
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_bd BEFORE DELETE ON videos BEGIN
+		DELETE FROM videos_fts WHERE docid = old.rowid;
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_bu BEFORE UPDATE OF title, description ON videos BEGIN
+		DELETE FROM videos_fts WHERE docid = old.rowid;
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_au AFTER UPDATE OF title, description ON videos BEGIN
+		INSERT INTO videos_fts(docid, title, description) VALUES (new.rowid, new.title, new.description);
+	END;
+	`
+)
+
+// migrateSearch creates the search index and its triggers, and fills the index
+// from existing videos when it's new.
+func (c *Client) migrateSearch() error {
+	var existing string
+	err := c.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'videos_fts'`).Scan(&existing)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	created := existing == ""
+	if created {
+		_, err = c.db.Exec(`CREATE VIRTUAL TABLE videos_fts USING fts5(title, description, content='videos', content_rowid='rowid')`)
+		if err != nil && strings.Contains(err.Error(), "no such module") {
+			_, err = c.db.Exec(`CREATE VIRTUAL TABLE videos_fts USING fts4(title, description, content='videos')`)
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'videos_fts'`).Scan(&existing); err != nil {
+			return err
+		}
+	}
+
+	c.searchFTS5 = strings.Contains(strings.ToLower(existing), "fts5")
+	triggers := searchTriggersFTS4
+	if c.searchFTS5 {
+		triggers = searchTriggersFTS5
+	}
+	if _, err := c.db.Exec(triggers); err != nil {
+		return err
+	}
+	if created {
+		_, err = c.db.Exec(`INSERT INTO videos_fts(videos_fts) VALUES ('rebuild')`)
+	}
+	return err
+}
+
+type SearchVideosParams struct {
+	// Query is free text; every word must match, the last one as a prefix.
+	Query string
+	// OwnerID limits the results to one user's videos; uuid.Nil searches everyone's.
+	OwnerID uuid.UUID
+	// ViewerID sees their own videos whatever their moderation status and
+	// visibility; everyone else's only show up once approved, and if public.
+	// uuid.Nil for anonymous searches.
+	ViewerID uuid.UUID
+	// Tag limits the results to videos with that (normalized) tag. With a Tag,
+	// Query may be empty, to list the tag's videos newest first.
+	Tag    string
+	Limit  int
+	Offset int
+}
+
+// ErrEmptySearch is returned by SearchVideos when the query has no words in it
+// and there's no tag to go by either:
+var ErrEmptySearch = errors.New("search query has no words")
+
+// SearchVideos returns the videos matching the query, best matches first (with
+// FTS5; newest first otherwise). Title matches weigh more than description ones.
+func (c Client) SearchVideos(ctx context.Context, params SearchVideosParams) ([]Video, error) {
+	match := ftsQuery(params.Query, c.searchFTS5)
+	if match == "" && params.Tag == "" {
+		return nil, ErrEmptySearch
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	from := `videos_fts JOIN videos v ON v.rowid = videos_fts.rowid`
+	where := `videos_fts MATCH ?`
+	order := `v.created_at DESC`
+	if c.searchFTS5 {
+		order = `bm25(videos_fts, 10.0, 1.0), v.created_at DESC`
+	}
+	// No words, just a tag: list its videos, keeping the MATCH parameter's place:
+	if match == "" {
+		from, where, order = `videos v`, `? = ''`, `v.created_at DESC`
+	}
+	query := `
+	SELECT
+		v.id,
+		v.created_at,
+		v.updated_at,
+		v.title,
+		v.description,
+		v.thumbnail_url,
+		v.thumbnails,
+		v.video_url,
+		v.hls_url,
+		v.dash_url,
+		v.media_kind,
+		v.original_filename,
+		v.version,
+		v.status,
+		v.moderation_status,
+		v.visibility,
+		v.like_count,
+		v.user_id
+	FROM ` + from + `
+	WHERE ` + where + `
+		AND v.deleted_at IS NULL
+		AND ((v.moderation_status = ? AND v.visibility = ?) OR v.user_id = ?)
+		AND (? = '' OR v.user_id = ?)
+		AND (? = '' OR v.id IN (SELECT vt.video_id FROM video_tags vt JOIN tags t ON t.id = vt.tag_id WHERE t.name = ?))
+	ORDER BY ` + order + `
+	LIMIT ? OFFSET ?
+	`
+
+	owner := ""
+	if params.OwnerID != uuid.Nil {
+		owner = params.OwnerID.String()
+	}
+	rows, err := c.db.QueryContext(ctx, query,
+		match,
+		ModerationApproved, VisibilityPublic, params.ViewerID,
+		owner, owner,
+		params.Tag, params.Tag,
+		params.Limit, params.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		var video Video
+		if err := rows.Scan(
+			&video.ID,
+			&video.CreatedAt,
+			&video.UpdatedAt,
+			&video.Title,
+			&video.Description,
+			&video.ThumbnailURL,
+			&video.Thumbnails,
+			&video.VideoURL,
+			&video.HLSURL,
+			&video.DashURL,
+			&video.MediaKind,
+			&video.OriginalFilename,
+			&video.Version,
+			&video.Status,
+			&video.ModerationStatus,
+			&video.Visibility,
+			&video.LikeCount,
+			&video.UserID,
+		); err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return videos, c.attachTags(ctx, videos)
+}
+
+// ftsQuery turns user input into a safe MATCH expression: each word becomes a
+// quoted phrase, so FTS operators and stray quotes can't cause syntax errors,
+// and the last one matches as a prefix for search-as-you-type. FTS5 wants the
+// prefix star after the closing quote, FTS4 inside it.
+func ftsQuery(input string, fts5 bool) string {
+	var terms []string
+	for _, word := range strings.Fields(input) {
+		word = strings.ReplaceAll(word, `"`, "")
+		if word != "" {
+			terms = append(terms, `"`+word+`"`)
+		}
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	last := terms[len(terms)-1]
+	if fts5 {
+		terms[len(terms)-1] = last + "*"
+	} else {
+		terms[len(terms)-1] = last[:len(last)-1] + `*"`
+	}
+	return strings.Join(terms, " ")
+}
+
+// IndexedVideo is what an external search index keeps of a video.
+type IndexedVideo struct {
+	ID               uuid.UUID
+	UserID           uuid.UUID
+	Title            string
+	Description      string
+	Visibility       string
+	ModerationStatus string
+	CreatedAt        time.Time
+	Tags             []string
+}
+
+// GetIndexedVideos returns up to limit videos with IDs after the given one, in
+// ID order, for filling an external search index a page at a time; start from
+// uuid.Nil. Deleted videos are left out.
+func (c Client) GetIndexedVideos(ctx context.Context, after uuid.UUID, limit int) ([]IndexedVideo, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT id, user_id, title, description, visibility, moderation_status, created_at,
+		(SELECT group_concat(t.name, ',') FROM video_tags vt JOIN tags t ON t.id = vt.tag_id WHERE vt.video_id = videos.id)
+	FROM videos
+	WHERE id > ? AND deleted_at IS NULL
+	ORDER BY id
+	LIMIT ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []IndexedVideo{}
+	for rows.Next() {
+		var video IndexedVideo
+		var tags sql.NullString
+		if err := rows.Scan(
+			&video.ID,
+			&video.UserID,
+			&video.Title,
+			&video.Description,
+			&video.Visibility,
+			&video.ModerationStatus,
+			&video.CreatedAt,
+			&tags,
+		); err != nil {
+			return nil, err
+		}
+		// Tags can't contain commas, see SetVideoTags:
+		if tags.Valid {
+			video.Tags = strings.Split(tags.String, ",")
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareLink lets whoever holds its token watch one video, private or not, until
+// it expires or the owner revokes it.
+type ShareLink struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	VideoID   uuid.UUID  `json:"video_id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+type CreateShareLinkParams struct {
+	VideoID uuid.UUID
+	UserID  uuid.UUID
+	// TokenHash is the hex SHA-256 of the token handed out:
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+const shareLinkColumns = `id, created_at, video_id, user_id, expires_at, revoked_at`
+
+func scanShareLink(row interface{ Scan(...any) error }) (ShareLink, error) {
+	var link ShareLink
+	err := row.Scan(
+		&link.ID,
+		&link.CreatedAt,
+		&link.VideoID,
+		&link.UserID,
+		&link.ExpiresAt,
+		&link.RevokedAt,
+	)
+	return link, err
+}
+
+func (c Client) CreateShareLink(ctx context.Context, params CreateShareLinkParams) (ShareLink, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	id := uuid.New()
+	query := `
+	INSERT INTO share_links (id, video_id, user_id, token_hash, expires_at)
+	VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := c.db.ExecContext(ctx, query, id, params.VideoID, params.UserID, params.TokenHash, params.ExpiresAt.UTC())
+	if err != nil {
+		return ShareLink{}, err
+	}
+	return c.GetShareLink(ctx, id)
+}
+
+// GetShareLink returns the link, or a zero ShareLink if there's none with that ID.
+func (c Client) GetShareLink(ctx context.Context, id uuid.UUID) (ShareLink, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	link, err := scanShareLink(c.db.QueryRowContext(ctx, `SELECT `+shareLinkColumns+` FROM share_links WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShareLink{}, nil
+	}
+	return link, err
+}
+
+// GetShareLinks returns the video's links, revoked and expired ones included,
+// newest first.
+func (c Client) GetShareLinks(ctx context.Context, videoID uuid.UUID) ([]ShareLink, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT `+shareLinkColumns+` FROM share_links WHERE video_id = ? ORDER BY created_at DESC`, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// ValidShareLink reports whether tokenHash belongs to a link to videoID that
+// is neither revoked nor expired at now.
+func (c Client) ValidShareLink(ctx context.Context, videoID uuid.UUID, tokenHash string, now time.Time) (bool, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT COUNT(*)
+	FROM share_links
+	WHERE token_hash = ? AND video_id = ? AND revoked_at IS NULL AND expires_at > ?
+	`
+	var n int
+	if err := c.db.QueryRowContext(ctx, query, tokenHash, videoID, now.UTC()).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// RevokeShareLink stops the link working; revoking it again changes nothing.
+func (c Client) RevokeShareLink(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `UPDATE share_links SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL`, id)
+	return err
+}
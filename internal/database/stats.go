@@ -0,0 +1,217 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CreateProcessingRunParams struct {
+	VideoID   uuid.UUID     `json:"video_id"`
+	UserID    uuid.UUID     `json:"user_id"`
+	Duration  time.Duration `json:"duration"`
+	Succeeded bool          `json:"succeeded"`
+	Error     string        `json:"error,omitempty"`
+}
+
+type StorageStats struct {
+	ScannedAt   time.Time `json:"scanned_at"`
+	ObjectCount int64     `json:"object_count"`
+	TotalBytes  int64     `json:"total_bytes"`
+}
+
+type UserStorage struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Email      string    `json:"email"`
+	VideoCount int64     `json:"video_count"`
+	TotalBytes int64     `json:"total_bytes"`
+}
+
+type ProcessingStats struct {
+	TotalRuns         int64   `json:"total_runs"`
+	FailedRuns        int64   `json:"failed_runs"`
+	FailureRate       float64 `json:"failure_rate"`
+	AverageDurationMs float64 `json:"average_duration_ms"`
+}
+
+func (c Client) CreateProcessingRun(ctx context.Context, params CreateProcessingRunParams) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	INSERT INTO processing_runs (
+		id,
+		created_at,
+		video_id,
+		user_id,
+		duration_ms,
+		succeeded,
+		error
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.ExecContext(
+		ctx,
+		query,
+		uuid.New(),
+		params.VideoID,
+		params.UserID,
+		params.Duration.Milliseconds(),
+		params.Succeeded,
+		params.Error,
+	)
+	return err
+}
+
+func (c Client) CountVideos(ctx context.Context) (int64, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var count int64
+	err := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM videos WHERE deleted_at IS NULL`).Scan(&count)
+	return count, err
+}
+
+// GetProcessingStats aggregates every recorded processing run. The average
+// duration only counts successful runs, failed ones often bail out early.
+func (c Client) GetProcessingStats(ctx context.Context) (ProcessingStats, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT
+		COUNT(*),
+		COALESCE(SUM(CASE WHEN succeeded THEN 0 ELSE 1 END), 0),
+		COALESCE(AVG(CASE WHEN succeeded THEN duration_ms END), 0)
+	FROM processing_runs
+	`
+	var stats ProcessingStats
+	err := c.db.QueryRowContext(ctx, query).Scan(&stats.TotalRuns, &stats.FailedRuns, &stats.AverageDurationMs)
+	if err != nil {
+		return ProcessingStats{}, err
+	}
+	if stats.TotalRuns > 0 {
+		stats.FailureRate = float64(stats.FailedRuns) / float64(stats.TotalRuns)
+	}
+	return stats, nil
+}
+
+func (c Client) GetTopUsersByStorage(ctx context.Context, limit int) ([]UserStorage, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT
+		u.id,
+		u.email,
+		COUNT(v.id),
+		COALESCE(SUM(v.size_bytes), 0) AS total_bytes
+	FROM users u
+	JOIN videos v ON v.user_id = u.id
+	GROUP BY u.id, u.email
+	ORDER BY total_bytes DESC
+	LIMIT ?
+	`
+
+	rows, err := c.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []UserStorage{}
+	for rows.Next() {
+		var user UserStorage
+		if err := rows.Scan(&user.UserID, &user.Email, &user.VideoCount, &user.TotalBytes); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetStorageStats returns the result of the last bucket reconciliation scan,
+// or nil if no scan has run yet.
+func (c Client) GetStorageStats(ctx context.Context) (*StorageStats, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT scanned_at, object_count, total_bytes
+	FROM storage_stats
+	WHERE id = 1
+	`
+	var stats StorageStats
+	err := c.db.QueryRowContext(ctx, query).Scan(&stats.ScannedAt, &stats.ObjectCount, &stats.TotalBytes)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func (c Client) SaveStorageStats(ctx context.Context, stats StorageStats) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	INSERT INTO storage_stats (id, scanned_at, object_count, total_bytes)
+	VALUES (1, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		scanned_at = excluded.scanned_at,
+		object_count = excluded.object_count,
+		total_bytes = excluded.total_bytes
+	`
+	_, err := c.db.ExecContext(ctx, query, stats.ScannedAt, stats.ObjectCount, stats.TotalBytes)
+	return err
+}
+
+func (c Client) SetVideoSize(ctx context.Context, id uuid.UUID, sizeBytes int64) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE videos
+	SET size_bytes = ?
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, sizeBytes, id)
+	return err
+}
+
+// VideoSizes returns the stored file sizes of the videos that have one:
+func (c Client) VideoSizes(ctx context.Context, videoIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	sizes := map[uuid.UUID]int64{}
+	if len(videoIDs) == 0 {
+		return sizes, nil
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	args := make([]any, len(videoIDs))
+	for i, id := range videoIDs {
+		args[i] = id
+	}
+	query := `SELECT id, size_bytes FROM videos WHERE size_bytes > 0 AND id IN (?` + strings.Repeat(", ?", len(videoIDs)-1) + `)`
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		var size int64
+		if err := rows.Scan(&id, &size); err != nil {
+			return nil, err
+		}
+		sizes[id] = size
+	}
+	return sizes, rows.Err()
+}
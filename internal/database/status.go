@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// status values, a video's place in its lifecycle:
+const (
+	StatusDraft      = "draft"      // created, no file yet
+	StatusUploading  = "uploading"  // a file is on its way in
+	StatusProcessing = "processing" // a file arrived and is being transcoded and stored
+	StatusReady      = "ready"      // playable
+	StatusFailed     = "failed"     // the only file it got couldn't be processed
+	StatusArchived   = "archived"   // deleted by its owner, waiting to be purged
+)
+
+// statusTransitions lists where each status may move. A video that already
+// plays goes back to ready when a new upload is canceled or fails, so ready
+// is reachable from uploading and processing alike. Nothing leaves archived.
+var statusTransitions = map[string][]string{
+	StatusDraft:      {StatusUploading, StatusProcessing, StatusArchived},
+	StatusUploading:  {StatusDraft, StatusReady, StatusProcessing, StatusArchived},
+	StatusProcessing: {StatusReady, StatusFailed, StatusArchived},
+	StatusReady:      {StatusUploading, StatusProcessing, StatusArchived},
+	StatusFailed:     {StatusUploading, StatusProcessing, StatusArchived},
+	StatusArchived:   {},
+}
+
+// StatusTransitionAllowed reports whether statusTransitions lets a video move
+// from one status to another. Staying put is always allowed.
+func StatusTransitionAllowed(from, to string) bool {
+	return from == to || slices.Contains(statusTransitions[from], to)
+}
+
+// statusTransitionAbort is the message the guard trigger aborts with:
+const statusTransitionAbort = "invalid video status transition"
+
+// StatusTransitionError is returned by SetVideoStatus for a move the state
+// machine doesn't allow, such as anything out of archived.
+type StatusTransitionError struct {
+	VideoID uuid.UUID
+	From    string
+	To      string
+}
+
+func (e *StatusTransitionError) Error() string {
+	return fmt.Sprintf("video %s can't go from %s to %s", e.VideoID, e.From, e.To)
+}
+
+// migrateStatus adds the status column, filling it in for existing rows from
+// what they have, and (re)creates the trigger that enforces statusTransitions
+// on every UPDATE, whoever makes it.
+func (c *Client) migrateStatus() error {
+	var exists int
+	err := c.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('videos') WHERE name = 'status'`).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		if _, err := c.db.Exec(`ALTER TABLE videos ADD COLUMN status TEXT NOT NULL DEFAULT 'draft'`); err != nil {
+			return err
+		}
+		_, err = c.db.Exec(`
+		UPDATE videos SET status = CASE
+			WHEN deleted_at IS NOT NULL THEN 'archived'
+			WHEN video_url IS NOT NULL THEN 'ready'
+			ELSE 'draft'
+		END
+		`)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Dropped and recreated so it always matches statusTransitions:
+	if _, err := c.db.Exec(`DROP TRIGGER IF EXISTS videos_status_guard`); err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`
+	CREATE TRIGGER videos_status_guard BEFORE UPDATE OF status ON videos
+	WHEN old.status <> new.status AND NOT (` + statusTransitionSQL() + `)
+	BEGIN
+		SELECT RAISE(ABORT, '` + statusTransitionAbort + `');
+	END
+	`)
+	return err
+}
+
+// statusTransitionSQL renders statusTransitions as a condition on old.status
+// and new.status:
+func statusTransitionSQL() string {
+	var allowed []string
+	for from, tos := range statusTransitions {
+		if len(tos) == 0 {
+			continue
+		}
+		quoted := make([]string, len(tos))
+		for i, to := range tos {
+			quoted[i] = "'" + to + "'"
+		}
+		allowed = append(allowed, fmt.Sprintf("(old.status = '%s' AND new.status IN (%s))", from, strings.Join(quoted, ", ")))
+	}
+	return strings.Join(allowed, " OR ")
+}
+
+// SetVideoStatus moves the video to status. Setting the status it already has
+// is a no-op; a move statusTransitions doesn't allow returns a
+// *StatusTransitionError.
+func (c Client) SetVideoStatus(ctx context.Context, id uuid.UUID, status string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `UPDATE videos SET status = ? WHERE id = ?`, status, id)
+	if err != nil && strings.Contains(err.Error(), statusTransitionAbort) {
+		var from string
+		c.db.QueryRowContext(ctx, `SELECT status FROM videos WHERE id = ?`, id).Scan(&from)
+		return &StatusTransitionError{VideoID: id, From: from, To: status}
+	}
+	return err
+}
+
+// SettleVideoStatus moves the video out of from, if it's still there: back to
+// ready when it has a playable file from before, to fallback otherwise. It's how
+// a canceled upload or a failed transcode ends.
+func (c Client) SettleVideoStatus(ctx context.Context, id uuid.UUID, from, fallback string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE videos
+	SET status = CASE WHEN video_url IS NOT NULL THEN ? ELSE ? END
+	WHERE id = ? AND status = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, StatusReady, fallback, id, from)
+	return err
+}
+
+// RecoverVideoStatuses settles the videos a crash left mid-flight: processing
+// ones didn't finish, and uploading ones with no upload in progress never will.
+func (c Client) RecoverVideoStatuses(ctx context.Context) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE videos
+	SET status = CASE
+		WHEN video_url IS NOT NULL THEN 'ready'
+		WHEN status = 'processing' THEN 'failed'
+		ELSE 'draft'
+	END
+	WHERE status = 'processing'
+		OR (status = 'uploading' AND id NOT IN (SELECT video_id FROM uploads))
+	`
+	_, err := c.db.ExecContext(ctx, query)
+	return err
+}
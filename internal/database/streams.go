@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// VideoStreams describes a video's adaptive-streaming package: every segment and
+// manifest lives under Prefix, and either URL may be nil when that format wasn't
+// produced.
+type VideoStreams struct {
+	Prefix  string
+	HLSURL  *string
+	DashURL *string
+}
+
+// GetVideoStreamPrefix returns the key prefix of the video's current package, or
+// "" if it has none.
+func (c Client) GetVideoStreamPrefix(ctx context.Context, videoID uuid.UUID) (string, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var prefix *string
+	err := c.db.QueryRowContext(ctx, `SELECT stream_prefix FROM videos WHERE id = ?`, videoID).Scan(&prefix)
+	if err != nil || prefix == nil {
+		return "", err
+	}
+	return *prefix, nil
+}
+
+// SetVideoStreams points the video at a new package. Pass a zero VideoStreams to
+// clear it.
+func (c Client) SetVideoStreams(ctx context.Context, videoID uuid.UUID, streams VideoStreams) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var prefix *string
+	if streams.Prefix != "" {
+		prefix = &streams.Prefix
+	}
+	query := `
+	UPDATE videos
+	SET stream_prefix = ?, hls_url = ?, dash_url = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, prefix, streams.HLSURL, streams.DashURL, videoID)
+	return err
+}
+
+// GetHLSKey returns the video's sealed HLS content key, or nil if it has none.
+// The key is stored encrypted; sealing and opening it is the caller's job.
+func (c Client) GetHLSKey(ctx context.Context, videoID uuid.UUID) ([]byte, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var sealed []byte
+	err := c.db.QueryRowContext(ctx, `SELECT hls_key FROM videos WHERE id = ?`, videoID).Scan(&sealed)
+	return sealed, err
+}
+
+// SetHLSKeyIfMissing stores a sealed content key unless the video already has
+// one, so concurrent packaging runs agree on a single key. Read it back with
+// GetHLSKey.
+func (c Client) SetHLSKeyIfMissing(ctx context.Context, videoID uuid.UUID, sealed []byte) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `UPDATE videos SET hls_key = ? WHERE id = ? AND hls_key IS NULL`, sealed, videoID)
+	return err
+}
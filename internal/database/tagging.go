@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// StoredVideo is what we know about the objects a video has in storage, enough
+// to label them with their owner:
+type StoredVideo struct {
+	VideoID   uuid.UUID
+	UserID    uuid.UUID
+	MediaKind string
+	// ObjectKey is the playable file, "" when it wasn't recorded:
+	ObjectKey string
+	// Shared is set when ObjectKey is a content-addressed object other videos may
+	// point at too.
+	Shared bool
+	// StreamPrefix holds the HLS/DASH package, "" when there is none:
+	StreamPrefix string
+}
+
+// GetStoredVideos returns every live video with an object or a stream package:
+func (c Client) GetStoredVideos(ctx context.Context) ([]StoredVideo, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT id, user_id, media_kind, COALESCE(object_key, ''), content_hash IS NOT NULL, COALESCE(stream_prefix, '')
+	FROM videos
+	WHERE deleted_at IS NULL AND (object_key IS NOT NULL OR stream_prefix IS NOT NULL)
+	ORDER BY created_at
+	`
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var videos []StoredVideo
+	for rows.Next() {
+		var v StoredVideo
+		if err := rows.Scan(&v.VideoID, &v.UserID, &v.MediaKind, &v.ObjectKey, &v.Shared, &v.StreamPrefix); err != nil {
+			return nil, err
+		}
+		videos = append(videos, v)
+	}
+	return videos, rows.Err()
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// TagCount is a tag and how many videos anyone can see carry it:
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// SetVideoTags replaces the video's tags, in one transaction. Tags are stored
+// as given; the handler normalizes them first, and leaves no commas in them,
+// which GetIndexedVideos joins them with.
+func (c Client) SetVideoTags(ctx context.Context, videoID uuid.UUID, tags []string) ([]string, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	err := c.inTx(ctx, func(tx dbtx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM video_tags WHERE video_id = ?`, videoID); err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO tags (name) VALUES (?) ON CONFLICT(name) DO NOTHING`, tag); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO video_tags (video_id, tag_id) SELECT ?, id FROM tags WHERE name = ?`, videoID, tag)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.GetVideoTags(ctx, videoID)
+}
+
+// GetVideoTags returns the video's tags in alphabetical order.
+func (c Client) GetVideoTags(ctx context.Context, videoID uuid.UUID) ([]string, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT t.name
+	FROM video_tags vt
+	JOIN tags t ON t.id = vt.tag_id
+	WHERE vt.video_id = ?
+	ORDER BY t.name
+	`
+	rows, err := c.db.QueryContext(ctx, query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// attachTagsBatch is how many videos attachTags looks up per query, to stay
+// well under SQLite's limit on query parameters:
+const attachTagsBatch = 500
+
+// attachTags fills in the Tags of a list of videos, a batch per query.
+func (c Client) attachTags(ctx context.Context, videos []Video) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	index := make(map[uuid.UUID]int, len(videos))
+	for i, video := range videos {
+		index[video.ID] = i
+	}
+	for start := 0; start < len(videos); start += attachTagsBatch {
+		batch := videos[start:min(start+attachTagsBatch, len(videos))]
+		args := make([]any, len(batch))
+		for i, video := range batch {
+			args[i] = video.ID
+		}
+		query := `
+		SELECT vt.video_id, t.name
+		FROM video_tags vt
+		JOIN tags t ON t.id = vt.tag_id
+		WHERE vt.video_id IN (?` + strings.Repeat(", ?", len(batch)-1) + `)
+		ORDER BY t.name
+		`
+		rows, err := c.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var videoID uuid.UUID
+			var tag string
+			if err := rows.Scan(&videoID, &tag); err != nil {
+				rows.Close()
+				return err
+			}
+			i := index[videoID]
+			videos[i].Tags = append(videos[i].Tags, tag)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PopularTags returns the tags on the most videos anyone can see (approved,
+// public and not deleted), most used first.
+func (c Client) PopularTags(ctx context.Context, limit int) ([]TagCount, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT t.name, COUNT(*) AS uses
+	FROM video_tags vt
+	JOIN tags t ON t.id = vt.tag_id
+	JOIN videos v ON v.id = vt.video_id
+	WHERE v.deleted_at IS NULL AND v.moderation_status = ? AND v.visibility = ?
+	GROUP BY t.id
+	ORDER BY uses DESC, t.name
+	LIMIT ?
+	`
+	rows, err := c.db.QueryContext(ctx, query, ModerationApproved, VisibilityPublic, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []TagCount{}
+	for rows.Next() {
+		var count TagCount
+		if err := rows.Scan(&count.Tag, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
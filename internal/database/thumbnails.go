@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Thumbnails maps a width in pixels, as a string, to the URL of the video's
+// thumbnail scaled to it. It's stored as JSON in the thumbnails column.
+type Thumbnails map[string]string
+
+func (t *Thumbnails) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("can't scan %T into Thumbnails", src)
+	}
+	return json.Unmarshal(data, t)
+}
+
+func (t Thumbnails) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// SetVideoThumbnails stores the variants made from thumbnailURL, unless the
+// video's thumbnail was replaced meanwhile; it reports whether they were stored.
+// UpdateVideo clears them whenever thumbnail_url changes.
+func (c Client) SetVideoThumbnails(ctx context.Context, videoID uuid.UUID, thumbnailURL string, thumbnails Thumbnails) (bool, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE videos
+	SET thumbnails = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND thumbnail_url = ? AND deleted_at IS NULL
+	`
+	result, err := c.db.ExecContext(ctx, query, thumbnails, videoID, thumbnailURL)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Storage tiers a video's object can be in. "cold" means it has been tagged for
+// the lifecycle rule and may already be in Glacier.
+const (
+	StorageTierHot  = "hot"
+	StorageTierCold = "cold"
+)
+
+// VideoObject is a video's stored object as far as tiering is concerned:
+type VideoObject struct {
+	VideoID     uuid.UUID `json:"video_id"`
+	ObjectKey   string    `json:"object_key"`
+	StorageTier string    `json:"storage_tier"`
+}
+
+// RecordView counts one playback request for the video:
+func (c Client) RecordView(ctx context.Context, videoID uuid.UUID) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `INSERT INTO video_views (video_id, viewed_at) VALUES (?, CURRENT_TIMESTAMP)`, videoID)
+	return err
+}
+
+// GetVideoObject returns the video's object key and tier. ObjectKey is empty for
+// videos uploaded before keys were recorded, or not uploaded at all.
+func (c Client) GetVideoObject(ctx context.Context, videoID uuid.UUID) (VideoObject, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	obj := VideoObject{VideoID: videoID}
+	var key *string
+	err := c.db.QueryRowContext(ctx, `SELECT object_key, storage_tier FROM videos WHERE id = ?`, videoID).Scan(&key, &obj.StorageTier)
+	if err != nil {
+		return VideoObject{}, err
+	}
+	if key != nil {
+		obj.ObjectKey = *key
+	}
+	return obj, nil
+}
+
+// VideoObjectKeys returns the object keys of the videos that have one:
+func (c Client) VideoObjectKeys(ctx context.Context, videoIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	keys := map[uuid.UUID]string{}
+	if len(videoIDs) == 0 {
+		return keys, nil
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	args := make([]any, len(videoIDs))
+	for i, id := range videoIDs {
+		args[i] = id
+	}
+	query := `SELECT id, object_key FROM videos WHERE object_key IS NOT NULL AND id IN (?` + strings.Repeat(", ?", len(videoIDs)-1) + `)`
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			return nil, err
+		}
+		keys[id] = key
+	}
+	return keys, rows.Err()
+}
+
+// SetVideoObject records the key of a freshly stored object; new objects are hot,
+// and yet to be checked for integrity:
+func (c Client) SetVideoObject(ctx context.Context, videoID uuid.UUID, key string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE videos
+	SET object_key = ?, storage_tier = ?,
+		integrity_status = ?, integrity_error = NULL, integrity_checked_at = NULL
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, key, StorageTierHot, IntegrityUnchecked, videoID)
+	return err
+}
+
+func (c Client) SetVideoStorageTier(ctx context.Context, videoID uuid.UUID, tier string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `UPDATE videos SET storage_tier = ? WHERE id = ?`, tier, videoID)
+	return err
+}
+
+// GetColdVideoCandidates returns hot videos created before since that have had
+// at most maxViews views since then, i.e. nobody watches them any more.
+// Content-addressed objects shared with other videos are skipped; one cold
+// video shouldn't archive another's playback.
+func (c Client) GetColdVideoCandidates(ctx context.Context, since time.Time, maxViews, limit int) ([]VideoObject, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT v.id, v.object_key, v.storage_tier
+	FROM videos v
+	WHERE v.object_key IS NOT NULL
+		AND v.deleted_at IS NULL
+		AND v.storage_tier = ?
+		AND (v.content_hash IS NULL OR (
+			SELECT ref_count FROM content_objects co WHERE co.hash = v.content_hash
+		) = 1)
+		AND v.created_at < ?
+		AND (
+			SELECT COUNT(*) FROM video_views vv
+			WHERE vv.video_id = v.id AND vv.viewed_at >= ?
+		) <= ?
+	ORDER BY v.created_at
+	LIMIT ?
+	`
+	// CURRENT_TIMESTAMP's format, so the comparisons are plain string compares:
+	cutoff := since.UTC().Format(time.DateTime)
+	rows, err := c.db.QueryContext(ctx, query, StorageTierHot, cutoff, cutoff, maxViews, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []VideoObject{}
+	for rows.Next() {
+		var obj VideoObject
+		if err := rows.Scan(&obj.VideoID, &obj.ObjectKey, &obj.StorageTier); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, obj)
+	}
+	return candidates, rows.Err()
+}
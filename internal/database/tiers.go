@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// users.tier values, which decide the upload limits:
+const (
+	TierFree = "free"
+	TierPro  = "pro"
+)
+
+// SetUserTier moves the user to another tier:
+func (c Client) SetUserTier(ctx context.Context, userID uuid.UUID, tier string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE users
+	SET tier = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, tier, userID.String())
+	return err
+}
+
+// RecordUploadUsage counts duration of processed media against the user:
+func (c Client) RecordUploadUsage(ctx context.Context, userID, videoID uuid.UUID, duration time.Duration) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	INSERT INTO upload_usage (id, created_at, user_id, video_id, duration_ms)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.ExecContext(ctx, query, uuid.NewString(), userID.String(), videoID.String(), duration.Milliseconds())
+	return err
+}
+
+// GetUploadUsageSince is how much media the user has had processed since since:
+func (c Client) GetUploadUsageSince(ctx context.Context, userID uuid.UUID, since time.Time) (time.Duration, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT COALESCE(SUM(duration_ms), 0)
+	FROM upload_usage
+	WHERE user_id = ? AND created_at >= ?
+	`
+	var ms int64
+	err := c.db.QueryRowContext(ctx, query, userID.String(), since.UTC().Format(time.DateTime)).Scan(&ms)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// VideoDurations returns the durations of the videos that have been processed,
+// the latest processing's when a video was replaced:
+func (c Client) VideoDurations(ctx context.Context, videoIDs []uuid.UUID) (map[uuid.UUID]time.Duration, error) {
+	durations := map[uuid.UUID]time.Duration{}
+	if len(videoIDs) == 0 {
+		return durations, nil
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	args := make([]any, len(videoIDs))
+	for i, id := range videoIDs {
+		args[i] = id.String()
+	}
+	query := `
+	SELECT video_id, duration_ms
+	FROM upload_usage
+	WHERE video_id IN (?` + strings.Repeat(", ?", len(videoIDs)-1) + `)
+	ORDER BY created_at, rowid
+	`
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		var ms int64
+		if err := rows.Scan(&id, &ms); err != nil {
+			return nil, err
+		}
+		durations[id] = time.Duration(ms) * time.Millisecond
+	}
+	return durations, rows.Err()
+}
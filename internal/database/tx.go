@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// dbtx is what the query methods need from the database: the *sql.DB, or the
+// *sql.Tx of a Client from WithTx.
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// WithTx runs fn with a Client whose methods all run in one transaction. It
+// commits if fn returns nil, and rolls back if fn fails or panics. Inside fn,
+// use only the tx Client, and only on this goroutine; calling WithTx on it joins
+// the same transaction. The statement timeout bounds the whole transaction.
+func (c Client) WithTx(ctx context.Context, fn func(tx Client) error) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	return c.inTx(ctx, func(tx dbtx) error {
+		c.db = tx
+		return fn(c)
+	})
+}
+
+// inTx runs fn in a new transaction, or in the one c is already in, so methods
+// that need a transaction of their own still work inside WithTx.
+func (c Client) inTx(ctx context.Context, fn func(tx dbtx) error) error {
+	db, ok := c.db.(*sql.DB)
+	if !ok {
+		return fn(c.db)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
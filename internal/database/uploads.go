@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upload is a resumable upload in progress: the client sends the file in pieces,
+// each appended at OffsetBytes to the file at TempPath.
+type Upload struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	VideoID     uuid.UUID `json:"video_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	MediaType   string    `json:"media_type"`
+	SizeBytes   int64     `json:"size_bytes"`
+	OffsetBytes int64     `json:"offset_bytes"`
+	TempPath    string    `json:"-"`
+	// Metadata is the client's raw Upload-Metadata header, echoed back on HEAD:
+	Metadata string `json:"-"`
+}
+
+type CreateUploadParams struct {
+	ID        uuid.UUID
+	VideoID   uuid.UUID
+	UserID    uuid.UUID
+	MediaType string
+	SizeBytes int64
+	TempPath  string
+	Metadata  string
+}
+
+func (c Client) CreateUpload(ctx context.Context, params CreateUploadParams) (Upload, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	INSERT INTO uploads (id, video_id, user_id, media_type, size_bytes, temp_path, metadata)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.ExecContext(ctx, query, params.ID, params.VideoID, params.UserID, params.MediaType, params.SizeBytes, params.TempPath, params.Metadata)
+	if err != nil {
+		return Upload{}, err
+	}
+	return c.GetUpload(ctx, params.ID)
+}
+
+const uploadColumns = `id, created_at, updated_at, video_id, user_id, media_type, size_bytes, offset_bytes, temp_path, metadata`
+
+func scanUpload(row interface{ Scan(...any) error }) (Upload, error) {
+	var upload Upload
+	err := row.Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&upload.VideoID,
+		&upload.UserID,
+		&upload.MediaType,
+		&upload.SizeBytes,
+		&upload.OffsetBytes,
+		&upload.TempPath,
+		&upload.Metadata,
+	)
+	return upload, err
+}
+
+// GetUpload returns the upload, or a zero Upload if there's none with that ID.
+func (c Client) GetUpload(ctx context.Context, id uuid.UUID) (Upload, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	upload, err := scanUpload(c.db.QueryRowContext(ctx, `SELECT `+uploadColumns+` FROM uploads WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Upload{}, nil
+	}
+	return upload, err
+}
+
+// GetUploads returns every upload in progress, for the startup re-scan.
+func (c Client) GetUploads(ctx context.Context) ([]Upload, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT `+uploadColumns+` FROM uploads ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []Upload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+// GetStaleUploads returns the uploads nothing has been written to since before,
+// oldest first.
+func (c Client) GetStaleUploads(ctx context.Context, before time.Time) ([]Upload, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	// CURRENT_TIMESTAMP's format, so the comparison is a plain string compare:
+	cutoff := before.UTC().Format(time.DateTime)
+	rows, err := c.db.QueryContext(ctx, `SELECT `+uploadColumns+` FROM uploads WHERE updated_at < ? ORDER BY updated_at`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []Upload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+// SetUploadOffset records how many bytes of the upload are safely on disk:
+func (c Client) SetUploadOffset(ctx context.Context, id uuid.UUID, offset int64) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `UPDATE uploads SET offset_bytes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, offset, id)
+	return err
+}
+
+func (c Client) DeleteUpload(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `DELETE FROM uploads WHERE id = ?`, id)
+	return err
+}
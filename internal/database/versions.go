@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reasons a video's file was replaced, kept with the version it replaced:
+const (
+	VersionReplaced      = "replaced"
+	VersionRestored      = "restored"
+	VersionAudioMuted    = "audio_muted"
+	VersionAudioReplaced = "audio_replaced"
+	VersionReprocessed   = "reprocessed"
+)
+
+// VideoVersion is a file a video played before an edit replaced it. ContentHash
+// is set in the content-addressable layout, where the version holds a reference
+// to the object like a video does.
+type VideoVersion struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	Number    int       `json:"number"`
+	CreatedAt time.Time `json:"created_at"`
+	Reason    string    `json:"reason"`
+	ObjectKey string    `json:"-"`
+	VideoURL  *string   `json:"video_url"`
+	// VideoURLExpiresAt is set when VideoURL is signed, like a video's:
+	VideoURLExpiresAt *time.Time `json:"video_url_expires_at,omitempty"`
+	ContentHash       *string    `json:"-"`
+}
+
+const versionColumns = `video_id, number, created_at, reason, object_key, video_url, content_hash`
+
+func scanVersion(row interface{ Scan(...any) error }) (VideoVersion, error) {
+	var v VideoVersion
+	err := row.Scan(&v.VideoID, &v.Number, &v.CreatedAt, &v.Reason, &v.ObjectKey, &v.VideoURL, &v.ContentHash)
+	return v, err
+}
+
+// AddVideoVersion records a replaced file as the video's next version, and
+// returns its number.
+func (c Client) AddVideoVersion(ctx context.Context, version VideoVersion) (int, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	INSERT INTO video_versions (video_id, number, created_at, reason, object_key, video_url, content_hash)
+	SELECT ?, COALESCE(MAX(number), 0) + 1, CURRENT_TIMESTAMP, ?, ?, ?, ?
+	FROM video_versions
+	WHERE video_id = ?
+	RETURNING number
+	`
+	var number int
+	err := c.db.QueryRowContext(ctx, query,
+		version.VideoID, version.Reason, version.ObjectKey, version.VideoURL, version.ContentHash,
+		version.VideoID,
+	).Scan(&number)
+	return number, err
+}
+
+// GetVideoVersions returns the video's earlier versions, newest first.
+func (c Client) GetVideoVersions(ctx context.Context, videoID uuid.UUID) ([]VideoVersion, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT `+versionColumns+` FROM video_versions WHERE video_id = ? ORDER BY number DESC`, videoID)
+	if err != nil {
+		return nil, err
+	}
+	return scanVersions(rows)
+}
+
+// GetVideoVersion returns one of the video's earlier versions, or a zero
+// VideoVersion if it has none by that number.
+func (c Client) GetVideoVersion(ctx context.Context, videoID uuid.UUID, number int) (VideoVersion, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	row := c.db.QueryRowContext(ctx, `SELECT `+versionColumns+` FROM video_versions WHERE video_id = ? AND number = ?`, videoID, number)
+	v, err := scanVersion(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoVersion{}, nil
+	}
+	return v, err
+}
+
+// GetExpiredVersions returns the versions past the retention policy: all but
+// the keep newest of each video, and, unless createdBefore is zero, any made
+// before it.
+func (c Client) GetExpiredVersions(ctx context.Context, keep int, createdBefore time.Time) ([]VideoVersion, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	SELECT ` + versionColumns + `
+	FROM (
+		SELECT *, ROW_NUMBER() OVER (PARTITION BY video_id ORDER BY number DESC) AS newness
+		FROM video_versions
+	)
+	WHERE newness > ?`
+	args := []any{keep}
+	if !createdBefore.IsZero() {
+		query += ` OR created_at < ?`
+		args = append(args, createdBefore.UTC().Format(time.DateTime))
+	}
+	query += ` ORDER BY video_id, number`
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanVersions(rows)
+}
+
+func scanVersions(rows *sql.Rows) ([]VideoVersion, error) {
+	defer rows.Close()
+	versions := []VideoVersion{}
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// DeleteVideoVersion removes the record of a version; its object is the
+// caller's to release.
+func (c Client) DeleteVideoVersion(ctx context.Context, videoID uuid.UUID, number int) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `DELETE FROM video_versions WHERE video_id = ? AND number = ?`, videoID, number)
+	return err
+}
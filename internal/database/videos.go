@@ -1,8 +1,10 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,16 +16,66 @@ type Video struct {
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
 	VideoURL     *string   `json:"video_url"`
+	HLSURL       *string   `json:"hls_url,omitempty"`
+	DashURL      *string   `json:"dash_url,omitempty"`
+	// Thumbnails are ThumbnailURL scaled to each of a few widths, for srcset;
+	// they're cleared when ThumbnailURL changes until new ones are made.
+	Thumbnails Thumbnails `json:"thumbnails,omitempty"`
+	// MediaKind is "video" or, for podcast-style audio posts, "audio":
+	MediaKind string `json:"media_kind"`
+	// OriginalFilename is the (sanitized) name of the uploaded file, used for
+	// downloads instead of the random storage key:
+	OriginalFilename *string `json:"original_filename,omitempty"`
+	// Version goes up by one on every UpdateVideo; see ConflictError.
+	Version int `json:"version"`
+	// Status is one of the Status* constants; SetVideoStatus moves it.
+	Status string `json:"status"`
+	// ModerationStatus is one of the Moderation* constants:
+	ModerationStatus string    `json:"moderation_status"`
+	Chapters         []Chapter `json:"chapters,omitempty"`
+	// Tags are normalized (see SetVideoTags) and sorted:
+	Tags []string `json:"tags,omitempty"`
+	// LikeCount is kept up to date by LikeVideo and UnlikeVideo:
+	LikeCount int `json:"like_count"`
+	// LikedByMe is only set for logged-in viewers; see LikedVideos.
+	LikedByMe *bool `json:"liked_by_me,omitempty"`
+	// VideoURLExpiresAt is set when VideoURL is signed, for players to fetch
+	// the video again before then; it isn't stored.
+	VideoURLExpiresAt *time.Time `json:"video_url_expires_at,omitempty"`
 	CreateVideoParams
 }
 
+// ConflictError is returned by UpdateVideo when the row was updated (or deleted)
+// after the caller read it, so writing the caller's copy would lose that change.
+// Re-read the video, re-apply the change and try again.
+type ConflictError struct {
+	VideoID uuid.UUID
+	Version int
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("video %s changed since version %d was read", e.VideoID, e.Version)
+}
+
 type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	// Visibility is one of the Visibility* constants; empty means public.
+	Visibility string `json:"visibility"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+// Visibility values. Public videos are for anyone who has the ID; private ones
+// only for the owner, admins, and people given a share link.
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+func (c Client) GetVideos(ctx context.Context, userID uuid.UUID) ([]Video, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	query := `
 	SELECT
 		id,
@@ -32,14 +84,24 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		title,
 		description,
 		thumbnail_url,
+		thumbnails,
 		video_url,
+		hls_url,
+		dash_url,
+		media_kind,
+		original_filename,
+		version,
+		status,
+		moderation_status,
+		visibility,
+		like_count,
 		user_id
 	FROM videos
-	WHERE user_id = ?
+	WHERE user_id = ? AND deleted_at IS NULL
 	ORDER BY created_at DESC
 	`
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -55,18 +117,35 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 			&video.Title,
 			&video.Description,
 			&video.ThumbnailURL,
+			&video.Thumbnails,
 			&video.VideoURL,
+			&video.HLSURL,
+			&video.DashURL,
+			&video.MediaKind,
+			&video.OriginalFilename,
+			&video.Version,
+			&video.Status,
+			&video.ModerationStatus,
+			&video.Visibility,
+			&video.LikeCount,
 			&video.UserID,
 		); err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
 
-	return videos, nil
+	return videos, c.attachTags(ctx, videos)
 }
 
-func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
+func (c Client) CreateVideo(ctx context.Context, params CreateVideoParams) (Video, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	id := uuid.New()
 	query := `
 	INSERT INTO videos (
@@ -75,18 +154,26 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		visibility
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	visibility := params.Visibility
+	if visibility == "" {
+		visibility = VisibilityPublic
+	}
+	_, err := c.db.ExecContext(ctx, query, id, params.Title, params.Description, params.UserID, visibility)
 	if err != nil {
 		return Video{}, err
 	}
 
-	return c.GetVideo(id)
+	return c.GetVideo(ctx, id)
 }
 
-func (c Client) GetVideo(id uuid.UUID) (Video, error) {
+func (c Client) GetVideo(ctx context.Context, id uuid.UUID) (Video, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	query := `
 	SELECT
 		id,
@@ -95,21 +182,41 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		title,
 		description,
 		thumbnail_url,
+		thumbnails,
 		video_url,
+		hls_url,
+		dash_url,
+		media_kind,
+		original_filename,
+		version,
+		status,
+		moderation_status,
+		visibility,
+		like_count,
 		user_id
 	FROM videos
-	WHERE id = ?
+	WHERE id = ? AND deleted_at IS NULL
 	`
 
 	var video Video
-	err := c.db.QueryRow(query, id).Scan(
+	err := c.db.QueryRowContext(ctx, query, id).Scan(
 		&video.ID,
 		&video.CreatedAt,
 		&video.UpdatedAt,
 		&video.Title,
 		&video.Description,
 		&video.ThumbnailURL,
+		&video.Thumbnails,
 		&video.VideoURL,
+		&video.HLSURL,
+		&video.DashURL,
+		&video.MediaKind,
+		&video.OriginalFilename,
+		&video.Version,
+		&video.Status,
+		&video.ModerationStatus,
+		&video.Visibility,
+		&video.LikeCount,
 		&video.UserID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -118,38 +225,153 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		return Video{}, err
 	}
 
+	video.Chapters, err = c.GetChapters(ctx, video.ID)
+	if err != nil {
+		return Video{}, err
+	}
+	video.Tags, err = c.GetVideoTags(ctx, video.ID)
+	if err != nil {
+		return Video{}, err
+	}
+
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
+// UpdateVideo writes video back if its row is still at video.Version, and returns
+// a *ConflictError otherwise. On success the stored version is video.Version+1.
+func (c Client) UpdateVideo(ctx context.Context, video Video) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	query := `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
+		thumbnails = CASE WHEN thumbnail_url IS ? THEN thumbnails ELSE NULL END,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
-	WHERE id = ?
+		media_kind = ?,
+		original_filename = ?,
+		user_id = ?,
+		version = version + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND version = ?
 	`
 
-	_, err := c.db.Exec(
+	result, err := c.db.ExecContext(
+		ctx,
 		query,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		&video.ThumbnailURL,
 		&video.VideoURL,
+		video.MediaKind,
+		video.OriginalFilename,
 		video.UserID,
 		video.ID,
+		video.Version,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &ConflictError{VideoID: video.ID, Version: video.Version}
+	}
+	return nil
+}
+
+// SoftDeleteVideos hides those of ids that belong to userID, leaving the rows for
+// a background cleanup to remove with DeleteVideo. It returns the IDs it hid;
+// the rest were someone else's, missing, or already deleted.
+func (c Client) SoftDeleteVideos(ctx context.Context, userID uuid.UUID, ids []uuid.UUID) ([]uuid.UUID, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+	UPDATE videos
+	SET deleted_at = CURRENT_TIMESTAMP, status = 'archived'
+	WHERE id = ? AND user_id = ? AND deleted_at IS NULL
+	`
+	deleted := []uuid.UUID{}
+	for _, id := range ids {
+		result, err := c.db.ExecContext(ctx, query, id, userID)
+		if err != nil {
+			return deleted, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		if n > 0 {
+			deleted = append(deleted, id)
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteVideo removes the video and its rows in other tables, all or nothing.
+func (c Client) DeleteVideo(ctx context.Context, id uuid.UUID) error {
+	return c.WithTx(ctx, func(tx Client) error {
+		if err := tx.DeleteChapters(ctx, id); err != nil {
+			return err
+		}
+		if _, err := tx.db.ExecContext(ctx, `DELETE FROM video_views WHERE video_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.db.ExecContext(ctx, `DELETE FROM uploads WHERE video_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.db.ExecContext(ctx, `DELETE FROM share_links WHERE video_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.db.ExecContext(ctx, `DELETE FROM playlist_videos WHERE video_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.db.ExecContext(ctx, `DELETE FROM video_likes WHERE video_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.db.ExecContext(ctx, `DELETE FROM video_versions WHERE video_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.db.ExecContext(ctx, `DELETE FROM video_tags WHERE video_id = ?`, id); err != nil {
+			return err
+		}
+
+		query := `
+		DELETE FROM videos
+		WHERE id = ?
+		`
+		_, err := tx.db.ExecContext(ctx, query, id)
+		return err
+	})
 }
 
-func (c Client) DeleteVideo(id uuid.UUID) error {
+// SetVideoSource records the SHA-256 and size of the file as it was uploaded,
+// before any processing:
+func (c Client) SetVideoSource(ctx context.Context, id uuid.UUID, sha256 string, sizeBytes int64) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	query := `
-	DELETE FROM videos
+	UPDATE videos
+	SET source_sha256 = ?, source_size_bytes = ?
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err := c.db.ExecContext(ctx, query, sha256, sizeBytes, id)
+	return err
+}
+
+// SetVideoVisibility makes the video public or private:
+func (c Client) SetVideoVisibility(ctx context.Context, videoID uuid.UUID, visibility string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `UPDATE videos SET visibility = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, visibility, videoID)
 	return err
 }
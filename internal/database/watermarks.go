@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+// Watermark is a user's logo overlay settings. AssetPath is relative to the
+// assets root, like thumbnail files.
+type Watermark struct {
+	AssetPath string  `json:"asset_path"`
+	Position  string  `json:"position"`
+	Opacity   float64 `json:"opacity"`
+}
+
+// GetWatermark returns nil when the user hasn't uploaded a watermark.
+func (c Client) GetWatermark(ctx context.Context, userID uuid.UUID) (*Watermark, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT watermark_path, watermark_position, watermark_opacity
+		FROM users
+		WHERE id = ?
+	`
+	var (
+		assetPath sql.NullString
+		position  sql.NullString
+		opacity   sql.NullFloat64
+	)
+	err := c.db.QueryRowContext(ctx, query, userID.String()).Scan(&assetPath, &position, &opacity)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !assetPath.Valid {
+		return nil, nil
+	}
+	return &Watermark{
+		AssetPath: assetPath.String,
+		Position:  position.String,
+		Opacity:   opacity.Float64,
+	}, nil
+}
+
+func (c Client) SetWatermark(ctx context.Context, userID uuid.UUID, watermark Watermark) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET
+			watermark_path = ?,
+			watermark_position = ?,
+			watermark_opacity = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, watermark.AssetPath, watermark.Position, watermark.Opacity, userID.String())
+	return err
+}
+
+func (c Client) ClearWatermark(ctx context.Context, userID uuid.UUID) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET
+			watermark_path = NULL,
+			watermark_position = NULL,
+			watermark_opacity = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.ExecContext(ctx, query, userID.String())
+	return err
+}
@@ -0,0 +1,35 @@
+// Package errreport sends crashes (recovered panics) to an error tracker, so
+// they're seen even when nobody is reading the logs.
+package errreport
+
+import (
+	"context"
+	"time"
+)
+
+// Report describes one crash.
+type Report struct {
+	// Message is the panic value, formatted:
+	Message string
+	// Stack is the goroutine's stack trace at the panic, from debug.Stack:
+	Stack []byte
+	Time  time.Time
+	// RequestID, Method and Route identify the request that crashed, if it
+	// was one; Route is the ServeMux pattern, e.g. "POST /api/video_upload/{videoID}".
+	RequestID string
+	Method    string
+	Route     string
+	// Tags are extra searchable labels, e.g. the job kind of a crashed job.
+	Tags map[string]string
+}
+
+// Reporter delivers crash reports. Report is called on the crashed request's
+// goroutine, so implementations shouldn't keep it waiting long.
+type Reporter interface {
+	Report(ctx context.Context, report Report)
+}
+
+// NoOp drops reports; the crash is still logged. It's the default.
+type NoOp struct{}
+
+func (NoOp) Report(ctx context.Context, report Report) {}
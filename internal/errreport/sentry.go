@@ -0,0 +1,141 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sentryTimeout bounds one delivery:
+const sentryTimeout = 10 * time.Second
+
+// Sentry sends reports to Sentry (or a compatible service, like GlitchTip)
+// through its store endpoint. It posts the events itself rather than pulling in
+// the SDK, which would also hook into logging and HTTP clients we don't want
+// touched.
+type Sentry struct {
+	endpoint  string
+	publicKey string
+	// Environment and Release label every event, e.g. "production" and a git SHA:
+	Environment string
+	Release     string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// NewSentry parses a project DSN, https://<key>@<host>/<project-id>.
+func NewSentry(dsn string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: no public key")
+	}
+	// The project ID is the last path segment; anything before it is a prefix
+	// the API lives under too:
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || i == len(path)-1 {
+		return nil, fmt.Errorf("invalid Sentry DSN: no project ID")
+	}
+	return &Sentry{
+		endpoint:  fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path[:i], path[i+1:]),
+		publicKey: u.User.Username(),
+	}, nil
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Transaction string            `json:"transaction,omitempty"`
+	Exception   sentryExceptions  `json:"exception"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Report sends the event in the background; delivery failures are logged.
+func (s *Sentry) Report(ctx context.Context, report Report) {
+	tags := map[string]string{}
+	for k, v := range report.Tags {
+		tags[k] = v
+	}
+	if report.RequestID != "" {
+		tags["request_id"] = report.RequestID
+	}
+	if report.Method != "" {
+		tags["http.method"] = report.Method
+	}
+	event := sentryEvent{
+		EventID:     strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Timestamp:   report.Time.UTC().Format(time.RFC3339),
+		Level:       "fatal",
+		Platform:    "go",
+		Logger:      "tubely",
+		Environment: s.Environment,
+		Release:     s.Release,
+		Transaction: report.Route,
+		Exception:   sentryExceptions{Values: []sentryException{{Type: "panic", Value: report.Message}}},
+		Tags:        tags,
+		Extra:       map[string]string{"stack": string(report.Stack)},
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sentryTimeout)
+		defer cancel()
+		if err := s.send(ctx, event); err != nil {
+			log.Printf("Couldn't report crash to Sentry: %v", err)
+		}
+	}()
+}
+
+func (s *Sentry) send(ctx context.Context, event sentryEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=tubely/1.0, sentry_key=%s", s.publicKey))
+
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return fmt.Errorf("sentry store: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Package flags holds the per-deployment feature switches. Each flag has a
+// built-in default, which a JSON file can override, which the environment can
+// override in turn.
+package flags
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Flag names a feature switch. Its environment variable is the name in upper
+// case, e.g. ENABLE_HLS.
+type Flag string
+
+const (
+	// EnableHLS and EnableDASH package processed videos for adaptive streaming.
+	EnableHLS  Flag = "enable_hls"
+	EnableDASH Flag = "enable_dash"
+	// EnableModeration runs new videos and thumbnails past the moderator. Off,
+	// everything is approved as it's uploaded.
+	EnableModeration Flag = "enable_moderation"
+	// EnableDirectUploads hands out presigned URLs to upload straight to S3.
+	EnableDirectUploads Flag = "enable_direct_uploads"
+	// AutoThumbnails gives videos processed without a thumbnail one taken from
+	// their frames.
+	AutoThumbnails Flag = "auto_thumbnails"
+)
+
+// defaults lists every flag there is:
+var defaults = map[Flag]bool{
+	EnableHLS:           false,
+	EnableDASH:          false,
+	EnableModeration:    true,
+	EnableDirectUploads: true,
+	AutoThumbnails:      true,
+}
+
+// Where a flag's value came from:
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+)
+
+// State is one flag's value, as GET /api/admin/flags shows it.
+type State struct {
+	Name    Flag   `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source"`
+}
+
+// Set is the flags of one deployment. It doesn't change once loaded, so it's
+// safe to share.
+type Set struct {
+	states map[Flag]State
+}
+
+// Load reads the flags. path, if not empty, is a JSON object of flag names to
+// booleans, e.g. {"enable_hls": true}; unknown names are an error, so typos
+// don't go unnoticed. Environment variables win over the file.
+func Load(path string) (*Set, error) {
+	return LoadFrom(path, os.LookupEnv)
+}
+
+// LoadFrom is Load with the environment looked up through lookup.
+func LoadFrom(path string, lookup func(string) (string, bool)) (*Set, error) {
+	s := &Set{states: make(map[Flag]State, len(defaults))}
+	for name, enabled := range defaults {
+		s.states[name] = State{Name: name, Enabled: enabled, Source: SourceDefault}
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read flags file: %w", err)
+		}
+		var fromFile map[Flag]bool
+		if err := json.Unmarshal(data, &fromFile); err != nil {
+			return nil, fmt.Errorf("couldn't parse flags file %s: %w", path, err)
+		}
+		for name, enabled := range fromFile {
+			if _, ok := defaults[name]; !ok {
+				return nil, fmt.Errorf("unknown flag %q in %s", name, path)
+			}
+			s.states[name] = State{Name: name, Enabled: enabled, Source: SourceFile}
+		}
+	}
+
+	for name := range defaults {
+		envName := strings.ToUpper(string(name))
+		value, _ := lookup(envName)
+		if value == "" {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false: %w", envName, err)
+		}
+		s.states[name] = State{Name: name, Enabled: enabled, Source: SourceEnv}
+	}
+	return s, nil
+}
+
+// Enabled reports whether the feature is on. Flags that don't exist are off.
+func (s *Set) Enabled(name Flag) bool {
+	return s.states[name].Enabled
+}
+
+// All returns every flag, by name.
+func (s *Set) All() []State {
+	all := make([]State, 0, len(s.states))
+	for _, state := range s.states {
+		all = append(all, state)
+	}
+	slices.SortFunc(all, func(a, b State) int {
+		return strings.Compare(string(a.Name), string(b.Name))
+	})
+	return all
+}
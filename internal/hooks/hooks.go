@@ -0,0 +1,252 @@
+// Package hooks lets a deployment run its own Go code when a video is created,
+// when its upload starts, when it's processed or fails, when who can see it
+// changes, and when it's deleted: billing, search indexing, notifications to
+// other systems.
+// Hooks are compiled into the server; register one from an init function in a
+// file of your own in package main:
+//
+//	func init() {
+//		hooks.Register("billing", hooks.Funcs{
+//			Processed: func(ctx context.Context, e hooks.Event) {
+//				chargeFor(e.UserID, e.Video)
+//			},
+//		})
+//	}
+//
+// Each hook gets its events in order, one at a time, on a goroutine of its own,
+// so a slow hook holds up neither requests nor the other hooks. A hook that
+// falls more than QueueSize events behind has events dropped, and logged.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// QueueSize is how many events a hook may fall behind by:
+const QueueSize = 256
+
+// Kind names an event, for logs:
+type Kind string
+
+const (
+	Created       Kind = "created"
+	UploadStarted Kind = "upload_started"
+	Processed     Kind = "processed"
+	Failed        Kind = "failed"
+	Updated       Kind = "updated"
+	Deleted       Kind = "deleted"
+)
+
+// Event is what happened to which video.
+type Event struct {
+	Kind    Kind
+	VideoID uuid.UUID
+	UserID  uuid.UUID
+	// Video is the video as it was at the time; nil for Deleted.
+	Video *database.Video
+	// Err is why processing failed, for Failed:
+	Err error
+}
+
+// Hook is the code a deployment runs on events. The context keeps the
+// request's values but not its cancellation, as events often outlive the
+// request.
+type Hook interface {
+	OnCreated(ctx context.Context, e Event)
+	OnUploadStarted(ctx context.Context, e Event)
+	OnProcessed(ctx context.Context, e Event)
+	OnFailed(ctx context.Context, e Event)
+	// OnUpdated is for changes to the video's visibility or moderation status,
+	// which decide who can find it.
+	OnUpdated(ctx context.Context, e Event)
+	OnDeleted(ctx context.Context, e Event)
+}
+
+// Funcs is a Hook made of whichever functions are set; the others do nothing.
+type Funcs struct {
+	Created       func(ctx context.Context, e Event)
+	UploadStarted func(ctx context.Context, e Event)
+	Processed     func(ctx context.Context, e Event)
+	Failed        func(ctx context.Context, e Event)
+	Updated       func(ctx context.Context, e Event)
+	Deleted       func(ctx context.Context, e Event)
+}
+
+func (f Funcs) OnCreated(ctx context.Context, e Event)       { call(f.Created, ctx, e) }
+func (f Funcs) OnUploadStarted(ctx context.Context, e Event) { call(f.UploadStarted, ctx, e) }
+func (f Funcs) OnProcessed(ctx context.Context, e Event)     { call(f.Processed, ctx, e) }
+func (f Funcs) OnFailed(ctx context.Context, e Event)        { call(f.Failed, ctx, e) }
+func (f Funcs) OnUpdated(ctx context.Context, e Event)       { call(f.Updated, ctx, e) }
+func (f Funcs) OnDeleted(ctx context.Context, e Event)       { call(f.Deleted, ctx, e) }
+
+func call(fn func(context.Context, Event), ctx context.Context, e Event) {
+	if fn != nil {
+		fn(ctx, e)
+	}
+}
+
+// Registry delivers events to the hooks registered with it. A nil *Registry
+// has no hooks, so callers don't have to check.
+type Registry struct {
+	mu     sync.Mutex
+	hooks  []*runner
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type delivery struct {
+	ctx context.Context
+	e   Event
+}
+
+type runner struct {
+	name  string
+	hook  Hook
+	queue chan delivery
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+var defaultRegistry = NewRegistry()
+
+// Register adds a hook to the registry main uses; see the package comment.
+// Like Registry.Register, it panics on a name that's taken.
+func Register(name string, h Hook) {
+	defaultRegistry.Register(name, h)
+}
+
+// Default returns the registry Register adds to:
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// Register adds h under name, which shows in the logs. It panics if name is
+// already registered, or the registry is closed.
+func (r *Registry) Register(name string, h Hook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		panic("hooks: Register after Close")
+	}
+	for _, existing := range r.hooks {
+		if existing.name == name {
+			panic(fmt.Sprintf("hooks: %q registered twice", name))
+		}
+	}
+	run := &runner{name: name, hook: h, queue: make(chan delivery, QueueSize)}
+	r.hooks = append(r.hooks, run)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for d := range run.queue {
+			run.deliver(d)
+		}
+	}()
+}
+
+// Names lists the registered hooks, in the order they were registered:
+func (r *Registry) Names() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, len(r.hooks))
+	for i, run := range r.hooks {
+		names[i] = run.name
+	}
+	return names
+}
+
+func (r *Registry) Created(ctx context.Context, video database.Video) {
+	r.publish(ctx, Event{Kind: Created, VideoID: video.ID, UserID: video.UserID, Video: &video})
+}
+
+func (r *Registry) UploadStarted(ctx context.Context, video database.Video) {
+	r.publish(ctx, Event{Kind: UploadStarted, VideoID: video.ID, UserID: video.UserID, Video: &video})
+}
+
+func (r *Registry) Processed(ctx context.Context, video database.Video) {
+	r.publish(ctx, Event{Kind: Processed, VideoID: video.ID, UserID: video.UserID, Video: &video})
+}
+
+func (r *Registry) Failed(ctx context.Context, video database.Video, err error) {
+	r.publish(ctx, Event{Kind: Failed, VideoID: video.ID, UserID: video.UserID, Video: &video, Err: err})
+}
+
+func (r *Registry) Updated(ctx context.Context, video database.Video) {
+	r.publish(ctx, Event{Kind: Updated, VideoID: video.ID, UserID: video.UserID, Video: &video})
+}
+
+func (r *Registry) Deleted(ctx context.Context, videoID, userID uuid.UUID) {
+	r.publish(ctx, Event{Kind: Deleted, VideoID: videoID, UserID: userID})
+}
+
+// publish queues e for every hook. It never blocks: a hook whose queue is
+// full misses the event.
+func (r *Registry) publish(ctx context.Context, e Event) {
+	if r == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	for _, run := range r.hooks {
+		select {
+		case run.queue <- delivery{ctx, e}:
+		default:
+			log.Printf("Hook %s is %d events behind, dropped %s of video %s", run.name, QueueSize, e.Kind, e.VideoID)
+		}
+	}
+}
+
+// Close stops taking events and waits for the hooks to handle those queued.
+func (r *Registry) Close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		for _, run := range r.hooks {
+			close(run.queue)
+		}
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// deliver calls the hook, which can't take the server down by panicking:
+func (run *runner) deliver(d delivery) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("Hook %s panicked on %s of video %s: %v\n%s", run.name, d.e.Kind, d.e.VideoID, v, debug.Stack())
+		}
+	}()
+	switch d.e.Kind {
+	case Created:
+		run.hook.OnCreated(d.ctx, d.e)
+	case UploadStarted:
+		run.hook.OnUploadStarted(d.ctx, d.e)
+	case Processed:
+		run.hook.OnProcessed(d.ctx, d.e)
+	case Failed:
+		run.hook.OnFailed(d.ctx, d.e)
+	case Updated:
+		run.hook.OnUpdated(d.ctx, d.e)
+	case Deleted:
+		run.hook.OnDeleted(d.ctx, d.e)
+	}
+}
@@ -0,0 +1,95 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// recorder is a Hook that keeps what it's given:
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) add(_ context.Context, e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *recorder) OnCreated(ctx context.Context, e Event)       { r.add(ctx, e) }
+func (r *recorder) OnUploadStarted(ctx context.Context, e Event) { r.add(ctx, e) }
+func (r *recorder) OnProcessed(ctx context.Context, e Event)     { r.add(ctx, e) }
+func (r *recorder) OnFailed(ctx context.Context, e Event)        { r.add(ctx, e) }
+func (r *recorder) OnUpdated(ctx context.Context, e Event)       { r.add(ctx, e) }
+func (r *recorder) OnDeleted(ctx context.Context, e Event)       { r.add(ctx, e) }
+
+func TestRegistryDeliversInOrder(t *testing.T) {
+	reg := NewRegistry()
+	rec := &recorder{}
+	reg.Register("recorder", rec)
+	// A hook that panics doesn't stop the others, or its own later events:
+	var processed int
+	reg.Register("panicky", Funcs{
+		UploadStarted: func(context.Context, Event) { panic("boom") },
+		Processed:     func(context.Context, Event) { processed++ },
+	})
+
+	video := database.Video{ID: uuid.New(), CreateVideoParams: database.CreateVideoParams{UserID: uuid.New()}}
+	failure := errors.New("transcode failed")
+	ctx, cancel := context.WithCancel(context.Background())
+	reg.Created(ctx, video)
+	reg.UploadStarted(ctx, video)
+	reg.Failed(ctx, video, failure)
+	reg.Processed(ctx, video)
+	reg.Updated(ctx, video)
+	reg.Deleted(ctx, video.ID, video.UserID)
+	cancel()
+	reg.Close()
+
+	want := []Kind{Created, UploadStarted, Failed, Processed, Updated, Deleted}
+	if len(rec.events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(rec.events), len(want))
+	}
+	for i, e := range rec.events {
+		if e.Kind != want[i] || e.VideoID != video.ID || e.UserID != video.UserID {
+			t.Errorf("event %d = %s of %s by %s, want %s of %s by %s", i, e.Kind, e.VideoID, e.UserID, want[i], video.ID, video.UserID)
+		}
+	}
+	if rec.events[2].Err != failure {
+		t.Errorf("Failed event has err %v, want %v", rec.events[1].Err, failure)
+	}
+	if rec.events[5].Video != nil {
+		t.Error("Deleted event has a video")
+	}
+	if processed != 1 {
+		t.Errorf("panicky hook processed %d events, want 1", processed)
+	}
+}
+
+func TestRegistryDuplicateName(t *testing.T) {
+	reg := NewRegistry()
+	defer reg.Close()
+	reg.Register("search", Funcs{})
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice didn't panic")
+		}
+	}()
+	reg.Register("search", Funcs{})
+}
+
+func TestNilRegistry(t *testing.T) {
+	var reg *Registry
+	reg.Processed(context.Background(), database.Video{})
+	reg.Deleted(context.Background(), uuid.New(), uuid.New())
+	if names := reg.Names(); len(names) != 0 {
+		t.Errorf("nil registry has hooks %v", names)
+	}
+	reg.Close()
+}
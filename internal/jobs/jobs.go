@@ -0,0 +1,228 @@
+// Package jobs runs background work (transcodes, packaging, cleanup) on bounded
+// worker pools. Jobs are split into priority tiers, each with its own pool, so a
+// burst of hour-long transcodes can't hold up a quick clip.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Priority int
+
+// Tiers, highest first:
+const (
+	PriorityHigh Priority = iota
+	PriorityNormal
+	PriorityLow
+	NumPriorities
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityNormal:
+		return "normal"
+	case PriorityLow:
+		return "low"
+	}
+	return "unknown"
+}
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusRetrying  Status = "retrying" // failed, waiting out its backoff; see Retry
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+// Finished reports whether the status is terminal:
+func (s Status) Finished() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCanceled
+}
+
+// ErrNotFound is returned by Get for unknown or already pruned jobs.
+var ErrNotFound = errors.New("job not found")
+
+// RetriesExhaustedError is the error of a job that failed on every attempt its
+// Retry allowed, wrapping the last attempt's. Jobs without retries fail with
+// their own error.
+type RetriesExhaustedError struct {
+	Kind     string
+	Attempts int
+	Err      error
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	return fmt.Sprintf("%s failed %d times: %v", e.Kind, e.Attempts, e.Err)
+}
+
+func (e *RetriesExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// Retry has a failed job run again after Backoff, then twice that, and so on,
+// until it has run Attempts times. Canceled jobs and Permanent errors aren't
+// retried. The zero Retry runs a job once.
+type Retry struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+// Permanent marks an error retrying won't fix, so the job fails right away:
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// RunFunc does the job's work. It should return promptly once ctx is done.
+type RunFunc func(ctx context.Context, job *Job) error
+
+// Spec describes a job to submit.
+type Spec struct {
+	Kind     string
+	OwnerID  uuid.UUID
+	VideoID  uuid.UUID
+	Priority Priority
+	Run      RunFunc
+	Retry    Retry
+}
+
+type Job struct {
+	ID      uuid.UUID
+	Kind    string
+	OwnerID uuid.UUID
+	VideoID uuid.UUID
+
+	submittedAt time.Time // unlike enqueuedAt, never reset by promotions
+	run         RunFunc
+	retry       Retry
+	done        chan struct{}
+
+	mu         sync.Mutex
+	priority   Priority
+	status     Status
+	canceled   bool
+	cancel     context.CancelFunc // set while running
+	progress   float64            // percent complete, 0-100
+	attempts   int                // runs so far, see Retry
+	changed    chan struct{}      // closed and replaced on every visible change
+	err        error
+	enqueuedAt time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// Snapshot is a point-in-time, JSON-friendly copy of a job's state.
+type Snapshot struct {
+	ID         uuid.UUID  `json:"id"`
+	Kind       string     `json:"kind"`
+	VideoID    uuid.UUID  `json:"video_id"`
+	Priority   string     `json:"priority"`
+	Status     Status     `json:"status"`
+	Progress   float64    `json:"progress"`
+	Attempts   int        `json:"attempts"`
+	Error      string     `json:"error,omitempty"`
+	EnqueuedAt time.Time  `json:"enqueued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func (j *Job) Snapshot() Snapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s := Snapshot{
+		ID:         j.ID,
+		Kind:       j.Kind,
+		VideoID:    j.VideoID,
+		Priority:   j.priority.String(),
+		Status:     j.status,
+		Progress:   math.Round(j.progress*10) / 10,
+		Attempts:   j.attempts,
+		EnqueuedAt: j.enqueuedAt,
+	}
+	if j.err != nil {
+		s.Error = j.err.Error()
+	}
+	if !j.startedAt.IsZero() {
+		startedAt := j.startedAt
+		s.StartedAt = &startedAt
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		s.FinishedAt = &finishedAt
+	}
+	return s
+}
+
+// SetProgress records how far along a running job is, in percent. Watchers are
+// only woken when the whole-number percentage changes.
+func (j *Job) SetProgress(percent float64) {
+	percent = min(max(percent, 0), 100)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	wake := math.Floor(percent) != math.Floor(j.progress)
+	j.progress = percent
+	if wake {
+		j.notifyLocked()
+	}
+}
+
+// Changed returns a channel that is closed the next time the job's status or
+// progress changes. Take it before reading a Snapshot so no update is missed.
+func (j *Job) Changed() <-chan struct{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.changed
+}
+
+func (j *Job) notifyLocked() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// Cancel stops the job: a queued job is dropped when a worker reaches it, a
+// running one has its context canceled.
+func (j *Job) Cancel() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.canceled = true
+	if j.cancel != nil {
+		j.cancel()
+	}
+}
+
+// Wait blocks until the job finishes (returning its error) or ctx is done.
+// Giving up on the wait doesn't cancel the job.
+func (j *Job) Wait(ctx context.Context) error {
+	select {
+	case <-j.done:
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		return j.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
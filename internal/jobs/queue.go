@@ -0,0 +1,405 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Config sizes the worker pools. Workers[p] is the number of workers dedicated
+// to tier p. A worker prefers its own tier but, when that's empty, helps with
+// higher tiers, never lower ones: high-priority workers can't get stuck on huge
+// jobs. StarvationAge bounds how long a job waits before being promoted one tier.
+type Config struct {
+	Workers       [NumPriorities]int
+	StarvationAge time.Duration
+	// Retention is how long finished jobs stay visible to Get.
+	Retention time.Duration
+	// OnPanic, if set, is told about jobs that panicked, with the recovered
+	// value and the stack. The job fails either way.
+	OnPanic func(job *Job, recovered any, stack []byte)
+}
+
+type Queue struct {
+	cfg Config
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending [NumPriorities][]*Job
+	jobs    map[uuid.UUID]*Job
+	closed  bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewQueue(cfg Config) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		cfg:    cfg,
+		jobs:   map[uuid.UUID]*Job{},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Start launches the worker pools.
+func (q *Queue) Start() {
+	for tier := Priority(0); tier < NumPriorities; tier++ {
+		for range max(q.cfg.Workers[tier], 0) {
+			q.wg.Add(1)
+			go q.worker(tier)
+		}
+	}
+	if q.cfg.StarvationAge > 0 {
+		q.wg.Add(1)
+		go q.starvationGuard()
+	}
+	if q.cfg.Retention > 0 {
+		q.wg.Add(1)
+		go q.pruner()
+	}
+}
+
+// Submit enqueues a job and returns it immediately.
+func (q *Queue) Submit(spec Spec) (*Job, error) {
+	if spec.Priority < 0 || spec.Priority >= NumPriorities {
+		return nil, fmt.Errorf("invalid priority %d", spec.Priority)
+	}
+	now := time.Now().UTC()
+	job := &Job{
+		ID:          uuid.New(),
+		Kind:        spec.Kind,
+		OwnerID:     spec.OwnerID,
+		VideoID:     spec.VideoID,
+		run:         spec.Run,
+		retry:       spec.Retry,
+		done:        make(chan struct{}),
+		changed:     make(chan struct{}),
+		priority:    spec.Priority,
+		status:      StatusQueued,
+		enqueuedAt:  now,
+		submittedAt: now,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, fmt.Errorf("job queue is shut down")
+	}
+	q.jobs[job.ID] = job
+	q.pending[job.priority] = append(q.pending[job.priority], job)
+	q.cond.Broadcast()
+	return job, nil
+}
+
+func (q *Queue) Get(id uuid.UUID) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return job, nil
+}
+
+// ListByVideo returns the jobs known for a video, oldest first:
+func (q *Queue) ListByVideo(videoID uuid.UUID) []*Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var list []*Job
+	for _, job := range q.jobs {
+		if job.VideoID == videoID {
+			list = append(list, job)
+		}
+	}
+	slices.SortFunc(list, func(a, b *Job) int {
+		return a.submittedAt.Compare(b.submittedAt)
+	})
+	return list
+}
+
+// Depths reports how many jobs wait in each tier, keyed by tier name.
+func (q *Queue) Depths() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	depths := map[string]int{}
+	for tier := Priority(0); tier < NumPriorities; tier++ {
+		depths[tier.String()] = len(q.pending[tier])
+	}
+	return depths
+}
+
+// Unfinished reports how many jobs are queued, running or waiting to retry,
+// keyed by status; it's empty once the queue has drained.
+func (q *Queue) Unfinished() map[Status]int {
+	q.mu.Lock()
+	list := make([]*Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		list = append(list, job)
+	}
+	q.mu.Unlock()
+
+	counts := map[Status]int{}
+	for _, job := range list {
+		job.mu.Lock()
+		if !job.status.Finished() {
+			counts[job.status]++
+		}
+		job.mu.Unlock()
+	}
+	return counts
+}
+
+// Shutdown stops accepting jobs, cancels running ones and waits for the workers.
+func (q *Queue) Shutdown() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.cancel()
+	q.wg.Wait()
+}
+
+// next blocks until there is a job for a worker of the given tier: its own tier
+// first, then higher tiers from the top down.
+func (q *Queue) next(tier Priority) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.closed {
+			return nil
+		}
+		if job := q.popLocked(tier); job != nil {
+			return job
+		}
+		for p := Priority(0); p < tier; p++ {
+			if job := q.popLocked(p); job != nil {
+				return job
+			}
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *Queue) popLocked(tier Priority) *Job {
+	if len(q.pending[tier]) == 0 {
+		return nil
+	}
+	job := q.pending[tier][0]
+	q.pending[tier][0] = nil
+	q.pending[tier] = q.pending[tier][1:]
+	return job
+}
+
+func (q *Queue) worker(tier Priority) {
+	defer q.wg.Done()
+	for {
+		job := q.next(tier)
+		if job == nil {
+			return
+		}
+		q.execute(job)
+	}
+}
+
+func (q *Queue) execute(job *Job) {
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+
+	job.mu.Lock()
+	if job.canceled {
+		job.status = StatusCanceled
+		job.err = context.Canceled
+		job.finishedAt = time.Now().UTC()
+		job.notifyLocked()
+		job.mu.Unlock()
+		close(job.done)
+		return
+	}
+	job.status = StatusRunning
+	job.startedAt = time.Now().UTC()
+	job.cancel = cancel
+	job.notifyLocked()
+	job.mu.Unlock()
+
+	err := q.runSafely(ctx, job)
+
+	job.mu.Lock()
+	job.attempts++
+	if err != nil && !job.canceled && q.ctx.Err() == nil && !errors.As(err, new(*permanentError)) {
+		if job.attempts < job.retry.Attempts {
+			q.retryLocked(job, err)
+			job.mu.Unlock()
+			return
+		}
+		if job.retry.Attempts > 1 {
+			err = &RetriesExhaustedError{Kind: job.Kind, Attempts: job.attempts, Err: err}
+		}
+	}
+	job.err = err
+	job.cancel = nil
+	job.finishedAt = time.Now().UTC()
+	switch {
+	case err == nil:
+		job.status = StatusSucceeded
+		job.progress = 100
+	case job.canceled:
+		job.status = StatusCanceled
+	default:
+		job.status = StatusFailed
+	}
+	canceled := job.canceled
+	job.notifyLocked()
+	job.mu.Unlock()
+	close(job.done)
+
+	if err != nil && !canceled {
+		log.Printf("Job %s (%s) failed: %v", job.ID, job.Kind, err)
+	}
+}
+
+// retryLocked puts a failed job back on its tier once its backoff is up. It
+// stays unfinished meanwhile, so Wait keeps waiting, unless the queue shuts
+// down first: then the job is canceled with the error it failed with.
+func (q *Queue) retryLocked(job *Job, err error) {
+	delay := job.retry.Backoff << (job.attempts - 1)
+	job.status = StatusRetrying
+	job.err = err
+	job.cancel = nil
+	job.progress = 0
+	job.notifyLocked()
+	log.Printf("Job %s (%s) failed on attempt %d of %d, retrying in %v: %v", job.ID, job.Kind, job.attempts, job.retry.Attempts, delay, err)
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-q.ctx.Done():
+		}
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		job.mu.Lock()
+		if q.closed {
+			job.status = StatusCanceled
+			job.finishedAt = time.Now().UTC()
+			job.notifyLocked()
+			job.mu.Unlock()
+			close(job.done)
+			return
+		}
+		job.status = StatusQueued
+		job.enqueuedAt = time.Now().UTC()
+		tier := job.priority
+		job.notifyLocked()
+		job.mu.Unlock()
+		q.pending[tier] = append(q.pending[tier], job)
+		q.cond.Broadcast()
+	}()
+}
+
+// runSafely keeps one panicking job from taking the worker down with it:
+func (q *Queue) runSafely(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			err = fmt.Errorf("job panicked: %v\n%s", r, stack)
+			if q.cfg.OnPanic != nil {
+				q.cfg.OnPanic(job, r, stack)
+			}
+		}
+	}()
+	return job.run(ctx, job)
+}
+
+// starvationGuard periodically promotes jobs that waited longer than
+// StarvationAge.
+func (q *Queue) starvationGuard() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(q.cfg.StarvationAge / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+			q.promoteStarved(time.Now().UTC())
+		}
+	}
+}
+
+// pruner periodically drops finished jobs past their retention.
+func (q *Queue) pruner() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(q.cfg.Retention / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+			q.pruneFinished(time.Now().UTC())
+		}
+	}
+}
+
+func (q *Queue) promoteStarved(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	promoted := false
+	for tier := Priority(1); tier < NumPriorities; tier++ {
+		kept := q.pending[tier][:0]
+		for _, job := range q.pending[tier] {
+			job.mu.Lock()
+			starved := now.Sub(job.enqueuedAt) > q.cfg.StarvationAge
+			if starved {
+				job.priority = tier - 1
+				// restart the clock so it climbs one tier per StarvationAge:
+				job.enqueuedAt = now
+				job.notifyLocked()
+			}
+			job.mu.Unlock()
+			if starved {
+				q.pending[tier-1] = append(q.pending[tier-1], job)
+				promoted = true
+			} else {
+				kept = append(kept, job)
+			}
+		}
+		clear(q.pending[tier][len(kept):])
+		q.pending[tier] = kept
+	}
+	if promoted {
+		q.cond.Broadcast()
+	}
+}
+
+func (q *Queue) pruneFinished(now time.Time) {
+	if q.cfg.Retention <= 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, job := range q.jobs {
+		job.mu.Lock()
+		expired := !job.finishedAt.IsZero() && now.Sub(job.finishedAt) > q.cfg.Retention
+		job.mu.Unlock()
+		if expired {
+			delete(q.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShutdownDuringRetryBackoff(t *testing.T) {
+	q := NewQueue(Config{Workers: [NumPriorities]int{1, 1, 1}})
+	q.Start()
+
+	failed := errors.New("flaky")
+	ran := make(chan struct{}, 1)
+	job, err := q.Submit(Spec{
+		Kind:     "flaky",
+		Priority: PriorityNormal,
+		Retry:    Retry{Attempts: 3, Backoff: time.Hour},
+		Run: func(ctx context.Context, job *Job) error {
+			ran <- struct{}{}
+			return failed
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ran
+	// Wait for the job to go into its backoff:
+	for job.Snapshot().Status != StatusRetrying {
+		time.Sleep(time.Millisecond)
+	}
+
+	q.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := job.Wait(ctx); !errors.Is(err, failed) {
+		t.Fatalf("Wait = %v, want the job's last error", err)
+	}
+	if status := job.Snapshot().Status; status != StatusCanceled {
+		t.Errorf("status = %s, want %s", status, StatusCanceled)
+	}
+}
+
+func TestPruneWithoutStarvationAge(t *testing.T) {
+	q := NewQueue(Config{Workers: [NumPriorities]int{1, 1, 1}, Retention: 10 * time.Millisecond})
+	q.Start()
+	t.Cleanup(q.Shutdown)
+
+	job, err := q.Submit(Spec{
+		Kind:     "quick",
+		Priority: PriorityNormal,
+		Run:      func(ctx context.Context, job *Job) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := q.Get(job.ID); errors.Is(err, ErrNotFound) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("finished job was never pruned")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,288 @@
+// Package keys names the objects uploaded videos are stored as. Each layout
+// (STORAGE_KEY_LAYOUT) is a Strategy that builds a key from what's known about
+// the upload and parses that back out of a key, so tools holding only a key can
+// tell what it is.
+package keys
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Layout names a Strategy:
+type Layout string
+
+const (
+	// LayoutPrefix is the original layout, an aspect-ratio directory plus a
+	// random name: landscape/<random>.mp4, audio/<random>.mp3.
+	LayoutPrefix Layout = "prefix"
+	// LayoutUser groups each user's objects: users/<user ID>/<random>.mp4.
+	LayoutUser Layout = "user"
+	// LayoutDate groups objects by upload day (UTC): 2026/10/17/<random>.mp4.
+	LayoutDate Layout = "date"
+	// LayoutCAS derives the key from the SHA-256 of the stored bytes, fanned
+	// out over two directory levels: sha256/ab/cd/abcd1234....mp4. Identical
+	// uploads share one object, and a URL never changes content.
+	LayoutCAS Layout = "cas"
+)
+
+// Object is what a key is built from. Which fields a layout uses is up to it;
+// the random part of a name comes from Rand, crypto/rand when it's nil.
+type Object struct {
+	UserID uuid.UUID
+	// Audio objects go under "audio" in the prefix layout, whatever their
+	// AspectRatio:
+	Audio bool
+	// AspectRatio is what the probe classified the video as: "16:9", "9:16",
+	// "4:3", "1:1" or "other".
+	AspectRatio string
+	// Ext includes the dot, e.g. ".mp4":
+	Ext string
+	// Hash is the hex SHA-256 of the stored bytes; LayoutCAS needs it.
+	Hash string
+	// Time is when it was uploaded; LayoutDate needs it.
+	Time time.Time
+	Rand io.Reader
+}
+
+// Info is what a key says about its object. Fields its layout doesn't record
+// are left zero; AspectRatio is empty for "other".
+type Info struct {
+	Layout      Layout
+	Audio       bool
+	AspectRatio string
+	UserID      uuid.UUID
+	Date        time.Time
+	Hash        string
+	Ext         string
+}
+
+// Strategy is one key layout:
+type Strategy interface {
+	Key(obj Object) (string, error)
+	// Parse reports ok false for keys not in its layout.
+	Parse(key string) (info Info, ok bool)
+}
+
+// strategies are the layouts, in the order Parse tries them:
+var strategies = []struct {
+	layout   Layout
+	strategy Strategy
+}{
+	{LayoutCAS, casStrategy{}},
+	{LayoutUser, userStrategy{}},
+	{LayoutDate, dateStrategy{}},
+	{LayoutPrefix, prefixStrategy{}},
+}
+
+var ErrUnknownKey = errors.New("key isn't in any known layout")
+
+// Builder builds keys in the layout the deployment is configured with:
+type Builder struct {
+	Layout   Layout
+	strategy Strategy
+}
+
+// New returns the Builder for a STORAGE_KEY_LAYOUT value:
+func New(layout string) (Builder, error) {
+	for _, s := range strategies {
+		if string(s.layout) == layout {
+			return Builder{Layout: s.layout, strategy: s.strategy}, nil
+		}
+	}
+	return Builder{}, fmt.Errorf("unknown key layout %q", layout)
+}
+
+func (b Builder) Key(obj Object) (string, error) {
+	return b.strategy.Key(obj)
+}
+
+// ContentAddressed reports whether equal bytes get equal keys, so one object
+// may be shared by several videos:
+func (b Builder) ContentAddressed() bool {
+	return b.Layout == LayoutCAS
+}
+
+// Parse reads a key back, in whichever layout it was built: keys outlive a
+// change of STORAGE_KEY_LAYOUT.
+func Parse(key string) (Info, error) {
+	for _, s := range strategies {
+		if info, ok := s.strategy.Parse(key); ok {
+			info.Layout = s.layout
+			return info, nil
+		}
+	}
+	return Info{}, fmt.Errorf("%w: %q", ErrUnknownKey, key)
+}
+
+// randomName is 32 random bytes, URL-safe base64, plus ext. At 256 bits, two
+// names colliding isn't a concern.
+func randomName(r io.Reader, ext string) (string, error) {
+	if r == nil {
+		r = rand.Reader
+	}
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", fmt.Errorf("couldn't generate a random name: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b) + ext, nil
+}
+
+// randomNamePattern matches a randomName, capturing the extension:
+var randomNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}(\.[A-Za-z0-9]+)$`)
+
+// parseName returns the extension of a randomName:
+func parseName(name string) (string, bool) {
+	m := randomNamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// aspectDirectories are the prefix layout's directories, by aspect ratio:
+var aspectDirectories = map[string]string{
+	"16:9": "landscape",
+	"9:16": "portrait",
+	"4:3":  "standard",
+	"1:1":  "square",
+}
+
+const (
+	audioDirectory = "audio"
+	otherDirectory = "other"
+)
+
+type prefixStrategy struct{}
+
+func (prefixStrategy) Key(obj Object) (string, error) {
+	name, err := randomName(obj.Rand, obj.Ext)
+	if err != nil {
+		return "", err
+	}
+	directory := audioDirectory
+	if !obj.Audio {
+		directory = otherDirectory
+		if d, ok := aspectDirectories[obj.AspectRatio]; ok {
+			directory = d
+		}
+	}
+	return path.Join(directory, name), nil
+}
+
+func (prefixStrategy) Parse(key string) (Info, bool) {
+	directory, name, ok := strings.Cut(key, "/")
+	if !ok {
+		return Info{}, false
+	}
+	ext, ok := parseName(name)
+	if !ok {
+		return Info{}, false
+	}
+	info := Info{Ext: ext}
+	switch directory {
+	case audioDirectory:
+		info.Audio = true
+		return info, true
+	case otherDirectory:
+		return info, true
+	}
+	for ratio, d := range aspectDirectories {
+		if d == directory {
+			info.AspectRatio = ratio
+			return info, true
+		}
+	}
+	return Info{}, false
+}
+
+type userStrategy struct{}
+
+func (userStrategy) Key(obj Object) (string, error) {
+	if obj.UserID == uuid.Nil {
+		return "", errors.New("the user layout needs the uploader's ID")
+	}
+	name, err := randomName(obj.Rand, obj.Ext)
+	if err != nil {
+		return "", err
+	}
+	return path.Join("users", obj.UserID.String(), name), nil
+}
+
+func (userStrategy) Parse(key string) (Info, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 || parts[0] != "users" {
+		return Info{}, false
+	}
+	userID, err := uuid.Parse(parts[1])
+	if err != nil {
+		return Info{}, false
+	}
+	ext, ok := parseName(parts[2])
+	if !ok {
+		return Info{}, false
+	}
+	return Info{UserID: userID, Ext: ext}, true
+}
+
+type dateStrategy struct{}
+
+func (dateStrategy) Key(obj Object) (string, error) {
+	if obj.Time.IsZero() {
+		return "", errors.New("the date layout needs the upload time")
+	}
+	name, err := randomName(obj.Rand, obj.Ext)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(obj.Time.UTC().Format("2006/01/02"), name), nil
+}
+
+func (dateStrategy) Parse(key string) (Info, bool) {
+	directory, name := path.Split(key)
+	date, err := time.Parse("2006/01/02/", directory)
+	if err != nil {
+		return Info{}, false
+	}
+	ext, ok := parseName(name)
+	if !ok {
+		return Info{}, false
+	}
+	return Info{Date: date, Ext: ext}, true
+}
+
+type casStrategy struct{}
+
+// hashPattern matches a hex SHA-256:
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func (casStrategy) Key(obj Object) (string, error) {
+	if !hashPattern.MatchString(obj.Hash) {
+		return "", fmt.Errorf("the cas layout needs the hex SHA-256 of the object, got %q", obj.Hash)
+	}
+	return fmt.Sprintf("sha256/%s/%s/%s%s", obj.Hash[0:2], obj.Hash[2:4], obj.Hash, obj.Ext), nil
+}
+
+func (casStrategy) Parse(key string) (Info, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 || parts[0] != "sha256" {
+		return Info{}, false
+	}
+	hash, ext, _ := strings.Cut(parts[3], ".")
+	if !hashPattern.MatchString(hash) || parts[1] != hash[0:2] || parts[2] != hash[2:4] {
+		return Info{}, false
+	}
+	if ext != "" {
+		ext = "." + ext
+	}
+	return Info{Hash: hash, Ext: ext}, true
+}
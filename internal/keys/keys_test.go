@@ -0,0 +1,156 @@
+package keys
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const testHash = "abcd1234abcd1234abcd1234abcd1234abcd1234abcd1234abcd1234abcd1234"
+
+func TestRoundTrip(t *testing.T) {
+	userID := uuid.MustParse("6f1c2d3e-4b5a-4c7d-8e9f-0a1b2c3d4e5f")
+	uploaded := time.Date(2026, 10, 17, 23, 30, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+
+	tests := []struct {
+		name       string
+		layout     Layout
+		obj        Object
+		wantPrefix string
+		want       Info
+	}{
+		{"landscape", LayoutPrefix, Object{AspectRatio: "16:9", Ext: ".mp4"}, "landscape/", Info{Layout: LayoutPrefix, AspectRatio: "16:9", Ext: ".mp4"}},
+		{"portrait", LayoutPrefix, Object{AspectRatio: "9:16", Ext: ".mp4"}, "portrait/", Info{Layout: LayoutPrefix, AspectRatio: "9:16", Ext: ".mp4"}},
+		{"other ratio", LayoutPrefix, Object{AspectRatio: "other", Ext: ".mp4"}, "other/", Info{Layout: LayoutPrefix, Ext: ".mp4"}},
+		{"audio ignores ratio", LayoutPrefix, Object{Audio: true, AspectRatio: "16:9", Ext: ".mp3"}, "audio/", Info{Layout: LayoutPrefix, Audio: true, Ext: ".mp3"}},
+		{"user", LayoutUser, Object{UserID: userID, Ext: ".mp4"}, "users/" + userID.String() + "/", Info{Layout: LayoutUser, UserID: userID, Ext: ".mp4"}},
+		{"date is UTC", LayoutDate, Object{Time: uploaded, Ext: ".m4a"}, "2026/10/17/", Info{Layout: LayoutDate, Date: time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), Ext: ".m4a"}},
+		{"cas", LayoutCAS, Object{Hash: testHash, Ext: ".mp4"}, "sha256/ab/cd/" + testHash, Info{Layout: LayoutCAS, Hash: testHash, Ext: ".mp4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := New(string(tt.layout))
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := b.Key(tt.obj)
+			if err != nil {
+				t.Fatalf("Key() error: %v", err)
+			}
+			if !strings.HasPrefix(key, tt.wantPrefix) || !strings.HasSuffix(key, tt.obj.Ext) {
+				t.Errorf("Key() = %q, want %q...%q", key, tt.wantPrefix, tt.obj.Ext)
+			}
+			got, err := Parse(key)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", key, err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeysDontCollide(t *testing.T) {
+	userID := uuid.New()
+	for _, layout := range []Layout{LayoutPrefix, LayoutUser, LayoutDate} {
+		b, err := New(string(layout))
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := Object{UserID: userID, AspectRatio: "16:9", Ext: ".mp4", Time: time.Now()}
+		seen := map[string]bool{}
+		for i := 0; i < 10000; i++ {
+			key, err := b.Key(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seen[key] {
+				t.Fatalf("%s layout produced %q twice", layout, key)
+			}
+			seen[key] = true
+		}
+	}
+}
+
+func TestRandomPartComesFromRand(t *testing.T) {
+	b, _ := New(string(LayoutPrefix))
+	obj := Object{AspectRatio: "16:9", Ext: ".mp4"}
+	obj.Rand = bytes.NewReader(make([]byte, 32))
+	first, err := b.Key(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.Rand = bytes.NewReader(make([]byte, 32))
+	second, _ := b.Key(obj)
+	if first != second {
+		t.Errorf("same random bytes gave %q and %q", first, second)
+	}
+
+	obj.Rand = bytes.NewReader(make([]byte, 8))
+	if _, err := b.Key(obj); err == nil {
+		t.Error("Key() with a short Rand succeeded")
+	}
+}
+
+func TestCASSharesKeysByContent(t *testing.T) {
+	b, _ := New(string(LayoutCAS))
+	if !b.ContentAddressed() {
+		t.Error("cas layout isn't ContentAddressed")
+	}
+	first, _ := b.Key(Object{Hash: testHash, Ext: ".mp4"})
+	second, _ := b.Key(Object{Hash: testHash, Ext: ".mp4", UserID: uuid.New()})
+	if first != second {
+		t.Errorf("same content got keys %q and %q", first, second)
+	}
+	other, _ := b.Key(Object{Hash: strings.Repeat("0", 64), Ext: ".mp4"})
+	if other == first {
+		t.Errorf("different content got the same key %q", first)
+	}
+}
+
+func TestKeyNeedsLayoutInputs(t *testing.T) {
+	tests := []struct {
+		layout Layout
+		obj    Object
+	}{
+		{LayoutUser, Object{Ext: ".mp4"}},
+		{LayoutDate, Object{Ext: ".mp4"}},
+		{LayoutCAS, Object{Ext: ".mp4"}},
+		{LayoutCAS, Object{Hash: "ABCD", Ext: ".mp4"}},
+	}
+	for _, tt := range tests {
+		b, _ := New(string(tt.layout))
+		if key, err := b.Key(tt.obj); err == nil {
+			t.Errorf("%s layout built %q from %+v", tt.layout, key, tt.obj)
+		}
+	}
+}
+
+func TestParseRejectsForeignKeys(t *testing.T) {
+	for _, key := range []string{
+		"",
+		"landscape",
+		"landscape/short.mp4",
+		"videos/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA.mp4",
+		"users/not-a-uuid/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA.mp4",
+		"2026/13/40/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA.mp4",
+		"sha256/00/cd/" + testHash + ".mp4",
+		"uploads/" + uuid.NewString() + "/raw.mp4",
+	} {
+		if info, err := Parse(key); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Parse(%q) = %+v, %v, want ErrUnknownKey", key, info, err)
+		}
+	}
+}
+
+func TestNewRejectsUnknownLayout(t *testing.T) {
+	if _, err := New("flat"); err == nil {
+		t.Error(`New("flat") succeeded`)
+	}
+}
@@ -0,0 +1,33 @@
+// Package moderation screens uploaded media before it's listed publicly.
+package moderation
+
+import "context"
+
+// Result is a moderator's verdict on one piece of media.
+type Result struct {
+	Flagged bool
+	// Labels names what was found, e.g. "Explicit Nudity" or "Violence":
+	Labels []string
+}
+
+// Moderator checks media for content an admin should look at before it's shown
+// to everyone.
+type Moderator interface {
+	// ModerateImage checks an encoded JPEG or PNG, such as a thumbnail.
+	ModerateImage(ctx context.Context, image []byte) (Result, error)
+	// ModerateVideo checks a processed video by its storage key. It may take
+	// minutes, so callers should run it in the background.
+	ModerateVideo(ctx context.Context, key string) (Result, error)
+}
+
+// NoOp approves everything. It's the default, for development and for
+// deployments that don't moderate.
+type NoOp struct{}
+
+func (NoOp) ModerateImage(ctx context.Context, image []byte) (Result, error) {
+	return Result{}, nil
+}
+
+func (NoOp) ModerateVideo(ctx context.Context, key string) (Result, error) {
+	return Result{}, nil
+}
@@ -0,0 +1,194 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// maxImageBytes is the largest image DetectModerationLabels accepts inline:
+const maxImageBytes = 5 << 20
+
+// Rekognition moderates with Amazon Rekognition's moderation labels. Videos are
+// read by Rekognition straight from the bucket, so it only works with the S3
+// storage backend.
+//
+// It speaks Rekognition's JSON protocol directly with a SigV4-signed client,
+// which keeps the whole service SDK out of the build.
+type Rekognition struct {
+	// Config supplies credentials and the region:
+	Config aws.Config
+	// Bucket holds the processed videos passed to ModerateVideo:
+	Bucket string
+	// MinConfidence (0-100) is the lowest label confidence that flags media:
+	MinConfidence float64
+	// PollInterval is how often ModerateVideo checks on the video analysis.
+	// Defaults to 10s.
+	PollInterval time.Duration
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+type rekognitionS3Object struct {
+	Bucket string
+	Name   string
+}
+
+type moderationLabel struct {
+	Name       string
+	ParentName string
+	Confidence float64
+}
+
+func (r *Rekognition) ModerateImage(ctx context.Context, image []byte) (Result, error) {
+	if len(image) > maxImageBytes {
+		return Result{}, fmt.Errorf("image is %d bytes, Rekognition accepts at most %d", len(image), maxImageBytes)
+	}
+	var out struct {
+		ModerationLabels []moderationLabel
+	}
+	// []byte fields marshal as base64, which is what the API expects:
+	err := r.call(ctx, "DetectModerationLabels", map[string]any{
+		"Image":         map[string]any{"Bytes": image},
+		"MinConfidence": r.MinConfidence,
+	}, &out)
+	if err != nil {
+		return Result{}, err
+	}
+	return resultFor(out.ModerationLabels), nil
+}
+
+func (r *Rekognition) ModerateVideo(ctx context.Context, key string) (Result, error) {
+	var started struct {
+		JobId string
+	}
+	err := r.call(ctx, "StartContentModeration", map[string]any{
+		"Video":         map[string]any{"S3Object": rekognitionS3Object{Bucket: r.Bucket, Name: key}},
+		"MinConfidence": r.MinConfidence,
+	}, &started)
+	if err != nil {
+		return Result{}, err
+	}
+
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Wait for the analysis to finish, then page through its labels:
+	var labels []moderationLabel
+	nextToken := ""
+	for {
+		var out struct {
+			JobStatus        string
+			StatusMessage    string
+			NextToken        string
+			ModerationLabels []struct {
+				ModerationLabel moderationLabel
+			}
+		}
+		params := map[string]any{"JobId": started.JobId, "MaxResults": 1000}
+		if nextToken != "" {
+			params["NextToken"] = nextToken
+		}
+		if err := r.call(ctx, "GetContentModeration", params, &out); err != nil {
+			return Result{}, err
+		}
+
+		switch out.JobStatus {
+		case "SUCCEEDED":
+			for _, l := range out.ModerationLabels {
+				labels = append(labels, l.ModerationLabel)
+			}
+			if out.NextToken != "" {
+				nextToken = out.NextToken
+				continue
+			}
+			return resultFor(labels), nil
+		case "FAILED":
+			return Result{}, fmt.Errorf("content moderation job %s failed: %s", started.JobId, out.StatusMessage)
+		}
+
+		select {
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// resultFor flags media with any label at or above MinConfidence (Rekognition
+// already filtered the rest out) and lists each label name once:
+func resultFor(labels []moderationLabel) Result {
+	var result Result
+	seen := map[string]bool{}
+	for _, l := range labels {
+		if l.Name == "" || seen[l.Name] {
+			continue
+		}
+		seen[l.Name] = true
+		result.Labels = append(result.Labels, l.Name)
+	}
+	result.Flagged = len(result.Labels) > 0
+	return result
+}
+
+// call POSTs one Rekognition API action and decodes its response into out:
+func (r *Rekognition) call(ctx context.Context, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("https://rekognition.%s.amazonaws.com/", r.Config.Region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "RekognitionService."+action)
+
+	creds, err := r.Config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't get AWS credentials: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	err = v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "rekognition", r.Config.Region, time.Now())
+	if err != nil {
+		return err
+	}
+
+	client := r.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &apiErr)
+		return fmt.Errorf("rekognition %s: %s: %s %s", action, resp.Status, apiErr.Type, apiErr.Message)
+	}
+	return json.Unmarshal(data, out)
+}
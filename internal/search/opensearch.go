@@ -0,0 +1,249 @@
+// Package search keeps video metadata in an OpenSearch (or Elasticsearch)
+// index, for catalogs too big for SQLite's full-text search. Only what search
+// needs is indexed: the text, and who may see each video; results are IDs, to
+// be loaded from the database.
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Document is one video in the index.
+type Document struct {
+	ID               uuid.UUID `json:"-"`
+	UserID           uuid.UUID `json:"user_id"`
+	Title            string    `json:"title"`
+	Description      string    `json:"description"`
+	Visibility       string    `json:"visibility"`
+	ModerationStatus string    `json:"moderation_status"`
+	CreatedAt        time.Time `json:"created_at"`
+	Tags             []string  `json:"tags"`
+}
+
+// Query is a search, with the same rules as database.SearchVideosParams: the
+// viewer sees their own videos whatever their state, and everyone else's only
+// with PublicModeration and PublicVisibility.
+type Query struct {
+	// Text may be empty when there's a Tag, to list the tag's videos newest first.
+	Text     string
+	Tag      string
+	OwnerID  uuid.UUID
+	ViewerID uuid.UUID
+	// PublicModeration and PublicVisibility are the moderation status and
+	// visibility a video needs to be found by anyone:
+	PublicModeration string
+	PublicVisibility string
+	Limit            int
+	Offset           int
+}
+
+// OpenSearch talks to an index over the REST API, which Elasticsearch shares.
+type OpenSearch struct {
+	// URL is the cluster's, e.g. https://search.example.com:9200.
+	URL   string
+	Index string
+	// Username and Password are for basic auth, if the cluster wants it:
+	Username string
+	Password string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// indexMapping types the fields: the text is analyzed, the rest matched exactly.
+var indexMapping = map[string]any{
+	"properties": map[string]any{
+		"user_id":           map[string]any{"type": "keyword"},
+		"title":             map[string]any{"type": "text"},
+		"description":       map[string]any{"type": "text"},
+		"visibility":        map[string]any{"type": "keyword"},
+		"moderation_status": map[string]any{"type": "keyword"},
+		"created_at":        map[string]any{"type": "date"},
+		"tags":              map[string]any{"type": "keyword"},
+	},
+}
+
+// EnsureIndex creates the index, unless it's already there, in which case
+// fields added to the mapping since are added to it.
+func (o *OpenSearch) EnsureIndex(ctx context.Context) error {
+	status, body, err := o.do(ctx, http.MethodPut, o.indexPath(""), map[string]any{"mappings": indexMapping})
+	if err != nil {
+		return err
+	}
+	if status == http.StatusBadRequest && bytes.Contains(body, []byte("resource_already_exists_exception")) {
+		status, body, err = o.do(ctx, http.MethodPut, o.indexPath("_mapping"), indexMapping)
+		if err != nil {
+			return err
+		}
+		return o.check("update mapping", status, body)
+	}
+	return o.check("create index", status, body)
+}
+
+// Put adds doc to the index, or replaces it.
+func (o *OpenSearch) Put(ctx context.Context, doc Document) error {
+	status, body, err := o.do(ctx, http.MethodPut, o.indexPath("_doc/"+doc.ID.String()), doc)
+	if err != nil {
+		return err
+	}
+	return o.check("index", status, body)
+}
+
+// Delete removes the video from the index; one that isn't there is no error.
+func (o *OpenSearch) Delete(ctx context.Context, id uuid.UUID) error {
+	status, body, err := o.do(ctx, http.MethodDelete, o.indexPath("_doc/"+id.String()), nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return o.check("delete", status, body)
+}
+
+// Search returns the IDs of the videos matching q, best first. Like the SQLite
+// search, every word must match, the last one as a prefix, and title matches
+// weigh ten times as much as description ones.
+func (o *OpenSearch) Search(ctx context.Context, q Query) ([]uuid.UUID, error) {
+	// Anyone's approved public videos, or the viewer's own:
+	visible := []any{
+		map[string]any{"bool": map[string]any{"filter": []any{
+			term("moderation_status", q.PublicModeration),
+			term("visibility", q.PublicVisibility),
+		}}},
+	}
+	if q.ViewerID != uuid.Nil {
+		visible = append(visible, term("user_id", q.ViewerID.String()))
+	}
+	filter := []any{
+		map[string]any{"bool": map[string]any{"should": visible, "minimum_should_match": 1}},
+	}
+	if q.OwnerID != uuid.Nil {
+		filter = append(filter, term("user_id", q.OwnerID.String()))
+	}
+	if q.Tag != "" {
+		filter = append(filter, term("tags", q.Tag))
+	}
+	must := map[string]any{"match_all": map[string]any{}}
+	if strings.TrimSpace(q.Text) != "" {
+		must = map[string]any{"multi_match": map[string]any{
+			"query":    q.Text,
+			"type":     "bool_prefix",
+			"operator": "and",
+			"fields":   []string{"title^10", "description"},
+		}}
+	}
+	request := map[string]any{
+		"from":    q.Offset,
+		"size":    q.Limit,
+		"_source": false,
+		"query": map[string]any{"bool": map[string]any{
+			"must":   must,
+			"filter": filter,
+		}},
+		"sort": []any{"_score", map[string]any{"created_at": "desc"}},
+	}
+
+	status, body, err := o.do(ctx, http.MethodPost, o.indexPath("_search"), request)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.check("search", status, body); err != nil {
+		return nil, err
+	}
+	var out struct {
+		Hits struct {
+			Hits []struct {
+				ID string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("opensearch search: %v", err)
+	}
+	ids := make([]uuid.UUID, 0, len(out.Hits.Hits))
+	for _, hit := range out.Hits.Hits {
+		id, err := uuid.Parse(hit.ID)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func term(field, value string) map[string]any {
+	return map[string]any{"term": map[string]any{field: value}}
+}
+
+func (o *OpenSearch) indexPath(path string) string {
+	p := "/" + url.PathEscape(o.Index)
+	if path != "" {
+		p += "/" + path
+	}
+	return p
+}
+
+// do sends a request with body, if any, as JSON, and returns the response.
+func (o *OpenSearch) do(ctx context.Context, method, path string, body any) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(o.URL, "/")+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if o.Username != "" {
+		req.SetBasicAuth(o.Username, o.Password)
+	}
+
+	client := o.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, data, nil
+}
+
+// check turns an unsuccessful response into an error, with OpenSearch's reason
+// for it when there is one.
+func (o *OpenSearch) check(op string, status int, body []byte) error {
+	if status/100 == 2 {
+		return nil
+	}
+	var out struct {
+		Error struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &out) == nil && out.Error.Reason != "" {
+		return fmt.Errorf("opensearch %s: %d %s: %s", op, status, out.Error.Type, out.Error.Reason)
+	}
+	return fmt.Errorf("opensearch %s: %s", op, http.StatusText(status))
+}
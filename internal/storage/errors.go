@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/smithy-go"
+)
+
+// Failures of the bucket itself, as opposed to one object, so the API can tell
+// clients and operators what's wrong instead of a blanket 500. Store errors wrap
+// one of these along with the original error.
+var (
+	// ErrBucketNotFound: the configured bucket doesn't exist.
+	ErrBucketNotFound = errors.New("bucket not found")
+	// ErrAccessDenied: the credentials aren't allowed to do this.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrThrottled: the store is shedding load, even after retries.
+	ErrThrottled = errors.New("request rate too high")
+	// ErrTooLarge: the object is over the store's size limit.
+	ErrTooLarge = errors.New("object too large")
+	// ErrTimeout: the store gave up waiting for the request body.
+	ErrTimeout = errors.New("request timed out")
+)
+
+// s3Error wraps the matching error above around an S3 API error:
+func s3Error(err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	var kind error
+	switch apiErr.ErrorCode() {
+	case "NoSuchBucket":
+		kind = ErrBucketNotFound
+	case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "AllAccessDisabled":
+		kind = ErrAccessDenied
+	case "SlowDown", "ServiceUnavailable":
+		kind = ErrThrottled
+	case "EntityTooLarge":
+		kind = ErrTooLarge
+	case "RequestTimeout":
+		kind = ErrTimeout
+	default:
+		return err
+	}
+	return fmt.Errorf("%w: %w", kind, err)
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// streamPartSize is the part size of streamed uploads. Only one part is held in
+// memory at a time; 8 MiB parts allow objects up to 80 GB in S3's 10,000 parts.
+const streamPartSize = 8 << 20
+
+// partBuffers keeps streamed uploads from allocating a part buffer each:
+var partBuffers = sync.Pool{New: func() any {
+	buf := make([]byte, streamPartSize)
+	return &buf
+}}
+
+// StreamPutter is implemented by stores that can take a body of unknown length
+// without buffering it all first, e.g. a request body on its way in.
+type StreamPutter interface {
+	// PutStream stores body under key like Put and returns its size.
+	PutStream(ctx context.Context, key string, body io.Reader, opts PutOptions) (int64, error)
+}
+
+// Copier is implemented by stores that can copy an object without it passing
+// through the API server.
+type Copier interface {
+	// Copy copies srcKey to dstKey, with opts replacing the source's headers and
+	// tags.
+	Copy(ctx context.Context, srcKey, dstKey string, opts PutOptions) error
+}
+
+// PutStream sends body as a multipart upload, one streamPartSize part at a time.
+// A failed upload is aborted, so its parts don't linger (and cost) in the bucket.
+func (s *S3Store) PutStream(ctx context.Context, key string, body io.Reader, opts PutOptions) (int64, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if len(opts.Tags) > 0 {
+		input.Tagging = aws.String(encodeTags(opts.Tags))
+	}
+	s.Encryption.applyToMultipartUpload(input)
+	if opts.Retain {
+		s.Retention.applyToMultipartUpload(input)
+	}
+	upload, err := s.Client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return 0, s3Error(err)
+	}
+
+	size, err := s.uploadParts(ctx, key, upload.UploadId, body)
+	if err != nil {
+		_, abortErr := s.Client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.Bucket),
+			Key:      aws.String(key),
+			UploadId: upload.UploadId,
+		})
+		if abortErr != nil {
+			log.Printf("Couldn't abort multipart upload of %s: %v", key, abortErr)
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+func (s *S3Store) uploadParts(ctx context.Context, key string, uploadID *string, body io.Reader) (int64, error) {
+	var (
+		parts []types.CompletedPart
+		size  int64
+	)
+	pooled := partBuffers.Get().(*[]byte)
+	defer partBuffers.Put(pooled)
+	buf := *pooled
+	for partNumber := int32(1); ; partNumber++ {
+		n, err := io.ReadFull(body, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		// An empty body is still one (empty) part; otherwise EOF ends the upload:
+		if n == 0 && len(parts) > 0 {
+			break
+		}
+		out, err := s.Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(s.Bucket),
+			Key:        aws.String(key),
+			UploadId:   uploadID,
+			PartNumber: aws.Int32(partNumber),
+			Body:       bytes.NewReader(buf[:n]),
+		})
+		if err != nil {
+			return 0, s3Error(err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(partNumber)})
+		size += int64(n)
+		if n < len(buf) {
+			break
+		}
+	}
+
+	_, err := s.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.Bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return 0, s3Error(err)
+	}
+	return size, nil
+}
+
+// Copy is a single CopyObject, which handles objects up to 5 GB.
+func (s *S3Store) Copy(ctx context.Context, srcKey, dstKey string, opts PutOptions) error {
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(s.Bucket),
+		Key:               aws.String(dstKey),
+		CopySource:        aws.String(s.Bucket + "/" + url.PathEscape(srcKey)),
+		MetadataDirective: types.MetadataDirectiveReplace,
+		TaggingDirective:  types.TaggingDirectiveReplace,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if len(opts.Tags) > 0 {
+		input.Tagging = aws.String(encodeTags(opts.Tags))
+	}
+	s.Encryption.applyToCopyObject(input)
+	if opts.Retain {
+		s.Retention.applyToCopyObject(input)
+	}
+	if _, err := s.Client.CopyObject(ctx, input); err != nil {
+		return fmt.Errorf("couldn't copy %s to %s: %w", srcKey, dstKey, s3Error(err))
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// presignClient presigns as of s.ClockSkew ago. The skew is added to the
+// expiry as well, so the URL still runs out ttl from now.
+func (s *S3Store) presignClient() *s3.PresignClient {
+	return s3.NewPresignClient(s.Client, func(o *s3.PresignOptions) {
+		o.Presigner = backdatedPresigner{v4.NewSigner(), s.ClockSkew}
+	})
+}
+
+// backdatedPresigner signs as of skew before the time it's given:
+type backdatedPresigner struct {
+	signer *v4.Signer
+	skew   time.Duration
+}
+
+func (p backdatedPresigner) PresignHTTP(ctx context.Context, credentials aws.Credentials, r *http.Request, payloadHash, service, region string, signingTime time.Time, optFns ...func(*v4.SignerOptions)) (string, http.Header, error) {
+	return p.signer.PresignHTTP(ctx, credentials, r, payloadHash, service, region, signingTime.Add(-p.skew), optFns...)
+}
+
+// Presigner is implemented by stores clients can upload to directly, bypassing
+// the API server.
+type Presigner interface {
+	// PresignPut returns a URL that accepts one PUT of an object with the given
+	// Content-Type under key, valid for ttl.
+	PresignPut(ctx context.Context, key, contentType string, ttl time.Duration) (string, error)
+}
+
+func (s *S3Store) PresignPut(ctx context.Context, key, contentType string, ttl time.Duration) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	}
+	// The signature covers the encryption headers, so the client has to send the
+	// same ones:
+	s.Encryption.applyToPutObject(input)
+	req, err := s.presignClient().PresignPutObject(ctx, input, s3.WithPresignExpires(ttl+s.ClockSkew))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// PostPolicy is a signed S3 POST policy: an HTML form posting Fields, then the
+// file as the last field named "file", to URL uploads one object.
+type PostPolicy struct {
+	URL    string
+	Fields map[string]string
+}
+
+// PostPresigner is implemented by stores browsers can upload to with a plain
+// HTML form.
+type PostPresigner interface {
+	// PresignPost returns a policy that accepts one POST of an object under key,
+	// with the given Content-Type and at most maxSize bytes, valid for ttl.
+	PresignPost(ctx context.Context, key, contentType string, maxSize int64, ttl time.Duration) (PostPolicy, error)
+}
+
+func (s *S3Store) PresignPost(ctx context.Context, key, contentType string, maxSize int64, ttl time.Duration) (PostPolicy, error) {
+	// The policy only signs conditions; the fields they check have to be sent
+	// in the form as well, so both are built from the same values:
+	fields := map[string]string{"Content-Type": contentType}
+	for name, value := range s.Encryption.postFields() {
+		fields[name] = value
+	}
+	conditions := []interface{}{
+		[]interface{}{"content-length-range", 1, maxSize},
+	}
+	for name, value := range fields {
+		conditions = append(conditions, map[string]string{name: value})
+	}
+
+	req, err := s3.NewPresignClient(s.Client).PresignPostObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}, func(o *s3.PresignPostOptions) {
+		// POST policies can't be backdated, only given the skew at the end:
+		o.Expires = ttl + s.ClockSkew
+		o.Conditions = conditions
+	})
+	if err != nil {
+		return PostPolicy{}, err
+	}
+	for name, value := range req.Values {
+		fields[name] = value
+	}
+	return PostPolicy{URL: req.URL, Fields: fields}, nil
+}
+
+// GetPresigner is implemented by stores that can hand out temporary read URLs
+// for private objects, e.g. to let ffmpeg range-read a video straight from the
+// bucket.
+type GetPresigner interface {
+	// PresignGet returns a URL that allows GETs of key, valid for ttl.
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+func (s *S3Store) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	req, err := s.presignClient().PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(ttl+s.ClockSkew))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
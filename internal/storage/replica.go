@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// CopyFrom copies key from sourceBucket into this store's bucket under the same
+// key. S3 copies the bytes server-side, also across regions when s's client is
+// for the destination region. A single CopyObject handles objects up to 5 GB.
+func (s *S3Store) CopyFrom(ctx context.Context, sourceBucket, key string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(s.Bucket),
+		Key:        aws.String(key),
+		CopySource: aws.String(sourceBucket + "/" + url.PathEscape(key)),
+	}
+	s.Encryption.applyToCopyObject(input)
+	_, err := s.Client.CopyObject(ctx, input)
+	return err
+}
+
+// Ping checks that the bucket is reachable with the store's credentials:
+func (s *S3Store) Ping(ctx context.Context) error {
+	_, err := s.Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.Bucket)})
+	return err
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Object Lock modes, as set by S3_OBJECT_LOCK_MODE:
+const (
+	LockModeNone       = "none"
+	LockModeGovernance = "governance"
+	LockModeCompliance = "compliance"
+)
+
+// Retention is the Object Lock retention put on the objects we keep for good
+// (PutOptions.Retain), for deployments with legal retention needs. The bucket
+// has to have been created with Object Lock, which also turns on versioning.
+// In compliance mode nobody, the root user included, can delete or overwrite
+// a retained object version until the retention runs out; in governance mode
+// users with s3:BypassGovernanceRetention can. A delete without a version
+// only hides the object behind a delete marker.
+//
+// Object Lock needs a checksum on every write, which the SDK sends by default.
+type Retention struct {
+	Mode string `json:"mode"`
+	Days int    `json:"days,omitempty"`
+}
+
+func ParseRetention(mode string, days int) (Retention, error) {
+	switch mode {
+	case "", LockModeNone:
+		return Retention{Mode: LockModeNone}, nil
+	case LockModeGovernance, LockModeCompliance:
+		if days <= 0 {
+			return Retention{}, fmt.Errorf("S3_OBJECT_LOCK_DAYS must be at least 1 with S3_OBJECT_LOCK_MODE=%q", mode)
+		}
+		return Retention{Mode: mode, Days: days}, nil
+	}
+	return Retention{}, fmt.Errorf("unknown S3_OBJECT_LOCK_MODE %q, expected %q, %q or %q", mode, LockModeNone, LockModeGovernance, LockModeCompliance)
+}
+
+// Enabled reports whether objects are put under retention at all:
+func (r Retention) Enabled() bool {
+	return r.Mode == LockModeGovernance || r.Mode == LockModeCompliance
+}
+
+func (r Retention) s3Mode() types.ObjectLockMode {
+	switch r.Mode {
+	case LockModeGovernance:
+		return types.ObjectLockModeGovernance
+	case LockModeCompliance:
+		return types.ObjectLockModeCompliance
+	}
+	return ""
+}
+
+// until is when an object written now is retained until:
+func (r Retention) until() *time.Time {
+	return aws.Time(time.Now().UTC().AddDate(0, 0, r.Days))
+}
+
+// applyToPutObject sets the retention on a single-part upload:
+func (r Retention) applyToPutObject(input *s3.PutObjectInput) {
+	if !r.Enabled() {
+		return
+	}
+	input.ObjectLockMode = r.s3Mode()
+	input.ObjectLockRetainUntilDate = r.until()
+}
+
+// applyToMultipartUpload sets it on a multipart upload; like the encryption
+// settings, only the create call carries it:
+func (r Retention) applyToMultipartUpload(input *s3.CreateMultipartUploadInput) {
+	if !r.Enabled() {
+		return
+	}
+	input.ObjectLockMode = r.s3Mode()
+	input.ObjectLockRetainUntilDate = r.until()
+}
+
+// applyToCopyObject sets it on a copy, which doesn't inherit the source's:
+func (r Retention) applyToCopyObject(input *s3.CopyObjectInput) {
+	if !r.Enabled() {
+		return
+	}
+	input.ObjectLockMode = r.s3Mode()
+	input.ObjectLockRetainUntilDate = r.until()
+}
+
+// Locker is implemented by stores that can tell whether an object is under
+// Object Lock retention, so deletes can be turned away up front rather than
+// leaving the object in place behind a delete marker.
+type Locker interface {
+	// RetainedUntil is when the object's retention runs out. It's zero when the
+	// object has none, or no longer has any, and when there's no such object.
+	RetainedUntil(ctx context.Context, key string) (time.Time, error)
+}
+
+func (s *S3Store) RetainedUntil(ctx context.Context, key string) (time.Time, error) {
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, s3Error(err)
+	}
+	until := aws.ToTime(out.ObjectLockRetainUntilDate)
+	if until.Before(time.Now()) {
+		return time.Time{}, nil
+	}
+	return until, nil
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// maxDeleteBatch is the DeleteObjects limit per call:
+const maxDeleteBatch = 1000
+
+type S3Store struct {
+	Client     *s3.Client
+	Bucket     string
+	Region     string
+	Encryption Encryption
+	// Retention is put on the objects written with PutOptions.Retain:
+	Retention Retention
+	// Endpoint is set for an S3-compatible service other than AWS, such as
+	// LocalStack or MinIO; see NewS3Client.
+	Endpoint string
+	// ClockSkew dates presigned URLs that much earlier, so S3 takes them even
+	// when its clock is behind ours; see presign.go.
+	ClockSkew time.Duration
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if len(opts.Tags) > 0 {
+		input.Tagging = aws.String(encodeTags(opts.Tags))
+	}
+	s.Encryption.applyToPutObject(input)
+	if opts.Retain {
+		s.Retention.applyToPutObject(input)
+	}
+	_, err := s.Client.PutObject(ctx, input)
+	return s3Error(err)
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, s3Error(err)
+	}
+	return out.Body, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, keys ...string) error {
+	for len(keys) > 0 {
+		n := min(len(keys), maxDeleteBatch)
+		objects := make([]types.ObjectIdentifier, 0, n)
+		for _, key := range keys[:n] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+		out, err := s.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.Bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return s3Error(err)
+		}
+		// DeleteObjects reports per-key failures in the body, not as an error:
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return fmt.Errorf("couldn't delete %s: %s", aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	// ListObjectsV2 returns at most 1000 keys per call, the paginator follows the
+	// continuation tokens for us:
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(s.Bucket)}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	paginator := s3.NewListObjectsV2Paginator(s.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return s3Error(err)
+		}
+		for _, obj := range page.Contents {
+			err := fn(ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// URL builds the bucket's own URL for key, in the format
+// https://<bucket-name>.s3.<region>.amazonaws.com/<key>, or <endpoint>/<bucket-name>/<key>
+// with an Endpoint. Playback URLs come from the CDN in front of the bucket
+// instead, see internal/cdn.
+func (s *S3Store) URL(key string) string {
+	if s.Endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(s.Endpoint, "/"), s.Bucket, key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, s.Region, key)
+}
+
+// NewS3Client makes a client for AWS or, with an endpoint, for the
+// S3-compatible service there. Those are addressed path-style, since they
+// rarely have a DNS name per bucket.
+func NewS3Client(cfg aws.Config, endpoint string) *s3.Client {
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+}
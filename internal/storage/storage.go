@@ -0,0 +1,55 @@
+// Package storage abstracts where processed media lives. Production uses S3
+// (optionally fronted by CloudFront); dev mode swaps in a local directory so the
+// whole upload pipeline runs without AWS credentials.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned by Get when the key doesn't exist.
+var ErrNotFound = errors.New("object not found")
+
+type Store interface {
+	// Put stores body under key, replacing any existing object.
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error
+	// Get opens the object for reading. The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the objects. Missing keys are not an error.
+	Delete(ctx context.Context, keys ...string) error
+	// List calls fn for every object whose key starts with prefix.
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+	// URL returns the public playback URL for key.
+	URL(key string) string
+}
+
+// FileStore is implemented by stores that keep objects as local files:
+type FileStore interface {
+	FilePath(key string) (string, error)
+}
+
+type PutOptions struct {
+	ContentType string
+	// ContentDisposition is sent back with the object, e.g. to name downloads.
+	// Stores that can't keep headers ignore it.
+	ContentDisposition string
+	// CacheControl is sent back with the object too, for CDNs and browsers.
+	// Stores that can't keep headers ignore it.
+	CacheControl string
+	// Tags label the object for cost allocation and lifecycle rules. Stores
+	// without tags ignore them.
+	Tags map[string]string
+	// Retain puts the object under the store's Object Lock retention, if it has
+	// one (see Retention): stored media is, scratch files and staging aren't.
+	// Stores without Object Lock ignore it.
+	Retain bool
+}
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Tagger is implemented by stores that can label objects after the fact, e.g.
+// to tag objects stored before tagging existed. Put sets PutOptions.Tags on new
+// objects.
+type Tagger interface {
+	// SetTags adds tags to the object, replacing any with the same keys and
+	// keeping the rest (such as the cold-tier tag).
+	SetTags(ctx context.Context, key string, tags map[string]string) error
+}
+
+// encodeTags formats tags as the x-amz-tagging header, a URL query string:
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+func (s *S3Store) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	// PutObjectTagging replaces the whole tag set, so merge with what's there:
+	current, err := s.Client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return s.objectError(err)
+	}
+	merged := make([]types.Tag, 0, len(current.TagSet)+len(tags))
+	for _, tag := range current.TagSet {
+		if _, replaced := tags[aws.ToString(tag.Key)]; !replaced {
+			merged = append(merged, tag)
+		}
+	}
+	for k, v := range tags {
+		merged = append(merged, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	_, err = s.Client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(s.Bucket),
+		Key:     aws.String(key),
+		Tagging: &types.Tagging{TagSet: merged},
+	})
+	return err
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// Tiering is implemented by stores that can move cold objects to cheaper storage
+// classes. Objects are marked with a tag and a bucket lifecycle rule does the
+// actual transitions, so marking is cheap and reversible until the rule runs.
+type Tiering interface {
+	// SetCold adds or removes the cold-tier tag on the object.
+	SetCold(ctx context.Context, key string, cold bool) error
+	// ArchiveState reports whether the object can be read right now.
+	ArchiveState(ctx context.Context, key string) (ArchiveState, error)
+	// Restore starts bringing an archived object back for the given number of days.
+	Restore(ctx context.Context, key string, days int32) error
+	// EnsureLifecycleRule installs (or updates) the rule that transitions tagged
+	// objects, leaving any other rules on the bucket alone.
+	EnsureLifecycleRule(ctx context.Context, policy LifecyclePolicy) error
+}
+
+type ArchiveState string
+
+const (
+	ArchiveStateAvailable ArchiveState = "available"
+	ArchiveStateArchived  ArchiveState = "archived"
+	ArchiveStateRestoring ArchiveState = "restoring"
+)
+
+// LifecyclePolicy says how long a tagged object stays in each tier, counted from
+// the object's creation as S3 lifecycle rules are. Zero days skips a tier.
+type LifecyclePolicy struct {
+	InfrequentAccessDays int32 `json:"infrequent_access_days"`
+	GlacierDays          int32 `json:"glacier_days"`
+}
+
+// The tag that marks cold objects, and the ID of the lifecycle rule matching it:
+const (
+	coldTagKey          = "tubely-tier"
+	coldTagValue        = "cold"
+	coldLifecycleRuleID = "tubely-cold-tier"
+)
+
+func (s *S3Store) SetCold(ctx context.Context, key string, cold bool) error {
+	// PutObjectTagging replaces the whole tag set, so merge with what's there:
+	current, err := s.Client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	tags := make([]types.Tag, 0, len(current.TagSet)+1)
+	for _, tag := range current.TagSet {
+		if aws.ToString(tag.Key) != coldTagKey {
+			tags = append(tags, tag)
+		}
+	}
+	if cold {
+		tags = append(tags, types.Tag{Key: aws.String(coldTagKey), Value: aws.String(coldTagValue)})
+	}
+	_, err = s.Client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(s.Bucket),
+		Key:     aws.String(key),
+		Tagging: &types.Tagging{TagSet: tags},
+	})
+	return err
+}
+
+func (s *S3Store) ArchiveState(ctx context.Context, key string) (ArchiveState, error) {
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	switch out.StorageClass {
+	case types.StorageClassGlacier, types.StorageClassDeepArchive:
+	default:
+		return ArchiveStateAvailable, nil
+	}
+	// x-amz-restore is absent until a restore is requested, then reads
+	// ongoing-request="true" until the temporary copy is ready:
+	restore := aws.ToString(out.Restore)
+	switch {
+	case restore == "":
+		return ArchiveStateArchived, nil
+	case strings.Contains(restore, `ongoing-request="true"`):
+		return ArchiveStateRestoring, nil
+	}
+	return ArchiveStateAvailable, nil
+}
+
+func (s *S3Store) Restore(ctx context.Context, key string, days int32) error {
+	_, err := s.Client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		RestoreRequest: &types.RestoreRequest{
+			Days:                 aws.Int32(days),
+			GlacierJobParameters: &types.GlacierJobParameters{Tier: types.TierStandard},
+		},
+	})
+	// A second request while one is running isn't a failure for us:
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	return err
+}
+
+func (s *S3Store) EnsureLifecycleRule(ctx context.Context, policy LifecyclePolicy) error {
+	var transitions []types.Transition
+	if policy.InfrequentAccessDays > 0 {
+		transitions = append(transitions, types.Transition{
+			Days:         aws.Int32(policy.InfrequentAccessDays),
+			StorageClass: types.TransitionStorageClassStandardIa,
+		})
+	}
+	if policy.GlacierDays > 0 {
+		transitions = append(transitions, types.Transition{
+			Days:         aws.Int32(policy.GlacierDays),
+			StorageClass: types.TransitionStorageClassGlacier,
+		})
+	}
+	if len(transitions) == 0 {
+		return fmt.Errorf("lifecycle policy has no transitions")
+	}
+	rule := types.LifecycleRule{
+		ID:     aws.String(coldLifecycleRuleID),
+		Status: types.ExpirationStatusEnabled,
+		Filter: &types.LifecycleRuleFilter{
+			Tag: &types.Tag{Key: aws.String(coldTagKey), Value: aws.String(coldTagValue)},
+		},
+		Transitions: transitions,
+	}
+
+	// The put replaces the bucket's whole configuration, so keep the other rules:
+	rules := []types.LifecycleRule{rule}
+	current, err := s.Client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(s.Bucket),
+	})
+	var apiErr smithy.APIError
+	switch {
+	case err == nil:
+		for _, r := range current.Rules {
+			if aws.ToString(r.ID) != coldLifecycleRuleID {
+				rules = append(rules, r)
+			}
+		}
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration":
+	default:
+		return err
+	}
+
+	_, err = s.Client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(s.Bucket),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: rules},
+	})
+	return err
+}
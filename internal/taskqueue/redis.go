@@ -0,0 +1,340 @@
+package taskqueue
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// redisGroup is the consumer group every worker reads the stream through:
+	redisGroup = "workers"
+	// redisBlock is how long XREADGROUP waits for a new entry:
+	redisBlock = 20 * time.Second
+	// redisMaxIdleConns caps the connections kept around between calls:
+	redisMaxIdleConns = 4
+)
+
+// Redis is a Broker on a Redis stream read through a consumer group. An entry a
+// worker received but never acked stays pending; once it's been idle for
+// VisibilityTimeout, the next Receive anywhere claims it, which is what SQS's
+// visibility timeout does for free.
+//
+// It talks RESP over plain TCP (or TLS for rediss://); it only needs a handful
+// of commands.
+type Redis struct {
+	addr     string
+	useTLS   bool
+	username string
+	password string
+	db       int
+	stream   string
+	consumer string
+
+	mu         sync.Mutex
+	idle       []*redisConn
+	groupReady bool
+}
+
+// NewRedis connects lazily; a bad address shows up on the first call.
+func NewRedis(rawURL, stream, consumer string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("redis URL must start with redis:// or rediss://, got %q", rawURL)
+	}
+	r := &Redis{
+		addr:     u.Host,
+		useTLS:   u.Scheme == "rediss",
+		stream:   stream,
+		consumer: consumer,
+	}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.username = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		r.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	return r, nil
+}
+
+func (r *Redis) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = r.do(ctx, "XADD", r.stream, "*", "body", string(body))
+	return err
+}
+
+func (r *Redis) Receive(ctx context.Context) (*Delivery, error) {
+	if err := r.ensureGroup(ctx); err != nil {
+		return nil, err
+	}
+
+	// Entries a dead worker left pending come first:
+	reply, err := r.do(ctx, "XAUTOCLAIM", r.stream, redisGroup, r.consumer,
+		strconv.FormatInt(VisibilityTimeout.Milliseconds(), 10), "0-0", "COUNT", "1")
+	if err != nil {
+		return nil, err
+	}
+	if claimed, ok := reply.([]any); ok && len(claimed) >= 2 {
+		if entries, _ := claimed[1].([]any); len(entries) > 0 {
+			return r.delivery(ctx, entries[0])
+		}
+	}
+
+	reply, err = r.do(ctx, "XREADGROUP", "GROUP", redisGroup, r.consumer,
+		"COUNT", "1", "BLOCK", strconv.FormatInt(redisBlock.Milliseconds(), 10),
+		"STREAMS", r.stream, ">")
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	// [[stream, [[id, [field, value, ...]]]]]
+	streams, _ := reply.([]any)
+	if len(streams) == 0 {
+		return nil, nil
+	}
+	stream, _ := streams[0].([]any)
+	if len(stream) < 2 {
+		return nil, fmt.Errorf("unexpected XREADGROUP reply %v", reply)
+	}
+	entries, _ := stream[1].([]any)
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return r.delivery(ctx, entries[0])
+}
+
+// delivery turns a stream entry, [id, [field, value, ...]], into a Delivery:
+func (r *Redis) delivery(ctx context.Context, entry any) (*Delivery, error) {
+	fields, _ := entry.([]any)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("unexpected stream entry %v", entry)
+	}
+	id, _ := fields[0].(string)
+	d := &Delivery{
+		ack: func(ctx context.Context) error {
+			if _, err := r.do(ctx, "XACK", r.stream, redisGroup, id); err != nil {
+				return err
+			}
+			_, err := r.do(ctx, "XDEL", r.stream, id)
+			return err
+		},
+		extend: func(ctx context.Context) error {
+			// Claiming our own entry resets its idle time:
+			_, err := r.do(ctx, "XCLAIM", r.stream, redisGroup, r.consumer, "0", id, "JUSTID")
+			return err
+		},
+	}
+
+	values, _ := fields[1].([]any)
+	var body string
+	for i := 0; i+1 < len(values); i += 2 {
+		if values[i] == "body" {
+			body, _ = values[i+1].(string)
+		}
+	}
+	if err := json.Unmarshal([]byte(body), &d.Message); err != nil {
+		// It'll never parse, so don't let it come back:
+		d.Ack(ctx)
+		return nil, fmt.Errorf("malformed message %s: %w", id, err)
+	}
+	return d, nil
+}
+
+// ensureGroup creates the stream and its consumer group, once it has worked:
+func (r *Redis) ensureGroup(ctx context.Context) error {
+	r.mu.Lock()
+	ready := r.groupReady
+	r.mu.Unlock()
+	if ready {
+		return nil
+	}
+	_, err := r.do(ctx, "XGROUP", "CREATE", r.stream, redisGroup, "0", "MKSTREAM")
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	r.mu.Lock()
+	r.groupReady = true
+	r.mu.Unlock()
+	return nil
+}
+
+// do runs one command on a pooled connection. A connection that fails is
+// dropped; an error reply from Redis is returned as an error but leaves the
+// connection usable.
+func (r *Redis) do(ctx context.Context, args ...string) (any, error) {
+	conn, err := r.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(ctx, args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.Close()
+		return nil, err
+	}
+	r.put(conn)
+	return reply, err
+}
+
+func (r *Redis) get(ctx context.Context) (*redisConn, error) {
+	r.mu.Lock()
+	if n := len(r.idle); n > 0 {
+		conn := r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		r.mu.Unlock()
+		return conn, nil
+	}
+	r.mu.Unlock()
+	return r.dial(ctx)
+}
+
+func (r *Redis) put(conn *redisConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.idle) >= redisMaxIdleConns {
+		conn.Close()
+		return
+	}
+	r.idle = append(r.idle, conn)
+}
+
+func (r *Redis) dial(ctx context.Context) (*redisConn, error) {
+	var d net.Dialer
+	var nc net.Conn
+	var err error
+	if r.useTLS {
+		host, _, _ := net.SplitHostPort(r.addr)
+		nc, err = (&tls.Dialer{NetDialer: &d, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", r.addr)
+	} else {
+		nc, err = d.DialContext(ctx, "tcp", r.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, rd: bufio.NewReader(nc)}
+
+	if r.password != "" {
+		args := []string{"AUTH", r.password}
+		if r.username != "" {
+			args = []string{"AUTH", r.username, r.password}
+		}
+		if _, err := conn.do(ctx, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err := conn.do(ctx, "SELECT", strconv.Itoa(r.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// redisError is an error reply, such as "BUSYGROUP Consumer Group name already exists":
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+type redisConn struct {
+	net.Conn
+	rd *bufio.Reader
+}
+
+// do writes a command and reads its reply. Bulk and simple strings come back as
+// string, integers as int64, arrays as []any and nil replies as nil.
+func (c *redisConn) do(ctx context.Context, args ...string) (any, error) {
+	// Blocking commands need longer than their block time:
+	deadline := time.Now().Add(redisBlock + 10*time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.SetDeadline(deadline)
+	// Moving the deadline up is what interrupts a blocked read:
+	stop := context.AfterFunc(ctx, func() { c.SetDeadline(time.Now()) })
+	defer stop()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	reply, err := c.read()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return reply, err
+}
+
+func (c *redisConn) read() (any, error) {
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				// An error nested in an array (a failed XAUTOCLAIM entry, say) is
+				// still a whole reply; anything else leaves the stream unreadable.
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected redis reply %q", line)
+}
@@ -0,0 +1,145 @@
+package taskqueue
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// sqsWaitSeconds is the long-poll time of ReceiveMessage; 20 is SQS's maximum:
+const sqsWaitSeconds = 20
+
+// SQS is a Broker on an Amazon SQS standard queue. SQS delivers at least once,
+// so a task can occasionally run twice; the results are the same either way.
+//
+// Like the Rekognition moderator, it speaks the service's JSON protocol directly
+// with a SigV4-signed client rather than pulling in the SQS SDK.
+type SQS struct {
+	// Config supplies credentials and the region:
+	Config aws.Config
+	// QueueURL is the queue's URL; requests go to its host, so it can point at a
+	// local SQS emulator too.
+	QueueURL string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+func (q *SQS) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return q.call(ctx, "SendMessage", map[string]any{
+		"QueueUrl":    q.QueueURL,
+		"MessageBody": string(body),
+	}, &struct{}{})
+}
+
+func (q *SQS) Receive(ctx context.Context) (*Delivery, error) {
+	var out struct {
+		Messages []struct {
+			MessageId     string
+			ReceiptHandle string
+			Body          string
+		}
+	}
+	err := q.call(ctx, "ReceiveMessage", map[string]any{
+		"QueueUrl":            q.QueueURL,
+		"MaxNumberOfMessages": 1,
+		"WaitTimeSeconds":     sqsWaitSeconds,
+		"VisibilityTimeout":   int(VisibilityTimeout.Seconds()),
+	}, &out)
+	if err != nil || len(out.Messages) == 0 {
+		return nil, err
+	}
+
+	m := out.Messages[0]
+	receipt := m.ReceiptHandle
+	d := &Delivery{
+		ack: func(ctx context.Context) error {
+			return q.call(ctx, "DeleteMessage", map[string]any{
+				"QueueUrl":      q.QueueURL,
+				"ReceiptHandle": receipt,
+			}, &struct{}{})
+		},
+		extend: func(ctx context.Context) error {
+			return q.call(ctx, "ChangeMessageVisibility", map[string]any{
+				"QueueUrl":          q.QueueURL,
+				"ReceiptHandle":     receipt,
+				"VisibilityTimeout": int(VisibilityTimeout.Seconds()),
+			}, &struct{}{})
+		},
+	}
+	if err := json.Unmarshal([]byte(m.Body), &d.Message); err != nil {
+		// It'll never parse, so don't let it come back:
+		d.Ack(ctx)
+		return nil, fmt.Errorf("malformed message %s: %w", m.MessageId, err)
+	}
+	return d, nil
+}
+
+// call POSTs one SQS API action and decodes its response into out:
+func (q *SQS) call(ctx context.Context, action string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	queueURL, err := url.Parse(q.QueueURL)
+	if err != nil {
+		return fmt.Errorf("invalid SQS queue URL: %w", err)
+	}
+	endpoint := queueURL.Scheme + "://" + queueURL.Host + "/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+
+	creds, err := q.Config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't get AWS credentials: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	err = v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "sqs", q.Config.Region, time.Now())
+	if err != nil {
+		return err
+	}
+
+	client := q.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &apiErr)
+		return fmt.Errorf("sqs %s: %s: %s %s", action, resp.Status, apiErr.Type, apiErr.Message)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,135 @@
+// Package taskqueue carries transcode tasks from the API server to separate
+// worker processes (cmd/tubely-worker) through SQS or Redis, so the CPU-heavy
+// ffmpeg work scales independently of the API.
+//
+// The files themselves travel through storage, under a staging prefix: the API
+// server uploads the raw file, queues a Message naming it, and polls for the
+// Result object the worker writes once the processed file is stored.
+package taskqueue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+// VisibilityTimeout is how long a received message stays hidden from other
+// workers. KeepAlive extends it while a long transcode runs; if the worker dies,
+// the message is handed to another one once it runs out.
+const VisibilityTimeout = 5 * time.Minute
+
+// Message is one queued transcode. The worker downloads InputKey (and LogoKey,
+// for a watermark), runs Task, uploads the processed file to OutputKey and then
+// writes a Result to ResultKey.
+type Message struct {
+	ID        uuid.UUID      `json:"id"`
+	VideoID   uuid.UUID      `json:"video_id"`
+	Task      transcode.Task `json:"task"`
+	InputKey  string         `json:"input_key"`
+	LogoKey   string         `json:"logo_key,omitempty"`
+	OutputKey string         `json:"output_key"`
+	ResultKey string         `json:"result_key"`
+}
+
+// Result is what the worker reports back. Error is empty when OutputKey holds
+// the processed file, of OutputSize bytes.
+type Result struct {
+	Error      string    `json:"error,omitempty"`
+	OutputSize int64     `json:"output_size,omitempty"`
+	Worker     string    `json:"worker"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// StagingKeys lays out a task's objects under prefix:
+func StagingKeys(prefix string, id uuid.UUID) Message {
+	dir := prefix + id.String() + "/"
+	return Message{
+		ID:        id,
+		InputKey:  dir + "input",
+		LogoKey:   dir + "logo",
+		OutputKey: dir + "output",
+		ResultKey: dir + "result.json",
+	}
+}
+
+type Broker interface {
+	// Send queues a message for any worker.
+	Send(ctx context.Context, msg Message) error
+	// Receive waits a while (a long poll, not until ctx is done) for a message.
+	// It returns nil and no error when none arrived in time.
+	Receive(ctx context.Context) (*Delivery, error)
+}
+
+// Delivery is a received message. It's redelivered to another worker unless it
+// is acked within VisibilityTimeout (see KeepAlive).
+type Delivery struct {
+	Message Message
+	ack     func(ctx context.Context) error
+	extend  func(ctx context.Context) error
+}
+
+// Ack removes the message from the queue for good:
+func (d *Delivery) Ack(ctx context.Context) error {
+	return d.ack(ctx)
+}
+
+// KeepAlive extends the message's visibility timeout until the returned stop
+// function is called, so a transcode can take longer than VisibilityTimeout.
+func (d *Delivery) KeepAlive(ctx context.Context) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(VisibilityTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.extend(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("Couldn't extend visibility of task %s: %v", d.Message.ID, err)
+				}
+			}
+		}
+	}()
+	return cancel
+}
+
+// Config picks and configures a Broker. Backend is "sqs" or "redis".
+type Config struct {
+	Backend string
+	// SQS: the queue's URL, and credentials and region for signing requests:
+	SQSQueueURL string
+	AWS         aws.Config
+	// Redis: redis://[user:password@]host:port[/db], or rediss:// for TLS:
+	RedisURL string
+	// RedisStream is the stream tasks are added to. Defaults to "tubely:transcode".
+	RedisStream string
+	// Consumer names this process to the broker; Redis tracks pending messages
+	// per consumer. Only workers need it.
+	Consumer string
+}
+
+func Open(cfg Config) (Broker, error) {
+	switch cfg.Backend {
+	case "sqs":
+		if cfg.SQSQueueURL == "" {
+			return nil, fmt.Errorf("SQS backend needs a queue URL")
+		}
+		return &SQS{Config: cfg.AWS, QueueURL: cfg.SQSQueueURL}, nil
+	case "redis":
+		if cfg.RedisURL == "" {
+			return nil, fmt.Errorf("redis backend needs a URL")
+		}
+		stream := cfg.RedisStream
+		if stream == "" {
+			stream = "tubely:transcode"
+		}
+		return NewRedis(cfg.RedisURL, stream, cfg.Consumer)
+	}
+	return nil, fmt.Errorf("unknown task queue backend %q, expected \"sqs\" or \"redis\"", cfg.Backend)
+}
@@ -0,0 +1,83 @@
+// Package telemetry sets up OpenTelemetry tracing and the request IDs that tie a
+// request's spans and log lines together.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/bootdotdev/learn-file-storage-s3-golang-starter"
+
+// Setup installs the global tracer provider. Tracing is on when an OTLP endpoint
+// is configured with the standard OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT variables; the exporter also reads the other
+// OTEL_EXPORTER_OTLP_* settings (headers, timeout, ...) and the SDK reads
+// OTEL_SERVICE_NAME and OTEL_TRACES_SAMPLER. Without an endpoint the spans cost
+// next to nothing and go nowhere.
+//
+// Call the returned shutdown function before exiting to flush buffered spans.
+func Setup(ctx context.Context, serviceName string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Later options win, so OTEL_SERVICE_NAME overrides serviceName:
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span from the global tracer provider:
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End records err (if any) on the span and ends it:
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Detach returns a context that carries parent's span and request ID but none of
+// its cancelation or deadline. Background work started by a request (a queued
+// job, say) runs under it, so its spans land in the request's trace without
+// being cut short when the request finishes.
+func Detach(parent, ctx context.Context) context.Context {
+	ctx = trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(parent))
+	if id := RequestID(parent); id != "" {
+		ctx = WithRequestID(ctx, id)
+	}
+	return ctx
+}
@@ -0,0 +1,159 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+)
+
+// Encoder is an H.264 encoder ffmpeg re-encodes video with. The hardware ones
+// are many times faster than libx264 on hosts that have the hardware, and fail
+// outright on those that don't, so one is only used once DetectEncoding has seen
+// it work.
+type Encoder string
+
+const (
+	EncoderSoftware     Encoder = "libx264"
+	EncoderNVENC        Encoder = "h264_nvenc"
+	EncoderVideoToolbox Encoder = "h264_videotoolbox"
+	EncoderVAAPI        Encoder = "h264_vaapi"
+)
+
+// Encoders maps the names VIDEO_ENCODER takes to encoders:
+var Encoders = map[string]Encoder{
+	"software":     EncoderSoftware,
+	"nvenc":        EncoderNVENC,
+	"videotoolbox": EncoderVideoToolbox,
+	"vaapi":        EncoderVAAPI,
+}
+
+// hardwareEncoders are tried in this order by DetectEncoding("auto"):
+var hardwareEncoders = []Encoder{EncoderNVENC, EncoderVideoToolbox, EncoderVAAPI}
+
+// Encoding is the encoder re-encodes use, with what it needs:
+type Encoding struct {
+	Encoder Encoder
+	// VAAPIDevice is the render node h264_vaapi encodes on:
+	VAAPIDevice string
+}
+
+var encoding atomic.Pointer[Encoding]
+
+// UseEncoding sets the encoding re-encodes use from then on; libx264 until it's
+// called.
+func UseEncoding(e Encoding) {
+	encoding.Store(&e)
+}
+
+func currentEncoding() Encoding {
+	if e := encoding.Load(); e != nil {
+		return *e
+	}
+	return Encoding{Encoder: EncoderSoftware}
+}
+
+// inputArgs go before the first -i:
+func (e Encoding) inputArgs() []string {
+	if e.Encoder == EncoderVAAPI {
+		return []string{"-vaapi_device", e.VAAPIDevice}
+	}
+	return nil
+}
+
+// uploadFilter ends the video filter chain for encoders that take their frames
+// in GPU memory, empty for the rest:
+func (e Encoding) uploadFilter() string {
+	if e.Encoder == EncoderVAAPI {
+		return "format=nv12,hwupload"
+	}
+	return ""
+}
+
+// codecArgs encode the video the way every browser plays it: H.264 in 8-bit
+// 4:2:0 (nv12 is that too, in the layout VAAPI wants).
+func (e Encoding) codecArgs() []string {
+	switch e.Encoder {
+	case EncoderNVENC:
+		return []string{"-c:v", "h264_nvenc", "-preset", "p4", "-pix_fmt", "yuv420p"}
+	case EncoderVideoToolbox:
+		// It has no CRF; a bitrate is what keeps it from starving high resolutions:
+		return []string{"-c:v", "h264_videotoolbox", "-b:v", "6M", "-pix_fmt", "yuv420p"}
+	case EncoderVAAPI:
+		return []string{"-c:v", "h264_vaapi"}
+	}
+	return []string{"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p"}
+}
+
+// encodeCheckTimeout bounds one DetectEncoding test encode; a hardware encoder
+// that hangs counts as not working.
+const encodeCheckTimeout = 15 * time.Second
+
+// DetectEncoding picks the encoding for preference: "software", "auto" for the
+// first hardware encoder that works here, or a name from Encoders. An encoder
+// works when it encodes a test pattern. libx264 is the fallback: with "auto"
+// when no hardware encoder works, and with a named one that doesn't, which is
+// when the error says why.
+func DetectEncoding(ctx context.Context, preference, vaapiDevice string) (Encoding, error) {
+	software := Encoding{Encoder: EncoderSoftware}
+	switch preference {
+	case "", "software":
+		return software, nil
+	case "auto":
+		for _, encoder := range hardwareEncoders {
+			e := Encoding{Encoder: encoder, VAAPIDevice: vaapiDevice}
+			if checkEncoding(ctx, e) == nil {
+				return e, nil
+			}
+		}
+		return software, nil
+	}
+	encoder, ok := Encoders[preference]
+	if !ok {
+		return software, fmt.Errorf("unknown encoder %q", preference)
+	}
+	e := Encoding{Encoder: encoder, VAAPIDevice: vaapiDevice}
+	if err := checkEncoding(ctx, e); err != nil {
+		return software, fmt.Errorf("%s doesn't work on this host: %w", encoder, err)
+	}
+	return e, nil
+}
+
+// checkEncoding encodes a fraction of a second of ffmpeg's test pattern with e:
+func checkEncoding(ctx context.Context, e Encoding) error {
+	ctx, cancel := context.WithTimeout(ctx, encodeCheckTimeout)
+	defer cancel()
+
+	args := []string{"-hide_banner", "-v", "error"}
+	args = append(args, e.inputArgs()...)
+	args = append(args, "-f", "lavfi", "-i", "testsrc2=size=320x240:rate=30:duration=0.2")
+	if filter := e.uploadFilter(); filter != "" {
+		args = append(args, "-vf", filter)
+	}
+	args = append(args, e.codecArgs()...)
+	args = append(args, "-f", "null", "-")
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stderr := bufpool.GetBuffer()
+	defer bufpool.PutBuffer(stderr)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return &FFmpegError{Stderr: stderr.String(), Err: err}
+	}
+	return nil
+}
+
+// encode runs the ffmpeg command build makes for the encoding in use. When a
+// hardware encoder fails, it runs it once more with libx264: they turn down
+// some inputs libx264 takes, like odd frame sizes or 10-bit on older GPUs.
+func encode(ctx context.Context, inputFilePath string, onProgress ProgressFunc, build func(Encoding) []string) error {
+	e := currentEncoding()
+	err := RunFFmpeg(ctx, inputFilePath, onProgress, build(e)...)
+	if err == nil || e.Encoder == EncoderSoftware || ctx.Err() != nil {
+		return err
+	}
+	return RunFFmpeg(ctx, inputFilePath, onProgress, build(Encoding{Encoder: EncoderSoftware})...)
+}
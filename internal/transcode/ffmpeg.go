@@ -0,0 +1,126 @@
+// Package transcode runs the ffmpeg steps that turn a raw upload into the file
+// we store. It's shared by the API server, which runs them on its own job queue,
+// and cmd/tubely-worker, which runs them for tasks pulled off a remote queue.
+package transcode
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ProgressFunc receives percent complete (0-100) while ffmpeg runs:
+type ProgressFunc func(percent float64)
+
+// ProbeDuration asks ffprobe for the container duration. Progress is reported
+// relative to it.
+func ProbeDuration(ctx context.Context, filePath string) (_ time.Duration, err error) {
+	ctx, span := telemetry.Start(ctx, "ffprobe duration")
+	defer func() { telemetry.End(span, err) }()
+
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		filePath,
+	)
+	out := bufpool.GetBuffer()
+	defer bufpool.PutBuffer(out)
+	cmd.Stdout = out
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("ffprobe error: %v", err)
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(out.String()), 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse duration %q: %v", out, err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// RunFFmpeg runs ffmpeg with -progress on stdout and feeds the parsed position to
+// onProgress. The error is an *FFmpegError with ffmpeg's stderr, which is where
+// it explains what went wrong. Progress is skipped when inputFilePath's duration can't be probed
+// (or onProgress is nil); the command still runs.
+func RunFFmpeg(ctx context.Context, inputFilePath string, onProgress ProgressFunc, args ...string) (err error) {
+	// One span per ffmpeg run, so a trace shows which step an upload spent its time in:
+	ctx, span := telemetry.Start(ctx, "ffmpeg", trace.WithAttributes(
+		attribute.StringSlice("ffmpeg.args", args),
+	))
+	defer func() { telemetry.End(span, err) }()
+
+	var total time.Duration
+	if onProgress != nil {
+		total, _ = ProbeDuration(ctx, inputFilePath)
+	}
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", append([]string{"-progress", "pipe:1", "-nostats"}, args...)...)
+	stderr := bufpool.GetBuffer()
+	defer bufpool.PutBuffer(stderr)
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	parseFFmpegProgress(stdout, func(position time.Duration) {
+		if total > 0 {
+			onProgress(100 * float64(position) / float64(total))
+		}
+	})
+	if err := cmd.Wait(); err != nil {
+		return &FFmpegError{Stderr: stderr.String(), Err: err}
+	}
+	return nil
+}
+
+// FFmpegError is a failed ffmpeg run, with what it wrote to stderr:
+type FFmpegError struct {
+	Stderr string
+	Err    error
+}
+
+func (e *FFmpegError) Error() string {
+	return fmt.Sprintf("%s, %v", e.Stderr, e.Err)
+}
+
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+// parseFFmpegProgress reads ffmpeg's -progress output, blocks of key=value lines
+// each ending in progress=continue or progress=end, and reports the output
+// position at the end of each block. out_time_us is preferred; out_time_ms is the
+// older name for the same microsecond value.
+func parseFFmpegProgress(r io.Reader, report func(position time.Duration)) {
+	scanner := bufio.NewScanner(r)
+	var position time.Duration
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us", "out_time_ms":
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				position = time.Duration(us) * time.Microsecond
+			}
+		case "progress":
+			report(position)
+		}
+	}
+	// Drain anything left so ffmpeg never blocks on a full pipe:
+	io.Copy(io.Discard, r)
+}
@@ -0,0 +1,248 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Kinds of Task:
+const (
+	KindFastStart = "faststart"
+	KindWatermark = "watermark"
+	KindPodcast   = "podcast"
+)
+
+// Task describes one processing step completely, so it can be queued for a
+// worker in another process as JSON.
+type Task struct {
+	Kind string `json:"kind"`
+	// Fast start and watermark: re-encode the video to H.264/yuv420p (with the
+	// encoder UseEncoding set), or the audio to AAC, instead of copying a stream
+	// browsers may not play:
+	ReencodeVideo bool `json:"reencode_video,omitempty"`
+	ReencodeAudio bool `json:"reencode_audio,omitempty"`
+	// Fast start and watermark: the display rotation the upload carries, in
+	// degrees, 0 for none. Players that ignore it show phone videos sideways,
+	// so it's baked into the pixels, which takes a re-encode:
+	Rotation int `json:"rotation,omitempty"`
+	// Watermark: Overlay is the ffmpeg overlay x:y expression, Opacity is in
+	// (0, 1]. LogoPath is a file on whichever machine runs the task, so it's
+	// filled in there rather than sent.
+	Overlay  string  `json:"overlay,omitempty"`
+	Opacity  float64 `json:"opacity,omitempty"`
+	LogoPath string  `json:"-"`
+	// Podcast: the audio codec and container the normalized audio is written in:
+	Codec  string `json:"codec,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+// Run performs the task on inputFilePath and returns the path of the processed
+// file, which is written next to the input, over whatever an earlier attempt
+// left there. The caller removes it when done.
+func Run(ctx context.Context, task Task, inputFilePath string, onProgress ProgressFunc) (string, error) {
+	switch task.Kind {
+	case KindFastStart:
+		return fastStart(ctx, task, inputFilePath, onProgress)
+	case KindWatermark:
+		return watermark(ctx, task, inputFilePath, onProgress)
+	case KindPodcast:
+		return podcast(ctx, task, inputFilePath, onProgress)
+	}
+	return "", fmt.Errorf("unknown transcode task %q", task.Kind)
+}
+
+// fastStart creates and returns a new path to a file with "fast start" encoding:
+func fastStart(ctx context.Context, task Task, inputFilePath string, onProgress ProgressFunc) (string, error) {
+	//Create a new string for the output file path. I just appended .processing to the input file
+	// (which should be the path to the temp file on disk):
+	processedFilePath := fmt.Sprintf("%s.processing", inputFilePath)
+	// Run ffmpeg. The arguments are -y, -i, the input file path, -c, copy, -movflags, faststart,
+	// -f, mp4 and the output file path
+	// (RunFFmpeg kills ffmpeg if the processing job is canceled, reports progress as it
+	// goes, and puts ffmpeg's stderr in the error)
+	// Streams the task marks for re-encoding override the copy:
+	reencode := task.ReencodeVideo || task.Rotation != 0
+	build := func(e Encoding) []string {
+		args := []string{"-y"}
+		if reencode {
+			args = append(args, e.inputArgs()...)
+		}
+		args = append(args, "-i", inputFilePath, "-movflags", "faststart", "-codec", "copy")
+		if reencode {
+			if filter := e.uploadFilter(); filter != "" {
+				args = append(args, "-vf", filter)
+			}
+			args = append(args, e.codecArgs()...)
+		}
+		args = append(args, rotationArgs(task)...)
+		args = append(args, audioCodecArgs(task)...)
+		return append(args, "-f", "mp4", processedFilePath)
+	}
+	var err error
+	if reencode {
+		err = encode(ctx, inputFilePath, onProgress, build)
+	} else {
+		err = RunFFmpeg(ctx, inputFilePath, onProgress, build(Encoding{})...)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error processing video: %w", err)
+	}
+	return checkOutput(processedFilePath)
+}
+
+// watermark overlays a PNG logo onto the video. Unlike the fast-start step this
+// has to re-encode the video stream, but the audio is copied through unless the
+// task asks for a re-encode. The output is also fast-start.
+func watermark(ctx context.Context, task Task, inputFilePath string, onProgress ProgressFunc) (string, error) {
+	if task.Overlay == "" {
+		return "", fmt.Errorf("watermark task has no overlay position")
+	}
+	if task.Opacity <= 0 || task.Opacity > 1 {
+		return "", fmt.Errorf("watermark opacity must be in (0, 1], got %v", task.Opacity)
+	}
+
+	processedFilePath := fmt.Sprintf("%s.processing", inputFilePath)
+	// scale the logo's alpha channel by the opacity, then lay it over the video:
+	overlay := fmt.Sprintf("[1:v]format=rgba,colorchannelmixer=aa=%.2f[logo];[0:v][logo]overlay=%s", task.Opacity, task.Overlay)
+	err := encode(ctx, inputFilePath, onProgress, func(e Encoding) []string {
+		filter := overlay
+		if upload := e.uploadFilter(); upload != "" {
+			filter += "," + upload
+		}
+		args := append([]string{"-y"}, e.inputArgs()...)
+		args = append(args,
+			"-i", inputFilePath,
+			"-i", task.LogoPath,
+			"-filter_complex", filter,
+		)
+		args = append(args, e.codecArgs()...)
+		args = append(args, rotationArgs(task)...)
+		args = append(args, audioCodecArgs(task)...)
+		return append(args, "-movflags", "faststart", "-f", "mp4", processedFilePath)
+	})
+	if err != nil {
+		return "", fmt.Errorf("error watermarking video: %w", err)
+	}
+	return checkOutput(processedFilePath)
+}
+
+// rotationArgs go with a re-encode of a rotated video. ffmpeg's decoder turns
+// the frames upright by itself (autorotate, on by default, so a watermark lands
+// the right way up too); what's left is to clear the rotation the output would
+// otherwise inherit, or players that honor it would turn the video again.
+// Newer builds drop it themselves, older ones copy the "rotate" tag.
+func rotationArgs(task Task) []string {
+	if task.Rotation == 0 {
+		return nil
+	}
+	return []string{"-metadata:s:v:0", "rotate=0"}
+}
+
+// audioCodecArgs copies the audio, or encodes it to AAC if the task asks for a
+// re-encode:
+func audioCodecArgs(task Task) []string {
+	if task.ReencodeAudio {
+		return []string{"-c:a", "aac", "-b:a", "160k"}
+	}
+	return []string{"-c:a", "copy"}
+}
+
+// podcastLoudness is the EBU R128 target for audio posts: -16 LUFS integrated,
+// the usual podcast level, with true peaks kept under -1.5 dBTP.
+const podcastLoudness = "loudnorm=I=-16:TP=-1.5:LRA=11"
+
+// podcast normalizes the loudness of an audio upload and drops any video stream
+// (cover art) so players get a plain audio file.
+func podcast(ctx context.Context, task Task, inputFilePath string, onProgress ProgressFunc) (string, error) {
+	if task.Codec == "" || task.Format == "" {
+		return "", fmt.Errorf("podcast task needs a codec and a format")
+	}
+
+	processedFilePath := fmt.Sprintf("%s.processing", inputFilePath)
+	args := []string{
+		"-y",
+		"-i", inputFilePath,
+		"-vn",
+		"-af", podcastLoudness,
+		"-c:a", task.Codec,
+	}
+	if task.Format == "mp4" {
+		args = append(args, "-movflags", "faststart")
+	}
+	args = append(args, "-f", task.Format, processedFilePath)
+
+	if err := RunFFmpeg(ctx, inputFilePath, onProgress, args...); err != nil {
+		return "", fmt.Errorf("error normalizing audio: %w", err)
+	}
+	return checkOutput(processedFilePath)
+}
+
+// checkOutput makes sure ffmpeg actually wrote something:
+func checkOutput(processedFilePath string) (string, error) {
+	fileInfo, err := os.Stat(processedFilePath)
+	if err != nil {
+		return "", fmt.Errorf("could not stat processed file: %v", err)
+	}
+	if fileInfo.Size() == 0 {
+		os.Remove(processedFilePath)
+		return "", fmt.Errorf("processed file is empty")
+	}
+	return processedFilePath, nil
+}
+
+// Clip writes the part of source from start to end to output, as a fast-start
+// MP4. source is a path or a URL; seeking before -i means only that part is read.
+// The video is re-encoded so the cut lands on the exact frame, not the keyframe
+// before it, and the audio goes to AAC to match.
+func Clip(ctx context.Context, source string, start, end time.Duration, output string) error {
+	err := encode(ctx, source, nil, func(e Encoding) []string {
+		args := append([]string{"-y"}, e.inputArgs()...)
+		args = append(args,
+			"-ss", strconv.FormatFloat(start.Seconds(), 'f', 3, 64),
+			"-to", strconv.FormatFloat(end.Seconds(), 'f', 3, 64),
+			"-i", source,
+		)
+		if filter := e.uploadFilter(); filter != "" {
+			args = append(args, "-vf", filter)
+		}
+		args = append(args, e.codecArgs()...)
+		// The decoder already turned the frames upright, see rotationArgs:
+		args = append(args, "-metadata:s:v:0", "rotate=0")
+		return append(args, "-c:a", "aac", "-b:a", "160k", "-movflags", "faststart", "-f", "mp4", output)
+	})
+	if err != nil {
+		return fmt.Errorf("error cutting clip: %w", err)
+	}
+	_, err = checkOutput(output)
+	return err
+}
+
+// ReplaceAudio writes source to output, as a fast-start MP4, with its audio
+// swapped for the first audio stream of audio, or dropped when audio is "". The
+// video stream is copied as it is. New audio is padded with silence, or cut,
+// to the length of the video.
+func ReplaceAudio(ctx context.Context, source, audio, output string) error {
+	args := []string{"-y", "-i", source}
+	if audio == "" {
+		args = append(args, "-map", "0:v:0", "-c:v", "copy", "-an")
+	} else {
+		args = append(args,
+			"-i", audio,
+			"-filter_complex", "[1:a:0]apad[a]",
+			"-map", "0:v:0", "-map", "[a]",
+			"-c:v", "copy",
+			"-c:a", "aac", "-b:a", "160k",
+			"-shortest",
+		)
+	}
+	args = append(args, "-movflags", "faststart", "-f", "mp4", output)
+
+	if err := RunFFmpeg(ctx, source, nil, args...); err != nil {
+		return fmt.Errorf("error replacing audio: %w", err)
+	}
+	_, err := checkOutput(output)
+	return err
+}
@@ -0,0 +1,167 @@
+// Package upload is the video upload pipeline, as a series of small steps with
+// a typed result each: validate the file's media type, stage it on disk,
+// probe it, process it, store the result and persist it on the video. Every
+// entry point (a multipart form, a resumable upload, a URL import, a clip, a
+// dead-letter re-drive, or a CLI or gRPC front end) hands a Pipeline the file
+// it got and gets the published video back, so they all behave the same. What
+// each step does is up to the Steps the pipeline runs; the API server's are in
+// package main.
+package upload
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Metadata is the title, description and visibility sent with a file, saving
+// the client an update after the upload. They're checked before any processing
+// and saved with the new file. Nil fields aren't changed.
+type Metadata struct {
+	Title       *string
+	Description *string
+	Visibility  *string
+}
+
+// ApplyTo sets the metadata's video fields; visibility is a column of its own,
+// set by whoever persists the video.
+func (m Metadata) ApplyTo(video *database.Video) {
+	if m.Title != nil {
+		video.Title = *m.Title
+	}
+	if m.Description != nil {
+		video.Description = *m.Description
+	}
+}
+
+// Source is a file as an entry point receives it.
+type Source struct {
+	Body io.Reader
+	// Filename is the client's name for the file, if it sent one; it's kept for
+	// downloads.
+	Filename    string
+	ContentType string
+	Metadata    Metadata
+}
+
+// Staged is a file on disk, waiting to be processed. Size, SHA256 and
+// AspectRatio are what was learned while it was written; they're zero when it
+// was staged some other way, say by a resumable upload.
+type Staged struct {
+	Path        string
+	MediaType   string
+	Filename    string
+	Metadata    Metadata
+	Size        int64
+	SHA256      string
+	AspectRatio string
+}
+
+// Probed is what the probe found out about a staged file.
+type Probed struct {
+	// AspectRatio is "" for audio.
+	AspectRatio string
+	Duration    time.Duration
+}
+
+// Processed is the file processing made of a staged one, to be stored.
+type Processed struct {
+	Path string
+}
+
+// Stored is the video once it points at its stored file, with that file's
+// hash.
+type Stored struct {
+	Video database.Video
+	Hash  string
+}
+
+// Steps are what a Pipeline runs. Each returns an error the entry point can
+// pass on to its client as it is.
+type Steps interface {
+	// Validate checks the media type src claims and returns the one to go by.
+	Validate(ctx context.Context, src Source) (mediaType string, err error)
+	// Stage writes src to a file on disk, learning what it can on the way. It
+	// leaves nothing behind when it fails.
+	Stage(ctx context.Context, src Source, mediaType string) (Staged, error)
+	// Begin readies the video for processing the staged file, and returns it
+	// with a function that's called with the outcome: the video as it ends up,
+	// and the error that stopped the pipeline, if any.
+	Begin(ctx context.Context, video database.Video, staged Staged) (database.Video, func(database.Video, error), error)
+	// Probe checks the staged file is one to process, and reads its shape.
+	Probe(ctx context.Context, video database.Video, staged Staged) (Probed, error)
+	// Process makes the file to store out of the staged one.
+	Process(ctx context.Context, video database.Video, staged Staged, probed Probed) (Processed, error)
+	// Store puts the processed file in storage and points the video at it.
+	Store(ctx context.Context, video database.Video, staged Staged, probed Probed, processed Processed) (Stored, error)
+	// Persist records what's left to know about the stored video, and returns
+	// it as the client should see it.
+	Persist(ctx context.Context, staged Staged, processed Processed, stored Stored) (database.Video, error)
+}
+
+// Pipeline runs files through Steps.
+type Pipeline struct {
+	steps Steps
+}
+
+// New returns a pipeline running steps.
+func New(steps Steps) *Pipeline {
+	return &Pipeline{steps: steps}
+}
+
+// Upload takes src through every step onto video, and removes the staged file
+// once done.
+func (p *Pipeline) Upload(ctx context.Context, video database.Video, src Source) (database.Video, error) {
+	staged, err := p.Stage(ctx, src)
+	if err != nil {
+		return database.Video{}, err
+	}
+	defer os.Remove(staged.Path)
+	return p.Run(ctx, video, staged)
+}
+
+// Stage validates src and writes it to disk, for entry points that have
+// something to do between taking a file in and processing it. The caller
+// removes the staged file.
+func (p *Pipeline) Stage(ctx context.Context, src Source) (Staged, error) {
+	mediaType, err := p.steps.Validate(ctx, src)
+	if err != nil {
+		return Staged{}, err
+	}
+	return p.steps.Stage(ctx, src, mediaType)
+}
+
+// Run takes a staged file from the probe on, and returns the video as
+// published. The staged file is left to the caller; the processed one is
+// removed.
+func (p *Pipeline) Run(ctx context.Context, video database.Video, staged Staged) (_ database.Video, err error) {
+	video, end, err := p.steps.Begin(ctx, video, staged)
+	if err != nil {
+		return database.Video{}, err
+	}
+	defer func() { end(video, err) }()
+
+	probed, err := p.steps.Probe(ctx, video, staged)
+	if err != nil {
+		return database.Video{}, err
+	}
+	processed, err := p.steps.Process(ctx, video, staged, probed)
+	if err != nil {
+		return database.Video{}, err
+	}
+	defer os.Remove(processed.Path)
+	stored, err := p.steps.Store(ctx, video, staged, probed, processed)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video = stored.Video
+	persisted, err := p.steps.Persist(ctx, staged, processed, stored)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video = persisted
+	return video, nil
+}
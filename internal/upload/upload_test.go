@@ -0,0 +1,112 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// fakeSteps records the steps it runs, and fails the one named in fail:
+type fakeSteps struct {
+	dir   string
+	fail  string
+	steps []string
+	ended error
+	// processed is the path Process returned:
+	processed string
+}
+
+func (f *fakeSteps) step(name string) error {
+	f.steps = append(f.steps, name)
+	if f.fail == name {
+		return errors.New(name + " failed")
+	}
+	return nil
+}
+
+func (f *fakeSteps) Validate(ctx context.Context, src Source) (string, error) {
+	return src.ContentType, f.step("validate")
+}
+
+func (f *fakeSteps) Stage(ctx context.Context, src Source, mediaType string) (Staged, error) {
+	if err := f.step("stage"); err != nil {
+		return Staged{}, err
+	}
+	path := filepath.Join(f.dir, "staged")
+	if err := os.WriteFile(path, []byte("video"), 0o600); err != nil {
+		return Staged{}, err
+	}
+	return Staged{Path: path, MediaType: mediaType, Filename: src.Filename}, nil
+}
+
+func (f *fakeSteps) Begin(ctx context.Context, video database.Video, staged Staged) (database.Video, func(database.Video, error), error) {
+	return video, func(_ database.Video, err error) { f.ended = err }, f.step("begin")
+}
+
+func (f *fakeSteps) Probe(ctx context.Context, video database.Video, staged Staged) (Probed, error) {
+	return Probed{AspectRatio: "16:9"}, f.step("probe")
+}
+
+func (f *fakeSteps) Process(ctx context.Context, video database.Video, staged Staged, probed Probed) (Processed, error) {
+	if err := f.step("process"); err != nil {
+		return Processed{}, err
+	}
+	f.processed = filepath.Join(f.dir, "processed")
+	return Processed{Path: f.processed}, os.WriteFile(f.processed, []byte("video"), 0o600)
+}
+
+func (f *fakeSteps) Store(ctx context.Context, video database.Video, staged Staged, probed Probed, processed Processed) (Stored, error) {
+	video.Title = probed.AspectRatio
+	return Stored{Video: video, Hash: "hash"}, f.step("store")
+}
+
+func (f *fakeSteps) Persist(ctx context.Context, staged Staged, processed Processed, stored Stored) (database.Video, error) {
+	return stored.Video, f.step("persist")
+}
+
+func TestPipelineRunsStepsInOrder(t *testing.T) {
+	steps := &fakeSteps{dir: t.TempDir()}
+	video := database.Video{ID: uuid.New()}
+
+	got, err := New(steps).Upload(context.Background(), video, Source{Body: strings.NewReader("video"), ContentType: "video/mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "validate stage begin probe process store persist"; strings.Join(steps.steps, " ") != want {
+		t.Errorf("steps = %v, want %s", steps.steps, want)
+	}
+	if got.ID != video.ID || got.Title != "16:9" {
+		t.Errorf("video = %+v, want the stored one", got)
+	}
+	// Upload removes the staged file, Run the processed one:
+	for _, path := range []string{filepath.Join(steps.dir, "staged"), steps.processed} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was left behind", path)
+		}
+	}
+}
+
+func TestPipelineStopsAtFailedStep(t *testing.T) {
+	steps := &fakeSteps{dir: t.TempDir(), fail: "store"}
+
+	_, err := New(steps).Upload(context.Background(), database.Video{ID: uuid.New()}, Source{ContentType: "video/mp4"})
+	if err == nil || err.Error() != "store failed" {
+		t.Fatalf("err = %v, want the store step's", err)
+	}
+	if want := "validate stage begin probe process store"; strings.Join(steps.steps, " ") != want {
+		t.Errorf("steps = %v, want %s", steps.steps, want)
+	}
+	// Begin's end is told how it went:
+	if steps.ended != err {
+		t.Errorf("end got %v, want %v", steps.ended, err)
+	}
+	if _, err := os.Stat(steps.processed); !os.IsNotExist(err) {
+		t.Error("the processed file was left behind")
+	}
+}
@@ -6,18 +6,116 @@ import (
 	"net/http"
 )
 
+// errorCode is a machine-readable error type, so clients can branch on what went
+// wrong without matching on message text.
+type errorCode string
+
+const (
+	codeInvalidRequest      errorCode = "INVALID_REQUEST"
+	codeValidationFailed    errorCode = "VALIDATION_FAILED"
+	codeUnauthenticated     errorCode = "UNAUTHENTICATED"
+	codeNotOwner            errorCode = "NOT_OWNER"
+	codeForbidden           errorCode = "FORBIDDEN"
+	codeNotFound            errorCode = "NOT_FOUND"
+	codeConflict            errorCode = "CONFLICT"
+	codeFeatureDisabled     errorCode = "FEATURE_DISABLED"
+	codeInvalidMIME         errorCode = "INVALID_MIME"
+	codeInvalidURL          errorCode = "INVALID_URL"
+	codeFileTooLarge        errorCode = "FILE_TOO_LARGE"
+	codeDurationLimit       errorCode = "DURATION_LIMIT_EXCEEDED"
+	codeUploadQuota         errorCode = "UPLOAD_QUOTA_EXCEEDED"
+	codeTransferQuota       errorCode = "TRANSFER_QUOTA_EXCEEDED"
+	codeObjectLocked        errorCode = "OBJECT_LOCKED"
+	codeProbeFailed         errorCode = "PROBE_FAILED"
+	codeCorruptMedia        errorCode = "CORRUPT_MEDIA"
+	codeUploadTooSlow       errorCode = "UPLOAD_TOO_SLOW"
+	codeChecksumMismatch    errorCode = "CHECKSUM_MISMATCH"
+	codeProcessingFailed    errorCode = "PROCESSING_FAILED"
+	codeStorageFailed       errorCode = "STORAGE_FAILED"
+	codeStorageNoBucket     errorCode = "STORAGE_BUCKET_NOT_FOUND"
+	codeStorageDenied       errorCode = "STORAGE_ACCESS_DENIED"
+	codeStorageThrottled    errorCode = "STORAGE_THROTTLED"
+	codeStorageTimeout      errorCode = "STORAGE_TIMEOUT"
+	codeInsufficientStorage errorCode = "INSUFFICIENT_STORAGE"
+	codeUpstreamFailed      errorCode = "UPSTREAM_FAILED"
+	codeUnavailable         errorCode = "UNAVAILABLE"
+	codeMaintenance         errorCode = "MAINTENANCE"
+	codeInternal            errorCode = "INTERNAL"
+)
+
+// codeForStatus is the code used when a handler only gives a status:
+func codeForStatus(status int) errorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return codeInvalidRequest
+	case http.StatusUnauthorized:
+		return codeUnauthenticated
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusConflict:
+		return codeConflict
+	case http.StatusRequestEntityTooLarge:
+		return codeFileTooLarge
+	case http.StatusInsufficientStorage:
+		return codeInsufficientStorage
+	case http.StatusBadGateway:
+		return codeUpstreamFailed
+	case http.StatusServiceUnavailable:
+		return codeUnavailable
+	}
+	return codeInternal
+}
+
+// fieldError points at one invalid request field:
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// errorResponse is the body of every error. Error repeats Message for clients
+// written against the original {"error": "..."} shape.
+type errorResponse struct {
+	Code        errorCode    `json:"code"`
+	Message     string       `json:"message"`
+	FieldErrors []fieldError `json:"field_errors,omitempty"`
+	Error       string       `json:"error"`
+	// RequestID is set on crashes, for quoting in bug reports:
+	RequestID string `json:"request_id,omitempty"`
+}
+
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
+	respondWithCode(w, code, codeForStatus(code), msg, err)
+}
+
+// respondWithCode is respondWithError with an explicit error code:
+func respondWithCode(w http.ResponseWriter, status int, code errorCode, msg string, err error) {
 	if err != nil {
 		log.Println(err)
 	}
-	if code > 499 {
+	if status > 499 {
 		log.Printf("Responding with 5XX error: %s", msg)
 	}
-	type errorResponse struct {
-		Error string `json:"error"`
+	respondWithJSON(w, status, errorResponse{
+		Code:    code,
+		Message: msg,
+		Error:   msg,
+	})
+}
+
+// respondWithFieldErrors rejects a request that failed validation, listing every
+// bad field rather than just the first:
+func respondWithFieldErrors(w http.ResponseWriter, fieldErrors []fieldError) {
+	msg := "Request validation failed"
+	if len(fieldErrors) == 1 {
+		msg = fieldErrors[0].Message
 	}
-	respondWithJSON(w, code, errorResponse{
-		Error: msg,
+	respondWithJSON(w, http.StatusBadRequest, errorResponse{
+		Code:        codeValidationFailed,
+		Message:     msg,
+		FieldErrors: fieldErrors,
+		Error:       msg,
 	})
 }
 
@@ -2,12 +2,37 @@ package main
 
 import (
 	"context"
+	"crypto/cipher"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"github.com/aws/aws-sdk-go-v2/config"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/flags"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/hooks"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/keys"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oauth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/search"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/taskqueue"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/telemetry"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -15,97 +40,518 @@ import (
 
 type apiConfig struct {
 	db               database.Client
-	jwtSecret        string
+	videos           VideoStore   // the video records in db, see deps.go
+	tokens           *auth.KeySet // signs and verifies access tokens, see internal/auth
+	passwords        auth.Passwords
 	platform         string
-	s3Client         *s3.Client	// Add an s3Client field to apiConfig of type *s3.Client
-	//							A pointer to an AWS S3 SDK client (from aws-sdk-go-v2)
+	store            ObjectStore // where processed videos live: S3 or, in dev, a local directory
+	storageBackend   string
+	keys             keys.Builder // names stored videos, per STORAGE_KEY_LAYOUT
 	filepathRoot     string
 	assetsRoot       string
+	uploadTmpDir     string
+	diskHighWater    int    // DISK_HIGH_WATER_PERCENT, see diskspace.go
+	uploadStaging    string // "disk" or "s3", see upload_staging.go
 	s3Bucket         string
 	s3Region         string
 	s3CfDistribution string
+	s3Encryption     storage.Encryption
+	s3Retention      storage.Retention // S3_OBJECT_LOCK_MODE, see object_lock.go and internal/storage/retention.go
 	port             string
+	publicBaseURL    string // where clients reach the server, for embed and oEmbed links
+	jobs             *jobs.Queue
+	// limits, deadlines, flags and the codec policy, which SIGHUP reloads; see
+	// reload.go:
+	settings   atomic.Pointer[liveSettings]
+	reloadMu   sync.Mutex
+	processEnv map[string]string // the environment before .env was loaded
+	// seals the per-video AES-128 HLS keys stored in the database; nil unless
+	// HLS_ENCRYPTION is set, see hls_keys.go:
+	hlsKeyCipher cipher.AEAD
+	tempCipher   *tempCipher // encrypts raw uploads in uploadTmpDir, see temp_encryption.go
+	tiering      tieringConfig
+	assetGC      assetGCConfig
+	integrity    integrityConfig
+	versions     versionsConfig
+	caching      cachingConfig // Cache-Control of stored files, see cache.go
+	// S3 event notifications (handler_s3_events.go): the SNS topic we accept
+	// messages from, and the HMAC secret for direct deliveries:
+	s3EventsTopicARN string
+	s3EventsSecret   string
+	// HMAC-signed requests made with API keys, see request_signing.go:
+	requestSigning requestSigningConfig
+	// how long signed playback, download and upload URLs last, see signed_urls.go:
+	signedURLs signedURLConfig
+	// the H.264 encoder VIDEO_ENCODER settled on at startup, see internal/transcode:
+	videoEncoder transcode.Encoder
+	// screens new videos and thumbnails before they're shown publicly, see moderation.go:
+	moderator moderation.Moderator
+	// attributes of the cookie-mode session cookies, see sessions.go:
+	sessionCookies sessionCookieConfig
+	// the identity providers users can sign in with, by name; see handler_oauth.go:
+	oauthProviders map[string]*oauth.Provider
+	// nil unless REPLICA_BUCKET is set, see replication.go:
+	replication *replicationConfig
+	// builds every media URL and purges deleted objects, see cdn.go:
+	cdn         cdn.CDN
+	cdnProvider string
+	// how long a resumable upload may sit paused before it expires; 0 keeps them
+	// forever, see handler_upload_resumable.go:
+	uploadTTL time.Duration
+	// how long a probe cache entry is kept after it was last read; 0 keeps them
+	// forever, see probe.go:
+	probeCacheTTL time.Duration
+	// where recovered panics are reported, see recover.go:
+	errorReporter errreport.Reporter
+	// nil unless JOB_BACKEND sends transcodes to remote workers, see remote_transcode.go:
+	remoteTranscode *remoteTranscodeConfig
+	// how failed transcodes and storage uploads are retried before the upload is
+	// dead-lettered, see dead_letters.go:
+	jobRetry jobs.Retry
+	// whether new uploads are turned away while the queue drains, see
+	// maintenance.go:
+	maintenance maintenanceMode
+	// bounds the video uploads taken in at once; nil for no limit, see
+	// upload_limit.go:
+	uploadLimiter *uploadLimiter
+	// ffprobe and ffmpeg, or fakes in tests; see deps.go:
+	prober     Prober
+	transcoder Transcoder
+	// run on uploads, processing and deletes; nil runs none, see internal/hooks:
+	hooks *hooks.Registry
+	// search is the optional OpenSearch index of videos, see search_index.go:
+	search searchConfig
+	// keeps concurrent plays of an unpackaged video from queueing its packaging
+	// twice, see backfillPackaging:
+	packagingBackfillMu sync.Mutex
 }
 
 func main() {
-	godotenv.Load(".env")
+	configHelp := flag.Bool("config-help", false, "list every setting with its default and exit")
+	flag.Parse()
+	if *configHelp {
+		config.PrintSettings(os.Stdout)
+		return
+	}
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	processEnv := environ()
+	godotenv.Load(envFile)
+	// Every setting is read and checked up front; see internal/config for the
+	// full list (or run with -config-help):
+	conf, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Tracing goes to the OTLP endpoint in OTEL_EXPORTER_OTLP_ENDPOINT, if any; the
+	// X-Request-Id of each request is recorded on its spans:
+	shutdownTracing, err := telemetry.Setup(context.Background(), "tubely")
+	if err != nil {
+		log.Fatalf("Couldn't set up tracing: %v", err)
 	}
 
-	db, err := database.NewClient(pathToDB)
+	// Connection pool and statement timeout tuning. SQLite serializes writers, so a
+	// small pool is usually best; the timeout bounds every query a handler makes:
+	db, err := database.NewClient(conf.DB.Path, database.PoolConfig{
+		MaxOpenConns:    conf.DB.MaxOpenConns,
+		MaxIdleConns:    conf.DB.MaxIdleConns,
+		ConnMaxLifetime: conf.DB.ConnMaxLifetime,
+		QueryTimeout:    conf.DB.QueryTimeout,
+	})
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	// Where raw uploads are staged while they're processed. Defaults to the system
+	// temp dir; point it at a big volume so large uploads don't fill /tmp:
+	uploadTmpDir := conf.Uploads.TmpDir
+	if err := os.MkdirAll(uploadTmpDir, 0700); err != nil {
+		log.Fatalf("Couldn't create upload temp directory: %v", err)
 	}
+	// mime/multipart spills large parts to os.TempDir(), which honours TMPDIR; point
+	// it at the same place so the preflight space check covers those files too:
+	os.Setenv("TMPDIR", uploadTmpDir)
+	// UPLOAD_TMP_ENCRYPTION_KEY keeps the raw uploads there encrypted, see
+	// temp_encryption.go:
+	var uploadTmpCipher *tempCipher
+	if conf.Uploads.TmpEncryptionKey != "" {
+		uploadTmpCipher, err = newTempCipher(conf.Uploads.TmpEncryptionKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Uploads are copied through pooled buffers of COPY_BUFFER_SIZE:
+	bufpool.SetSize(int(conf.Uploads.CopyBufferSize))
+
+	port := conf.Port
+	// STORAGE_BACKEND=local swaps S3 for a directory on disk, served at /media/,
+	// so the upload pipeline can run without any AWS credentials:
+	storageBackend := conf.Storage.Backend
+
+	var (
+		store        storage.Store
+		localStore   *storage.LocalStore
+		s3Bucket     string
+		s3Region     string
+		s3Encryption storage.Encryption
+		s3Retention  storage.Retention
+		awsCfg       aws.Config
+	)
+	switch storageBackend {
+	case "local":
+		localStore, err = storage.NewLocalStore(conf.Storage.LocalRoot, fmt.Sprintf("http://localhost:%s/media", port))
+		if err != nil {
+			log.Fatalf("Couldn't create local storage directory: %v", err)
+		}
+		store = localStore
+	case "s3":
+		s3Bucket = conf.Storage.Bucket
+		s3Region = conf.Storage.Region
 
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM environment variable is not set")
+		// Optional at-rest encryption for uploaded objects:
+		s3Encryption, err = storage.ParseEncryption(conf.Storage.SSEMode, conf.Storage.SSEKMSKeyID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// And Object Lock retention of stored media, for legal retention needs:
+		s3Retention, err = storage.ParseRetention(conf.Storage.ObjectLockMode, conf.Storage.ObjectLockDays)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Use awsconfig.LoadDefaultConfig to auto load the default AWS SDK config (the keys you set with aws configure)
+		// As arguments, give it an empty Context and pass awsconfig.WithRegion(s3Region) to use the region that's
+		// set in your .env file.
+		// (awsconfig.LoadDefaultConfig(...) loads credentials and settings from the default sources (env vars,
+		// shared config/credentials files, IAM role), forcing the region to s3Region. It returns awsCfg
+		// or an error)
+		awsCfg, err = awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion(s3Region))
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Trace every S3 (and Rekognition) call made with this config:
+		telemetry.AppendAWSMiddlewares(&awsCfg.APIOptions)
+		// Create a client with your config using s3.NewFromConfig (S3_ENDPOINT points
+		// it at LocalStack or MinIO instead of AWS):
+		store = &storage.S3Store{
+			Client:     storage.NewS3Client(awsCfg, conf.Storage.Endpoint),
+			Bucket:     s3Bucket,
+			Region:     s3Region,
+			Encryption: s3Encryption,
+			Retention:  s3Retention,
+			ClockSkew:  conf.SignedURLs.ClockSkew,
+			Endpoint:   conf.Storage.Endpoint,
+		}
 	}
 
-	filepathRoot := os.Getenv("FILEPATH_ROOT")
-	if filepathRoot == "" {
-		log.Fatal("FILEPATH_ROOT environment variable is not set")
+	// Media URLs go through a CDN: CloudFront by default on S3, see newCDN:
+	mediaCDN, err := newCDN(conf.CDN, store, awsCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// REPLICA_BUCKET copies every uploaded video to a second bucket, normally in
+	// another region (REPLICA_REGION), and plays from it while the primary is down:
+	var replication *replicationConfig
+	if replicaBucket := conf.Replica.Bucket; replicaBucket != "" {
+		replicaRegion := conf.Replica.Region
+		// KMS keys are regional, so the replica has its own (empty uses aws/s3):
+		replicaEncryption, err := storage.ParseEncryption(s3Encryption.Mode, conf.Replica.SSEKMSKeyID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		replicaCfg := awsCfg.Copy()
+		replicaCfg.Region = replicaRegion
+		replica := &storage.S3Store{
+			Client:     s3.NewFromConfig(replicaCfg),
+			Bucket:     replicaBucket,
+			Region:     replicaRegion,
+			Encryption: replicaEncryption,
+			ClockSkew:  conf.SignedURLs.ClockSkew,
+		}
+		// The replica is played from its own CloudFront distribution, if it has one:
+		var replicaCDN cdn.CDN = cdn.Origin{Store: replica}
+		if domain := conf.Replica.CloudFrontDomain; domain != "" {
+			replicaCDN = &cdn.CloudFront{
+				Domain:         domain,
+				DistributionID: conf.Replica.CloudFrontDistributionID,
+				Config:         replicaCfg,
+			}
+		}
+		replication = &replicationConfig{
+			Replica:  replica,
+			CDN:      replicaCDN,
+			Interval: conf.Replica.Interval,
+		}
+	}
+
+	// MODERATION_PROVIDER=rekognition runs new videos and thumbnails past Amazon
+	// Rekognition; anything it flags stays hidden until an admin reviews it. The
+	// default, "none", approves everything:
+	var moderator moderation.Moderator = moderation.NoOp{}
+	if conf.Moderation.Provider == "rekognition" {
+		moderator = &moderation.Rekognition{
+			Config:        awsCfg,
+			Bucket:        s3Bucket,
+			MinConfidence: float64(conf.Moderation.MinConfidence),
+		}
+	}
+
+	// JOB_BACKEND=sqs or redis sends transcodes to cmd/tubely-worker processes
+	// through that queue instead of running ffmpeg here. The files go through a
+	// staging prefix in the bucket, so it needs STORAGE_BACKEND=s3:
+	var remoteTranscode *remoteTranscodeConfig
+	if backend := conf.Jobs.Backend; backend != "local" {
+		broker, err := taskqueue.Open(taskqueue.Config{
+			Backend:     backend,
+			SQSQueueURL: conf.Jobs.SQSQueueURL,
+			AWS:         awsCfg,
+			RedisURL:    conf.Jobs.RedisURL,
+			RedisStream: conf.Jobs.RedisStream,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		remoteTranscode = &remoteTranscodeConfig{
+			Backend:       backend,
+			Broker:        broker,
+			StagingPrefix: conf.Jobs.StagingPrefix,
+			PollInterval:  conf.Jobs.PollInterval,
+		}
+	}
+
+	// VIDEO_ENCODER=auto, or a hardware encoder by name, re-encodes on the GPU
+	// when this host has one that works, and with libx264 otherwise:
+	encoding, err := transcode.DetectEncoding(context.Background(), conf.Encoding.Encoder, conf.Encoding.VAAPIDevice)
+	if err != nil {
+		log.Printf("Falling back to libx264: %v", err)
+	}
+	transcode.UseEncoding(encoding)
+	log.Printf("Re-encoding video with %s", encoding.Encoder)
+
+	// Feature flags come from FEATURE_FLAGS_FILE, a JSON object, and the
+	// environment (ENABLE_HLS=true and so on), which wins:
+	featureFlags, err := flags.Load(conf.FeatureFlagsFile)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	assetsRoot := os.Getenv("ASSETS_ROOT")
-	if assetsRoot == "" {
-		log.Fatal("ASSETS_ROOT environment variable is not set")
+	// HLS_ENCRYPTION AES-128 encrypts HLS segments, with keys served only to logged-in
+	// users. DASH can't share encrypted segments, so the two don't mix:
+	var hlsKeyCipher cipher.AEAD
+	if conf.HLS.Encryption {
+		if !featureFlags.Enabled(flags.EnableHLS) || featureFlags.Enabled(flags.EnableDASH) {
+			log.Fatal("HLS_ENCRYPTION needs ENABLE_HLS=true and ENABLE_DASH=false")
+		}
+		hlsKeyCipher, err = newHLSKeyCipher(conf.HLS.KeyEncryptionKey)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	// Cookie sessions (POST /api/login with use_cookies): COOKIE_SECURE=false allows
+	// them over plain http for local development:
+	cookieSameSite, err := parseSameSite(conf.Cookies.SameSite)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sessionCookies := sessionCookieConfig{
+		SameSite: cookieSameSite,
+		Secure:   conf.Cookies.Secure,
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	// SENTRY_DSN sends handler and job panics to Sentry (or anything speaking its
+	// protocol); they're only logged otherwise:
+	var errorReporter errreport.Reporter = errreport.NoOp{}
+	if dsn := conf.Sentry.DSN; dsn != "" {
+		sentry, err := errreport.NewSentry(dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sentry.Environment = conf.Sentry.Environment
+		sentry.Release = conf.Sentry.Release
+		errorReporter = sentry
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	// Deployments' own hooks, registered from init functions; see internal/hooks.
+	// They're closed after the job queue below, whose jobs publish to them:
+	hookRegistry := hooks.Default()
+	defer hookRegistry.Close()
+	// OPENSEARCH_URL keeps videos in an OpenSearch (or Elasticsearch) index, and
+	// SEARCH_BACKEND=opensearch searches it instead of SQLite. Existing videos
+	// are indexed with POST /api/admin/search/reindex:
+	var searchIndex searchConfig
+	if conf.Search.OpenSearchURL != "" {
+		searchIndex.Index = &search.OpenSearch{
+			URL:      conf.Search.OpenSearchURL,
+			Index:    conf.Search.OpenSearchIndex,
+			Username: conf.Search.OpenSearchUsername,
+			Password: conf.Search.OpenSearchPassword,
+		}
+		if err := searchIndex.Index.EnsureIndex(context.Background()); err != nil {
+			log.Fatalf("Couldn't set up the search index: %v", err)
+		}
+		searchIndex.Query = conf.Search.Backend == "opensearch"
+		hookRegistry.Register("opensearch", searchIndexer{index: searchIndex.Index, videos: db})
 	}
+	if names := hookRegistry.Names(); len(names) > 0 {
+		log.Printf("Running hooks: %s", strings.Join(names, ", "))
+	}
+
+	// Background processing runs on one worker pool per priority tier. Idle workers
+	// help out with higher tiers, and jobs waiting longer than JOB_STARVATION_AGE
+	// are bumped up a tier so big uploads still finish under steady load:
+	jobQueue := jobs.NewQueue(jobs.Config{
+		Workers: [jobs.NumPriorities]int{
+			jobs.PriorityHigh:   conf.Jobs.WorkersHigh,
+			jobs.PriorityNormal: conf.Jobs.WorkersNormal,
+			jobs.PriorityLow:    conf.Jobs.WorkersLow,
+		},
+		StarvationAge: conf.Jobs.StarvationAge,
+		Retention:     conf.Jobs.Retention,
+		OnPanic:       reportJobPanics(errorReporter),
+	})
+	jobQueue.Start()
+	defer jobQueue.Shutdown()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	// Access tokens are HS256 with JWT_SECRET, or signed with JWT_SIGNING_KEYS so
+	// other services can verify them against /.well-known/jwks.json:
+	signingKeys, err := auth.LoadSigningKeys(conf.JWTSigningKeys)
+	if err != nil {
+		log.Fatalf("Couldn't load JWT_SIGNING_KEYS: %v", err)
+	}
+	tokens, err := auth.NewKeySet(conf.JWTSecret, signingKeys...)
+	if err != nil {
+		log.Fatalf("Couldn't set up access tokens: %v", err)
 	}
 
-	// Use config.LoadDefaultConfig to auto load the default AWS SDK config (the keys you set with aws configure)
-	// As arguments, give it an empty Context and pass config.WithRegion(s3Region) to use the region that's 
-	// set in your .env file.
-	// (config.LoadDefaultConfig(...) loads credentials and settings from the default sources (env vars, 
-	// shared config/credentials files, IAM role), forcing the region to s3Region. It returns awsCfg 
-	// or an error)
-	awsCfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+	keyBuilder, err := keys.New(conf.Storage.KeyLayout)
 	if err != nil {
 		log.Fatal(err)
 	}
-	// Create a client with your config using s3.NewFromConfig:
-	//
-	client := s3.NewFromConfig(awsCfg)
 
+	// New passwords are hashed with PASSWORD_HASH; older hashes are replaced as
+	// their users log in:
+	passwords := auth.Passwords{
+		Algorithm: conf.Passwords.Algorithm,
+		Argon2: auth.Argon2Params{
+			Memory:      uint32(conf.Passwords.Argon2Memory),
+			Iterations:  uint32(conf.Passwords.Argon2Iterations),
+			Parallelism: uint8(conf.Passwords.Argon2Parallelism),
+		},
+	}
+	// Sign-in with Google and GitHub, for the providers with OAUTH_* credentials:
+	loginProviders := oauthProviders(
+		conf.OAuth.GoogleClientID, conf.OAuth.GoogleClientSecret,
+		conf.OAuth.GitHubClientID, conf.OAuth.GitHubClientSecret,
+	)
 
 	cfg := apiConfig{
 		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		s3Client:         client,		// Assign the client to the s3Client field
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
+		videos:           db,
+		tokens:           tokens,
+		passwords:        passwords,
+		platform:         conf.Platform,
+		store:            store,
+		storageBackend:   storageBackend,
+		keys:             keyBuilder,
+		filepathRoot:     conf.FilepathRoot,
+		assetsRoot:       conf.AssetsRoot,
+		uploadTmpDir:     uploadTmpDir,
+		diskHighWater:    conf.Uploads.DiskHighWater,
+		tempCipher:       uploadTmpCipher,
+		uploadStaging:    conf.Uploads.Staging,
 		s3Bucket:         s3Bucket,
 		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
+		s3CfDistribution: conf.CDN.CloudFrontDomain,
+		s3Encryption:     s3Encryption,
+		s3Retention:      s3Retention,
+		cdn:              mediaCDN,
+		cdnProvider:      conf.CDN.Provider,
 		port:             port,
+		publicBaseURL:    conf.PublicBaseURL,
+		jobs:             jobQueue,
+		processEnv:       processEnv,
+		hlsKeyCipher:     hlsKeyCipher,
+		// Cold-video tiering: videos watched at most COLD_MAX_VIEWS times in the last
+		// COLD_AFTER get tagged, and the bucket lifecycle rule moves them to
+		// Infrequent Access and then Glacier:
+		tiering: tieringConfig{
+			ColdAfter: conf.Tiering.ColdAfter,
+			MaxViews:  conf.Tiering.MaxViews,
+			Lifecycle: storage.LifecyclePolicy{
+				InfrequentAccessDays: int32(conf.Tiering.InfrequentAccessDays),
+				GlacierDays:          int32(conf.Tiering.GlacierDays),
+			},
+			RestoreDays: int32(conf.Tiering.RestoreDays),
+			Interval:    conf.Tiering.Interval,
+		},
+		// Unreferenced files in ASSETS_ROOT (replaced thumbnails, mostly) are removed
+		// once they're ASSET_GC_GRACE old:
+		assetGC: assetGCConfig{
+			Grace:    conf.AssetGC.Grace,
+			Interval: conf.AssetGC.Interval,
+		},
+		// Files videos played before a re-upload or edit are kept, VERSION_KEEP per
+		// video, and pruned every VERSION_PRUNE_INTERVAL:
+		versions: versionsConfig{
+			Keep:     conf.Versions.Keep,
+			MaxAge:   conf.Versions.MaxAge,
+			Interval: conf.Versions.Interval,
+		},
+		caching: cachingConfig{
+			Immutable: conf.Caching.Immutable,
+			Video:     conf.Caching.Video,
+			Stream:    conf.Caching.Stream,
+			Thumbnail: conf.Caching.Thumbnail,
+		},
+		// Every INTEGRITY_CHECK_INTERVAL, a few stored videos are read back in
+		// part and compared with their upload checksums:
+		integrity: integrityConfig{
+			Interval:     conf.Integrity.Interval,
+			SampleVideos: conf.Integrity.SampleVideos,
+			SampleBlocks: conf.Integrity.SampleBlocks,
+		},
+		s3EventsTopicARN: conf.S3Events.TopicARN,
+		s3EventsSecret:   conf.S3Events.Secret,
+		requestSigning:   requestSigningConfig{Secret: conf.Signing.Secret, Window: conf.Signing.Window},
+		signedURLs:       signedURLConfig{signPlayback: conf.SignedURLs.SignPlayback, playbackTTL: conf.SignedURLs.PlaybackTTL, downloadTTL: conf.SignedURLs.DownloadTTL, uploadTTL: conf.SignedURLs.UploadTTL, clockSkew: conf.SignedURLs.ClockSkew},
+		moderator:        moderator,
+		sessionCookies:   sessionCookies,
+		oauthProviders:   loginProviders,
+		replication:      replication,
+		uploadTTL:        conf.Uploads.UploadTTL,
+		uploadLimiter:    newUploadLimiter(conf.Uploads.Concurrency, conf.Uploads.QueueWait),
+		probeCacheTTL:    conf.Uploads.ProbeCacheTTL,
+		errorReporter:    errorReporter,
+		remoteTranscode:  remoteTranscode,
+		videoEncoder:     encoding.Encoder,
+		jobRetry:         jobs.Retry{Attempts: conf.Jobs.RetryAttempts, Backoff: conf.Jobs.RetryBackoff},
+		prober:           ffmpegProber{},
+		transcoder:       ffmpegTranscoder{},
+		hooks:            hookRegistry,
+		search:           searchIndex,
+	}
+
+	cfg.settings.Store(newLiveSettings(conf, featureFlags))
+	cfg.reloadOnSignal(context.Background())
+
+	cfg.startTieringPolicy(context.Background())
+	cfg.startReplicationSweep(context.Background())
+	cfg.startUploadExpiry(context.Background())
+	cfg.startProbeCachePrune(context.Background())
+	cfg.startAssetGC(context.Background())
+	cfg.startIntegrityChecks(context.Background())
+	cfg.startVersionPrune(context.Background())
+
+	// Settle the videos a crash left uploading or processing; resumable uploads
+	// keep theirs, they pick up again below:
+	if err := db.RecoverVideoStatuses(context.Background()); err != nil {
+		log.Fatalf("Couldn't recover video statuses: %v", err)
+	}
+	// Pick up resumable uploads that were in flight when the server last stopped:
+	if err := cfg.recoverUploads(context.Background()); err != nil {
+		log.Fatalf("Couldn't recover resumable uploads: %v", err)
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -114,34 +560,138 @@ func main() {
 	}
 
 	mux := http.NewServeMux()
-	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
+	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(conf.FilepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(conf.AssetsRoot)))
+	mux.Handle("/assets/", cacheControlMiddleware(cacheControl(cfg.caching.Thumbnail, false), assetsHandler))
+
+	if localStore != nil {
+		mux.Handle("/media/", streamingDeadlines(http.StripPrefix("/media", localStore)))
+	}
+
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
+	mux.HandleFunc("GET /embed/{videoID}", cfg.handlerEmbed)
+	mux.HandleFunc("GET /api/oembed", cfg.handlerOEmbed)
+	mux.HandleFunc("GET /feeds/users/{feed}", cfg.handlerUserFeed)
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("GET /api/auth/{provider}/login", cfg.handlerOAuthLogin)
+	mux.HandleFunc("GET /api/auth/{provider}/callback", cfg.handlerOAuthCallback)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("POST /api/logout", cfg.handlerLogout)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("POST /api/users/me/watermark", cfg.uploadDeadlines(cfg.withDiskHeadroom(cfg.handlerWatermarkUpload)))
+	mux.HandleFunc("DELETE /api/users/me/watermark", cfg.handlerWatermarkDelete)
+	mux.HandleFunc("POST /api/users/me/avatar", cfg.uploadDeadlines(cfg.withDiskHeadroom(cfg.handlerAvatarUpload)))
+	mux.HandleFunc("DELETE /api/users/me/avatar", cfg.handlerAvatarDelete)
+	mux.Handle("GET /api/users/me/export", streamingDeadlines(http.HandlerFunc(cfg.handlerExport)))
+	mux.HandleFunc("GET /api/users/me/usage", cfg.handlerUsage)
+	mux.HandleFunc("POST /api/api-keys", cfg.handlerAPIKeyCreate)
+	mux.HandleFunc("GET /api/api-keys", cfg.handlerAPIKeysList)
+	mux.HandleFunc("DELETE /api/api-keys/{keyID}", cfg.handlerAPIKeyRevoke)
 
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.HandleFunc("POST /api/videos/bulk-delete", cfg.handlerVideosBulkDelete)
+	mux.HandleFunc("POST /api/videos/bulk-import", cfg.handlerVideosBulkImport)
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.uploadDeadlines(cfg.withDiskHeadroom(cfg.decompressThumbnail(cfg.handlerUploadThumbnail))))
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.limitUploads(cfg.uploadDeadlines(cfg.handlerUploadVideo)))
+	mux.HandleFunc("POST /api/videos/{videoID}/upload-from-url", cfg.limitUploads(cfg.processingDeadlines(cfg.handlerUploadVideoFromURL)))
+	mux.HandleFunc("POST /api/videos/{videoID}/upload-url", cfg.handlerDirectUploadURL)
+	mux.HandleFunc("POST /api/videos/{videoID}/upload-policy", cfg.handlerDirectUploadPolicy)
+	mux.HandleFunc("POST /api/videos/{videoID}/uploads", cfg.handlerUploadCreate)
+	mux.HandleFunc("HEAD /api/uploads/{uploadID}", cfg.handlerUploadHead)
+	mux.HandleFunc("PATCH /api/uploads/{uploadID}", cfg.limitUploads(cfg.uploadDeadlines(cfg.handlerUploadPatch)))
+	mux.HandleFunc("DELETE /api/uploads/{uploadID}", cfg.handlerUploadCancel)
+	mux.HandleFunc("POST /api/webhooks/s3-events", cfg.handlerS3Events)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/search", cfg.handlerVideosSearch)
 	// mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
 	// mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("POST /api/videos/{videoID}/chapters", cfg.handlerChaptersUpdate)
+	mux.HandleFunc("PUT /api/videos/{videoID}/tags", cfg.handlerVideoTagsUpdate)
+	mux.HandleFunc("GET /api/tags/popular", cfg.handlerTagsPopular)
+	mux.HandleFunc("PATCH /api/videos/{videoID}/thumbnail-from-frame", cfg.processingDeadlines(cfg.handlerThumbnailFromFrame))
+	mux.HandleFunc("POST /api/videos/{videoID}/clips", cfg.processingDeadlines(cfg.withDiskHeadroom(cfg.handlerClipCreate)))
+	mux.HandleFunc("GET /api/videos/{videoID}/clips", cfg.handlerClipsList)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/audio", cfg.processingDeadlines(cfg.withDiskHeadroom(cfg.handlerVideoAudioMute)))
+	mux.HandleFunc("PUT /api/videos/{videoID}/audio", cfg.limitUploads(cfg.uploadDeadlines(cfg.withDiskHeadroom(cfg.handlerVideoAudioReplace))))
+	mux.HandleFunc("GET /api/videos/{videoID}/versions", cfg.handlerVideoVersionsList)
+	mux.HandleFunc("POST /api/videos/{videoID}/versions/{n}/restore", cfg.processingDeadlines(cfg.withDiskHeadroom(cfg.handlerVideoVersionRestore)))
+	mux.HandleFunc("GET /api/videos/{videoID}/hls-key", cfg.handlerVideoHLSKey)
+	mux.HandleFunc("PUT /api/videos/{videoID}/visibility", cfg.handlerVideoVisibility)
+	mux.HandleFunc("POST /api/videos/{videoID}/like", cfg.handlerVideoLike)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/like", cfg.handlerVideoUnlike)
+	mux.HandleFunc("POST /api/videos/{videoID}/share-links", cfg.handlerShareLinkCreate)
+	mux.HandleFunc("GET /api/videos/{videoID}/share-links", cfg.handlerShareLinksList)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/share-links/{linkID}", cfg.handlerShareLinkRevoke)
+	mux.Handle("GET /api/videos/{videoID}/stream", streamingDeadlines(http.HandlerFunc(cfg.handlerVideoStream)))
+	mux.HandleFunc("GET /api/videos/{videoID}/download", cfg.handlerVideoDownload)
+
+	mux.HandleFunc("GET /api/notifications", cfg.handlerNotificationsRetrieve)
+	mux.HandleFunc("POST /api/notifications/{notificationID}/read", cfg.handlerNotificationRead)
+
+	mux.HandleFunc("POST /api/playlists", cfg.handlerPlaylistCreate)
+	mux.HandleFunc("GET /api/playlists", cfg.handlerPlaylistsRetrieve)
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.handlerPlaylistGet)
+	mux.HandleFunc("PATCH /api/playlists/{playlistID}", cfg.handlerPlaylistUpdate)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}", cfg.handlerPlaylistDelete)
+
+	mux.HandleFunc("GET /api/videos/{videoID}/jobs", cfg.handlerVideoJobs)
+	mux.HandleFunc("GET /api/jobs/{jobID}", cfg.handlerJobGet)
+	mux.Handle("GET /api/jobs/{jobID}/events", streamingDeadlines(http.HandlerFunc(cfg.handlerJobEvents)))
+
+	mux.HandleFunc("GET /api/admin/config", cfg.handlerAdminConfig)
+	mux.HandleFunc("POST /api/admin/config/reload", cfg.handlerAdminConfigReload)
+	mux.HandleFunc("GET /api/admin/config/audit", cfg.handlerAdminConfigAudit)
+	mux.HandleFunc("GET /api/admin/flags", cfg.handlerAdminFlags)
+	mux.HandleFunc("PUT /api/admin/users/{userID}/tier", cfg.handlerAdminSetUserTier)
+	mux.HandleFunc("GET /api/admin/stats", cfg.handlerAdminStats)
+	mux.HandleFunc("POST /api/admin/stats/reconcile", cfg.handlerAdminReconcileStorage)
+	mux.HandleFunc("POST /api/admin/storage/retag", cfg.handlerAdminRetagObjects)
+	mux.HandleFunc("POST /api/admin/search/reindex", cfg.handlerAdminSearchReindex)
+	mux.HandleFunc("POST /api/admin/assets/gc", cfg.handlerAdminAssetGC)
+	mux.HandleFunc("GET /api/admin/integrity", cfg.handlerAdminIntegrity)
+	mux.HandleFunc("POST /api/admin/integrity/run", cfg.handlerAdminIntegrityRun)
+	mux.HandleFunc("POST /api/admin/tiering/run", cfg.handlerAdminTieringRun)
+	mux.HandleFunc("POST /api/admin/versions/prune", cfg.handlerAdminVersionPrune)
+	mux.HandleFunc("GET /api/admin/videos", cfg.handlerAdminReprocessableVideos)
+	mux.HandleFunc("POST /api/admin/videos/{videoID}/reprocess", cfg.processingDeadlines(cfg.withDiskHeadroom(cfg.handlerAdminVideoReprocess)))
+	mux.HandleFunc("POST /api/admin/tiering/lifecycle", cfg.handlerAdminTieringLifecycle)
+	mux.HandleFunc("GET /api/admin/moderation", cfg.handlerAdminModerationQueue)
+	mux.HandleFunc("POST /api/admin/moderation/{videoID}", cfg.handlerAdminModerationReview)
+	mux.HandleFunc("GET /api/admin/dead-letters", cfg.handlerAdminDeadLetters)
+	mux.HandleFunc("POST /api/admin/dead-letters/{deadLetterID}/redrive", cfg.handlerAdminDeadLetterRedrive)
+	mux.HandleFunc("DELETE /api/admin/dead-letters/{deadLetterID}", cfg.handlerAdminDeadLetterDiscard)
+	mux.HandleFunc("GET /api/admin/maintenance", cfg.handlerAdminMaintenance)
+	mux.HandleFunc("POST /api/admin/maintenance", cfg.handlerAdminSetMaintenance)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
 
-	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+	srv := newServer(":"+port, telemetry.Middleware(cfg.recoverPanics(cfg.sessionCookieMiddleware(cfg.apiKeyMiddleware(mux, cfg.maintenanceMiddleware(mux, cfg.apiDeadlines(cfg.decompressJSON(mux))))))))
+
+	tlsSettings := tlsSettings(conf.TLS)
+	scheme := "http"
+	if tlsSettings.enabled() {
+		scheme = "https"
 	}
+	log.Printf("Serving on: %s://localhost:%s/app/\n", scheme, port)
+	err = listenAndServe(srv, tlsSettings)
+	shutdownTracing(context.Background())
+	log.Fatal(err)
+}
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+// environ is the process environment as a map:
+func environ() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			env[name] = value
+		}
+	}
+	return env
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+)
+
+// Maintenance mode is for deploying without cutting work off: turn it on, wait
+// for GET /api/admin/maintenance to report drained, then restart. While it's on,
+// the routes below are turned away with a 503 and a Retry-After, and what was
+// already accepted (upload requests still in flight, queued and running jobs)
+// runs to completion. It's per instance, like the job queue it drains.
+
+// maintenanceRoutes are the routes that start new uploads or processing:
+var maintenanceRoutes = map[string]bool{
+	"POST /api/users/me/watermark":                        true,
+	"POST /api/users/me/avatar":                           true,
+	"POST /api/thumbnail_upload/{videoID}":                true,
+	"POST /api/video_upload/{videoID}":                    true,
+	"POST /api/videos/{videoID}/upload-from-url":          true,
+	"POST /api/videos/{videoID}/upload-url":               true,
+	"POST /api/videos/{videoID}/upload-policy":            true,
+	"POST /api/videos/{videoID}/uploads":                  true,
+	"PATCH /api/uploads/{uploadID}":                       true,
+	"POST /api/webhooks/s3-events":                        true,
+	"PATCH /api/videos/{videoID}/thumbnail-from-frame":    true,
+	"POST /api/admin/dead-letters/{deadLetterID}/redrive": true,
+}
+
+// defaultMaintenanceRetryAfter is the Retry-After when turning maintenance on
+// doesn't give one:
+const defaultMaintenanceRetryAfter = 5 * time.Minute
+
+type maintenanceState struct {
+	Since      time.Time
+	RetryAfter time.Duration
+}
+
+type maintenanceMode struct {
+	// nil while off:
+	state atomic.Pointer[maintenanceState]
+	// requests to maintenanceRoutes being served, on or off:
+	inFlight atomic.Int64
+}
+
+// maintenanceMiddleware turns away maintenanceRoutes while maintenance is on,
+// and counts the ones it lets through until they're done.
+func (cfg *apiConfig) maintenanceMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); !maintenanceRoutes[pattern] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Counted before the check, so a drain can't see zero while one slips in:
+		cfg.maintenance.inFlight.Add(1)
+		defer cfg.maintenance.inFlight.Add(-1)
+		if state := cfg.maintenance.state.Load(); state != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(state.RetryAfter.Seconds())))
+			respondWithCode(w, http.StatusServiceUnavailable, codeMaintenance, "Uploads are paused for maintenance, try again later", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type maintenanceResponse struct {
+	Enabled           bool                `json:"enabled"`
+	Since             *time.Time          `json:"since,omitempty"`
+	RetryAfterSeconds int                 `json:"retry_after_seconds,omitempty"`
+	InFlightRequests  int64               `json:"in_flight_requests"`
+	Jobs              map[jobs.Status]int `json:"jobs"`
+	// Drained means maintenance is on and nothing is left running: it's safe to
+	// restart.
+	Drained bool `json:"drained"`
+}
+
+func (cfg *apiConfig) maintenanceStatus() maintenanceResponse {
+	resp := maintenanceResponse{
+		InFlightRequests: cfg.maintenance.inFlight.Load(),
+		Jobs:             cfg.jobs.Unfinished(),
+	}
+	if state := cfg.maintenance.state.Load(); state != nil {
+		since := state.Since
+		resp.Enabled = true
+		resp.Since = &since
+		resp.RetryAfterSeconds = int(state.RetryAfter.Seconds())
+		resp.Drained = resp.InFlightRequests == 0 && len(resp.Jobs) == 0
+	}
+	return resp
+}
+
+// handlerAdminMaintenance reports whether maintenance is on, and how far the
+// drain has got.
+func (cfg *apiConfig) handlerAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.maintenanceStatus())
+}
+
+// handlerAdminSetMaintenance turns maintenance on or off. Turning it on again
+// keeps the original since, so drain progress reads from the first call.
+func (cfg *apiConfig) handlerAdminSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	type parameters struct {
+		Enabled           bool `json:"enabled"`
+		RetryAfterSeconds int  `json:"retry_after_seconds"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.RetryAfterSeconds < 0 {
+		respondWithFieldErrors(w, []fieldError{{"retry_after_seconds", "Retry-After can't be negative"}})
+		return
+	}
+
+	if !params.Enabled {
+		cfg.maintenance.state.Store(nil)
+		respondWithJSON(w, http.StatusOK, cfg.maintenanceStatus())
+		return
+	}
+	state := &maintenanceState{Since: time.Now().UTC(), RetryAfter: defaultMaintenanceRetryAfter}
+	if params.RetryAfterSeconds > 0 {
+		state.RetryAfter = time.Duration(params.RetryAfterSeconds) * time.Second
+	}
+	if current := cfg.maintenance.state.Load(); current != nil {
+		state.Since = current.Since
+	}
+	cfg.maintenance.state.Store(state)
+	respondWithJSON(w, http.StatusOK, cfg.maintenanceStatus())
+}
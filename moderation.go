@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/flags"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/google/uuid"
+)
+
+// moderationTimeout bounds one check; Rekognition takes about as long as the
+// video to analyze it:
+const moderationTimeout = 2 * time.Hour
+
+// scheduleModeration hides the video from the public until check passes. Checks
+// mostly wait on the moderation service, so they run in their own goroutine
+// instead of holding a processing queue worker. With ENABLE_MODERATION off it
+// does nothing, and the video stays as it was.
+func (cfg *apiConfig) scheduleModeration(videoID uuid.UUID, check func(ctx context.Context) (moderation.Result, error)) {
+	if !cfg.live().Flags.Enabled(flags.EnableModeration) {
+		return
+	}
+	if err := cfg.db.BeginModeration(context.Background(), videoID); err != nil {
+		log.Printf("Couldn't start moderation of video %s: %v", videoID, err)
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), moderationTimeout)
+		defer cancel()
+
+		outcome := database.ModerationApproved
+		result, err := check(ctx)
+		switch {
+		case err != nil:
+			// Leave it pending, so it shows up in the admin review queue:
+			log.Printf("Couldn't moderate video %s: %v", videoID, err)
+			outcome = database.ModerationPending
+		case result.Flagged:
+			log.Printf("Video %s flagged for review: %v", videoID, result.Labels)
+			outcome = database.ModerationFlagged
+		}
+		if err := cfg.db.FinishModeration(context.Background(), videoID, outcome, result.Labels); err != nil {
+			log.Printf("Couldn't record moderation of video %s: %v", videoID, err)
+			return
+		}
+		video, err := cfg.videos.GetVideo(context.Background(), videoID)
+		if err != nil || video.ID == uuid.Nil {
+			return
+		}
+		cfg.hooks.Updated(context.Background(), video)
+		if outcome == database.ModerationFlagged {
+			cfg.notify(context.Background(), video, database.NotificationModerationFlagged, fmt.Sprintf("%q was flagged for review and is hidden until a moderator looks at it", video.Title))
+		}
+	}()
+}
+
+// canSeeUnmoderated reports whether the request comes from the video's owner or
+// an admin, the only people who see videos that haven't been approved. The
+// bearer token is optional here, since public video fetches don't send one.
+func (cfg *apiConfig) canSeeUnmoderated(r *http.Request, video database.Video) bool {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return false
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		return false
+	}
+	if userID == video.UserID {
+		return true
+	}
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	return err == nil && user != nil && user.IsAdmin
+}
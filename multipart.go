@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"mime/multipart"
+	"net/http"
+)
+
+// findMultipartFile advances a streaming multipart reader to the named file part,
+// discarding any parts before it. Reading the returned part reads straight from
+// the request body, nothing is buffered in memory or spilled to disk.
+func findMultipartFile(r *http.Request, name string) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, fmt.Errorf("no %q file in form: %w", name, err)
+		}
+		if part.FormName() == name && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
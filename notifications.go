@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// notify adds to the video owner's notification feed. It's never worth failing
+// the event over, so errors are only logged.
+func (cfg *apiConfig) notify(ctx context.Context, video database.Video, kind, message string) {
+	err := cfg.db.CreateNotification(context.WithoutCancel(ctx), database.CreateNotificationParams{
+		UserID:  video.UserID,
+		Kind:    kind,
+		VideoID: video.ID,
+		Message: message,
+	})
+	if err != nil {
+		log.Printf("Couldn't notify user %s about video %s: %v", video.UserID, video.ID, err)
+	}
+}
+
+// notifyProcessed tells the owner, and the deployment's hooks, how processing
+// their upload went, err being its outcome. Uploads finish in the background
+// often enough (URL imports, S3 events, resumable uploads) that the response
+// alone isn't enough.
+func (cfg *apiConfig) notifyProcessed(ctx context.Context, video database.Video, err error) {
+	if err == nil {
+		cfg.hooks.Processed(ctx, video)
+		cfg.notify(ctx, video, database.NotificationVideoReady, fmt.Sprintf("%q is ready to watch", video.Title))
+		return
+	}
+	cfg.hooks.Failed(ctx, video, err)
+	// Only the client-facing part of the error; the rest is for the logs:
+	reason := "processing failed"
+	var pe *pipelineError
+	if errors.As(err, &pe) {
+		reason = pe.message
+	}
+	cfg.notify(ctx, video, database.NotificationVideoFailed, fmt.Sprintf("%q couldn't be processed: %s", video.Title, reason))
+}
+
+// handlerNotificationsRetrieve pages through the caller's notifications, newest
+// first: GET /api/notifications?unread=true&limit=20&offset=0.
+func (cfg *apiConfig) handlerNotificationsRetrieve(w http.ResponseWriter, r *http.Request) {
+	const (
+		defaultNotificationLimit = 20
+		maxNotificationLimit     = 100
+	)
+	type response struct {
+		Results     []database.Notification `json:"results"`
+		UnreadCount int                     `json:"unread_count"`
+		Limit       int                     `json:"limit"`
+		Offset      int                     `json:"offset"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	query := r.URL.Query()
+	params := database.GetNotificationsParams{
+		UserID: userID,
+		Limit:  defaultNotificationLimit,
+	}
+	var fieldErrors []fieldError
+	if unread := query.Get("unread"); unread != "" {
+		b, err := strconv.ParseBool(unread)
+		if err != nil {
+			fieldErrors = append(fieldErrors, fieldError{"unread", "Invalid unread, expected true or false"})
+		}
+		params.UnreadOnly = b
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxNotificationLimit {
+			fieldErrors = append(fieldErrors, fieldError{"limit", fmt.Sprintf("Invalid limit, expected 1 to %d", maxNotificationLimit)})
+		}
+		params.Limit = n
+	}
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			fieldErrors = append(fieldErrors, fieldError{"offset", "Invalid offset, expected a non-negative number"})
+		}
+		params.Offset = n
+	}
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, fieldErrors)
+		return
+	}
+
+	notifications, err := cfg.db.GetNotifications(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notifications", err)
+		return
+	}
+	unread, err := cfg.db.CountUnreadNotifications(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notifications", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Results:     notifications,
+		UnreadCount: unread,
+		Limit:       params.Limit,
+		Offset:      params.Offset,
+	})
+}
+
+// handlerNotificationRead marks one of the caller's notifications read.
+func (cfg *apiConfig) handlerNotificationRead(w http.ResponseWriter, r *http.Request) {
+	notificationID, err := uuid.Parse(r.PathValue("notificationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid notification ID", err)
+		return
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	found, err := cfg.db.MarkNotificationRead(r.Context(), userID, notificationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update notification", err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Notification not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
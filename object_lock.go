@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// objectRetainedUntil is when key comes out of Object Lock retention
+// (S3_OBJECT_LOCK_MODE), zero when it isn't retained or objects aren't locked.
+func (cfg *apiConfig) objectRetainedUntil(ctx context.Context, key string) (time.Time, error) {
+	locker, ok := cfg.store.(storage.Locker)
+	if !ok || !cfg.s3Retention.Enabled() || key == "" {
+		return time.Time{}, nil
+	}
+	return locker.RetainedUntil(ctx, key)
+}
+
+// videoRetainedUntil is when the last of the video's stored files, its earlier
+// versions included, comes out of retention.
+func (cfg *apiConfig) videoRetainedUntil(ctx context.Context, videoID uuid.UUID) (time.Time, error) {
+	if !cfg.s3Retention.Enabled() {
+		return time.Time{}, nil
+	}
+	obj, err := cfg.db.GetVideoObject(ctx, videoID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keys := []string{obj.ObjectKey}
+	versions, err := cfg.db.GetVideoVersions(ctx, videoID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, version := range versions {
+		keys = append(keys, version.ObjectKey)
+	}
+
+	var last time.Time
+	for _, key := range keys {
+		until, err := cfg.objectRetainedUntil(ctx, key)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if until.After(last) {
+			last = until
+		}
+	}
+	return last, nil
+}
+
+// checkDeletable turns away deleting a video while its files are retained.
+// S3 would take the delete, but only hide the objects behind delete markers, so
+// the video would be gone from the API but not from the bucket; better to say
+// so up front.
+func (cfg *apiConfig) checkDeletable(ctx context.Context, videoID uuid.UUID) error {
+	until, err := cfg.videoRetainedUntil(ctx, videoID)
+	if err != nil {
+		return storageError(http.StatusInternalServerError, "Couldn't check the video's retention", err)
+	}
+	if !until.IsZero() {
+		return &pipelineError{http.StatusConflict, codeObjectLocked,
+			fmt.Sprintf("Video is under %s retention until %s and can't be deleted before then", cfg.s3Retention.Mode, until.UTC().Format(time.DateOnly)), nil}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+)
+
+const jobKindRetagObjects = "retag_objects"
+
+// Object tags, for S3 cost-allocation reports and lifecycle rules scoped to a
+// user or a kind of content. Activate user_id and content_kind as cost
+// allocation tags in the billing console to see them in reports.
+const (
+	tagUserID      = "user_id"
+	tagVideoID     = "video_id"
+	tagContentKind = "content_kind"
+)
+
+// content_kind values besides the media kinds ("video" and "audio"):
+const (
+	contentKindStream = "stream" // HLS/DASH segments and manifests
+	contentKindUpload = "upload" // a raw direct upload waiting to be processed
+)
+
+// videoObjectTags labels an object that belongs to video alone:
+func videoObjectTags(video database.Video, contentKind string) map[string]string {
+	return map[string]string{
+		tagUserID:      video.UserID.String(),
+		tagVideoID:     video.ID.String(),
+		tagContentKind: contentKind,
+	}
+}
+
+// sharedObjectTags labels a content-addressed object. It's shared by every video
+// with the same bytes, so it isn't any one user's:
+func sharedObjectTags(contentKind string) map[string]string {
+	return map[string]string{tagContentKind: contentKind}
+}
+
+// handlerAdminRetagObjects tags every stored video object the way new uploads
+// are, for objects stored before tagging existed (or re-owned since). It runs as
+// a job; the response has its ID.
+func (cfg *apiConfig) handlerAdminRetagObjects(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := cfg.store.(storage.Tagger); !ok {
+		respondWithError(w, http.StatusConflict, "Storage backend doesn't support tagging", nil)
+		return
+	}
+
+	job, err := cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindRetagObjects,
+		OwnerID:  user.ID,
+		Priority: jobs.PriorityLow,
+		Run: tracedJob(r.Context(), jobKindRetagObjects, func(ctx context.Context, job *jobs.Job) error {
+			return cfg.retagObjects(ctx, job.SetProgress)
+		}),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Couldn't queue retagging", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, map[string]any{"job_id": job.ID})
+}
+
+// retagObjects tags each video's object and stream package, and the replica's
+// copy of the object. Objects that are gone are skipped; the first other failure
+// stops the run, which is safe to repeat.
+func (cfg *apiConfig) retagObjects(ctx context.Context, onProgress transcode.ProgressFunc) error {
+	tagger := cfg.store.(storage.Tagger)
+	videos, err := cfg.db.GetStoredVideos(ctx)
+	if err != nil {
+		return err
+	}
+
+	tagged := 0
+	setTags := func(t storage.Tagger, key string, tags map[string]string) error {
+		err := t.SetTags(ctx, key, tags)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't tag %s: %w", key, err)
+		}
+		tagged++
+		return nil
+	}
+
+	for i, v := range videos {
+		video := database.Video{ID: v.VideoID, CreateVideoParams: database.CreateVideoParams{UserID: v.UserID}}
+		if v.ObjectKey != "" {
+			tags := videoObjectTags(video, v.MediaKind)
+			if v.Shared {
+				tags = sharedObjectTags(v.MediaKind)
+			}
+			if err := setTags(tagger, v.ObjectKey, tags); err != nil {
+				return err
+			}
+			if cfg.replication != nil {
+				if err := setTags(cfg.replication.Replica, v.ObjectKey, tags); err != nil {
+					return err
+				}
+			}
+		}
+		if v.StreamPrefix != "" {
+			tags := videoObjectTags(video, contentKindStream)
+			err := cfg.store.List(ctx, v.StreamPrefix+"/", func(obj storage.ObjectInfo) error {
+				return setTags(tagger, obj.Key, tags)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		onProgress(float64(i+1) / float64(len(videos)) * 100)
+	}
+	log.Printf("Retagged %d objects of %d videos", tagged, len(videos))
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/flags"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+const (
+	jobKindPackageVideo = "package_video"
+
+	// packageSegmentSeconds is the target segment length for both formats:
+	packageSegmentSeconds = "4"
+	dashManifestName      = "manifest.mpd"
+	hlsPlaylistName       = "master.m3u8"
+)
+
+// packageContentTypes maps the files ffmpeg writes to the types players expect:
+var packageContentTypes = map[string]string{
+	".mpd":  "application/dash+xml",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+}
+
+// schedulePackaging queues the adaptive-streaming packaging of a processed video
+// when ENABLE_HLS or ENABLE_DASH is set. The job gets its own hard link to the
+// file, since the upload pipeline removes its copy as soon as it returns.
+func (cfg *apiConfig) schedulePackaging(ctx context.Context, video database.Video, processedFilePath string, size int64) {
+	if !cfg.live().Flags.Enabled(flags.EnableHLS) && !cfg.live().Flags.Enabled(flags.EnableDASH) {
+		return
+	}
+
+	input := processedFilePath + ".package"
+	if err := os.Link(processedFilePath, input); err != nil {
+		log.Printf("Couldn't stage video %s for packaging: %v", video.ID, err)
+		return
+	}
+	_, err := cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindPackageVideo,
+		OwnerID:  video.UserID,
+		VideoID:  video.ID,
+		Priority: cfg.processingPriority(size),
+		Run: tracedJob(ctx, jobKindPackageVideo, func(ctx context.Context, job *jobs.Job) error {
+			defer os.Remove(input)
+			return cfg.packageVideo(ctx, video, input, job.SetProgress)
+		}),
+	})
+	if err != nil {
+		os.Remove(input)
+		log.Printf("Couldn't queue packaging for video %s: %v", video.ID, err)
+	}
+}
+
+// schedulePackagingFromStore is schedulePackaging for a video that was never on
+// local disk (UPLOAD_STAGING=s3), or was stored before packaging was turned on:
+// the job reads the stored file through openVideoSource when it runs.
+func (cfg *apiConfig) schedulePackagingFromStore(ctx context.Context, video database.Video, key string, priority jobs.Priority) error {
+	if !cfg.live().Flags.Enabled(flags.EnableHLS) && !cfg.live().Flags.Enabled(flags.EnableDASH) {
+		return nil
+	}
+	_, err := cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindPackageVideo,
+		OwnerID:  video.UserID,
+		VideoID:  video.ID,
+		Priority: priority,
+		Run: tracedJob(ctx, jobKindPackageVideo, func(ctx context.Context, job *jobs.Job) error {
+			source, cleanup, err := cfg.openVideoSource(ctx, key)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			return cfg.packageVideo(ctx, video, source, job.SetProgress)
+		}),
+	})
+	if err != nil {
+		log.Printf("Couldn't queue packaging for video %s: %v", video.ID, err)
+	}
+	return err
+}
+
+// packageVideo segments the MP4 without re-encoding and uploads the result under a
+// fresh prefix, then swaps the video over and deletes the previous package. With
+// DASH enabled the HLS playlists are written by the same ffmpeg run and share the
+// fMP4 (CMAF) segments, so enabling both doesn't double the storage. With
+// HLS_ENCRYPTION the segments are AES-128 encrypted, see hls_keys.go.
+func (cfg *apiConfig) packageVideo(ctx context.Context, video database.Video, inputFilePath string, onProgress transcode.ProgressFunc) error {
+	videoID := video.ID
+	outDir, err := os.MkdirTemp(cfg.uploadTmpDir, "tubely-package-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+
+	args := []string{"-i", inputFilePath, "-map", "0:v:0", "-map", "0:a?", "-c", "copy"}
+	var manifest string
+	if cfg.live().Flags.Enabled(flags.EnableDASH) {
+		manifest = dashManifestName
+		args = append(args,
+			"-f", "dash",
+			"-seg_duration", packageSegmentSeconds,
+			"-use_template", "1",
+			"-use_timeline", "1",
+			"-init_seg_name", "init-$RepresentationID$.m4s",
+			"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		)
+		if cfg.live().Flags.Enabled(flags.EnableHLS) {
+			args = append(args, "-hls_playlist", "1")
+		}
+	} else {
+		manifest = hlsPlaylistName
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", packageSegmentSeconds,
+			"-hls_playlist_type", "vod",
+			"-hls_segment_type", "fmp4",
+			"-hls_fmp4_init_filename", "init.mp4",
+			"-hls_segment_filename", filepath.Join(outDir, "chunk-%05d.m4s"),
+		)
+		// AES-128 encrypt the segments; the playlist points players at the key endpoint:
+		if cfg.hlsKeyCipher != nil {
+			keyInfoPath, cleanup, err := cfg.writeHLSKeyInfo(ctx, videoID)
+			if err != nil {
+				return fmt.Errorf("couldn't prepare HLS key: %w", err)
+			}
+			defer cleanup()
+			args = append(args, "-hls_key_info_file", keyInfoPath)
+		}
+	}
+	args = append(args, filepath.Join(outDir, manifest))
+
+	if err := transcode.RunFFmpeg(ctx, inputFilePath, onProgress, args...); err != nil {
+		return fmt.Errorf("error packaging video: %v", err)
+	}
+
+	// A new random prefix per package, so CDN caches never serve a mix of the old
+	// and new segments:
+	prefix := path.Join("streams", videoID.String(), uuid.NewString())
+	tags := videoObjectTags(video, contentKindStream)
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := cfg.putPackageFile(ctx, prefix, filepath.Join(outDir, entry.Name()), tags); err != nil {
+			cfg.deletePrefix(ctx, prefix+"/")
+			return err
+		}
+	}
+
+	streams := database.VideoStreams{Prefix: prefix}
+	if cfg.live().Flags.Enabled(flags.EnableDASH) {
+		url := cfg.cdn.PublicURL(path.Join(prefix, dashManifestName))
+		streams.DashURL = &url
+	}
+	if cfg.live().Flags.Enabled(flags.EnableHLS) {
+		url := cfg.cdn.PublicURL(path.Join(prefix, hlsPlaylistName))
+		streams.HLSURL = &url
+	}
+
+	previous, err := cfg.db.GetVideoStreamPrefix(ctx, videoID)
+	if err != nil {
+		return err
+	}
+	if err := cfg.db.SetVideoStreams(ctx, videoID, streams); err != nil {
+		cfg.deletePrefix(ctx, prefix+"/")
+		return err
+	}
+	if previous != "" {
+		cfg.deletePrefix(ctx, previous+"/")
+	}
+	return nil
+}
+
+func (cfg *apiConfig) putPackageFile(ctx context.Context, prefix, filePath string, tags map[string]string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	contentType, ok := packageContentTypes[filepath.Ext(filePath)]
+	if !ok {
+		contentType = "application/octet-stream"
+	}
+	return cfg.store.Put(ctx, path.Join(prefix, filepath.Base(filePath)), f, storage.PutOptions{
+		ContentType:  contentType,
+		CacheControl: cacheControl(cfg.caching.Stream, false),
+		Tags:         tags,
+		Retain:       true,
+	})
+}
+
+// deletePrefix removes every object under prefix. It's cleanup, so failures are
+// only logged.
+func (cfg *apiConfig) deletePrefix(ctx context.Context, prefix string) {
+	var keys []string
+	err := cfg.store.List(ctx, prefix, func(obj storage.ObjectInfo) error {
+		keys = append(keys, obj.Key)
+		return nil
+	})
+	if err == nil && len(keys) > 0 {
+		err = cfg.store.Delete(ctx, keys...)
+	}
+	if err != nil {
+		log.Printf("Couldn't delete objects under %s: %v", prefix, err)
+		return
+	}
+	cfg.invalidateCDN(ctx, keys...)
+}
+
+// Formats players can ask GET /api/videos/{videoID} for, with ?format=:
+const (
+	playbackFormatMP4  = "mp4"
+	playbackFormatHLS  = "hls"
+	playbackFormatDASH = "dash"
+)
+
+// backfillPackaging packages, on demand, a video that was stored before
+// ENABLE_HLS or ENABLE_DASH was turned on, the first time a player asks for the
+// rendition it's missing. It reports whether the rendition is on its way; the
+// player gets the progressive MP4 meanwhile, so the catalog moves over as it's
+// watched rather than all at once. A packaging that fails isn't tried again
+// until the queue has forgotten it.
+func (cfg *apiConfig) backfillPackaging(ctx context.Context, video database.Video, format string) bool {
+	switch format {
+	case playbackFormatHLS:
+		if video.HLSURL != nil || !cfg.live().Flags.Enabled(flags.EnableHLS) {
+			return false
+		}
+	case playbackFormatDASH:
+		if video.DashURL != nil || !cfg.live().Flags.Enabled(flags.EnableDASH) {
+			return false
+		}
+	default:
+		return false
+	}
+	if video.MediaKind != mediaKindVideo || video.VideoURL == nil || video.Status != database.StatusReady {
+		return false
+	}
+
+	cfg.packagingBackfillMu.Lock()
+	defer cfg.packagingBackfillMu.Unlock()
+	var last *jobs.Job
+	for _, job := range cfg.jobs.ListByVideo(video.ID) {
+		if job.Kind == jobKindPackageVideo {
+			last = job
+		}
+	}
+	if last != nil {
+		return !last.Snapshot().Status.Finished()
+	}
+	obj, err := cfg.db.GetVideoObject(ctx, video.ID)
+	if err != nil {
+		log.Printf("Couldn't get video %s to package it: %v", video.ID, err)
+		return false
+	}
+	if obj.ObjectKey == "" {
+		return false
+	}
+	// Backfills wait behind new uploads:
+	return cfg.schedulePackagingFromStore(ctx, video, obj.ObjectKey, jobs.PriorityLow) == nil
+}
@@ -0,0 +1,330 @@
+// Package tubelyclient is a Go client for the Tubely HTTP API.
+//
+//	c := tubelyclient.New("http://localhost:8091")
+//	if _, err := c.Login(ctx, email, password); err != nil { ... }
+//	video, err := c.CreateVideo(ctx, "Title", "Description")
+//	video, err = c.UploadVideo(ctx, video.ID, "clip.mp4", "video/mp4")
+package tubelyclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Client calls the API as one user. It's safe for concurrent use once logged in.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// MaxRetries is how many times a failed upload or idempotent request is
+	// retried after a network error, 429, 502, 503 or 504. Defaults to 3 in New.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry; it doubles on each one.
+	RetryBackoff time.Duration
+
+	token string
+}
+
+// New returns a Client for the server at baseURL, e.g. "http://localhost:8091".
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		HTTPClient:   http.DefaultClient,
+		MaxRetries:   3,
+		RetryBackoff: time.Second,
+	}
+}
+
+// SetToken uses an access token obtained elsewhere instead of calling Login.
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// Login exchanges credentials for an access token, which the client then sends
+// with every request.
+func (c *Client) Login(ctx context.Context, email, password string) (User, error) {
+	var resp struct {
+		User
+		Token string `json:"token"`
+	}
+	err := c.doJSON(ctx, http.MethodPost, "/api/login", map[string]string{
+		"email":    email,
+		"password": password,
+	}, &resp)
+	if err != nil {
+		return User{}, err
+	}
+	c.token = resp.Token
+	return resp.User, nil
+}
+
+// CreateVideo creates a draft to upload a file into.
+func (c *Client) CreateVideo(ctx context.Context, title, description string) (Video, error) {
+	var video Video
+	err := c.doJSON(ctx, http.MethodPost, "/api/videos", map[string]string{
+		"title":       title,
+		"description": description,
+	}, &video)
+	return video, err
+}
+
+// ListVideos returns the logged-in user's videos, newest first.
+func (c *Client) ListVideos(ctx context.Context) ([]Video, error) {
+	var videos []Video
+	err := c.doJSON(ctx, http.MethodGet, "/api/videos", nil, &videos)
+	return videos, err
+}
+
+// SearchVideos runs a full-text search over titles and descriptions, best
+// matches first. limit 0 uses the server's default.
+func (c *Client) SearchVideos(ctx context.Context, query string, limit, offset int) ([]Video, error) {
+	params := url.Values{"q": {query}}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		params.Set("offset", strconv.Itoa(offset))
+	}
+	var resp struct {
+		Results []Video `json:"results"`
+	}
+	err := c.doJSON(ctx, http.MethodGet, "/api/videos/search?"+params.Encode(), nil, &resp)
+	return resp.Results, err
+}
+
+func (c *Client) GetVideo(ctx context.Context, id uuid.UUID) (Video, error) {
+	var video Video
+	err := c.doJSON(ctx, http.MethodGet, "/api/videos/"+id.String(), nil, &video)
+	return video, err
+}
+
+func (c *Client) DeleteVideo(ctx context.Context, id uuid.UUID) error {
+	return c.doJSON(ctx, http.MethodDelete, "/api/videos/"+id.String(), nil, nil)
+}
+
+// UploadVideo uploads the file at filePath as the video's content and returns
+// the processed video. contentType is e.g. "video/mp4" or "audio/mpeg".
+func (c *Client) UploadVideo(ctx context.Context, id uuid.UUID, filePath, contentType string) (Video, error) {
+	var video Video
+	err := c.uploadFile(ctx, "/api/video_upload/"+id.String(), "video", filePath, contentType, &video)
+	return video, err
+}
+
+// UploadThumbnail sets the video's thumbnail from a JPEG or PNG file.
+func (c *Client) UploadThumbnail(ctx context.Context, id uuid.UUID, filePath, contentType string) (Video, error) {
+	var video Video
+	err := c.uploadFile(ctx, "/api/thumbnail_upload/"+id.String(), "thumbnail", filePath, contentType, &video)
+	return video, err
+}
+
+func (c *Client) GetJob(ctx context.Context, id uuid.UUID) (Job, error) {
+	var job Job
+	err := c.doJSON(ctx, http.MethodGet, "/api/jobs/"+id.String(), nil, &job)
+	return job, err
+}
+
+// ListVideoJobs returns the video's recent and running jobs.
+func (c *Client) ListVideoJobs(ctx context.Context, videoID uuid.UUID) ([]Job, error) {
+	var jobs []Job
+	err := c.doJSON(ctx, http.MethodGet, "/api/videos/"+videoID.String()+"/jobs", nil, &jobs)
+	return jobs, err
+}
+
+// WaitForJob polls the job every interval until it finishes or ctx is done,
+// and returns its final state. A failed job is returned with a nil error; check
+// Status and Error.
+func (c *Client) WaitForJob(ctx context.Context, id uuid.UUID, interval time.Duration) (Job, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job, err := c.GetJob(ctx, id)
+		if err != nil {
+			return Job{}, err
+		}
+		if job.Finished() {
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListReprocessableVideos returns up to limit of the videos with a stored file,
+// by ID, starting after the given one (uuid.Nil for the first page); an empty
+// page is the end. limit 0 uses the server's default. It needs an admin.
+func (c *Client) ListReprocessableVideos(ctx context.Context, after uuid.UUID, limit int) ([]ReprocessableVideo, error) {
+	params := url.Values{}
+	if after != uuid.Nil {
+		params.Set("after", after.String())
+	}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	var videos []ReprocessableVideo
+	err := c.doJSON(ctx, http.MethodGet, "/api/admin/videos?"+params.Encode(), nil, &videos)
+	return videos, err
+}
+
+// ReprocessVideo runs the video's stored file through the server's current
+// processing pipeline again and returns the video once the new file is stored.
+// It needs an admin.
+func (c *Client) ReprocessVideo(ctx context.Context, id uuid.UUID) (Video, error) {
+	var video Video
+	err := c.doJSON(ctx, http.MethodPost, "/api/admin/videos/"+id.String()+"/reprocess", nil, &video)
+	return video, err
+}
+
+// uploadFile streams the file as the named part of a multipart form, so even
+// huge files never sit in memory. Each attempt re-reads the file from the start.
+func (c *Client) uploadFile(ctx context.Context, path, field, filePath, contentType string, out any) error {
+	return c.withRetries(ctx, func() (*http.Request, error) {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		go func() {
+			defer f.Close()
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, filepath.Base(filePath)))
+			header.Set("Content-Type", contentType)
+			part, err := mw.CreatePart(header)
+			if err == nil {
+				_, err = io.Copy(part, f)
+			}
+			if err == nil {
+				err = mw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, pr)
+		if err != nil {
+			pr.Close()
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req, nil
+	}, out)
+}
+
+// doJSON sends body (if any) as JSON and decodes the response into out (if any).
+// Only idempotent methods are retried.
+func (c *Client) doJSON(ctx context.Context, method, path string, body, out any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	newRequest := func() (*http.Request, error) {
+		var reqBody io.Reader
+		if data != nil {
+			reqBody = bytes.NewReader(data)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reqBody)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, nil
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return c.withRetries(ctx, newRequest, out)
+	}
+	req, err := newRequest()
+	if err != nil {
+		return err
+	}
+	return c.do(req, out)
+}
+
+// withRetries sends a fresh request from newRequest until one succeeds, fails
+// with a non-retryable error, or MaxRetries is used up.
+func (c *Client) withRetries(ctx context.Context, newRequest func() (*http.Request, error), out any) error {
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return err
+		}
+		err = c.do(req, out)
+		if err == nil || attempt >= c.MaxRetries || !retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether err looks temporary: a network failure or a status
+// that means "try again later".
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (c *Client) do(req *http.Request, out any) error {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		if apiErr.Message == "" {
+			apiErr.Message = resp.Status
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
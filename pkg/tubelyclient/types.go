@@ -0,0 +1,109 @@
+package tubelyclient
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Video is a video as the API returns it.
+type Video struct {
+	ID           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	UserID       uuid.UUID `json:"user_id"`
+	ThumbnailURL *string   `json:"thumbnail_url"`
+	VideoURL     *string   `json:"video_url"`
+	HLSURL       *string   `json:"hls_url,omitempty"`
+	DashURL      *string   `json:"dash_url,omitempty"`
+	// Thumbnails maps widths ("320", "640", "1280") to scaled thumbnail URLs:
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+	// MediaKind is "video" or "audio":
+	MediaKind        string  `json:"media_kind"`
+	OriginalFilename *string `json:"original_filename,omitempty"`
+	Version          int     `json:"version"`
+	// Status is "draft", "uploading", "processing", "ready", "failed" or "archived":
+	Status           string    `json:"status"`
+	ModerationStatus string    `json:"moderation_status"`
+	Chapters         []Chapter `json:"chapters,omitempty"`
+	// Visibility is "public" or "private":
+	Visibility string `json:"visibility"`
+	LikeCount  int    `json:"like_count"`
+	// LikedByMe is only set when the client is logged in:
+	LikedByMe *bool `json:"liked_by_me,omitempty"`
+	// VideoURLExpiresAt is set when VideoURL is signed; fetch the video again
+	// before then for a fresh one.
+	VideoURLExpiresAt *time.Time `json:"video_url_expires_at,omitempty"`
+	// PlaybackStatus is only set by GetVideo: "available", or "restoring" while
+	// an archived video is brought back from cold storage.
+	PlaybackStatus string `json:"playback_status,omitempty"`
+}
+
+type Chapter struct {
+	ID           uuid.UUID `json:"id"`
+	Position     int       `json:"position"`
+	StartSeconds float64   `json:"start_seconds"`
+	EndSeconds   float64   `json:"end_seconds"`
+	Title        string    `json:"title"`
+}
+
+// User is the logged-in account.
+type User struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Email     string    `json:"email"`
+	IsAdmin   bool      `json:"is_admin"`
+	AvatarURL *string   `json:"avatar_url"`
+}
+
+// Job is a background job, such as processing or packaging a video.
+type Job struct {
+	ID         uuid.UUID  `json:"id"`
+	Kind       string     `json:"kind"`
+	VideoID    uuid.UUID  `json:"video_id"`
+	Priority   string     `json:"priority"`
+	Status     string     `json:"status"`
+	Progress   float64    `json:"progress"`
+	Error      string     `json:"error,omitempty"`
+	EnqueuedAt time.Time  `json:"enqueued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the job has stopped, successfully or not.
+func (j Job) Finished() bool {
+	return j.Status == "succeeded" || j.Status == "failed" || j.Status == "canceled"
+}
+
+// ReprocessableVideo is a video ListReprocessableVideos found, one an admin can
+// have reprocessed.
+type ReprocessableVideo struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	MediaKind string    `json:"media_kind"`
+	// HasStreams is whether the video has been packaged for HLS or DASH:
+	HasStreams bool `json:"has_streams"`
+}
+
+// FieldError is one invalid field of a VALIDATION_FAILED error.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// APIError is a non-2xx response. Code is the server's machine-readable error
+// code, such as "NOT_OWNER" or "FILE_TOO_LARGE".
+type APIError struct {
+	StatusCode  int
+	Code        string       `json:"code"`
+	Message     string       `json:"message"`
+	FieldErrors []FieldError `json:"field_errors,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("tubely: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
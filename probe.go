@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// probeCachePruneInterval is how often entries unused for PROBE_CACHE_TTL are dropped:
+const probeCachePruneInterval = 24 * time.Hour
+
+// probeFile returns ffprobe's JSON description of the file: its format, streams
+// and chapters. hash is the SHA-256 of the file's content; when it's set the
+// output is cached under it, so re-uploads of the same file (and later probes
+// of a stored one) don't run ffprobe again. source may be a URL ffprobe reads.
+func (cfg *apiConfig) probeFile(ctx context.Context, source, hash string) ([]byte, error) {
+	if hash != "" {
+		probe, err := cfg.db.GetProbe(ctx, hash)
+		if err != nil {
+			log.Printf("Couldn't read probe cache for %s: %v", hash, err)
+		} else if probe != nil {
+			return probe, nil
+		}
+	}
+
+	probe, err := cfg.prober.Probe(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	if hash != "" {
+		if err := cfg.db.SaveProbe(ctx, hash, probe); err != nil {
+			log.Printf("Couldn't cache probe of %s: %v", hash, err)
+		}
+	}
+	return probe, nil
+}
+
+// durationFromProbe reads the container duration from ffprobe's -show_format
+// JSON output:
+func durationFromProbe(probeOutput []byte) (time.Duration, error) {
+	var output struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(probeOutput, &output); err != nil {
+		return 0, fmt.Errorf("could not parse ffprobe output: %v", err)
+	}
+	seconds, err := strconv.ParseFloat(output.Format.Duration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse duration %q: %v", output.Format.Duration, err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// startProbeCachePrune drops probe cache entries nobody has read for
+// PROBE_CACHE_TTL, every probeCachePruneInterval until ctx is done:
+func (cfg *apiConfig) startProbeCachePrune(ctx context.Context) {
+	if cfg.probeCacheTTL <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(probeCachePruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := cfg.db.DeleteProbesUnusedSince(ctx, time.Now().Add(-cfg.probeCacheTTL))
+				if err != nil {
+					log.Printf("Probe cache prune failed: %v", err)
+				} else if n > 0 {
+					log.Printf("Pruned %d unused probe cache entries", n)
+				}
+			}
+		}
+	}()
+}
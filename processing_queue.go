@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/telemetry"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+)
+
+const jobKindProcessVideo = "process_video"
+
+// processingPriority picks the queue tier for an upload of the given size. Files
+// at or under JOB_SMALL_FILE_BYTES go to the high tier, files over
+// JOB_LARGE_FILE_BYTES to the low tier, everything else in between.
+func (cfg *apiConfig) processingPriority(size int64) jobs.Priority {
+	switch {
+	case size <= cfg.live().JobSmallFileBytes:
+		return jobs.PriorityHigh
+	case size > cfg.live().JobLargeFileBytes:
+		return jobs.PriorityLow
+	}
+	return jobs.PriorityNormal
+}
+
+// runProcessingJob runs a transcode step on the job queue and waits for its
+// output file. The step reports its progress to the job, where the events
+// endpoint picks it up. With JOB_BACKEND set, the job hands the step to the
+// remote workers and waits for them instead (see remote_transcode.go). A failed
+// step is retried, see dead_letters.go. If the caller gives up (client
+// disconnects, request times out) the job is canceled and any output it still
+// produces is removed.
+func (cfg *apiConfig) runProcessingJob(ctx context.Context, video database.Video, inputFilePath string, task transcode.Task) (string, error) {
+	info, err := os.Stat(inputFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	var processedFilePath string
+	job, err := cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindProcessVideo,
+		OwnerID:  video.UserID,
+		VideoID:  video.ID,
+		Priority: cfg.processingPriority(info.Size()),
+		Retry:    cfg.jobRetry,
+		Run: tracedJob(ctx, jobKindProcessVideo, func(ctx context.Context, job *jobs.Job) error {
+			var output string
+			var err error
+			if cfg.remoteTranscode != nil {
+				output, err = cfg.runRemoteTranscode(ctx, video, inputFilePath, task, job.SetProgress)
+			} else {
+				// ffmpeg reads an encrypted upload through a decrypting pipe:
+				source, closeSource, sourceErr := cfg.tempCipher.plainSource(inputFilePath)
+				if sourceErr != nil {
+					return sourceErr
+				}
+				defer closeSource()
+				output, err = cfg.transcoder.Transcode(ctx, task, source, job.SetProgress)
+			}
+			processedFilePath = output
+			return err
+		}),
+	})
+	if err != nil {
+		return "", &pipelineError{http.StatusServiceUnavailable, codeUnavailable, "Processing queue is unavailable", err}
+	}
+
+	if err := job.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			job.Cancel()
+			go func() {
+				if job.Wait(context.Background()) == nil {
+					os.Remove(processedFilePath)
+				}
+			}()
+		}
+		return "", err
+	}
+	return processedFilePath, nil
+}
+
+// tracedJob wraps a job's run function in a "job.<kind>" span that belongs to the
+// trace of the request that queued it (parent), so an upload's time waiting in
+// and running on the queue shows up under its request.
+func tracedJob(parent context.Context, kind string, run jobs.RunFunc) jobs.RunFunc {
+	return func(ctx context.Context, job *jobs.Job) (err error) {
+		ctx, span := telemetry.Start(telemetry.Detach(parent, ctx), "job."+kind,
+			trace.WithAttributes(attribute.String("job.id", job.ID.String())))
+		defer func() { telemetry.End(span, err) }()
+		return run(ctx, job)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/telemetry"
+	"github.com/google/uuid"
+)
+
+// recoverPanics turns a panicking handler into a 500 with the request ID,
+// instead of net/http's default of logging a bare stack and dropping the
+// connection. The crash is logged and sent to the error reporter. It goes inside
+// telemetry.Middleware, which assigns the request ID.
+func (cfg *apiConfig) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &panicResponseWriter{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// Handlers abort responses on purpose with this one:
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			requestID := telemetry.RequestID(r.Context())
+			stack := debug.Stack()
+			log.Printf("Panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, requestID, recovered, stack)
+			route := r.Pattern
+			if route == "" {
+				route = r.Method + " " + r.URL.Path
+			}
+			cfg.errorReporter.Report(r.Context(), errreport.Report{
+				Message:   fmt.Sprint(recovered),
+				Stack:     stack,
+				Time:      time.Now(),
+				RequestID: requestID,
+				Method:    r.Method,
+				Route:     route,
+			})
+
+			if rw.wroteHeader {
+				// Too late for an error response; cut the connection so the client
+				// doesn't take a truncated body for a complete one:
+				panic(http.ErrAbortHandler)
+			}
+			respondWithJSON(rw, http.StatusInternalServerError, errorResponse{
+				Code:      codeInternal,
+				Message:   "Internal server error",
+				Error:     "Internal server error",
+				RequestID: requestID,
+			})
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// reportJobPanics is the job queue's OnPanic hook, so crashes in background
+// processing reach the error reporter as well:
+func reportJobPanics(reporter errreport.Reporter) func(job *jobs.Job, recovered any, stack []byte) {
+	return func(job *jobs.Job, recovered any, stack []byte) {
+		tags := map[string]string{"job_id": job.ID.String(), "job_kind": job.Kind}
+		if job.VideoID != uuid.Nil {
+			tags["video_id"] = job.VideoID.String()
+		}
+		reporter.Report(context.Background(), errreport.Report{
+			Message: fmt.Sprint(recovered),
+			Stack:   stack,
+			Time:    time.Now(),
+			Tags:    tags,
+		})
+	}
+}
+
+// panicResponseWriter remembers whether the response has started:
+type panicResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *panicResponseWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *panicResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming handlers (job events) working through the wrapper:
+func (w *panicResponseWriter) Flush() {
+	w.wroteHeader = true
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the connection's deadlines:
+func (w *panicResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/flags"
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+)
+
+// envFile is the .env file settings are read from besides the environment:
+const envFile = ".env"
+
+// configAuditLimit is how many reloads GET /api/admin/config/audit returns:
+const configAuditLimit = 50
+
+// liveSettings are the settings that can change while the server runs: size
+// limits, upload rate limits and deadlines, the processing tiers, feature flags
+// and the codec policy. SIGHUP or POST /api/admin/config/reload swaps in a new
+// set; everything else in internal/config needs a restart. Handlers read them
+// through cfg.live(), so requests already in flight keep what they started with.
+type liveSettings struct {
+	// MultipartMaxMemory is how much of a multipart form ParseMultipartForm keeps
+	// in RAM before spilling file parts to disk; it does not limit the body size:
+	MultipartMaxMemory   int64
+	ThumbnailUploadLimit int64
+	// MaxDecompressedBody caps decompressed request bodies, see decompress.go:
+	MaxDecompressedBody int64
+	// size cut-offs for the processing queue tiers, see processingPriority:
+	JobSmallFileBytes int64
+	JobLargeFileBytes int64
+	// upload duration limits per user tier, see tiers.go:
+	TierLimits map[string]tierLimit
+	// per-route request deadlines, see timeouts.go:
+	Timeouts timeoutConfig
+	// uploads with codecs outside the allow-list are re-encoded, see codecs.go:
+	Codecs codecPolicy
+	// uploads are decoded once before processing, see decode_check.go:
+	DecodeCheck decodeCheckConfig
+	// feature switches (adaptive streaming, moderation, direct uploads...), see
+	// internal/flags:
+	Flags *flags.Set
+}
+
+func newLiveSettings(conf *config.Config, featureFlags *flags.Set) *liveSettings {
+	return &liveSettings{
+		MultipartMaxMemory:   conf.Uploads.MultipartMaxMemory,
+		ThumbnailUploadLimit: conf.Uploads.ThumbnailUploadLimit,
+		MaxDecompressedBody:  conf.Uploads.MaxDecompressedBody,
+		JobSmallFileBytes:    conf.Jobs.SmallFileBytes,
+		JobLargeFileBytes:    conf.Jobs.LargeFileBytes,
+		TierLimits:           tierLimitsFrom(conf.Tiers),
+		Timeouts:             timeoutConfig(conf.Timeouts),
+		Codecs:               codecPolicy(conf.Codecs),
+		DecodeCheck:          decodeCheckConfig(conf.DecodeCheck),
+		Flags:                featureFlags,
+	}
+}
+
+// live returns the current settings. Read them once per use rather than
+// holding on to them, so a reload takes effect.
+func (cfg *apiConfig) live() *liveSettings {
+	return cfg.settings.Load()
+}
+
+// values lists the settings by environment variable, for comparing two sets:
+func (s *liveSettings) values() map[string]string {
+	v := map[string]string{
+		"MULTIPART_MAX_MEMORY":     strconv.FormatInt(s.MultipartMaxMemory, 10),
+		"THUMBNAIL_UPLOAD_LIMIT":   strconv.FormatInt(s.ThumbnailUploadLimit, 10),
+		"MAX_DECOMPRESSED_BODY":    strconv.FormatInt(s.MaxDecompressedBody, 10),
+		"JOB_SMALL_FILE_BYTES":     strconv.FormatInt(s.JobSmallFileBytes, 10),
+		"JOB_LARGE_FILE_BYTES":     strconv.FormatInt(s.JobLargeFileBytes, 10),
+		"FREE_MAX_DURATION":        s.TierLimits[database.TierFree].MaxDuration.String(),
+		"FREE_MONTHLY_MINUTES":     strconv.Itoa(int(s.TierLimits[database.TierFree].MonthlyDuration.Minutes())),
+		"PRO_MAX_DURATION":         s.TierLimits[database.TierPro].MaxDuration.String(),
+		"PRO_MONTHLY_MINUTES":      strconv.Itoa(int(s.TierLimits[database.TierPro].MonthlyDuration.Minutes())),
+		"FREE_MONTHLY_TRANSFER_GB": strconv.FormatInt(s.TierLimits[database.TierFree].MonthlyTransfer>>30, 10),
+		"PRO_MONTHLY_TRANSFER_GB":  strconv.FormatInt(s.TierLimits[database.TierPro].MonthlyTransfer>>30, 10),
+		"API_READ_TIMEOUT":         s.Timeouts.Read.String(),
+		"API_WRITE_TIMEOUT":        s.Timeouts.Write.String(),
+		"UPLOAD_IDLE_TIMEOUT":      s.Timeouts.UploadIdle.String(),
+		"UPLOAD_MIN_RATE":          strconv.FormatInt(s.Timeouts.MinUploadRate, 10),
+		"UPLOAD_SLOW_WINDOW":       s.Timeouts.UploadSlowWindow.String(),
+		"PROCESSING_TIMEOUT":       s.Timeouts.Processing.String(),
+		"ALLOWED_VIDEO_CODECS":     strings.Join(s.Codecs.VideoCodecs, ","),
+		"ALLOWED_PIXEL_FORMATS":    strings.Join(s.Codecs.PixelFormats, ","),
+		"ALLOWED_AUDIO_CODECS":     strings.Join(s.Codecs.AudioCodecs, ","),
+		"AUTO_REENCODE":            strconv.FormatBool(s.Codecs.Reencode),
+		"DECODE_CHECK":             s.DecodeCheck.Mode,
+		"DECODE_CHECK_STRICT":      strconv.FormatBool(s.DecodeCheck.Strict),
+		"DECODE_CHECK_TIMEOUT":     s.DecodeCheck.Timeout.String(),
+	}
+	for _, flag := range s.Flags.All() {
+		v[strings.ToUpper(string(flag.Name))] = strconv.FormatBool(flag.Enabled)
+	}
+	return v
+}
+
+// diffSettings lists what changed from old to next, by setting name:
+func diffSettings(old, next *liveSettings) []database.ConfigChange {
+	oldValues, nextValues := old.values(), next.values()
+	changes := []database.ConfigChange{}
+	for name, value := range nextValues {
+		if oldValues[name] != value {
+			changes = append(changes, database.ConfigChange{Setting: name, Old: oldValues[name], New: value})
+		}
+	}
+	slices.SortFunc(changes, func(a, b database.ConfigChange) int {
+		return strings.Compare(a.Setting, b.Setting)
+	})
+	return changes
+}
+
+// reloadLookup looks settings up like startup did: the process environment
+// first, then the .env file, read again.
+func (cfg *apiConfig) reloadLookup() (func(string) (string, bool), error) {
+	fromFile, err := godotenv.Read(envFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("couldn't read %s: %w", envFile, err)
+	}
+	return func(name string) (string, bool) {
+		if value, ok := cfg.processEnv[name]; ok {
+			return value, true
+		}
+		value, ok := fromFile[name]
+		return value, ok
+	}, nil
+}
+
+// reloadConfig re-reads the settings and swaps in the live ones, recording
+// what changed, and who asked (actorID, uuid.Nil for a signal), in the config
+// audit. Invalid settings change nothing; the error is a *config.Error then.
+func (cfg *apiConfig) reloadConfig(ctx context.Context, source string, actorID uuid.UUID) ([]database.ConfigChange, error) {
+	// One reload at a time, so each diffs against what the last one stored:
+	cfg.reloadMu.Lock()
+	defer cfg.reloadMu.Unlock()
+
+	lookup, err := cfg.reloadLookup()
+	if err != nil {
+		return nil, err
+	}
+	conf, err := config.LoadFrom(lookup)
+	if err != nil {
+		return nil, err
+	}
+	featureFlags, err := flags.LoadFrom(conf.FeatureFlagsFile, lookup)
+	if err != nil {
+		return nil, err
+	}
+	// The HLS keys were set up at startup, for HLS without DASH:
+	if cfg.hlsKeyCipher != nil && (!featureFlags.Enabled(flags.EnableHLS) || featureFlags.Enabled(flags.EnableDASH)) {
+		return nil, errors.New("HLS_ENCRYPTION needs ENABLE_HLS=true and ENABLE_DASH=false")
+	}
+
+	next := newLiveSettings(conf, featureFlags)
+	changes := diffSettings(cfg.live(), next)
+	if len(changes) == 0 {
+		return changes, nil
+	}
+	cfg.settings.Store(next)
+
+	for _, change := range changes {
+		log.Printf("Config reload (%s): %s changed from %q to %q", source, change.Setting, change.Old, change.New)
+	}
+	// The settings are in place either way; the log has them if this fails:
+	err = cfg.db.CreateConfigAuditEntry(context.WithoutCancel(ctx), database.CreateConfigAuditEntryParams{
+		Source:  source,
+		ActorID: actorID,
+		Changes: changes,
+	})
+	if err != nil {
+		log.Printf("Couldn't record config reload in the audit: %v", err)
+	}
+	return changes, nil
+}
+
+// reloadOnSignal reloads the settings on every SIGHUP until ctx is done.
+func (cfg *apiConfig) reloadOnSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if _, err := cfg.reloadConfig(ctx, database.ConfigSourceSignal, uuid.Nil); err != nil {
+					log.Printf("Config reload on SIGHUP failed, nothing changed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// handlerAdminConfigReload is the SIGHUP reload for admins, who are recorded
+// as the ones making the change.
+func (cfg *apiConfig) handlerAdminConfigReload(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	changes, err := cfg.reloadConfig(r.Context(), database.ConfigSourceAdmin, user.ID)
+	if err != nil {
+		var confErr *config.Error
+		if errors.As(err, &confErr) {
+			fieldErrors := make([]fieldError, 0, len(confErr.Problems))
+			for _, problem := range confErr.Problems {
+				setting, _, _ := strings.Cut(problem, " ")
+				fieldErrors = append(fieldErrors, fieldError{Field: setting, Message: problem})
+			}
+			respondWithFieldErrors(w, fieldErrors)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]any{"changes": changes})
+}
+
+// handlerAdminConfigAudit lists the latest reloads that changed something.
+func (cfg *apiConfig) handlerAdminConfigAudit(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	entries, err := cfg.db.GetConfigAuditEntries(r.Context(), configAuditLimit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get config audit", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]any{"entries": entries})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/taskqueue"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+// remoteTranscodeConfig hands transcodes to separate worker processes
+// (cmd/tubely-worker) instead of running ffmpeg on the API server. JOB_BACKEND
+// picks the queue: "sqs" (SQS_QUEUE_URL) or "redis" (REDIS_URL).
+type remoteTranscodeConfig struct {
+	// Backend is "sqs" or "redis":
+	Backend string
+	Broker  taskqueue.Broker
+	// StagingPrefix is where raw uploads and processed files wait in the bucket
+	// (TRANSCODE_STAGING_PREFIX). Everything under it is deleted once the result
+	// is in, but a task abandoned halfway leaves files behind, so give the prefix
+	// a lifecycle expiration rule.
+	StagingPrefix string
+	// PollInterval is how often we look for the worker's result:
+	PollInterval time.Duration
+}
+
+// runRemoteTranscode stages the file, queues the task and waits for a worker to
+// finish it. The processed file is downloaded next to the input, where
+// transcode.Run would have left it, so the rest of the pipeline can't tell the
+// difference.
+func (cfg *apiConfig) runRemoteTranscode(ctx context.Context, video database.Video, inputFilePath string, task transcode.Task, onProgress transcode.ProgressFunc) (string, error) {
+	remote := cfg.remoteTranscode
+	msg := taskqueue.StagingKeys(remote.StagingPrefix, uuid.New())
+	msg.VideoID = video.ID
+	msg.Task = task
+
+	staged := []string{msg.InputKey, msg.OutputKey, msg.ResultKey}
+	if task.Kind == transcode.KindWatermark {
+		staged = append(staged, msg.LogoKey)
+	} else {
+		msg.LogoKey = ""
+	}
+	defer func() {
+		if err := cfg.store.Delete(context.WithoutCancel(ctx), staged...); err != nil {
+			log.Printf("Couldn't delete staged files of task %s: %v", msg.ID, err)
+		}
+	}()
+
+	input, err := cfg.tempCipher.open(inputFilePath)
+	if err != nil {
+		return "", fmt.Errorf("couldn't stage upload: %w", err)
+	}
+	defer input.Close()
+	if err := cfg.store.Put(ctx, msg.InputKey, input, storage.PutOptions{ContentType: "application/octet-stream"}); err != nil {
+		return "", fmt.Errorf("couldn't stage upload: %w", err)
+	}
+	if msg.LogoKey != "" {
+		if err := cfg.putStagingFile(ctx, msg.LogoKey, task.LogoPath); err != nil {
+			return "", fmt.Errorf("couldn't stage watermark: %w", err)
+		}
+	}
+	if _, err := cfg.transcodeStaged(ctx, msg, onProgress); err != nil {
+		return "", err
+	}
+
+	processedFilePath := fmt.Sprintf("%s.processing", inputFilePath)
+	if err := cfg.getStagingFile(ctx, msg.OutputKey, processedFilePath); err != nil {
+		os.Remove(processedFilePath)
+		return "", fmt.Errorf("couldn't download processed file: %w", err)
+	}
+	return processedFilePath, nil
+}
+
+// transcodeStaged queues a task whose files are already in the bucket and waits
+// for the worker's result. The caller removes the staged files.
+func (cfg *apiConfig) transcodeStaged(ctx context.Context, msg taskqueue.Message, onProgress transcode.ProgressFunc) (taskqueue.Result, error) {
+	if err := cfg.remoteTranscode.Broker.Send(ctx, msg); err != nil {
+		return taskqueue.Result{}, fmt.Errorf("couldn't queue transcode: %w", err)
+	}
+	result, err := cfg.waitForTranscodeResult(ctx, msg.ResultKey)
+	if err != nil {
+		return taskqueue.Result{}, err
+	}
+	if result.Error != "" {
+		return taskqueue.Result{}, fmt.Errorf("worker %s: %s", result.Worker, result.Error)
+	}
+	onProgress(100)
+	return result, nil
+}
+
+// waitForTranscodeResult polls for the result object until it shows up or ctx
+// is done:
+func (cfg *apiConfig) waitForTranscodeResult(ctx context.Context, key string) (taskqueue.Result, error) {
+	ticker := time.NewTicker(cfg.remoteTranscode.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return taskqueue.Result{}, ctx.Err()
+		case <-ticker.C:
+		}
+		body, err := cfg.store.Get(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Couldn't check for transcode result %s: %v", key, err)
+			continue
+		}
+		var result taskqueue.Result
+		err = json.NewDecoder(body).Decode(&result)
+		body.Close()
+		if err != nil {
+			return taskqueue.Result{}, fmt.Errorf("malformed transcode result: %w", err)
+		}
+		return result, nil
+	}
+}
+
+func (cfg *apiConfig) putStagingFile(ctx context.Context, key, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return cfg.store.Put(ctx, key, f, storage.PutOptions{ContentType: "application/octet-stream"})
+}
+
+func (cfg *apiConfig) getStagingFile(ctx context.Context, key, filePath string) error {
+	body, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err := bufpool.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
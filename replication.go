@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const jobKindReplicate = "replicate_object"
+
+// replicationBatchSize caps how many copies one retry sweep makes:
+const replicationBatchSize = 100
+
+// primaryHealthTTL is how long a primary bucket health check result is reused,
+// so playback requests don't each make a HeadBucket call:
+const primaryHealthTTL = 30 * time.Second
+
+// replicationConfig turns on copying every uploaded video object to a second
+// bucket, normally in another region (REPLICA_BUCKET, REPLICA_REGION). When the
+// primary bucket is unreachable, playback URLs point at the replica instead.
+type replicationConfig struct {
+	Replica *storage.S3Store
+	// CDN builds playback URLs for replica objects:
+	CDN cdn.CDN
+	// Interval between sweeps that retry pending and failed copies:
+	Interval time.Duration
+
+	mu          sync.Mutex
+	checkedAt   time.Time
+	primaryDown bool
+}
+
+// scheduleReplication queues the copy of a freshly stored object. The video is
+// marked pending first, so the retry sweep picks it up if the job never runs.
+func (cfg *apiConfig) scheduleReplication(ctx context.Context, video database.Video, key string) {
+	if cfg.replication == nil {
+		return
+	}
+	videoID := video.ID
+	if err := cfg.db.SetReplicationStatus(ctx, videoID, database.ReplicationPending, ""); err != nil {
+		log.Printf("Couldn't mark video %s for replication: %v", videoID, err)
+		return
+	}
+	_, err := cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindReplicate,
+		OwnerID:  video.UserID,
+		VideoID:  videoID,
+		Priority: jobs.PriorityLow,
+		Run: tracedJob(ctx, jobKindReplicate, func(ctx context.Context, job *jobs.Job) error {
+			return cfg.replicateObject(ctx, videoID, key)
+		}),
+	})
+	if err != nil {
+		log.Printf("Couldn't queue replication of video %s, the sweep will retry: %v", videoID, err)
+	}
+}
+
+// replicateObject copies key to the replica bucket and records the outcome:
+func (cfg *apiConfig) replicateObject(ctx context.Context, videoID uuid.UUID, key string) error {
+	s3Store, ok := cfg.store.(*storage.S3Store)
+	if !ok {
+		return nil
+	}
+	copyErr := cfg.replication.Replica.CopyFrom(ctx, s3Store.Bucket, key)
+	status, errMsg := database.ReplicationReplicated, ""
+	if copyErr != nil {
+		status, errMsg = database.ReplicationFailed, copyErr.Error()
+	}
+	if err := cfg.db.SetReplicationStatus(context.WithoutCancel(ctx), videoID, status, errMsg); err != nil {
+		log.Printf("Couldn't record replication of video %s: %v", videoID, err)
+	}
+	return copyErr
+}
+
+// startReplicationSweep retries pending and failed copies every Interval until
+// ctx is done:
+func (cfg *apiConfig) startReplicationSweep(ctx context.Context) {
+	if cfg.replication == nil || cfg.replication.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.replication.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pending, err := cfg.db.GetUnreplicated(ctx, replicationBatchSize)
+				if err != nil {
+					log.Printf("Replication sweep failed: %v", err)
+					continue
+				}
+				replicated := 0
+				for _, r := range pending {
+					if err := cfg.replicateObject(ctx, r.VideoID, r.ObjectKey); err != nil {
+						log.Printf("Couldn't replicate video %s: %v", r.VideoID, err)
+						continue
+					}
+					replicated++
+				}
+				if replicated > 0 {
+					log.Printf("Replication sweep copied %d objects", replicated)
+				}
+			}
+		}
+	}()
+}
+
+// deleteReplicas removes the replica copies of deleted objects. It's best
+// effort: a leftover copy costs storage but breaks nothing.
+func (cfg *apiConfig) deleteReplicas(ctx context.Context, keys ...string) {
+	if cfg.replication == nil || len(keys) == 0 {
+		return
+	}
+	if err := cfg.replication.Replica.Delete(ctx, keys...); err != nil {
+		log.Printf("Couldn't delete %d replica objects: %v", len(keys), err)
+		return
+	}
+	if err := cfg.replication.CDN.Invalidate(ctx, keys...); err != nil {
+		log.Printf("Couldn't invalidate %d replica objects in the CDN: %v", len(keys), err)
+	}
+}
+
+// primaryUnreachable reports whether the primary bucket failed its last health
+// check, re-checking at most every primaryHealthTTL.
+func (cfg *apiConfig) primaryUnreachable(ctx context.Context) bool {
+	s3Store, ok := cfg.store.(*storage.S3Store)
+	if cfg.replication == nil || !ok {
+		return false
+	}
+	r := cfg.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checkedAt) < primaryHealthTTL {
+		return r.primaryDown
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := s3Store.Ping(pingCtx)
+	if err != nil && ctx.Err() != nil {
+		// our caller gave up, that says nothing about the bucket:
+		return r.primaryDown
+	}
+	if err != nil && !r.primaryDown {
+		log.Printf("Primary bucket unreachable, serving replicas: %v", err)
+	} else if err == nil && r.primaryDown {
+		log.Printf("Primary bucket reachable again")
+	}
+	r.primaryDown = err != nil
+	r.checkedAt = time.Now()
+	return r.primaryDown
+}
+
+// withReplicaFallback points the video's URL at the replica when the primary
+// bucket is down and the object has been copied.
+func (cfg *apiConfig) withReplicaFallback(ctx context.Context, video database.Video) database.Video {
+	if video.VideoURL == nil || !cfg.primaryUnreachable(ctx) {
+		return video
+	}
+	replication, err := cfg.db.GetReplication(ctx, video.ID)
+	if err != nil || replication.Status != database.ReplicationReplicated {
+		return video
+	}
+	url := cfg.replication.CDN.PublicURL(replication.ObjectKey)
+	video.VideoURL = &url
+	return video
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/upload"
+	"github.com/google/uuid"
+)
+
+// Page sizes of GET /api/admin/videos:
+const (
+	defaultReprocessPageSize = 100
+	maxReprocessPageSize     = 1000
+)
+
+// reprocessSteps run a video's stored file back through the upload pipeline,
+// so videos stored before a processing change (the codec policy, packaging,
+// thumbnails, chapters) catch up with it. They're the upload steps without what
+// only belongs to a new upload: the file isn't held to, or counted against, its
+// owner's tier, the watermark it was stored with isn't burned in a second time,
+// and a failure leaves the video playing the file it had, with nothing
+// dead-lettered and nobody notified.
+type reprocessSteps struct {
+	uploadSteps
+}
+
+// reprocessor is the upload pipeline with reprocessSteps:
+func (cfg *apiConfig) reprocessor() *upload.Pipeline {
+	return upload.New(reprocessSteps{uploadSteps{cfg}})
+}
+
+// Begin marks the video processing, and ready again however it goes; the file
+// it had stays until a new one is stored.
+func (s reprocessSteps) Begin(ctx context.Context, video database.Video, staged upload.Staged) (database.Video, func(database.Video, error), error) {
+	settle, err := s.cfg.beginVideoStatus(ctx, video.ID, database.StatusProcessing, database.StatusReady)
+	if err != nil {
+		return database.Video{}, nil, videoStatusError(err)
+	}
+	video.MediaKind = mediaKindFor(staged.MediaType)
+	return video, func(video database.Video, err error) {
+		settle()
+		if err == nil {
+			s.cfg.hooks.Processed(ctx, video)
+		}
+	}, nil
+}
+
+// Probe only reads the aspect ratio; the file passed the checks when it was
+// uploaded.
+func (s reprocessSteps) Probe(ctx context.Context, video database.Video, staged upload.Staged) (upload.Probed, error) {
+	var probed upload.Probed
+	if video.MediaKind == mediaKindAudio || staged.AspectRatio != "" {
+		probed.AspectRatio = staged.AspectRatio
+		return probed, nil
+	}
+	source, closeSource, err := s.cfg.tempCipher.plainSource(staged.Path)
+	if err != nil {
+		return upload.Probed{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Could not read temp file", err}
+	}
+	defer closeSource()
+	probe, err := s.cfg.probeFile(ctx, source, staged.SHA256)
+	if err == nil {
+		probed.AspectRatio, err = aspectRatioFromProbe(probe)
+	}
+	if err != nil {
+		return upload.Probed{}, &pipelineError{http.StatusInternalServerError, codeProbeFailed, "Error determining aspect ratio", err}
+	}
+	return probed, nil
+}
+
+// Process is the upload's transcode step without the watermark, and without
+// counting the duration against the owner.
+func (s reprocessSteps) Process(ctx context.Context, video database.Video, staged upload.Staged, probed upload.Probed) (upload.Processed, error) {
+	source, closeSource, err := s.cfg.tempCipher.plainSource(staged.Path)
+	if err != nil {
+		return upload.Processed{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Could not read temp file", err}
+	}
+	task, err := s.cfg.transcodeTaskFor(ctx, video, staged.MediaType, source, staged.SHA256, false)
+	closeSource()
+	if err != nil {
+		return upload.Processed{}, err
+	}
+
+	processingStart := time.Now()
+	processedFilePath, err := s.cfg.runProcessingJob(ctx, video, staged.Path, task)
+	s.cfg.recordProcessingRun(ctx, video, processingStart, err)
+	if err != nil {
+		return upload.Processed{}, &pipelineError{http.StatusInternalServerError, codeProcessingFailed, "Error processing video", err}
+	}
+	return upload.Processed{Path: processedFilePath}, nil
+}
+
+// Store stores the new file like an upload's, keeping the one it replaces as a
+// version, so a reprocessing that made things worse can be undone.
+func (s reprocessSteps) Store(ctx context.Context, video database.Video, staged upload.Staged, probed upload.Probed, processed upload.Processed) (upload.Stored, error) {
+	video, processedHash, err := s.cfg.storeProcessedVideo(ctx, video, processed.Path, staged.MediaType, probed.AspectRatio, staged.Filename, upload.Metadata{}, database.VersionReprocessed)
+	if err != nil {
+		return upload.Stored{}, err
+	}
+	return upload.Stored{Video: video, Hash: processedHash}, nil
+}
+
+// Persist pulls the chapter markers embedded in the file for videos that have
+// none; chapters set since the upload are left alone. The staged file isn't the
+// raw upload, so its checksum isn't kept as the source's.
+func (s reprocessSteps) Persist(ctx context.Context, staged upload.Staged, processed upload.Processed, stored upload.Stored) (database.Video, error) {
+	video := stored.Video
+	if len(video.Chapters) == 0 {
+		s.cfg.saveEmbeddedChapters(ctx, &video, processed.Path, stored.Hash)
+	}
+	return video, nil
+}
+
+// reprocessVideo reads the video's stored file, at key, back and runs it
+// through reprocessor.
+func (cfg *apiConfig) reprocessVideo(ctx context.Context, video database.Video, key string) (database.Video, error) {
+	body, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		return database.Video{}, storageError(http.StatusBadGateway, "Couldn't read video from storage", err)
+	}
+	defer body.Close()
+
+	filename := ""
+	if video.OriginalFilename != nil {
+		filename = *video.OriginalFilename
+	}
+	// Stored keys end in the extension of the type they were stored as:
+	return cfg.reprocessor().Upload(ctx, video, upload.Source{
+		Body:        body,
+		Filename:    filename,
+		ContentType: mediaTypeFromExt(path.Ext(key)),
+	})
+}
+
+// handlerAdminVideoReprocess runs a video's stored file through the current
+// processing pipeline again, see reprocessSteps: POST
+// /api/admin/videos/{videoID}/reprocess. It answers once the new file is stored;
+// packaging and thumbnails follow in the background. cmd/tubely-backfill calls
+// it for every video.
+func (cfg *apiConfig) handlerAdminVideoReprocess(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithCode(w, http.StatusNotFound, codeNotFound, "Video not found", nil)
+		return
+	}
+	obj, err := cfg.db.GetVideoObject(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if obj.ObjectKey == "" {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video has no stored file", nil)
+		return
+	}
+	// An archived object can't be read until it's restored; this starts the restore:
+	if status, err := cfg.playbackStatus(r.Context(), video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video availability", err)
+		return
+	} else if status == playbackRestoring {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video is being restored from archive, try again later", nil)
+		return
+	}
+
+	video, err = cfg.reprocessVideo(r.Context(), video, obj.ObjectKey)
+	if err != nil {
+		respondWithPipelineError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerAdminReprocessableVideos pages through the videos
+// handlerAdminVideoReprocess takes, by ID: GET /api/admin/videos?after=<id>&limit=<n>.
+// The next page starts after the last ID of this one; an empty page is the end.
+func (cfg *apiConfig) handlerAdminReprocessableVideos(w http.ResponseWriter, r *http.Request) {
+	type reprocessableVideo struct {
+		ID        uuid.UUID `json:"id"`
+		UserID    uuid.UUID `json:"user_id"`
+		MediaKind string    `json:"media_kind"`
+		// HasStreams is whether the video has been packaged for HLS or DASH:
+		HasStreams bool `json:"has_streams"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	query := r.URL.Query()
+	var fieldErrors []fieldError
+	var after uuid.UUID
+	if s := query.Get("after"); s != "" {
+		var err error
+		if after, err = uuid.Parse(s); err != nil {
+			fieldErrors = append(fieldErrors, fieldError{"after", "Invalid after, expected a video ID"})
+		}
+	}
+	limit := defaultReprocessPageSize
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxReprocessPageSize {
+			fieldErrors = append(fieldErrors, fieldError{"limit", fmt.Sprintf("Invalid limit, expected 1 to %d", maxReprocessPageSize)})
+		}
+		limit = n
+	}
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, fieldErrors)
+		return
+	}
+
+	videos, err := cfg.db.GetReprocessableVideos(r.Context(), after, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list videos", err)
+		return
+	}
+	page := make([]reprocessableVideo, len(videos))
+	for i, v := range videos {
+		page[i] = reprocessableVideo{ID: v.VideoID, UserID: v.UserID, MediaKind: v.MediaKind, HasStreams: v.StreamPrefix != ""}
+	}
+	respondWithJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// testAdmin is testDB with a user made admin the way an operator does it, in
+// SQL, and the user's ID and access token.
+func testAdmin(t *testing.T, tokens *auth.KeySet) (database.Client, uuid.UUID, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tubely.db")
+	db, err := database.NewClient(path, database.PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, token := testUser(t, db, tokens)
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if _, err := raw.Exec("UPDATE users SET is_admin = TRUE WHERE id = ?", userID.String()); err != nil {
+		t.Fatal(err)
+	}
+	return db, userID, token
+}
+
+// uploadTestVideo uploads contents to a new video of the user's through cfg:
+func uploadTestVideo(t *testing.T, cfg *apiConfig, userID uuid.UUID, token string, contents []byte) database.Video {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(context.Background(), database.CreateVideoParams{Title: "Boots", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	cfg.handlerUploadVideo(rec, videoUploadRequest(t, video.ID, token, contents))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &video); err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+func TestAdminReprocessKeepsPriorFile(t *testing.T) {
+	ctx := context.Background()
+	tokens := testKeySet(t)
+	db, userID, token := testAdmin(t, tokens)
+	objects := newFakeObjectStore()
+	transcoder := &fakeTranscoder{}
+	cfg := newFakeUploadHandlers(t, uploadDeps{DB: db, Tokens: tokens, Objects: objects, Transcoder: transcoder})
+
+	video := uploadTestVideo(t, cfg, userID, token, []byte("stored before the codec policy"))
+	before, err := db.GetVideoObject(ctx, video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/videos/"+video.ID.String()+"/reprocess", nil)
+	req.SetPathValue("videoID", video.ID.String())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	cfg.handlerAdminVideoReprocess(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reprocess: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	after, err := db.GetVideoObject(ctx, video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.ObjectKey == before.ObjectKey {
+		t.Errorf("object key = %s, want a new file", after.ObjectKey)
+	}
+	versions, err := db.GetVideoVersions(ctx, video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].Reason != database.VersionReprocessed || versions[0].ObjectKey != before.ObjectKey {
+		t.Fatalf("versions = %+v, want the prior file kept as %q", versions, database.VersionReprocessed)
+	}
+	if _, ok := objects.objects[before.ObjectKey]; !ok {
+		t.Error("prior file was deleted from storage")
+	}
+	if got, _ := db.GetVideo(ctx, video.ID); got.Status != database.StatusReady {
+		t.Errorf("status = %q, want %q", got.Status, database.StatusReady)
+	}
+	if tasks := transcoder.ran(); len(tasks) != 2 {
+		t.Errorf("transcoder ran %d tasks, want 2", len(tasks))
+	}
+}
+
+func TestAdminReprocessableVideosPaging(t *testing.T) {
+	tokens := testKeySet(t)
+	db, userID, token := testAdmin(t, tokens)
+	cfg := newFakeUploadHandlers(t, uploadDeps{DB: db, Tokens: tokens})
+
+	uploaded := map[uuid.UUID]bool{}
+	for range 3 {
+		uploaded[uploadTestVideo(t, cfg, userID, token, []byte("boots")).ID] = true
+	}
+	// A video without a stored file isn't listed:
+	if _, err := db.CreateVideo(context.Background(), database.CreateVideoParams{Title: "Draft", UserID: userID}); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(query string) (int, []struct {
+		ID uuid.UUID `json:"id"`
+	}) {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/videos"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.handlerAdminReprocessableVideos(rec, req)
+		var page []struct {
+			ID uuid.UUID `json:"id"`
+		}
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, page
+	}
+
+	seen := map[uuid.UUID]bool{}
+	query := "?limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 2 {
+			t.Fatal("paging didn't end")
+		}
+		code, page := list(query)
+		if code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", query, code)
+		}
+		if len(page) > 2 {
+			t.Fatalf("%s: got %d videos, want at most 2", query, len(page))
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, v := range page {
+			if seen[v.ID] {
+				t.Errorf("%s listed twice", v.ID)
+			}
+			seen[v.ID] = true
+		}
+		query = "?limit=2&after=" + page[len(page)-1].ID.String()
+	}
+	if len(seen) != len(uploaded) {
+		t.Errorf("listed %d videos, want the %d uploaded", len(seen), len(uploaded))
+	}
+	for id := range seen {
+		if !uploaded[id] {
+			t.Errorf("listed %s, which has no stored file", id)
+		}
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=1001", "?after=boots"} {
+		if code, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, code)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Signed requests are for server-to-server callers that would rather not send
+// a credential at all. Instead of "Authorization: ApiKey <key>" they send
+//
+//	Authorization: HMAC-SHA256 <key ID>:<signature>
+//	X-Tubely-Timestamp: <unix seconds>
+//	X-Tubely-Content-SHA256: <hex SHA-256 of the body, or UNSIGNED-PAYLOAD>
+//
+// where the signature is the hex HMAC-SHA256, keyed with the key's signing
+// secret, of the method, escaped path, raw query, timestamp and body hash,
+// joined by newlines. The request then goes on as if the key itself had been
+// sent, scopes and all.
+const (
+	signedRequestScheme       = "HMAC-SHA256"
+	signedRequestTimestampHdr = "X-Tubely-Timestamp"
+	signedRequestBodyHashHdr  = "X-Tubely-Content-SHA256"
+	// unsignedPayload leaves the body out of the signature, for uploads too
+	// big to hold in memory while the hash is checked:
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// signedBodyLimit is the largest body that may be signed:
+	signedBodyLimit = 10 << 20
+)
+
+type requestSigningConfig struct {
+	// Secret derives each API key's signing secret; signed requests are off
+	// without it:
+	Secret string
+	// Window is how far a request's timestamp may be from the server's clock,
+	// either way; a signature is accepted once within it.
+	Window time.Duration
+}
+
+// seenSignatures holds the signatures accepted within the replay window, by
+// when they can be forgotten. It's per instance, like the window is per clock.
+var seenSignatures sync.Map
+
+// signingSecret is the secret the API key signs requests with. It's derived
+// rather than stored, so the database alone isn't enough to sign.
+func (c requestSigningConfig) signingSecret(keyID uuid.UUID) string {
+	mac := hmac.New(sha256.New, []byte(c.Secret))
+	mac.Write([]byte("api-key:" + keyID.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRequest returns the signature of a request, see above:
+func signRequest(secret, method, path, query, timestamp, bodyHash string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{method, path, query, timestamp, bodyHash}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isSignedRequest reports whether the request uses the signed scheme rather
+// than a token or key:
+func isSignedRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), signedRequestScheme+" ")
+}
+
+// verifySignedRequest returns the API key that signed the request, with the
+// request to carry on with: its body is read to check the hash, so the
+// returned one has it back. Errors are *pipelineError.
+func (cfg *apiConfig) verifySignedRequest(w http.ResponseWriter, r *http.Request) (*database.APIKey, *http.Request, error) {
+	if cfg.requestSigning.Secret == "" {
+		return nil, nil, &pipelineError{http.StatusUnauthorized, codeForStatus(http.StatusUnauthorized), "Signed requests aren't enabled", nil}
+	}
+	unauthorized := func(msg string) error {
+		return &pipelineError{http.StatusUnauthorized, codeForStatus(http.StatusUnauthorized), msg, nil}
+	}
+
+	credential := strings.TrimPrefix(r.Header.Get("Authorization"), signedRequestScheme+" ")
+	keyIDString, signature, ok := strings.Cut(strings.TrimSpace(credential), ":")
+	keyID, err := uuid.Parse(keyIDString)
+	if !ok || err != nil {
+		return nil, nil, unauthorized("Malformed signature, expected " + signedRequestScheme + " <key ID>:<signature>")
+	}
+	timestamp := r.Header.Get(signedRequestTimestampHdr)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, nil, unauthorized("Missing or malformed " + signedRequestTimestampHdr)
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > cfg.requestSigning.Window || skew < -cfg.requestSigning.Window {
+		return nil, nil, unauthorized("Signed request is too old, or the clock is off")
+	}
+	bodyHash := r.Header.Get(signedRequestBodyHashHdr)
+	if bodyHash == "" {
+		return nil, nil, unauthorized("Missing " + signedRequestBodyHashHdr)
+	}
+
+	apiKey, err := cfg.db.GetLiveAPIKey(r.Context(), keyID)
+	if err != nil {
+		return nil, nil, &pipelineError{http.StatusInternalServerError, codeForStatus(http.StatusInternalServerError), "Couldn't check API key", err}
+	}
+	if apiKey == nil {
+		return nil, nil, unauthorized("Invalid, revoked or expired API key")
+	}
+	want := signRequest(cfg.requestSigning.signingSecret(keyID), r.Method, r.URL.EscapedPath(), r.URL.RawQuery, timestamp, bodyHash)
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return nil, nil, unauthorized("Invalid signature")
+	}
+
+	if bodyHash != unsignedPayload {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, signedBodyLimit))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, nil, &pipelineError{http.StatusRequestEntityTooLarge, codeForStatus(http.StatusRequestEntityTooLarge), "Signed bodies are limited to 10 MB, sign bigger ones with " + unsignedPayload, err}
+			}
+			return nil, nil, &pipelineError{http.StatusBadRequest, codeForStatus(http.StatusBadRequest), "Couldn't read body", err}
+		}
+		sum := sha256.Sum256(body)
+		if !hmac.Equal([]byte(bodyHash), []byte(hex.EncodeToString(sum[:]))) {
+			return nil, nil, unauthorized("Body doesn't match " + signedRequestBodyHashHdr)
+		}
+		r = r.Clone(r.Context())
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	// Only now that it's known to be genuine, so a forger can't burn
+	// signatures nobody sent:
+	if !acceptSignature(signature, time.Unix(unix, 0).Add(cfg.requestSigning.Window)) {
+		return nil, nil, unauthorized("Signed request was already used")
+	}
+	return apiKey, r, nil
+}
+
+// acceptSignature records the signature until it expires, and reports whether
+// it's the first time it's seen. Expired ones are dropped along the way.
+func acceptSignature(signature string, expires time.Time) bool {
+	now := time.Now()
+	seenSignatures.Range(func(key, value any) bool {
+		if value.(time.Time).Before(now) {
+			seenSignatures.Delete(key)
+		}
+		return true
+	})
+	_, seen := seenSignatures.LoadOrStore(signature, expires)
+	return !seen
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/hooks"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/search"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+const jobKindSearchReindex = "search_reindex"
+
+// searchReindexPage is how many videos a reindex reads from the database at once:
+const searchReindexPage = 500
+
+// searchConfig is the OpenSearch index (OPENSEARCH_URL), if any; with Query
+// (SEARCH_BACKEND=opensearch), GET /api/videos/search reads from it instead of
+// SQLite's full-text index.
+type searchConfig struct {
+	Index *search.OpenSearch
+	Query bool
+}
+
+// searchIndexer is the hook that keeps the index up to date. Events only say
+// which video changed: it indexes the row as it is when the event is handled,
+// so events handled late or twice do no harm, and a video deleted since is
+// taken out of the index.
+type searchIndexer struct {
+	index  *search.OpenSearch
+	videos VideoStore
+}
+
+func (s searchIndexer) OnCreated(ctx context.Context, e hooks.Event)       { s.sync(ctx, e.VideoID) }
+func (s searchIndexer) OnUploadStarted(ctx context.Context, e hooks.Event) {}
+func (s searchIndexer) OnProcessed(ctx context.Context, e hooks.Event)     { s.sync(ctx, e.VideoID) }
+func (s searchIndexer) OnFailed(ctx context.Context, e hooks.Event)        {}
+func (s searchIndexer) OnUpdated(ctx context.Context, e hooks.Event)       { s.sync(ctx, e.VideoID) }
+
+func (s searchIndexer) OnDeleted(ctx context.Context, e hooks.Event) {
+	if err := s.index.Delete(ctx, e.VideoID); err != nil {
+		log.Printf("Couldn't remove video %s from the search index: %v", e.VideoID, err)
+	}
+}
+
+func (s searchIndexer) sync(ctx context.Context, videoID uuid.UUID) {
+	video, err := s.videos.GetVideo(ctx, videoID)
+	if err != nil {
+		log.Printf("Couldn't read video %s to index it: %v", videoID, err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		err = s.index.Delete(ctx, videoID)
+	} else {
+		err = s.index.Put(ctx, searchDocument(database.IndexedVideo{
+			ID:               video.ID,
+			UserID:           video.UserID,
+			Title:            video.Title,
+			Description:      video.Description,
+			Visibility:       video.Visibility,
+			ModerationStatus: video.ModerationStatus,
+			CreatedAt:        video.CreatedAt,
+			Tags:             video.Tags,
+		}))
+	}
+	if err != nil {
+		log.Printf("Couldn't index video %s: %v", videoID, err)
+	}
+}
+
+func searchDocument(v database.IndexedVideo) search.Document {
+	return search.Document{
+		ID:               v.ID,
+		UserID:           v.UserID,
+		Title:            v.Title,
+		Description:      v.Description,
+		Visibility:       v.Visibility,
+		ModerationStatus: v.ModerationStatus,
+		CreatedAt:        v.CreatedAt,
+		Tags:             v.Tags,
+	}
+}
+
+// searchIndexVideos finds videos through the OpenSearch index, then loads them
+// from the database. The index can lag behind, so the database has the last
+// word: videos deleted since, or no longer visible to the viewer, are left out.
+func (cfg *apiConfig) searchIndexVideos(ctx context.Context, params database.SearchVideosParams) ([]database.Video, error) {
+	ids, err := cfg.search.Index.Search(ctx, search.Query{
+		Text:             params.Query,
+		Tag:              params.Tag,
+		OwnerID:          params.OwnerID,
+		ViewerID:         params.ViewerID,
+		PublicModeration: database.ModerationApproved,
+		PublicVisibility: database.VisibilityPublic,
+		Limit:            params.Limit,
+		Offset:           params.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	videos := []database.Video{}
+	for _, id := range ids {
+		video, err := cfg.videos.GetVideo(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if video.ID == uuid.Nil {
+			continue
+		}
+		if params.OwnerID != uuid.Nil && video.UserID != params.OwnerID {
+			continue
+		}
+		public := video.ModerationStatus == database.ModerationApproved && video.Visibility == database.VisibilityPublic
+		if !public && (params.ViewerID == uuid.Nil || video.UserID != params.ViewerID) {
+			continue
+		}
+		videos = append(videos, video)
+	}
+	return videos, nil
+}
+
+// handlerAdminSearchReindex puts every video into the OpenSearch index, for
+// videos from before OPENSEARCH_URL was set, or an index that was lost. It runs
+// as a job; the response has its ID.
+func (cfg *apiConfig) handlerAdminSearchReindex(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if cfg.search.Index == nil {
+		respondWithError(w, http.StatusConflict, "No search index is configured", nil)
+		return
+	}
+
+	job, err := cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindSearchReindex,
+		OwnerID:  user.ID,
+		Priority: jobs.PriorityLow,
+		Run: tracedJob(r.Context(), jobKindSearchReindex, func(ctx context.Context, job *jobs.Job) error {
+			return cfg.reindexSearch(ctx, job.SetProgress)
+		}),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Couldn't queue reindexing", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, map[string]any{"job_id": job.ID})
+}
+
+// reindexSearch indexes the videos a page at a time; the total isn't known up
+// front, so progress only jumps to 100 at the end. Videos deleted before this
+// run aren't removed from the index, search skips them anyway. The first failure
+// stops the run, which is safe to repeat.
+func (cfg *apiConfig) reindexSearch(ctx context.Context, onProgress transcode.ProgressFunc) error {
+	indexed := 0
+	after := uuid.Nil
+	for {
+		videos, err := cfg.db.GetIndexedVideos(ctx, after, searchReindexPage)
+		if err != nil {
+			return err
+		}
+		for _, video := range videos {
+			if err := cfg.search.Index.Put(ctx, searchDocument(video)); err != nil {
+				return fmt.Errorf("couldn't index video %s: %w", video.ID, err)
+			}
+			indexed++
+		}
+		if len(videos) < searchReindexPage {
+			break
+		}
+		after = videos[len(videos)-1].ID
+	}
+	onProgress(100)
+	log.Printf("Indexed %d videos for search", indexed)
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// Cookie-mode sessions: a browser front-end logs in with {"use_cookies": true}
+// and gets the tokens as httpOnly cookies instead of in the response body, so
+// scripts (and any XSS) never see them.
+const (
+	accessCookieName  = "tubely_access"
+	refreshCookieName = "tubely_refresh"
+
+	accessTokenTTL       = time.Hour
+	refreshTokenTTL      = 60 * 24 * time.Hour
+	bearerAccessTokenTTL = 30 * 24 * time.Hour
+)
+
+// refreshCookiePaths are the endpoints that take a refresh token. The refresh
+// cookie is set once for each, so browsers send it there and nowhere else:
+var refreshCookiePaths = []string{"/api/refresh", "/api/revoke", "/api/logout"}
+
+// sessionCookieConfig comes from COOKIE_SAMESITE and COOKIE_SECURE:
+type sessionCookieConfig struct {
+	SameSite http.SameSite
+	Secure   bool
+}
+
+// parseSameSite maps the COOKIE_SAMESITE setting to its http.SameSite value:
+func parseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("unknown COOKIE_SAMESITE %q, expected lax, strict or none", value)
+}
+
+func (cfg *apiConfig) setAccessCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(accessTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.sessionCookies.Secure,
+		SameSite: cfg.sessionCookies.SameSite,
+	})
+}
+
+func (cfg *apiConfig) setRefreshCookie(w http.ResponseWriter, token string) {
+	for _, path := range refreshCookiePaths {
+		http.SetCookie(w, &http.Cookie{
+			Name:     refreshCookieName,
+			Value:    token,
+			Path:     path,
+			MaxAge:   int(refreshTokenTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   cfg.sessionCookies.Secure,
+			SameSite: cfg.sessionCookies.SameSite,
+		})
+	}
+}
+
+// clearSessionCookies expires the session cookies; the attributes have to
+// match the ones they were set with for browsers to drop them.
+func (cfg *apiConfig) clearSessionCookies(w http.ResponseWriter) {
+	cookies := []struct{ name, path string }{{accessCookieName, "/"}}
+	for _, path := range refreshCookiePaths {
+		cookies = append(cookies, struct{ name, path string }{refreshCookieName, path})
+	}
+	for _, c := range cookies {
+		http.SetCookie(w, &http.Cookie{
+			Name:     c.name,
+			Path:     c.path,
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   cfg.sessionCookies.Secure,
+			SameSite: cfg.sessionCookies.SameSite,
+		})
+	}
+}
+
+// refreshTokenFromRequest takes the refresh token from the Authorization header,
+// or failing that from the refresh cookie. fromCookie tells the caller which.
+func refreshTokenFromRequest(r *http.Request) (token string, fromCookie bool, err error) {
+	token, err = auth.GetBearerToken(r.Header)
+	if err == nil {
+		return token, false, nil
+	}
+	if c, cookieErr := r.Cookie(refreshCookieName); cookieErr == nil && c.Value != "" {
+		return c.Value, true, nil
+	}
+	return "", false, err
+}
+
+// refreshTokenPaths take a refresh token in the Authorization header, so the
+// access cookie mustn't be put there:
+var refreshTokenPaths = map[string]bool{
+	"/api/refresh": true,
+	"/api/revoke":  true,
+	"/api/logout":  true,
+}
+
+// sessionCookieMiddleware lets the access cookie stand in for a Bearer token:
+// when a request has no Authorization header, the cookie's token is copied into
+// one, so every handler keeps using auth.GetBearerToken. An explicit header
+// always wins.
+//
+// Cookies are sent by the browser on its own, so a cookie-authenticated request
+// that changes something must come from our own origin. With SameSite=None this
+// is the only CSRF protection.
+func (cfg *apiConfig) sessionCookieMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" || refreshTokenPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c, err := r.Cookie(accessCookieName)
+		if err != nil || c.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !isSafeMethod(r.Method) && !sameOrigin(r) {
+			respondWithError(w, http.StatusForbidden, "Cross-origin request rejected", nil)
+			return
+		}
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+c.Value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// sameOrigin reports whether the request's Origin (or, for older browsers,
+// Referer) names this host. Browsers always send one of them on cross-origin
+// POSTs, so a request with neither didn't come from another site's page.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// handlerLogout ends a session: the refresh token (cookie or Bearer) is revoked
+// and the session cookies are cleared. Logging out twice is fine.
+func (cfg *apiConfig) handlerLogout(w http.ResponseWriter, r *http.Request) {
+	refreshToken, fromCookie, err := refreshTokenFromRequest(r)
+	if err == nil {
+		if fromCookie && !sameOrigin(r) {
+			respondWithError(w, http.StatusForbidden, "Cross-origin request rejected", nil)
+			return
+		}
+		if err := cfg.db.RevokeRefreshToken(r.Context(), refreshToken); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+			return
+		}
+	}
+	cfg.clearSessionCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Share links last a week unless the owner asks otherwise, and never more than
+// 90 days; a link that should outlive that is a public video.
+const (
+	shareLinkDefaultTTL = 7 * 24 * time.Hour
+	shareLinkMaxTTL     = 90 * 24 * time.Hour
+)
+
+// hashShareToken is what's stored of a share token: looking links up by hash
+// means a leaked database doesn't leak working links.
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// hasShareLink reports whether the request carries a live share token for the
+// video, as ?token=.
+func (cfg *apiConfig) hasShareLink(r *http.Request, video database.Video) bool {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return false
+	}
+	ok, err := cfg.db.ValidShareLink(r.Context(), video.ID, hashShareToken(token), time.Now())
+	if err != nil {
+		log.Printf("Couldn't check share link of video %s: %v", video.ID, err)
+		return false
+	}
+	return ok
+}
+
+// canView reports whether the request may see the video. The owner and admins
+// always may; anyone else needs it approved, and, if it's private, a share link.
+func (cfg *apiConfig) canView(r *http.Request, video database.Video) bool {
+	if visibleTo(video, uuid.Nil) {
+		return true
+	}
+	if cfg.canSeeUnmoderated(r, video) {
+		return true
+	}
+	return video.ModerationStatus == database.ModerationApproved && cfg.hasShareLink(r, video)
+}
+
+// visibleTo reports whether viewerID (uuid.Nil for anonymous viewers) may see
+// the video without a share link.
+func visibleTo(video database.Video, viewerID uuid.UUID) bool {
+	if viewerID != uuid.Nil && video.UserID == viewerID {
+		return true
+	}
+	return video.ModerationStatus == database.ModerationApproved && video.Visibility != database.VisibilityPrivate
+}
+
+// ownedVideo loads the video named in the path for a request that must come
+// from its owner, and responds with the error if it can't.
+func (cfg *apiConfig) ownedVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := cfg.tokens.ValidateJWT(token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithCode(w, http.StatusUnauthorized, codeNotOwner, "Not authorized to update this video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// handlerShareLinkCreate makes a link to the video that works without logging
+// in: POST /api/videos/{videoID}/share-links {"expires_in_seconds": 86400}.
+// The token is only ever in this response; the link list shows the rest.
+func (cfg *apiConfig) handlerShareLinkCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresInSeconds *int64 `json:"expires_in_seconds"`
+	}
+	type response struct {
+		database.ShareLink
+		Token string `json:"token"`
+		// URL is the video's player page with the token in it:
+		URL string `json:"url"`
+	}
+
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	// The body is optional; no body means the default lifetime:
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	ttl := shareLinkDefaultTTL
+	if params.ExpiresInSeconds != nil {
+		ttl = time.Duration(*params.ExpiresInSeconds) * time.Second
+		if ttl <= 0 || ttl > shareLinkMaxTTL {
+			respondWithFieldErrors(w, []fieldError{{"expires_in_seconds", fmt.Sprintf("Invalid expiry, expected 1 to %d seconds", int64(shareLinkMaxTTL/time.Second))}})
+			return
+		}
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share token", err)
+		return
+	}
+	link, err := cfg.db.CreateShareLink(r.Context(), database.CreateShareLinkParams{
+		VideoID:   video.ID,
+		UserID:    video.UserID,
+		TokenHash: hashShareToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share link", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		ShareLink: link,
+		Token:     token,
+		URL:       cfg.embedURL(video.ID) + "?" + url.Values{"token": {token}}.Encode(),
+	})
+}
+
+// handlerShareLinksList lists the video's links, without their tokens.
+func (cfg *apiConfig) handlerShareLinksList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+	links, err := cfg.db.GetShareLinks(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share links", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, links)
+}
+
+// handlerShareLinkRevoke stops a link working. It stays in the list, marked
+// revoked.
+func (cfg *apiConfig) handlerShareLinkRevoke(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+	linkID, err := uuid.Parse(r.PathValue("linkID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid share link ID", err)
+		return
+	}
+	link, err := cfg.db.GetShareLink(r.Context(), linkID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+	if link.ID == uuid.Nil || link.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get share link", nil)
+		return
+	}
+	if err := cfg.db.RevokeShareLink(r.Context(), link.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke share link", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerVideoVisibility makes a video public or private:
+// PUT /api/videos/{videoID}/visibility {"visibility": "private"}. Share links
+// keep working either way.
+func (cfg *apiConfig) handlerVideoVisibility(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Visibility string `json:"visibility"`
+	}
+
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !validVisibility(params.Visibility) {
+		respondWithFieldErrors(w, []fieldError{{"visibility", `Invalid visibility, expected "public" or "private"`}})
+		return
+	}
+
+	if err := cfg.db.SetVideoVisibility(r.Context(), video.ID, params.Visibility); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	video.Visibility = params.Visibility
+	cfg.hooks.Updated(r.Context(), video)
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func validVisibility(visibility string) bool {
+	return visibility == database.VisibilityPublic || visibility == database.VisibilityPrivate
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// signedURLConfig is how long the URLs handed to clients stay valid, by use.
+// Each is signed for clockSkew longer than the expiry the client is told, so a
+// CDN or bucket whose clock is a little behind ours doesn't cut it short; S3
+// also dates its signatures clockSkew early, see storage.S3Store.
+type signedURLConfig struct {
+	// signPlayback signs the video URLs players get, see signPlaybackURLs:
+	signPlayback bool
+	playbackTTL  time.Duration
+	downloadTTL  time.Duration
+	uploadTTL    time.Duration
+	clockSkew    time.Duration
+}
+
+// signedURL signs key through the CDN for ttl, returning the URL with the
+// expiry the client should refresh it by.
+func (cfg *apiConfig) signedURL(ctx context.Context, key string, ttl time.Duration) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ttl).UTC()
+	url, err := cfg.cdn.SignedURL(ctx, key, ttl+cfg.signedURLs.clockSkew)
+	return url, expiresAt, err
+}
+
+// signPlaybackURLs swaps each video's URL for a signed one, with SIGN_PLAYBACK_URLS.
+// Only URLs of the video's own object are signed: HLS and DASH manifests and
+// replica fallbacks are left as they are. A failure leaves the URL unsigned.
+func (cfg *apiConfig) signPlaybackURLs(ctx context.Context, videos []database.Video) {
+	if !cfg.signedURLs.signPlayback || len(videos) == 0 {
+		return
+	}
+	ids := make([]uuid.UUID, len(videos))
+	for i, video := range videos {
+		ids[i] = video.ID
+	}
+	keys, err := cfg.db.VideoObjectKeys(ctx, ids)
+	if err != nil {
+		log.Printf("Couldn't get object keys to sign playback URLs: %v", err)
+		return
+	}
+	for i := range videos {
+		key := keys[videos[i].ID]
+		if key == "" || videos[i].VideoURL == nil || *videos[i].VideoURL != cfg.cdn.PublicURL(key) {
+			continue
+		}
+		url, expiresAt, err := cfg.signedURL(ctx, key, cfg.signedURLs.playbackTTL)
+		if err != nil {
+			log.Printf("Couldn't sign playback URL of video %s: %v", videos[i].ID, err)
+			continue
+		}
+		videos[i].VideoURL = &url
+		videos[i].VideoURLExpiresAt = &expiresAt
+	}
+}
+
+// handlerVideoDownload returns a signed URL of the video's file, valid for
+// SIGNED_URL_DOWNLOAD_TTL, whether or not playback URLs are signed. Who may
+// download is who may see the video.
+func (cfg *apiConfig) handlerVideoDownload(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, err := cfg.videos.GetVideo(r.Context(), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !cfg.canView(r, video) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	obj, err := cfg.db.GetVideoObject(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.VideoURL == nil || obj.ObjectKey == "" {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video has no uploaded content", nil)
+		return
+	}
+	// An archived object can't be read until it's restored; this starts the restore:
+	if status, err := cfg.playbackStatus(r.Context(), video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video availability", err)
+		return
+	} else if status == playbackRestoring {
+		respondWithCode(w, http.StatusConflict, codeConflict, "Video is being restored from archive, try again later", nil)
+		return
+	}
+
+	url, expiresAt, err := cfg.signedURL(r.Context(), obj.ObjectKey, cfg.signedURLs.downloadTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign download URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{URL: url, ExpiresAt: expiresAt})
+}
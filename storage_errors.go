@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// storageRetryAfter is the Retry-After sent when the bucket is throttling us, in
+// seconds:
+const storageRetryAfter = "5"
+
+// storageError describes a failed storage call for the client. Failures with a
+// known cause (see internal/storage/errors.go) get their own status and code;
+// the rest get status and codeStorageFailed. msg says what was being done.
+func storageError(status int, msg string, err error) *pipelineError {
+	switch {
+	case errors.Is(err, storage.ErrBucketNotFound):
+		// Ours to fix: the bucket in S3_BUCKET is gone or misspelled.
+		return &pipelineError{http.StatusInternalServerError, codeStorageNoBucket, msg + ": storage bucket doesn't exist", err}
+	case errors.Is(err, storage.ErrAccessDenied):
+		// Ours too: the credentials or the bucket policy are wrong.
+		return &pipelineError{http.StatusInternalServerError, codeStorageDenied, msg + ": access to storage was denied", err}
+	case errors.Is(err, storage.ErrThrottled):
+		return &pipelineError{http.StatusServiceUnavailable, codeStorageThrottled, msg + ": storage is busy, try again shortly", err}
+	case errors.Is(err, storage.ErrTooLarge):
+		return &pipelineError{http.StatusRequestEntityTooLarge, codeFileTooLarge, msg + ": file is too large for storage", err}
+	case errors.Is(err, storage.ErrTimeout):
+		return &pipelineError{http.StatusGatewayTimeout, codeStorageTimeout, msg + ": storage timed out", err}
+	}
+	return &pipelineError{status, codeStorageFailed, msg, err}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// maxVideoTags and maxTagLength keep tags short labels, not a second
+	// description:
+	maxVideoTags = 10
+	maxTagLength = 30
+
+	defaultPopularTags = 20
+	maxPopularTags     = 100
+)
+
+// normalizeTag lowercases a tag and collapses its runs of whitespace, so that
+// "Go  Lang" and "go lang" are the same tag.
+func normalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+// validTag reports whether a normalized tag is letters, digits, spaces, '-' and
+// '_' only; that leaves no commas, which the search reindex joins tags with.
+func validTag(tag string) bool {
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// handlerVideoTagsUpdate replaces the tags on one of the caller's videos:
+// PUT /api/videos/{videoID}/tags {"tags": ["cooking", "Go"]}. Tags are stored
+// lowercased; an empty list removes them all.
+func (cfg *apiConfig) handlerVideoTagsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tags []string `json:"tags"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Tags) > maxVideoTags {
+		respondWithFieldErrors(w, []fieldError{{"tags", fmt.Sprintf("Too many tags, the limit is %d", maxVideoTags)}})
+		return
+	}
+	var fieldErrors []fieldError
+	tags := make([]string, 0, len(params.Tags))
+	seen := map[string]int{}
+	for i, raw := range params.Tags {
+		field := fmt.Sprintf("tags[%d]", i)
+		tag := normalizeTag(raw)
+		switch {
+		case tag == "":
+			fieldErrors = append(fieldErrors, fieldError{field, "Can't be empty"})
+		case len([]rune(tag)) > maxTagLength:
+			fieldErrors = append(fieldErrors, fieldError{field, fmt.Sprintf("Too long, the limit is %d characters", maxTagLength)})
+		case !validTag(tag):
+			fieldErrors = append(fieldErrors, fieldError{field, "Only letters, digits, spaces, '-' and '_' are allowed"})
+		default:
+			if j, ok := seen[tag]; ok {
+				fieldErrors = append(fieldErrors, fieldError{field, fmt.Sprintf("Duplicate of tags[%d]", j)})
+				continue
+			}
+			seen[tag] = i
+			tags = append(tags, tag)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, fieldErrors)
+		return
+	}
+
+	tags, err := cfg.db.SetVideoTags(r.Context(), video.ID, tags)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save tags", err)
+		return
+	}
+	video.Tags = tags
+	cfg.hooks.Updated(r.Context(), video)
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerTagsPopular lists the tags on the most videos anyone can see, with
+// their counts: GET /api/tags/popular?limit=20.
+func (cfg *apiConfig) handlerTagsPopular(w http.ResponseWriter, r *http.Request) {
+	limit := defaultPopularTags
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPopularTags {
+			respondWithFieldErrors(w, []fieldError{{"limit", fmt.Sprintf("Invalid limit, expected 1 to %d", maxPopularTags)}})
+			return
+		}
+		limit = n
+	}
+
+	tags, err := cfg.db.PopularTags(r.Context(), limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get tags", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, tags)
+}
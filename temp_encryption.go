@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+)
+
+// With UPLOAD_TMP_ENCRYPTION_KEY set, raw uploads are AES-CTR encrypted while
+// they wait in UPLOAD_TMP_DIR, so on a shared host the media never sits on disk
+// in the clear. Go code reads them through tempCipher.open; ffmpeg and ffprobe,
+// which want a path, read them through a decrypting named pipe. A pipe can't
+// seek, so MP4s have to be fast-start (moov before mdat) to be processed. The
+// processed file ffmpeg writes isn't covered: it's the copy that's stored.
+//
+// A nil *tempCipher is encryption turned off; its methods then read and write
+// the files as they are.
+type tempCipher struct {
+	block cipher.Block
+	key   []byte
+}
+
+func newTempCipher(encoded string) (*tempCipher, error) {
+	if !haveNamedPipes {
+		return nil, errors.New("UPLOAD_TMP_ENCRYPTION_KEY needs named pipes, which this platform doesn't have")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("UPLOAD_TMP_ENCRYPTION_KEY isn't valid base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("UPLOAD_TMP_ENCRYPTION_KEY must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &tempCipher{block: block, key: key}, nil
+}
+
+// stream is the keystream of the file at path from offset on. The IV comes
+// from the file's name, which is random, rather than a header, so an encrypted
+// file is as long as its plaintext: the disk space checks and resumable upload
+// offsets work the same either way.
+func (c *tempCipher) stream(path string, offset int64) cipher.Stream {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(filepath.Base(path)))
+	iv := mac.Sum(nil)[:aes.BlockSize]
+
+	// CTR counts blocks on from the IV as one big-endian number:
+	lo := binary.BigEndian.Uint64(iv[8:])
+	hi := binary.BigEndian.Uint64(iv[:8])
+	next := lo + uint64(offset/aes.BlockSize)
+	if next < lo {
+		hi++
+	}
+	binary.BigEndian.PutUint64(iv[:8], hi)
+	binary.BigEndian.PutUint64(iv[8:], next)
+	s := cipher.NewCTR(c.block, iv)
+	// Then into the block:
+	skip := make([]byte, offset%aes.BlockSize)
+	s.XORKeyStream(skip, skip)
+	return s
+}
+
+// writer encrypts what's written to f from offset on; f's position has to be
+// at offset.
+func (c *tempCipher) writer(f *os.File, offset int64) io.Writer {
+	if c == nil {
+		return f
+	}
+	return cipher.StreamWriter{S: c.stream(f.Name(), offset), W: f}
+}
+
+// tempFileReader reads a temp file written through tempCipher.writer.
+type tempFileReader struct {
+	f *os.File
+	c *tempCipher
+	s cipher.Stream
+}
+
+func (c *tempCipher) open(path string) (*tempFileReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &tempFileReader{f: f, c: c}
+	if c != nil {
+		r.s = c.stream(path, 0)
+	}
+	return r, nil
+}
+
+func (r *tempFileReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	if r.c != nil {
+		r.s.XORKeyStream(p[:n], p[:n])
+	}
+	return n, err
+}
+
+func (r *tempFileReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.f.Seek(offset, whence)
+	if err == nil && r.c != nil {
+		r.s = r.c.stream(r.f.Name(), pos)
+	}
+	return pos, err
+}
+
+func (r *tempFileReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.f.ReadAt(p, off)
+	if r.c != nil {
+		r.c.stream(r.f.Name(), off).XORKeyStream(p[:n], p[:n])
+	}
+	return n, err
+}
+
+func (r *tempFileReader) Close() error {
+	return r.f.Close()
+}
+
+// plainSource returns a path ffmpeg and ffprobe can read the temp file at, and
+// a func to call once they're done with it: the file itself, or a decrypting
+// pipe next to it.
+func (c *tempCipher) plainSource(path string) (string, func(), error) {
+	if c == nil {
+		return path, func() {}, nil
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", nil, err
+	}
+	p := &decryptingPipe{
+		path:    path + ".pipe-" + hex.EncodeToString(suffix),
+		source:  path,
+		cipher:  c,
+		closing: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := makeNamedPipe(p.path); err != nil {
+		return "", nil, err
+	}
+	go p.serve()
+	return p.path, p.close, nil
+}
+
+// decryptingPipe feeds the plaintext of an encrypted temp file to whoever
+// opens the named pipe at path. Each reader gets the whole file from the start:
+// as soon as one has the pipe open, it's swapped for a fresh one for the next.
+// Readers take turns, as the pipeline's ffprobe and ffmpeg runs do; two at
+// once could end up sharing a pipe.
+type decryptingPipe struct {
+	path    string
+	source  string
+	cipher  *tempCipher
+	closing chan struct{}
+	stopped chan struct{}
+}
+
+func (p *decryptingPipe) serve() {
+	defer close(p.stopped)
+	for {
+		// Blocks until a reader opens the pipe, or close pretends to be one:
+		w, err := os.OpenFile(p.path, os.O_WRONLY, 0)
+		if err != nil {
+			log.Printf("Couldn't open decrypting pipe %s: %v", p.path, err)
+			return
+		}
+		select {
+		case <-p.closing:
+			w.Close()
+			return
+		default:
+		}
+		os.Remove(p.path)
+		if err := makeNamedPipe(p.path); err != nil {
+			w.Close()
+			log.Printf("Couldn't replace decrypting pipe %s: %v", p.path, err)
+			return
+		}
+		go p.feed(w)
+	}
+}
+
+// feed writes the plaintext to one reader. Readers like ffprobe stop early,
+// which ends the write with EPIPE; that's not a failure.
+func (p *decryptingPipe) feed(w *os.File) {
+	defer w.Close()
+	r, err := p.cipher.open(p.source)
+	if err != nil {
+		log.Printf("Couldn't open %s for its decrypting pipe: %v", p.source, err)
+		return
+	}
+	defer r.Close()
+	bufpool.Copy(w, r)
+}
+
+func (p *decryptingPipe) close() {
+	close(p.closing)
+	// serve may be waiting for a reader, or between pipes; knock until it's gone:
+	for {
+		unblockNamedPipe(p.path)
+		select {
+		case <-p.stopped:
+			os.Remove(p.path)
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// lacksFastStart reports whether the upload is an MP4 that isn't fast-start,
+// when that matters: with encryption, ffmpeg reads it through a pipe and can't
+// seek back from the end for the moov box.
+func (c *tempCipher) lacksFastStart(path, mediaType string) (bool, error) {
+	if c == nil || (mediaType != "video/mp4" && mediaType != "audio/mp4") {
+		return false, nil
+	}
+	r, err := c.open(path)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	first, err := moovFirst(r)
+	return !first, err
+}
+
+// moovFirst walks the MP4's top-level boxes for whether moov comes before
+// mdat. A file without either isn't for this to judge; ffmpeg will.
+func moovFirst(r io.ReaderAt) (bool, error) {
+	var offset int64
+	header := make([]byte, 16)
+	for {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return true, nil
+			}
+			return false, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		switch string(header[4:8]) {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+		switch size {
+		case 0:
+			// The box runs to the end of the file:
+			return true, nil
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return true, nil
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if size < 8 {
+			return true, nil
+		}
+		offset += size
+	}
+}
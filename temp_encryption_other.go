@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// Named pipes, and so encrypted temp files, are unix-only:
+const haveNamedPipes = false
+
+func makeNamedPipe(path string) error {
+	return errors.New("named pipes aren't supported on this platform")
+}
+
+func unblockNamedPipe(path string) {}
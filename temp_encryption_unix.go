@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const haveNamedPipes = true
+
+func makeNamedPipe(path string) error {
+	return syscall.Mkfifo(path, 0600)
+}
+
+// unblockNamedPipe opens the pipe for reading without waiting for a writer,
+// which lets a writer waiting for a reader through.
+func unblockNamedPipe(path string) {
+	if f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+		f.Close()
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/images"
+)
+
+// thumbnailWidths are the sizes every thumbnail is scaled to, for front-ends to
+// pick from with srcset: small for lists, large for the detail view.
+var thumbnailWidths = []int{320, 640, 1280}
+
+// makeThumbnailVariants scales the video's thumbnail, whose file is at
+// sourceDiskPath, to each of thumbnailWidths and stores them, returning the
+// video with its Thumbnails set. Images are never scaled up, so a small one's
+// variants can be the same size. The variants are a nicety: when they can't be
+// made, that's logged and the video goes on with just ThumbnailURL.
+func (cfg *apiConfig) makeThumbnailVariants(ctx context.Context, video database.Video, sourceDiskPath string) database.Video {
+	if video.ThumbnailURL == nil {
+		return video
+	}
+	var diskPaths []string
+	removeAll := func() {
+		for _, diskPath := range diskPaths {
+			os.Remove(diskPath)
+		}
+	}
+	thumbnails, err := cfg.writeThumbnailVariants(sourceDiskPath, &diskPaths)
+	if err != nil {
+		removeAll()
+		log.Printf("Couldn't make thumbnail variants for video %s: %v", video.ID, err)
+		return video
+	}
+	stored, err := cfg.db.SetVideoThumbnails(ctx, video.ID, *video.ThumbnailURL, thumbnails)
+	if err != nil || !stored {
+		// Replaced or deleted meanwhile; the newer thumbnail gets its own:
+		removeAll()
+		if err != nil {
+			log.Printf("Couldn't save thumbnail variants for video %s: %v", video.ID, err)
+		}
+		return video
+	}
+	video.Thumbnails = thumbnails
+	return video
+}
+
+// writeThumbnailVariants writes the scaled copies as assets in the source's
+// format, adding each file to diskPaths as it's created.
+func (cfg *apiConfig) writeThumbnailVariants(sourceDiskPath string, diskPaths *[]string) (database.Thumbnails, error) {
+	src, err := os.Open(sourceDiskPath)
+	if err != nil {
+		return nil, err
+	}
+	img, format, err := images.Decode(src)
+	src.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	thumbnails := database.Thumbnails{}
+	for _, width := range thumbnailWidths {
+		w := min(width, b.Dx())
+		h := max(1, (b.Dy()*w+b.Dx()/2)/b.Dx())
+
+		assetPath := getAssetPath("image/" + format)
+		diskPath := cfg.getAssetDiskPath(assetPath)
+		dst, err := os.Create(diskPath)
+		if err != nil {
+			return nil, err
+		}
+		*diskPaths = append(*diskPaths, diskPath)
+		err = images.Encode(dst, images.Resize(img, w, h), format)
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		thumbnails[strconv.Itoa(width)] = cfg.getAssetURL(assetPath)
+	}
+	return thumbnails, nil
+}
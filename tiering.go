@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// tieringBatchSize caps how many videos one policy run tags:
+const tieringBatchSize = 500
+
+// tieringConfig controls when videos count as cold and what happens to them.
+// Tiering only works on stores implementing storage.Tiering (S3).
+type tieringConfig struct {
+	// ColdAfter is the look-back window: a video older than this with at most
+	// MaxViews views inside it is cold.
+	ColdAfter   time.Duration
+	MaxViews    int
+	Lifecycle   storage.LifecyclePolicy
+	RestoreDays int32
+	// Interval between background policy runs; 0 disables them.
+	Interval time.Duration
+}
+
+// runTieringPolicy tags one batch of cold videos for the lifecycle rule and
+// returns how many it tagged.
+func (cfg *apiConfig) runTieringPolicy(ctx context.Context) (int, error) {
+	tiering, ok := cfg.store.(storage.Tiering)
+	if !ok {
+		return 0, nil
+	}
+
+	since := time.Now().Add(-cfg.tiering.ColdAfter)
+	candidates, err := cfg.db.GetColdVideoCandidates(ctx, since, cfg.tiering.MaxViews, tieringBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	tagged := 0
+	for _, candidate := range candidates {
+		if err := tiering.SetCold(ctx, candidate.ObjectKey, true); err != nil {
+			log.Printf("Couldn't tag %s as cold: %v", candidate.ObjectKey, err)
+			continue
+		}
+		if err := cfg.db.SetVideoStorageTier(ctx, candidate.VideoID, database.StorageTierCold); err != nil {
+			return tagged, err
+		}
+		tagged++
+	}
+	return tagged, nil
+}
+
+// startTieringPolicy runs the policy every Interval until ctx is done:
+func (cfg *apiConfig) startTieringPolicy(ctx context.Context) {
+	if cfg.tiering.Interval <= 0 {
+		return
+	}
+	if _, ok := cfg.store.(storage.Tiering); !ok {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.tiering.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				tagged, err := cfg.runTieringPolicy(ctx)
+				if err != nil {
+					log.Printf("Tiering policy failed: %v", err)
+				} else if tagged > 0 {
+					log.Printf("Tiering policy tagged %d cold videos", tagged)
+				}
+			}
+		}
+	}()
+}
+
+// Playback states reported on video responses:
+const (
+	playbackAvailable = "available"
+	playbackRestoring = "restoring"
+)
+
+// playbackStatus checks whether a cold video's object is readable and, if it has
+// been archived, kicks off a restore.
+func (cfg *apiConfig) playbackStatus(ctx context.Context, video database.Video) (string, error) {
+	tiering, ok := cfg.store.(storage.Tiering)
+	if !ok || video.VideoURL == nil {
+		return playbackAvailable, nil
+	}
+	obj, err := cfg.db.GetVideoObject(ctx, video.ID)
+	if err != nil {
+		return "", err
+	}
+	if obj.StorageTier != database.StorageTierCold || obj.ObjectKey == "" {
+		return playbackAvailable, nil
+	}
+
+	state, err := tiering.ArchiveState(ctx, obj.ObjectKey)
+	if err != nil {
+		return "", err
+	}
+	switch state {
+	case storage.ArchiveStateArchived:
+		if err := tiering.Restore(ctx, obj.ObjectKey, cfg.tiering.RestoreDays); err != nil {
+			return "", err
+		}
+		log.Printf("Restoring archived video %s", video.ID)
+		return playbackRestoring, nil
+	case storage.ArchiveStateRestoring:
+		return playbackRestoring, nil
+	}
+	return playbackAvailable, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// tierLimit is what a user tier may upload. Zero means no limit.
+type tierLimit struct {
+	// MaxDuration is the longest video (or audio post) one upload may be:
+	MaxDuration time.Duration
+	// MonthlyDuration is how much media may be processed per calendar month (UTC):
+	MonthlyDuration time.Duration
+	// MonthlyTransfer is how many bytes may be uploaded and streamed through the
+	// API per calendar month (UTC), see bandwidth.go:
+	MonthlyTransfer int64
+}
+
+// tierLimitsFrom maps FREE_MAX_DURATION, FREE_MONTHLY_MINUTES,
+// FREE_MONTHLY_TRANSFER_GB and their PRO_ counterparts onto the tiers:
+func tierLimitsFrom(conf config.Tiers) map[string]tierLimit {
+	return map[string]tierLimit{
+		database.TierFree: {
+			MaxDuration:     conf.FreeMaxDuration,
+			MonthlyDuration: conf.FreeMonthlyDuration,
+			MonthlyTransfer: conf.FreeMonthlyTransfer,
+		},
+		database.TierPro: {
+			MaxDuration:     conf.ProMaxDuration,
+			MonthlyDuration: conf.ProMonthlyDuration,
+			MonthlyTransfer: conf.ProMonthlyTransfer,
+		},
+	}
+}
+
+// monthStart is when the month of t began, in UTC:
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// checkUploadLimits probes the upload's duration and checks it, and the
+// month's transfer, against the owner's tier, before any time goes into
+// transcoding it. It returns the duration, to be recorded once processing
+// succeeds. hash is the upload's SHA-256, if known, for the probe cache.
+func (cfg *apiConfig) checkUploadLimits(ctx context.Context, userID uuid.UUID, filePath, hash string) (time.Duration, error) {
+	user, err := cfg.db.GetUser(ctx, userID)
+	if err != nil || user == nil {
+		return 0, &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't get user", err}
+	}
+	limit := cfg.tierLimitOf(user)
+	// The upload's own bytes were counted as they arrived:
+	if err := cfg.checkTransfer(ctx, user); err != nil {
+		return 0, err
+	}
+
+	probe, err := cfg.probeFile(ctx, filePath, hash)
+	if err != nil {
+		return 0, &pipelineError{http.StatusInternalServerError, codeProbeFailed, "Error determining duration", err}
+	}
+	duration, err := durationFromProbe(probe)
+	if err != nil {
+		return 0, &pipelineError{http.StatusBadRequest, codeProbeFailed, "Couldn't determine the file's duration", err}
+	}
+
+	if limit.MaxDuration > 0 && duration > limit.MaxDuration {
+		return 0, &pipelineError{http.StatusForbidden, codeDurationLimit,
+			fmt.Sprintf("Uploads on the %s tier can be at most %s long, this one is %s", user.Tier, limit.MaxDuration, duration.Round(time.Second)), nil}
+	}
+	if limit.MonthlyDuration > 0 {
+		used, err := cfg.db.GetUploadUsageSince(ctx, userID, monthStart(time.Now()))
+		if err != nil {
+			return 0, &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't get upload usage", err}
+		}
+		if used+duration > limit.MonthlyDuration {
+			left := max(limit.MonthlyDuration-used, 0)
+			return 0, &pipelineError{http.StatusPaymentRequired, codeUploadQuota,
+				fmt.Sprintf("The %s tier allows %d minutes of uploads a month, %d are left", user.Tier, int(limit.MonthlyDuration.Minutes()), int(left.Minutes())), nil}
+		}
+	}
+	return duration, nil
+}
+
+// recordUploadUsage counts processed media against the owner's monthly limit.
+// A failure only means the upload is free, so it's logged.
+func (cfg *apiConfig) recordUploadUsage(ctx context.Context, video database.Video, duration time.Duration) {
+	if err := cfg.db.RecordUploadUsage(ctx, video.UserID, video.ID, duration); err != nil {
+		log.Printf("Couldn't record upload usage of video %s: %v", video.ID, err)
+	}
+}
+
+// handlerAdminSetUserTier moves a user to another tier, with {"tier": "pro"}.
+func (cfg *apiConfig) handlerAdminSetUserTier(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tier string `json:"tier"`
+	}
+
+	if _, ok := cfg.requireAdmin(w, r); !ok {
+		return
+	}
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if _, ok := cfg.live().TierLimits[params.Tier]; !ok {
+		respondWithFieldErrors(w, []fieldError{{"tier", fmt.Sprintf("Tier must be %q or %q", database.TierFree, database.TierPro)}})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	if err := cfg.db.SetUserTier(r.Context(), userID, params.Tier); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update tier", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]any{"user_id": userID, "tier": params.Tier})
+}
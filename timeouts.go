@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// timeoutConfig bounds requests per route class, instead of one server-wide
+// ReadTimeout/WriteTimeout that would either cut off big uploads or leave
+// everything else unbounded. The deadlines are set per request through
+// http.ResponseController. Zero means no deadline.
+//
+// A read deadline outlives the body: once it passes, net/http's background read
+// of the connection fails and cancels the request's context. So it bounds the
+// whole handler, and handlers that work long after reading are given a later one.
+type timeoutConfig struct {
+	// Read and Write bound ordinary API calls (API_READ_TIMEOUT, API_WRITE_TIMEOUT):
+	Read  time.Duration
+	Write time.Duration
+	// UploadIdle is how long an upload body may stall (UPLOAD_IDLE_TIMEOUT);
+	// the deadline moves forward as bytes arrive, so a slow but steady upload
+	// never hits it.
+	UploadIdle time.Duration
+	// MinUploadRate is the slowest an upload body may arrive, in bytes/s, averaged
+	// over UploadSlowWindow (UPLOAD_MIN_RATE, UPLOAD_SLOW_WINDOW); zero disables
+	// the check. See uploadRateReader.
+	MinUploadRate    int64
+	UploadSlowWindow time.Duration
+	// Processing is how long a handler may take once it's processing a file in
+	// the request (PROCESSING_TIMEOUT):
+	Processing time.Duration
+}
+
+// deadlineExtendInterval keeps an upload from setting its deadline on every
+// small read (with a short UPLOAD_IDLE_TIMEOUT it's half of that instead):
+const deadlineExtendInterval = time.Second
+
+// setDeadlines sets the request's read and write deadlines, read and write from
+// now. Writers that can't set them (http.ErrNotSupported) just don't get them.
+func setDeadlines(w http.ResponseWriter, read, write time.Duration) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(deadlineAfter(read))
+	rc.SetWriteDeadline(deadlineAfter(write))
+}
+
+// deadlineAfter is now+d, or no deadline for d <= 0:
+func deadlineAfter(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// apiDeadlines is the default for every route. The deadlines are set on every
+// request, since on a kept-alive connection they'd otherwise carry over from the
+// previous one.
+func (cfg *apiConfig) apiDeadlines(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeouts := cfg.live().Timeouts
+		setDeadlines(w, timeouts.Read, timeouts.Write)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// uploadDeadlines is for routes that take a file in the body. Both deadlines
+// start at UploadIdle and move forward while the body keeps coming; the handler
+// calls extendForProcessing once it has the file. The body's throughput is
+// recorded on the request's span, and a body slower than MinUploadRate fails.
+func (cfg *apiConfig) uploadDeadlines(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeouts := cfg.live().Timeouts
+		setDeadlines(w, timeouts.UploadIdle, timeouts.UploadIdle)
+		rate := newUploadRateReader(r.Body, timeouts.MinUploadRate, timeouts.UploadSlowWindow)
+		defer rate.record(r)
+		r.Body = rate
+		if timeouts.UploadIdle > 0 {
+			r.Body = &idleDeadlineReader{
+				ReadCloser: r.Body,
+				w:          w,
+				idle:       timeouts.UploadIdle,
+				extendedAt: time.Now(),
+			}
+		}
+		next(w, r)
+	}
+}
+
+// processingDeadlines is for routes that do heavy work without a big body, like
+// fetching and transcoding a URL or extracting a frame:
+func (cfg *apiConfig) processingDeadlines(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeouts := cfg.live().Timeouts
+		setDeadlines(w, timeouts.Processing, timeouts.Processing)
+		next(w, r)
+	}
+}
+
+// streamingDeadlines is for responses that last as long as the client wants
+// them to: media downloads and event streams.
+func streamingDeadlines(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setDeadlines(w, 0, 0)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// extendForProcessing gives an upload handler PROCESSING_TIMEOUT to finish
+// once the body is in.
+func (cfg *apiConfig) extendForProcessing(w http.ResponseWriter) {
+	timeouts := cfg.live().Timeouts
+	setDeadlines(w, timeouts.Processing, timeouts.Processing)
+}
+
+// idleDeadlineReader pushes the request's deadlines idle into the future every
+// time body bytes arrive:
+type idleDeadlineReader struct {
+	io.ReadCloser
+	w          http.ResponseWriter
+	idle       time.Duration
+	extendedAt time.Time
+}
+
+func (r *idleDeadlineReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && time.Since(r.extendedAt) >= min(deadlineExtendInterval, r.idle/2) {
+		r.extendedAt = time.Now()
+		setDeadlines(r.w, r.idle, r.idle)
+	}
+	return n, err
+}
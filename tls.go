@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Server timeouts. Request bodies get no overall read deadline, since a 1 GB
+// upload on a slow link takes as long as it takes; only the headers are bounded.
+const (
+	serverReadHeaderTimeout = 10 * time.Second
+	serverIdleTimeout       = 2 * time.Minute
+)
+
+// tlsSettings is how the server terminates TLS itself, for running without a
+// proxy in front. Either TLS_CERT_FILE and TLS_KEY_FILE name a certificate, or
+// TLS_AUTOCERT_DOMAINS lists the host names to get Let's Encrypt certificates
+// for. HTTP/2 comes with TLS. It's config.TLS, checked and defaulted there.
+type tlsSettings struct {
+	CertFile string
+	KeyFile  string
+
+	AutocertDomains []string
+	// AutocertCacheDir keeps issued certificates across restarts, so they aren't
+	// requested again (Let's Encrypt rate-limits that):
+	AutocertCacheDir string
+	AutocertEmail    string
+
+	// RedirectAddr, when set, serves plain HTTP that redirects to HTTPS. With
+	// autocert it also answers the ACME HTTP-01 challenges, so it has to be
+	// reachable on port 80 then.
+	RedirectAddr string
+}
+
+func (t tlsSettings) enabled() bool {
+	return t.CertFile != "" || len(t.AutocertDomains) > 0
+}
+
+// newServer builds the API server with timeouts that suit streamed bodies:
+// bounded headers and idle keep-alives, but no deadline on reading an upload or
+// writing a download.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		IdleTimeout:       serverIdleTimeout,
+	}
+}
+
+// listenAndServe serves srv over HTTPS when TLS is configured, plain HTTP
+// otherwise.
+func listenAndServe(srv *http.Server, t tlsSettings) error {
+	if !t.enabled() {
+		return srv.ListenAndServe()
+	}
+
+	_, port, _ := net.SplitHostPort(srv.Addr)
+	redirect := redirectToHTTPS(port)
+	if len(t.AutocertDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(t.AutocertDomains...),
+			Cache:      autocert.DirCache(t.AutocertCacheDir),
+			Email:      t.AutocertEmail,
+		}
+		// Also answers TLS-ALPN-01 challenges and offers h2:
+		srv.TLSConfig = manager.TLSConfig()
+		redirect = manager.HTTPHandler(nil)
+	} else {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if t.RedirectAddr != "" {
+		go func() {
+			err := newServer(t.RedirectAddr, redirect).ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("HTTP redirect listener on %s stopped: %v", t.RedirectAddr, err)
+			}
+		}()
+	}
+	// With autocert, the certificate comes from TLSConfig.GetCertificate:
+	return srv.ListenAndServeTLS(t.CertFile, t.KeyFile)
+}
+
+// redirectToHTTPS sends requests to the same host on the TLS port:
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
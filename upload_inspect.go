@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os/exec"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+)
+
+// uploadInspection is what we learn about a raw upload while copying it to disk.
+// Doing it during the copy saves a full extra read of the file (and an ffprobe
+// run) before processing can start.
+type uploadInspection struct {
+	Size   int64
+	SHA256 string
+	// AspectRatio is "" when the streamed probe couldn't tell, e.g. for an MP4 with
+	// its moov atom after the media data; the pipeline then probes the file.
+	AspectRatio string
+}
+
+// errProbeDone closes the probe's pipe once ffprobe has exited:
+var errProbeDone = errors.New("ffprobe finished")
+
+// copyAndInspect copies src to dst, hashing the bytes on the way and, for video,
+// feeding them to ffprobe on stdin at the same time.
+func copyAndInspect(ctx context.Context, dst io.Writer, src io.Reader, mediaType string) (*uploadInspection, error) {
+	hasher := sha256.New()
+	writers := []io.Writer{dst, hasher}
+
+	var probe *streamProbe
+	if mediaKindFor(mediaType) == mediaKindVideo {
+		probe = startStreamProbe(ctx)
+		writers = append(writers, probe)
+	}
+
+	n, err := bufpool.Copy(io.MultiWriter(writers...), src)
+	inspection := &uploadInspection{
+		Size:   n,
+		SHA256: hex.EncodeToString(hasher.Sum(nil)),
+	}
+	if probe != nil {
+		// A failed probe isn't fatal, the pipeline falls back to probing the file:
+		inspection.AspectRatio, _ = probe.finish(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inspection, nil
+}
+
+// streamProbe runs ffprobe on a copy of the upload fed through a pipe. ffprobe
+// stops reading as soon as it has seen the stream headers, so writes never fail:
+// once it's gone, the rest of the upload is just not passed on.
+type streamProbe struct {
+	pw      *io.PipeWriter
+	stopped bool
+	stdout  *bytes.Buffer
+	done    chan struct{}
+	err     error
+}
+
+func startStreamProbe(ctx context.Context) *streamProbe {
+	pr, pw := io.Pipe()
+	p := &streamProbe{pw: pw, stdout: bufpool.GetBuffer(), done: make(chan struct{})}
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_streams",
+		"-i", "pipe:0",
+	)
+	cmd.Stdin = pr
+	cmd.Stdout = p.stdout
+	go func() {
+		defer close(p.done)
+		p.err = cmd.Run()
+		// Unblock (and fail) any write still waiting for ffprobe to read:
+		pr.CloseWithError(errProbeDone)
+	}()
+	return p
+}
+
+func (p *streamProbe) Write(b []byte) (int, error) {
+	if !p.stopped {
+		if _, err := p.pw.Write(b); err != nil {
+			p.stopped = true
+		}
+	}
+	return len(b), nil
+}
+
+// finish signals the end of the upload (or copyErr, if it failed) and returns
+// the probed aspect ratio.
+func (p *streamProbe) finish(copyErr error) (string, error) {
+	if copyErr != nil {
+		p.pw.CloseWithError(copyErr)
+	} else {
+		p.pw.Close()
+	}
+	<-p.done
+	defer bufpool.PutBuffer(p.stdout)
+	if p.err != nil {
+		return "", p.err
+	}
+	return aspectRatioFromProbe(p.stdout.Bytes())
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// uploadLimiter bounds how many video uploads this instance takes in at once
+// (UPLOAD_CONCURRENCY, the CPU count by default), so a burst of them can't fill
+// the temp disk or starve ffmpeg of CPU. An upload over the limit waits up to
+// UPLOAD_QUEUE_WAIT for a slot, then gets a 503 with a Retry-After. A nil
+// limiter takes any number.
+type uploadLimiter struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// uploadLimitRetryAfter is the Retry-After sent with an upload turned away for
+// want of a slot:
+const uploadLimitRetryAfter = 10 * time.Second
+
+// newUploadLimiter returns nil, no limit, for n = 0.
+func newUploadLimiter(n int, wait time.Duration) *uploadLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &uploadLimiter{slots: make(chan struct{}, n), wait: wait}
+}
+
+// acquire takes a slot, waiting up to the queue wait for one, and reports
+// whether it got one; release it once the upload is done with.
+func (l *uploadLimiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.wait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (l *uploadLimiter) release() {
+	<-l.slots
+}
+
+// limitUploads holds a slot of cfg.uploadLimiter for the length of the request,
+// processing included, for the routes that take a video in and transcode it.
+func (cfg *apiConfig) limitUploads(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.uploadLimiter == nil {
+			next(w, r)
+			return
+		}
+		if !cfg.uploadLimiter.acquire(r) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(uploadLimitRetryAfter.Seconds())))
+			respondWithCode(w, http.StatusServiceUnavailable, codeUnavailable, "Too many uploads in progress, try again later", nil)
+			return
+		}
+		defer cfg.uploadLimiter.release()
+		next(w, r)
+	}
+}
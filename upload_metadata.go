@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/upload"
+)
+
+// uploadMetadataFields are the form fields a video upload may carry next to
+// its "video" part:
+var uploadMetadataFields = []string{"title", "description", "visibility"}
+
+// maxUploadMetadataField bounds one metadata field:
+const maxUploadMetadataField = 64 << 10
+
+// uploadMetadataFrom takes the metadata from the form's values and checks it.
+// It's saved with the new file, in the same transaction, see publishVideo.
+func uploadMetadataFrom(values map[string][]string) (upload.Metadata, []fieldError) {
+	var meta upload.Metadata
+	var fieldErrors []fieldError
+	for _, field := range uploadMetadataFields {
+		if v := values[field]; len(v) > 0 && len(v[0]) > maxUploadMetadataField {
+			fieldErrors = append(fieldErrors, fieldError{field, fmt.Sprintf("Can't be longer than %d bytes", maxUploadMetadataField)})
+		}
+	}
+	if v, ok := values["title"]; ok && len(v) > 0 {
+		title := strings.TrimSpace(v[0])
+		if title == "" {
+			fieldErrors = append(fieldErrors, fieldError{"title", "Title can't be empty"})
+		}
+		meta.Title = &title
+	}
+	if v, ok := values["description"]; ok && len(v) > 0 {
+		meta.Description = &v[0]
+	}
+	if v, ok := values["visibility"]; ok && len(v) > 0 {
+		if !validVisibility(v[0]) {
+			fieldErrors = append(fieldErrors, fieldError{"visibility", `Invalid visibility, expected "public" or "private"`})
+		}
+		meta.Visibility = &v[0]
+	}
+	return meta, fieldErrors
+}
+
+// readMetadataPart reads a metadata field of a streamed form into values,
+// up to a byte past the limit so uploadMetadataFrom still sees it's too long.
+// Parts that aren't one are left unread.
+func readMetadataPart(part *multipart.Part, values map[string][]string) error {
+	name := part.FormName()
+	if part.FileName() != "" || !slices.Contains(uploadMetadataFields, name) {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(part, maxUploadMetadataField+1))
+	if err != nil {
+		return err
+	}
+	values[name] = append(values[name], string(data))
+	return nil
+}
+
+// readStreamedVideoForm reads a video upload's form up to its "video" part,
+// which it returns for the caller to stream, along with the metadata fields
+// sent before it. Those have to come first to be seen. It responds itself when
+// the form is no good.
+func readStreamedVideoForm(w http.ResponseWriter, r *http.Request) (*multipart.Part, upload.Metadata, bool) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+		return nil, upload.Metadata{}, false
+	}
+	values := map[string][]string{}
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			respondWithError(w, http.StatusBadRequest, "Unable to parse form file", errors.New("no video part"))
+			return nil, upload.Metadata{}, false
+		}
+		if err != nil {
+			if !respondIfUploadTooSlow(w, err) {
+				respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+			}
+			return nil, upload.Metadata{}, false
+		}
+		if p.FormName() == "video" {
+			meta, fieldErrors := uploadMetadataFrom(values)
+			if len(fieldErrors) > 0 {
+				respondWithFieldErrors(w, fieldErrors)
+				return nil, upload.Metadata{}, false
+			}
+			return p, meta, true
+		}
+		if err := readMetadataPart(p, values); err != nil {
+			if !respondIfUploadTooSlow(w, err) {
+				respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+			}
+			return nil, upload.Metadata{}, false
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/upload"
+)
+
+// uploads is the upload pipeline (internal/upload) with the API server's steps.
+// Every way of getting a video onto the server (multipart upload, resumable
+// upload, URL import, direct upload, clips, dead-letter re-drives) funnels
+// through it, so they all behave the same.
+func (cfg *apiConfig) uploads() *upload.Pipeline {
+	return upload.New(uploadSteps{cfg})
+}
+
+// uploadSteps are the pipeline's steps: the upload is checked, probed, run
+// through a transcode job, stored and published. Errors are *pipelineError,
+// for respondWithPipelineError.
+type uploadSteps struct {
+	cfg *apiConfig
+}
+
+var _ upload.Steps = uploadSteps{}
+
+// Validate takes MP4 video, and MP3, M4A and Ogg audio for podcast episodes:
+func (s uploadSteps) Validate(ctx context.Context, src upload.Source) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(src.ContentType)
+	if err != nil {
+		return "", &pipelineError{http.StatusBadRequest, codeInvalidMIME, "Invalid Content-Type", err}
+	}
+	if !isAllowedUploadType(mediaType) {
+		return "", &pipelineError{http.StatusBadRequest, codeInvalidMIME, "Invalid file type, only MP4 video or MP3, M4A and Ogg audio are allowed", nil}
+	}
+	return mediaType, nil
+}
+
+// Stage writes the upload to a temp file in UPLOAD_TMP_DIR, encrypted when
+// TEMP_ENCRYPTION is on. copyAndInspect hashes and probes the bytes on their
+// way through, so the probe step doesn't have to re-read the file before it can
+// start. The error of a failed copy is the reader's, as it is, for the entry
+// point to tell a client that sent too much, or too slowly, from a failure of
+// ours.
+func (s uploadSteps) Stage(ctx context.Context, src upload.Source, mediaType string) (upload.Staged, error) {
+	tempFile, err := os.CreateTemp(s.cfg.uploadTmpDir, "tubely-upload"+mediaTypeToExt(mediaType))
+	if err != nil {
+		return upload.Staged{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Could not create temp file", err}
+	}
+	defer tempFile.Close()
+	inspection, err := copyAndInspect(ctx, s.cfg.tempCipher.writer(tempFile, 0), src.Body, mediaType)
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return upload.Staged{}, err
+	}
+	return upload.Staged{
+		Path:        tempFile.Name(),
+		MediaType:   mediaType,
+		Filename:    src.Filename,
+		Metadata:    src.Metadata,
+		Size:        inspection.Size,
+		SHA256:      inspection.SHA256,
+		AspectRatio: inspection.AspectRatio,
+	}, nil
+}
+
+// Begin marks the video processing until it's stored (ready) or the pipeline
+// fails; it's only marked failed if it has no older file to fall back on. The
+// outcome is announced, and a step that failed on every retry leaves the
+// upload for an admin to re-drive, see dead_letters.go.
+func (s uploadSteps) Begin(ctx context.Context, video database.Video, staged upload.Staged) (database.Video, func(database.Video, error), error) {
+	settle, err := s.cfg.beginVideoStatus(ctx, video.ID, database.StatusProcessing, database.StatusFailed)
+	if err != nil {
+		return database.Video{}, nil, videoStatusError(err)
+	}
+	// Audio posts skip the aspect-ratio and watermark steps and live under audio/:
+	video.MediaKind = mediaKindFor(staged.MediaType)
+	return video, func(video database.Video, err error) {
+		s.cfg.notifyProcessed(ctx, video, err)
+		settle()
+		s.cfg.deadLetterUpload(ctx, video, err, staged.Path, staged.MediaType, staged.Filename, staged.Metadata)
+	}, nil
+}
+
+// Probe reads the aspect ratio, unless the probe that ran while the file was
+// staged already found it, and holds the upload to its owner's tier limits and
+// the decode check before a transcode is spent on it.
+func (s uploadSteps) Probe(ctx context.Context, video database.Video, staged upload.Staged) (upload.Probed, error) {
+	// An encrypted upload reaches ffprobe and ffmpeg through a decrypting pipe,
+	// which they can't seek; see temp_encryption.go:
+	notFastStart, err := s.cfg.tempCipher.lacksFastStart(staged.Path, staged.MediaType)
+	if err != nil {
+		return upload.Probed{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Could not read temp file", err}
+	}
+	if notFastStart {
+		return upload.Probed{}, &pipelineError{http.StatusUnprocessableEntity, codeValidationFailed, "MP4 uploads have to be fast-start, with the moov atom before the media data", nil}
+	}
+	source, closeSource, err := s.cfg.tempCipher.plainSource(staged.Path)
+	if err != nil {
+		return upload.Probed{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Could not read temp file", err}
+	}
+	defer closeSource()
+
+	// The aspect ratio goes into the key in the prefix layout; audio has none. A
+	// re-upload of the same file hits the probe cache, keyed by the staged hash:
+	var probed upload.Probed
+	if video.MediaKind != mediaKindAudio {
+		probed.AspectRatio = staged.AspectRatio
+		if probed.AspectRatio == "" {
+			probe, err := s.cfg.probeFile(ctx, source, staged.SHA256)
+			if err == nil {
+				probed.AspectRatio, err = aspectRatioFromProbe(probe)
+			}
+			if err != nil {
+				return upload.Probed{}, &pipelineError{http.StatusInternalServerError, codeProbeFailed, "Error determining aspect ratio", err}
+			}
+		}
+	}
+
+	probed.Duration, err = s.cfg.checkUploadLimits(ctx, video.UserID, source, staged.SHA256)
+	if err != nil {
+		return upload.Probed{}, err
+	}
+	// Catch truncated and corrupt files before they're transcoded and stored:
+	if err := s.cfg.checkDecodable(ctx, source, probed.Duration); err != nil {
+		return upload.Probed{}, err
+	}
+	return probed, nil
+}
+
+// Process runs the transcode step (see transcodeTaskFor) on the shared job
+// queue, prioritised by file size, so a short clip doesn't wait behind
+// hour-long transcodes.
+func (s uploadSteps) Process(ctx context.Context, video database.Video, staged upload.Staged, probed upload.Probed) (upload.Processed, error) {
+	source, closeSource, err := s.cfg.tempCipher.plainSource(staged.Path)
+	if err != nil {
+		return upload.Processed{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Could not read temp file", err}
+	}
+	task, err := s.cfg.transcodeTaskFor(ctx, video, staged.MediaType, source, staged.SHA256, true)
+	closeSource()
+	if err != nil {
+		return upload.Processed{}, err
+	}
+
+	processingStart := time.Now()
+	processedFilePath, err := s.cfg.runProcessingJob(ctx, video, staged.Path, task)
+	s.cfg.recordProcessingRun(ctx, video, processingStart, err)
+	if err != nil {
+		return upload.Processed{}, &pipelineError{http.StatusInternalServerError, codeProcessingFailed, "Error processing video", err}
+	}
+	s.cfg.recordUploadUsage(ctx, video, probed.Duration)
+	return upload.Processed{Path: processedFilePath}, nil
+}
+
+// Store stores the processed file and publishes the video, see
+// storeProcessedVideo. The file it played until now, if any, is kept as a
+// version.
+func (s uploadSteps) Store(ctx context.Context, video database.Video, staged upload.Staged, probed upload.Probed, processed upload.Processed) (upload.Stored, error) {
+	video, processedHash, err := s.cfg.storeProcessedVideo(ctx, video, processed.Path, staged.MediaType, probed.AspectRatio, sanitizeFilename(staged.Filename), staged.Metadata, database.VersionReplaced)
+	if err != nil {
+		return upload.Stored{}, err
+	}
+	return upload.Stored{Video: video, Hash: processedHash}, nil
+}
+
+// Persist keeps the raw upload's checksum and size, to tell later whether a
+// re-upload is the same file, and pulls any chapter markers embedded in the
+// MP4 so players can show them. Neither fails the upload.
+func (s uploadSteps) Persist(ctx context.Context, staged upload.Staged, processed upload.Processed, stored upload.Stored) (database.Video, error) {
+	video := stored.Video
+	if staged.SHA256 != "" {
+		if err := s.cfg.db.SetVideoSource(ctx, video.ID, staged.SHA256, staged.Size); err != nil {
+			log.Printf("Couldn't record source checksum for video %s: %v", video.ID, err)
+		}
+	}
+	s.cfg.saveEmbeddedChapters(ctx, &video, processed.Path, stored.Hash)
+	return video, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errUploadTooSlow is returned by upload body reads once the client has sent
+// less than UPLOAD_MIN_RATE for a whole UPLOAD_SLOW_WINDOW.
+var errUploadTooSlow = errors.New("upload too slow")
+
+// uploadRateReader measures an upload body's throughput. UPLOAD_IDLE_TIMEOUT
+// only catches a body that stops; a client trickling in a few bytes at a time
+// would keep it from firing and pin a handler and its temp file for hours. So
+// with a floor set, the body is cut off once a window's worth of reads averages
+// below it.
+type uploadRateReader struct {
+	io.ReadCloser
+	floor  int64
+	window time.Duration
+
+	start       time.Time
+	total       int64
+	windowStart time.Time
+	windowBytes int64
+	err         error
+}
+
+func newUploadRateReader(body io.ReadCloser, floor int64, window time.Duration) *uploadRateReader {
+	now := time.Now()
+	return &uploadRateReader{
+		ReadCloser:  body,
+		floor:       floor,
+		window:      window,
+		start:       now,
+		windowStart: now,
+	}
+}
+
+func (r *uploadRateReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.total += int64(n)
+	r.windowBytes += int64(n)
+	if r.floor <= 0 || r.window <= 0 || err != nil {
+		return n, err
+	}
+	now := time.Now()
+	if elapsed := now.Sub(r.windowStart); elapsed >= r.window {
+		rate := float64(r.windowBytes) / elapsed.Seconds()
+		if rate < float64(r.floor) {
+			r.err = fmt.Errorf("%w: %.0f bytes/s over the last %s, below the %d bytes/s minimum",
+				errUploadTooSlow, rate, elapsed.Round(time.Second), r.floor)
+			return n, r.err
+		}
+		r.windowStart, r.windowBytes = now, 0
+	}
+	return n, nil
+}
+
+// rate is the average throughput of the body so far, in bytes/s:
+func (r *uploadRateReader) rate() float64 {
+	elapsed := time.Since(r.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(r.total) / elapsed
+}
+
+// record puts the body's size and throughput on the request's span:
+func (r *uploadRateReader) record(req *http.Request) {
+	span := trace.SpanFromContext(req.Context())
+	span.SetAttributes(
+		attribute.Int64("upload.bytes", r.total),
+		attribute.Float64("upload.bytes_per_second", r.rate()),
+		attribute.Bool("upload.too_slow", r.err != nil),
+	)
+}
+
+// respondIfUploadTooSlow responds with 408 if err is a body cut off by the
+// throughput floor, and reports whether it did. The connection is closed rather
+// than drained, since draining is exactly what the client is too slow for.
+func respondIfUploadTooSlow(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, errUploadTooSlow) {
+		return false
+	}
+	w.Header().Set("Connection", "close")
+	respondWithCode(w, http.StatusRequestTimeout, codeUploadTooSlow, "Upload is too slow", err)
+	return true
+}
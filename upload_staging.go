@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/keys"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/taskqueue"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/upload"
+	"github.com/google/uuid"
+)
+
+// Where video uploads wait for processing (UPLOAD_STAGING): on local disk, in
+// UPLOAD_TMP_DIR, or in the bucket under the transcode staging prefix. With "s3"
+// the API server needs no scratch disk for uploads: the multipart body is
+// streamed into the bucket, the remote workers transcode it from there, and the
+// result is copied into place without passing through the server. It needs
+// JOB_BACKEND workers and the prefix key layout, since content addressing
+// hashes the processed file.
+const (
+	uploadStagingDisk = "disk"
+	uploadStagingS3   = "s3"
+)
+
+// handleStagedUpload is handlerUploadVideo with UPLOAD_STAGING=s3, from the
+// point the caller is known to own the video.
+func (cfg *apiConfig) handleStagedUpload(w http.ResponseWriter, r *http.Request, video database.Video) {
+	// Read the form part by part instead of ParseMultipartForm, which would
+	// spill the video to disk:
+	part, meta, ok := readStreamedVideoForm(w, r)
+	if !ok {
+		return
+	}
+	filename, contentType := part.FileName(), part.Header.Get("Content-Type")
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Invalid Content-Type", err)
+		return
+	}
+	if !isAllowedUploadType(mediaType) {
+		respondWithCode(w, http.StatusBadRequest, codeInvalidMIME, "Invalid file type, only MP4 video or MP3, M4A and Ogg audio are allowed", nil)
+		return
+	}
+
+	msg := taskqueue.StagingKeys(cfg.remoteTranscode.StagingPrefix, uuid.New())
+	msg.VideoID = video.ID
+	defer func() {
+		if err := cfg.store.Delete(context.WithoutCancel(r.Context()), msg.InputKey, msg.LogoKey, msg.OutputKey, msg.ResultKey); err != nil {
+			log.Printf("Couldn't delete staged files of task %s: %v", msg.ID, err)
+		}
+	}()
+	inspection, err := cfg.stageUpload(r.Context(), msg.InputKey, part, mediaType)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Video is too large", err)
+			return
+		}
+		if respondIfUploadTooSlow(w, err) {
+			return
+		}
+		respondWithPipelineError(w, storageError(http.StatusInternalServerError, "Couldn't stage upload", err))
+		return
+	}
+	cfg.recordBandwidth(r.Context(), video.UserID, inspection.Size, 0)
+
+	// The body is in; processing gets its own, longer deadline:
+	cfg.extendForProcessing(w)
+
+	video, err = cfg.processStagedUpload(r.Context(), video, msg, mediaType, filename, meta, inspection)
+	if err != nil {
+		respondWithPipelineError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// stageUpload streams the upload into the bucket under key, hashing and probing
+// it on the way like copyAndInspect does for a temp file.
+func (cfg *apiConfig) stageUpload(ctx context.Context, key string, body io.Reader, mediaType string) (*uploadInspection, error) {
+	streamer, ok := cfg.store.(storage.StreamPutter)
+	if !ok {
+		return nil, errors.New("storage backend can't stage uploads")
+	}
+
+	pr, pw := io.Pipe()
+	putErr := make(chan error, 1)
+	go func() {
+		_, err := streamer.PutStream(ctx, key, pr, storage.PutOptions{ContentType: "application/octet-stream"})
+		// A failed upload stops the copy instead of leaving it blocked on the pipe:
+		pr.CloseWithError(err)
+		putErr <- err
+	}()
+	inspection, err := copyAndInspect(ctx, pw, body, mediaType)
+	// A nil error ends the stream; any other aborts the multipart upload:
+	pw.CloseWithError(err)
+	if err := <-putErr; err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inspection, nil
+}
+
+// processStagedUpload is the upload pipeline (upload_pipeline.go) for an upload
+// staged in the bucket at msg.InputKey, which has no file on disk to hand it.
+// ffprobe reads it through a presigned URL; the transcode runs
+// on a remote worker, and its output is copied to the video's key. The caller
+// removes the staged files.
+func (cfg *apiConfig) processStagedUpload(ctx context.Context, video database.Video, msg taskqueue.Message, mediaType, filename string, meta upload.Metadata, inspection *uploadInspection) (_ database.Video, err error) {
+	settle, err := cfg.beginVideoStatus(ctx, video.ID, database.StatusProcessing, database.StatusFailed)
+	if err != nil {
+		return database.Video{}, videoStatusError(err)
+	}
+	defer settle()
+	defer func() { cfg.notifyProcessed(ctx, video, err) }()
+
+	video.MediaKind = mediaKindFor(mediaType)
+	originalFilename := sanitizeFilename(filename)
+	presigner, ok := cfg.store.(storage.GetPresigner)
+	if !ok {
+		return database.Video{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Storage backend can't process staged uploads", nil}
+	}
+	copier, ok := cfg.store.(storage.Copier)
+	if !ok {
+		return database.Video{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Storage backend can't process staged uploads", nil}
+	}
+	source, err := presigner.PresignGet(ctx, msg.InputKey, sourceURLTTL)
+	if err != nil {
+		return database.Video{}, storageError(http.StatusInternalServerError, "Couldn't read staged upload", err)
+	}
+
+	var aspectRatio string
+	if video.MediaKind != mediaKindAudio {
+		aspectRatio = inspection.AspectRatio
+		if aspectRatio == "" {
+			probe, err := cfg.probeFile(ctx, source, inspection.SHA256)
+			if err == nil {
+				aspectRatio, err = aspectRatioFromProbe(probe)
+			}
+			if err != nil {
+				return database.Video{}, &pipelineError{http.StatusInternalServerError, codeProbeFailed, "Error determining aspect ratio", err}
+			}
+		}
+	}
+
+	mediaDuration, err := cfg.checkUploadLimits(ctx, video.UserID, source, inspection.SHA256)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if err := cfg.checkDecodable(ctx, source, mediaDuration); err != nil {
+		return database.Video{}, err
+	}
+	task, err := cfg.transcodeTaskFor(ctx, video, mediaType, source, inspection.SHA256, true)
+	if err != nil {
+		return database.Video{}, err
+	}
+	msg.Task = task
+	if task.Kind == transcode.KindWatermark {
+		if err := cfg.putStagingFile(ctx, msg.LogoKey, task.LogoPath); err != nil {
+			return database.Video{}, storageError(http.StatusInternalServerError, "Couldn't stage watermark", err)
+		}
+	} else {
+		msg.LogoKey = ""
+	}
+
+	processingStart := time.Now()
+	result, err := cfg.runStagedProcessingJob(ctx, video, msg, inspection.Size)
+	cfg.recordProcessingRun(ctx, video, processingStart, err)
+	if err != nil {
+		return database.Video{}, &pipelineError{http.StatusInternalServerError, codeProcessingFailed, "Error processing video", err}
+	}
+	cfg.recordUploadUsage(ctx, video, mediaDuration)
+
+	// Staging rules out the cas layout (see config), so no hash is needed here:
+	key, err := cfg.keys.Key(keys.Object{
+		UserID:      video.UserID,
+		Audio:       video.MediaKind == mediaKindAudio,
+		AspectRatio: aspectRatio,
+		Ext:         mediaTypeToExt(mediaType),
+		Time:        time.Now(),
+	})
+	if err != nil {
+		return database.Video{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't name stored file", err}
+	}
+	err = copier.Copy(ctx, msg.OutputKey, key, storage.PutOptions{
+		ContentType:        mediaType,
+		ContentDisposition: contentDisposition(originalFilename),
+		CacheControl:       cfg.videoCacheControl(false),
+		Tags:               videoObjectTags(video, video.MediaKind),
+		Retain:             true,
+	})
+	if err != nil {
+		return database.Video{}, storageError(http.StatusInternalServerError, "Error uploading file to S3", err)
+	}
+
+	// Keep what the video played until now, see video_versions.go:
+	if err := cfg.keepPriorVersion(ctx, video, database.VersionReplaced); err != nil {
+		return database.Video{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't keep the previous version", err}
+	}
+	video, err = cfg.publishVideo(ctx, video, key, originalFilename, meta, nil)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if err := cfg.db.SetVideoSource(ctx, video.ID, inspection.SHA256, inspection.Size); err != nil {
+		log.Printf("Couldn't record source checksum for video %s: %v", video.ID, err)
+	}
+	if err := cfg.db.SetVideoSize(ctx, video.ID, result.OutputSize); err != nil {
+		log.Printf("Couldn't record size for video %s: %v", video.ID, err)
+	}
+
+	// The follow-up steps read the stored file back through presigned URLs:
+	if video.MediaKind == mediaKindVideo {
+		cfg.schedulePackagingFromStore(ctx, video, key, cfg.processingPriority(result.OutputSize))
+	}
+	cfg.scheduleAutoThumbnailFromStore(ctx, video, key)
+	if processed, err := presigner.PresignGet(ctx, key, sourceURLTTL); err != nil {
+		log.Printf("Couldn't extract chapters for video %s: %v", video.ID, err)
+	} else {
+		cfg.saveEmbeddedChapters(ctx, &video, processed, "")
+	}
+
+	return video, nil
+}
+
+// runStagedProcessingJob is runProcessingJob for a staged upload: the job hands
+// msg to the remote workers and waits for their result.
+func (cfg *apiConfig) runStagedProcessingJob(ctx context.Context, video database.Video, msg taskqueue.Message, size int64) (taskqueue.Result, error) {
+	var result taskqueue.Result
+	job, err := cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindProcessVideo,
+		OwnerID:  video.UserID,
+		VideoID:  video.ID,
+		Priority: cfg.processingPriority(size),
+		Run: tracedJob(ctx, jobKindProcessVideo, func(ctx context.Context, job *jobs.Job) error {
+			var err error
+			result, err = cfg.transcodeStaged(ctx, msg, job.SetProgress)
+			return err
+		}),
+	})
+	if err != nil {
+		return taskqueue.Result{}, &pipelineError{http.StatusServiceUnavailable, codeUnavailable, "Processing queue is unavailable", err}
+	}
+	if err := job.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			job.Cancel()
+		}
+		return taskqueue.Result{}, err
+	}
+	if result.OutputSize <= 0 {
+		return taskqueue.Result{}, fmt.Errorf("worker %s reported no output", result.Worker)
+	}
+	return result, nil
+}
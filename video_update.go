@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// videoUpdateAttempts bounds how often updateVideo re-reads and retries after a
+// version conflict:
+const videoUpdateAttempts = 5
+
+// errVideoGone means the video was deleted while we were updating it:
+var errVideoGone = errors.New("video was deleted")
+
+// updateVideo applies change to video and saves it. If another request updated
+// the row in the meantime (say a thumbnail upload finishing during a long video
+// upload), it re-reads the row and applies change again on top of it, so both
+// writes survive. change must only set the fields this caller owns.
+// It returns the video as saved.
+func (cfg *apiConfig) updateVideo(ctx context.Context, video database.Video, change func(*database.Video)) (database.Video, error) {
+	return updateVideoIn(ctx, cfg.videos, video, change)
+}
+
+// updateVideoIn is updateVideo through videos, e.g. a transaction from WithTx.
+func updateVideoIn(ctx context.Context, videos VideoStore, video database.Video, change func(*database.Video)) (database.Video, error) {
+	for range videoUpdateAttempts {
+		change(&video)
+		err := videos.UpdateVideo(ctx, video)
+		if err == nil {
+			video.Version++
+			return video, nil
+		}
+		var conflict *database.ConflictError
+		if !errors.As(err, &conflict) {
+			return database.Video{}, err
+		}
+
+		video, err = videos.GetVideo(ctx, video.ID)
+		if err != nil {
+			return database.Video{}, err
+		}
+		if video.ID == uuid.Nil {
+			return database.Video{}, errVideoGone
+		}
+	}
+	return database.Video{}, &database.ConflictError{VideoID: video.ID, Version: video.Version}
+}
+
+// videoUpdateError maps an updateVideo failure to what the client should see:
+func videoUpdateError(err error) *pipelineError {
+	var conflict *database.ConflictError
+	switch {
+	case errors.Is(err, errVideoGone):
+		return &pipelineError{http.StatusNotFound, codeNotFound, "Video was deleted", err}
+	case errors.As(err, &conflict):
+		return &pipelineError{http.StatusConflict, codeConflict, "Video is being updated by another request, try again", err}
+	}
+	return &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't update video", err}
+}
+
+// beginVideoStatus moves the video to status (uploading or processing) for the
+// length of a flow. The returned settle function ends it: if the flow didn't
+// move the video on by then, it goes back to ready when it still has an older
+// file, or to fallback. Call it deferred.
+func (cfg *apiConfig) beginVideoStatus(ctx context.Context, videoID uuid.UUID, status, fallback string) (settle func(), err error) {
+	if err := cfg.videos.SetVideoStatus(ctx, videoID, status); err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := cfg.videos.SettleVideoStatus(context.WithoutCancel(ctx), videoID, status, fallback); err != nil {
+			log.Printf("Couldn't settle status of video %s: %v", videoID, err)
+		}
+	}, nil
+}
+
+// videoStatusError maps a SetVideoStatus failure to what the client should see:
+func videoStatusError(err error) *pipelineError {
+	var transition *database.StatusTransitionError
+	if errors.As(err, &transition) {
+		return &pipelineError{http.StatusConflict, codeConflict, fmt.Sprintf("Video is %s", transition.From), err}
+	}
+	return &pipelineError{http.StatusInternalServerError, codeInternal, "Couldn't update video status", err}
+}
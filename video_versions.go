@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/bufpool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/upload"
+	"github.com/google/uuid"
+)
+
+const jobKindVersionPrune = "version_prune"
+
+// versionsConfig is the retention policy for the files videos played before
+// they were replaced or edited.
+type versionsConfig struct {
+	// Keep is how many earlier versions each video keeps (VERSION_KEEP).
+	Keep int
+	// MaxAge is the age from which a version goes however few the video has
+	// (VERSION_MAX_AGE); 0 leaves it to Keep.
+	MaxAge time.Duration
+	// Interval between background prunes (VERSION_PRUNE_INTERVAL); 0 disables them.
+	Interval time.Duration
+}
+
+// keepPriorVersion records the file the video plays now as one of its versions,
+// before a re-upload or an edit points the video at a new one. In the
+// content-addressable layout the version takes a reference of its own to the
+// object, so the video letting go of it doesn't delete it. A video with no
+// stored file has nothing to keep.
+func (cfg *apiConfig) keepPriorVersion(ctx context.Context, video database.Video, reason string) error {
+	obj, err := cfg.db.GetVideoObject(ctx, video.ID)
+	if err != nil {
+		return err
+	}
+	if obj.ObjectKey == "" {
+		return nil
+	}
+	hash, err := cfg.db.GetVideoContentHash(ctx, video.ID)
+	if err != nil {
+		return err
+	}
+	if hash != nil {
+		if _, err := cfg.db.AcquireContentObject(ctx, *hash, obj.ObjectKey, 0); err != nil {
+			return err
+		}
+	}
+
+	_, err = cfg.db.AddVideoVersion(ctx, database.VideoVersion{
+		VideoID:     video.ID,
+		Reason:      reason,
+		ObjectKey:   obj.ObjectKey,
+		VideoURL:    video.VideoURL,
+		ContentHash: hash,
+	})
+	if err != nil && hash != nil {
+		if relErr := cfg.releaseContentHash(ctx, *hash); relErr != nil {
+			log.Printf("Couldn't release content object %s: %v", *hash, relErr)
+		}
+	}
+	return err
+}
+
+// dropVersion removes the record of a version and lets go of its object: a
+// content-addressed one is released like a video's, and the key of one in the
+// prefix layout, which belonged to this version alone, is returned for the
+// caller to delete. The row goes first, so a retry after a failure doesn't
+// release anything twice.
+func (cfg *apiConfig) dropVersion(ctx context.Context, version database.VideoVersion) (string, error) {
+	if err := cfg.db.DeleteVideoVersion(ctx, version.VideoID, version.Number); err != nil {
+		return "", err
+	}
+	if version.ContentHash == nil {
+		return version.ObjectKey, nil
+	}
+	return "", cfg.releaseContentHash(ctx, *version.ContentHash)
+}
+
+// releaseVersions drops all of a video's versions, for when the video is
+// deleted, and returns the keys the caller has to delete; see dropVersion.
+func (cfg *apiConfig) releaseVersions(ctx context.Context, videoID uuid.UUID) ([]string, error) {
+	versions, err := cfg.db.GetVideoVersions(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, version := range versions {
+		key, err := cfg.dropVersion(ctx, version)
+		if err != nil {
+			return nil, err
+		}
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// deleteVersionObjects deletes the objects dropVersion handed back:
+func (cfg *apiConfig) deleteVersionObjects(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := cfg.store.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	cfg.invalidateCDN(ctx, keys...)
+	cfg.deleteReplicas(ctx, keys...)
+	return nil
+}
+
+// handlerVideoVersionsList lists the earlier versions of one of the caller's
+// videos, newest first: GET /api/videos/{videoID}/versions.
+func (cfg *apiConfig) handlerVideoVersionsList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	versions, err := cfg.db.GetVideoVersions(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get versions", err)
+		return
+	}
+	// Signed like the video's own URL would be, see signPlaybackURLs:
+	if cfg.signedURLs.signPlayback {
+		for i, version := range versions {
+			if version.VideoURL == nil || *version.VideoURL != cfg.cdn.PublicURL(version.ObjectKey) {
+				continue
+			}
+			url, expiresAt, err := cfg.signedURL(r.Context(), version.ObjectKey, cfg.signedURLs.playbackTTL)
+			if err != nil {
+				log.Printf("Couldn't sign URL of version %d of video %s: %v", version.Number, video.ID, err)
+				continue
+			}
+			versions[i].VideoURL = &url
+			versions[i].VideoURLExpiresAt = &expiresAt
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, versions)
+}
+
+// handlerVideoVersionRestore makes an earlier version of one of the caller's
+// videos its file again: POST /api/videos/{videoID}/versions/{n}/restore. The
+// file it replaces becomes a version in turn, so a restore can be undone the
+// same way. The restored file goes through the same steps as a new upload's
+// (packaging, checksums, moderation), without being transcoded again.
+func (cfg *apiConfig) handlerVideoVersionRestore(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || number < 1 {
+		respondWithError(w, http.StatusBadRequest, "Invalid version number", err)
+		return
+	}
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+	version, err := cfg.db.GetVideoVersion(r.Context(), video.ID, number)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get version", err)
+		return
+	}
+	if version.Number == 0 {
+		respondWithCode(w, http.StatusNotFound, codeNotFound, "Version not found", nil)
+		return
+	}
+
+	video, err = cfg.restoreVersion(r.Context(), video, version)
+	if err != nil {
+		respondWithPipelineError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// restoreVersion reads the version's object back and stores it as the video's
+// file, keeping the current one as a version, then drops the restored version.
+// In the content-addressable layout the bytes are already stored, so only the
+// references change hands; in the prefix layout the file is stored again under
+// a new key and the version's object is deleted.
+func (cfg *apiConfig) restoreVersion(ctx context.Context, video database.Video, version database.VideoVersion) (database.Video, error) {
+	settle, err := cfg.beginVideoStatus(ctx, video.ID, database.StatusProcessing, database.StatusReady)
+	if err != nil {
+		return database.Video{}, videoStatusError(err)
+	}
+	defer settle()
+
+	body, err := cfg.store.Get(ctx, version.ObjectKey)
+	if err != nil {
+		return database.Video{}, storageError(http.StatusBadGateway, "Couldn't read version from storage", err)
+	}
+	defer body.Close()
+	// Stored files are processed ones, so this is the plain file, not an upload:
+	tempFile, err := os.CreateTemp(cfg.uploadTmpDir, "tubely-version-*"+path.Ext(version.ObjectKey))
+	if err != nil {
+		return database.Video{}, &pipelineError{http.StatusInternalServerError, codeInternal, "Could not create temp file", err}
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = bufpool.Copy(tempFile, body)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return database.Video{}, storageError(http.StatusBadGateway, "Couldn't read version from storage", err)
+	}
+
+	mediaType := mediaTypeFromExt(path.Ext(version.ObjectKey))
+	video.MediaKind = mediaKindFor(mediaType)
+	var aspectRatio string
+	if video.MediaKind == mediaKindVideo {
+		var hash string
+		if version.ContentHash != nil {
+			hash = *version.ContentHash
+		}
+		probe, err := cfg.probeFile(ctx, tempFile.Name(), hash)
+		if err == nil {
+			aspectRatio, err = aspectRatioFromProbe(probe)
+		}
+		if err != nil {
+			return database.Video{}, &pipelineError{http.StatusInternalServerError, codeProbeFailed, "Error determining aspect ratio", err}
+		}
+	}
+
+	originalFilename := ""
+	if video.OriginalFilename != nil {
+		originalFilename = *video.OriginalFilename
+	}
+	video, _, err = cfg.storeProcessedVideo(ctx, video, tempFile.Name(), mediaType, aspectRatio, originalFilename, upload.Metadata{}, database.VersionRestored)
+	if err != nil {
+		return database.Video{}, err
+	}
+	// The video plays the restored file now; the version's hold on the object is
+	// no longer needed:
+	key, err := cfg.dropVersion(ctx, version)
+	if err != nil {
+		log.Printf("Couldn't drop restored version %d of video %s: %v", version.Number, video.ID, err)
+	} else if key != "" {
+		if err := cfg.deleteVersionObjects(ctx, []string{key}); err != nil {
+			log.Printf("Couldn't delete restored version %d of video %s: %v", version.Number, video.ID, err)
+		}
+	}
+	cfg.hooks.Processed(ctx, video)
+	return video, nil
+}
+
+// mediaTypeFromExt is mediaTypeToExt backwards, for the types uploads are
+// stored as:
+func mediaTypeFromExt(ext string) string {
+	for mediaType := range audioFormats {
+		if mediaTypeToExt(mediaType) == ext {
+			return mediaType
+		}
+	}
+	return "video/mp4"
+}
+
+// pruneVersions drops the versions past the retention policy and deletes
+// their objects, returning how many went.
+func (cfg *apiConfig) pruneVersions(ctx context.Context) (int, error) {
+	var createdBefore time.Time
+	if cfg.versions.MaxAge > 0 {
+		createdBefore = time.Now().Add(-cfg.versions.MaxAge)
+	}
+	expired, err := cfg.db.GetExpiredVersions(ctx, cfg.versions.Keep, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	pruned := 0
+	for _, version := range expired {
+		// One still under Object Lock retention waits for a run after it runs
+		// out, see object_lock.go:
+		until, err := cfg.objectRetainedUntil(ctx, version.ObjectKey)
+		if err != nil {
+			return 0, err
+		}
+		if !until.IsZero() {
+			continue
+		}
+		key, err := cfg.dropVersion(ctx, version)
+		if err != nil {
+			return 0, err
+		}
+		if key != "" {
+			keys = append(keys, key)
+		}
+		pruned++
+	}
+	if err := cfg.deleteVersionObjects(ctx, keys); err != nil {
+		return 0, err
+	}
+	if pruned > 0 {
+		log.Printf("Pruned %d earlier video versions", pruned)
+	}
+	return pruned, nil
+}
+
+// submitVersionPrune queues a prune on the low-priority tier. ownerID is the
+// admin who asked for it, or uuid.Nil for the background runs.
+func (cfg *apiConfig) submitVersionPrune(ctx context.Context, ownerID uuid.UUID) (*jobs.Job, error) {
+	return cfg.jobs.Submit(jobs.Spec{
+		Kind:     jobKindVersionPrune,
+		OwnerID:  ownerID,
+		Priority: jobs.PriorityLow,
+		Run: tracedJob(ctx, jobKindVersionPrune, func(ctx context.Context, job *jobs.Job) error {
+			_, err := cfg.pruneVersions(ctx)
+			return err
+		}),
+	})
+}
+
+// startVersionPrune queues a prune every Interval until ctx is done:
+func (cfg *apiConfig) startVersionPrune(ctx context.Context) {
+	if cfg.versions.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.versions.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := cfg.submitVersionPrune(ctx, uuid.Nil); err != nil {
+					log.Printf("Couldn't queue version prune: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// handlerAdminVersionPrune queues a prune now and responds with its job ID:
+func (cfg *apiConfig) handlerAdminVersionPrune(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	job, err := cfg.submitVersionPrune(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Couldn't queue version prune", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, map[string]any{"job_id": job.ID})
+}
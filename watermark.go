@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+)
+
+// Default overlay settings used when the user doesn't pick their own:
+const (
+	defaultWatermarkPosition = "bottom-right"
+	defaultWatermarkOpacity  = 0.5
+	// watermarkMargin is the gap in pixels between the logo and the frame edge:
+	watermarkMargin = 16
+)
+
+// watermarkPositions maps a position name to ffmpeg overlay x:y expressions, where
+// W/H are the video's dimensions and w/h the logo's:
+var watermarkPositions = map[string]string{
+	"top-left":     fmt.Sprintf("%d:%d", watermarkMargin, watermarkMargin),
+	"top-right":    fmt.Sprintf("W-w-%d:%d", watermarkMargin, watermarkMargin),
+	"bottom-left":  fmt.Sprintf("%d:H-h-%d", watermarkMargin, watermarkMargin),
+	"bottom-right": fmt.Sprintf("W-w-%d:H-h-%d", watermarkMargin, watermarkMargin),
+	"center":       "(W-w)/2:(H-h)/2",
+}
+
+// watermarkTask is the transcode step that burns the user's logo (at logoPath)
+// into the video:
+func watermarkTask(w *database.Watermark, logoPath string) (transcode.Task, error) {
+	overlay, ok := watermarkPositions[w.Position]
+	if !ok {
+		return transcode.Task{}, fmt.Errorf("unknown watermark position %q", w.Position)
+	}
+	return transcode.Task{
+		Kind:     transcode.KindWatermark,
+		Overlay:  overlay,
+		Opacity:  w.Opacity,
+		LogoPath: logoPath,
+	}, nil
+}